@@ -0,0 +1,250 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const (
+	AGG_COUNT = 1 // of the rows for an empty Col, else of the values not NULL
+	AGG_SUM   = 2
+	AGG_MIN   = 3
+	AGG_MAX   = 4
+	AGG_AVG   = 5
+)
+
+// an aggregate of a column, see Aggregate
+type AggSpec struct {
+	Func int
+	Col  string // empty for COUNT(*)
+	Name string // of the result column, empty for the default like SUM(col)
+}
+
+func (agg AggSpec) String() string {
+	if agg.Name != "" {
+		return agg.Name
+	}
+	col := agg.Col
+	if col == "" {
+		col = "*"
+	}
+	return fmt.Sprintf("%s(%s)", aggName(agg.Func), col)
+}
+
+func aggName(fn int) string {
+	switch fn {
+	case AGG_COUNT:
+		return "COUNT"
+	case AGG_SUM:
+		return "SUM"
+	case AGG_MIN:
+		return "MIN"
+	case AGG_MAX:
+		return "MAX"
+	case AGG_AVG:
+		return "AVG"
+	default:
+		return fmt.Sprintf("agg %d", fn)
+	}
+}
+
+// the running value of an aggregate
+type aggState struct {
+	typ   uint32 // of the column, 0 for COUNT(*)
+	col   int    // in the decoded row
+	count int64  // of the values not NULL
+	sumI  int64
+	sumF  float64
+	val   Value // MIN & MAX
+}
+
+// compute the aggregates over the rows of the request in one pass, the rows
+// are decoded one at a time and only for the columns of the aggregates. the
+// NULLs are skipped, SUM, MIN, MAX & AVG of no values are NULL. a SUM of
+// integers that overflows is ErrOverflow. when every aggregate is a MIN or a
+// MAX of the column after the equal leading columns of the range, they are
+// the first & the last value of the range, read by a seek each. the request
+// is consumed, its Project is replaced.
+//
+// Deprecated: use DBTX.Aggregate or DBReader.Aggregate.
+func (db *DB) Aggregate(table string, req *Scanner, aggs []AggSpec, tree *BTree) (Record, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return Record{}, tableNotFound(table)
+	}
+	if len(aggs) == 0 {
+		return Record{}, fmt.Errorf("no aggregates")
+	}
+	states, cols, err := aggSetup(tdef, aggs, nil)
+	if err != nil {
+		return Record{}, err
+	}
+	lowerFirst(req)
+	if ok, err := aggSeek(db, tdef, req, aggs, states, tree); err != nil || ok {
+		return aggResult(aggs, states), err
+	}
+
+	req.Project = cols
+	if err := dbScan(db, tdef, req, tree); err != nil {
+		return Record{}, err
+	}
+	rec := Record{}
+	for ; req.valid(); req.Next() {
+		if len(cols) > 0 {
+			if err := req.Deref(&rec, tree); err != nil {
+				return Record{}, err
+			}
+		}
+		for i := range states {
+			if err := states[i].add(aggs[i], rec.Vals); err != nil {
+				return Record{}, err
+			}
+		}
+	}
+	if err := req.iterErr(); err != nil {
+		return Record{}, err
+	}
+	return aggResult(aggs, states), nil
+}
+
+// check the aggregates, the columns are those the rows are decoded for, after
+// the columns given
+func aggSetup(tdef *TableDef, aggs []AggSpec, cols []string) ([]aggState, []string, error) {
+	states := make([]aggState, len(aggs))
+	for i, agg := range aggs {
+		if agg.Func < AGG_COUNT || agg.Func > AGG_AVG {
+			return nil, nil, fmt.Errorf("bad aggregate: %d", agg.Func)
+		}
+		if agg.Col == "" {
+			if agg.Func != AGG_COUNT {
+				return nil, nil, fmt.Errorf("%s needs a column", aggName(agg.Func))
+			}
+			continue
+		}
+		idx := ColIndex(tdef, agg.Col)
+		if idx < 0 {
+			return nil, nil, columnNotFound(tdef.Name, agg.Col)
+		}
+		typ := tdef.Types[idx]
+		if agg.Func == AGG_SUM || agg.Func == AGG_AVG {
+			if typ != TYPE_INT64 && typ != TYPE_INT32 && typ != TYPE_FLOAT64 {
+				return nil, nil, fmt.Errorf("%w: %v of %s", ErrTypeMismatch, agg, typeName(typ))
+			}
+		}
+		if !slices.Contains(cols, agg.Col) {
+			cols = append(cols, agg.Col)
+		}
+		states[i].typ, states[i].col = typ, slices.Index(cols, agg.Col)
+	}
+	return states, cols, nil
+}
+
+// the MIN & MAX of the column after the equal leading columns of the range
+// by a seek from each end, false if the aggregates or the range need a scan
+func aggSeek(db *DB, tdef *TableDef, req *Scanner, aggs []AggSpec, states []aggState, tree *BTree) (bool, error) {
+	sc := *req
+	if err := scanSetup(db, tdef, &sc, tree); err != nil {
+		return false, err
+	}
+	if sc.filter != nil || sc.distinct != nil || sc.Limit > 0 || sc.StartAfter != nil {
+		return false, nil
+	}
+	index := tdef.Cols[:tdef.PKeys]
+	if sc.indexNo >= 0 {
+		index = tdef.Indexes[sc.indexNo]
+	}
+	eq := eqPrefix(&sc)
+	for _, agg := range aggs {
+		if agg.Func != AGG_MIN && agg.Func != AGG_MAX || eq >= len(index) || agg.Col != index[eq] {
+			return false, nil
+		}
+	}
+	for i, agg := range aggs {
+		end := *req
+		end.Desc = agg.Func == AGG_MAX
+		end.Project = []string{agg.Col}
+		if err := dbScan(db, tdef, &end, tree); err != nil {
+			return false, err
+		}
+		// the NULLs are first
+		rec := Record{}
+		for ; end.valid(); end.Next() {
+			if err := end.Deref(&rec, tree); err != nil {
+				return false, err
+			}
+			if !rec.Vals[0].Null {
+				states[i].add(agg, rec.Vals)
+				break
+			}
+		}
+		if err := end.iterErr(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (st *aggState) add(agg AggSpec, row []Value) error {
+	if agg.Col == "" {
+		st.count++
+		return nil
+	}
+	v := row[st.col]
+	if v.Null {
+		return nil
+	}
+	st.count++
+	switch agg.Func {
+	case AGG_SUM, AGG_AVG:
+		switch {
+		case st.typ == TYPE_FLOAT64:
+			st.sumF += v.F64
+			return nil
+		case agg.Func == AGG_AVG:
+			st.sumF += float64(v.I64)
+			return nil
+		}
+		if v.I64 > 0 && st.sumI > math.MaxInt64-v.I64 || v.I64 < 0 && st.sumI < math.MinInt64-v.I64 {
+			return fmt.Errorf("%w: %v", ErrOverflow, agg)
+		}
+		st.sumI += v.I64
+	case AGG_MIN, AGG_MAX:
+		if st.count == 1 {
+			st.val = v
+		} else if order := orderValues(v, st.val); agg.Func == AGG_MIN && order < 0 || agg.Func == AGG_MAX && order > 0 {
+			st.val = v
+		}
+	}
+	return nil
+}
+
+// the aggregates as the columns of a record
+func aggResult(aggs []AggSpec, states []aggState) Record {
+	rec := Record{}
+	for i, agg := range aggs {
+		st := &states[i]
+		v := Value{Type: st.typ, Null: st.count == 0}
+		switch agg.Func {
+		case AGG_COUNT:
+			v = Value{Type: TYPE_INT64, I64: st.count}
+		case AGG_SUM:
+			if st.typ == TYPE_FLOAT64 {
+				v.F64 = st.sumF
+			} else {
+				v.Type, v.I64 = TYPE_INT64, st.sumI
+			}
+		case AGG_AVG:
+			v.Type = TYPE_FLOAT64
+			if st.count > 0 {
+				v.F64 = st.sumF / float64(st.count)
+			}
+		case AGG_MIN, AGG_MAX:
+			if !v.Null {
+				v = st.val
+			}
+		}
+		rec.Cols, rec.Vals = append(rec.Cols, agg.String()), append(rec.Vals, v)
+	}
+	return rec
+}
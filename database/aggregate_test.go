@@ -0,0 +1,130 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "sales",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_INT64, TYPE_FLOAT64, TYPE_BYTES},
+		Cols:    []string{"id", "shop", "qty", "price", "note"},
+		PKeys:   1,
+		Indexes: [][]string{{"shop", "qty"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	// shop = id % 3, qty = id, price = id / 2, a NULL note & qty for id 10
+	for id := int64(1); id <= 12; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddInt64("shop", id%3)
+		if id == 10 {
+			rec.AddNull("qty", TYPE_INT64).AddFloat64("price", float64(id)/2).AddNull("note", TYPE_BYTES)
+		} else {
+			rec.AddInt64("qty", id).AddFloat64("price", float64(id)/2).AddStr("note", []byte(fmt.Sprintf("n%d", id)))
+		}
+		if _, err := tx.Set("sales", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	num := func(n int64) *Expr { return LiteralExpr(Value{Type: TYPE_INT64, I64: n}) }
+	text := func(rec Record) string {
+		s := ""
+		for i, v := range rec.Vals {
+			s += rec.Cols[i] + "="
+			switch {
+			case v.Null:
+				s += "NULL"
+			case v.Type == TYPE_FLOAT64:
+				s += fmt.Sprint(v.F64)
+			case v.Type == TYPE_BYTES:
+				s += string(v.Str)
+			default:
+				s += fmt.Sprint(v.I64)
+			}
+			s += " "
+		}
+		return s
+	}
+
+	all := []AggSpec{
+		{Func: AGG_COUNT}, {Func: AGG_COUNT, Col: "qty"}, {Func: AGG_SUM, Col: "qty"},
+		{Func: AGG_SUM, Col: "price", Name: "total"}, {Func: AGG_MIN, Col: "note"},
+		{Func: AGG_MAX, Col: "qty"}, {Func: AGG_AVG, Col: "qty"},
+	}
+	for _, tt := range []struct {
+		name string
+		sc   Scanner
+		aggs []AggSpec
+		seek bool
+		want string
+	}{
+		{"every row", Scanner{}, all, false,
+			"COUNT(*)=12 COUNT(qty)=11 SUM(qty)=68 total=39 MIN(note)=n1 MAX(qty)=12 AVG(qty)=6.181818181818182 "},
+		{"filter", Scanner{Filter: CompareExpr(ColumnExpr("qty"), CMP_GT, num(8))}, all, false,
+			"COUNT(*)=3 COUNT(qty)=3 SUM(qty)=32 total=16 MIN(note)=n11 MAX(qty)=12 AVG(qty)=10.666666666666666 "},
+		{"no rows", Scanner{Filter: CompareExpr(ColumnExpr("id"), CMP_GT, num(20))}, all, false,
+			"COUNT(*)=0 COUNT(qty)=0 SUM(qty)=NULL total=NULL MIN(note)=NULL MAX(qty)=NULL AVG(qty)=NULL "},
+		{"primary key", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: *(&Record{}).AddInt64("id", 3), Key2: *(&Record{}).AddInt64("id", 9)},
+			[]AggSpec{{Func: AGG_MIN, Col: "id"}, {Func: AGG_MAX, Col: "id"}}, true, "MIN(id)=3 MAX(id)=8 "},
+		// the NULL qty of the shop 1 is first in the index
+		{"index after the equal column", Scanner{Filter: CompareExpr(ColumnExpr("shop"), CMP_EQ, num(1))},
+			[]AggSpec{{Func: AGG_MIN, Col: "qty"}, {Func: AGG_MAX, Col: "qty"}}, true, "MIN(qty)=1 MAX(qty)=7 "},
+		{"with a count", Scanner{Filter: CompareExpr(ColumnExpr("shop"), CMP_EQ, num(1))},
+			[]AggSpec{{Func: AGG_MIN, Col: "qty"}, {Func: AGG_COUNT}}, false, "MIN(qty)=1 COUNT(*)=4 "},
+		{"descending range", Scanner{Cmp1: CMP_LE, Cmp2: CMP_GE, Key1: *(&Record{}).AddInt64("id", 12), Key2: *(&Record{}).AddInt64("id", 10)},
+			[]AggSpec{{Func: AGG_MAX, Col: "id"}, {Func: AGG_MIN, Col: "id"}}, true, "MAX(id)=12 MIN(id)=10 "},
+		{"limit", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("id", 1), Key2: *(&Record{}).AddInt64("id", 12), Limit: 2},
+			[]AggSpec{{Func: AGG_MIN, Col: "id"}, {Func: AGG_MAX, Col: "id"}}, false, "MIN(id)=1 MAX(id)=2 "},
+	} {
+		if tt.seek {
+			tdef := GetTableDef(db, "sales", &tx.kv.Tree)
+			sc := tt.sc
+			lowerFirst(&sc)
+			states, _, _ := aggSetup(tdef, tt.aggs, nil)
+			ok, err := aggSeek(db, tdef, &sc, tt.aggs, states, &tx.kv.Tree)
+			if err != nil || !ok {
+				t.Errorf("%s: expected the seeks, got %v %v", tt.name, ok, err)
+			}
+		}
+		sc := tt.sc
+		rec, err := tx.Aggregate("sales", &sc, tt.aggs)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := text(rec); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	for _, aggs := range [][]AggSpec{
+		{{Func: AGG_SUM, Col: "note"}},
+		{{Func: AGG_MIN}},
+		{{Func: AGG_AVG, Col: "color"}},
+		{},
+	} {
+		if _, err := tx.Aggregate("sales", &Scanner{}, aggs); err == nil {
+			t.Errorf("%v: expected an error", aggs)
+		}
+	}
+	rec := (&Record{}).AddInt64("id", 13).AddInt64("shop", 0).AddInt64("qty", math.MaxInt64)
+	if _, err := tx.Set("sales", *rec, MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := tx.Aggregate("sales", &Scanner{}, []AggSpec{{Func: AGG_SUM, Col: "qty"}}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	// the average is of floats
+	if rec, err := tx.Aggregate("sales", &Scanner{}, []AggSpec{{Func: AGG_AVG, Col: "qty"}}); err != nil || rec.Vals[0].F64 < 1e17 {
+		t.Errorf("expected the average of the large value, got %v %v", rec, err)
+	}
+	db.Abort(&tx)
+}
@@ -0,0 +1,347 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// the buckets of the histogram of a column, each with about as many rows
+const ANALYZE_BUCKETS = 32
+
+// the statistics of a table computed by Analyze, kept in a meta row of the
+// catalog until the next Analyze or the drop of the table. the planner
+// estimates the rows of a range with them, see Explain.
+type TableAnalysis struct {
+	Rows     int64
+	Analyzed time.Time
+	Columns  []ColumnAnalysis // the leading column of the primary key, then of each index
+}
+
+// the statistics of the values of a column
+type ColumnAnalysis struct {
+	Col      string
+	Nulls    int64
+	Distinct int64 // of the values that are not null
+	// an equi-depth histogram of the values that are not null: the bucket i
+	// has the Depths[i] values in (Bounds[i], Bounds[i+1]], the first one
+	// also has Bounds[0], the smallest value. no bucket splits a value, the
+	// BoundRows[i] of them are Bounds[i+1], so a frequent value is exact.
+	Bounds    []Value
+	Depths    []int64
+	BoundRows []int64
+}
+
+func (a *TableAnalysis) Column(col string) *ColumnAnalysis {
+	for i := range a.Columns {
+		if a.Columns[i].Col == col {
+			return &a.Columns[i]
+		}
+	}
+	return nil
+}
+
+// compute the statistics of the table & write them, they are exact: the
+// keys of the primary key & of each index are read in order, so the
+// distinct values are counted & the histograms are cut in one pass.
+//
+// Deprecated: use DBTX.Analyze.
+func (db *DB) Analyze(table string, kvtx *KVTX) (*TableAnalysis, error) {
+	if kvtx.kv.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	if tdef.internal() {
+		return nil, fmt.Errorf("cannot analyze the internal table %s", table)
+	}
+	rows, err := rowCountGet(db, tdef, &kvtx.Tree)
+	if err != nil {
+		return nil, err
+	}
+	a := &TableAnalysis{Analyzed: time.Now().UTC()}
+	for i := -1; i < len(tdef.Indexes); i++ {
+		col, prefix := tdef.Cols[0], tdef.Prefix
+		if i >= 0 {
+			col, prefix = tdef.Indexes[i][0], tdef.IndexPrefix[i]
+		}
+		if a.Column(col) != nil {
+			continue
+		}
+		c, n := analyzeColumn(&kvtx.Tree, prefix, col, tdef.Types[ColIndex(tdef, col)], rows)
+		if i < 0 {
+			a.Rows = n
+		}
+		a.Columns = append(a.Columns, c)
+	}
+
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	kvtx.Update(&InsertReq{Key: analysisKey(tdef), Value: encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: buf}})})
+	return a, nil
+}
+
+// the statistics of the leading column of the keys with the prefix, with
+// the number of keys. rows is the expected number, for the depth of the
+// buckets.
+func analyzeColumn(tree *BTree, prefix uint32, col string, typ uint32, rows int64) (ColumnAnalysis, int64) {
+	c := ColumnAnalysis{Col: col}
+	vals := []Value{{Type: typ}}
+	var keys, depth, same, target int64
+	var enc, prev []byte
+	var last Value
+	prefixScan(tree, binary.BigEndian.AppendUint32(nil, prefix), func(key []byte) bool {
+		keys++
+		decodeValues(key[4:], vals)
+		if vals[0].Null {
+			c.Nulls++ // sorted first
+			return true
+		}
+		enc = encodeValues(enc[:0], vals)
+		if c.Distinct == 0 || !bytes.Equal(enc, prev) {
+			if c.Distinct == 0 {
+				target = (rows - c.Nulls + ANALYZE_BUCKETS - 1) / ANALYZE_BUCKETS
+				if target < 1 {
+					target = 1
+				}
+				c.Bounds = append(c.Bounds, analysisValue(vals[0]))
+			} else if depth >= target {
+				c.bucket(last, depth, same)
+				depth = 0
+			}
+			c.Distinct++
+			prev, last, same = append(prev[:0], enc...), analysisValue(vals[0]), 0
+		}
+		depth++
+		same++
+		return true
+	})
+	if depth > 0 {
+		c.bucket(last, depth, same)
+	}
+	return c, keys
+}
+
+func (c *ColumnAnalysis) bucket(bound Value, depth, same int64) {
+	c.Bounds = append(c.Bounds, bound)
+	c.Depths, c.BoundRows = append(c.Depths, depth), append(c.BoundRows, same)
+}
+
+// a value out of the page it was decoded from
+func analysisValue(v Value) Value {
+	v.Str = bytes.Clone(v.Str)
+	return v
+}
+
+// the meta row of the statistics of a table
+func analysisKey(tdef *TableDef) []byte {
+	name := fmt.Sprintf("analyze_%d", tdef.Prefix)
+	return encodeKey(nil, TDEF_META.Prefix, []Value{{Type: TYPE_BYTES, Str: []byte(name)}})
+}
+
+// the statistics of the last Analyze of the table, nil if there is none
+//
+// Deprecated: use DBTX.Analysis or DBReader.Analysis.
+func (db *DB) Analysis(table string, tree *BTree) (*TableAnalysis, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	return analysisGet(tree, tdef)
+}
+
+func analysisGet(tree *BTree, tdef *TableDef) (*TableAnalysis, error) {
+	val, ok, err := tree.Get(analysisKey(tdef))
+	if err != nil || !ok {
+		return nil, err
+	}
+	out := []Value{{Type: TYPE_BYTES}}
+	if decodeValues(val, out) != 1 {
+		return nil, fmt.Errorf("corrupted statistics of %s", tdef.Name)
+	}
+	a := &TableAnalysis{}
+	if err := json.Unmarshal(out[0].Str, a); err != nil {
+		return nil, fmt.Errorf("corrupted statistics of %s: %w", tdef.Name, err)
+	}
+	return a, nil
+}
+
+// the estimated rows matching all the conditions, the columns taken as
+// independent. a condition of a column without statistics matches every row.
+func (a *TableAnalysis) estimate(tdef *TableDef, conds []Cond) float64 {
+	rows := float64(a.Rows)
+	done := map[string]bool{}
+	for _, c := range conds {
+		col := a.Column(c.Col)
+		if col == nil || done[c.Col] || a.Rows == 0 {
+			continue
+		}
+		done[c.Col] = true
+		rows *= col.rows(tdef, conds) / float64(a.Rows)
+	}
+	return rows
+}
+
+// the estimated rows matching the conditions of the column: an equal value,
+// or the bounds of a range
+func (c *ColumnAnalysis) rows(tdef *TableDef, conds []Cond) float64 {
+	var lo, hi *Value
+	loInc, hiInc := true, true
+	for _, cond := range conds {
+		if cond.Col != c.Col || cond.Val.Null || !keyable(tdef, cond) {
+			continue
+		}
+		v := keyValue(tdef, cond)
+		switch cond.Cmp {
+		case CMP_EQ:
+			return c.equal(v)
+		case CMP_NE:
+			return c.total() - c.equal(v)
+		case CMP_GE, CMP_GT:
+			lo, loInc = &v, cond.Cmp == CMP_GE
+		case CMP_LE, CMP_LT:
+			hi, hiInc = &v, cond.Cmp == CMP_LE
+		case CMP_PREFIX:
+			// the strings from the prefix to the prefix followed by the greatest bytes
+			end := v
+			end.Str = append(bytes.Clone(v.Str), bytes.Repeat([]byte{0xff}, 8)...)
+			lo, hi = &v, &end
+		}
+	}
+	if lo == nil && hi == nil {
+		return float64(c.Nulls) + c.total()
+	}
+	n := c.total()
+	if hi != nil {
+		n = c.below(*hi)
+		if hiInc {
+			n += c.equal(*hi)
+		}
+	}
+	if lo != nil {
+		n -= c.below(*lo)
+		if !loInc {
+			n -= c.equal(*lo)
+		}
+	}
+	return clamp(n, 0, c.total())
+}
+
+// the values that are not null
+func (c *ColumnAnalysis) total() float64 {
+	var n int64
+	for _, d := range c.Depths {
+		n += d
+	}
+	return float64(n)
+}
+
+// the estimated rows of the value: exact for the bound of a bucket, else
+// the average of the values
+func (c *ColumnAnalysis) equal(v Value) float64 {
+	if c.Distinct == 0 || valueCompare(v, c.Bounds[0]) < 0 {
+		return 0
+	}
+	for i, d := range c.Depths {
+		switch cmp := valueCompare(v, c.Bounds[i+1]); {
+		case cmp == 0:
+			return float64(c.BoundRows[i])
+		case cmp < 0:
+			return min(c.total()/float64(c.Distinct), float64(d-c.BoundRows[i]))
+		}
+	}
+	return 0
+}
+
+// the estimated rows of the values before v
+func (c *ColumnAnalysis) below(v Value) float64 {
+	if len(c.Depths) == 0 || valueCompare(v, c.Bounds[0]) <= 0 {
+		return 0
+	}
+	n := 0.0
+	for i, d := range c.Depths {
+		switch cmp := valueCompare(v, c.Bounds[i+1]); {
+		case cmp > 0:
+			n += float64(d)
+			continue
+		case cmp == 0:
+			n += float64(d - c.BoundRows[i])
+		default:
+			n += float64(d-c.BoundRows[i]) * valueFraction(c.Bounds[i], c.Bounds[i+1], v)
+		}
+		break
+	}
+	return n
+}
+
+// -1, 0 or 1, in the order of the keys
+func valueCompare(v1, v2 Value) int {
+	return bytes.Compare(encodeValues(nil, []Value{v1}), encodeValues(nil, []Value{v2}))
+}
+
+// where v is between lo & hi, 0 to 1: interpolated for the numbers, the
+// middle for the strings
+func valueFraction(lo, hi, v Value) float64 {
+	var f float64
+	switch v.Type {
+	case TYPE_BYTES:
+		f = 0.5
+	case TYPE_FLOAT64:
+		f = (v.F64 - lo.F64) / (hi.F64 - lo.F64)
+	default:
+		f = float64(v.I64-lo.I64) / float64(hi.I64-lo.I64)
+	}
+	return clamp(f, 0, 1)
+}
+
+func clamp(f, lo, hi float64) float64 {
+	if f < lo || f != f {
+		return lo
+	}
+	if f > hi {
+		return hi
+	}
+	return f
+}
+
+// the conditions of the range of the scan on the leading column of its index
+func scanConds(tdef *TableDef, sc *Scanner) []Cond {
+	if len(sc.Key1.Vals) == 0 && len(sc.Key2.Vals) == 0 {
+		return nil
+	}
+	col := tdef.Cols[0]
+	if sc.indexNo >= 0 {
+		col = tdef.Indexes[sc.indexNo][0]
+	}
+	lo, cmpLo, hi, cmpHi := sc.Key1.Vals, sc.Cmp1, sc.Key2.Vals, sc.Cmp2
+	if cmpLo < 0 {
+		lo, cmpLo, hi, cmpHi = hi, cmpHi, lo, cmpLo
+	}
+	switch {
+	case sc.prefix:
+		return []Cond{{Col: col, Cmp: CMP_PREFIX, Val: lo[0]}}
+	case len(lo) > 0 && len(hi) > 0 && compareValues(lo[0], hi[0]) && (len(lo) > 1 || cmpLo == CMP_GE) && (len(hi) > 1 || cmpHi == CMP_LE):
+		return []Cond{{Col: col, Cmp: CMP_EQ, Val: lo[0]}}
+	}
+	var conds []Cond
+	if len(lo) > 0 && !lo[0].Null {
+		// a bound of more columns includes the value of the first one
+		if len(lo) > 1 {
+			cmpLo = CMP_GE
+		}
+		conds = append(conds, Cond{Col: col, Cmp: cmpLo, Val: lo[0]})
+	}
+	if len(hi) > 0 && !hi[0].Null {
+		if len(hi) > 1 {
+			cmpHi = CMP_LE
+		}
+		conds = append(conds, Cond{Col: col, Cmp: cmpHi, Val: hi[0]})
+	}
+	return conds
+}
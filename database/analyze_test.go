@@ -0,0 +1,143 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 1000 rows: a is skewed, 900 rows of 0 & the others 1 to 100; b is id, or
+// null for every 10th row
+func setupAnalyzeTable(t *testing.T, db *DB) {
+	err := db.Transact(func(tx *DBTX) error {
+		err := tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "a", "b"},
+			PKeys: 1, Indexes: [][]string{{"a"}, {"b"}},
+		})
+		if err != nil {
+			return err
+		}
+		for i := int64(0); i < 1000; i++ {
+			rec := (&Record{}).AddInt64("id", i).AddInt64("a", 0)
+			if i >= 900 {
+				rec.Vals[1].I64 = i - 899
+			}
+			if i%10 == 0 {
+				rec.AddNull("b", TYPE_INT64)
+			} else {
+				rec.AddInt64("b", i)
+			}
+			if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analyze.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	setupAnalyzeTable(t, db)
+
+	// the two ranges tie without the statistics
+	filter := AndExpr(
+		CompareExpr(ColumnExpr("a"), CMP_GE, LiteralExpr(Value{Type: TYPE_INT64, I64: 0})),
+		CompareExpr(ColumnExpr("b"), CMP_GE, LiteralExpr(Value{Type: TYPE_INT64, I64: 990})),
+	)
+	explain := func() *ScanPlan {
+		var plan *ScanPlan
+		err := db.View(func(tx *DBReader) (err error) {
+			plan, err = tx.ExplainAnalyze("items", &Scanner{Filter: filter})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("explain: %v", err)
+		}
+		return plan
+	}
+	if plan := explain(); plan.IndexNo != 0 || plan.Rows != -1 || plan.Actual != 9 {
+		t.Errorf("expected the index of a & no estimate, got %+v", plan)
+	}
+
+	var stats *TableAnalysis
+	err = db.Transact(func(tx *DBTX) (err error) {
+		stats, err = tx.Analyze("items")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	if stats.Rows != 1000 || len(stats.Columns) != 3 || stats.Analyzed.IsZero() {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+	for _, want := range []ColumnAnalysis{{Col: "id", Distinct: 1000}, {Col: "a", Distinct: 101}, {Col: "b", Distinct: 900, Nulls: 100}} {
+		c := stats.Column(want.Col)
+		if c == nil || c.Distinct != want.Distinct || c.Nulls != want.Nulls {
+			t.Errorf("expected %+v, got %+v", want, c)
+			continue
+		}
+		// the buckets are about as deep & do not split a value
+		if int64(c.total())+c.Nulls != 1000 || len(c.Bounds) != len(c.Depths)+1 || len(c.Depths) > ANALYZE_BUCKETS {
+			t.Errorf("unexpected histogram of %s: %v %v", c.Col, c.Bounds, c.Depths)
+		}
+	}
+	if a := stats.Column("a"); a.Depths[0] != 900 {
+		t.Errorf("expected the 900 zeros in the first bucket, got %v", a.Depths)
+	}
+
+	// the range of b is the smaller one now
+	plan := explain()
+	if plan.IndexNo != 1 || !plan.Estimated || plan.Actual != 9 || plan.Rows < 5 || plan.Rows > 15 {
+		t.Errorf("expected the index of b & about 9 rows, got %+v", plan)
+	}
+	if s := plan.String(); !strings.Contains(s, "rows:   ") || !strings.Contains(s, " estimated\nactual: 9") {
+		t.Errorf("expected the estimated & actual rows, got\n%s", s)
+	}
+	for _, tt := range []struct {
+		cond     *Expr
+		min, max int64
+	}{
+		{CompareExpr(ColumnExpr("a"), CMP_EQ, LiteralExpr(Value{Type: TYPE_INT64, I64: 50})), 0, 10},
+		{CompareExpr(ColumnExpr("a"), CMP_GT, LiteralExpr(Value{Type: TYPE_INT64, I64: 50})), 40, 60},
+		{CompareExpr(ColumnExpr("a"), CMP_GT, LiteralExpr(Value{Type: TYPE_INT64, I64: 500})), 0, 0},
+		{CompareExpr(ColumnExpr("b"), CMP_LT, LiteralExpr(Value{Type: TYPE_INT64, I64: 500})), 400, 500},
+		{CompareExpr(ColumnExpr("id"), CMP_LT, LiteralExpr(Value{Type: TYPE_INT64, I64: 250})), 230, 270},
+	} {
+		var plan *ScanPlan
+		db.View(func(tx *DBReader) (err error) {
+			plan, err = tx.Explain("items", &Scanner{Filter: tt.cond})
+			return err
+		})
+		if plan == nil || !plan.Estimated || plan.Rows < tt.min || plan.Rows > tt.max {
+			t.Errorf("%v: expected %d to %d rows, got %+v", tt.cond, tt.min, tt.max, plan)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// kept in the catalog, & by the REPL
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	db.View(func(tx *DBReader) error {
+		again, err := tx.Analysis("items")
+		if err != nil || again == nil || again.Rows != 1000 || !again.Analyzed.Equal(stats.Analyzed) {
+			t.Errorf("expected the statistics kept, got %+v %v", again, err)
+		}
+		return nil
+	})
+	stdout, stderr := captureOutput(t, func() { repl(db, strings.NewReader("analyze items\n"), false) })
+	if stderr != "" || !strings.Contains(stdout, "b                           900        100") {
+		t.Errorf("expected the statistics printed, got %q %q", stdout, stderr)
+	}
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// the next values of the AUTO_INCREMENT keys. they are handed out outside of
+// the transactions so two of them never get the same value, the value of an
+// aborted transaction is not reused. the commits keep the highest value in a
+// meta row, the counter starts after it & after the last key on a restart.
+type autoIncrement struct {
+	mu   sync.Mutex
+	next map[uint32]int64 // by the table prefix
+}
+
+// the meta row of the table
+func autoincKey(tdef *TableDef) []byte {
+	name := fmt.Sprintf("autoinc_%d", tdef.Prefix)
+	return encodeKey(nil, TDEF_META.Prefix, []Value{{Type: TYPE_BYTES, Str: []byte(name)}})
+}
+
+func autoincVal(n int64) []byte {
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(n))
+	return encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: buf}})
+}
+
+// the value of the meta row, 0 if missing
+func autoincGet(tree *BTree, key []byte) (int64, error) {
+	val, ok, err := tree.Get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	out := []Value{{Type: TYPE_BYTES}}
+	if decodeValues(val, out) != 1 || len(out[0].Str) != 8 {
+		return 0, fmt.Errorf("corrupted meta value: invalid length")
+	}
+	return int64(binary.LittleEndian.Uint64(out[0].Str)), nil
+}
+
+// the counter from the latest version, under ai.mu
+func (ai *autoIncrement) load(db *DB, tdef *TableDef) (int64, error) {
+	if next, ok := ai.next[tdef.Prefix]; ok {
+		return next, nil
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	last, err := autoincGet(&reader.Tree, autoincKey(tdef))
+	if err != nil {
+		return 0, err
+	}
+	// the last key too, the meta row is only kept by the writes through autoincFill
+	iter := reader.Tree.SeekLE(binary.BigEndian.AppendUint32(nil, tdef.Prefix+1))
+	if iter.Valid() {
+		key, _ := iter.Deref()
+		if len(key) >= 4 && binary.BigEndian.Uint32(key) == tdef.Prefix {
+			pkey := []Value{{Type: TYPE_INT64}}
+			decodeValues(key[4:], pkey)
+			if pkey[0].I64 > last {
+				last = pkey[0].I64
+			}
+		}
+	}
+	if ai.next == nil {
+		ai.next = map[uint32]int64{}
+	}
+	ai.next[tdef.Prefix] = last + 1
+	return last + 1, nil
+}
+
+// drop the counter of the table prefix, loaded again from the latest version
+func (ai *autoIncrement) forget(prefix uint32) {
+	ai.mu.Lock()
+	delete(ai.next, prefix)
+	ai.mu.Unlock()
+}
+
+// hand out the next value
+func (ai *autoIncrement) take(db *DB, tdef *TableDef) (int64, error) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	next, err := ai.load(db, tdef)
+	if err != nil {
+		return 0, err
+	}
+	ai.next[tdef.Prefix] = next + 1
+	return next, nil
+}
+
+// an explicit key moves the counter past it
+func (ai *autoIncrement) bump(db *DB, tdef *TableDef, n int64) error {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	next, err := ai.load(db, tdef)
+	if err == nil && n >= next {
+		ai.next[tdef.Prefix] = n + 1
+	}
+	return err
+}
+
+// fill in an omitted or null key of an insert, in place when it's a null.
+// the value is kept for the commit, see commitCounters.
+func autoincFill(db *DB, tdef *TableDef, rec *Record, mode int, kvtx *KVTX) error {
+	if !tdef.AutoIncrement || mode == MODE_UPDATE_ONLY {
+		return nil
+	}
+	col := tdef.Cols[0]
+	v := rec.Get(col)
+	var n int64
+	var err error
+	switch {
+	case v == nil || v.Type == 0 || v.Null:
+		if n, err = db.autoinc.take(db, tdef); err != nil {
+			return err
+		}
+		if v == nil {
+			rec.AddInt64(col, n)
+		} else {
+			*v = Value{Type: TYPE_INT64, I64: n}
+		}
+	case v.Type == TYPE_INT64:
+		n = v.I64
+		if err := db.autoinc.bump(db, tdef, n); err != nil {
+			return err
+		}
+	default:
+		return nil // fails in validateTableTypes
+	}
+	key := string(autoincKey(tdef))
+	if kvtx.counters == nil {
+		kvtx.counters = map[string]int64{}
+	}
+	if last, ok := kvtx.counters[key]; !ok || n > last {
+		kvtx.counters[key] = n
+	}
+	return nil
+}
+
+// raise the meta rows to the values used by the transaction, under the
+// writer lock. the concurrent commits raise them too, so they are not writes
+// that conflict.
+func commitCounters(tx *KVTX, w *kvWriter, keys map[string]struct{}) error {
+	for key, n := range tx.counters {
+		last, err := autoincGet(&w.Tree, []byte(key))
+		if err != nil {
+			return err
+		}
+		if n <= last {
+			continue
+		}
+		if err := w.Tree.Insert([]byte(key), autoincVal(n)); err != nil {
+			return err
+		}
+		keys[key] = struct{}{}
+	}
+	return nil
+}
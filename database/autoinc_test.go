@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAutoIncrement(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "autoinc.db")
+	open := func() *DB {
+		t.Helper()
+		db := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+		if err := db.kv.Open(); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if err := initializeInternalTables(db); err != nil && !errors.Is(err, ErrTableAlreadyExists) {
+			t.Fatalf("init: %v", err)
+		}
+		return db
+	}
+	db := open()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:          "events",
+		Types:         []uint32{TYPE_INT64, TYPE_BYTES},
+		Cols:          []string{"id", "name"},
+		PKeys:         1,
+		AutoIncrement: true,
+	})
+	if err == nil {
+		err = db.Commit(&tx)
+	}
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	insert := func(db *DB, rec *Record) int64 {
+		t.Helper()
+		var tx DBTX
+		db.Begin(&tx)
+		if _, err := tx.InsertAuto("events", rec); err != nil {
+			db.Abort(&tx)
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		return rec.Get("id").I64
+	}
+	event := func() *Record {
+		return (&Record{}).AddStr("name", []byte("e"))
+	}
+
+	// assigned in order, an explicit key moves the counter past it
+	if a, b := insert(db, event()), insert(db, event()); a != 1 || b != 2 {
+		t.Errorf("expected ids 1 & 2, got %d & %d", a, b)
+	}
+	if id := insert(db, event().AddInt64("id", 10)); id != 10 {
+		t.Errorf("expected the explicit id 10, got %d", id)
+	}
+	if id := insert(db, event()); id != 11 {
+		t.Errorf("expected id 11 after the explicit one, got %d", id)
+	}
+	// a null key is filled in place by Insert
+	null := Record{Cols: []string{"id", "name"}, Vals: []Value{{}, {Type: TYPE_BYTES, Str: []byte("e")}}}
+	db.Begin(&tx)
+	if _, err := db.Insert("events", null, &tx.kv); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Abort(&tx) // the value is not reused
+	if null.Vals[0].I64 != 12 {
+		t.Errorf("expected the null key to be 12, got %d", null.Vals[0].I64)
+	}
+
+	// concurrent transactions get different values & do not conflict
+	const workers = 8
+	ids := make(chan int64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var tx DBTX
+			db.Begin(&tx)
+			rec := event()
+			if _, err := tx.InsertAuto("events", rec); err != nil {
+				db.Abort(&tx)
+				t.Errorf("failed to insert: %v", err)
+				return
+			}
+			if err := db.Commit(&tx); err != nil {
+				t.Errorf("failed to commit: %v", err)
+				return
+			}
+			ids <- rec.Get("id").I64
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := map[int64]bool{}
+	for id := range ids {
+		if seen[id] || id <= 12 {
+			t.Errorf("unexpected id %d", id)
+		}
+		seen[id] = true
+	}
+
+	// the counter survives a restart, a deleted last row is not reused
+	last := insert(db, event())
+	db.Begin(&tx)
+	if ok, err := tx.Delete("events", *(&Record{}).AddInt64("id", last)); !ok || err != nil {
+		t.Fatalf("failed to delete: %v %v", ok, err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.kv.Close()
+	db = open()
+	defer db.kv.Close()
+	if id := insert(db, event()); id != last+1 {
+		t.Errorf("expected id %d after the restart, got %d", last+1, id)
+	}
+
+	db.Begin(&tx)
+	defer db.Abort(&tx)
+	err = tx.TableNew(&TableDef{
+		Name:          "tags",
+		Types:         []uint32{TYPE_BYTES},
+		Cols:          []string{"name"},
+		PKeys:         1,
+		AutoIncrement: true,
+	})
+	if err == nil {
+		t.Errorf("expected AUTO_INCREMENT on a bytes key to be rejected")
+	}
+}
@@ -2,8 +2,11 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"sort"
+	"sync"
 )
 
 type BNode struct {
@@ -29,6 +32,17 @@ type BTree struct {
 	get func(uint64) BNode // dereference the page number (pointer)
 	new func(BNode) uint64 // create a new page
 	del func(uint64)       // de-allocate the page
+	// bumped on every update, iterators created before are stale
+	version uint64
+	// split a node evenly even when the key was appended at its end
+	evenSplits bool
+	// the version of the catalog of the snapshot, see GetTableDef. 0 once
+	// the transaction wrote the catalog or for a tree not of a snapshot.
+	catalog uint64
+	// the definitions read since the transaction last wrote the catalog
+	defs map[string]*TableDef
+	// the scans of the snapshot stop once it is done, see DB.BeginTx
+	ctx context.Context
 }
 
 func (tree *BTree) Insert(key, val []byte) error {
@@ -38,6 +52,8 @@ func (tree *BTree) Insert(key, val []byte) error {
 	if len(val) > BTREE_MAX_VAL_SIZE {
 		return errors.New("val size exceeds the max size")
 	}
+	tree.version++
+	val, overflow := tree.storeVal(val)
 
 	if tree.root == 0 {
 		root := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
@@ -46,15 +62,18 @@ func (tree *BTree) Insert(key, val []byte) error {
 		// thus a lookup can always find a containing node.
 		nodeAppendKV(root, 0, 0, nil, nil)
 		nodeAppendKV(root, 1, 0, key, val)
+		if overflow {
+			root.setOverflow(1)
+		}
 		tree.root = tree.new(root)
 		return nil
 	}
 	node := tree.get(tree.root)
 	tree.del(tree.root)
 	// Inserts the KV pair & returns the node
-	node = treeInsert(tree, node, key, val)
+	node, appended := treeInsert(tree, node, key, val, overflow)
 	// If the updated node is big we split it
-	nsplit, splitted := nodeSplit3(node)
+	nsplit, splitted := nodeSplit3(node, appended && !tree.evenSplits)
 	if nsplit > 1 {
 		root := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
 		root.setHeader(BNODE_INODE, nsplit)
@@ -80,6 +99,7 @@ func (tree *BTree) Delete(key []byte) bool {
 	if len(updated.data) == 0 {
 		return false
 	}
+	tree.version++
 	tree.del(tree.root)
 	if updated.bNodeType() == BNODE_INODE && updated.nKeys() == 1 {
 		tree.root = updated.getPtr(0)
@@ -89,6 +109,34 @@ func (tree *BTree) Delete(key []byte) bool {
 	return true
 }
 
+// delete every key in [start, end], returns the number of keys deleted.
+// subtrees inside the range are freed without being rewritten.
+func (tree *BTree) DeleteRange(start, end []byte) int {
+	assert(len(start) != 0)
+	if tree.root == 0 || bytes.Compare(start, end) > 0 {
+		return 0
+	}
+	updated, deleted := treeDeleteRange(tree, tree.get(tree.root), start, end, nil)
+	if deleted == 0 {
+		return 0
+	}
+	tree.version++
+	tree.del(tree.root)
+	// the dummy key is never deleted, so the tree can't become empty
+	for updated.bNodeType() == BNODE_INODE && updated.nKeys() == 1 {
+		ptr := updated.getPtr(0)
+		kid := tree.get(ptr)
+		if kid.bNodeType() == BNODE_LEAF || kid.nKeys() > 1 {
+			tree.root = ptr
+			return deleted
+		}
+		tree.del(ptr)
+		updated = kid
+	}
+	tree.root = tree.new(updated)
+	return deleted
+}
+
 func (tree *BTree) Get(key []byte) ([]byte, bool, error) {
 	if len(key) == 0 || len(key) > BTREE_MAX_KEY_SIZE {
 		return nil, false, errors.New("key size is not valid")
@@ -103,7 +151,7 @@ func (tree *BTree) Get(key []byte) ([]byte, bool, error) {
 		case BNODE_LEAF:
 			idx := nodeLookupLE(node, key)
 			if bytes.Equal(node.getKey(idx), key) {
-				return node.getVal(idx), true, nil
+				return tree.loadVal(node, idx), true, nil
 			}
 			return nil, false, nil
 		case BNODE_INODE:
@@ -119,20 +167,26 @@ const HEADER = 4
 
 const (
 	BTREE_PAGE_SIZE = 4096
+	// the page ends with its checksum, see PAGE_CHECKSUM_SIZE
+	BTREE_NODE_SIZE = BTREE_PAGE_SIZE - PAGE_CHECKSUM_SIZE
 	// Adding constraint to KV so a single pair can fit on a single page
-	BTREE_MAX_KEY_SIZE = 1000
-	BTREE_MAX_VAL_SIZE = 3000
+	BTREE_MAX_KEY_SIZE        = 1000
+	BTREE_MAX_INLINE_VAL_SIZE = 3000
+	// larger values are stored in overflow pages
+	BTREE_MAX_VAL_SIZE = 64 << 20
 )
 
 func init() {
 	// 8 - Pointers | 2 - Offsets | 4 - klen(2) & vlen(2)
-	nodeMax := HEADER + 8 + 2 + 4 + BTREE_MAX_KEY_SIZE + BTREE_MAX_VAL_SIZE
-	assertWithSrc(nodeMax <= BTREE_PAGE_SIZE, "Node Max is greater than tree size")
+	nodeMax := HEADER + 8 + 2 + 4 + BTREE_MAX_KEY_SIZE + BTREE_MAX_INLINE_VAL_SIZE
+	assertWithSrc(nodeMax <= BTREE_NODE_SIZE, "Node Max is greater than tree size")
 }
 
 const (
 	BNODE_INODE = 1 // internal nodes without values
 	BNODE_LEAF  = 2 // leaf node with values
+	// a page holding a part of a large value
+	BNODE_OVERFLOW = 4
 )
 
 func (node BNode) bNodeType() uint16 {
@@ -205,7 +259,7 @@ func (node BNode) getVal(idx uint16) []byte {
 	assertWithSrc(idx < node.nKeys(), "Failed in getVal")
 	pos := node.kvPos(idx)
 	klen := binary.LittleEndian.Uint16(node.data[pos:])
-	vlen := binary.LittleEndian.Uint16(node.data[pos+2:])
+	vlen := binary.LittleEndian.Uint16(node.data[pos+2:]) &^ VAL_OVERFLOW
 	// Skip the klen & the vlen by adding 4, then skip the key by adding the klen
 	return node.data[pos+4+klen:][:vlen]
 }
@@ -216,10 +270,8 @@ func (node BNode) nbytes() uint16 {
 
 /// B-Tree Insertion
 
-// Returns the index of the key where it should be located
+// Returns the index of the last key that is less than or equal to the key
 func nodeLookupLE(node BNode, key []byte) uint16 {
-	keysLen := node.nKeys()
-	found := uint16(0)
 	/*	The first key is a copy from the parent node
 		Eg.
 			 	 [30, 50]
@@ -227,71 +279,140 @@ func nodeLookupLE(node BNode, key []byte) uint16 {
 		[10, 20] [30*, 40] [50*, 60, 70]
 		30* & 50* are the copies from the parent node
 	*/
-	for i := uint16(1); i < keysLen; i++ {
-		cmp := bytes.Compare(node.getKey(i), key)
-		if cmp <= 0 {
-			found = i
-		}
-		if cmp > 0 {
-			break
+	// so it is always <= the key & the search starts from idx 1.
+	// binary search for the first key greater than the key, in [lo, hi)
+	lo, hi := uint16(1), node.nKeys()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if bytes.Compare(node.getKey(mid), key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
 		}
 	}
-	return found
+	return lo - 1
 }
 
 // node - Its the node where the insertion is taking place
-func treeInsert(tree *BTree, node BNode, key, val []byte) BNode {
+// overflow - the val is a stub of an overflow chain
+// appended - the node grew at its end, by a new last key or a split last kid
+func treeInsert(tree *BTree, node BNode, key, val []byte, overflow bool) (newNode BNode, appended bool) {
 	// Creating node with double size for copying all vals/ptrs from existing node & inserting the new key/val
-	newNode := BNode{data: make([]byte, 2*BTREE_PAGE_SIZE)}
+	newNode = nodeBufGet()
 	idx := nodeLookupLE(node, key)
 	switch node.bNodeType() {
 	case BNODE_LEAF:
 		// If already exists update the key
 		if bytes.Equal(key, node.getKey(idx)) {
+			tree.freeVal(node, idx)
 			leafUpdate(newNode, node, idx, key, val)
 		} else {
-			leafInsert(newNode, node, idx+1, key, val)
+			idx++
+			leafInsert(newNode, node, idx, key, val)
+			appended = idx == node.nKeys()
+		}
+		if overflow {
+			newNode.setOverflow(idx)
 		}
 	case BNODE_INODE:
-		nodeInsert(tree, newNode, node, idx, key, val)
+		appended = nodeInsert(tree, newNode, node, idx, key, val, overflow)
 	default:
 		panic("bad node!!")
 	}
-	return newNode
+	return newNode, appended
 }
 
-func nodeInsert(tree *BTree, new, node BNode, idx uint16, key, val []byte) {
+func nodeInsert(tree *BTree, new, node BNode, idx uint16, key, val []byte, overflow bool) bool {
 	kptr := node.getPtr(idx)
 	// Leaf node by the kptr(child ptr)
 	knode := tree.get(kptr)
 	tree.del(kptr)
-	knode = treeInsert(tree, knode, key, val)
-	nsplit, splitted := nodeSplit3(knode)
+	knode, appended := treeInsert(tree, knode, key, val, overflow)
+	nsplit, splitted := nodeSplit3(knode, appended && !tree.evenSplits)
 	nodeReplaceKidN(tree, new, node, idx, splitted[:nsplit]...)
+	return idx+1 == node.nKeys() && nsplit > 1
 }
 
-func nodeSplit3(old BNode) (uint16, [3]BNode) {
-	if old.nbytes() <= BTREE_PAGE_SIZE {
-		old.data = old.data[:BTREE_PAGE_SIZE]
-		return 1, [3]BNode{old}
+// old is a buffer of nodeBufGet, it is given back & the pages returned are new.
+// a node appended to keeps its left page full, see nodeSplit2.
+func nodeSplit3(old BNode, appended bool) (uint16, [3]BNode) {
+	defer nodeBufPut(old)
+	if old.nbytes() <= BTREE_NODE_SIZE {
+		return 1, [3]BNode{nodePage(old)}
 	}
-	left := BNode{data: make([]byte, 2*BTREE_PAGE_SIZE)}
+	left := nodeBufGet()
+	defer nodeBufPut(left)
 	right := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
-	nodeSplit2(left, right, old)
-	if left.nbytes() <= BTREE_PAGE_SIZE {
-		return 2, [3]BNode{left, right}
+	nodeSplit2(left, right, old, appended)
+	if left.nbytes() <= BTREE_NODE_SIZE {
+		return 2, [3]BNode{nodePage(left), right}
 	}
 	leftLeft := BNode{make([]byte, BTREE_PAGE_SIZE)}
 	middle := BNode{make([]byte, BTREE_PAGE_SIZE)}
-	nodeSplit2(leftLeft, middle, left)
-	assertWithSrc(leftLeft.nbytes() <= BTREE_PAGE_SIZE, "Failed in nodeSplit3")
-	return 3, [3]BNode{left, middle, right}
+	nodeSplit2(leftLeft, middle, left, appended)
+	assertWithSrc(leftLeft.nbytes() <= BTREE_NODE_SIZE, "Failed in nodeSplit3")
+	return 3, [3]BNode{leftLeft, middle, right}
 }
 
-func nodeSplit2(left, right, old BNode) {
-	midIndex := old.nKeys() / 2
-	nodeAppendRange(left, old, 0, 0, midIndex)
-	nodeAppendRange(right, old, 0, midIndex, old.nKeys()-1)
+// the 2-page buffers a node is built in before it is split. a page kept by
+// the tree is never one of them, see nodePage.
+var nodeBufs = sync.Pool{New: func() any { return new([2 * BTREE_PAGE_SIZE]byte) }}
+
+func nodeBufGet() BNode {
+	buf := nodeBufs.Get().(*[2 * BTREE_PAGE_SIZE]byte)
+	clear(buf[:])
+	return BNode{buf[:]}
+}
+
+func nodeBufPut(node BNode) {
+	nodeBufs.Put((*[2 * BTREE_PAGE_SIZE]byte)(node.data[:2*BTREE_PAGE_SIZE]))
+}
+
+// a copy of a node that fits in a page, out of its buffer
+func nodePage(node BNode) BNode {
+	page := BNode{make([]byte, BTREE_PAGE_SIZE)}
+	copy(page.data, node.data[:node.nbytes()])
+	return page
+}
+
+// split a node so the right half fits in a page, the left half may not.
+// a node appended to is split unevenly instead: the left page is left full
+// & the right one starts with the keys past it, so the sequential inserts
+// fill their pages rather than leave each of them half empty.
+func nodeSplit2(left, right, old BNode, appended bool) {
+	assertWithSrc(old.nKeys() >= 2, "Failed in nodeSplit2")
+	// size of the node made of the first `n` keys
+	leftBytes := func(n uint16) uint16 {
+		return HEADER + 8*n + 2*n + old.getOffset(n)
+	}
+	rightBytes := func(n uint16) uint16 {
+		return old.nbytes() - leftBytes(n) + HEADER
+	}
+	nleft := old.nKeys() / 2
+	if appended {
+		// an internal node keeps 2 kids at least, a lone kid could not be
+		// merged with a sibling
+		keep := uint16(1)
+		if old.bNodeType() == BNODE_INODE {
+			keep = 2
+		}
+		for nleft+keep < old.nKeys() && leftBytes(nleft+1) <= BTREE_NODE_SIZE {
+			nleft++
+		}
+	}
+	for nleft > 1 && leftBytes(nleft) > BTREE_NODE_SIZE {
+		nleft--
+	}
+	for rightBytes(nleft) > BTREE_NODE_SIZE {
+		nleft++
+	}
+	assertWithSrc(nleft < old.nKeys(), "Failed in nodeSplit2")
+	nright := old.nKeys() - nleft
+
+	left.setHeader(old.bNodeType(), nleft)
+	right.setHeader(old.bNodeType(), nright)
+	nodeAppendRange(left, old, 0, 0, nleft)
+	nodeAppendRange(right, old, 0, nleft, nright)
 }
 
 func nodeReplaceKidN(tree *BTree, new BNode, old BNode, idx uint16, kids ...BNode) {
@@ -380,6 +501,7 @@ func treeDelete(tree *BTree, node BNode, key []byte) BNode {
 		if !bytes.Equal(key, node.getKey(idx)) {
 			return BNode{}
 		}
+		tree.freeVal(node, idx)
 		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
 		leafDelete(new, node, idx)
 		return new
@@ -408,15 +530,115 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 		nodeReplace2Kid(new, node, idx-1, tree.new(merged), merged.getKey(0))
 	case mergeDir > 0: // right
 		merged := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
-		nodeMerge(merged, sibling, updated)
+		nodeMerge(merged, updated, sibling)
 		tree.del(node.getPtr(idx + 1))
 		nodeReplace2Kid(new, node, idx, tree.new(merged), merged.getKey(0))
+	case updated.nKeys() == 0:
+		// emptied without a sibling to merge into, the kid is dropped &
+		// the node may empty in turn
+		new.setHeader(BNODE_INODE, node.nKeys()-1)
+		nodeAppendRange(new, node, 0, 0, idx)
+		nodeAppendRange(new, node, idx, idx+1, node.nKeys()-(idx+1))
 	case mergeDir == 0:
 		nodeReplaceKidN(tree, new, node, idx, updated)
 	}
 	return new
 }
 
+// hi is the first key after the node, nil if it's the last node.
+// returns the updated node, which has no keys if it became empty.
+func treeDeleteRange(tree *BTree, node BNode, start, end, hi []byte) (BNode, int) {
+	n := node.nKeys()
+	switch node.bNodeType() {
+	case BNODE_LEAF:
+		lo := sort.Search(int(n), func(i int) bool {
+			return bytes.Compare(node.getKey(uint16(i)), start) >= 0
+		})
+		up := sort.Search(int(n), func(i int) bool {
+			return bytes.Compare(node.getKey(uint16(i)), end) > 0
+		})
+		if lo >= up {
+			return BNode{}, 0
+		}
+		for i := lo; i < up; i++ {
+			tree.freeVal(node, uint16(i))
+		}
+		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		new.setHeader(BNODE_LEAF, n-uint16(up-lo))
+		nodeAppendRange(new, node, 0, 0, uint16(lo))
+		nodeAppendRange(new, node, uint16(lo), uint16(up), n-uint16(up))
+		return new, up - lo
+	case BNODE_INODE:
+		kids := make([]bulkEntry, 0, n)
+		deleted := 0
+		for i := uint16(0); i < n; i++ {
+			kptr, first, next := node.getPtr(i), node.getKey(i), hi
+			if i+1 < n {
+				next = node.getKey(i + 1)
+			}
+			switch {
+			case bytes.Compare(first, end) > 0 || (next != nil && bytes.Compare(next, start) <= 0):
+				// outside the range
+				kids = append(kids, bulkEntry{ptr: kptr, key: first})
+			case bytes.Compare(first, start) >= 0 && next != nil && bytes.Compare(next, end) <= 0:
+				// inside the range
+				deleted += treeFree(tree, kptr)
+			default:
+				updated, ndel := treeDeleteRange(tree, tree.get(kptr), start, end, next)
+				if ndel == 0 {
+					kids = append(kids, bulkEntry{ptr: kptr, key: first})
+					continue
+				}
+				deleted += ndel
+				tree.del(kptr)
+				if updated.nKeys() == 0 {
+					continue
+				}
+				// merge a small node into its left sibling
+				if last := len(kids) - 1; last >= 0 && updated.nbytes() <= BTREE_NODE_SIZE/4 {
+					sibling := tree.get(kids[last].ptr)
+					if sibling.nbytes()+updated.nbytes()-HEADER <= BTREE_NODE_SIZE {
+						merged := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+						nodeMerge(merged, sibling, updated)
+						tree.del(kids[last].ptr)
+						kids[last] = bulkEntry{ptr: tree.new(merged), key: merged.getKey(0)}
+						continue
+					}
+				}
+				kids = append(kids, bulkEntry{ptr: tree.new(updated), key: updated.getKey(0)})
+			}
+		}
+		if deleted == 0 {
+			return BNode{}, 0
+		}
+		if len(kids) == 0 {
+			return BNode{data: make([]byte, BTREE_PAGE_SIZE)}, deleted
+		}
+		packed := nodePack(BNODE_INODE, kids)
+		assertWithSrc(len(packed) == 1, "Failed in treeDeleteRange")
+		return packed[0], deleted
+	default:
+		panic("bad node!!")
+	}
+}
+
+// free a subtree, returns the number of keys in it
+func treeFree(tree *BTree, ptr uint64) int {
+	node := tree.get(ptr)
+	tree.del(ptr)
+	if node.bNodeType() == BNODE_LEAF {
+		for i := uint16(0); i < node.nKeys(); i++ {
+			tree.freeVal(node, i)
+		}
+		return int(node.nKeys())
+	}
+	n := 0
+	for i := uint16(0); i < node.nKeys(); i++ {
+		n += treeFree(tree, node.getPtr(i))
+	}
+	return n
+}
+
 func nodeMerge(new, left, right BNode) {
 	new.setHeader(left.bNodeType(), left.nKeys()+right.nKeys())
 	nodeAppendRange(new, left, 0, 0, left.nKeys())
@@ -431,21 +653,21 @@ func nodeReplace2Kid(new, node BNode, idx uint16, ptr uint64, key []byte) {
 }
 
 func shouldMerge(tree *BTree, node BNode, idx uint16, updated BNode) (int, BNode) {
-	if updated.nbytes() > BTREE_PAGE_SIZE/4 {
+	if updated.nbytes() > BTREE_NODE_SIZE/4 {
 		return 0, BNode{}
 	}
 
 	if idx > 0 {
 		sibling := tree.get(node.getPtr(idx - 1))
 		merged := sibling.nbytes() + updated.nbytes() - HEADER
-		if merged <= BTREE_PAGE_SIZE {
+		if merged <= BTREE_NODE_SIZE {
 			return -1, sibling
 		}
 	}
 	if idx+1 < node.nKeys() {
 		sibling := tree.get(node.getPtr(idx + 1))
 		merged := sibling.nbytes() + updated.nbytes() - HEADER
-		if merged <= BTREE_PAGE_SIZE {
+		if merged <= BTREE_NODE_SIZE {
 			return +1, sibling
 		}
 
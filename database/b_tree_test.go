@@ -0,0 +1,909 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a BTree backed by an in-memory page map
+type testTree struct {
+	tree  BTree
+	pages map[uint64]BNode
+	next  uint64
+}
+
+func newTestTree() *testTree {
+	c := &testTree{pages: map[uint64]BNode{}, next: 1}
+	c.tree.get = func(ptr uint64) BNode {
+		node, ok := c.pages[ptr]
+		assertWithSrc(ok, "bad ptr")
+		return node
+	}
+	c.tree.new = func(node BNode) uint64 {
+		if node.bNodeType() != BNODE_OVERFLOW {
+			assertWithSrc(node.nbytes() <= BTREE_NODE_SIZE, "page too large")
+		}
+		ptr := c.next
+		c.next++
+		c.pages[ptr] = BNode{data: append([]byte{}, node.data[:BTREE_PAGE_SIZE]...)}
+		return ptr
+	}
+	c.tree.del = func(ptr uint64) {
+		_, ok := c.pages[ptr]
+		assertWithSrc(ok, "bad ptr")
+		delete(c.pages, ptr)
+	}
+	return c
+}
+
+func (c *testTree) insert(t *testing.T, key, val string) {
+	if err := c.tree.Insert([]byte(key), []byte(val)); err != nil {
+		t.Fatalf("failed to insert %q: %v", key, err)
+	}
+}
+
+// the number of levels from the root to the leaves
+func (c *testTree) depth() int {
+	depth := 0
+	for ptr := c.tree.root; ptr != 0; depth++ {
+		node := c.tree.get(ptr)
+		ptr = 0
+		if node.bNodeType() == BNODE_INODE {
+			ptr = node.getPtr(0)
+		}
+	}
+	return depth
+}
+
+// keys wide enough to build a tree of several levels quickly
+func testKey(i int) string {
+	return fmt.Sprintf("%0100d", i)
+}
+
+func TestSeekFirstLast(t *testing.T) {
+	t.Run("empty tree", func(t *testing.T) {
+		c := newTestTree()
+		if c.tree.SeekFirst().Valid() {
+			t.Error("expected SeekFirst to be invalid")
+		}
+		if c.tree.SeekLast().Valid() {
+			t.Error("expected SeekLast to be invalid")
+		}
+	})
+
+	t.Run("single leaf", func(t *testing.T) {
+		c := newTestTree()
+		for _, k := range []string{"b", "c", "a"} {
+			c.insert(t, k, "v"+k)
+		}
+		if c.depth() != 1 {
+			t.Fatalf("expected a single leaf, got depth %d", c.depth())
+		}
+		key, val := c.tree.SeekFirst().Deref()
+		if string(key) != "a" || string(val) != "va" {
+			t.Errorf("expected first key a, got %q", key)
+		}
+		key, _ = c.tree.SeekLast().Deref()
+		if string(key) != "c" {
+			t.Errorf("expected last key c, got %q", key)
+		}
+	})
+
+	t.Run("multi-level tree", func(t *testing.T) {
+		c := newTestTree()
+		for i := 2000; i > 0; i-- {
+			c.insert(t, testKey(i), "v")
+		}
+		if c.depth() < 3 {
+			t.Fatalf("expected at least 3 levels, got depth %d", c.depth())
+		}
+		key, _ := c.tree.SeekFirst().Deref()
+		if string(key) != testKey(1) {
+			t.Errorf("expected first key %d, got %q", 1, key)
+		}
+		key, _ = c.tree.SeekLast().Deref()
+		if string(key) != testKey(2000) {
+			t.Errorf("expected last key %d, got %q", 2000, key)
+		}
+	})
+
+	t.Run("only the dummy key left", func(t *testing.T) {
+		c := newTestTree()
+		c.insert(t, "a", "v")
+		c.tree.Delete([]byte("a"))
+		if c.tree.SeekFirst().Valid() {
+			t.Error("expected SeekFirst to be invalid")
+		}
+		if c.tree.SeekLast().Valid() {
+			t.Error("expected SeekLast to be invalid")
+		}
+	})
+}
+
+func TestIterWalk(t *testing.T) {
+	c := newTestTree()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		// insert out of order
+		c.insert(t, testKey((i*7919)%n), fmt.Sprint((i*7919)%n))
+	}
+	if c.depth() < 3 {
+		t.Fatalf("expected at least 3 levels, got depth %d", c.depth())
+	}
+
+	t.Run("forward", func(t *testing.T) {
+		iter := c.tree.SeekFirst()
+		for i := 0; i < n; i++ {
+			if !iter.Valid() {
+				t.Fatalf("iterator ended early at %d", i)
+			}
+			key, val := iter.Deref()
+			if string(key) != testKey(i) || string(val) != fmt.Sprint(i) {
+				t.Fatalf("expected key %d at position %d, got %q", i, i, val)
+			}
+			iter.Next()
+		}
+		// past the last key
+		if iter.Valid() {
+			t.Errorf("expected the iterator invalid past the last key")
+		}
+		if iter.Next(); iter.Valid() {
+			t.Errorf("expected the iterator to stay invalid")
+		}
+	})
+
+	t.Run("backward", func(t *testing.T) {
+		iter := c.tree.SeekLast()
+		for i := n - 1; i >= 0; i-- {
+			if !iter.Valid() {
+				t.Fatalf("iterator ended early at %d", i)
+			}
+			key, _ := iter.Deref()
+			if string(key) != testKey(i) {
+				t.Fatalf("expected key %d, got %q", i, key)
+			}
+			iter.Prev()
+		}
+		// the dummy key comes before every key
+		key, _ := iter.Deref()
+		if len(key) != 0 {
+			t.Errorf("expected the dummy key, got %q", key)
+		}
+		if iter.Prev(); iter.Valid() {
+			t.Errorf("expected the iterator invalid before the first key")
+		}
+		if iter.Prev(); iter.Valid() {
+			t.Errorf("expected the iterator to stay invalid")
+		}
+	})
+
+	t.Run("seek then walk", func(t *testing.T) {
+		iter := c.tree.Seek([]byte(testKey(500)), CMP_GT)
+		for i := 501; i < 1500; i++ {
+			key, _ := iter.Deref()
+			if string(key) != testKey(i) {
+				t.Fatalf("expected key %d, got %q", i, key)
+			}
+			iter.Next()
+		}
+		for i := 1500; i > 100; i-- {
+			key, _ := iter.Deref()
+			if string(key) != testKey(i) {
+				t.Fatalf("expected key %d, got %q", i, key)
+			}
+			iter.Prev()
+		}
+	})
+}
+
+// the linear lookup nodeLookupLE replaced, kept as a reference
+func nodeLookupLELinear(node BNode, key []byte) uint16 {
+	found := uint16(0)
+	for i := uint16(1); i < node.nKeys(); i++ {
+		if bytes.Compare(node.getKey(i), key) > 0 {
+			break
+		}
+		found = i
+	}
+	return found
+}
+
+// builds a leaf with the given sorted keys after the dummy key
+func testLeaf(keys ...string) BNode {
+	node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	node.setHeader(BNODE_LEAF, uint16(len(keys)+1))
+	nodeAppendKV(node, 0, 0, nil, nil)
+	for i, key := range keys {
+		nodeAppendKV(node, uint16(i+1), 0, []byte(key), nil)
+	}
+	return node
+}
+
+func TestNodeLookupLE(t *testing.T) {
+	node := testLeaf("b", "ba", "bab", "bb", "d", "f")
+
+	tests := []struct {
+		name     string
+		key      string
+		expected uint16
+	}{
+		{"smaller than all keys", "a", 0},
+		{"first key", "b", 1},
+		{"last key", "f", 6},
+		{"larger than all keys", "z", 6},
+		{"missing key in the middle", "c", 4},
+		{"duplicate prefix", "ba", 2},
+		{"longer than a duplicate prefix", "baa", 2},
+		{"duplicate prefix extended", "bab", 3},
+		{"between prefixed keys", "bac", 3},
+		{"empty key", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodeLookupLE(node, []byte(tt.key))
+			if got != tt.expected {
+				t.Errorf("expected idx %d, got %d", tt.expected, got)
+			}
+			if linear := nodeLookupLELinear(node, []byte(tt.key)); got != linear {
+				t.Errorf("binary search %d disagrees with linear search %d", got, linear)
+			}
+		})
+	}
+
+	t.Run("only the dummy key", func(t *testing.T) {
+		if got := nodeLookupLE(testLeaf(), []byte("a")); got != 0 {
+			t.Errorf("expected idx 0, got %d", got)
+		}
+	})
+
+	t.Run("every key of a full node", func(t *testing.T) {
+		keys := make([]string, 0, 200)
+		for i := 0; i < 200; i++ {
+			keys = append(keys, fmt.Sprintf("%04d", i*2))
+		}
+		node := testLeaf(keys...)
+		for i := -1; i < 401; i++ {
+			key := []byte(fmt.Sprintf("%04d", i))
+			if got, linear := nodeLookupLE(node, key), nodeLookupLELinear(node, key); got != linear {
+				t.Fatalf("key %s: binary search %d disagrees with linear search %d", key, got, linear)
+			}
+		}
+	})
+}
+
+var benchTree struct {
+	once sync.Once
+	tree *testTree
+}
+
+const benchTreeKeys = 1_000_000
+
+func benchKey(i int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(i))
+}
+
+func setupBenchTree(b *testing.B) *testTree {
+	benchTree.once.Do(func() {
+		c := newTestTree()
+		for i := 0; i < benchTreeKeys; i++ {
+			if err := c.tree.Insert(benchKey(i), []byte("v")); err != nil {
+				b.Fatalf("failed to insert: %v", err)
+			}
+		}
+		benchTree.tree = c
+	})
+	return benchTree.tree
+}
+
+func BenchmarkTreeGet(b *testing.B) {
+	c := setupBenchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := benchKey((i * 7919) % benchTreeKeys)
+		if _, ok, _ := c.tree.Get(key); !ok {
+			b.Fatal("key not found")
+		}
+	}
+}
+
+func BenchmarkTreeGetLinear(b *testing.B) {
+	c := setupBenchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := benchKey((i * 7919) % benchTreeKeys)
+		// the same descent as BTree.Get, with the linear lookup
+		node := c.tree.get(c.tree.root)
+		for node.bNodeType() == BNODE_INODE {
+			node = c.tree.get(node.getPtr(nodeLookupLELinear(node, key)))
+		}
+		if !bytes.Equal(node.getKey(nodeLookupLELinear(node, key)), key) {
+			b.Fatal("key not found")
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	build := func(t *testing.T) *testTree {
+		c := newTestTree()
+		c.tree.evenSplits = true // a root of more than 2 children
+		for i := 0; i < 2000; i++ {
+			c.insert(t, testKey(i), "v")
+		}
+		return c
+	}
+	// the root & its first child
+	nodes := func(c *testTree) (BNode, uint64, BNode) {
+		root := c.tree.get(c.tree.root)
+		kid := root.getPtr(1)
+		return root, kid, c.tree.get(kid)
+	}
+
+	t.Run("valid trees", func(t *testing.T) {
+		if err := newTestTree().tree.Verify(); err != nil {
+			t.Errorf("empty tree: unexpected error: %v", err)
+		}
+		c := build(t)
+		for i := 0; i < 2000; i += 3 {
+			c.tree.Delete([]byte(testKey(i)))
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		corrupt func(c *testTree)
+		errMsg  string
+	}{
+		{
+			name: "unsorted keys",
+			corrupt: func(c *testTree) {
+				root, _, _ := nodes(c)
+				leaf := c.tree.get(c.tree.get(root.getPtr(0)).getPtr(0))
+				// overwrite the last byte of a key so it sorts before its predecessor
+				pos := leaf.kvPos(2)
+				klen := binary.LittleEndian.Uint16(leaf.data[pos:])
+				leaf.data[pos+4+klen-1] = 0
+			},
+			errMsg: "keys are not sorted",
+		},
+		{
+			name: "null child pointer",
+			corrupt: func(c *testTree) {
+				root, _, _ := nodes(c)
+				root.setPtr(0, 1)
+			},
+			errMsg: "null child pointer",
+		},
+		{
+			name: "unresolvable child pointer",
+			corrupt: func(c *testTree) {
+				root, _, _ := nodes(c)
+				root.setPtr(99999, 1)
+			},
+			errMsg: "page 99999: cannot be read",
+		},
+		{
+			name: "bad node type",
+			corrupt: func(c *testTree) {
+				_, _, kid := nodes(c)
+				binary.LittleEndian.PutUint16(kid.data, 7)
+			},
+			errMsg: "bad node type",
+		},
+		{
+			name: "child outside the separators",
+			corrupt: func(c *testTree) {
+				root, _, _ := nodes(c)
+				// swap two children
+				a, b := root.getPtr(1), root.getPtr(2)
+				root.setPtr(b, 1)
+				root.setPtr(a, 2)
+			},
+			errMsg: "does not match the parent separator",
+		},
+		{
+			name: "internal node with one kid",
+			corrupt: func(c *testTree) {
+				_, _, kid := nodes(c)
+				lone := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+				lone.setHeader(BNODE_INODE, 1)
+				nodeAppendRange(lone, kid, 0, 0, 1)
+				copy(kid.data, lone.data)
+			},
+			errMsg: "internal node with 1 kid",
+		},
+		{
+			name: "empty leaf",
+			corrupt: func(c *testTree) {
+				_, _, kid := nodes(c)
+				c.tree.get(kid.getPtr(1)).setHeader(BNODE_LEAF, 0)
+			},
+			errMsg: "node without keys",
+		},
+		{
+			name: "uneven leaf depth",
+			corrupt: func(c *testTree) {
+				root, _, kid := nodes(c)
+				// skip a level, the leaf has the same first key as its parent
+				root.setPtr(kid.getPtr(0), 1)
+			},
+			errMsg: "leaf at depth 1, expected 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := build(t)
+			if c.depth() < 3 {
+				t.Fatalf("expected at least 3 levels, got depth %d", c.depth())
+			}
+			tt.corrupt(c)
+			err := c.tree.Verify()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !isEqual(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+// the keys & values of the tree in order, without the dummy key
+func (c *testTree) dump() []string {
+	var out []string
+	for iter := c.tree.SeekFirst(); iter.Valid(); iter.Next() {
+		key, val := iter.Deref()
+		out = append(out, string(key)+"="+string(val))
+	}
+	return out
+}
+
+// the fewest kids of the internal nodes under ptr, the root aside
+func (c *testTree) minKids(ptr uint64, root bool) int {
+	node := c.tree.get(ptr)
+	if node.bNodeType() != BNODE_INODE {
+		return math.MaxInt
+	}
+	kids := math.MaxInt
+	if !root {
+		kids = int(node.nKeys())
+	}
+	for i := uint16(0); i < node.nKeys(); i++ {
+		kids = min(kids, c.minKids(node.getPtr(i), false))
+	}
+	return kids
+}
+
+func TestBulkLoad(t *testing.T) {
+	bulk := func(from, to, step int) ([][]byte, [][]byte) {
+		var keys, vals [][]byte
+		for i := from; i < to; i += step {
+			keys = append(keys, []byte(testKey(i)))
+			vals = append(vals, []byte(fmt.Sprint(i)))
+		}
+		return keys, vals
+	}
+
+	t.Run("empty tree", func(t *testing.T) {
+		c, want := newTestTree(), newTestTree()
+		want.tree.evenSplits = true
+		keys, vals := bulk(0, 3000, 1)
+		if err := c.tree.BulkLoad(keys, vals); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := range keys {
+			want.insert(t, string(keys[i]), string(vals[i]))
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		if fmt.Sprint(c.dump()) != fmt.Sprint(want.dump()) {
+			t.Error("the bulk loaded tree differs from the inserted one")
+		}
+		// packed full, so fewer pages than with even splits
+		if len(c.pages) >= len(want.pages) {
+			t.Errorf("expected fewer than %d pages, got %d", len(want.pages), len(c.pages))
+		}
+		// still usable by the normal updates
+		c.insert(t, testKey(-1), "x")
+		for i := 0; i < 3000; i += 2 {
+			c.tree.Delete([]byte(testKey(i)))
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("invalid tree after updates: %v", err)
+		}
+	})
+
+	t.Run("merge into an existing tree", func(t *testing.T) {
+		c, want := newTestTree(), newTestTree()
+		for i := 0; i < 3000; i += 3 {
+			c.insert(t, testKey(i), fmt.Sprint(i))
+			want.insert(t, testKey(i), fmt.Sprint(i))
+		}
+		old := c.tree.root
+		keys, vals := bulk(1, 4000, 3)
+		if err := c.tree.BulkLoad(keys, vals); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.tree.root == old {
+			t.Fatal("expected a new root")
+		}
+		for i := range keys {
+			want.insert(t, string(keys[i]), string(vals[i]))
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		if fmt.Sprint(c.dump()) != fmt.Sprint(want.dump()) {
+			t.Error("the bulk loaded tree differs from the inserted one")
+		}
+	})
+
+	// the last node of a level used to get the entries left over, down to
+	// a lone kid that the deletes could not merge
+	t.Run("delete every key", func(t *testing.T) {
+		for _, n := range []int{6436, 5000, 7777} {
+			c := newTestTree()
+			var keys, vals [][]byte
+			for i := 0; i < n; i++ {
+				keys = append(keys, []byte(fmt.Sprintf("k%06d", i)))
+				vals = append(vals, bytes.Repeat([]byte("v"), 100))
+			}
+			if err := c.tree.BulkLoad(keys, vals); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if kids := c.minKids(c.tree.root, true); kids < 2 {
+				t.Errorf("%d keys: expected 2 kids or more per internal node, got %d", n, kids)
+			}
+			for i := len(keys) - 1; i >= 0; i-- {
+				if !c.tree.Delete(keys[i]) {
+					t.Fatalf("%d keys: failed to delete %s", n, keys[i])
+				}
+			}
+			if err := c.tree.Verify(); err != nil {
+				t.Fatalf("%d keys: invalid tree after the deletes: %v", n, err)
+			}
+			if len(c.dump()) != 0 {
+				t.Errorf("%d keys: expected no key left, got %d", n, len(c.dump()))
+			}
+		}
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		c := newTestTree()
+		for i := 0; i < 100; i += 2 {
+			c.insert(t, testKey(i), "v")
+		}
+		tests := []struct {
+			name   string
+			keys   []int
+			errMsg string
+		}{
+			{"unsorted", []int{1, 5, 3}, "key 2: keys are not sorted"},
+			{"duplicate", []int{1, 3, 3}, "key 2: duplicate key"},
+			{"existing key", []int{1, 3, 4}, "key 2: key already exists"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var keys, vals [][]byte
+				for _, k := range tt.keys {
+					keys = append(keys, []byte(testKey(k)))
+					vals = append(vals, nil)
+				}
+				root, version := c.tree.root, c.tree.version
+				err := c.tree.BulkLoad(keys, vals)
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !isEqual(err.Error(), tt.errMsg) {
+					t.Errorf("expected error containing %q, got %q", tt.errMsg, err.Error())
+				}
+				if c.tree.root != root || c.tree.version != version {
+					t.Error("expected the tree to be untouched")
+				}
+			})
+		}
+	})
+}
+
+const benchLoadKeys = 100_000
+
+func BenchmarkTreeBulkLoad(b *testing.B) {
+	keys := make([][]byte, benchLoadKeys)
+	vals := make([][]byte, benchLoadKeys)
+	for i := range keys {
+		keys[i], vals[i] = benchKey(i), []byte("v")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := newTestTree()
+		if err := c.tree.BulkLoad(keys, vals); err != nil {
+			b.Fatalf("failed to load: %v", err)
+		}
+	}
+}
+
+func BenchmarkTreeInsertSequential(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := newTestTree()
+		for j := 0; j < benchLoadKeys; j++ {
+			if err := c.tree.Insert(benchKey(j), []byte("v")); err != nil {
+				b.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+}
+
+// the appends leave their pages full, the random inserts still split evenly
+func TestSequentialSplits(t *testing.T) {
+	const n = 100_000
+	pages := func(evenSplits bool) int {
+		c := newTestTree()
+		c.tree.evenSplits = evenSplits
+		for i := 0; i < n; i++ {
+			if err := c.tree.Insert(benchKey(i), []byte("v")); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		if stats := c.tree.Stats(); stats.Keys != n {
+			t.Fatalf("expected %d keys, got %+v", n, stats)
+		}
+		return len(c.pages)
+	}
+	even, uneven := pages(true), pages(false)
+	if float64(even)/float64(uneven) < 1.9 {
+		t.Errorf("expected about half the pages, got %d instead of %d", uneven, even)
+	}
+
+	// the split off internal nodes keep 2 kids at least
+	c := newTestTree()
+	for i := 0; i < 20_000; i++ {
+		c.insert(t, string(benchKey(i)), strings.Repeat("v", 200))
+	}
+	if kids := c.minKids(c.tree.root, true); kids < 2 {
+		t.Fatalf("internal node with %d kid", kids)
+	}
+
+	// random keys, then more appends to the full pages
+	c = newTestTree()
+	keys := rand.New(rand.NewSource(1)).Perm(20_000)
+	for _, i := range keys {
+		c.insert(t, string(benchKey(i)), "v")
+	}
+	for i := 20_000; i < 30_000; i++ {
+		c.insert(t, string(benchKey(i)), "v")
+	}
+	for _, i := range keys[:5000] {
+		c.insert(t, string(benchKey(i)), "updated")
+	}
+	if err := c.tree.Verify(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+	for i := 0; i < 30_000; i++ {
+		if _, ok, _ := c.tree.Get(benchKey(i)); !ok {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+}
+
+// the number of pages reachable from the root, overflow pages included
+func (c *testTree) reachable() int {
+	var walk func(ptr uint64) int
+	walk = func(ptr uint64) int {
+		node, n := c.tree.get(ptr), 1
+		for i := uint16(0); i < node.nKeys(); i++ {
+			switch {
+			case node.bNodeType() == BNODE_INODE:
+				n += walk(node.getPtr(i))
+			case node.isOverflow(i):
+				n += overflowPages(node.valLen(i))
+			}
+		}
+		return n
+	}
+	if c.tree.root == 0 {
+		return 0
+	}
+	return walk(c.tree.root)
+}
+
+func TestDeleteRange(t *testing.T) {
+	const n = 3000
+	tests := []struct {
+		name       string
+		start, end int
+		deleted    int
+	}{
+		{"single key", 10, 10, 1},
+		{"within a leaf", 10, 15, 6},
+		{"across leaves", 100, 300, 201},
+		{"most of the tree", 5, 2990, 2986},
+		{"everything", 0, n - 1, n},
+		{"after the last key", n, n + 10, 0},
+		{"empty range", 20, 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestTree()
+			for i := 0; i < n; i++ {
+				c.insert(t, testKey(i), fmt.Sprint(i))
+			}
+			got := c.tree.DeleteRange([]byte(testKey(tt.start)), []byte(testKey(tt.end)))
+			if got != tt.deleted {
+				t.Errorf("expected %d keys deleted, got %d", tt.deleted, got)
+			}
+			if err := c.tree.Verify(); err != nil {
+				t.Fatalf("invalid tree: %v", err)
+			}
+			// no page is leaked
+			if c.reachable() != len(c.pages) {
+				t.Errorf("expected %d pages, %d are reachable", len(c.pages), c.reachable())
+			}
+			for i := 0; i < n; i++ {
+				_, ok, _ := c.tree.Get([]byte(testKey(i)))
+				if inRange := i >= tt.start && i <= tt.end; ok == inRange {
+					t.Fatalf("key %d: expected found=%v", i, !inRange)
+				}
+			}
+			// still usable by the normal updates
+			c.insert(t, testKey(tt.start), "x")
+			if err := c.tree.Verify(); err != nil {
+				t.Fatalf("invalid tree after insert: %v", err)
+			}
+		})
+	}
+}
+
+func TestTreeStats(t *testing.T) {
+	if stats := newTestTree().tree.Stats(); stats != (TreeStats{}) {
+		t.Errorf("expected zero stats for an empty tree, got %+v", stats)
+	}
+
+	c := newTestTree()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		c.insert(t, testKey(i), "val")
+	}
+	stats := c.tree.Stats()
+	if stats.Depth != c.depth() {
+		t.Errorf("expected depth %d, got %d", c.depth(), stats.Depth)
+	}
+	if stats.LeafNodes+stats.InternalNodes != len(c.pages) {
+		t.Errorf("expected %d nodes, got %+v", len(c.pages), stats)
+	}
+	if stats.InternalNodes == 0 || stats.LeafNodes <= stats.InternalNodes {
+		t.Errorf("unexpected node counts %+v", stats)
+	}
+	if stats.Keys != n || stats.BytesKeys != n*100 || stats.BytesVals != n*3 {
+		t.Errorf("expected %d keys of %d & %d bytes, got %+v", n, n*100, n*3, stats)
+	}
+	if stats.AvgFill <= 0.25 || stats.AvgFill > 1 {
+		t.Errorf("unexpected fill %v", stats.AvgFill)
+	}
+
+	// a range only counts the nodes on its path
+	part := treeStatsRange(&c.tree, []byte(testKey(10)), []byte(testKey(20)))
+	if part.Keys != 10 || part.Depth != stats.Depth || part.LeafNodes > 2 || part.InternalNodes != stats.Depth-1 {
+		t.Errorf("unexpected range stats %+v", part)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	bigVal := func(size int, seed byte) []byte {
+		val := make([]byte, size)
+		for i := range val {
+			val[i] = seed + byte(i%251)
+		}
+		return val
+	}
+	small, kb10, mb1 := []byte("small"), bigVal(10<<10, 1), bigVal(1<<20, 2)
+	inline := bigVal(BTREE_MAX_INLINE_VAL_SIZE, 3)
+
+	c := newTestTree()
+	vals := map[string][]byte{}
+	for i := 0; i < 300; i++ {
+		val := small
+		switch i % 50 {
+		case 7:
+			val = kb10
+		case 13:
+			val = inline
+		case 29:
+			val = mb1
+		}
+		if err := c.tree.Insert([]byte(testKey(i)), val); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		vals[testKey(i)] = val
+	}
+	check := func(t *testing.T) {
+		t.Helper()
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		if c.reachable() != len(c.pages) {
+			t.Errorf("expected %d pages, %d are reachable", len(c.pages), c.reachable())
+		}
+		for key, want := range vals {
+			got, ok, err := c.tree.Get([]byte(key))
+			if err != nil || !ok || !bytes.Equal(got, want) {
+				t.Fatalf("key %q: expected a value of %d bytes, got %d bytes", key, len(want), len(got))
+			}
+		}
+		// the iterator sees the same values
+		n := 0
+		for iter := c.tree.Seek([]byte(testKey(0)), CMP_GE); iter.Valid() && n < len(vals); iter.Next() {
+			key, val := iter.Deref()
+			if !bytes.Equal(val, vals[string(key)]) {
+				t.Fatalf("key %q: the iterator returned a value of %d bytes", key, len(val))
+			}
+			n++
+		}
+		if n != len(vals) {
+			t.Errorf("expected %d keys from the iterator, got %d", len(vals), n)
+		}
+	}
+	check(t)
+	stats := c.tree.Stats()
+	if want := 6*overflowPages(len(kb10)) + 6*overflowPages(len(mb1)); stats.OverflowPages != want {
+		t.Errorf("expected %d overflow pages, got %d", want, stats.OverflowPages)
+	}
+
+	// replaced & deleted values free their pages
+	for i := 7; i < 300; i += 50 {
+		c.insert(t, testKey(i), "small")
+		vals[testKey(i)] = small
+	}
+	for i := 29; i < 300; i += 100 {
+		c.tree.Delete([]byte(testKey(i)))
+		delete(vals, testKey(i))
+	}
+	check(t)
+	// 129 is already deleted
+	if n := c.tree.DeleteRange([]byte(testKey(100)), []byte(testKey(199))); n != 99 {
+		t.Errorf("expected 99 keys deleted, got %d", n)
+	}
+	for i := 100; i < 200; i++ {
+		delete(vals, testKey(i))
+	}
+	check(t)
+	if stats := c.tree.Stats(); stats.OverflowPages != 2*overflowPages(len(mb1)) {
+		t.Errorf("expected the pages of 2 values left, got %d", stats.OverflowPages)
+	}
+
+	// bulk load keeps the old & the new overflow values
+	keys, bvals := [][]byte{[]byte(testKey(150)), []byte(testKey(151))}, [][]byte{kb10, small}
+	if err := c.tree.BulkLoad(keys, bvals); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vals[testKey(150)], vals[testKey(151)] = kb10, small
+	check(t)
+
+	if err := c.tree.Insert([]byte("k"), make([]byte, BTREE_MAX_VAL_SIZE+1)); err == nil {
+		t.Error("expected an error for a value over the max size")
+	}
+}
+
+func BenchmarkTreeInsertRandom(b *testing.B) {
+	order := rand.New(rand.NewSource(1)).Perm(benchLoadKeys)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := newTestTree()
+		for _, j := range order {
+			if err := c.tree.Insert(benchKey(j), []byte("v")); err != nil {
+				b.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// the number of pages copied per write
+const BACKUP_BATCH = 64
+
+// stream a consistent snapshot of the DB file, the output can be opened as
+// a DB. writers are only blocked while the snapshot is taken.
+func (db *DB) Backup(dst io.Writer) error {
+	return db.kv.Backup(dst)
+}
+
+func (kv *KV) Backup(dst io.Writer) error {
+	// the snapshot pins the pages it can reach, they are not reused until it ends
+	var tx KVReader
+	kv.BeginRead(&tx)
+	defer kv.EndRead(&tx)
+
+	master := masterEncode(masterState{
+		version: tx.version,
+		root:    tx.Tree.root,
+		used:    tx.used,
+		free:    tx.free,
+	}, kv.flags, kv.format)
+	batch := make([]byte, 0, BACKUP_BATCH*BTREE_PAGE_SIZE)
+	batch = append(batch, master[:]...)
+	batch = batch[:BTREE_PAGE_SIZE]
+
+	for ptr := uint64(1); ptr < tx.used; ptr++ {
+		page := append(batch[len(batch):], tx.pageRaw(ptr)...)
+		if ptr == tx.free.tailPage && tx.checksums {
+			// the tail node is updated in place past the snapshot's items,
+			// the copy may have caught a write
+			pageSetChecksum(page)
+		}
+		batch = batch[:len(batch)+BTREE_PAGE_SIZE]
+		if len(batch) == cap(batch) {
+			if _, err := dst.Write(batch); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if _, err := dst.Write(batch); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// write a backup stream to a new file at `path`, the file is removed
+// unless it opens as a DB with a valid tree
+func Restore(src io.Reader, path string) error {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if err := restoreFile(fp, src); err != nil {
+		_ = os.Remove(path)
+		_ = os.Remove(walPath(path))
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
+
+func restoreFile(fp *os.File, src io.Reader) error {
+	n, err := io.Copy(fp, src)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// would be opened as a new DB
+		return ErrNotDatabase
+	}
+
+	// the header & the master page are checked by Open
+	kv := KV{Path: fp.Name()}
+	if err := kv.Open(); err != nil {
+		return err
+	}
+	defer kv.Close()
+	var tx KVReader
+	kv.BeginRead(&tx)
+	defer kv.EndRead(&tx)
+	if tx.checksums {
+		if _, errs := tx.VerifyPages(); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return tx.Tree.Verify()
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// holds the backup after its first write until released
+type blockingWriter struct {
+	buf     bytes.Buffer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if w.buf.Len() == 0 {
+		close(w.started)
+		<-w.release
+	}
+	return w.buf.Write(p)
+}
+
+func TestBackup(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 300; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- db.Backup(w)
+	}()
+	<-w.started
+
+	// writes continue while the backup is copying
+	committed := make(chan error)
+	go func() {
+		var tx DBTX
+		db.Begin(&tx)
+		for id := int64(1); id <= 400; id++ {
+			if id <= 150 {
+				if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", id)); err != nil {
+					committed <- err
+					return
+				}
+				continue
+			}
+			rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("Jane")).AddStr("email", []byte("jane@example.com"))
+			if _, err := tx.Set("users", *rec, MODE_UPSERT); err != nil {
+				committed <- err
+				return
+			}
+		}
+		committed <- db.Commit(&tx)
+	}()
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatalf("failed to write during the backup: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writer is blocked by the backup")
+	}
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := os.WriteFile(path, w.buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	backup := &DB{Path: path, kv: *newKV(path), tables: make(map[string]cachedDef)}
+	if err := backup.kv.Open(); err != nil {
+		t.Fatalf("failed to open the backup: %v", err)
+	}
+	defer backup.kv.Close()
+
+	var reader KVReader
+	backup.kv.BeginRead(&reader)
+	defer backup.kv.EndRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Fatalf("invalid tree in the backup: %v", err)
+	}
+	if _, errs := reader.VerifyPages(); len(errs) != 0 {
+		t.Fatalf("corrupt pages in the backup: %v", errs)
+	}
+	// the rows as of the snapshot
+	sc, err := backup.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := scanIDs(sc, &reader.Tree)
+	if len(got) != 300 || got[0] != 1 || got[299] != 300 {
+		t.Fatalf("expected ids 1..300 in the backup, got %d ids", len(got))
+	}
+	rec := (&Record{}).AddInt64("id", 200)
+	if ok, err := backup.Get("users", rec, &reader); !ok || err != nil || string(rec.Get("name").Str) != "John" {
+		t.Errorf("expected the row before the concurrent update, got %v %v %v", ok, err, rec)
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+	setupTestTable(t, db)
+	insertTestRecord(t, db, 1)
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := backupFile(db, path); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if err := backupFile(db, path); err == nil {
+		t.Fatalf("expected the backup not to overwrite an existing file")
+	}
+	kv := newKV(path)
+	if err := kv.Open(); err != nil {
+		t.Fatalf("failed to open the backup: %v", err)
+	}
+	kv.Close()
+}
+
+func TestRestore(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+	setupTestTable(t, db)
+	for id := int64(1); id <= 100; id++ {
+		insertTestRecord(t, db, id)
+	}
+	var buf bytes.Buffer
+	if err := db.Backup(&buf); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "restored.db")
+	if err := Restore(bytes.NewReader(buf.Bytes()), path); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored := &DB{Path: path, kv: *newKV(path), tables: make(map[string]cachedDef)}
+	if err := restored.kv.Open(); err != nil {
+		t.Fatalf("failed to open the restored file: %v", err)
+	}
+	var reader KVReader
+	restored.kv.BeginRead(&reader)
+	sc, err := restored.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &reader.Tree); len(got) != 100 {
+		t.Errorf("expected 100 rows, got %d", len(got))
+	}
+	restored.kv.EndRead(&reader)
+	restored.kv.Close()
+
+	// an existing file is kept
+	if err := Restore(bytes.NewReader(buf.Bytes()), path); err == nil {
+		t.Errorf("expected the restore not to overwrite an existing file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the existing file to be kept: %v", err)
+	}
+
+	// bad streams leave nothing behind
+	for name, data := range map[string][]byte{
+		"truncated": buf.Bytes()[:buf.Len()/2],
+		"garbage":   bytes.Repeat([]byte("not a db"), BTREE_PAGE_SIZE),
+		"empty":     nil,
+	} {
+		path := filepath.Join(dir, name+".db")
+		if err := Restore(bytes.NewReader(data), path); err == nil {
+			t.Errorf("%s: expected the restore to fail", name)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the file to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	dir := t.TempDir()
+	open := func(path string) error {
+		kv := KV{Path: path}
+		err := kv.Open()
+		if err == nil {
+			kv.Close()
+		}
+		return err
+	}
+	// a file with the master page edited at `off`
+	edit := func(name string, off int64, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := open(path); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		fp, err := os.OpenFile(path, os.O_RDWR, 0o644)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer fp.Close()
+		if _, err := fp.WriteAt(data, off); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+
+	random := filepath.Join(dir, "random")
+	if err := os.WriteFile(random, []byte("hello world, this is not a database\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := open(random); !errors.Is(err, ErrNotDatabase) || !strings.Contains(err.Error(), "not an AtomixDB file") {
+		t.Errorf("expected not an AtomixDB file, got %v", err)
+	}
+
+	version := binary.LittleEndian.AppendUint32(nil, FORMAT_VERSION+1)
+	err := open(edit("version", 72, version))
+	if !errors.Is(err, ErrUnsupportedVersion) || !strings.Contains(err.Error(), fmt.Sprintf("unsupported version %d", FORMAT_VERSION+1)) {
+		t.Errorf("expected an unsupported version, got %v", err)
+	}
+
+	pageSize := binary.LittleEndian.AppendUint32(nil, 8192)
+	if err := open(edit("page size", 76, pageSize)); err == nil || !strings.Contains(err.Error(), "unsupported page size 8192") {
+		t.Errorf("expected an unsupported page size, got %v", err)
+	}
+
+	// version 1 has no format field & is upgraded on close
+	legacy := edit("legacy", 72, make([]byte, 8))
+	if err := open(legacy); err != nil {
+		t.Fatalf("failed to open a version 1 file: %v", err)
+	}
+	data, err := os.ReadFile(legacy)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if format := binary.LittleEndian.Uint32(data[72:]); format != FORMAT_VERSION {
+		t.Errorf("expected the file to be upgraded to version %d, got %d", FORMAT_VERSION, format)
+	}
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// a group of writes to several tables, applied atomically by Commit in a
+// single transaction with a single fsync. the writes are applied in order,
+// the inserts between the other writes are sorted & bulk loaded per table
+// along with their index keys. an error discards the whole batch.
+type WriteBatch struct {
+	db  *DB
+	ops []batchOp
+}
+
+type batchOp struct {
+	mode  int // MODE_INSERT_ONLY, MODE_UPDATE_ONLY or BATCH_DELETE
+	table string
+	rec   Record
+}
+
+const BATCH_DELETE = -1
+
+func (db *DB) WriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// the row must have every column
+func (b *WriteBatch) Insert(table string, rec Record) {
+	b.ops = append(b.ops, batchOp{MODE_INSERT_ONLY, table, rec})
+}
+
+func (b *WriteBatch) Update(table string, rec Record) {
+	b.ops = append(b.ops, batchOp{MODE_UPDATE_ONLY, table, rec})
+}
+
+// the row only needs the primary key
+func (b *WriteBatch) Delete(table string, rec Record) {
+	b.ops = append(b.ops, batchOp{BATCH_DELETE, table, rec})
+}
+
+// the number of writes waiting for Commit
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// apply the writes, the batch is empty afterwards unless it fails
+func (b *WriteBatch) Commit() error {
+	var tx DBTX
+	b.db.Begin(&tx)
+	if err := batchApply(b.db, b.ops, &tx.kv); err != nil {
+		b.db.Abort(&tx)
+		return err
+	}
+	if err := b.db.Commit(&tx); err != nil {
+		return err
+	}
+	b.ops = b.ops[:0]
+	return nil
+}
+
+func batchApply(db *DB, ops []batchOp, kvtx *KVTX) error {
+	inserts := map[string][]Record{}
+	var tables []string // in the order of the first insert
+	flush := func() error {
+		for _, table := range tables {
+			rows, err := batchSort(db, table, inserts[table], kvtx)
+			if err == nil {
+				err = db.BulkInsert(table, rows, kvtx)
+			}
+			if err != nil {
+				return fmt.Errorf("insert into %s: %w", table, err)
+			}
+			delete(inserts, table)
+		}
+		tables = tables[:0]
+		return nil
+	}
+
+	for i, op := range ops {
+		if op.mode == MODE_INSERT_ONLY {
+			if _, ok := inserts[op.table]; !ok {
+				tables = append(tables, op.table)
+			}
+			inserts[op.table] = append(inserts[op.table], op.rec)
+			continue
+		}
+		// the inserts before it must be visible
+		if err := flush(); err != nil {
+			return err
+		}
+		var err error
+		if op.mode == MODE_UPDATE_ONLY {
+			_, err = db.Update(op.table, op.rec, kvtx)
+		} else {
+			_, err = db.Delete(op.table, op.rec, kvtx)
+		}
+		if err != nil {
+			return fmt.Errorf("write %d to %s: %w", i, op.table, err)
+		}
+	}
+	return flush()
+}
+
+// sort the rows by the primary key, two rows with the same key fail with ErrKeyExists
+func batchSort(db *DB, table string, rows []Record, kvtx *KVTX) ([]Record, error) {
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	keys := make([][]byte, len(rows))
+	for i, rec := range rows {
+		values, err := checkRecord(tdef, rec, tdef.PKeys)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		keys[i] = encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(keys[order[a]], keys[order[b]]) < 0
+	})
+	sorted := make([]Record, len(rows))
+	for i, idx := range order {
+		if i > 0 && bytes.Equal(keys[order[i-1]], keys[idx]) {
+			return nil, fmt.Errorf("row %d: duplicate primary key in the batch: %w", idx, ErrKeyExists)
+		}
+		sorted[i] = rows[idx]
+	}
+	return sorted, nil
+}
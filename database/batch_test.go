@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func userRecord(id int64, name string) Record {
+	return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).AddStr("email", []byte(name+"@example.com"))
+}
+
+func TestWriteBatch(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 3; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	// out of order, across tables, mixed with other writes
+	batch := db.WriteBatch()
+	for _, id := range []int64{9, 5, 7} {
+		batch.Insert("users", userRecord(id, "batch"))
+	}
+	for i, email := range []string{"c@x", "a@x", "b@x"} {
+		batch.Insert("people", *(&Record{}).AddInt64("id", int64(i+1)).AddStr("name", []byte("batch")).AddStr("email", []byte(email)))
+	}
+	batch.Update("users", userRecord(5, "updated"))
+	batch.Delete("users", *(&Record{}).AddInt64("id", 1))
+	batch.Insert("users", userRecord(1, "again"))
+	if batch.Len() != 9 {
+		t.Fatalf("expected 9 writes, got %d", batch.Len())
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if batch.Len() != 0 {
+		t.Errorf("expected the batch to be emptied by the commit")
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	sc, err := db.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &reader.Tree); !equalIDs(got, []int64{1, 2, 3, 5, 7, 9}) {
+		t.Errorf("unexpected users %v", got)
+	}
+	for id, name := range map[int64]string{1: "again", 5: "updated", 7: "batch"} {
+		rec := (&Record{}).AddInt64("id", id)
+		if ok, err := db.Get("users", rec, &reader); !ok || err != nil || string(rec.Get("name").Str) != name {
+			t.Errorf("user %d: expected %q, got %v %v %v", id, name, ok, err, rec)
+		}
+	}
+	sc = &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddStr("email", []byte("a@x")),
+		Key2: *(&Record{}).AddStr("email", []byte("z@x")),
+	}
+	if err := db.Scan("people", sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &reader.Tree); !equalIDs(got, []int64{2, 3, 1}) {
+		t.Errorf("expected the index to be updated, got %v", got)
+	}
+	db.kv.EndRead(&reader)
+
+	// a failed batch leaves nothing behind
+	for name, fill := range map[string]func(*WriteBatch){
+		"existing key": func(b *WriteBatch) {
+			b.Insert("users", userRecord(100, "new"))
+			b.Insert("users", userRecord(2, "dup"))
+		},
+		"duplicate in the batch": func(b *WriteBatch) {
+			b.Insert("users", userRecord(100, "new"))
+			b.Insert("users", userRecord(100, "dup"))
+		},
+		"missing table": func(b *WriteBatch) {
+			b.Insert("users", userRecord(100, "new"))
+			b.Delete("users", *(&Record{}).AddInt64("id", 2))
+			b.Insert("nope", userRecord(1, "x"))
+		},
+	} {
+		batch := db.WriteBatch()
+		fill(batch)
+		err := batch.Commit()
+		if err == nil {
+			t.Errorf("%s: expected the commit to fail", name)
+		} else if name != "missing table" && !errors.Is(err, ErrKeyExists) {
+			t.Errorf("%s: expected ErrKeyExists, got %v", name, err)
+		} else if name == "missing table" && !strings.Contains(err.Error(), "table not found") {
+			t.Errorf("%s: expected table not found, got %v", name, err)
+		}
+		db.kv.BeginRead(&reader)
+		sc, _ := db.ScanAll("users", &reader.Tree)
+		if got := scanIDs(sc, &reader.Tree); !equalIDs(got, []int64{1, 2, 3, 5, 7, 9}) {
+			t.Errorf("%s: expected no change, got %v", name, got)
+		}
+		db.kv.EndRead(&reader)
+	}
+}
+
+// the cost per row, with an fsync per commit
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, size := range []int{1, 100, 1000} {
+		b.Run(fmt.Sprintf("rows per commit %d", size), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "batch.db")
+			db := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+			if err := db.kv.Open(); err != nil {
+				b.Fatalf("open: %v", err)
+			}
+			defer db.kv.Close()
+			if err := initializeInternalTables(db); err != nil {
+				b.Fatalf("init: %v", err)
+			}
+			var tx DBTX
+			db.Begin(&tx)
+			err := tx.TableNew(&TableDef{
+				Name:    "people",
+				Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+				Cols:    []string{"id", "name", "email"},
+				PKeys:   1,
+				Indexes: [][]string{{"email"}},
+			})
+			if err == nil {
+				err = db.Commit(&tx)
+			}
+			if err != nil {
+				b.Fatalf("create: %v", err)
+			}
+			b.ResetTimer()
+			batch := db.WriteBatch()
+			for i := 0; i < b.N; i++ {
+				id := int64(i ^ 0x2aaa) // unique, not in key order
+				email := fmt.Sprintf("user%d@example.com", id)
+				batch.Insert("people", *(&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email)))
+				if batch.Len() == size || i == b.N-1 {
+					if err := batch.Commit(); err != nil {
+						b.Fatalf("commit: %v", err)
+					}
+				}
+			}
+		})
+	}
+}
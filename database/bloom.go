@@ -0,0 +1,300 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// a bloom filter of the primary keys of each table lets a Get of a missing
+// key return without a descent of the tree. the filter of a table is built
+// by the first Get of it, from the latest version under the writer lock, &
+// the commits add the keys they write to it. it never forgets a key: the
+// deletes are counted & the filter is built again once they are too many,
+// or once the table has outgrown it. Close writes the filters into meta
+// rows, so the next Open loads them instead of scanning the tables. the rows
+// are of their file, they are not copied by Compact nor sent to the replicas.
+
+const (
+	BLOOM_BITS_PER_KEY = 10 // about 1% of false positives
+	BLOOM_HASHES       = 7
+	BLOOM_MIN_KEYS     = 1024
+)
+
+type bloomFilter struct {
+	bits    []atomic.Uint64
+	k       uint32
+	cap     int64 // the keys it is sized for, twice the rows it was built with
+	adds    atomic.Int64
+	deletes atomic.Int64
+	built   uint64 // the version whose keys it has, with the commits after it
+	loaded  bool   // read from the meta row, not by a scan
+}
+
+// the filters of the tables by the table prefix, in the KV
+type bloomSet struct {
+	mu      sync.RWMutex
+	filters map[uint32]*bloomFilter
+}
+
+func newBloomFilter(rows int64) *bloomFilter {
+	capacity := int64(BLOOM_MIN_KEYS)
+	if 2*rows > capacity {
+		capacity = 2 * rows
+	}
+	words := (capacity*BLOOM_BITS_PER_KEY + 63) / 64
+	return &bloomFilter{bits: make([]atomic.Uint64, words), k: BLOOM_HASHES, cap: capacity}
+}
+
+// the bits of the key by double hashing
+func (f *bloomFilter) positions(key []byte, visit func(word int, mask uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	m := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if !visit(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key []byte) {
+	f.positions(key, func(word int, mask uint64) bool {
+		f.bits[word].Or(mask)
+		return true
+	})
+}
+
+// false if the key was never added
+func (f *bloomFilter) has(key []byte) bool {
+	return f.positions(key, func(word int, mask uint64) bool {
+		return f.bits[word].Load()&mask != 0
+	})
+}
+
+// built again by the next Get
+func (f *bloomFilter) stale() bool {
+	return f.adds.Load() > f.cap || f.deletes.Load() > f.cap/2
+}
+
+func (s *bloomSet) get(prefix uint32) *bloomFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filters[prefix]
+}
+
+func (s *bloomSet) set(prefix uint32, f *bloomFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.filters == nil {
+		s.filters = map[uint32]*bloomFilter{}
+	}
+	if f == nil {
+		delete(s.filters, prefix)
+	} else {
+		s.filters[prefix] = f
+	}
+}
+
+// a key written by a commit, under the writer lock before it is visible.
+// a filter that became stale is dropped.
+func (s *bloomSet) written(key []byte, deleted bool) {
+	if len(key) < 4 {
+		return
+	}
+	prefix := binary.BigEndian.Uint32(key)
+	f := s.get(prefix)
+	if f == nil {
+		return
+	}
+	if deleted {
+		f.deletes.Add(1)
+	} else {
+		f.add(key)
+		f.adds.Add(1)
+	}
+	if f.stale() {
+		s.set(prefix, nil)
+	}
+}
+
+// the row of the key is missing from the snapshot for sure. a tree with
+// writes of its own, or a snapshot older than the filter, is not filtered.
+func bloomAbsent(db *DB, tdef *TableDef, rec Record, reader *KVReader) bool {
+	if db.kv.NoBloom || tdef.internal() || reader.Tree.version != 0 {
+		return false
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return false // the error is for the lookup
+	}
+	f := db.kv.blooms.get(tdef.Prefix)
+	if f == nil {
+		if f, err = bloomBuild(db, tdef); err != nil {
+			db.logger().Warn("bloom filter not built", "table", tdef.Name, "err", err)
+			return false
+		}
+	}
+	if versionBefore(reader.version, f.built) {
+		return false
+	}
+	return !f.has(encodeKey(nil, tdef.Prefix, values[:tdef.PKeys]))
+}
+
+// the filter of the table from its meta row, or from a scan of the latest
+// version, under the writer lock so no commit is missed
+func bloomBuild(db *DB, tdef *TableDef) (*bloomFilter, error) {
+	kv := &db.kv
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+	if f := kv.blooms.get(tdef.Prefix); f != nil {
+		return f, nil // by another reader
+	}
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+	var f *bloomFilter
+	if !kv.Replica {
+		// its versions are not those of the leader's filters
+		var err error
+		if f, err = bloomLoad(&reader.Tree, tdef, reader.version); err != nil {
+			return nil, err
+		}
+	}
+	if f == nil {
+		rows, err := rowCountGet(db, tdef, &reader.Tree)
+		if err != nil {
+			return nil, err
+		}
+		f = newBloomFilter(rows)
+		prefixScan(&reader.Tree, binary.BigEndian.AppendUint32(nil, tdef.Prefix), func(key []byte) bool {
+			f.add(key)
+			return true
+		})
+		f.built = reader.version
+	}
+	kv.blooms.set(tdef.Prefix, f)
+	return f, nil
+}
+
+// the meta row of the filter of a table
+func bloomKey(prefix uint32) []byte {
+	return bloomMetaKey(fmt.Sprintf("bloom_%d", prefix))
+}
+
+func bloomMetaKey(name string) []byte {
+	return encodeKey(nil, TDEF_META.Prefix, []Value{{Type: TYPE_BYTES, Str: []byte(name)}})
+}
+
+// the meta row of the generation of the filters, written with them. a
+// filter of another generation is not loaded, the versions of two files
+// repeat each other.
+var BLOOM_GEN_KEY = bloomMetaKey("bloom_gen")
+
+// the keys of the meta rows of the filters start with it, without the
+// terminator of the name
+var bloomRowPrefix = bytes.TrimSuffix(bloomMetaKey("bloom_"), []byte{0})
+
+// a meta row of the filters, see BLOOM_GEN_KEY
+func bloomRow(key []byte) bool {
+	return bytes.HasPrefix(key, bloomRowPrefix)
+}
+
+// | version | gen | cap | adds | deletes | k  | bits       |
+// | 8B      | 8B  | 8B  | 8B   | 8B      | 4B | 8B * words |
+const BLOOM_HEADER = 44
+
+func bloomEncode(f *bloomFilter, version, gen uint64) []byte {
+	buf := make([]byte, 0, BLOOM_HEADER+8*len(f.bits))
+	buf = binary.LittleEndian.AppendUint64(buf, version)
+	buf = binary.LittleEndian.AppendUint64(buf, gen)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(f.cap))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(f.adds.Load()))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(f.deletes.Load()))
+	buf = binary.LittleEndian.AppendUint32(buf, f.k)
+	for i := range f.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, f.bits[i].Load())
+	}
+	return encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: buf}})
+}
+
+// the filter written for this version, nil if there is none or it is of
+// another version or generation
+func bloomLoad(tree *BTree, tdef *TableDef, version uint64) (*bloomFilter, error) {
+	gen, ok, err := tree.Get(BLOOM_GEN_KEY)
+	if err != nil || !ok {
+		return nil, err
+	}
+	val, ok, err := tree.Get(bloomKey(tdef.Prefix))
+	if err != nil || !ok {
+		return nil, err
+	}
+	out := []Value{{Type: TYPE_BYTES}, {Type: TYPE_BYTES}}
+	if decodeValues(gen, out[1:]) != 1 || len(out[1].Str) != 8 || decodeValues(val, out[:1]) != 1 {
+		return nil, fmt.Errorf("corrupted bloom filter of %s", tdef.Name)
+	}
+	buf := out[0].Str
+	if len(buf) < BLOOM_HEADER || (len(buf)-BLOOM_HEADER)%8 != 0 {
+		return nil, fmt.Errorf("corrupted bloom filter of %s", tdef.Name)
+	}
+	if binary.LittleEndian.Uint64(buf) != version || !bytes.Equal(buf[8:16], out[1].Str) {
+		return nil, nil
+	}
+	f := &bloomFilter{
+		bits:   make([]atomic.Uint64, (len(buf)-BLOOM_HEADER)/8),
+		cap:    int64(binary.LittleEndian.Uint64(buf[16:])),
+		k:      binary.LittleEndian.Uint32(buf[40:]),
+		built:  version,
+		loaded: true,
+	}
+	f.adds.Store(int64(binary.LittleEndian.Uint64(buf[24:])))
+	f.deletes.Store(int64(binary.LittleEndian.Uint64(buf[32:])))
+	for i := range f.bits {
+		f.bits[i].Store(binary.LittleEndian.Uint64(buf[BLOOM_HEADER+8*i:]))
+	}
+	if len(f.bits) == 0 || f.k == 0 {
+		return nil, fmt.Errorf("corrupted bloom filter of %s", tdef.Name)
+	}
+	return f, nil
+}
+
+// write the filters into their meta rows in one commit, for the version the
+// commit makes. called by Close once the transactions ended.
+func bloomPersist(db *DB) error {
+	kv := &db.kv
+	if kv.NoBloom || kv.ReadOnly || kv.Replica || kv.inMemory() {
+		return nil
+	}
+	kv.blooms.mu.RLock()
+	filters := make(map[uint32]*bloomFilter, len(kv.blooms.filters))
+	for prefix, f := range kv.blooms.filters {
+		filters[prefix] = f
+	}
+	kv.blooms.mu.RUnlock()
+	if len(filters) == 0 {
+		return nil
+	}
+	var gen [8]byte
+	if _, err := rand.Read(gen[:]); err != nil {
+		return err
+	}
+	var tx KVTX
+	kv.Begin(&tx)
+	version := tx.version + 1
+	tx.Update(&InsertReq{Key: BLOOM_GEN_KEY, Value: encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: gen[:]}})})
+	for prefix, f := range filters {
+		if _, ok, _ := tx.Tree.Get(rowCountKey(&TableDef{Prefix: prefix})); !ok {
+			continue // dropped
+		}
+		tx.Update(&InsertReq{Key: bloomKey(prefix), Value: bloomEncode(f, version, binary.LittleEndian.Uint64(gen[:]))})
+	}
+	return kv.Commit(&tx)
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func openBloomDB(t testing.TB, path string, opts ...Option) *DB {
+	db, err := Open(path, opts...)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return db
+}
+
+func insertBloomRows(t testing.TB, db *DB, from, to int64) {
+	err := db.Transact(func(tx *DBTX) error {
+		if _, err := tx.Describe("items"); err != nil {
+			err = tx.TableNew(&TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "qty"}, PKeys: 1})
+			if err != nil {
+				return err
+			}
+		}
+		for i := from; i < to; i++ {
+			if _, err := tx.Set("items", *(&Record{}).AddInt64("id", i).AddInt64("qty", i), MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+}
+
+func bloomGet(t testing.TB, db *DB, id int64) bool {
+	var found bool
+	err := db.View(func(tx *DBReader) (err error) {
+		found, err = tx.Get("items", (&Record{}).AddInt64("id", id))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("get %d: %v", id, err)
+	}
+	return found
+}
+
+func itemsDef(t testing.TB, db *DB) *TableDef {
+	var tdef *TableDef
+	db.View(func(tx *DBReader) (err error) {
+		tdef, err = tx.Describe("items")
+		return err
+	})
+	if tdef == nil {
+		t.Fatalf("no table")
+	}
+	return tdef
+}
+
+func itemsFilter(t testing.TB, db *DB) *bloomFilter {
+	return db.kv.blooms.get(itemsDef(t, db).Prefix)
+}
+
+func TestBloomFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bloom.db")
+	db := openBloomDB(t, path)
+	insertBloomRows(t, db, 0, 1000)
+	for i := int64(0); i < 2000; i++ {
+		if got := bloomGet(t, db, i); got != (i < 1000) {
+			t.Fatalf("get %d: expected %v, got %v", i, i < 1000, got)
+		}
+	}
+	f := itemsFilter(t, db)
+	if f == nil || f.loaded {
+		t.Fatalf("expected the filter built by a scan, got %+v", f)
+	}
+
+	// the keys of the commits are added, a transaction sees its own
+	insertBloomRows(t, db, 1000, 1100)
+	if !bloomGet(t, db, 1050) {
+		t.Errorf("expected the committed row")
+	}
+	err := db.Transact(func(tx *DBTX) error {
+		if _, err := tx.Set("items", *(&Record{}).AddInt64("id", 5000).AddInt64("qty", 0), MODE_INSERT_ONLY); err != nil {
+			return err
+		}
+		if ok, err := tx.Get("items", (&Record{}).AddInt64("id", 5000)); !ok || err != nil {
+			t.Errorf("expected the row of the transaction, got %v %v", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transact: %v", err)
+	}
+	if itemsFilter(t, db) != f {
+		t.Errorf("expected the same filter")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// the next open loads the filter
+	db = openBloomDB(t, path)
+	if bloomGet(t, db, 1500) || !bloomGet(t, db, 5000) {
+		t.Errorf("unexpected rows after the reopen")
+	}
+	if f := itemsFilter(t, db); f == nil || !f.loaded {
+		t.Errorf("expected the filter loaded, got %+v", f)
+	}
+
+	// too many deletes drop the filter, the next Get builds it again
+	err = db.Transact(func(tx *DBTX) error {
+		for i := int64(0); i < 1100; i++ {
+			if _, err := tx.Delete("items", *(&Record{}).AddInt64("id", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if itemsFilter(t, db) != nil {
+		t.Errorf("expected the stale filter dropped")
+	}
+	if bloomGet(t, db, 10) || !bloomGet(t, db, 5000) {
+		t.Errorf("unexpected rows after the deletes")
+	}
+	if f := itemsFilter(t, db); f == nil || f.loaded {
+		t.Errorf("expected the filter built again, got %+v", f)
+	}
+	db.Close()
+
+	// an older filter is ignored
+	db = openBloomDB(t, path, WithBloomFilters(false))
+	insertBloomRows(t, db, 6000, 6010)
+	if !bloomGet(t, db, 6005) || itemsFilter(t, db) != nil {
+		t.Errorf("expected no filter")
+	}
+	db.Close()
+	db = openBloomDB(t, path)
+	defer db.Close()
+	if !bloomGet(t, db, 6005) {
+		t.Errorf("expected the row written without the filter")
+	}
+	if f := itemsFilter(t, db); f == nil || f.loaded {
+		t.Errorf("expected the filter built again, got %+v", f)
+	}
+}
+
+// 90% of the keys are missing
+func BenchmarkGetMissing(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		bloom bool
+	}{{"bloom", true}, {"nobloom", false}} {
+		b.Run(bench.name, func(b *testing.B) {
+			db := openBloomDB(b, filepath.Join(b.TempDir(), "bench.db"), WithBloomFilters(bench.bloom))
+			defer db.Close()
+			const rows = 100_000
+			insertBloomRows(b, db, 0, rows)
+			b.ResetTimer()
+			db.View(func(tx *DBReader) error {
+				rec := Record{}
+				for i := 0; i < b.N; i++ {
+					id := int64(i % rows)
+					if i%10 != 0 {
+						id += rows
+					}
+					rec.Cols, rec.Vals = rec.Cols[:0], rec.Vals[:0]
+					if _, err := tx.Get("items", rec.AddInt64("id", id)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+// the filters are of their file, a compacted file reaching the version of
+// a filter it copied does not load it
+func TestBloomFilterCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bloom.db")
+	db := openBloomDB(t, path)
+	insertBloomRows(t, db, 0, 1)
+	for i := 0; i < 60; i++ {
+		insertBloomRows(t, db, 100+int64(i), 101+int64(i))
+	}
+	bloomGet(t, db, 0)
+	tag := db.kv.version + 1 // of the commit of the filters
+	db.Close()
+
+	db = openBloomDB(t, path)
+	insertBloomRows(t, db, 2, 3)
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	db.View(func(tx *DBReader) error {
+		if _, ok, _ := tx.kv.Tree.Get(BLOOM_GEN_KEY); ok {
+			t.Errorf("expected the filters left out of the copy")
+		}
+		return nil
+	})
+	if db.kv.version >= tag {
+		t.Fatalf("expected the compacted file before version %d, got %d", tag, db.kv.version)
+	}
+	for db.kv.version < tag {
+		err := db.Transact(func(tx *DBTX) error {
+			if _, err := tx.Describe("other"); err != nil {
+				return tx.TableNew(&TableDef{Name: "other", Types: []uint32{TYPE_INT64}, Cols: []string{"id"}, PKeys: 1})
+			}
+			_, err := tx.Set("other", *(&Record{}).AddInt64("id", int64(db.kv.version)), MODE_UPSERT)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+	db.Close()
+
+	db = openBloomDB(t, path)
+	defer db.Close()
+	if !bloomGet(t, db, 2) {
+		t.Errorf("expected the row inserted before the compaction")
+	}
+	if err := bloomPersist(db); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+
+	// a filter of the version but of another generation is not loaded
+	tdef := itemsDef(t, db)
+	var tx KVTX
+	db.kv.Begin(&tx)
+	defer db.kv.Abort(&tx)
+	if f, err := bloomLoad(&tx.Tree, tdef, tx.version); f == nil || err != nil {
+		t.Fatalf("expected the filter loaded, got %v %v", f, err)
+	}
+	tx.Update(&InsertReq{Key: BLOOM_GEN_KEY, Value: encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: make([]byte, 8)}})})
+	if f, err := bloomLoad(&tx.Tree, tdef, tx.version); f != nil || err != nil {
+		t.Errorf("expected no filter of another generation, got %v %v", f, err)
+	}
+}
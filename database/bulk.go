@@ -0,0 +1,230 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrKeyExists error = errors.New("key already exists")
+
+// a KV pair or a child pointer waiting to be packed into a node
+type bulkEntry struct {
+	ptr      uint64
+	key      []byte
+	val      []byte
+	overflow bool // the val is an overflow stub
+}
+
+// insert a batch of keys sorted in ascending order.
+// each node on the way is visited once for the whole batch instead of once
+// per key, the leaves are rebuilt & packed full.
+// fails without touching the tree if a key exists or the input is not sorted.
+func (tree *BTree) BulkLoad(keys, vals [][]byte) error {
+	if len(keys) != len(vals) {
+		return errors.New("length of keys & vals do not match")
+	}
+	for i, key := range keys {
+		if len(key) == 0 || len(key) > BTREE_MAX_KEY_SIZE {
+			return fmt.Errorf("key %d: key size not valid", i)
+		}
+		if len(vals[i]) > BTREE_MAX_VAL_SIZE {
+			return fmt.Errorf("key %d: val size exceeds the max size", i)
+		}
+		if i > 0 {
+			switch cmp := bytes.Compare(keys[i-1], key); {
+			case cmp == 0:
+				return fmt.Errorf("key %d: duplicate key", i)
+			case cmp > 0:
+				return fmt.Errorf("key %d: keys are not sorted", i)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var root BNode
+	if tree.root == 0 {
+		root = BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		root.setHeader(BNODE_LEAF, 1)
+		// the dummy key, same as the first Insert
+		nodeAppendKV(root, 0, 0, nil, nil)
+	} else {
+		root = tree.get(tree.root)
+		if idx := bulkCheck(tree, root, keys); idx >= 0 {
+			return fmt.Errorf("key %d: %w", idx, ErrKeyExists)
+		}
+	}
+	tree.version++
+
+	nodes := treeBulkInsert(tree, root, keys, vals)
+	if tree.root != 0 {
+		tree.del(tree.root)
+	}
+	// grow new levels until there is a single root
+	for len(nodes) > 1 {
+		nodes = nodePack(BNODE_INODE, bulkKids(tree, nodes))
+	}
+	tree.root = tree.new(nodes[0])
+	return nil
+}
+
+// the end of the keys that belong to the idx-th kid of an internal node
+func bulkPartition(node BNode, idx uint16, keys [][]byte) int {
+	if idx+1 == node.nKeys() {
+		return len(keys)
+	}
+	next := node.getKey(idx + 1)
+	return sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], next) >= 0
+	})
+}
+
+// returns the index of the first key that is already in the tree, or -1
+func bulkCheck(tree *BTree, node BNode, keys [][]byte) int {
+	switch node.bNodeType() {
+	case BNODE_LEAF:
+		i := uint16(0)
+		for j, key := range keys {
+			for i < node.nKeys() && bytes.Compare(node.getKey(i), key) < 0 {
+				i++
+			}
+			if i < node.nKeys() && bytes.Equal(node.getKey(i), key) {
+				return j
+			}
+		}
+		return -1
+	case BNODE_INODE:
+		start := 0
+		for i := nodeLookupLE(node, keys[0]); start < len(keys); i++ {
+			end := start + bulkPartition(node, i, keys[start:])
+			if end > start {
+				if idx := bulkCheck(tree, tree.get(node.getPtr(i)), keys[start:end]); idx >= 0 {
+					return start + idx
+				}
+			}
+			start = end
+		}
+		return -1
+	default:
+		panic("bad node!!")
+	}
+}
+
+// merge the keys into the node, returns the nodes replacing it
+func treeBulkInsert(tree *BTree, node BNode, keys, vals [][]byte) []BNode {
+	n := node.nKeys()
+	switch node.bNodeType() {
+	case BNODE_LEAF:
+		merged := make([]bulkEntry, 0, int(n)+len(keys))
+		i := uint16(0)
+		for j, key := range keys {
+			for ; i < n && bytes.Compare(node.getKey(i), key) < 0; i++ {
+				merged = append(merged, leafEntry(node, i))
+			}
+			val, overflow := tree.storeVal(vals[j])
+			merged = append(merged, bulkEntry{key: key, val: val, overflow: overflow})
+		}
+		for ; i < n; i++ {
+			merged = append(merged, leafEntry(node, i))
+		}
+		return nodePack(BNODE_LEAF, merged)
+	case BNODE_INODE:
+		kids := make([]bulkEntry, 0, n)
+		start := 0
+		for i := uint16(0); i < n; i++ {
+			end := start + bulkPartition(node, i, keys[start:])
+			kptr := node.getPtr(i)
+			if end == start {
+				kids = append(kids, bulkEntry{ptr: kptr, key: node.getKey(i)})
+				continue
+			}
+			updated := treeBulkInsert(tree, tree.get(kptr), keys[start:end], vals[start:end])
+			tree.del(kptr)
+			kids = append(kids, bulkKids(tree, updated)...)
+			start = end
+		}
+		return nodePack(BNODE_INODE, kids)
+	default:
+		panic("bad node!!")
+	}
+}
+
+func leafEntry(node BNode, idx uint16) bulkEntry {
+	return bulkEntry{key: node.getKey(idx), val: node.getVal(idx), overflow: node.isOverflow(idx)}
+}
+
+// allocate the nodes & return pointers to them
+func bulkKids(tree *BTree, nodes []BNode) []bulkEntry {
+	kids := make([]bulkEntry, len(nodes))
+	for i, node := range nodes {
+		kids[i] = bulkEntry{ptr: tree.new(node), key: node.getKey(0)}
+	}
+	return kids
+}
+
+// pack the entries into as few pages as possible. the last two share their
+// entries evenly, so no node but a single one is left nearly empty.
+func nodePack(btype uint16, entries []bulkEntry) []BNode {
+	var nodes []BNode
+	for len(entries) > 0 {
+		n := bulkFit(entries)
+		if n < len(entries) && bulkFit(entries[n:]) == len(entries)-n {
+			n = bulkSplit(entries)
+		}
+		node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		node.setHeader(btype, uint16(n))
+		for i, e := range entries[:n] {
+			nodeAppendKV(node, uint16(i), e.ptr, e.key, e.val)
+			if e.overflow {
+				node.setOverflow(uint16(i))
+			}
+		}
+		nodes = append(nodes, node)
+		entries = entries[n:]
+	}
+	return nodes
+}
+
+func bulkEntrySize(e bulkEntry) int {
+	return 8 + 2 + 4 + len(e.key) + len(e.val)
+}
+
+// the number of the leading entries that fit in a node
+func bulkFit(entries []bulkEntry) int {
+	n, size := 0, HEADER
+	for n < len(entries) {
+		size += bulkEntrySize(entries[n])
+		if size > BTREE_NODE_SIZE {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// the entries of the first of two nodes holding them all, the two closest in size
+func bulkSplit(entries []bulkEntry) int {
+	total := 0
+	for _, e := range entries {
+		total += bulkEntrySize(e)
+	}
+	best, diff := 0, total
+	left := 0
+	for n := 1; n < len(entries); n++ {
+		left += bulkEntrySize(entries[n-1])
+		right := total - left
+		if HEADER+left > BTREE_NODE_SIZE {
+			break
+		}
+		if HEADER+right > BTREE_NODE_SIZE {
+			continue
+		}
+		if d := max(left-right, right-left); d < diff {
+			best, diff = n, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+)
+
+// an LRU of the pages read from the file, keyed by the pointer. a cached page
+// skips the lookup & the checksum. the pages of a commit are dropped from it,
+// a reused page is rewritten in place or replaced in memory.
+// the size is counted in bytes of pages, KV.CacheSize.
+type pageCache struct {
+	mu     sync.Mutex
+	budget int
+	size   int
+	lru    *list.List // the front is the most recently used
+	pages  map[uint64]*list.Element
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	ptr  uint64
+	node BNode
+}
+
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Pages  int
+	Bytes  int // the size of the cached pages
+}
+
+// nil for no cache, the methods of a nil cache do nothing
+func newPageCache(budget int) *pageCache {
+	if budget < BTREE_PAGE_SIZE {
+		return nil
+	}
+	return &pageCache{budget: budget, lru: list.New(), pages: map[uint64]*list.Element{}}
+}
+
+func (c *pageCache) get(ptr uint64) (BNode, bool) {
+	if c == nil {
+		return BNode{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.pages[ptr]
+	if !ok {
+		c.misses++
+		return BNode{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).node, true
+}
+
+func (c *pageCache) add(ptr uint64, node BNode) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pages[ptr]; ok {
+		return // added by another reader
+	}
+	c.pages[ptr] = c.lru.PushFront(&cacheEntry{ptr: ptr, node: node})
+	c.size += len(node.data)
+	for c.size > c.budget {
+		c.remove(c.lru.Back())
+	}
+}
+
+// drop the pages written or freed by a commit
+func (c *pageCache) invalidate(updates map[uint64][]byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ptr := range updates {
+		if elem, ok := c.pages[ptr]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *pageCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.pages, entry.ptr)
+	c.size -= len(entry.node.data)
+}
+
+func (c *pageCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Pages: c.lru.Len(), Bytes: c.size}
+}
+
+// the counters of the page cache, zero without a cache
+func (db *KV) CacheStats() CacheStats {
+	return db.cache.stats()
+}
+
+func (db *DB) CacheStats() CacheStats {
+	return db.kv.CacheStats()
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageCacheLRU(t *testing.T) {
+	c := newPageCache(2 * BTREE_PAGE_SIZE)
+	page := func() BNode { return BNode{make([]byte, BTREE_PAGE_SIZE)} }
+	c.add(1, page())
+	c.add(2, page())
+	if _, ok := c.get(1); !ok {
+		t.Fatalf("expected page 1 to be cached")
+	}
+	c.add(3, page()) // evicts 2, the least recently used
+	if _, ok := c.get(2); ok {
+		t.Errorf("expected page 2 to be evicted")
+	}
+	c.invalidate(map[uint64][]byte{3: nil})
+	if _, ok := c.get(3); ok {
+		t.Errorf("expected page 3 to be invalidated")
+	}
+	if stats := c.stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Pages != 1 || stats.Bytes != BTREE_PAGE_SIZE {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if newPageCache(0) != nil {
+		t.Errorf("expected no cache for a zero size")
+	}
+}
+
+func TestPageCache(t *testing.T) {
+	for name, path := range map[string]string{
+		"file":   filepath.Join(t.TempDir(), "cache.db"),
+		"memory": MEMORY_PATH,
+	} {
+		t.Run(name, func(t *testing.T) {
+			kv := KV{Path: path, CacheSize: 16 * BTREE_PAGE_SIZE}
+			if err := kv.Open(); err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer kv.Close()
+			// the freed pages are reused by the later commits while cached
+			for n := 0; n < 20; n++ {
+				walCommit(t, &kv, n)
+				walCheck(t, &kv, n)
+			}
+			stats := kv.CacheStats()
+			if stats.Hits == 0 || stats.Misses == 0 {
+				t.Errorf("expected hits & misses, got %+v", stats)
+			}
+			if stats.Bytes > kv.CacheSize || stats.Bytes != stats.Pages*BTREE_PAGE_SIZE {
+				t.Errorf("expected the cache within its size, got %+v", stats)
+			}
+		})
+	}
+}
+
+const benchCacheKeys = 100_000
+
+func benchCacheKV(b *testing.B, cacheSize int) *KV {
+	kv := &KV{Path: filepath.Join(b.TempDir(), "bench.db"), CacheSize: cacheSize}
+	if err := kv.Open(); err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	var tx KVTX
+	kv.Begin(&tx)
+	val := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < benchCacheKeys; i++ {
+		if err := tx.Set(benchKey(i), val); err != nil {
+			b.Fatalf("set: %v", err)
+		}
+	}
+	if err := kv.Commit(&tx); err != nil {
+		b.Fatalf("commit: %v", err)
+	}
+	return kv
+}
+
+// point lookups on the first `keys` keys, with a 1MB cache
+func BenchmarkCacheGet(b *testing.B) {
+	for _, bc := range []struct {
+		name      string
+		cacheSize int
+		keys      int
+	}{
+		{"no cache", 0, benchCacheKeys},
+		{"smaller than the cache", 1 << 20, 2000},
+		{"larger than the cache", 1 << 20, benchCacheKeys},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			kv := benchCacheKV(b, bc.cacheSize)
+			defer kv.Close()
+			var reader KVReader
+			kv.BeginRead(&reader)
+			defer kv.EndRead(&reader)
+			before := kv.CacheStats()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := benchKey((i * 7919) % bc.keys)
+				if _, ok, _ := reader.Tree.Get(key); !ok {
+					b.Fatalf("key %q not found", key)
+				}
+			}
+			b.StopTimer()
+			stats := kv.CacheStats()
+			if lookups := stats.Hits + stats.Misses - before.Hits - before.Misses; lookups > 0 {
+				b.ReportMetric(float64(stats.Hits-before.Hits)/float64(lookups), "hit-rate")
+			}
+		})
+	}
+}
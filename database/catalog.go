@@ -0,0 +1,107 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the names of the tables in the catalog in name order, without the
+// internal tables
+//
+// Deprecated: use DBTX.ListTables or DBReader.ListTables.
+func (db *DB) ListTables(tree *BTree) ([]string, error) {
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, TDEF_TABLE, &sc, tree)
+	var names []string
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, tree); err != nil {
+			return nil, err
+		}
+		name := string(rec.Get("name").Str)
+		if name == TDEF_META.Name || name == TDEF_TABLE.Name {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// a copy of the definition of the table
+//
+// Deprecated: use DBTX.Describe or DBReader.Describe.
+func (db *DB) Describe(name string, tree *BTree) (*TableDef, error) {
+	tdef := GetTableDef(db, name, tree)
+	if tdef == nil {
+		return nil, tableNotFound(name)
+	}
+	def := *tdef
+	return &def, nil
+}
+
+func typeName(typ uint32) string {
+	switch typ {
+	case TYPE_INT64:
+		return "int64"
+	case TYPE_BYTES:
+		return "bytes"
+	case TYPE_INT32:
+		return "int32"
+	case TYPE_FLOAT64:
+		return "float64"
+	case TYPE_BOOL:
+		return "bool"
+	case TYPE_TIME:
+		return "time"
+	default:
+		return fmt.Sprintf("type %d", typ)
+	}
+}
+
+// the lines of DESC: the columns aligned, then the indexes & the foreign keys
+func describeLines(tdef *TableDef) []string {
+	nameWidth, typeWidth := len("Column"), len("Type")
+	for i, col := range tdef.Cols {
+		nameWidth = max(nameWidth, len(col))
+		typeWidth = max(typeWidth, len(typeName(tdef.Types[i])))
+	}
+	lines := []string{
+		fmt.Sprintf("Table %s, prefix %d", tdef.Name, tdef.Prefix),
+		fmt.Sprintf("%-*s  %-*s  %-3s  %-8s  %s", nameWidth, "Column", typeWidth, "Type", "Key", "Null", "Default"),
+	}
+	for i, col := range tdef.Cols {
+		key, null, def := "", "NULL", ""
+		if i < tdef.PKeys {
+			key = "PK"
+		}
+		if i < tdef.PKeys || (i < len(tdef.NotNull) && tdef.NotNull[i]) {
+			null = "NOT NULL"
+		}
+		if i < tdef.PKeys && tdef.AutoIncrement {
+			def = "auto increment"
+		} else if i < len(tdef.Defaults) && tdef.Defaults[i].Type != 0 {
+			def = formatValue(tdef.Defaults[i])
+		}
+		line := fmt.Sprintf("%-*s  %-*s  %-3s  %-8s  %s", nameWidth, col, typeWidth, typeName(tdef.Types[i]), key, null, def)
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	for i, index := range tdef.Indexes {
+		kind := "Index"
+		if indexUnique(tdef, i) {
+			kind = "Unique index"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s), prefix %d", kind, strings.Join(index, ","), tdef.IndexPrefix[i]))
+	}
+	for _, fk := range tdef.ForeignKeys {
+		onDelete := "RESTRICT"
+		if fk.OnDelete == FK_CASCADE {
+			onDelete = "CASCADE"
+		}
+		lines = append(lines, fmt.Sprintf("Foreign key (%s) references %s(%s) on delete %s",
+			strings.Join(fk.Cols, ","), fk.Parent, strings.Join(fk.RefCols, ","), onDelete))
+	}
+	if len(tdef.Referenced) > 0 {
+		lines = append(lines, "Referenced by "+strings.Join(tdef.Referenced, ", "))
+	}
+	return lines
+}
@@ -0,0 +1,339 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListTables(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	write := func(fn func() error) {
+		t.Helper()
+		db.Begin(&tx)
+		if err := fn(); err != nil {
+			db.Abort(&tx)
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+	tables := func() []string {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		names, err := db.ListTables(&reader.kv.Tree)
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		return names
+	}
+	if names := tables(); len(names) != 0 {
+		t.Errorf("expected no tables, got %v", names)
+	}
+
+	write(func() error {
+		return tx.TableNew(&TableDef{
+			Name:          "customers",
+			Types:         []uint32{TYPE_INT64, TYPE_BYTES, TYPE_FLOAT64},
+			Cols:          []string{"id", "email", "balance"},
+			PKeys:         1,
+			Indexes:       [][]string{{"email"}},
+			Unique:        []bool{true},
+			NotNull:       []bool{false, true, false},
+			Defaults:      []Value{{}, {}, {Type: TYPE_FLOAT64, F64: 0.5}},
+			AutoIncrement: true,
+		})
+	})
+	write(func() error {
+		return tx.TableNew(&TableDef{
+			Name:        "orders",
+			Types:       []uint32{TYPE_INT64, TYPE_INT64, TYPE_TIME},
+			Cols:        []string{"id", "customer", "at"},
+			PKeys:       1,
+			Indexes:     [][]string{{"customer"}},
+			ForeignKeys: []ForeignKey{{Cols: []string{"customer"}, Parent: "customers", RefCols: []string{"id"}, OnDelete: FK_CASCADE}},
+		})
+	})
+	if names := tables(); !slices.Equal(names, []string{"customers", "orders"}) {
+		t.Errorf("expected [customers orders], got %v", names)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	tdef, err := db.Describe("customers", &reader.kv.Tree)
+	if err != nil {
+		t.Fatalf("failed to describe: %v", err)
+	}
+	expected := []string{
+		"Table customers, prefix 3",
+		"Column   Type     Key  Null      Default",
+		"id       int64    PK   NOT NULL  auto increment",
+		"email    bytes         NOT NULL",
+		"balance  float64       NULL      0.5",
+		"Unique index (email,id), prefix 4",
+		"Referenced by orders",
+	}
+	if got := describeLines(tdef); !slices.Equal(got, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, got)
+	}
+	tdef, _ = db.Describe("orders", &reader.kv.Tree)
+	if got := describeLines(tdef); got[len(got)-1] != "Foreign key (customer) references customers(id) on delete CASCADE" {
+		t.Errorf("unexpected foreign key line %q", got[len(got)-1])
+	}
+	if _, err := db.Describe("missing", &reader.kv.Tree); err == nil {
+		t.Errorf("expected an error for a missing table")
+	}
+	db.EndRead(&reader)
+
+	write(func() error { return tx.DropTable("orders") })
+	if names := tables(); !slices.Equal(names, []string{"customers"}) {
+		t.Errorf("expected [customers] after the drop, got %v", names)
+	}
+}
+
+func TestRename(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	write := func(fn func() error) error {
+		t.Helper()
+		db.Begin(&tx)
+		if err := fn(); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+	err := write(func() error {
+		err := tx.TableNew(&TableDef{
+			Name:    "customers",
+			Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+			Cols:    []string{"id", "email", "name"},
+			PKeys:   1,
+			Indexes: [][]string{{"email"}},
+			Unique:  []bool{true},
+		})
+		if err == nil {
+			err = tx.TableNew(&TableDef{
+				Name:        "orders",
+				Types:       []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+				Cols:        []string{"id", "customer", "note"},
+				PKeys:       1,
+				Indexes:     [][]string{{"customer"}},
+				ForeignKeys: []ForeignKey{{Cols: []string{"customer"}, Parent: "customers", RefCols: []string{"id"}}},
+			})
+		}
+		if err == nil {
+			_, err = tx.Set("customers", *(&Record{}).AddInt64("id", 1).AddStr("email", []byte("a@x")).AddStr("name", []byte("a")), MODE_INSERT_ONLY)
+		}
+		if err == nil {
+			_, err = tx.Set("orders", *(&Record{}).AddInt64("id", 1).AddInt64("customer", 1).AddStr("note", []byte("o")), MODE_INSERT_ONLY)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	// the rows of the index scan, the definitions are cached by the first one
+	scan := func(table string, key Record) ([]string, error) {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+		if err := reader.Scan(table, &sc); err != nil {
+			return nil, err
+		}
+		var rows []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			rows = append(rows, formatRecord(rec))
+		}
+		return rows, nil
+	}
+	email := *(&Record{}).AddStr("email", []byte("a@x"))
+	if _, err := scan("customers", email); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	if err := write(func() error { return tx.RenameTable("customers", "clients") }); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if _, err := scan("customers", email); err == nil {
+		t.Errorf("expected the old name to be gone")
+	}
+	if rows, err := scan("clients", email); err != nil || !slices.Equal(rows, []string{`id=1 email="a@x" name="a"`}) {
+		t.Errorf("unexpected rows %v %v", rows, err)
+	}
+	if err := write(func() error { return tx.RenameTable("clients", "orders") }); !errors.Is(err, ErrTableAlreadyExists) {
+		t.Errorf("expected ErrTableAlreadyExists, got %v", err)
+	}
+
+	if err := write(func() error { return tx.RenameColumn("clients", "id", "cid") }); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if err := write(func() error { return tx.RenameColumn("orders", "customer", "client") }); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if rows, err := scan("clients", email); err != nil || !slices.Equal(rows, []string{`cid=1 email="a@x" name="a"`}) {
+		t.Errorf("unexpected rows %v %v", rows, err)
+	}
+	if rows, err := scan("orders", *(&Record{}).AddInt64("client", 1)); err != nil || !slices.Equal(rows, []string{`id=1 client=1 note="o"`}) {
+		t.Errorf("unexpected rows %v %v", rows, err)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	names, _ := db.ListTables(&reader.kv.Tree)
+	clients := GetTableDef(db, "clients", &reader.kv.Tree)
+	orders := GetTableDef(db, "orders", &reader.kv.Tree)
+	db.EndRead(&reader)
+	if !slices.Equal(names, []string{"clients", "orders"}) {
+		t.Errorf("expected [clients orders], got %v", names)
+	}
+	if fk := orders.ForeignKeys[0]; fk.Parent != "clients" || !slices.Equal(fk.Cols, []string{"client"}) || !slices.Equal(fk.RefCols, []string{"cid"}) {
+		t.Errorf("unexpected foreign key %+v", fk)
+	}
+	if !slices.Equal(clients.Referenced, []string{"orders"}) || !slices.Equal(clients.Indexes[0], []string{"email", "cid"}) {
+		t.Errorf("unexpected definition %+v", clients)
+	}
+
+	// the foreign key is still checked
+	order := func(id, client int64) error {
+		_, err := tx.Set("orders", *(&Record{}).AddInt64("id", id).AddInt64("client", client).AddStr("note", []byte("o")), MODE_INSERT_ONLY)
+		return err
+	}
+	if err := write(func() error { return order(2, 9) }); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey, got %v", err)
+	}
+	if err := write(func() error { return order(2, 1) }); err != nil {
+		t.Errorf("failed to insert: %v", err)
+	}
+
+	for _, tt := range []struct {
+		fn   func() error
+		want string
+	}{
+		{func() error { return tx.RenameColumn("clients", "name", "email") }, "duplicate column name: email"},
+		{func() error { return tx.RenameColumn("clients", "phone", "tel") }, "column not found: phone"},
+		{func() error { return tx.RenameTable("missing", "other") }, "table not found: missing"},
+		{func() error { return tx.RenameTable("@meta", "meta") }, "cannot rename the internal table @meta"},
+	} {
+		if err := write(tt.fn); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected %q, got %v", tt.want, err)
+		}
+	}
+}
+
+func TestTableDefCache(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	err := db.Transact(func(tx *DBTX) error {
+		return tx.TableNew(&TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "name"}, PKeys: 1})
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	cols := func(tx *DBReader) int {
+		tdef, err := tx.Describe("items")
+		if err != nil {
+			t.Fatalf("describe: %v", err)
+		}
+		return len(tdef.Cols)
+	}
+	version := db.SchemaVersion()
+
+	// the definition read by a transaction after its own change is not the
+	// one of the others, nor of anyone once it is rolled back
+	var tx DBTX
+	db.Begin(&tx)
+	if err := tx.AddColumn("items", "qty", TYPE_INT64, Value{}); err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := tx.Set("items", *(&Record{}).AddInt64("id", 1).AddStr("name", []byte("a")).AddInt64("qty", 2), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	var old DBReader
+	db.BeginRead(&old)
+	if n := cols(&old); n != 2 {
+		t.Errorf("expected the 2 committed columns, got %d", n)
+	}
+	db.Abort(&tx)
+	err = db.View(func(tx *DBReader) error {
+		if n := cols(tx); n != 2 {
+			t.Errorf("expected the column rolled back, got %d columns", n)
+		}
+		return nil
+	})
+	if err != nil || db.SchemaVersion() != version {
+		t.Fatalf("expected the version %d after the rollback, got %d %v", version, db.SchemaVersion(), err)
+	}
+
+	// a commit is a new version, a snapshot from before keeps its own
+	err = db.Transact(func(tx *DBTX) error {
+		return tx.AddColumn("items", "qty", TYPE_INT64, Value{})
+	})
+	if err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	if db.SchemaVersion() != version+1 {
+		t.Errorf("expected the version %d, got %d", version+1, db.SchemaVersion())
+	}
+	err = db.View(func(tx *DBReader) error {
+		if n := cols(tx); n != 3 {
+			t.Errorf("expected the added column, got %d columns", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view: %v", err)
+	}
+	if n := cols(&old); n != 2 {
+		t.Errorf("expected the snapshot to keep 2 columns, got %d", n)
+	}
+	db.EndRead(&old)
+
+	// the writes of the rows keep the version
+	err = db.Transact(func(tx *DBTX) error {
+		_, err := tx.Set("items", *(&Record{}).AddInt64("id", 1).AddStr("name", []byte("a")), MODE_INSERT_ONLY)
+		return err
+	})
+	if err != nil || db.SchemaVersion() != version+1 {
+		t.Errorf("expected the version %d, got %d %v", version+1, db.SchemaVersion(), err)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	db := setupMemoryDB(b)
+	defer db.kv.Close()
+	const rows = 10_000
+	err := db.Transact(func(tx *DBTX) error {
+		err := tx.TableNew(&TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "qty"}, PKeys: 1})
+		for i := int64(0); err == nil && i < rows; i++ {
+			_, err = tx.Set("items", *(&Record{}).AddInt64("id", i).AddInt64("qty", i), MODE_INSERT_ONLY)
+		}
+		return err
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	db.View(func(tx *DBReader) error {
+		rec := Record{}
+		for i := 0; i < b.N; i++ {
+			rec.Cols, rec.Vals = rec.Cols[:0], rec.Vals[:0]
+			if ok, err := tx.Get("items", rec.AddInt64("id", int64(i%rows))); !ok || err != nil {
+				b.Fatal(ok, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/// Page checksums
+// Every page except the master page ends with a CRC32C of the rest of it,
+// written along with the page & checked when it is read from the file.
+// Files created before the checksums have MASTER_CHECKSUMS unset in the
+// master page & are read without checking.
+
+const (
+	PAGE_CHECKSUM_SIZE = 4
+	MASTER_CHECKSUMS   = 1 << 0 // flag in the master page
+)
+
+var ErrPageCorrupt error = errors.New("page checksum mismatch")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// the page must be a full page
+func pageSetChecksum(page []byte) {
+	sum := crc32.Checksum(page[:BTREE_NODE_SIZE], crc32c)
+	binary.LittleEndian.PutUint32(page[BTREE_NODE_SIZE:], sum)
+}
+
+func pageCheck(page []byte, ptr uint64) error {
+	sum := crc32.Checksum(page[:BTREE_NODE_SIZE], crc32c)
+	if binary.LittleEndian.Uint32(page[BTREE_NODE_SIZE:]) != sum {
+		return fmt.Errorf("page %d is corrupt: %w", ptr, ErrPageCorrupt)
+	}
+	return nil
+}
+
+// reports whether the pages of the file are checksummed
+func (db *KV) Checksums() bool {
+	return db.flags&MASTER_CHECKSUMS != 0
+}
+
+// checks the checksum of every page reachable from the snapshot: the tree,
+// the overflow pages & the free list nodes. returns the number of pages
+// checked & every mismatch, the pages below a corrupt node are not checked.
+func (tx *KVReader) VerifyPages() (int, []error) {
+	v := pageVerifier{tx: tx}
+	if tx.Tree.root != 0 {
+		v.node(tx.Tree.root)
+	}
+	for ptr := tx.free.headPage; ptr != 0; {
+		page, ok := v.page(ptr)
+		if !ok || ptr == tx.free.tailPage {
+			break
+		}
+		ptr = flnNext(page)
+	}
+	return v.checked, v.errs
+}
+
+type pageVerifier struct {
+	tx      *KVReader
+	checked int
+	errs    []error
+}
+
+func (v *pageVerifier) page(ptr uint64) (BNode, bool) {
+	v.checked++
+	page := v.tx.pageRaw(ptr)
+	if err := pageCheck(page, ptr); err != nil {
+		v.errs = append(v.errs, err)
+		return BNode{}, false
+	}
+	return BNode{page}, true
+}
+
+func (v *pageVerifier) node(ptr uint64) {
+	node, ok := v.page(ptr)
+	if !ok {
+		return
+	}
+	if err := verifyLayout(node); err != nil {
+		// cannot follow the pointers of a node that is valid but not a tree node
+		v.errs = append(v.errs, fmt.Errorf("page %d: %w", ptr, err))
+		return
+	}
+	for i := uint16(0); i < node.nKeys(); i++ {
+		switch {
+		case node.bNodeType() == BNODE_INODE:
+			v.node(node.getPtr(i))
+		case node.isOverflow(i) && len(node.getVal(i)) == OVERFLOW_STUB_SIZE:
+			for ptr := binary.LittleEndian.Uint64(node.getVal(i)[8:]); ptr != 0; {
+				page, ok := v.page(ptr)
+				if !ok {
+					break
+				}
+				ptr = overflowNext(page)
+			}
+		}
+	}
+}
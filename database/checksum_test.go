@@ -0,0 +1,149 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a DB with a few leaves, returns the leaf pointers
+func checksumDB(t *testing.T, path string) []uint64 {
+	kv := KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer kv.Close()
+	if !kv.Checksums() {
+		t.Fatalf("expected a new file to have checksums")
+	}
+	walCommit(t, &kv, 0)
+	walCommit(t, &kv, 1)
+
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+	root := reader.Tree.get(reader.Tree.root)
+	if root.bNodeType() != BNODE_INODE {
+		t.Fatalf("expected more than 1 leaf")
+	}
+	leaves := []uint64{}
+	for i := uint16(0); i < root.nKeys(); i++ {
+		leaves = append(leaves, root.getPtr(i))
+	}
+	if n, errs := reader.VerifyPages(); len(errs) != 0 || n < len(leaves)+1 {
+		t.Fatalf("expected %d valid pages at least, got %d & %v", len(leaves)+1, n, errs)
+	}
+	return leaves
+}
+
+// flip a bit in the middle of a page
+func corruptPage(t *testing.T, path string, ptr uint64) {
+	fp, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer fp.Close()
+	var b [1]byte
+	off := int64(ptr)*BTREE_PAGE_SIZE + BTREE_PAGE_SIZE/2
+	if _, err := fp.ReadAt(b[:], off); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	b[0] ^= 0x10
+	if _, err := fp.WriteAt(b[:], off); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestPageChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checksum.db")
+	leaves := checksumDB(t, path)
+	bad := []uint64{leaves[0], leaves[len(leaves)-1]}
+	for _, ptr := range bad {
+		corruptPage(t, path, ptr)
+	}
+
+	kv := KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer kv.Close()
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+
+	// the read fails with the page number instead of a bad slice access
+	func() {
+		defer func() {
+			err, ok := recover().(error)
+			if !ok || !errors.Is(err, ErrPageCorrupt) {
+				t.Fatalf("expected a corrupt page error, got %v", err)
+			}
+			if want := fmt.Sprintf("page %d is corrupt", bad[0]); !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("expected the error to name page %d, got %q", bad[0], err)
+			}
+		}()
+		reader.Tree.Get(walKey(0))
+	}()
+	if err := reader.Tree.Verify(); err == nil {
+		t.Errorf("expected Verify to fail on a corrupt page")
+	}
+
+	// every mismatch is reported
+	_, errs := reader.VerifyPages()
+	if len(errs) != len(bad) {
+		t.Fatalf("expected %d corrupt pages, got %v", len(bad), errs)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, ErrPageCorrupt) || err.Error() != fmt.Sprintf("page %d is corrupt: page checksum mismatch", bad[i]) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestPageChecksumOptOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checksum.db")
+	leaves := checksumDB(t, path)
+
+	// a file from before the checksums: no flag & no checksum in the pages
+	fp, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := fp.WriteAt(make([]byte, 8), 64); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, ptr := range leaves {
+		var sum [PAGE_CHECKSUM_SIZE]byte
+		binary.LittleEndian.PutUint32(sum[:], 0xdeadbeef)
+		if _, err := fp.WriteAt(sum[:], int64(ptr)*BTREE_PAGE_SIZE+BTREE_NODE_SIZE); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	fp.Close()
+
+	kv := KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if kv.Checksums() {
+		t.Fatalf("expected the file to be read without checksums")
+	}
+	walCommit(t, &kv, 2)
+	walCheck(t, &kv, 2)
+	kv.Close()
+
+	// stays without checksums after a checkpoint
+	kv = KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer kv.Close()
+	if kv.Checksums() {
+		t.Fatalf("expected the flag to stay unset")
+	}
+	walCheck(t, &kv, 2)
+}
@@ -3,8 +3,14 @@ package database
 import (
 	"atomixDB/database/helper"
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Command func(scanner *bufio.Reader, db *DB, currentTX *DBTX)
@@ -24,6 +30,7 @@ type QueryRequest struct {
 	endVals   []string
 	queryType QueryType
 	response  chan GetResponse
+	tx        *DBTX // the open transaction, its own writes are read too
 }
 
 type GetResponse struct {
@@ -34,14 +41,34 @@ type GetResponse struct {
 
 func RegisterCommands() map[string]Command {
 	return map[string]Command{
-		"create": HandleCreate,
-		"insert": HandleInsert,
-		"delete": HandleDelete,
-		"get":    HandleGet,
-		"update": HandleUpdate,
-		"begin":  func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
-		"abort":  func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
-		"commit": func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
+		"create":    HandleCreate,
+		"drop":      HandleDrop,
+		"rename":    HandleRename,
+		"insert":    HandleInsert,
+		"delete":    HandleDelete,
+		"get":       HandleGet,
+		"scan":      HandleScan,
+		"update":    HandleUpdate,
+		"upsert":    HandleUpsert,
+		"check":     HandleCheck,
+		"stats":     HandleStats,
+		"analyze":   HandleAnalyze,
+		"tables":    HandleTables,
+		"desc":      HandleDesc,
+		"verify":    HandleVerify,
+		"backup":    HandleBackup,
+		"vacuum":    HandleVacuum,
+		"import":    HandleImport,
+		"export":    HandleExport,
+		"dump":      HandleDump,
+		"mode":      HandleMode,
+		"timer":     HandleTimer,
+		"savepoint": HandleSavepoint,
+		"rollback":  HandleRollback,
+		"release":   HandleRelease,
+		"begin":     func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
+		"abort":     func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
+		"commit":    func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {},
 		"help": func(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 			helper.PrintWelcomeMessage(false)
 		},
@@ -49,31 +76,153 @@ func RegisterCommands() map[string]Command {
 }
 
 func HandleCreate(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
-	td := helper.GetTableInput(scanner)
+	td, err := helper.GetTableInput(scanner)
+	if err != nil {
+		replError("Error creating table: %v", err)
+		return
+	}
+	// the history gets the table in a line, to create it again
+	replEditor.add("create " + td.Line())
+	createTable(db, currentTX, td)
+}
+
+// the table of the input, of GetTableInput or of ParseTableInput
+func createTable(db *DB, currentTX *DBTX, td helper.TableInput) {
 	var writer KVTX
 	tdef := &TableDef{
-		Name:        td.Name,
-		Cols:        td.Cols,
-		Types:       td.Types,
-		Indexes:     td.Indexes,
-		PKeys:       1,
-		IndexPrefix: make([]uint32, 0),
+		Name:          td.Name,
+		Cols:          td.Cols,
+		Types:         td.Types,
+		Indexes:       td.Indexes,
+		Unique:        td.Unique,
+		PKeys:         1,
+		AutoIncrement: td.AutoInc,
+		IndexPrefix:   make([]uint32, 0),
+	}
+	if err := tableConstraints(tdef, td); err != nil {
+		replError("Error creating table: %v", friendlyError(err))
+		return
 	}
 	if currentTX != nil {
 		if err := db.TableNew(tdef, &writer); err != nil {
-			fmt.Println("Error creating table: ", err)
+			replError("Error creating table: %v", friendlyError(err))
 		} else {
-			fmt.Printf("Table '%s' created successfully.\n", td.Name)
+			replStatus("Table '%s' created successfully.", td.Name)
 		}
 	} else {
 		db.kv.Begin(&writer)
 		if err := db.TableNew(tdef, &writer); err != nil {
 			db.kv.Abort(&writer)
-			fmt.Println("Error creating table: ", err)
+			replError("Error creating table: %v", friendlyError(err))
 		} else {
 			db.kv.Commit(&writer)
-			fmt.Printf("Table '%s' created successfully.\n", td.Name)
+			replStatus("Table '%s' created successfully.", td.Name)
+		}
+	}
+}
+
+// the NOT NULL columns, the defaults & the foreign keys of the input
+func tableConstraints(tdef *TableDef, td helper.TableInput) error {
+	for _, fk := range td.Foreign {
+		onDelete := FK_RESTRICT
+		if fk.Cascade {
+			onDelete = FK_CASCADE
+		}
+		tdef.ForeignKeys = append(tdef.ForeignKeys, ForeignKey{
+			Cols: fk.Cols, Parent: fk.Parent, RefCols: fk.RefCols, OnDelete: onDelete,
+		})
+	}
+	if len(td.NotNull) == 0 && len(td.Default) == 0 {
+		return nil
+	}
+	tdef.NotNull = make([]bool, len(tdef.Cols))
+	tdef.Defaults = make([]Value, len(tdef.Cols))
+	for _, col := range td.NotNull {
+		i := ColIndex(tdef, strings.TrimSpace(col))
+		if i < 0 {
+			return columnNotFound(tdef.Name, col)
+		}
+		tdef.NotNull[i] = true
+	}
+	for col, raw := range td.Default {
+		i := ColIndex(tdef, col)
+		if i < 0 || i >= len(tdef.Types) {
+			return columnNotFound(tdef.Name, col)
+		}
+		v, err := parseValue(tdef.Types[i], raw)
+		if err != nil {
+			return fmt.Errorf("invalid default for %s: %w", col, err)
 		}
+		tdef.Defaults[i] = v
+	}
+	return nil
+}
+
+// asks for a confirmation when the input is a terminal, see StartDB
+func HandleDrop(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+	if helper.Interactive {
+		helper.Prompt("Drop table '%s' and all its records? [y/N]: ", tableName)
+		answer, _ := scanner.ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			replStatus("Drop cancelled.")
+			return
+		}
+	}
+	var err error
+	if currentTX != nil {
+		err = currentTX.DropTable(tableName)
+	} else {
+		var tx DBTX
+		db.Begin(&tx)
+		if err = tx.DropTable(tableName); err != nil {
+			db.Abort(&tx)
+		} else {
+			err = db.Commit(&tx)
+		}
+	}
+	if err != nil {
+		replError("Error dropping table: %v", friendlyError(err))
+		return
+	}
+	replStatus("Table '%s' dropped successfully.", tableName)
+}
+
+// rename a table, or one of its columns
+func HandleRename(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+	helper.PromptFor(helper.ANSWER_COLUMNS, "Enter column to rename (leave empty to rename the table): ")
+	col, _ := scanner.ReadString('\n')
+	col = strings.TrimSpace(col)
+	helper.Prompt("Enter new name: ")
+	name, _ := scanner.ReadString('\n')
+	name = strings.TrimSpace(name)
+
+	rename := func(tx *DBTX) error {
+		if col == "" {
+			return tx.RenameTable(tableName, name)
+		}
+		return tx.RenameColumn(tableName, col, name)
+	}
+	var err error
+	if currentTX != nil {
+		err = rename(currentTX)
+	} else {
+		var tx DBTX
+		db.Begin(&tx)
+		if err = rename(&tx); err != nil {
+			db.Abort(&tx)
+		} else {
+			err = db.Commit(&tx)
+		}
+	}
+	switch {
+	case err != nil:
+		replError("Error renaming: %v", friendlyError(err))
+	case col == "":
+		replStatus("Table '%s' renamed to '%s'.", tableName, name)
+	default:
+		replStatus("Column '%s' of '%s' renamed to '%s'.", col, tableName, name)
 	}
 }
 
@@ -85,13 +234,12 @@ func HandleInsert(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		Vals: []Value{},
 	}
 
-	var writer KVTX
 	var reader KVReader
 	db.kv.BeginRead(&reader)
 	tdef := GetTableDef(db, tableName, &reader.Tree)
 	db.kv.EndRead(&reader)
 	if tdef == nil {
-		fmt.Printf("Table '%s' not found.\n", tableName)
+		replError("Table '%s' not found.", tableName)
 		return
 	}
 
@@ -100,48 +248,91 @@ func HandleInsert(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		isValidInput := false
 
 		for !isValidInput {
-			fmt.Printf("Enter value for %s: ", col)
+			helper.Prompt("Enter value for %s: ", col)
 			valStr, _ := scanner.ReadString('\n')
 			valStr = strings.TrimSpace(valStr)
 
-			if tdef.Types[i] == TYPE_BYTES {
-				val = Value{Type: TYPE_BYTES, Str: []byte(valStr)}
-				isValidInput = true
-			} else if tdef.Types[i] == TYPE_INT64 {
-				var key int64
-				_, err := fmt.Sscanf(valStr, "%d", &key)
-				if err == nil {
-					val = Value{Type: TYPE_INT64, I64: key}
-					isValidInput = true
-				} else {
-					fmt.Printf("Invalid input. Please enter again:\n")
+			if valStr == "" && i >= tdef.PKeys && i < len(tdef.Defaults) && tdef.Defaults[i].Type != 0 {
+				break // left to the default
+			}
+			if valStr == "" && i == 0 && tdef.AutoIncrement {
+				break // assigned by the insert
+			}
+			if v, err := parseValue(tdef.Types[i], valStr); err == nil {
+				val, isValidInput = v, true
+			} else {
+				if !retryInput(col, valStr, err) {
+					return
 				}
+				helper.Prompt("Invalid input. Please enter again:\n")
 			}
 		}
+		if !isValidInput {
+			continue
+		}
 
 		rec.Cols = append(rec.Cols, col)
 		rec.Vals = append(rec.Vals, val)
 	}
 
+	insertRecord(db, currentTX, tdef, &rec)
+}
+
+// the row typed on the line of INSERT, insert <table> <values...>, see
+// parseRowLine
+func insertLine(db *DB, currentTX *DBTX, args string) {
+	tableName, values, _ := strings.Cut(args, " ")
+	var tdef *TableDef
 	if currentTX != nil {
-		if inserted, err := currentTX.Set(tableName, rec, MODE_INSERT_ONLY); err != nil {
-			fmt.Println("Failed to insert: ", err.Error())
+		tdef = GetTableDef(db, tableName, &currentTX.kv.Tree)
+	} else {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		tdef = GetTableDef(db, tableName, &reader.Tree)
+		db.kv.EndRead(&reader)
+	}
+	if tdef == nil {
+		replError("Table '%s' not found.", tableName)
+		return
+	}
+	rec, err := parseRowLine(tdef, values)
+	if err != nil {
+		replError("Failed to insert: %v", err)
+		return
+	}
+	insertRecord(db, currentTX, tdef, rec)
+}
+
+func insertRecord(db *DB, currentTX *DBTX, tdef *TableDef, rec *Record) {
+	tableName := tdef.Name
+	assigned := tdef.AutoIncrement && rec.Get(tdef.Cols[0]) == nil
+	timer := startExec(db)
+	if currentTX != nil {
+		if inserted, err := currentTX.InsertAuto(tableName, rec); err != nil {
+			replError("Failed to insert: %v", friendlyError(err))
 		} else if inserted {
-			fmt.Println("Record inserted successfully.")
+			replStatus("Record inserted successfully.")
+			if assigned {
+				fmt.Printf("Assigned %s = %d\n", tdef.Cols[0], rec.Get(tdef.Cols[0]).I64)
+			}
+			PrintTiming(timer.stop())
 		} else {
-			fmt.Println("Failed to insert record.")
+			replError("Failed to insert record.")
 		}
 	} else {
-		db.kv.Begin(&writer)
-		if inserted, err := db.Insert(tableName, rec, &writer); err != nil {
-			db.kv.Abort(&writer)
-			fmt.Println("Failed to insert: ", err.Error())
+		inserted, err := db.autocommit(tableName, rec, func(tx *DBTX) (bool, error) {
+			return tx.InsertAuto(tableName, rec)
+		})
+		if err != nil {
+			replError("Failed to insert: %v", friendlyError(err))
 		} else if inserted {
-			db.kv.Commit(&writer)
-			fmt.Println("Record inserted successfully.")
+			replStatus("Record inserted successfully.")
+			if assigned {
+				fmt.Printf("Assigned %s = %d\n", tdef.Cols[0], rec.Get(tdef.Cols[0]).I64)
+			}
+			PrintTiming(timer.stop())
 		} else {
-			db.kv.Abort(&writer)
-			fmt.Println("Failed to insert record.")
+			replError("Failed to insert record.")
 		}
 	}
 }
@@ -150,19 +341,22 @@ func HandleGet(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 	responseChan := make(chan GetResponse, 1)
 	tableName := helper.GetTableName(scanner)
 
-	fmt.Println("\nSelect query type:")
-	fmt.Println("1. Index lookup (primary/secondary index)")
-	fmt.Println("2. Range query")
-	fmt.Println("3. Column filter")
+	helper.Prompt("\nSelect query type:\n")
+	helper.Prompt("1. Index lookup (primary/secondary index)\n")
+	helper.Prompt("2. Range query\n")
+	helper.Prompt("3. Column filter\n")
 	var choice string
 	for {
-		fmt.Print("Enter choice (1, 2 or 3): ")
+		helper.Prompt("Enter choice (1, 2 or 3): ")
 		choice, _ = scanner.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 		if choice != "" {
 			break
+		} else if !helper.Interactive {
+			replError("Error: no query type")
+			return
 		} else {
-			fmt.Println("Please enter a valid choice!")
+			helper.Prompt("Please enter a valid choice!\n")
 		}
 	}
 
@@ -174,35 +368,32 @@ func HandleGet(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		queryType = TableScan
 	}
 
+	var req QueryRequest
 	switch queryType {
 	case RangeQuery:
-		fmt.Print("\nEnter column name for range lookup(index col): ")
+		helper.PromptFor(helper.ANSWER_COLUMNS, "\nEnter column name for range lookup(index col): ")
 		colStr, _ := scanner.ReadString('\n')
 		col := strings.TrimSpace(colStr)
 
 		startVals := make([]string, 0, 1)
 		endVals := make([]string, 0, 1)
 
-		fmt.Print("\nEnter start range value: ")
+		helper.Prompt("\nEnter start range value: ")
 		val, _ := scanner.ReadString('\n')
 		startVals = append(startVals, strings.TrimSpace(val))
 
-		fmt.Print("\nEnter end range value: ")
+		helper.Prompt("\nEnter end range value: ")
 		val, _ = scanner.ReadString('\n')
 		endVals = append(endVals, strings.TrimSpace(val))
 
-		db.pool.Submit(func() {
-			processQueryRequest(QueryRequest{
-				tableName: tableName,
-				cols:      []string{col},
-				startVals: startVals,
-				endVals:   endVals,
-				queryType: queryType,
-				response:  responseChan,
-			}, db)
-		})
+		req = QueryRequest{
+			tableName: tableName,
+			cols:      []string{col},
+			startVals: startVals,
+			endVals:   endVals,
+		}
 	case SingleRecord:
-		fmt.Print("\nEnter index column(s) (comma-separated for composite index): ")
+		helper.PromptFor(helper.ANSWER_COLUMNS, "\nEnter index column(s) (comma-separated for composite index): ")
 		colStr, _ := scanner.ReadString('\n')
 		cols := strings.Split(strings.TrimSpace(colStr), ",")
 		for i := range cols {
@@ -211,24 +402,20 @@ func HandleGet(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 
 		startVals := make([]string, 0, len(cols))
 		for _, col := range cols {
-			fmt.Printf("Enter value for %s: ", col)
+			helper.Prompt("Enter value for %s: ", col)
 			val, _ := scanner.ReadString('\n')
 			startVals = append(startVals, strings.TrimSpace(val))
 		}
 
-		db.pool.Submit(func() {
-			processQueryRequest(QueryRequest{
-				tableName: tableName,
-				cols:      cols,
-				startVals: startVals,
-				queryType: queryType,
-				response:  responseChan,
-			}, db)
-		})
+		req = QueryRequest{
+			tableName: tableName,
+			cols:      cols,
+			startVals: startVals,
+		}
 	default:
-		fmt.Print("\nEnter column name for filter: ")
+		helper.PromptFor(helper.ANSWER_COLUMNS, "\nEnter column name for filter: ")
 		colStr, _ := scanner.ReadString('\n')
-		fmt.Print("Enter values(comma-separated for multiple values): ")
+		helper.Prompt("Enter values(comma-separated for multiple values): ")
 		valStr, _ := scanner.ReadString('\n')
 
 		startVals := strings.Split(strings.TrimSpace(valStr), ",")
@@ -238,27 +425,67 @@ func HandleGet(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 			startCols[i] = strings.TrimSpace(colStr)
 		}
 
-		db.pool.Submit(func() {
-			processQueryRequest(QueryRequest{
-				tableName: tableName,
-				cols:      startCols,
-				startVals: startVals,
-				queryType: queryType,
-				response:  responseChan,
-			}, db)
-		})
+		req = QueryRequest{
+			tableName: tableName,
+			cols:      startCols,
+			startVals: startVals,
+		}
 	}
 
+	req.queryType, req.response, req.tx = queryType, responseChan, currentTX
+	timer := startExec(db)
+	db.pool.Submit(func() {
+		processQueryRequest(req, db)
+	})
 	response := <-responseChan
+	stats := timer.stop()
 	if response.err != nil {
-		fmt.Println("\nError:", response.err)
+		replError("Error: %v", friendlyError(response.err))
 		return
 	}
 	if !response.found {
-		fmt.Println("\nNo records found")
-		return
+		response.records = nil
 	}
 	printRecords(response.records)
+	PrintTiming(stats)
+}
+
+func HandleScan(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+
+	// inside a transaction its own writes are scanned too
+	reader := &KVReader{}
+	if currentTX != nil {
+		reader = &currentTX.kv.KVReader
+	} else {
+		db.kv.BeginRead(reader)
+		defer db.kv.EndRead(reader)
+		reader.Tree.ctx = replCtx // Ctrl-C stops the scan
+	}
+
+	timer := startExec(db)
+	sc, err := db.ScanAll(tableName, &reader.Tree)
+	if err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+
+	var rows [][]Value
+	for sc.Valid() {
+		rec := &Record{}
+		if err := sc.Deref(rec, &reader.Tree); err != nil {
+			replError("Error: %v", friendlyError(err))
+			return
+		}
+		rows = append(rows, rec.Vals)
+		sc.Next()
+	}
+	stats := timer.stop()
+	// the header of an empty table too
+	if err := PrintRows(os.Stdout, sc.tdef.Cols, rows); err != nil {
+		replError("Error: %v", err)
+	}
+	PrintTiming(stats)
 }
 
 func HandleDelete(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
@@ -268,13 +495,12 @@ func HandleDelete(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		Vals: []Value{},
 	}
 
-	var writer KVTX
 	var reader KVReader
 	db.kv.BeginRead(&reader)
 	tdef := GetTableDef(db, tableName, &reader.Tree)
 	db.kv.EndRead(&reader)
 	if tdef == nil {
-		fmt.Printf("Table '%s' not found.\n", tableName)
+		replError("Table '%s' not found.", tableName)
 		return
 	}
 
@@ -283,22 +509,17 @@ func HandleDelete(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		isValidInput := false
 
 		for !isValidInput {
-			fmt.Printf("Enter value for %s: ", col)
+			helper.Prompt("Enter value for %s: ", col)
 			valStr, _ := scanner.ReadString('\n')
 			valStr = strings.TrimSpace(valStr)
 
-			if tdef.Types[i] == TYPE_BYTES {
-				val = Value{Type: TYPE_BYTES, Str: []byte(valStr)}
-				isValidInput = true
-			} else if tdef.Types[i] == TYPE_INT64 {
-				var key int64
-				_, err := fmt.Sscanf(valStr, "%d", &key)
-				if err == nil {
-					val = Value{Type: TYPE_INT64, I64: key}
-					isValidInput = true
-				} else {
-					fmt.Printf("Invalid input. Please enter again:\n")
+			if v, err := parseValue(tdef.Types[i], valStr); err == nil {
+				val, isValidInput = v, true
+			} else {
+				if !retryInput(col, valStr, err) {
+					return
 				}
+				helper.Prompt("Invalid input. Please enter again:\n")
 			}
 		}
 
@@ -306,24 +527,27 @@ func HandleDelete(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		rec.Vals = append(rec.Vals, val)
 	}
 
+	timer := startExec(db)
 	if currentTX != nil {
 		if deleted, err := currentTX.Delete(tableName, rec); err != nil {
-			fmt.Println("Failed to delete: ", err.Error())
+			replError("Failed to delete: %v", friendlyError(err))
 		} else if deleted {
-			fmt.Println("Record deleted successfully.")
+			replStatus("Record deleted successfully.")
+			PrintTiming(timer.stop())
 		} else {
-			fmt.Println("Failed to delete record.")
+			replError("Failed to delete record.")
 		}
 	} else {
-		db.kv.Begin(&writer)
-		if deleted, err := db.Delete(tableName, rec, &writer); err != nil {
-			fmt.Println("Failed to delete: ", err.Error())
+		deleted, err := db.autocommit(tableName, &rec, func(tx *DBTX) (bool, error) {
+			return tx.Delete(tableName, rec)
+		})
+		if err != nil {
+			replError("Failed to delete: %v", friendlyError(err))
 		} else if deleted {
-			db.kv.Commit(&writer)
-			fmt.Println("Record deleted successfully.")
+			replStatus("Record deleted successfully.")
+			PrintTiming(timer.stop())
 		} else {
-			db.kv.Abort(&writer)
-			fmt.Println("Failed to delete record.")
+			replError("Failed to delete record.")
 		}
 	}
 }
@@ -336,21 +560,20 @@ func HandleUpdate(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 		Vals: []Value{},
 	}
 
-	var writer KVTX
 	var reader KVReader
 	db.kv.BeginRead(&reader)
 	tdef := GetTableDef(db, tableName, &reader.Tree)
 	db.kv.EndRead(&reader)
 
 	if tdef == nil {
-		fmt.Printf("Table '%s' not found.\n", tableName)
+		replError("Table '%s' not found.", tableName)
 		return
 	}
 	for i, col := range tdef.Cols {
 		if i == 0 {
-			fmt.Printf("Enter primary key for %s: ", col)
+			helper.Prompt("Enter primary key for %s: ", col)
 		} else {
-			fmt.Printf("Enter value for %s: ", col)
+			helper.Prompt("Enter value for %s (empty to keep): ", col)
 		}
 		var val Value
 		isValidInput := false
@@ -359,97 +582,686 @@ func HandleUpdate(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
 			valStr, _ := scanner.ReadString('\n')
 			valStr = strings.TrimSpace(valStr)
 
-			if tdef.Types[i] == TYPE_BYTES {
-				val = Value{Type: TYPE_BYTES, Str: []byte(valStr)}
-				isValidInput = true
-			} else if tdef.Types[i] == TYPE_INT64 {
-				var key int64
-				_, err := fmt.Sscanf(valStr, "%d", &key)
-				if err == nil {
-					val = Value{Type: TYPE_INT64, I64: key}
-					isValidInput = true
-				} else {
-					fmt.Printf("Invalid input. Please enter again: ")
+			if valStr == "" && i >= tdef.PKeys {
+				break // left unchanged
+			}
+			if v, err := parseValue(tdef.Types[i], valStr); err == nil {
+				val, isValidInput = v, true
+			} else {
+				if !retryInput(col, valStr, err) {
+					return
 				}
+				helper.Prompt("Invalid input. Please enter again: ")
 			}
 		}
+		if !isValidInput {
+			continue
+		}
 
 		rec.Cols = append(rec.Cols, col)
 		rec.Vals = append(rec.Vals, val)
 	}
 
+	var err error
+	timer := startExec(db)
 	if currentTX != nil {
-		if updated, err := currentTX.Set(tableName, rec, MODE_UPDATE_ONLY); err != nil {
-			fmt.Println("Error while updating: ", err.Error())
-		} else if updated {
-			printRecord(rec)
+		err = currentTX.UpdatePartial(tableName, rec)
+	} else {
+		_, err = db.autocommit(tableName, &rec, func(tx *DBTX) (bool, error) {
+			return true, tx.UpdatePartial(tableName, rec)
+		})
+	}
+	stats := timer.stop()
+	if err != nil {
+		replError("Error while updating: %v", friendlyError(err))
+	} else {
+		printRecords([]*Record{&rec})
+		PrintTiming(stats)
+	}
+}
+
+// insert the record, or replace the row with its primary key
+func HandleUpsert(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	tdef := GetTableDef(db, tableName, &reader.Tree)
+	db.kv.EndRead(&reader)
+	if tdef == nil {
+		replError("Table '%s' not found.", tableName)
+		return
+	}
+
+	rec := Record{}
+	for i, col := range tdef.Cols {
+		helper.Prompt("Enter value for %s: ", col)
+		for {
+			valStr, _ := scanner.ReadString('\n')
+			valStr = strings.TrimSpace(valStr)
+			v, err := parseValue(tdef.Types[i], valStr)
+			if err == nil {
+				rec.Cols = append(rec.Cols, col)
+				rec.Vals = append(rec.Vals, v)
+				break
+			}
+			if !retryInput(col, valStr, err) {
+				return
+			}
+			helper.Prompt("Invalid input. Please enter again: ")
+		}
+	}
+
+	var created bool
+	var err error
+	timer := startExec(db)
+	if currentTX != nil {
+		created, err = currentTX.Upsert(tableName, rec, MODE_UPSERT)
+	} else {
+		_, err = db.autocommit(tableName, &rec, func(tx *DBTX) (bool, error) {
+			created, err = tx.Upsert(tableName, rec, MODE_UPSERT)
+			return err == nil, err
+		})
+	}
+	stats := timer.stop()
+	if err != nil {
+		replError("Failed to upsert: %v", friendlyError(err))
+		return
+	} else if created {
+		replStatus("Record inserted successfully.")
+	} else {
+		replStatus("Record updated successfully.")
+	}
+	PrintTiming(stats)
+}
+
+// CHECK [<name> [REPAIR]]: the structure & the row counts of the database,
+// then the indexes of each table or of the one named. REPAIR fixes the
+// indexes of the table, in the open transaction or in one of its own.
+func HandleCheck(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter table name & REPAIR to fix its indexes (leave empty for the whole database): ")
+	input, _ := scanner.ReadString('\n')
+	fields := strings.Fields(input)
+	repair := len(fields) == 2 && strings.EqualFold(fields[1], "repair")
+	if len(fields) > 2 || (len(fields) == 2 && !repair) {
+		replError("Error: expected a table name & REPAIR, got %q", strings.TrimSpace(input))
+		return
+	}
+	if repair {
+		var problems []Problem
+		var err error
+		if currentTX != nil {
+			problems, err = currentTX.Repair(fields[0])
 		} else {
-			fmt.Println("Failed to update record.")
+			var tx DBTX
+			if err = db.BeginTx(replCtx, &tx); err == nil {
+				if problems, err = tx.Repair(fields[0]); err != nil {
+					db.Abort(&tx)
+				} else {
+					err = db.Commit(&tx)
+				}
+			}
+		}
+		if err != nil {
+			replError("Error repairing table: %v", friendlyError(err))
+			return
 		}
+		for _, p := range problems {
+			fmt.Println("Repaired " + p.String())
+		}
+		replStatus("Table '%s' repaired: %d problems.", fields[0], len(problems))
+		return
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	if err := reader.Tree.Verify(); err != nil {
+		replError("Database is corrupted: %v", err)
+		return
+	}
+	if err := db.CheckRowCounts(&reader.Tree); err != nil {
+		replError("Database is inconsistent: %v", err)
+		return
+	}
+	tables := fields
+	if len(tables) == 0 {
+		for _, tdef := range catalogTables(db, &reader.Tree) {
+			tables = append(tables, tdef.Name)
+		}
+	}
+	found := 0
+	for _, name := range tables {
+		problems, err := db.CheckConsistency(name, &reader.Tree)
+		if err != nil {
+			replError("Error: %v", friendlyError(err))
+			return
+		}
+		for _, p := range problems {
+			fmt.Println(p.String())
+		}
+		found += len(problems)
+	}
+	if found > 0 {
+		replError("Database is inconsistent: %d index problems, CHECK <table> REPAIR fixes them.", found)
+		return
+	}
+	fmt.Println("Database is consistent.")
+}
+
+func HandleVerify(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	if !db.kv.Checksums() {
+		fmt.Println("The database file was created without page checksums.")
+		return
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	checked, errs := reader.VerifyPages()
+	for _, err := range errs {
+		replError("Error: %v", err)
+	}
+	if len(errs) > 0 {
+		replError("%d of %d pages are corrupt.", len(errs), checked)
+		return
+	}
+	fmt.Printf("All %d pages are valid.\n", checked)
+}
+
+func HandleBackup(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter backup file path: ")
+	path, _ := scanner.ReadString('\n')
+	path = strings.TrimSpace(path)
+	if path == "" {
+		replError("Error: no backup file path")
+		return
+	}
+	if err := backupFile(db, path); err != nil {
+		replError("Error: %v", err)
+		return
+	}
+	replStatus("Backup written to '%s'.", path)
+}
+
+func backupFile(db *DB, path string) error {
+	// never overwrite an existing file, it may be the database itself
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(fp, BACKUP_BATCH*BTREE_PAGE_SIZE)
+	err = db.Backup(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// load a CSV file into a table, in transactions of its own
+func HandleImport(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+	helper.Prompt("Enter CSV file path: ")
+	path, _ := scanner.ReadString('\n')
+	path = strings.TrimSpace(path)
+	helper.Prompt("Enter options (header=skip|none delimiter=; empty=null batch=1000, or leave empty): ")
+	optsInput, _ := scanner.ReadString('\n')
+	if currentTX != nil {
+		replError("Error: IMPORT commits its own transactions, commit or abort the current one first")
+		return
+	}
+	opts, err := parseImportOptions(strings.TrimSpace(optsInput))
+	if err != nil {
+		replError("Error: %v", err)
+		return
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		replError("Error: %v", err)
+		return
+	}
+	defer fp.Close()
+
+	timer := startExec(db)
+	stats, err := db.ImportContext(replCtx, tableName, fp, opts)
+	for _, rerr := range stats.Errors {
+		replError("Rejected %s %v", path, rerr)
+	}
+	if err != nil {
+		replError("Error importing: %v", friendlyError(err))
+	}
+	fmt.Printf("Imported %d rows, rejected %d rows in %v.\n", stats.Inserted, stats.Rejected, stats.Elapsed.Round(time.Millisecond))
+	PrintTiming(timer.stop())
+}
+
+// write the rows of a table to a new file or to stdout for -, as CSV or
+// JSON Lines
+func HandleExport(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+	helper.PromptFor(helper.ANSWER_COLUMNS, "Enter columns (comma-separated, or leave empty for all): ")
+	colsInput, _ := scanner.ReadString('\n')
+	helper.Prompt("Enter format (csv or json): ")
+	formatInput, _ := scanner.ReadString('\n')
+	helper.Prompt("Enter file path (- for the output): ")
+	path, _ := scanner.ReadString('\n')
+	path = strings.TrimSpace(path)
+
+	var format Format
+	switch strings.ToLower(strings.TrimSpace(formatInput)) {
+	case "csv", "":
+		format = EXPORT_CSV
+	case "json", "jsonl":
+		format = EXPORT_JSONL
+	default:
+		replError("Error: unknown format %q, expected csv or json", strings.TrimSpace(formatInput))
+		return
+	}
+	if path == "" {
+		replError("Error: no export file path")
+		return
+	}
+	req := &Scanner{}
+	if colsInput = strings.TrimSpace(colsInput); colsInput != "" {
+		for _, col := range strings.Split(colsInput, ",") {
+			req.Project = append(req.Project, strings.TrimSpace(col))
+		}
+	}
+	req.SetContext(replCtx)
+	var n int
+	timer := startExec(db)
+	err := writeOutput(path, func(w io.Writer) (err error) {
+		if currentTX != nil {
+			n, err = currentTX.Export(tableName, req, w, format)
+		} else {
+			var reader DBReader
+			db.BeginRead(&reader)
+			n, err = reader.Export(tableName, req, w, format)
+			db.EndRead(&reader)
+		}
+		return err
+	})
+	if err != nil {
+		replError("Error exporting: %v", friendlyError(err))
+		return
+	}
+	stats := timer.stop()
+	if path != "-" {
+		fmt.Printf("Exported %d rows to '%s'.\n", n, path)
+	}
+	PrintTiming(stats)
+}
+
+// the tables & the rows in a script replayed by -f, see DB.Dump. inside a
+// transaction its own writes are dumped too.
+func HandleDump(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter the dump file path (- for stdout): ")
+	path, _ := scanner.ReadString('\n')
+	if path = strings.TrimSpace(path); path == "" {
+		replError("Error: no dump file path")
+		return
+	}
+	err := writeOutput(path, func(w io.Writer) error {
+		if currentTX != nil {
+			return dumpTree(db, w, &currentTX.kv.Tree)
+		}
+		return db.Dump(w)
+	})
+	if err != nil {
+		replError("Error dumping: %v", friendlyError(err))
+		return
+	}
+	if path != "-" {
+		fmt.Printf("Dumped the database to '%s'.\n", path)
+	}
+}
+
+// runs write to the new file of the path, or to stdout for -. the file is
+// removed when write fails.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	// never overwrite an existing file, it may be the database itself
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	err = write(fp)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// key=value options of IMPORT
+func parseImportOptions(input string) (ImportOptions, error) {
+	opts := ImportOptions{}
+	for _, field := range strings.Fields(input) {
+		key, val, _ := strings.Cut(field, "=")
+		switch strings.ToLower(key) {
+		case "header":
+			switch strings.ToLower(val) {
+			case "match", "":
+				opts.Header = IMPORT_HEADER
+			case "skip":
+				opts.Header = IMPORT_SKIP_HEADER
+			case "none":
+				opts.Header = IMPORT_NO_HEADER
+			default:
+				return opts, fmt.Errorf("header=%s, expected match, skip or none", val)
+			}
+		case "delimiter":
+			if val == "tab" || val == `\t` {
+				val = "\t"
+			}
+			runes := []rune(val)
+			if len(runes) != 1 {
+				return opts, fmt.Errorf("delimiter=%s, expected a single character", val)
+			}
+			opts.Delimiter = runes[0]
+		case "empty":
+			switch strings.ToLower(val) {
+			case "null", "default":
+				opts.EmptyNull = true
+			case "value":
+				opts.EmptyNull = false
+			default:
+				return opts, fmt.Errorf("empty=%s, expected null or value", val)
+			}
+		case "batch":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return opts, fmt.Errorf("batch=%s, expected a number of rows", val)
+			}
+			opts.BatchSize = n
+		default:
+			return opts, fmt.Errorf("unknown option %q", field)
+		}
+	}
+	return opts, nil
+}
+
+// the output of GET, SCAN, UPDATE & SELECT: a table, CSV or JSON Lines
+func HandleMode(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter output mode (table, csv or json) & width=N for a table: ")
+	input, _ := scanner.ReadString('\n')
+	if strings.TrimSpace(input) == "" {
+		fmt.Printf("Output mode: %s, width %d (0 is the terminal's)\n", outputNames[replOutput], replWidth)
+		return
+	}
+	mode, width, err := parseOutputMode(input, replOutput, replWidth)
+	if err != nil {
+		replError("Error: %v", err)
+		return
+	}
+	replOutput, replWidth = mode, width
+	replStatus("Output mode set to %s.", outputNames[mode])
+}
+
+// TIMER ON|OFF, the time & the rows examined & returned after each data
+// command. without an answer it is switched.
+func HandleTimer(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter on or off (empty to switch): ")
+	input, _ := scanner.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "":
+		replTimer = !replTimer
+	case "on":
+		replTimer = true
+	case "off":
+		replTimer = false
+	default:
+		replError("Error: expected on or off, got %q", strings.TrimSpace(input))
+		return
+	}
+	if replTimer {
+		replStatus("Timer is on.")
 	} else {
-		db.kv.Begin(&writer)
-		if updated, err := db.Update(tableName, rec, &writer); err != nil {
-			db.kv.Abort(&writer)
-			fmt.Println("Error while updating: ", err.Error())
-		} else if updated {
-			db.kv.Commit(&writer)
-			printRecord(rec)
+		replStatus("Timer is off.")
+	}
+}
+
+func HandleVacuum(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	stats, err := db.CompactContext(replCtx)
+	if err != nil {
+		replError("Error: %v", err)
+		return
+	}
+	fmt.Printf("Compacted %d tables with %d rows.\n", stats.Tables, stats.Rows)
+	fmt.Printf("File size: %d -> %d bytes.\n", stats.SizeBefore, stats.SizeAfter)
+}
+
+func HandleStats(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	helper.Prompt("Enter table name (leave empty for the whole database): ")
+	tableName, _ := scanner.ReadString('\n')
+	tableName = strings.TrimSpace(tableName)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	fmt.Printf("\n%-20s %6s %8s %9s %10s %12s %12s %6s\n",
+		"Tree", "Depth", "Leaves", "Internal", "Keys", "Key bytes", "Val bytes", "Fill")
+	if tableName == "" {
+		printTreeStats("database", reader.Tree.Stats())
+		return
+	}
+	stats, err := db.TableStats(tableName, &reader.Tree)
+	if err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+	tdef := GetTableDef(db, tableName, &reader.Tree)
+	printTreeStats("primary", stats.Primary)
+	for i, index := range tdef.Indexes {
+		printTreeStats("index "+strings.Join(index, "+"), stats.Indexes[i])
+	}
+}
+
+// ANALYZE <name>: the statistics of the table for the planner, written
+// in the open transaction or in one of their own
+func HandleAnalyze(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	tableName := helper.GetTableName(scanner)
+	var stats *TableAnalysis
+	var err error
+	if currentTX != nil {
+		stats, err = currentTX.Analyze(tableName)
+	} else {
+		var tx DBTX
+		db.Begin(&tx)
+		if stats, err = tx.Analyze(tableName); err != nil {
+			db.Abort(&tx)
 		} else {
-			db.kv.Abort(&writer)
-			fmt.Println("Failed to update record.")
+			err = db.Commit(&tx)
 		}
 	}
+	if err != nil {
+		replError("Error analyzing table: %v", friendlyError(err))
+		return
+	}
+	replStatus("Table '%s' analyzed: %d rows.", tableName, stats.Rows)
+	fmt.Printf("%-20s %10s %10s %8s  %s\n", "Column", "Distinct", "Nulls", "Buckets", "Range")
+	for _, c := range stats.Columns {
+		span := ""
+		if len(c.Bounds) > 0 {
+			span = formatValue(c.Bounds[0]) + " .. " + formatValue(c.Bounds[len(c.Bounds)-1])
+		}
+		fmt.Printf("%-20s %10d %10d %8d  %s\n", c.Col, c.Distinct, c.Nulls, len(c.Depths), span)
+	}
+}
+
+func HandleTables(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	names, err := db.ListTables(&reader.Tree)
+	if err != nil {
+		replError("Error listing tables: %v", friendlyError(err))
+		return
+	}
+	if len(names) == 0 {
+		fmt.Println("No tables.")
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// DESC <name> or DESC, asking for the name
+func HandleDesc(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	describeTable(db, helper.GetTableName(scanner))
+}
+
+func describeTable(db *DB, name string) {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	tdef, err := db.Describe(name, &reader.Tree)
+	if err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+	for _, line := range describeLines(tdef) {
+		fmt.Println(line)
+	}
+}
+
+func printTreeStats(name string, s TreeStats) {
+	fmt.Printf("%-20s %6d %8d %9d %10d %12d %12d %5.1f%%\n",
+		name, s.Depth, s.LeafNodes, s.InternalNodes, s.Keys, s.BytesKeys, s.BytesVals, 100*s.AvgFill)
 }
 
-func HandleBegin(scanner *bufio.Reader, db *DB, currentTX *DBTX) *DBTX {
+// the transaction is aborted once ctx is done
+func HandleBegin(scanner *bufio.Reader, db *DB, currentTX *DBTX, ctx context.Context) *DBTX {
 	if currentTX != nil {
-		fmt.Println("Transaction already in progress. Commit or abort the current transaction before starting a new one.")
+		replError("Transaction already in progress. Commit or abort the current transaction before starting a new one.")
 		return currentTX
 	}
 
 	tx := &DBTX{}
-	db.Begin(tx)
-	fmt.Println("Transaction started.")
+	if err := db.BeginTx(ctx, tx); err != nil {
+		replError("Failed to begin transaction: %v", friendlyError(err))
+		return nil
+	}
+	replStatus("Transaction started.")
 	return tx
 }
 
 func HandleCommit(scanner *bufio.Reader, db *DB, currentTX *DBTX) *DBTX {
 	if currentTX == nil {
-		fmt.Println("No active transaction to commit.")
+		replError("No active transaction to commit.")
 		return nil
 	}
 
-	if err := db.Commit(currentTX); err != nil {
-		fmt.Printf("Failed to commit transaction: %v\n", err)
-		return currentTX
+	// the transaction is over either way
+	pending := currentTX.Pending()
+	if err := db.Commit(currentTX); errors.Is(err, ErrHookPanic) {
+		replError("Transaction committed, but a hook failed: %v", err)
+		return nil
+	} else if err != nil {
+		replError("Failed to commit transaction: %v", friendlyError(err))
+		return nil
 	}
 
-	fmt.Println("Transaction committed successfully.")
+	replStatus("Transaction committed, %d pending writes applied.", pending)
 	return nil
 }
 
 func HandleAbort(scanner *bufio.Reader, db *DB, currentTX *DBTX) *DBTX {
 	if currentTX == nil {
-		fmt.Println("No active transaction to abort.")
+		replError("No active transaction to abort.")
 		return nil
 	}
 
-	db.Abort(currentTX)
-	fmt.Println("Transaction aborted.")
+	pending := currentTX.Pending()
+	if err := db.Abort(currentTX); err != nil {
+		replError("Error: %v", friendlyError(err))
+	}
+	replStatus("Transaction aborted, %d pending writes discarded.", pending)
 	return nil
 }
 
+func HandleSavepoint(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	name, ok := savepointInput(scanner, currentTX)
+	if !ok {
+		return
+	}
+	if err := currentTX.Savepoint(name); err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+	replStatus("Savepoint '%s' created.", name)
+}
+
+// roll back to a savepoint
+func HandleRollback(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	name, ok := savepointInput(scanner, currentTX)
+	if !ok {
+		return
+	}
+	if err := currentTX.RollbackTo(name); err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+	replStatus("Rolled back to savepoint '%s'.", name)
+}
+
+func HandleRelease(scanner *bufio.Reader, db *DB, currentTX *DBTX) {
+	name, ok := savepointInput(scanner, currentTX)
+	if !ok {
+		return
+	}
+	if err := currentTX.Release(name); err != nil {
+		replError("Error: %v", friendlyError(err))
+		return
+	}
+	replStatus("Savepoint '%s' released.", name)
+}
+
+func savepointInput(scanner *bufio.Reader, currentTX *DBTX) (string, bool) {
+	if currentTX == nil {
+		replError("No active transaction, savepoints are only used inside a transaction.")
+		return "", false
+	}
+	helper.Prompt("Enter savepoint name: ")
+	name, _ := scanner.ReadString('\n')
+	name = strings.TrimSpace(name)
+	if name == "" {
+		replError("Error: no savepoint name")
+		return "", false
+	}
+	return name, true
+}
+
 func processQueryRequest(req QueryRequest, db *DB) {
-	var reader KVReader
-	db.kv.BeginRead(&reader)
-	defer db.kv.EndRead(&reader)
+	reader := &KVReader{}
+	if req.tx != nil {
+		reader = &req.tx.kv.KVReader
+	} else {
+		db.kv.BeginRead(reader)
+		defer db.kv.EndRead(reader)
+	}
 
 	tdef := GetTableDef(db, req.tableName, &reader.Tree)
 	if tdef == nil {
 		req.response <- GetResponse{
 			records: nil,
 			found:   false,
-			err:     fmt.Errorf("table '%s' not found", req.tableName),
+			err:     tableNotFound(req.tableName),
 		}
 		return
 	}
@@ -470,18 +1282,12 @@ func processQueryRequest(req QueryRequest, db *DB) {
 
 	for i, col := range req.cols {
 		idx := ColIndex(tdef, col)
-		if tdef.Types[idx] == TYPE_BYTES {
-			startRecord.Vals[i] = Value{Type: TYPE_BYTES, Str: []byte(req.startVals[i])}
-		} else {
-			var key int64
-			fmt.Sscanf(req.startVals[i], "%d", &key)
-			startRecord.Vals[i] = Value{Type: TYPE_INT64, I64: key}
-		}
+		startRecord.Vals[i], _ = parseValue(tdef.Types[idx], req.startVals[i])
 		startRecord.Cols[i] = col
 	}
 
 	if req.queryType == SingleRecord {
-		found, err := db.Get(req.tableName, &startRecord, &reader)
+		found, err := db.Get(req.tableName, &startRecord, reader)
 		req.response <- GetResponse{
 			records: []*Record{&startRecord},
 			found:   found,
@@ -491,7 +1297,7 @@ func processQueryRequest(req QueryRequest, db *DB) {
 	}
 
 	if req.queryType == TableScan {
-		results, err := db.QueryWithFilter(req.tableName, tdef, &startRecord)
+		results, err := db.QueryWithFilter(req.tableName, tdef, &startRecord, reader)
 		if err != nil {
 			req.response <- GetResponse{
 				records: nil,
@@ -515,17 +1321,11 @@ func processQueryRequest(req QueryRequest, db *DB) {
 	}
 	for i, col := range req.cols {
 		idx := ColIndex(tdef, col)
-		if tdef.Types[idx] == TYPE_BYTES {
-			endRecord.Vals[i] = Value{Type: TYPE_BYTES, Str: []byte(req.endVals[i])}
-		} else {
-			var key int64
-			fmt.Sscanf(req.endVals[i], "%d", &key)
-			endRecord.Vals[i] = Value{Type: TYPE_INT64, I64: key}
-		}
+		endRecord.Vals[i], _ = parseValue(tdef.Types[idx], req.endVals[i])
 		endRecord.Cols[i] = col
 	}
 
-	records, err := db.GetRange(req.tableName, &startRecord, &endRecord, &reader)
+	records, err := db.GetRange(req.tableName, &startRecord, &endRecord, reader)
 	req.response <- GetResponse{
 		records: records,
 		found:   len(records) > 0,
@@ -543,98 +1343,108 @@ func verifyColumns(tdef *TableDef, cols []string) error {
 			}
 		}
 		if !found {
-			return fmt.Errorf("column '%s' not found in table", col)
+			return columnNotFound(tdef.Name, col)
 		}
 	}
 	return nil
 }
 
+// the REPL message of an error, the known errors in plain words
+func friendlyError(err error) string {
+	var se *StructuredError
+	if !errors.As(err, &se) {
+		se = &StructuredError{}
+	}
+	row := "the row"
+	if len(se.PKey.Cols) > 0 {
+		row = fmt.Sprintf("the row %s of table '%s'", formatRecord(se.PKey), se.Table)
+	}
+	switch {
+	case errors.Is(err, ErrTableNotFound):
+		return fmt.Sprintf("table '%s' does not exist", se.Table)
+	case errors.Is(err, ErrColumnNotFound) && se.Table != "":
+		return fmt.Sprintf("table '%s' has no column '%s'", se.Table, se.Column)
+	case errors.Is(err, ErrExists):
+		return row + " already exists, use UPDATE or UPSERT to change it"
+	case errors.Is(err, ErrNotFound):
+		return row + " does not exist"
+	case errors.Is(err, ErrConflict):
+		return "another transaction changed the same rows and committed first, run the transaction again"
+	case errors.Is(err, ErrBadRange):
+		return "the range needs a lower and an upper bound (" + err.Error() + ")"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, ErrTxDone):
+		return "the transaction has ended, start a new one with BEGIN"
+	case errors.Is(err, ErrReadOnly):
+		return "the database is open read-only"
+	default:
+		return err.Error()
+	}
+}
+
 func formatValue(v Value) string {
+	if v.Null {
+		return "NULL"
+	}
 	switch v.Type {
-	case 1:
+	case TYPE_INT64, TYPE_INT32:
 		return fmt.Sprintf("%d", v.I64)
-	case 2:
+	case TYPE_BYTES:
 		return string(v.Str)
+	case TYPE_FLOAT64:
+		return strconv.FormatFloat(v.F64, 'g', -1, 64)
+	case TYPE_BOOL:
+		return strconv.FormatBool(v.I64 != 0)
+	case TYPE_TIME:
+		return v.Time().UTC().Format(time.RFC3339Nano)
 	default:
 		return "Unknown"
 	}
 }
 
-func printRecord(record Record) {
-	if len(record.Cols) == 0 || len(record.Vals) == 0 {
-		fmt.Println("Empty record")
-		return
+// the value of a column of the type typed in the REPL, a time is RFC 3339 or
+// a date, NULL for none
+func parseValue(typ uint32, s string) (Value, error) {
+	v := Value{Type: typ}
+	if s == "NULL" {
+		v.Null = true
+		return v, nil
 	}
-
-	colWidths := make([]int, len(record.Cols))
-	for i, col := range record.Cols {
-		colWidths[i] = len(col)
-		valWidth := len(formatValue(record.Vals[i]))
-		if valWidth > colWidths[i] {
-			colWidths[i] = valWidth
+	var err error
+	switch typ {
+	case TYPE_INT64:
+		v.I64, err = strconv.ParseInt(s, 10, 64)
+	case TYPE_INT32:
+		v.I64, err = strconv.ParseInt(s, 10, 32)
+	case TYPE_BYTES:
+		v.Str = []byte(s)
+	case TYPE_FLOAT64:
+		v.F64, err = strconv.ParseFloat(s, 64)
+	case TYPE_BOOL:
+		var b bool
+		if b, err = strconv.ParseBool(s); b {
+			v.I64 = 1
 		}
-	}
-
-	fmt.Println(strings.Repeat("-", calculateTotalWidth(colWidths)))
-	for i, col := range record.Cols {
-		fmt.Printf("| %-*s ", colWidths[i], col)
-	}
-	fmt.Println("|")
-	fmt.Println(strings.Repeat("-", calculateTotalWidth(colWidths)))
-
-	for i, val := range record.Vals {
-		fmt.Printf("| %-*s ", colWidths[i], formatValue(val))
-	}
-	fmt.Println("|")
-	fmt.Println(strings.Repeat("-", calculateTotalWidth(colWidths)))
-}
-
-func calculateTotalWidth(colWidths []int) int {
-	total := 1
-	for _, width := range colWidths {
-		total += width + 3
-	}
-	return total
-}
-
-func printRecords(records []*Record) {
-	if len(records) == 0 {
-		fmt.Println("No records found")
-		return
-	}
-
-	colWidths := make([]int, len(records[0].Cols))
-	for _, record := range records {
-		for i, col := range record.Cols {
-
-			colWidths[i] = max(colWidths[i], len(col))
-
-			valWidth := len(formatValue(record.Vals[i]))
-			colWidths[i] = max(colWidths[i], valWidth)
+	case TYPE_TIME:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			t, err = time.Parse(time.DateOnly, s)
 		}
+		v.I64 = t.UnixNano()
+	default:
+		err = fmt.Errorf("invalid type %d", typ)
 	}
+	return v, err
+}
 
-	border := "+"
-	for _, width := range colWidths {
-		border += strings.Repeat("-", width+2) + "+"
-	}
-
-	fmt.Println(border)
-	fmt.Print("|")
-	for i, col := range records[0].Cols {
-		fmt.Printf(" %-*s |", colWidths[i], col)
-	}
-	fmt.Println()
-	fmt.Println(border)
-
-	for _, record := range records {
-		fmt.Print("|")
-		for i, val := range record.Vals {
-			fmt.Printf(" %-*s |", colWidths[i], formatValue(val))
-		}
-		fmt.Println()
+// an invalid value is typed again at a terminal, it fails a script
+func retryInput(col, input string, err error) bool {
+	if helper.Interactive {
+		return true
 	}
-	fmt.Println(border)
+	replError("Invalid value %q for %s: %v", input, col, err)
+	return false
 }
 
 func max(a, b int) int {
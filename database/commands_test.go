@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreate(t *testing.T) {
@@ -116,6 +117,428 @@ func TestCreateTable(t *testing.T) {
 	}
 }
 
+func TestDropTable(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	tdef := setupIndexedTable(t, db)
+	var tx DBTX
+	db.Begin(&tx)
+	for id := int64(1); id <= 200; id++ {
+		email := fmt.Sprintf("user%d@x", id)
+		rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email))
+		for _, table := range []string{"users", "people"} {
+			if _, err := tx.Set(table, *rec, MODE_INSERT_ONLY); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	exists := func(tree *BTree) bool {
+		t.Helper()
+		_, err := db.ScanAll("people", tree)
+		return err == nil
+	}
+
+	// gone for the transaction only, until the commit
+	db.Begin(&tx)
+	if err := tx.DropTable("people"); err != nil {
+		t.Fatalf("drop failed: %v", err)
+	}
+	if exists(&tx.kv.Tree) {
+		t.Errorf("expected the table to be gone in the transaction")
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	if !exists(&reader.Tree) {
+		t.Errorf("expected the table before the commit")
+	}
+	db.kv.EndRead(&reader)
+	db.Abort(&tx)
+	db.kv.BeginRead(&reader)
+	if !exists(&reader.Tree) {
+		t.Errorf("expected the table after the abort")
+	}
+	db.kv.EndRead(&reader)
+
+	db.Begin(&tx)
+	if err := tx.DropTable("people"); err != nil {
+		t.Fatalf("drop failed: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.kv.BeginRead(&reader)
+	if exists(&reader.Tree) {
+		t.Errorf("expected the table to be dropped")
+	}
+	for _, prefix := range append([]uint32{tdef.Prefix}, tdef.IndexPrefix...) {
+		if keys := prefixKeys(&reader.Tree, prefix); len(keys) != 0 {
+			t.Errorf("expected no keys under the prefix %d, got %d", prefix, len(keys))
+		}
+	}
+	sc, err := db.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(scanIDs(sc, &reader.Tree)); n != 200 {
+		t.Errorf("expected the other table to be kept, got %d rows", n)
+	}
+	if err := reader.Tree.Verify(); err != nil {
+		t.Errorf("invalid tree: %v", err)
+	}
+	db.kv.EndRead(&reader)
+
+	for name, want := range map[string]string{"people": "table not found", "@meta": "internal table"} {
+		db.Begin(&tx)
+		if err := tx.DropTable(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("drop %s: expected %q, got %v", name, want, err)
+		}
+		db.Abort(&tx)
+	}
+
+	// created again from scratch
+	setupIndexedTable(t, db)
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	sc, err = db.ScanAll("people", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := scanIDs(sc, &reader.Tree); len(ids) != 0 {
+		t.Errorf("expected an empty table, got %v", ids)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:          "events",
+		Types:         []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:          []string{"id", "tag", "note"},
+		PKeys:         1,
+		Indexes:       [][]string{{"tag"}},
+		AutoIncrement: true,
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	insert := func(tx *DBTX) int64 {
+		t.Helper()
+		rec := (&Record{}).AddStr("tag", []byte("t"))
+		if _, err := tx.InsertAuto("events", rec); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		return rec.Get("id").I64
+	}
+	for i := 0; i < 300; i++ {
+		insert(&tx)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	keys := func() int {
+		t.Helper()
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+		if err := reader.Tree.Verify(); err != nil {
+			t.Errorf("invalid tree: %v", err)
+		}
+		return len(prefixKeys(&reader.Tree, tdef.Prefix)) + len(prefixKeys(&reader.Tree, tdef.IndexPrefix[0]))
+	}
+	truncate := func(reset bool) error {
+		db.Begin(&tx)
+		if err := tx.Truncate("events", reset); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+
+	// refused while another transaction has written to the table
+	var other DBTX
+	db.Begin(&other)
+	insert(&other)
+	if err := truncate(false); !errors.Is(err, ErrTableBusy) {
+		t.Errorf("expected ErrTableBusy, got %v", err)
+	}
+	db.Abort(&other)
+
+	// a write committed after the truncate began
+	db.Begin(&tx)
+	if err := tx.Truncate("events", false); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	db.Begin(&other)
+	insert(&other)
+	if err := db.Commit(&other); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Commit(&tx); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if n := keys(); n != 2*301 {
+		t.Errorf("expected the rows to be kept, got %d keys", n)
+	}
+
+	// the counter goes on without a reset
+	if err := truncate(false); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	if n := keys(); n != 0 {
+		t.Errorf("expected no keys, got %d", n)
+	}
+	// 301 was taken by the aborted insert & 302 by the committed one
+	db.Begin(&tx)
+	if id := insert(&tx); id != 303 {
+		t.Errorf("expected id 303, got %d", id)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := truncate(true); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	db.Begin(&tx)
+	if id := insert(&tx); id != 1 {
+		t.Errorf("expected id 1 after the reset, got %d", id)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if n := keys(); n != 2 {
+		t.Errorf("expected the new row only, got %d keys", n)
+	}
+
+	for name, want := range map[string]string{"missing": "table not found", "@table": "internal table"} {
+		db.Begin(&tx)
+		if err := tx.Truncate(name, false); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("truncate %s: expected %q, got %v", name, want, err)
+		}
+		db.Abort(&tx)
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	for id := int64(1); id <= 50; id++ {
+		insertTestRecord(t, db, id)
+	}
+	var tx DBTX
+	db.Begin(&tx)
+	if err := tx.AddColumn("users", "age", TYPE_INT64, Value{Type: TYPE_INT64, I64: 18}); err != nil {
+		t.Fatalf("add column failed: %v", err)
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	if tdef := GetTableDef(db, "users", &reader.Tree); len(tdef.Cols) != 3 {
+		t.Errorf("expected the old columns before the commit, got %v", tdef.Cols)
+	}
+	db.kv.EndRead(&reader)
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// old rows, a new row with the column & one that defaults it
+	row := func(id int64, age int64) Record {
+		rec := userRecord(id, "new")
+		return *rec.AddInt64("age", age)
+	}
+	db.Begin(&tx)
+	if _, err := tx.Set("users", row(100, 30), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := tx.Set("users", userRecord(101, "new"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert without the column: %v", err)
+	}
+	if _, err := tx.Set("users", row(2, 40), MODE_UPDATE_ONLY); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if _, err := tx.Set("users", userRecord(3, "x"), MODE_UPDATE_ONLY); err == nil {
+		t.Errorf("expected an update without the column to fail")
+	}
+	if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", 4)); err != nil {
+		t.Fatalf("failed to delete an old row: %v", err)
+	}
+	if err := tx.AddColumn("users", "city", TYPE_BYTES, Value{Type: TYPE_BYTES, Str: []byte("none")}); err != nil {
+		t.Fatalf("add column failed: %v", err)
+	}
+	rec := row(102, 50)
+	if _, err := tx.Set("users", *rec.AddStr("city", []byte("Paris")), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	for name, add := range map[string]func() error{
+		"duplicate": func() error { return tx.AddColumn("users", "age", TYPE_INT64, Value{Type: TYPE_INT64}) },
+		"mismatch":  func() error { return tx.AddColumn("users", "x", TYPE_INT64, Value{Type: TYPE_BYTES}) },
+		"missing":   func() error { return tx.AddColumn("nope", "x", TYPE_INT64, Value{Type: TYPE_INT64}) },
+	} {
+		if err := add(); err == nil {
+			t.Errorf("%s: expected the column to be rejected", name)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	want := map[int64]string{2: "40 none", 100: "30 none", 101: "18 none", 102: "50 Paris"}
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	sc, err := db.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		id := rec.Get("id").I64
+		got := fmt.Sprintf("%d %s", rec.Get("age").I64, rec.Get("city").Str)
+		if w, ok := want[id]; ok && got != w || !ok && got != "18 none" {
+			t.Errorf("user %d: unexpected %q", id, got)
+		}
+		n++
+	}
+	if n != 52 {
+		t.Errorf("expected 52 rows, got %d", n)
+	}
+	// the projection of an old row
+	sc = &Scanner{
+		Cmp1:    CMP_GE,
+		Cmp2:    CMP_LE,
+		Key1:    *(&Record{}).AddInt64("id", 1),
+		Key2:    *(&Record{}).AddInt64("id", 1),
+		Project: []string{"city"},
+	}
+	if err := db.Scan("users", sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec = Record{}
+	if !sc.Valid() || sc.Deref(&rec, &reader.Tree) != nil || string(rec.Get("city").Str) != "none" {
+		t.Errorf("expected the default in the projection, got %v", rec)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "accounts",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"id", "name", "email"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}, {"name"}},
+		Unique:  []bool{true},
+	})
+	if err == nil {
+		err = db.Commit(&tx)
+	}
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	account := func(id int64, email string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("name", []byte("same")).AddStr("email", []byte(email))
+	}
+	violation := func(err error, email string) {
+		t.Helper()
+		var unique *UniqueError
+		if !errors.Is(err, ErrUniqueViolation) || !errors.As(err, &unique) {
+			t.Fatalf("expected ErrUniqueViolation, got %v", err)
+		}
+		if unique.Table != "accounts" || strings.Join(unique.Index, ",") != "email" || string(unique.Value.Get("email").Str) != email {
+			t.Errorf("expected the violation on accounts(email) %q, got %v", email, err)
+		}
+	}
+
+	db.Begin(&tx)
+	for id, email := range map[int64]string{1: "a@x", 2: "b@x"} {
+		if _, err := tx.Set("accounts", account(id, email), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	_, err = tx.Set("accounts", account(3, "a@x"), MODE_INSERT_ONLY)
+	violation(err, "a@x")
+	_, err = tx.Set("accounts", account(2, "a@x"), MODE_UPDATE_ONLY)
+	violation(err, "a@x")
+	err = tx.BulkInsert("accounts", []Record{account(10, "c@x"), account(11, "c@x")})
+	violation(err, "c@x")
+	err = tx.BulkInsert("accounts", []Record{account(10, "c@x"), account(11, "b@x")})
+	violation(err, "b@x")
+	// a deleted row frees its value
+	sc := &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddInt64("id", 1),
+		Key2: *(&Record{}).AddInt64("id", 1),
+	}
+	if _, err := tx.DeleteRange("accounts", sc); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := tx.Set("accounts", account(4, "a@x"), MODE_INSERT_ONLY); err != nil {
+		t.Errorf("expected the value of a deleted row to be free, got %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// two transactions with the same value, the second commit fails
+	var tx1, tx2 DBTX
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	if _, err := tx1.Set("accounts", account(20, "z@x"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := tx2.Set("accounts", account(21, "z@x"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx1); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	violation(db.Commit(&tx2), "z@x")
+
+	// in the same group commit
+	db.kv.CommitWindow = 5 * time.Millisecond
+	errs := make(chan error, 2)
+	for _, id := range []int64{30, 31} {
+		go func() {
+			var tx DBTX
+			db.Begin(&tx)
+			if _, err := tx.Set("accounts", account(id, "w@x"), MODE_INSERT_ONLY); err != nil {
+				errs <- err
+				return
+			}
+			errs <- db.Commit(&tx)
+		}()
+	}
+	err1, err2 := <-errs, <-errs
+	if (err1 == nil) == (err2 == nil) {
+		t.Fatalf("expected a single commit, got %v, %v", err1, err2)
+	}
+	violation(errors.Join(err1, err2), "w@x")
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	all, err := db.ScanAll("accounts", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(scanIDs(all, &reader.Tree)); n != 4 {
+		t.Errorf("expected 4 accounts, got %d", n)
+	}
+}
+
 func TestInsertRecord(t *testing.T) {
 	db := setupTestDB(t)
 	defer cleanupTestDB(t, db)
@@ -377,6 +800,150 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdatePartial(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "accounts",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES, TYPE_INT64},
+		Cols:    []string{"id", "email", "name", "score"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}, {"score"}},
+		Unique:  []bool{true, false},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	for id, email := range map[int64]string{1: "a@x", 2: "b@x"} {
+		rec := (&Record{}).AddInt64("id", id).AddStr("email", []byte(email)).AddStr("name", []byte("n")).AddInt64("score", 1)
+		if _, err := tx.Set("accounts", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// only the score index is written
+	db.Begin(&tx)
+	if err := tx.UpdatePartial("accounts", *(&Record{}).AddInt64("id", 1).AddInt64("score", 5)); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	tdef := GetTableDef(db, "accounts", &tx.kv.Tree)
+	row := (&Record{}).AddInt64("id", 1)
+	if ok, err := tx.Get("accounts", row); !ok || err != nil {
+		t.Fatalf("failed to get: %v %v", ok, err)
+	}
+	if got := formatRecord(*row); got != `id=1 email="a@x" name="n" score=5` {
+		t.Errorf("unexpected row %s", got)
+	}
+	keys := indexKeys(tdef, *row)
+	if _, ok := tx.kv.writes[string(keys[0])]; ok {
+		t.Errorf("expected the email index to be left alone")
+	}
+	if _, ok := tx.kv.writes[string(keys[1])]; !ok {
+		t.Errorf("expected the score index to be written")
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	score := *(&Record{}).AddInt64("score", 1)
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: score, Key2: score}
+	if err := reader.Scan("accounts", &sc); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if ids := scanIDs(&sc, &reader.kv.Tree); !equalIDs(ids, []int64{2}) {
+		t.Errorf("expected only row 2 at the old score, got %v", ids)
+	}
+	db.EndRead(&reader)
+
+	for _, tt := range []struct {
+		rec      *Record
+		errorMsg string
+	}{
+		{(&Record{}).AddInt64("id", 3).AddInt64("score", 1), "record not found"},
+		{(&Record{}).AddInt64("id", 1).AddInt64("rank", 1), "column not found: rank"},
+		{(&Record{}).AddInt64("score", 1), "missing primary key column: id"},
+		{(&Record{}).AddInt64("id", 1).AddStr("email", []byte("b@x")), "unique constraint violated"},
+	} {
+		db.Begin(&tx)
+		if err := tx.UpdatePartial("accounts", *tt.rec); err == nil || !isEqual(err.Error(), tt.errorMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", formatRecord(*tt.rec), tt.errorMsg, err)
+		}
+		db.Abort(&tx)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	defer db.Abort(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "accounts",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"id", "email", "name"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}},
+		Unique:  []bool{true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	account := func(id int64, email string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("email", []byte(email)).AddStr("name", []byte("n"))
+	}
+	byEmail := func(email string) []int64 {
+		t.Helper()
+		key := *(&Record{}).AddStr("email", []byte(email))
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+		if err := tx.Scan("accounts", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		return scanIDs(&sc, &tx.kv.Tree)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		rec     Record
+		mode    int
+		created bool
+		err     error
+	}{
+		{"new row", account(1, "a@x"), MODE_UPSERT, true, nil},
+		{"existing row", account(1, "c@x"), MODE_UPSERT, false, nil},
+		{"insert only", account(2, "b@x"), MODE_INSERT_ONLY, true, nil},
+		{"insert only on an existing key", account(1, "d@x"), MODE_INSERT_ONLY, false, ErrExists},
+		{"update only", account(2, "e@x"), MODE_UPDATE_ONLY, false, nil},
+		{"update only on a missing key", account(3, "f@x"), MODE_UPDATE_ONLY, false, ErrNotFound},
+		{"unique violation", account(1, "e@x"), MODE_UPSERT, false, ErrUniqueViolation},
+	} {
+		created, err := tx.Upsert("accounts", tt.rec, tt.mode)
+		if created != tt.created || !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.name, tt.created, tt.err, created, err)
+		}
+	}
+	// the stale index entries are gone & the failed writes left nothing
+	for email, ids := range map[string][]int64{"a@x": nil, "b@x": nil, "c@x": {1}, "d@x": nil, "e@x": {2}, "f@x": nil} {
+		if got := byEmail(email); !equalIDs(got, ids) {
+			t.Errorf("%s: expected ids %v, got %v", email, ids, got)
+		}
+	}
+
+	// a failed lookup fails every mode before the write
+	for _, mode := range []int{MODE_UPSERT, MODE_UPDATE_ONLY, MODE_INSERT_ONLY} {
+		req := InsertReq{Key: make([]byte, BTREE_MAX_KEY_SIZE+1), Value: []byte("v"), Mode: mode}
+		if ok, err := tx.kv.SetWithMode(&req); ok || err == nil || req.Updated || req.Added {
+			t.Errorf("mode %d: expected the lookup error, got %v %v, updated %v added %v", mode, ok, err, req.Updated, req.Added)
+		}
+	}
+}
+
 func TestGet(t *testing.T) {
 	db := setupTestDB(t)
 	defer cleanupTestDB(t, db)
@@ -424,7 +991,7 @@ func TestGet(t *testing.T) {
 				},
 			},
 			expectError: true,
-			errorMsg:    "no index found",
+			errorMsg:    "column not found: invalid_col",
 		},
 	}
 
@@ -453,6 +1020,41 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestLargeValue(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for _, size := range []int{10 << 10, 1 << 20} {
+		name := strings.Repeat("x", size)
+		rec := (&Record{}).AddInt64("id", int64(size)).AddStr("name", []byte(name)).AddStr("email", []byte("big@example.com"))
+
+		var writer KVTX
+		db.kv.Begin(&writer)
+		if _, err := db.Insert("users", *rec, &writer); err != nil {
+			t.Fatalf("failed to insert a %d bytes value: %v", size, err)
+		}
+		if err := db.kv.Commit(&writer); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		got := (&Record{}).AddInt64("id", int64(size))
+		found, err := db.Get("users", got, &reader)
+		if err != nil || !found {
+			t.Fatalf("failed to get a %d bytes value: %v", size, err)
+		}
+		if string(got.Get("name").Str) != name || string(got.Get("email").Str) != "big@example.com" {
+			t.Errorf("the %d bytes value was not read back", size)
+		}
+		if err := reader.Tree.Verify(); err != nil {
+			t.Errorf("invalid tree: %v", err)
+		}
+		db.kv.EndRead(&reader)
+	}
+}
+
 // Helper functions
 func setupTestDB(t *testing.T) *DB {
 	testPath := "test.db"
@@ -460,7 +1062,7 @@ func setupTestDB(t *testing.T) *DB {
 	testDB := &DB{
 		Path:   testPath,
 		kv:     *newKV(testPath),
-		tables: make(map[string]*TableDef),
+		tables: make(map[string]cachedDef),
 		pool:   NewPool(3),
 	}
 
@@ -527,3 +1129,107 @@ func insertTestRecord(t *testing.T, db *DB, id int64) {
 func isEqual(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
+
+func TestColumnConstraints(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	tdef := &TableDef{
+		Name:     "orders",
+		Types:    []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:     []string{"id", "status", "qty", "customer", "note"},
+		PKeys:    1,
+		NotNull:  []bool{false, true, true, true},
+		Defaults: []Value{{}, {Type: TYPE_BYTES, Str: []byte("new")}, {Type: TYPE_INT64, I64: 1}},
+	}
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(tdef)
+	if err == nil {
+		err = db.Commit(&tx)
+	}
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	get := func(id int64) Record {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		rec := (&Record{}).AddInt64("id", id)
+		if ok, err := reader.Get("orders", rec); !ok || err != nil {
+			t.Fatalf("order %d: %v %v", id, ok, err)
+		}
+		return *rec
+	}
+	insert := func(rec *Record) error {
+		db.Begin(&tx)
+		_, err := tx.Set("orders", *rec, MODE_INSERT_ONLY)
+		if err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+
+	// the defaults of a string & an integer column, a nullable column is empty
+	if err := insert((&Record{}).AddInt64("id", 1).AddStr("customer", []byte("ann"))); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	rec := get(1)
+	if string(rec.Get("status").Str) != "new" || rec.Get("qty").I64 != 1 || len(rec.Get("note").Str) != 0 {
+		t.Errorf("expected the defaults, got %s", formatRecord(rec))
+	}
+	if err := insert((&Record{}).AddInt64("id", 2).AddStr("status", []byte("paid")).AddInt64("qty", 5).AddStr("customer", []byte("bob"))); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if rec := get(2); string(rec.Get("status").Str) != "paid" || rec.Get("qty").I64 != 5 {
+		t.Errorf("expected the values over the defaults, got %s", formatRecord(rec))
+	}
+
+	// a missing or null NOT NULL column without a default, a null with one
+	notNull := func(err error, col string) {
+		t.Helper()
+		if !errors.Is(err, ErrNotNull) || !strings.Contains(err.Error(), col) {
+			t.Errorf("expected ErrNotNull on %s, got %v", col, err)
+		}
+	}
+	notNull(insert((&Record{}).AddInt64("id", 3)), "customer")
+	null := (&Record{}).AddInt64("id", 3).AddStr("customer", []byte("cid"))
+	null.Cols, null.Vals = append(null.Cols, "qty"), append(null.Vals, Value{})
+	notNull(insert(null), "qty")
+
+	// updates too
+	rec = get(1)
+	rec.Get("customer").Type, rec.Get("customer").Str = 0, nil
+	db.Begin(&tx)
+	_, err = tx.Set("orders", rec, MODE_UPDATE_ONLY)
+	notNull(err, "customer")
+	rows := []Record{
+		*(&Record{}).AddInt64("id", 4).AddStr("customer", []byte("dan")),
+		*(&Record{}).AddInt64("id", 5),
+	}
+	notNull(tx.BulkInsert("orders", rows), "customer")
+	if err := tx.BulkInsert("orders", rows[:1]); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if rec := get(4); string(rec.Get("status").Str) != "new" || rec.Get("qty").I64 != 1 {
+		t.Errorf("expected the defaults, got %s", formatRecord(rec))
+	}
+
+	// a default of another type
+	db.Begin(&tx)
+	defer db.Abort(&tx)
+	err = tx.TableNew(&TableDef{
+		Name:     "bad",
+		Types:    []uint32{TYPE_INT64, TYPE_INT64},
+		Cols:     []string{"id", "n"},
+		PKeys:    1,
+		Defaults: []Value{{}, {Type: TYPE_BYTES, Str: []byte("x")}},
+	})
+	if err == nil {
+		t.Errorf("expected a default of the wrong type to be rejected")
+	}
+}
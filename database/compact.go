@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// the number of rows bulk loaded per transaction
+const COMPACT_BATCH = 1000
+
+var ErrTxOpen error = errors.New("a transaction is open")
+
+type CompactStats struct {
+	Tables     int // without the internal tables
+	Rows       int
+	SizeBefore int64 // file sizes in bytes
+	SizeAfter  int64
+}
+
+// rewrite the DB file without the free pages: the tables are copied through
+// the catalog into a new file in key order, along with their indexes, and the
+// new file replaces the old one once it is complete. a crash leaves either
+// file in place. fails with ErrTxOpen while a transaction or a reader is open,
+// they hold pages of the old file. the ones begun during the swap wait for it.
+func (db *DB) Compact() (CompactStats, error) {
+	return db.CompactContext(context.Background())
+}
+
+// Compact stopped once ctx is done, with the error of ctx. the copy is
+// removed & the old file stays in place.
+func (db *DB) CompactContext(ctx context.Context) (CompactStats, error) {
+	kv := &db.kv
+	stats := CompactStats{}
+	if kv.mem != nil || kv.ReadOnly {
+		return stats, errors.New("compact: not a writable DB file")
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	if !kv.writer.TryLock() {
+		return stats, ErrTxOpen
+	}
+	defer kv.writer.Unlock()
+	kv.mu.Lock()
+	readers := len(kv.readers)
+	kv.mu.Unlock()
+	if readers > 0 {
+		return stats, ErrTxOpen
+	}
+
+	// the log of the old file must not be replayed on the new one
+	if err := checkpoint(kv); err != nil {
+		return stats, fmt.Errorf("compact: %w", err)
+	}
+	stats.SizeBefore = int64(kv.mmap.file)
+	tmp := kv.Path + ".compact"
+	if err := compactCopy(ctx, db, tmp, &stats); err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(walPath(tmp))
+		return stats, fmt.Errorf("compact: %w", err)
+	}
+
+	// the readers begun during the copy hold pages of the old file, the new
+	// ones wait for the swap
+	kv.mu.Lock()
+	if len(kv.readers) > 0 {
+		kv.mu.Unlock()
+		_ = os.Remove(tmp)
+		return stats, ErrTxOpen
+	}
+	swap := make(chan struct{})
+	kv.swap = swap
+	kv.mu.Unlock()
+	defer func() {
+		kv.mu.Lock()
+		kv.swap = nil
+		kv.mu.Unlock()
+		close(swap)
+	}()
+
+	// the old file is closed first, it cannot be renamed over while open on windows
+	kv.Close()
+	err := os.Rename(tmp, kv.Path)
+	if err == nil {
+		syncDir(filepath.Dir(kv.Path))
+	}
+	if oerr := kv.Open(); err == nil {
+		err = oerr
+	}
+	if err != nil {
+		return stats, fmt.Errorf("compact: %w", err)
+	}
+	stats.SizeAfter = int64(kv.mmap.file)
+	return stats, nil
+}
+
+// copy the live rows of every table into a new DB file at `path`, the scans
+// stop once ctx is done
+func compactCopy(ctx context.Context, db *DB, path string, stats *CompactStats) error {
+	// left by a compaction that did not finish
+	_ = os.Remove(path)
+	_ = os.Remove(walPath(path))
+	out := &DB{Path: path, kv: KV{Path: path, Sync: db.kv.Sync}, tables: make(map[string]cachedDef)}
+	if err := out.kv.Open(); err != nil {
+		return err
+	}
+	defer out.kv.Close()
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	tree := &reader.Tree
+	tree.ctx = ctx
+
+	// the catalog is copied first & as is but for the bloom filters, the
+	// tables keep their prefixes
+	tdefs := []*TableDef{TDEF_META, TDEF_TABLE}
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, TDEF_TABLE, &sc, tree)
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, tree); err != nil {
+			return err
+		}
+		name := string(rec.Get("name").Str)
+		if name == TDEF_META.Name || name == TDEF_TABLE.Name {
+			continue // the internal tables are listed too, copied above
+		}
+		tdef := getTableDefDB(db, name, tree)
+		if tdef == nil {
+			return fmt.Errorf("bad table definition: %s", name)
+		}
+		tdefs = append(tdefs, tdef)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	for _, tdef := range tdefs {
+		n, err := compactTable(db, out, tdef, tree)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", tdef.Name, err)
+		}
+		stats.Rows += n
+	}
+	stats.Tables = len(tdefs) - 2
+	// the new file must be complete before it replaces the old one
+	return checkpoint(&out.kv)
+}
+
+// bulk load the rows of a table in batches, the indexes are rebuilt from the rows
+func compactTable(db *DB, out *DB, tdef *TableDef, tree *BTree) (int, error) {
+	rows := make([]Record, 0, COMPACT_BATCH)
+	flush := func() error {
+		var tx KVTX
+		out.kv.Begin(&tx)
+		if err := dbBulkInsert(out, tdef, rows, &tx); err != nil {
+			out.kv.Abort(&tx)
+			return err
+		}
+		rows = rows[:0]
+		tx.rows = nil // the counts are copied with the meta table
+		return out.kv.Commit(&tx)
+	}
+
+	count := 0
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, tdef, &sc, tree)
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, tree); err != nil {
+			return count, err
+		}
+		if tdef == TDEF_META && bloomRow(bloomMetaKey(string(rec.Get("key").Str))) {
+			continue // the filters of the old file, see bloomLoad
+		}
+		rows = append(rows, rec)
+		count++
+		if len(rows) == COMPACT_BATCH {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return count, err
+	}
+	if len(rows) > 0 {
+		return count, flush()
+	}
+	return count, nil
+}
+
+// persist a rename, not supported on every platform
+func syncDir(dir string) {
+	if fp, err := os.Open(dir); err == nil {
+		_ = fp.Sync()
+		_ = fp.Close()
+	}
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	db := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.kv.Close()
+	if err := initializeInternalTables(db); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+	setupTestTable(t, db)
+	setupIndexedTable(t, db)
+	emails := []string{"c@x", "a@x", "e@x", "b@x", "d@x"}
+	for i, email := range emails {
+		insertIndexedRecord(t, db, int64(i+1), email)
+	}
+
+	// most of the file is freed by the deletes
+	bio := bytes.Repeat([]byte("x"), 500)
+	var tx DBTX
+	db.Begin(&tx)
+	for id := int64(1); id <= 3000; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddStr("name", bio).AddStr("email", []byte("john@example.com"))
+		if _, err := tx.Set("users", *rec, MODE_UPSERT); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.Begin(&tx)
+	for id := int64(1); id <= 3000; id++ {
+		if id%10 == 0 {
+			continue
+		}
+		if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", id)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// refused while a transaction or a reader is open
+	db.Begin(&tx)
+	if _, err := db.Compact(); !errors.Is(err, ErrTxOpen) {
+		t.Errorf("expected ErrTxOpen with a transaction, got %v", err)
+	}
+	db.Abort(&tx)
+	var reader DBReader
+	db.BeginRead(&reader)
+	if _, err := db.Compact(); !errors.Is(err, ErrTxOpen) {
+		t.Errorf("expected ErrTxOpen with a reader, got %v", err)
+	}
+	db.EndRead(&reader)
+
+	// stopped in the middle of the copy, the copy is removed & the old file stays
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if _, err := db.CompactContext(&countdownCtx{Context: context.Background(), n: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != before.Size() {
+		t.Errorf("expected the file size %d, got %v %v", before.Size(), fi, err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected no copy, got %v", err)
+	}
+
+	stats, err := db.Compact()
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if stats.Tables != 2 || stats.Rows < 305 {
+		t.Errorf("expected 2 tables & the rows left, got %+v", stats)
+	}
+	if stats.SizeAfter*2 > stats.SizeBefore {
+		t.Errorf("expected the file to shrink, got %+v", stats)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != stats.SizeAfter {
+		t.Errorf("expected the file size %d, got %v %v", stats.SizeAfter, fi, err)
+	}
+	for _, name := range []string{path + ".compact", walPath(path + ".compact")} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected no file %q, got %v", name, err)
+		}
+	}
+
+	check := func(db *DB, want string) {
+		t.Helper()
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+		if err := reader.Tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		if _, errs := reader.VerifyPages(); len(errs) != 0 {
+			t.Fatalf("corrupt pages: %v", errs)
+		}
+		sc, err := db.ScanAll("users", &reader.Tree)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := scanIDs(sc, &reader.Tree); len(got) != 300 || got[0] != 10 || got[299] != 3000 {
+			t.Errorf("expected every 10th user, got %d ids", len(got))
+		}
+		// the index is rebuilt
+		sc = &Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddStr("email", []byte("a@x")),
+			Key2: *(&Record{}).AddStr("email", []byte("z@x")),
+		}
+		if err := db.Scan("people", sc, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, string(rec.Get("email").Str))
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("expected the index scan in order, got %v", got)
+		}
+	}
+	check(db, "a@x,b@x,c@x,d@x,e@x")
+
+	// the new file is in use after a reopen
+	insertIndexedRecord(t, db, 6, "f@x")
+	db.kv.Close()
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	check(db, "a@x,b@x,c@x,d@x,e@x,f@x")
+}
+
+// starts a reader the first time the DB file is synced during a swap
+type swapReader struct {
+	osFileOps
+	db   *DB
+	once sync.Once
+	read chan error
+	done chan struct{} // closed once the compaction returned
+}
+
+func (r *swapReader) Sync(fp *os.File) error {
+	r.db.kv.mu.Lock()
+	swapping := r.db.kv.swap != nil && fp == r.db.kv.fp
+	r.db.kv.mu.Unlock()
+	if swapping {
+		r.once.Do(func() {
+			go func() {
+				var reader DBReader
+				r.db.BeginRead(&reader)
+				defer r.db.EndRead(&reader)
+				<-r.done
+				sc, err := r.db.ScanAll("users", &reader.kv.Tree)
+				if err == nil {
+					if got := scanIDs(sc, &reader.kv.Tree); len(got) != 500 {
+						err = fmt.Errorf("expected 500 users, got %d", len(got))
+					}
+				}
+				r.read <- err
+			}()
+			// the reader begins before the old file is closed
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+	return r.osFileOps.Sync(fp)
+}
+
+// calls fn on every check after the first one
+type hookCtx struct {
+	context.Context
+	calls int
+	fn    func()
+}
+
+func (c *hookCtx) Err() error {
+	if c.calls++; c.calls > 1 && c.fn != nil {
+		c.fn()
+		c.fn = nil
+	}
+	return c.Context.Err()
+}
+
+// the readers begun during the copy fail the swap, the ones begun during the
+// swap wait for the new file
+func TestCompactConcurrentReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	files := &swapReader{read: make(chan error, 1), done: make(chan struct{})}
+	db := &DB{Path: path, kv: KV{Path: path, Files: files}, tables: make(map[string]cachedDef)}
+	files.db = db
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.kv.Close()
+	if err := initializeInternalTables(db); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+	setupTestTable(t, db)
+	var tx DBTX
+	db.Begin(&tx)
+	for id := int64(1); id <= 500; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("john")).AddStr("email", []byte("john@example.com"))
+		if _, err := tx.Set("users", *rec, MODE_UPSERT); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	ctx := &hookCtx{Context: context.Background(), fn: func() { db.BeginRead(&reader) }}
+	if _, err := db.CompactContext(ctx); !errors.Is(err, ErrTxOpen) {
+		t.Fatalf("expected ErrTxOpen with a reader begun during the copy, got %v", err)
+	}
+	db.EndRead(&reader)
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected no copy, got %v", err)
+	}
+
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	close(files.done)
+	select {
+	case err := <-files.read:
+		if err != nil {
+			t.Errorf("reader: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reader did not begin after the swap")
+	}
+}
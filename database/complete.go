@@ -0,0 +1,93 @@
+package database
+
+import (
+	"atomixDB/database/helper"
+	"sort"
+	"strings"
+)
+
+// the commands asking for a table first, completed with the tables after
+// their name
+var tableCommands = map[string]bool{
+	"drop": true, "rename": true, "insert": true, "delete": true, "get": true, "scan": true,
+	"update": true, "upsert": true, "stats": true, "desc": true, "import": true, "export": true,
+	"analyze": true, "check": true,
+}
+
+// the words of SQL followed by a table
+var tableKeywords = map[string]bool{"from": true, "into": true, "update": true, "join": true, "table": true}
+
+// the completions at a terminal of the word before the cursor, by what the
+// line answers: a command at the start of a line, and a table after the
+// commands of tableCommands & the words of tableKeywords; a table; or a
+// column of the table of the command. the catalog is read at each Tab, in
+// the open transaction, so the tables created or dropped are completed
+// right away.
+func replCompletions(db *DB, tx *DBTX, before string) (string, []string) {
+	switch helper.Answer {
+	case helper.ANSWER_COMMAND:
+		words := strings.Fields(before)
+		word := before[strings.LastIndex(before, " ")+1:]
+		if len(words) == 0 || (len(words) == 1 && word != "") {
+			names := []string{"exit"}
+			for name := range RegisterCommands() {
+				names = append(names, name)
+			}
+			return word, matching(names, strings.ToLower(word))
+		}
+		prev := len(words) - 1
+		if word != "" {
+			prev--
+		}
+		if (prev == 0 && tableCommands[strings.ToLower(words[0])]) || tableKeywords[strings.ToLower(words[prev])] {
+			return word, matching(completionTables(db, tx), word)
+		}
+	case helper.ANSWER_TABLE:
+		word := before[strings.LastIndex(before, " ")+1:]
+		return word, matching(completionTables(db, tx), word)
+	case helper.ANSWER_COLUMNS:
+		word := before[strings.LastIndexAny(before, " ,+")+1:]
+		cols := helper.AnswerCols
+		if cols == nil {
+			withReader(db, tx, func(tree *BTree) {
+				if tdef := GetTableDef(db, helper.AnswerTable, tree); tdef != nil {
+					cols = tdef.Cols
+				}
+			})
+		}
+		return word, matching(cols, word)
+	}
+	return "", nil
+}
+
+func completionTables(db *DB, tx *DBTX) []string {
+	var names []string
+	withReader(db, tx, func(tree *BTree) {
+		names, _ = db.ListTables(tree)
+	})
+	return names
+}
+
+// runs read with the tree of the open transaction, or of a snapshot
+func withReader(db *DB, tx *DBTX, read func(tree *BTree)) {
+	if tx != nil && tx.Err() == nil {
+		read(&tx.kv.Tree)
+		return
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	read(&reader.Tree)
+}
+
+// the names starting with the prefix, sorted
+func matching(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
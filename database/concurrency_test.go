@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writers, deleters & readers on the same table at once, run with -race
+func TestConcurrentDB(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "concurrent.db"), WithCacheSize(64*BTREE_PAGE_SIZE))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	err = db.Transact(func(tx *DBTX) error {
+		return tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "qty", "name"},
+			PKeys: 1,
+		})
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	const writers, rows = 4, 100
+	retry := func(write func(tx *DBTX) error) error {
+		for {
+			err := db.Transact(write)
+			if !errors.Is(err, ErrConflict) {
+				return err
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+3)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				id := int64(w*rows + i)
+				err := retry(func(tx *DBTX) error {
+					rec := (&Record{}).AddInt64("id", id).AddInt64("qty", id%7).AddStr("name", []byte("x"))
+					_, err := tx.Set("items", *rec, MODE_INSERT_ONLY)
+					return err
+				})
+				if err == nil && i%4 == 0 {
+					err = retry(func(tx *DBTX) error {
+						_, err := tx.Delete("items", *(&Record{}).AddInt64("id", id))
+						return err
+					})
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	// the table definitions are cached & uncached meanwhile
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			name := fmt.Sprintf("other%d", i)
+			err := db.Transact(func(tx *DBTX) error {
+				return tx.TableNew(&TableDef{Name: name, Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "v"}, PKeys: 1})
+			})
+			if err == nil && i%2 == 0 {
+				err = db.Transact(func(tx *DBTX) error { return tx.DropTable(name) })
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				err := db.View(func(tx *DBReader) error {
+					// a snapshot counts as many rows as it scans
+					all, err := tx.Count("items", &Scanner{})
+					if err != nil {
+						return err
+					}
+					counted, err := tx.RowCount("items")
+					if err == nil && counted != all {
+						err = errors.New("the row count & the rows disagree")
+					}
+					if err == nil {
+						_, err = tx.Get("items", (&Record{}).AddInt64("id", 1))
+					}
+					return err
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent operation: %v", err)
+	}
+
+	db.View(func(tx *DBReader) error {
+		if err := tx.Tree().Verify(); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		if err := db.CheckRowCounts(tx.Tree()); err != nil {
+			t.Errorf("row counts: %v", err)
+		}
+		want := int64(writers * rows * 3 / 4)
+		if n, err := tx.RowCount("items"); err != nil || n != want {
+			t.Errorf("expected %d rows, got %d %v", want, n, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var ErrConflict error = errors.New("write conflict")
+
+// a commit rejected because a transaction that committed after it began
+// wrote one of its keys, the first committer wins. the transaction can be
+// retried from the start. matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Table string // empty if the key is not in a table
+	PKey  Record // the primary key of the row
+	Key   []byte // the KV key
+	keys  [][]byte
+}
+
+func (e *ConflictError) Error() string {
+	if e.Table == "" {
+		return fmt.Sprintf("%v on key %q", ErrConflict, e.Key)
+	}
+	return fmt.Sprintf("%v on table %s, primary key %s", ErrConflict, e.Table, formatRecord(e.PKey))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// the keys written by a commit
+type commitKeys struct {
+	version uint64 // the version made by the commit
+	keys    map[string]struct{}
+}
+
+// the keys written by the transaction & by the commits since it began, under the writer lock.
+// batch is the keys of the commits applied before it in the same batch.
+func commitCheck(kv *KV, tx *KVTX, batch map[string]struct{}) error {
+	var keys [][]byte
+	for key := range tx.writes {
+		if _, ok := batch[key]; ok {
+			keys = append(keys, []byte(key))
+			continue
+		}
+		for _, commit := range kv.history {
+			if _, ok := commit.keys[key]; ok && commit.version > tx.since(key) {
+				keys = append(keys, []byte(key))
+				break
+			}
+		}
+	}
+	// a truncated table conflicts with any key written under it since
+	truncated := func(key string) bool {
+		return len(key) >= 4 && slices.Contains(tx.truncated, binary.BigEndian.Uint32([]byte(key)))
+	}
+	if len(tx.truncated) > 0 {
+		for key := range batch {
+			if truncated(key) {
+				keys = append(keys, []byte(key))
+			}
+		}
+		for _, commit := range kv.history {
+			for key := range commit.keys {
+				if commit.version > tx.version && truncated(key) {
+					keys = append(keys, []byte(key))
+				}
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return &ConflictError{Key: keys[0], keys: keys}
+}
+
+// whether a commit after the transaction began wrote the key
+func commitChanged(kv *KV, tx *KVTX, key []byte) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for _, commit := range kv.history {
+		if _, ok := commit.keys[string(key)]; ok && commit.version > tx.since(string(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// the version the key was read from
+func (tx *KVTX) since(key string) uint64 {
+	if version, ok := tx.refreshed[key]; ok {
+		return version
+	}
+	return tx.version
+}
+
+// keep the keys of a commit for the open transactions, under kv.mu.
+// the commits none of them can conflict with are dropped.
+func commitRecord(kv *KV, keys map[string]struct{}) {
+	kv.history = append(kv.history, commitKeys{kv.version, keys})
+	oldest := kv.version
+	if len(kv.readers) > 0 {
+		oldest = kv.readers[0].version
+	}
+	i := 0
+	for i < len(kv.history) && kv.history[i].version <= oldest {
+		i++
+	}
+	kv.history = append(kv.history[:0], kv.history[i:]...)
+}
+
+// name the table & the primary key of a conflict, a row of a table is
+// preferred to an index key
+func conflictResolve(db *DB, conflict *ConflictError) {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	tdefs, indexes := prefixDefs(db, &reader.Tree)
+
+	found := false
+	for _, key := range conflict.keys {
+		if len(key) < 4 {
+			continue
+		}
+		prefix := binary.BigEndian.Uint32(key)
+		tdef := tdefs[prefix]
+		if tdef == nil {
+			continue
+		}
+		pkey := Record{}
+		if i, ok := indexes[prefix]; ok {
+			if found {
+				continue
+			}
+			index := tdef.Indexes[i]
+			ival := make([]Value, len(index))
+			for j, col := range index {
+				ival[j].Type = tdef.Types[ColIndex(tdef, col)]
+			}
+			decodeValues(key[4:], ival)
+			irec := Record{index, ival}
+			for _, col := range tdef.Cols[:tdef.PKeys] {
+				pkey.Cols = append(pkey.Cols, col)
+				pkey.Vals = append(pkey.Vals, *irec.Get(col))
+			}
+		} else {
+			pkey.Cols = tdef.Cols[:tdef.PKeys]
+			pkey.Vals = make([]Value, tdef.PKeys)
+			for j := range pkey.Vals {
+				pkey.Vals[j].Type = tdef.Types[j]
+			}
+			decodeValues(key[4:], pkey.Vals)
+		}
+		conflict.Table, conflict.PKey, conflict.Key = tdef.Name, pkey, key
+		found = true
+		if _, ok := indexes[prefix]; !ok {
+			return
+		}
+	}
+}
+
+// the table of each key prefix, & the index number of the index prefixes
+func prefixDefs(db *DB, tree *BTree) (map[uint32]*TableDef, map[uint32]int) {
+	tdefs := map[uint32]*TableDef{}
+	indexes := map[uint32]int{}
+	for _, tdef := range append([]*TableDef{TDEF_META, TDEF_TABLE}, catalogTables(db, tree)...) {
+		tdefs[tdef.Prefix] = tdef
+		for i, prefix := range tdef.IndexPrefix {
+			tdefs[prefix] = tdef
+			indexes[prefix] = i
+		}
+	}
+	return tdefs, indexes
+}
+
+// the definitions of the tables in the catalog, without the internal tables
+func catalogTables(db *DB, tree *BTree) []*TableDef {
+	var tdefs []*TableDef
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, TDEF_TABLE, &sc, tree)
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, tree); err != nil {
+			break
+		}
+		name := string(rec.Get("name").Str)
+		if name == TDEF_META.Name || name == TDEF_TABLE.Name {
+			continue
+		}
+		if tdef := getTableDefDB(db, name, tree); tdef != nil {
+			tdefs = append(tdefs, tdef)
+		}
+	}
+	return tdefs
+}
+
+// col=value pairs, e.g. id=1 name="a"
+func formatRecord(rec Record) string {
+	parts := make([]string, len(rec.Cols))
+	for i, col := range rec.Cols {
+		switch v := rec.Vals[i]; {
+		case v.Type == TYPE_BYTES && !v.Null:
+			parts[i] = fmt.Sprintf("%s=%q", col, v.Str)
+		default:
+			parts[i] = fmt.Sprintf("%s=%s", col, formatValue(v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
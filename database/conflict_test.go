@@ -0,0 +1,229 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestCommitConflict(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 3; id++ {
+		insertTestRecord(t, db, id)
+	}
+	update := func(tx *DBTX, id int64, name string) {
+		t.Helper()
+		if _, err := tx.Set("users", userRecord(id, name), MODE_UPDATE_ONLY); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+	}
+	name := func(id int64) string {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		rec := (&Record{}).AddInt64("id", id)
+		if ok, err := reader.Get("users", rec); !ok || err != nil {
+			t.Fatalf("user %d: %v %v", id, ok, err)
+		}
+		return string(rec.Get("name").Str)
+	}
+
+	// the same row, the first commit wins
+	var tx1, tx2 DBTX
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	update(&tx1, 1, "first")
+	update(&tx2, 1, "second")
+	if err := db.Commit(&tx1); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	err := db.Commit(&tx2)
+	var conflict *ConflictError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if conflict.Table != "users" || conflict.PKey.Get("id") == nil || conflict.PKey.Get("id").I64 != 1 {
+		t.Errorf("expected the conflict on users id=1, got %v", err)
+	}
+	if got := name(1); got != "first" {
+		t.Errorf("expected the first commit to stay, got %q", got)
+	}
+
+	// a row & its index keys, the row is reported
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	for _, tx := range []*DBTX{&tx1, &tx2} {
+		if _, err := tx.Set("people", userRecord(7, "x"), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx2); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	err = db.Commit(&tx1)
+	if !errors.As(err, &conflict) || conflict.Table != "people" || conflict.PKey.Get("id").I64 != 7 {
+		t.Errorf("expected the conflict on people id=7, got %v", err)
+	}
+
+	// different rows do not conflict, both commits are kept
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	update(&tx1, 2, "first")
+	update(&tx2, 3, "second")
+	if _, err := tx2.Set("users", userRecord(4, "new"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx1); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Commit(&tx2); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	for id, want := range map[int64]string{1: "first", 2: "first", 3: "second", 4: "new"} {
+		if got := name(id); got != want {
+			t.Errorf("user %d: expected %q, got %q", id, want, got)
+		}
+	}
+
+	// a transaction that begins after the commit sees it
+	db.Begin(&tx1)
+	update(&tx1, 1, "later")
+	db.Begin(&tx2)
+	update(&tx2, 2, "other")
+	if err := db.Commit(&tx2); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Commit(&tx1); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.Begin(&tx2)
+	update(&tx2, 1, "after")
+	if err := db.Commit(&tx2); err != nil {
+		t.Errorf("expected no conflict with an earlier commit, got %v", err)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+}
+
+// concurrent appends to a row, retried on conflicts, none are lost
+func TestCommitConflictRetry(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	insertTestRecord(t, db, 1)
+	var reader DBReader
+	db.BeginRead(&reader)
+	GetTableDef(db, "users", &reader.kv.Tree) // cached before the goroutines
+	db.EndRead(&reader)
+
+	const workers, appends = 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < appends; {
+				var tx DBTX
+				db.Begin(&tx)
+				rec := (&Record{}).AddInt64("id", 1)
+				_, err := db.Get("users", rec, &tx.kv.KVReader)
+				if err == nil {
+					name := string(rec.Get("name").Str) + "x"
+					_, err = tx.Set("users", userRecord(1, name), MODE_UPDATE_ONLY)
+				}
+				if err != nil {
+					db.Abort(&tx)
+					errs <- err
+					return
+				}
+				switch err := db.Commit(&tx); {
+				case err == nil:
+					i++
+				case !errors.Is(err, ErrConflict):
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	rec := (&Record{}).AddInt64("id", 1)
+	if ok, err := reader.Get("users", rec); !ok || err != nil {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	if got, want := len(rec.Get("name").Str), len("John")+workers*appends; got != want {
+		t.Errorf("expected %d bytes after the appends, got %d", want, got)
+	}
+}
+
+// the keys of each commit are replayed on the latest tree, new ones among
+// updates & deletes
+func TestCommitReplay(t *testing.T) {
+	kv := newKV(MEMORY_PATH)
+	if err := kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer kv.Close()
+	rng := rand.New(rand.NewSource(1))
+	want := map[string][]byte{}
+	key := func() []byte { return []byte(fmt.Sprintf("k%05d", rng.Intn(10000))) }
+	for step := 0; step < 2000; step++ {
+		var tx KVTX
+		kv.Begin(&tx)
+		written := map[string][]byte{}
+		for n := 1 + rng.Intn(30); n > 0; n-- {
+			k := key()
+			if rng.Intn(4) == 0 {
+				tx.Del(&DeleteReq{Key: k})
+				written[string(k)] = nil
+				continue
+			}
+			val := bytes.Repeat([]byte{byte('a' + step%26)}, rng.Intn(601))
+			tx.Update(&InsertReq{Key: k, Value: val})
+			written[string(k)] = val
+		}
+		if err := kv.Commit(&tx); err != nil {
+			t.Fatalf("step %d: failed to commit: %v", step, err)
+		}
+		for k, v := range written {
+			if v == nil {
+				delete(want, k)
+			} else {
+				want[k] = v
+			}
+		}
+	}
+
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		k := fmt.Sprintf("k%05d", i)
+		val, ok, err := reader.Tree.Get([]byte(k))
+		if err != nil || ok != (want[k] != nil) || !bytes.Equal(val, want[k]) {
+			t.Fatalf("%s: expected %d bytes, got %d %v %v", k, len(want[k]), len(val), ok, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// the kinds of a Problem between a secondary index & the rows
+const (
+	PROBLEM_DANGLING = 1 // the entry points at a row that does not exist
+	PROBLEM_STALE    = 2 // the row exists, its indexed values differ from the entry
+	PROBLEM_MISSING  = 3 // the row has no entry in the index
+)
+
+// an index entry that disagrees with the rows, see CheckConsistency
+type Problem struct {
+	Table string
+	Index string // the columns of the index, joined by commas as in DESC
+	Key   []byte // the encoded index entry, the one expected for PROBLEM_MISSING
+	PKey  []byte // the encoded primary key of the row
+	Kind  int
+}
+
+func (p Problem) String() string {
+	kind := map[int]string{
+		PROBLEM_DANGLING: "dangling entry", PROBLEM_STALE: "stale entry", PROBLEM_MISSING: "missing entry",
+	}[p.Kind]
+	return fmt.Sprintf("%s index (%s): %s %x of the row %x", p.Table, p.Index, kind, p.Key, p.PKey)
+}
+
+// compare the secondary indexes of the table with its rows: each entry must
+// point at a row whose indexed values it has, and each row must have its
+// entry in each index. the problems are in the order of the indexes, then
+// of the keys, nil if there are none.
+//
+// Deprecated: use DBTX.CheckConsistency or DBReader.CheckConsistency.
+func (db *DB) CheckConsistency(table string, tree *BTree) ([]Problem, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	return consistencyCheck(tdef, tree)
+}
+
+// delete the dangling & stale entries of the indexes of the table & insert
+// the missing ones, returns the problems repaired. they are all found
+// before any is repaired, a check stopped by the context of the transaction
+// repairs none.
+//
+// Deprecated: use DBTX.Repair.
+func (db *DB) Repair(table string, kvtx *KVTX) ([]Problem, error) {
+	if kvtx.kv.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	problems, err := consistencyCheck(tdef, &kvtx.Tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range problems {
+		if p.Kind == PROBLEM_MISSING {
+			kvtx.Update(&InsertReq{Key: p.Key})
+		} else {
+			kvtx.Del(&DeleteReq{Key: p.Key})
+		}
+	}
+	return problems, nil
+}
+
+func consistencyCheck(tdef *TableDef, tree *BTree) ([]Problem, error) {
+	var problems []Problem
+	row := make([]Value, len(tdef.Cols))
+	read := 0 // for the context of the tree, see Scanner.SetContext
+	// decode the primary row of the key, false if there is none
+	fetch := func(pkey []byte) (bool, error) {
+		if read++; read%CTX_CHECK_ROWS == 0 {
+			if err := tree.ctxErr(); err != nil {
+				return false, err
+			}
+		}
+		val, ok, err := tree.Get(pkey)
+		if err != nil || !ok {
+			return false, err
+		}
+		for i := range row {
+			row[i] = Value{Type: tdef.Types[i]}
+		}
+		decodeValues(pkey[4:], row[:tdef.PKeys])
+		decodeRow(tdef, val, row[tdef.PKeys:])
+		return true, nil
+	}
+
+	// the entries of each index point at a row with their values
+	for i, index := range tdef.Indexes {
+		ivals := make([]Value, len(index))
+		for j, col := range index {
+			ivals[j] = Value{Type: tdef.Types[ColIndex(tdef, col)]}
+		}
+		var err error
+		prefixScan(tree, binary.BigEndian.AppendUint32(nil, tdef.IndexPrefix[i]), func(key []byte) bool {
+			key = bytes.Clone(key)
+			if decodeValues(key[4:], ivals) != len(ivals) {
+				err = fmt.Errorf("%s index (%s): bad entry %x", tdef.Name, strings.Join(index, ","), key)
+				return false
+			}
+			irec := Record{index, ivals}
+			pk := make([]Value, tdef.PKeys)
+			for j := range pk {
+				pk[j] = *irec.Get(tdef.Cols[j])
+			}
+			pkey := encodeKey(nil, tdef.Prefix, pk)
+			var found bool
+			if found, err = fetch(pkey); err != nil {
+				return false
+			}
+			kind := PROBLEM_DANGLING
+			if found {
+				if bytes.Equal(indexKeys(tdef, Record{tdef.Cols, row})[i], key) {
+					return true
+				}
+				kind = PROBLEM_STALE
+			}
+			problems = append(problems, Problem{tdef.Name, strings.Join(index, ","), key, pkey, kind})
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// each row has its entry in each index
+	if len(tdef.Indexes) == 0 {
+		return problems, nil
+	}
+	var err error
+	prefixScan(tree, binary.BigEndian.AppendUint32(nil, tdef.Prefix), func(pkey []byte) bool {
+		pkey = bytes.Clone(pkey)
+		if _, err = fetch(pkey); err != nil {
+			return false
+		}
+		for i, key := range indexKeys(tdef, Record{tdef.Cols, row}) {
+			var ok bool
+			if _, ok, err = tree.Get(key); err != nil {
+				return false
+			}
+			if !ok {
+				index := strings.Join(tdef.Indexes[i], ",")
+				problems = append(problems, Problem{tdef.Name, index, key, pkey, PROBLEM_MISSING})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
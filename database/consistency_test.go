@@ -0,0 +1,113 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	err := db.Transact(func(tx *DBTX) error {
+		err := tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64}, Cols: []string{"id", "name", "qty"},
+			PKeys: 1, Indexes: [][]string{{"name"}, {"qty"}},
+		})
+		for i := int64(0); err == nil && i < 10; i++ {
+			rec := (&Record{}).AddInt64("id", i).AddStr("name", []byte{'a' + byte(i)}).AddInt64("qty", i*10)
+			_, err = tx.Set("items", *rec, MODE_INSERT_ONLY)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	check := func() []Problem {
+		var problems []Problem
+		err := db.View(func(tx *DBReader) (err error) {
+			problems, err = tx.CheckConsistency("items")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		return problems
+	}
+	if problems := check(); problems != nil {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	// break the indexes under the table: an entry of a row that does not
+	// exist, a row without its entry & a row changed without its entries
+	var tdef *TableDef
+	err = db.Transact(func(tx *DBTX) error {
+		tdef = GetTableDef(db, "items", tx.Tree())
+		row := func(id int64, name string, qty int64) Record {
+			return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).AddInt64("qty", qty)
+		}
+		kv := &tx.kv
+		kv.Update(&InsertReq{Key: indexKeys(tdef, row(42, "z", 420))[0]})
+		kv.Del(&DeleteReq{Key: indexKeys(tdef, row(3, "d", 30))[1]})
+		kv.Update(&InsertReq{
+			Key:   encodeKey(nil, tdef.Prefix, []Value{{Type: TYPE_INT64, I64: 5}}),
+			Value: encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: []byte("y")}, {Type: TYPE_INT64, I64: 50}}),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("corrupt: %v", err)
+	}
+	pkey := func(id int64) string {
+		return string(encodeKey(nil, tdef.Prefix, []Value{{Type: TYPE_INT64, I64: id}}))
+	}
+	problems := check()
+	want := []struct {
+		index string
+		kind  int
+		pkey  string
+	}{
+		{"name,id", PROBLEM_STALE, pkey(5)},
+		{"name,id", PROBLEM_DANGLING, pkey(42)},
+		{"qty,id", PROBLEM_MISSING, pkey(3)},
+		{"name,id", PROBLEM_MISSING, pkey(5)},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if p := problems[i]; p.Table != "items" || p.Index != w.index || p.Kind != w.kind || string(p.PKey) != w.pkey || len(p.Key) == 0 {
+			t.Errorf("problem %d: expected %+v, got %v", i, w, p)
+		}
+	}
+	if s := problems[1].String(); !strings.HasPrefix(s, "items index (name,id): dangling entry ") {
+		t.Errorf("unexpected description %q", s)
+	}
+
+	// REPAIR in the REPL
+	stdout, stderr := captureOutput(t, func() { repl(db, strings.NewReader("check\n\ncheck items repair\ncheck items\n"), true) })
+	if !strings.Contains(stderr, "Database is inconsistent: 4 index problems") || strings.Count(stdout, "Repaired items index") != 4 ||
+		!strings.HasSuffix(stdout, "Database is consistent.\n") {
+		t.Errorf("expected the problems found & repaired, got %q %q", stdout, stderr)
+	}
+	if problems := check(); problems != nil {
+		t.Fatalf("expected no problems after the repair, got %v", problems)
+	}
+	db.View(func(tx *DBReader) error {
+		for _, tt := range []struct {
+			col  string
+			val  Value
+			want int64
+		}{
+			{"name", Value{Type: TYPE_BYTES, Str: []byte("f")}, 0},
+			{"name", Value{Type: TYPE_BYTES, Str: []byte("y")}, 1},
+			{"name", Value{Type: TYPE_BYTES, Str: []byte("z")}, 0},
+			{"qty", Value{Type: TYPE_INT64, I64: 30}, 1},
+		} {
+			n, err := tx.Count("items", &Scanner{Filter: CompareExpr(ColumnExpr(tt.col), CMP_EQ, LiteralExpr(tt.val))})
+			if err != nil || n != tt.want {
+				t.Errorf("%s = %v: expected %d rows, got %d %v", tt.col, tt.val, tt.want, n, err)
+			}
+		}
+		return nil
+	})
+}
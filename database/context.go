@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrTxDone error = errors.New("the transaction has ended")
+
+// the long operations check their context every CTX_CHECK_ROWS rows read
+const CTX_CHECK_ROWS = 256
+
+// begin a transaction aborted when ctx is done, the operations fail with
+// ErrTxDone afterwards. fails with the error of ctx if it is already done.
+func (db *DB) BeginTx(ctx context.Context, tx *DBTX) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.Begin(tx)
+	tx.ctx = ctx
+	tx.kv.Tree.ctx = ctx
+	tx.stop = context.AfterFunc(ctx, func() {
+		_ = db.abort(tx, context.Cause(ctx))
+	})
+	return nil
+}
+
+// run fn with the scans of the transaction also stopped by ctx, like a
+// statement cancelled on its own: fn fails with the error of ctx & the
+// transaction stays open, with the writes fn made before it stopped.
+func (tx *DBTX) WithContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	// done with ctx at once, with the context of the transaction soon after
+	outer := tx.kv.Tree.ctx
+	inner, cancel := context.WithCancelCause(ctx)
+	stop := func() bool { return false }
+	if outer != nil {
+		stop = context.AfterFunc(outer, func() { cancel(context.Cause(outer)) })
+	}
+	tx.kv.Tree.ctx = inner
+	tx.mu.Unlock()
+	defer func() {
+		stop()
+		cancel(nil)
+		tx.mu.Lock()
+		tx.kv.Tree.ctx = outer
+		tx.mu.Unlock()
+	}()
+	return fn()
+}
+
+// the error of the context of the tree once it is done, nil before
+func (tree *BTree) ctxErr() error {
+	if tree == nil || tree.ctx == nil || tree.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(tree.ctx)
+}
+
+// ErrTxDone once the transaction was committed or aborted, nil while it is open
+func (tx *DBTX) Err() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.err()
+}
+
+// lock the transaction for an operation, it fails once the transaction ended.
+// a transaction whose context is done is aborted here if it is not yet.
+func (tx *DBTX) enter() error {
+	tx.mu.Lock()
+	if err := tx.err(); err != nil {
+		tx.mu.Unlock()
+		return err
+	}
+	if tx.ctx != nil && tx.ctx.Err() != nil {
+		tx.mu.Unlock()
+		_ = tx.db.abort(tx, context.Cause(tx.ctx))
+		return tx.Err()
+	}
+	return nil
+}
+
+// under tx.mu
+func (tx *DBTX) err() error {
+	if !tx.done {
+		return nil
+	}
+	if tx.cause != nil {
+		return fmt.Errorf("%w: %w", ErrTxDone, tx.cause)
+	}
+	return ErrTxDone
+}
+
+// mark the transaction as ended, under tx.mu
+func (tx *DBTX) end() {
+	tx.done = true
+	if tx.stop != nil {
+		tx.stop()
+	}
+}
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBeginTx(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	for id := int64(1); id <= 100; id++ {
+		insertTestRecord(t, db, id)
+	}
+	readers := func() int {
+		db.kv.mu.Lock()
+		defer db.kv.mu.Unlock()
+		return len(db.kv.readers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var tx DBTX
+	if err := db.BeginTx(ctx, &tx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a done context to fail, got %v", err)
+	}
+
+	// aborted at the deadline, the later operations fail
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.BeginTx(ctx, &tx); err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	rolledBack := make(chan struct{})
+	tx.OnRollback(func() { close(rolledBack) })
+	if _, err := tx.Set("users", userRecord(200, "late"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	select {
+	case <-rolledBack:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the transaction to be aborted at the deadline")
+	}
+	if readers() != 0 {
+		t.Errorf("expected the snapshot to be released")
+	}
+	_, err := tx.Set("users", userRecord(201, "late"), MODE_INSERT_ONLY)
+	if !errors.Is(err, ErrTxDone) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrTxDone from the deadline, got %v", err)
+	}
+	if err := db.Commit(&tx); !errors.Is(err, ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	if err := db.Abort(&tx); err != nil {
+		t.Errorf("expected the abort of an ended transaction to do nothing, got %v", err)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	if ok, _ := reader.Get("users", (&Record{}).AddInt64("id", 200)); ok {
+		t.Errorf("expected the write of the expired transaction to be discarded")
+	}
+	db.EndRead(&reader)
+
+	// a commit stops the timer
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := db.BeginTx(ctx, &tx); err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if _, err := tx.Set("users", userRecord(300, "ok"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	cancel()
+	if err := db.Commit(&tx); !errors.Is(err, ErrTxDone) || errors.Is(err, context.Canceled) {
+		t.Errorf("expected ErrTxDone from the commit, got %v", err)
+	}
+
+	// a scan stops once the context is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if err := db.BeginTx(ctx, &tx); err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	sc := &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("id", 0), Key2: *(&Record{}).AddInt64("id", 1000)}
+	if err := tx.Scan("users", sc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := 0
+	for ; sc.Valid(); sc.Next() {
+		if rows++; rows == 10 {
+			cancel()
+		}
+	}
+	if rows != 10 || !errors.Is(sc.Err(), ErrTxDone) {
+		t.Errorf("expected the scan to stop after 10 rows with ErrTxDone, got %d %v", rows, sc.Err())
+	}
+	rec := Record{}
+	if err := sc.Deref(&rec, &tx.kv.Tree); !errors.Is(err, ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+}
+
+func TestIdleContext(t *testing.T) {
+	expired := make(chan struct{})
+	ctx, idle := idleContext(50*time.Millisecond, func() { close(expired) })
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		idle.touch()
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected the touches to keep the context")
+	}
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the context to expire")
+	}
+	if ctx.Err() == nil || context.Cause(ctx).Error() != "idle for 50ms" {
+		t.Errorf("expected the idle cause, got %v", context.Cause(ctx))
+	}
+
+	// stopped with the transaction
+	ctx, idle = idleContext(10*time.Millisecond, func() { t.Errorf("expected no expiry") })
+	idle.stop()
+	time.Sleep(30 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Errorf("expected a stopped timer to keep the context")
+	}
+	var none *idleTimer
+	none.touch()
+	none.stop()
+}
+
+// a context done once its Err was checked n times, to stop an operation in
+// the middle
+type countdownCtx struct {
+	context.Context
+	n int
+}
+
+func (c *countdownCtx) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestScanContext(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	err := db.Transact(func(tx *DBTX) error {
+		for id := int64(1); id <= 1000; id++ {
+			if _, err := tx.Set("users", userRecord(id, "john"), MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	// stopped within CTX_CHECK_ROWS rows of the cancel
+	var reader DBReader
+	db.BeginRead(&reader)
+	sc, err := reader.ScanAll("users")
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc.SetContext(ctx)
+	n := 0
+	for ; sc.Valid(); sc.Next() {
+		if n++; n == 10 {
+			cancel()
+		}
+	}
+	if n < 10 || n > 10+CTX_CHECK_ROWS {
+		t.Errorf("expected the scan to stop after the cancel, got %d rows", n)
+	}
+	if err := sc.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+
+	// the rows skipped by the filter are checked too
+	nobody := CompareExpr(ColumnExpr("name"), CMP_EQ, LiteralExpr(Value{Type: TYPE_BYTES, Str: []byte("nobody")}))
+	sc = &Scanner{Filter: nobody}
+	sc.SetContext(&countdownCtx{Context: context.Background(), n: 2})
+	if err := reader.Scan("users", sc); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if sc.Valid() || !errors.Is(sc.Err(), context.Canceled) {
+		t.Errorf("expected the filtered scan to stop, got %v", sc.Err())
+	}
+	if examined := sc.Stats().Examined; examined != 3*CTX_CHECK_ROWS {
+		t.Errorf("expected %d rows examined, got %d", 3*CTX_CHECK_ROWS, examined)
+	}
+	db.EndRead(&reader)
+
+	// a stopped DeleteRange deletes none of the rows
+	err = db.Transact(func(tx *DBTX) error {
+		req := &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+		req.SetContext(&countdownCtx{Context: context.Background(), n: 2})
+		if _, err := tx.DeleteRange("users", req); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the error of the context, got %v", err)
+		}
+		n, err := tx.Count("users", &Scanner{})
+		if n != 1000 {
+			t.Errorf("expected the rows to stay, got %d", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("delete range: %v", err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	err := db.Transact(func(tx *DBTX) error {
+		for id := int64(1); id <= 1000; id++ {
+			if _, err := tx.Set("people", userRecord(id, fmt.Sprint("p", id)), MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	// the statement stops, the transaction stays open
+	var tx DBTX
+	db.Begin(&tx)
+	ctx, cancel := context.WithCancel(context.Background())
+	err = tx.WithContext(ctx, func() error {
+		if _, err := tx.Set("people", userRecord(2000, "new"), MODE_INSERT_ONLY); err != nil {
+			return err
+		}
+		cancel()
+		if _, err := tx.Repair("people"); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the repair to stop, got %v", err)
+		}
+		sc, err := tx.ScanAll("people")
+		if err != nil {
+			return err
+		}
+		for ; sc.Valid(); sc.Next() {
+		}
+		return sc.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+	if err := tx.WithContext(ctx, func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a done context to fail, got %v", err)
+	}
+	if n, err := tx.Count("people", &Scanner{}); err != nil || n != 1001 {
+		t.Errorf("expected the scans to go on after it, got %d %v", n, err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// the scans of a transaction of BeginTx stop with its context, which
+	// aborts it
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := db.BeginTx(ctx, &tx); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	sc, err := tx.ScanAll("people")
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	cancel()
+	if sc.Valid() || !errors.Is(sc.Err(), context.Canceled) {
+		t.Errorf("expected the scan to stop, got %v", sc.Err())
+	}
+	db.Abort(&tx)
+}
@@ -3,13 +3,16 @@ package database
 import (
 	"atomixDB/database/helper"
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func newKV(filename string) *KV {
@@ -22,21 +25,70 @@ func newDB() *DB {
 	return &DB{
 		Path:   fileName,
 		kv:     *newKV(fileName),
-		tables: make(map[string]*TableDef),
+		tables: make(map[string]cachedDef),
 		pool:   NewPool(3),
 	}
 }
 
 const fileName string = "database.db"
 
+// opens the DB file at the path, created if missing, or a DB without a file
+// for MEMORY_PATH. the DB reads & writes through BeginRead & Begin, or View
+// & Transact, and is closed by Close.
+func Open(path string, opts ...Option) (*DB, error) {
+	db := &DB{
+		Path:   path,
+		kv:     *newKV(path),
+		tables: make(map[string]cachedDef),
+		pool:   NewPool(3),
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	if err := db.kv.Open(); err != nil {
+		db.pool.Stop()
+		return nil, err
+	}
+	if db.kv.ReadOnly || db.kv.Replica {
+		if db.kv.format < FORMAT_VERSION {
+			db.Close()
+			return nil, fmt.Errorf("%w %d: the values are rewritten by opening it for writing once",
+				ErrUnsupportedVersion, db.kv.format)
+		}
+		if db.kv.Replica {
+			if err := replicaCheck(db); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		return db, nil
+	}
+	if err := initializeInternalTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := logOpen(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 func initializeInternalTables(db *DB) error {
+	if db.kv.format < FORMAT_VERSION {
+		if err := valuesRewrite(db); err != nil {
+			return fmt.Errorf("failed to rewrite the values of format %d: %v", db.kv.format, err)
+		}
+	}
 	tables := []*TableDef{TDEF_META, TDEF_TABLE}
 
 	for _, tableName := range tables {
 		var writer KVTX
 		db.kv.Begin(&writer)
 
-		if err := db.TableNew(tableName, &writer); err != nil {
+		// a copy, the prefixes of the globals are read by the other DBs
+		def := *tableName
+		if err := db.TableNew(&def, &writer); err != nil {
 			db.kv.Abort(&writer)
 			if errors.Is(err, ErrTableAlreadyExists) {
 				continue
@@ -45,15 +97,72 @@ func initializeInternalTables(db *DB) error {
 		}
 		db.kv.Commit(&writer)
 	}
-
+	if err := rowCountsInit(db); err != nil {
+		return fmt.Errorf("failed to count the rows: %v", err)
+	}
 	return nil
 }
 
 var ErrTableAlreadyExists error = errors.New("table already exists")
 
-func StartDB() {
-	scanner := bufio.NewReader(os.Stdin)
+// runs a SQL statement typed at the REPL in the open transaction, or in one of
+// its own when tx is nil, and prints its rows, and its status when status is
+// set. ctx stops the statement, see replCtx. set by main to the
+// atomixDB/database/sql package, which imports this one.
+var ReplSQL func(ctx context.Context, db *DB, tx *DBTX, stmt string, status bool) error
+
+// a line ending with ';', or the start of a SQL statement: SELECT, EXPLAIN,
+// INSERT INTO, DELETE FROM, CREATE TABLE, UPDATE <name> SET. the other lines
+// are the commands, with their first answer or the fields of
+// helper.ParseTableInput after the name.
+func isSQL(input string) bool {
+	if strings.HasSuffix(input, ";") {
+		return true
+	}
+	words := strings.Fields(strings.ToLower(input))
+	if len(words) < 2 {
+		return false
+	}
+	switch words[0] {
+	case "select", "explain":
+		return true
+	case "insert":
+		return words[1] == "into"
+	case "delete":
+		return words[1] == "from"
+	case "create":
+		return words[1] == "table"
+	case "update":
+		return len(words) > 2 && words[2] == "set"
+	default:
+		return false
+	}
+}
+
+// the input of the REPL, a terminal or a script
+type ReplOptions struct {
+	Script    string // the file of the commands, stdin when empty
+	KeepGoing bool   // a script goes on after a failed command
+}
+
+// runs the commands typed at a terminal, or the script of the file or of
+// stdin when it is not a terminal. a script exits with 1 after its first
+// failed command, or after all of them with KeepGoing.
+func StartDB(opts ReplOptions) {
+	in := io.Reader(os.Stdin)
+	if opts.Script != "" {
+		fp, err := os.Open(opts.Script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening the script:", err)
+			os.Exit(1)
+		}
+		defer fp.Close()
+		in = fp
+	} else if stat, err := os.Stdin.Stat(); err == nil {
+		helper.Interactive = stat.Mode()&os.ModeCharDevice != 0
+	}
 	db := newDB()
+	db.kv.Logger = NewTextLogger(os.Stderr)
 	if err := db.kv.Open(); err != nil {
 		log.Fatalf("Failed to open  %v", err)
 	}
@@ -68,46 +177,304 @@ func StartDB() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		<-sigChan
-		shutdownDB(db)
+		for sig := range sigChan {
+			// Ctrl-C cancels the command running, at a terminal it cancels
+			// the line typed & it does not end an open transaction either
+			if sig == syscall.SIGINT && (replInterrupt() || helper.Interactive) {
+				continue
+			}
+			shutdownDB(db, 1)
+		}
 	}()
 
+	if failed := repl(db, in, opts.KeepGoing); failed > 0 && !helper.Interactive {
+		shutdownDB(db, 1)
+	}
+	shutdownDB(db, 0)
+}
+
+// the prompts of the REPL, with a star while a transaction is open
+const (
+	REPL_PROMPT    = "atomix> "
+	REPL_TX_PROMPT = "atomix*> "
+)
+
+// the state the REPL keeps between its commands: the open transaction & the
+// timer aborting it when idle. only begin, end & close change it.
+type replSession struct {
+	db      *DB
+	tx      *DBTX // nil outside BEGIN
+	idle    *idleTimer
+	timeout time.Duration // of idle, 0 for none
+}
+
+// the open transaction, nil once the idle timer aborted it
+func (s *replSession) current() *DBTX {
+	if s.tx != nil && s.tx.Err() != nil {
+		s.end(nil) // aborted while idle, the notice was printed
+	}
+	return s.tx
+}
+
+func (s *replSession) prompt() string {
+	if s.current() != nil {
+		return REPL_TX_PROMPT
+	}
+	return REPL_PROMPT
+}
+
+func (s *replSession) begin(in *bufio.Reader) {
+	ctx := context.Background()
+	if s.tx == nil && s.timeout > 0 {
+		ctx, s.idle = idleContext(s.timeout, func() {
+			fmt.Printf("\nTransaction aborted after %v without a command.\n%s", s.timeout, REPL_PROMPT)
+		})
+	}
+	s.end(HandleBegin(in, s.db, s.tx, ctx))
+}
+
+// the transaction after a command, nil when it ended
+func (s *replSession) end(tx *DBTX) {
+	if s.tx = tx; tx == nil {
+		s.idle.stop()
+		s.idle = nil
+	}
+}
+
+// EXIT with an open transaction asks at a terminal whether to roll it back,
+// false to stay
+func (s *replSession) exit(in *bufio.Reader) bool {
+	if s.current() == nil || !helper.Interactive {
+		return true
+	}
+	helper.Prompt("The open transaction will be rolled back, continue? (y/n) ")
+	answer, _ := in.ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return false
+	}
+	s.end(HandleAbort(in, s.db, s.tx))
+	return true
+}
+
+// aborts the transaction left open at the end of the input, which fails
+func (s *replSession) close() (failed int) {
+	if s.current() != nil {
+		replError("The open transaction is aborted, it was not committed.")
+		s.db.Abort(s.tx)
+		failed++
+	}
+	s.end(nil)
+	return failed
+}
+
+// runs the commands of the input up to EXIT or its end, the number of the
+// failed ones
+func repl(db *DB, in io.Reader, keepGoing bool) (failed int) {
+	lines := &lineReader{r: bufio.NewReader(in)}
+	scanner := bufio.NewReader(lines)
 	commands := RegisterCommands()
-	var currentTX *DBTX
-	helper.PrintWelcomeMessage(true)
+	session := &replSession{db: db}
+	if helper.Interactive {
+		session.timeout = txIdleTimeout()
+		replEditor = newTerminalEditor(lines.r)
+		if replEditor != nil {
+			replEditor.prompt = helper.LastPrompt
+			replEditor.complete = func(before string) (string, []string) {
+				return replCompletions(db, session.tx, before)
+			}
+		}
+		helper.PrintWelcomeMessage(true)
+	}
+	defer func() {
+		replStop()
+		failed += session.close()
+		replFailed = false
+		replEditor = nil
+	}()
 
 	for {
-		fmt.Print("> ")
-		line, _, err := scanner.ReadLine()
-		if err != nil {
-			fmt.Println("Error reading input:", err)
+		replStop()
+		if replFailed {
+			replFailed = false
+			failed++
+			if !helper.Interactive && !keepGoing {
+				return failed
+			}
+		}
+		helper.PromptFor(helper.ANSWER_COMMAND, session.prompt())
+		line, err := scanner.ReadString('\n')
+		if err == errInterrupted {
 			continue
 		}
+		if err != nil && line == "" {
+			if err != io.EOF {
+				replError("Error reading input: %v", err)
+				failed++
+			}
+			return failed
+		}
+		replLine = lines.line
+		currentTX := session.current()
+		session.idle.touch()
 
-		command := strings.ToLower(strings.TrimSpace(string(line)))
-		if handler, exists := commands[command]; exists {
-			switch command {
-			case "begin":
-				currentTX = HandleBegin(scanner, db, currentTX)
-			case "commit":
-				currentTX = HandleCommit(scanner, db, currentTX)
-			case "abort":
-				currentTX = HandleAbort(scanner, db, currentTX)
+		input := strings.TrimSpace(line)
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue // a blank line or a comment of a script
+		}
+		if input, err = readContinued(scanner, input); err != nil {
+			continue // cancelled
+		}
+		replEditor.add(input)
+		replStart()
+		command := strings.ToLower(input)
+		// DESC <name>, the name keeps its case
+		if cmd, name, ok := strings.Cut(input, " "); ok && strings.EqualFold(cmd, "desc") {
+			describeTable(db, strings.TrimSpace(name))
+			continue
+		}
+		// BEGIN; COMMIT; & ROLLBACK; are the commands, the other statements go to ReplSQL
+		if ReplSQL != nil && isSQL(input) {
+			switch verb := strings.TrimSpace(strings.TrimSuffix(command, ";")); verb {
+			case "begin", "commit":
+				command = verb
+			case "rollback":
+				command = "abort"
 			default:
-				handler(scanner, db, currentTX)
+				if err := ReplSQL(replCtx, db, currentTX, input, helper.Interactive); err != nil {
+					replError("Error: %v", err)
+				}
+				continue
+			}
+		}
+		// a command with its first answer on its line, DROP <name>
+		input, args, _ := strings.Cut(input, " ")
+		if args = strings.TrimSpace(args); args != "" {
+			command = strings.ToLower(input)
+		}
+		// INSERT <table> <values...>, the row on its line
+		if command == "insert" && len(strings.Fields(args)) > 1 {
+			insertLine(db, currentTX, args)
+			continue
+		}
+		if command == "create" && args != "" {
+			if td, err := helper.ParseTableInput(args); err != nil {
+				replError("Error creating table: %v", err)
+			} else {
+				createTable(db, currentTX, td)
+			}
+			continue
+		}
+		if handler, exists := commands[command]; exists {
+			in := scanner
+			if args != "" {
+				in = bufio.NewReader(io.MultiReader(strings.NewReader(args+"\n"), scanner))
+			}
+			ok := lines.run(func() {
+				switch command {
+				case "begin":
+					session.begin(in)
+				case "commit":
+					session.end(HandleCommit(in, db, currentTX))
+				case "abort":
+					session.end(HandleAbort(in, db, currentTX))
+				default:
+					if currentTX == nil {
+						handler(in, db, currentTX)
+						break
+					}
+					// Ctrl-C stops the scans of the command, not the transaction
+					err := currentTX.WithContext(replCtx, func() error {
+						handler(in, db, currentTX)
+						return nil
+					})
+					if err != nil {
+						replError("Error: %v", friendlyError(err))
+					}
+				}
+			})
+			if !ok {
+				replStatus("Cancelled.")
 			}
 		} else if command == "exit" {
-			shutdownDB(db)
-			break
+			if session.exit(scanner) {
+				return failed
+			}
 		} else {
-			fmt.Println("Unknown command:", command)
+			replError("Unknown command: %s", command)
+		}
+	}
+}
+
+// the command of the line & of the lines after it while it is continued: a
+// backslash at the end joins the next line, a quote left open takes in the
+// newline. the error is Ctrl-C, at the end of the input the statement is
+// run as it is.
+func readContinued(scanner *bufio.Reader, input string) (string, error) {
+	for continued(input) {
+		helper.PromptFor(helper.ANSWER_COMMAND, "... ")
+		next, err := scanner.ReadString('\n')
+		if err != nil && next == "" {
+			if err == errInterrupted {
+				return "", err
+			}
+			return input, nil
+		}
+		if joined, ok := strings.CutSuffix(input, "\\"); ok {
+			input = joined + strings.TrimSpace(next)
+		} else {
+			input += "\n" + strings.TrimRight(next, "\r\n")
+		}
+		input = strings.TrimSpace(input)
+	}
+	return input, nil
+}
+
+// the idle time after which the REPL aborts an open transaction,
+// ATOMIXDB_IDLE_TIMEOUT overrides it with a duration, 0 disables it
+const REPL_IDLE_TIMEOUT = 10 * time.Minute
+
+func txIdleTimeout() time.Duration {
+	if env := os.Getenv("ATOMIXDB_IDLE_TIMEOUT"); env != "" {
+		timeout, err := time.ParseDuration(env)
+		if err == nil {
+			return timeout
 		}
+		fmt.Printf("Invalid ATOMIXDB_IDLE_TIMEOUT %q, using %v.\n", env, REPL_IDLE_TIMEOUT)
+	}
+	return REPL_IDLE_TIMEOUT
+}
+
+// cancels a context when it is not touched for a while, nil-safe
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// the context is cancelled after `timeout` without a touch, then `expired` is called
+func idleContext(timeout time.Duration, expired func()) (context.Context, *idleTimer) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	idle := &idleTimer{timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, func() {
+		cancel(fmt.Errorf("idle for %v", timeout))
+		expired()
+	})
+	return ctx, idle
+}
+
+func (idle *idleTimer) touch() {
+	if idle != nil {
+		idle.timer.Reset(idle.timeout)
+	}
+}
+
+func (idle *idleTimer) stop() {
+	if idle != nil {
+		idle.timer.Stop()
 	}
 }
 
-func shutdownDB(db *DB) {
-	db.kv.Close()
-	db.pool.Stop()
-	fmt.Println("Exiting...")
-	os.Exit(0)
+func shutdownDB(db *DB, code int) {
+	db.Close()
+	replStatus("Exiting...")
+	os.Exit(code)
 }
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// the distinct rows a Scanner remembers for the columns not in the order of
+// the scan, see DB.DistinctRows
+const DISTINCT_MEMORY_ROWS = 100_000
+
+// the rows already returned by a Scanner with DistinctCols
+type distinctSet struct {
+	cols     []int   // in the key of the index, or in the row
+	inKey    bool    // the columns are in the key, the row is not read
+	vals     []Value // of the key
+	adjacent bool    // the equal rows are next to each other in the scan
+	last     []byte  // the encoded columns of the last row
+	started  bool
+	seen     map[string]struct{}
+	max      int
+	buf      []byte
+}
+
+// check the distinct columns of the request, after its index is chosen
+func distinctSetup(db *DB, tdef *TableDef, req *Scanner) error {
+	req.distinct = nil
+	if len(req.DistinctCols) == 0 {
+		return nil
+	}
+	index := tdef.Cols[:tdef.PKeys]
+	if req.indexNo >= 0 {
+		index = tdef.Indexes[req.indexNo]
+	}
+	d := &distinctSet{inKey: isSubset(index, req.DistinctCols), max: db.DistinctRows}
+	for i, col := range req.DistinctCols {
+		idx := ColIndex(tdef, col)
+		switch {
+		case idx < 0:
+			return columnNotFound(tdef.Name, col)
+		case slices.Contains(req.DistinctCols[:i], col):
+			return fmt.Errorf("the distinct column %s twice", col)
+		case d.inKey:
+			idx = slices.Index(index, col)
+		}
+		d.cols = append(d.cols, idx)
+	}
+	if d.inKey {
+		for _, col := range index {
+			d.vals = append(d.vals, Value{Type: tdef.Types[ColIndex(tdef, col)]})
+		}
+	}
+	d.adjacent = keyAdjacent(tdef, req, req.DistinctCols)
+	if !d.adjacent {
+		d.seen = map[string]struct{}{}
+		if d.max <= 0 {
+			d.max = DISTINCT_MEMORY_ROWS
+		}
+	}
+	req.distinct = d
+	return nil
+}
+
+// the current row has the distinct columns of a row returned before, else it
+// is remembered
+func (sc *Scanner) duplicate() (bool, error) {
+	d := sc.distinct
+	d.buf = d.buf[:0]
+	if d.inKey {
+		key, _ := sc.iter.Deref()
+		decodeValues(key[4:], d.vals)
+		for _, i := range d.cols {
+			d.buf = encodeValues(d.buf, d.vals[i:i+1])
+		}
+	} else {
+		rec := Record{}
+		if err := sc.deref(&rec, sc.tree, true); err != nil {
+			return false, err
+		}
+		for _, i := range d.cols {
+			d.buf = encodeValues(d.buf, rec.Vals[i:i+1])
+		}
+	}
+	if d.adjacent {
+		if d.started && bytes.Equal(d.last, d.buf) {
+			return true, nil
+		}
+		d.last, d.started = append(d.last[:0], d.buf...), true
+		return false, nil
+	}
+	if _, ok := d.seen[string(d.buf)]; ok {
+		return true, nil
+	}
+	if len(d.seen) == d.max {
+		return false, fmt.Errorf("%w: more than %d distinct rows", ErrMemoryLimit, d.max)
+	}
+	d.seen[string(d.buf)] = struct{}{}
+	return false, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScanDistinct(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "items",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_INT64},
+		Cols:    []string{"id", "cat", "sub", "n"},
+		PKeys:   1,
+		Indexes: [][]string{{"cat", "sub"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	for id := int64(1); id <= 12; id++ {
+		rec := (&Record{}).AddInt64("id", id)
+		switch id % 4 {
+		case 0:
+			rec.AddNull("cat", TYPE_BYTES)
+		case 1:
+			rec.AddStr("cat", []byte("a"))
+		case 2:
+			rec.AddStr("cat", []byte("b"))
+		case 3:
+			rec.AddStr("cat", []byte{})
+		}
+		rec.AddInt64("sub", (id/4)%2).AddInt64("n", id)
+		if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	str := func(s string) *Expr { return LiteralExpr(Value{Type: TYPE_BYTES, Str: []byte(s)}) }
+	nonNull := CompareExpr(ColumnExpr("cat"), CMP_GE, str(""))
+
+	for _, tt := range []struct {
+		name     string
+		sc       Scanner
+		adjacent bool
+		inKey    bool
+		want     string
+	}{
+		{"index", Scanner{DistinctCols: []string{"cat"}, Filter: nonNull, Project: []string{"cat"}}, true, true,
+			`cat=""; cat="a"; cat="b"`},
+		{"hashed", Scanner{DistinctCols: []string{"cat"}, Project: []string{"id", "cat"}}, false, false,
+			`id=1 cat="a"; id=2 cat="b"; id=3 cat=""; id=4 cat=NULL`},
+		{"limit of the distinct rows", Scanner{DistinctCols: []string{"cat", "sub"}, Filter: nonNull, Project: []string{"cat", "sub"}, Limit: 4}, true, true,
+			`cat="" sub=0; cat="" sub=1; cat="a" sub=0; cat="a" sub=1`},
+		// the primary rows are read for n only
+		{"after the equal column", Scanner{DistinctCols: []string{"sub"}, Filter: CompareExpr(ColumnExpr("cat"), CMP_EQ, str("a")), Project: []string{"id", "n"}}, true, true,
+			`id=1 n=1; id=5 n=5`},
+	} {
+		sc := tt.sc
+		if err := tx.Scan("items", &sc); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if sc.distinct.adjacent != tt.adjacent || sc.distinct.inKey != tt.inKey {
+			t.Errorf("%s: expected adjacent %v & in the key %v", tt.name, tt.adjacent, tt.inKey)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, tx.Tree()); err != nil {
+				t.Fatalf("%s: failed to deref: %v", tt.name, err)
+			}
+			got = append(got, formatRecord(rec))
+		}
+		if err := sc.Err(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if strings.Join(got, "; ") != tt.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.want, strings.Join(got, "; "))
+		}
+	}
+
+	count := func(sc *Scanner) (int64, error) {
+		if err := tx.Scan("items", sc); err != nil {
+			return 0, err
+		}
+		return sc.Count(), sc.Err()
+	}
+	if n, err := count(&Scanner{DistinctCols: []string{"cat"}}); err != nil || n != 4 {
+		t.Errorf("expected 4 distinct cats, got %d %v", n, err)
+	}
+	plan, err := tx.Explain("items", &Scanner{DistinctCols: []string{"cat"}, Filter: nonNull})
+	if err != nil || !strings.Contains(plan.String(), "distinct: cat, in the order of the scan") {
+		t.Errorf("expected the distinct columns in the plan, got %v %v", plan, err)
+	}
+
+	// the cap is of the rows kept in memory
+	db.DistinctRows = 5
+	if n, err := count(&Scanner{DistinctCols: []string{"n"}}); n != 5 || !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("expected ErrMemoryLimit after 5 rows, got %d %v", n, err)
+	}
+	if _, err := count(&Scanner{DistinctCols: []string{"id", "cat"}}); err != nil {
+		t.Errorf("expected the distinct primary keys in the order, got %v", err)
+	}
+	for _, cols := range [][]string{{"color"}, {"cat", "cat"}} {
+		if err := tx.Scan("items", &Scanner{DistinctCols: cols}); err == nil {
+			t.Errorf("%v: expected an error", cols)
+		}
+	}
+	db.Abort(&tx)
+}
@@ -0,0 +1,314 @@
+// a database/sql driver of the SQL of atomixDB, registered as "atomix" with
+// the path of the DB file as the data source name:
+//
+//	db, err := sql.Open("atomix", "/path/to/atomix.db")
+//
+// the connections of a sql.DB share the DB file, opened once, and each runs
+// its statements in a session of its own. the DB is not yet safe for
+// concurrent use, the calls of the connections are run one at a time.
+package driver
+
+import (
+	"atomixDB/database"
+	atomixsql "atomixDB/database/sql"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+func init() {
+	sql.Register("atomix", &Driver{})
+}
+
+type Driver struct{}
+
+// a connection of a DB of its own, closed with the connection. sql.Open
+// calls OpenConnector instead.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	conn.(*Conn).owner = c.(*connector)
+	return conn, nil
+}
+
+// opens the DB file of the connections, closed with the sql.DB
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("no path of the DB file")
+	}
+	db, err := database.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{drv: d, db: db}, nil
+}
+
+type connector struct {
+	drv *Driver
+	db  *database.DB
+	mu  sync.Mutex // taken by each call of the connections
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &Conn{c: c, s: atomixsql.NewSession(c.db, nil)}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.drv
+}
+
+func (c *connector) Close() error {
+	return c.db.Close()
+}
+
+// a connection, a session of the SQL layer
+type Conn struct {
+	c     *connector
+	s     *atomixsql.Session
+	owner *connector // closed with the connection, see Driver.Open
+}
+
+func (cn *Conn) Prepare(query string) (driver.Stmt, error) {
+	return cn.PrepareContext(context.Background(), query)
+}
+
+func (cn *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cn.c.mu.Lock()
+	defer cn.c.mu.Unlock()
+	p, err := cn.s.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{cn: cn, p: p}, nil
+}
+
+// rolls back the open transaction, if any
+func (cn *Conn) Close() error {
+	cn.c.mu.Lock()
+	var err error
+	if cn.s.Tx() != nil {
+		_, err = cn.s.Exec("ROLLBACK")
+	}
+	cn.c.mu.Unlock()
+	if cn.owner != nil {
+		cn.owner.Close()
+	}
+	return err
+}
+
+func (cn *Conn) Begin() (driver.Tx, error) {
+	return cn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BEGIN of the session, the transactions are snapshots
+func (cn *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSnapshot {
+		return nil, fmt.Errorf("the isolation level %v is not supported", level)
+	}
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("read-only transactions are not supported")
+	}
+	cn.c.mu.Lock()
+	defer cn.c.mu.Unlock()
+	if _, err := cn.s.Exec("BEGIN"); err != nil {
+		return nil, err
+	}
+	return &Tx{cn: cn}, nil
+}
+
+type Tx struct {
+	cn *Conn
+}
+
+func (tx *Tx) Commit() error {
+	return tx.end("COMMIT")
+}
+
+func (tx *Tx) Rollback() error {
+	return tx.end("ROLLBACK")
+}
+
+func (tx *Tx) end(verb string) error {
+	tx.cn.c.mu.Lock()
+	defer tx.cn.c.mu.Unlock()
+	_, err := tx.cn.s.Exec(verb)
+	return err
+}
+
+// a prepared statement, see atomixsql.Prepared
+type Stmt struct {
+	cn *Conn
+	p  *atomixsql.Prepared
+}
+
+func (st *Stmt) Close() error {
+	return nil
+}
+
+func (st *Stmt) NumInput() int {
+	return st.p.NumParams()
+}
+
+func (st *Stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return st.ExecContext(context.Background(), namedValues(args))
+}
+
+func (st *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	vals, err := params(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	st.cn.c.mu.Lock()
+	defer st.cn.c.mu.Unlock()
+	res, err := st.p.Exec(vals...)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.Affected), nil
+}
+
+func (st *Stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return st.QueryContext(context.Background(), namedValues(args))
+}
+
+func (st *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	vals, err := params(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	st.cn.c.mu.Lock()
+	defer st.cn.c.mu.Unlock()
+	rows, err := st.p.Rows(vals...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{cn: st.cn, rows: rows}, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// the values of the ? in order, there are no named parameters
+func params(ctx context.Context, args []driver.NamedValue) ([]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vals := make([]any, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("the named parameter %s is not supported, use ?", arg.Name)
+		}
+		vals[i] = arg.Value
+	}
+	return vals, nil
+}
+
+// the rows of a query, read from its scan by Next
+type Rows struct {
+	cn   *Conn
+	rows *atomixsql.Rows
+	rec  database.Record
+}
+
+func (r *Rows) Columns() []string {
+	return r.rows.Cols
+}
+
+func (r *Rows) Close() error {
+	r.cn.c.mu.Lock()
+	defer r.cn.c.mu.Unlock()
+	return r.rows.Close()
+}
+
+func (r *Rows) Next(dest []driver.Value) error {
+	r.cn.c.mu.Lock()
+	defer r.cn.c.mu.Unlock()
+	ok, err := r.rows.Next(&r.rec)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return io.EOF
+	}
+	for i, v := range r.rec.Vals {
+		dest[i] = value(v)
+	}
+	return nil
+}
+
+// the value as one of the types of driver.Value
+func value(v database.Value) driver.Value {
+	if v.Null {
+		return nil
+	}
+	switch v.Type {
+	case database.TYPE_INT64, database.TYPE_INT32:
+		return v.I64
+	case database.TYPE_FLOAT64:
+		return v.F64
+	case database.TYPE_BOOL:
+		return v.I64 != 0
+	case database.TYPE_TIME:
+		return time.Unix(0, v.I64)
+	default:
+		// the bytes of the scan are not kept past Next
+		return slices.Clone(v.Str)
+	}
+}
+
+// the Go type of the values of the column, any for a column without a type
+func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	var typ uint32
+	if index < len(r.rows.Types) {
+		typ = r.rows.Types[index]
+	}
+	switch typ {
+	case database.TYPE_INT64, database.TYPE_INT32:
+		return reflect.TypeOf(int64(0))
+	case database.TYPE_FLOAT64:
+		return reflect.TypeOf(float64(0))
+	case database.TYPE_BOOL:
+		return reflect.TypeOf(false)
+	case database.TYPE_TIME:
+		return reflect.TypeOf(time.Time{})
+	case database.TYPE_BYTES:
+		return reflect.TypeOf([]byte(nil))
+	default:
+		return reflect.TypeOf((*any)(nil)).Elem()
+	}
+}
+
+// the type of the column, INT64 for TYPE_INT64
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	if index >= len(r.rows.Types) {
+		return ""
+	}
+	return atomixsql.TypeName(r.rows.Types[index])
+}
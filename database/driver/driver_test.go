@@ -0,0 +1,136 @@
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDriver(t *testing.T) {
+	db, err := sql.Open("atomix", filepath.Join(t.TempDir(), "atomix.db"))
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE people (id INT64 PRIMARY KEY, name TEXT, age INTEGER, score FLOAT, active BOOL, seen TIME)"); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	seen := time.Unix(1700000000, 5)
+	for i, name := range []string{"ann", "bob", "cy"} {
+		res, err := db.Exec("INSERT INTO people VALUES (?, ?, ?, ?, ?, ?)", i+1, name, 20+i, 1.5*float64(i), i%2 == 0, seen)
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", name, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			t.Errorf("expected 1 row affected, got %d %v", n, err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO people (id, name) VALUES (?, ?)", 4, nil); err != nil {
+		t.Fatalf("failed to insert the NULLs: %v", err)
+	}
+
+	rows, err := db.Query("SELECT id, name, age, score, active, seen FROM people WHERE age >= ? ORDER BY name", 21)
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf("failed to get the column types: %v", err)
+	}
+	var names []string
+	for _, ct := range types {
+		names = append(names, ct.DatabaseTypeName()+" "+ct.ScanType().String())
+	}
+	want := "[INT64 int64 BYTES []uint8 INT32 int64 FLOAT64 float64 BOOL bool TIME time.Time]"
+	if got := fmt.Sprint(names); got != want {
+		t.Errorf("expected the types %s, got %s", want, got)
+	}
+	var got []string
+	for rows.Next() {
+		var (
+			id, age int64
+			name    string
+			score   float64
+			active  bool
+			at      time.Time
+		)
+		if err := rows.Scan(&id, &name, &age, &score, &active, &at); err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		if !at.Equal(seen) {
+			t.Errorf("expected the time %v, got %v", seen, at)
+		}
+		got = append(got, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	rows.Close()
+	if fmt.Sprint(got) != "[bob cy]" {
+		t.Errorf("expected bob & cy, got %v", got)
+	}
+	var name sql.NullString
+	if err := db.QueryRow("SELECT name FROM people WHERE id = ?", 4).Scan(&name); err != nil || name.Valid {
+		t.Errorf("expected NULL, got %v %v", name, err)
+	}
+
+	// rolled back
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO people (id, name) VALUES (?, ?)", 5, "di"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM people WHERE id = 1"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	count := func(q interface {
+		QueryRow(string, ...any) *sql.Row
+	}) int {
+		t.Helper()
+		var n int
+		if err := q.QueryRow("SELECT COUNT(*) FROM people").Scan(&n); err != nil {
+			t.Fatalf("failed to count: %v", err)
+		}
+		return n
+	}
+	if n := count(tx); n != 4 {
+		t.Errorf("expected 4 rows in the transaction, got %d", n)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	if n := count(db); n != 4 {
+		t.Errorf("expected the 4 rows after the rollback, got %d", n)
+	}
+	var found string
+	if err := db.QueryRow("SELECT name FROM people WHERE id = 1").Scan(&found); err != nil || found != "ann" {
+		t.Errorf("expected the deleted row back, got %q %v", found, err)
+	}
+
+	// committed
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO people (id, name) VALUES (5, 'di')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if n := count(db); n != 5 {
+		t.Errorf("expected 5 rows after the commit, got %d", n)
+	}
+
+	if _, err := db.Exec("INSERT INTO people VALUES (?)", 1, 2); err == nil {
+		t.Errorf("expected an error for the number of values")
+	}
+	if _, err := db.Exec("SELECT * FROM people WHERE id = ?", "x"); err == nil {
+		t.Errorf("expected an error for the type of a value")
+	}
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"atomixDB/database/helper"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// write a script of the tables & their rows, which the script mode of the
+// REPL (-f) replays into an equivalent database: a CREATE line for each
+// table, the parents of foreign keys first, then an INSERT line for each row
+// in one transaction. the bytes are in Go quotes. the rows of a table with a
+// foreign key to itself replay in the order of the primary key.
+func (db *DB) Dump(w io.Writer) error {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	return dumpTree(db, w, &reader.Tree)
+}
+
+func dumpTree(db *DB, w io.Writer, tree *BTree) error {
+	names, err := db.ListTables(tree)
+	if err != nil {
+		return err
+	}
+	tdefs, err := dumpOrder(db, names, tree)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "# AtomixDB dump, replay it with -f")
+	for _, tdef := range tdefs {
+		line, err := dumpTableLine(tdef)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "create %s\n", line)
+	}
+	if len(tdefs) > 0 {
+		fmt.Fprintln(out, "begin")
+	}
+	var line []byte
+	for _, tdef := range tdefs {
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+		dbScanAll(db, tdef, &sc, tree)
+		rec := Record{}
+		for ; sc.Valid(); sc.Next() {
+			if err := sc.Deref(&rec, tree); err != nil {
+				return err
+			}
+			line = append(append(line[:0], "insert "...), tdef.Name...)
+			for _, v := range rec.Vals {
+				line = append(append(line, ' '), dumpValue(v)...)
+			}
+			if _, err := out.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	if len(tdefs) > 0 {
+		fmt.Fprintln(out, "commit")
+	}
+	return out.Flush()
+}
+
+// the tables, each after the parents of its foreign keys
+func dumpOrder(db *DB, names []string, tree *BTree) ([]*TableDef, error) {
+	byName := map[string]*TableDef{}
+	for _, name := range names {
+		if byName[name] = GetTableDef(db, name, tree); byName[name] == nil {
+			return nil, tableNotFound(name)
+		}
+	}
+	var order []*TableDef
+	state := map[string]int{} // 1 while its parents are added, 2 when added
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("the foreign keys of %s make a cycle", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		tdef := byName[name]
+		for _, fk := range tdef.ForeignKeys {
+			if fk.Parent == name || byName[fk.Parent] == nil {
+				continue
+			}
+			if err := visit(fk.Parent); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, tdef)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// the table in the line of helper.ParseTableInput, an error when a name or
+// a default does not read back the same
+func dumpTableLine(tdef *TableDef) (string, error) {
+	td := helper.TableInput{
+		Name:    tdef.Name,
+		Cols:    tdef.Cols,
+		Types:   tdef.Types,
+		Indexes: tdef.Indexes,
+		Unique:  make([]bool, len(tdef.Indexes)),
+		NotNull: []string{},
+		AutoInc: tdef.AutoIncrement,
+		Default: map[string]string{},
+		Foreign: []helper.ForeignKeyInput{},
+	}
+	copy(td.Unique, tdef.Unique)
+	for i, col := range tdef.Cols {
+		if i < len(tdef.NotNull) && tdef.NotNull[i] {
+			td.NotNull = append(td.NotNull, col)
+		}
+		if i < len(tdef.Defaults) && tdef.Defaults[i].Type != 0 {
+			td.Default[col] = formatValue(tdef.Defaults[i])
+		}
+	}
+	for _, fk := range tdef.ForeignKeys {
+		td.Foreign = append(td.Foreign, helper.ForeignKeyInput{
+			Cols: fk.Cols, Parent: fk.Parent, RefCols: fk.RefCols, Cascade: fk.OnDelete == FK_CASCADE,
+		})
+	}
+	line := td.Line()
+	if again, err := helper.ParseTableInput(line); err != nil || again.Line() != line {
+		return "", fmt.Errorf("the table %s does not read back from %q", tdef.Name, line)
+	}
+	return line, nil
+}
+
+// the value as parseRowLine reads it
+func dumpValue(v Value) string {
+	if v.Type == TYPE_BYTES && !v.Null {
+		return strconv.Quote(string(v.Str))
+	}
+	return formatValue(v)
+}
+
+// the values of the columns in their order, separated by spaces: NULL, a
+// value in Go quotes, or a word as typed at the prompts
+func parseRowLine(tdef *TableDef, line string) (*Record, error) {
+	rec := &Record{}
+	rest := strings.TrimSpace(line)
+	for i := 0; rest != ""; i++ {
+		if i == len(tdef.Cols) {
+			return nil, fmt.Errorf("more values than the %d columns", len(tdef.Cols))
+		}
+		col := tdef.Cols[i]
+		var v Value
+		var err error
+		if rest[0] == '"' {
+			quoted, qerr := strconv.QuotedPrefix(rest)
+			if qerr != nil {
+				return nil, fmt.Errorf("invalid %s: %v", col, qerr)
+			}
+			s, _ := strconv.Unquote(quoted)
+			if tdef.Types[i] == TYPE_BYTES {
+				v = Value{Type: TYPE_BYTES, Str: []byte(s)}
+			} else if v, err = parseValue(tdef.Types[i], s); err != nil {
+				return nil, fmt.Errorf("invalid %s %s: %v", col, quoted, err)
+			}
+			if rest = rest[len(quoted):]; rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				return nil, fmt.Errorf("expected a space after %s", quoted)
+			}
+		} else {
+			word := rest
+			if n := strings.IndexAny(rest, " \t"); n >= 0 {
+				word = rest[:n]
+			}
+			if v, err = parseValue(tdef.Types[i], word); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", col, word, err)
+			}
+			rest = rest[len(word):]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		rec.Cols = append(rec.Cols, col)
+		rec.Vals = append(rec.Vals, v)
+	}
+	if len(rec.Cols) != len(tdef.Cols) {
+		return nil, fmt.Errorf("%d values for %d columns", len(rec.Cols), len(tdef.Cols))
+	}
+	return rec, nil
+}
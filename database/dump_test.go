@@ -0,0 +1,176 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDump(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	// the child is first by name, its parent is created before it
+	tables := []*TableDef{
+		{
+			Name:    "people",
+			Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+			Cols:    []string{"id", "name", "email"},
+			PKeys:   1,
+			Indexes: [][]string{{"name"}},
+			Unique:  []bool{true},
+		},
+		{
+			Name:          "accounts",
+			Types:         []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_INT32, TYPE_TIME, TYPE_FLOAT64, TYPE_BOOL},
+			Cols:          []string{"id", "label", "owner", "age", "at", "score", "ok"},
+			PKeys:         1,
+			Indexes:       [][]string{{"age", "at"}, {"label"}, {"owner"}},
+			Unique:        []bool{false, true, false},
+			AutoIncrement: true,
+			NotNull:       []bool{false, true, false, false, false, false, false},
+			Defaults:      []Value{{}, {}, {}, {Type: TYPE_INT32, I64: 18}, {}, {}, {}},
+			ForeignKeys:   []ForeignKey{{Cols: []string{"owner"}, Parent: "people", RefCols: []string{"id"}, OnDelete: FK_CASCADE}},
+		},
+	}
+	for _, tdef := range tables {
+		if err := tx.TableNew(tdef); err != nil {
+			t.Fatalf("failed to create %s: %v", tdef.Name, err)
+		}
+	}
+	for i, name := range []string{"ann", "it's", "NULL"} {
+		if _, err := tx.Set("people", *(&Record{}).AddInt64("id", int64(i)).AddStr("name", []byte(name)).AddStr("email", []byte(name+"@x")), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	labels := []string{`say "hi"`, "two\nlines", "\x00\xff", "", "# not a comment", "ends;", `a\"b \`, "日本語"}
+	at := time.Date(2024, 2, 29, 13, 4, 5, 123456789, time.UTC)
+	for i, label := range labels {
+		rec := (&Record{}).AddInt64("id", int64(10*i+1)).AddStr("label", []byte(label)).AddInt64("owner", int64(i%3)).
+			AddInt32("age", int32(i)).AddTime("at", at.Add(time.Duration(i)*time.Minute)).AddFloat64("score", 1/float64(i+3)).AddBool("ok", i%2 == 0)
+		if i == 2 {
+			rec.Vals[2] = Value{Type: TYPE_INT64, Null: true}
+		}
+		if _, err := tx.Set("accounts", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := db.Dump(&out); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if !strings.Contains(out.String(), "create people cols=id,name,email types=int64,bytes,bytes unique=name+id\n") ||
+		strings.Index(out.String(), "create people") > strings.Index(out.String(), "create accounts") {
+		t.Errorf("expected the parent first, got\n%s", out.String())
+	}
+
+	// replayed into a new file, the same tables & rows
+	path := filepath.Join(t.TempDir(), "replay.db")
+	replay := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+	if err := replay.kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer replay.kv.Close()
+	if err := initializeInternalTables(replay); err != nil && !errors.Is(err, ErrTableAlreadyExists) {
+		t.Fatalf("init: %v", err)
+	}
+	var failed int
+	_, stderr := captureOutput(t, func() { failed = repl(replay, bytes.NewReader(out.Bytes()), false) })
+	if failed != 0 || stderr != "" {
+		t.Fatalf("expected the dump to replay, got %d %q from\n%s", failed, stderr, out.String())
+	}
+	contents := func(db *DB) (string, []*TableDef) {
+		t.Helper()
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+		names, err := db.ListTables(&reader.Tree)
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		var rows []string
+		var tdefs []*TableDef
+		for _, name := range names {
+			tdef := *GetTableDef(db, name, &reader.Tree)
+			tdef.Prefix, tdef.IndexPrefix, tdef.Version = 0, nil, 0
+			tdefs = append(tdefs, &tdef)
+			sc, err := db.ScanAll(name, &reader.Tree)
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+			for ; sc.Valid(); sc.Next() {
+				rec := Record{}
+				if err := sc.Deref(&rec, &reader.Tree); err != nil {
+					t.Fatalf("failed to deref: %v", err)
+				}
+				rows = append(rows, name+" "+formatRecord(rec))
+			}
+		}
+		return strings.Join(rows, "\n"), tdefs
+	}
+	wantRows, wantDefs := contents(db)
+	gotRows, gotDefs := contents(replay)
+	if gotRows != wantRows {
+		t.Errorf("expected the rows\n%s\ngot\n%s", wantRows, gotRows)
+	}
+	if !reflect.DeepEqual(gotDefs, wantDefs) {
+		for i := range wantDefs {
+			t.Errorf("expected the definition %+v, got %+v", *wantDefs[i], *gotDefs[i])
+		}
+	}
+	// the counter of the primary key goes on from the rows
+	for _, db := range []*DB{db, replay} {
+		var tx DBTX
+		db.Begin(&tx)
+		rec := (&Record{}).AddStr("label", []byte("new")).AddInt64("owner", 0)
+		if _, err := tx.InsertAuto("accounts", rec); err != nil || rec.Get("id").I64 != 72 {
+			t.Errorf("expected the id 72, got %v %v", rec, err)
+		}
+		db.Abort(&tx)
+	}
+
+	// the row on the line of INSERT
+	var people TableDef = *tables[0]
+	for line, want := range map[string]string{
+		`1 "x" "y"`:       "",
+		` 2   NULL	NULL `: "",
+		`3 bare ""`:       "",
+		`"4" "y" "z"`:     "",
+		`5 "a"`:           "2 values for 3 columns",
+		`6 "a" "b" "c"`:   "more values than the 3 columns",
+		`7 "a`:            "invalid name",
+		`8 "a"b`:          `expected a space after "a"`,
+		`x "y" "z"`:       `invalid id "x"`,
+	} {
+		_, err := parseRowLine(&people, line)
+		if (want == "") != (err == nil) || (err != nil && !strings.HasPrefix(err.Error(), want)) {
+			t.Errorf("expected %q for %q, got %v", want, line, err)
+		}
+	}
+
+	// a default the CREATE line cannot take
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name: "notes", Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "text"}, PKeys: 1,
+		Defaults: []Value{{}, {Type: TYPE_BYTES, Str: []byte("a b")}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Dump(&out); err == nil || !strings.Contains(err.Error(), "notes") {
+		t.Errorf("expected an error for the default, got %v", err)
+	}
+}
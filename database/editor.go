@@ -0,0 +1,326 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// the history of the REPL in the home directory, ATOMIXDB_HISTORY overrides
+// it with another file, an empty one keeps none
+const HISTORY_FILE = ".atomixdb_history"
+
+// the lines kept by the history
+const HISTORY_MAX = 1000
+
+// Ctrl-C at a terminal, it cancels the line or the command being typed
+var errInterrupted = errors.New("interrupted")
+
+// the keys of the escape sequences without a control character of their own
+const (
+	keyDelete rune = -1
+	keyNone   rune = -2
+)
+
+// the editor of the lines typed at a terminal: Left, Right, Home & End move
+// in the line, Backspace & Delete erase, Ctrl-U, Ctrl-K & Ctrl-W cut, Up &
+// Down go through the history, Tab completes. the line is redrawn in place,
+// one longer than the terminal is not redrawn right.
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	raw     func() (restore func(), err error) // the raw mode of the terminal, none when nil
+	history []string
+	file    string // the lines added are appended to it, none when empty
+	// the word at the end of the line before the cursor & the words it
+	// completes to, none when nil
+	complete func(before string) (word string, matches []string)
+	prompt   func() string // printed again after the list of the matches
+}
+
+// the editor of stdin, nil when it is not a terminal
+func newTerminalEditor(in *bufio.Reader) *lineEditor {
+	fd := os.Stdin.Fd()
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return nil
+	}
+	restore()
+	ed := &lineEditor{in: in, out: os.Stdout, raw: func() (func(), error) { return makeRaw(fd) }}
+	ed.loadHistory(historyFile())
+	return ed
+}
+
+func historyFile() string {
+	if file, ok := os.LookupEnv("ATOMIXDB_HISTORY"); ok {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, HISTORY_FILE)
+}
+
+// the history of the file, cut to its last HISTORY_MAX lines
+func (e *lineEditor) loadHistory(file string) {
+	e.file = file
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	e.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(e.history) > HISTORY_MAX {
+		e.history = e.history[len(e.history)-HISTORY_MAX:]
+		os.WriteFile(file, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+	}
+}
+
+// adds a line to the history & to its file, but for a blank line, one on
+// many lines & the last one again. nil-safe.
+func (e *lineEditor) add(line string) {
+	if e == nil || strings.TrimSpace(line) == "" || strings.Contains(line, "\n") {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > HISTORY_MAX {
+		e.history = e.history[1:]
+	}
+	if e.file == "" {
+		return
+	}
+	fp, err := os.OpenFile(e.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	fmt.Fprintln(fp, line)
+}
+
+// the line typed with its newline, errInterrupted for Ctrl-C, io.EOF for
+// Ctrl-D on an empty line
+func (e *lineEditor) readLine() (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return e.in.ReadString('\n')
+		}
+		defer restore()
+	}
+	return e.edit()
+}
+
+func (e *lineEditor) edit() (string, error) {
+	var line, typed []rune
+	pos := 0
+	recall := len(e.history) // the entry shown, len for the line typed
+	set := func(next []rune, at int) {
+		e.draw(pos, next, at)
+		line, pos = next, at
+	}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return string(line) + "\n", nil
+			}
+			return "", err
+		}
+		if r == 27 {
+			r = e.escape()
+		}
+		switch r {
+		case '\r', '\n':
+			io.WriteString(e.out, "\n")
+			return string(line) + "\n", nil
+		case 3: // Ctrl-C
+			io.WriteString(e.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				io.WriteString(e.out, "\n")
+				return "", io.EOF
+			}
+			fallthrough
+		case keyDelete:
+			if pos < len(line) {
+				set(append(line[:pos:pos], line[pos+1:]...), pos)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				set(append(line[:pos-1:pos-1], line[pos:]...), pos-1)
+			}
+		case 1: // Ctrl-A, Home
+			set(line, 0)
+		case 5: // Ctrl-E, End
+			set(line, len(line))
+		case 2: // Ctrl-B, Left
+			set(line, max(pos-1, 0))
+		case 6: // Ctrl-F, Right
+			set(line, min(pos+1, len(line)))
+		case 11: // Ctrl-K
+			set(line[:pos:pos], pos)
+		case 21: // Ctrl-U
+			set(append([]rune{}, line[pos:]...), 0)
+		case 23: // Ctrl-W, the word before the cursor
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			set(append(line[:start:start], line[pos:]...), start)
+		case '\t':
+			if e.complete == nil {
+				break
+			}
+			word, matches := e.complete(string(line[:pos]))
+			start := pos - len([]rune(word))
+			insert := func(text string) {
+				next := append(line[:start:start], []rune(text)...)
+				set(append(next, line[pos:]...), len(next))
+			}
+			switch common := commonPrefix(matches); {
+			case len(matches) == 0:
+				io.WriteString(e.out, "\a")
+			case len(matches) == 1:
+				insert(matches[0] + " ")
+			case len([]rune(common)) > len([]rune(word)):
+				insert(common)
+			default:
+				prompt := ""
+				if e.prompt != nil {
+					prompt = e.prompt()
+				}
+				fmt.Fprintf(e.out, "\n%s\n%s", strings.Join(matches, "  "), prompt)
+				e.draw(0, line, pos)
+			}
+		case 16: // Ctrl-P, Up
+			if recall > 0 {
+				if recall == len(e.history) {
+					typed = line
+				}
+				recall--
+				next := []rune(e.history[recall])
+				set(next, len(next))
+			}
+		case 14: // Ctrl-N, Down
+			if recall < len(e.history) {
+				recall++
+				next := typed
+				if recall < len(e.history) {
+					next = []rune(e.history[recall])
+				}
+				set(next, len(next))
+			}
+		default:
+			if r >= ' ' {
+				next := append(line[:pos:pos], r)
+				set(append(next, line[pos:]...), pos+1)
+			}
+		}
+	}
+}
+
+// the key of the escape sequence after ESC, as the control character of
+// the same key
+func (e *lineEditor) escape() rune {
+	if r, _, err := e.in.ReadRune(); err != nil || (r != '[' && r != 'O') {
+		return keyNone
+	}
+	var param []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return keyNone
+		}
+		if r >= '0' && r <= '9' || r == ';' {
+			param = append(param, r)
+			continue
+		}
+		switch r {
+		case 'A':
+			return 16
+		case 'B':
+			return 14
+		case 'C':
+			return 6
+		case 'D':
+			return 2
+		case 'H':
+			return 1
+		case 'F':
+			return 5
+		case '~':
+			switch string(param) {
+			case "1", "7":
+				return 1
+			case "4", "8":
+				return 5
+			case "3":
+				return keyDelete
+			}
+		}
+		return keyNone
+	}
+}
+
+// redraws the line from its start, the cursor at old, and puts the cursor
+// at pos
+func (e *lineEditor) draw(old int, line []rune, pos int) {
+	var b strings.Builder
+	if old > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", old)
+	}
+	b.WriteString(string(line))
+	b.WriteString("\x1b[K")
+	if back := len(line) - pos; back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	io.WriteString(e.out, b.String())
+}
+
+// the longest start of all the words
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// a line ending with a backslash, or with a quote left open, goes on with
+// the next one. a backslash in double quotes escapes the next character, as
+// in the Go quoted values of INSERT.
+func continued(input string) bool {
+	if strings.HasSuffix(input, "\\") {
+		return true
+	}
+	var quote byte
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return quote != 0
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"atomixDB/database/helper"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	editor := func(keys string, history ...string) *lineEditor {
+		return &lineEditor{in: bufio.NewReader(strings.NewReader(keys)), out: io.Discard, history: history}
+	}
+	for keys, want := range map[string]string{
+		"abc\x1b[D\x1b[DX\x1b[F!\r":   "aXbc!",
+		"hello\x01\x0bbye\r":          "bye",
+		"one two\x17\r":               "one ",
+		"abc\x7f\x02\x02\x1b[3~\n":    "b",
+		"ab\x02\x02\x04\r":            "b",
+		"\x1b[A\x1b[A\x1b[B!\r":       "b!",
+		"x\x1b[A\x1b[A\x1b[B\x1b[B\r": "x",
+		"\x1bOH1\x1b[4~2\x1b[1~0\r":   "012",
+	} {
+		line, err := editor(keys, "a", "b").readLine()
+		if err != nil || line != want+"\n" {
+			t.Errorf("expected %q for %q, got %q %v", want, keys, line, err)
+		}
+	}
+	if _, err := editor("abc\x03").readLine(); err != errInterrupted {
+		t.Errorf("expected errInterrupted for Ctrl-C, got %v", err)
+	}
+	if _, err := editor("\x04").readLine(); err != io.EOF {
+		t.Errorf("expected io.EOF for Ctrl-D, got %v", err)
+	}
+
+	// the history is appended to its file & cut to HISTORY_MAX when loaded
+	file := filepath.Join(t.TempDir(), "history")
+	ed := editor("")
+	ed.loadHistory(file)
+	for _, line := range []string{"scan users", "scan users", " ", "select 'a\nb';", "tables"} {
+		ed.add(line)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "scan users\ntables\n" {
+		t.Errorf("expected 2 lines in the file, got %q %v", data, err)
+	}
+	var lines strings.Builder
+	for i := 0; i < HISTORY_MAX+10; i++ {
+		fmt.Fprintf(&lines, "get %d\n", i)
+	}
+	os.WriteFile(file, []byte(lines.String()), 0600)
+	ed.loadHistory(file)
+	if len(ed.history) != HISTORY_MAX || ed.history[0] != "get 10" {
+		t.Errorf("expected the last %d lines, got %d from %q", HISTORY_MAX, len(ed.history), ed.history[0])
+	}
+	if ed.loadHistory(file); len(ed.history) != HISTORY_MAX {
+		t.Errorf("expected the file cut to %d lines, got %d", HISTORY_MAX, len(ed.history))
+	}
+	(*lineEditor)(nil).add("scan users")
+
+	// Ctrl-C in a command cancels it, the next line is a command again
+	replEditor = editor("users\r\x03scan\r")
+	defer func() { replEditor = nil }()
+	lr := &lineReader{}
+	scanner := bufio.NewReader(lr)
+	ok := lr.run(func() {
+		scanner.ReadString('\n')
+		scanner.ReadString('\n')
+		t.Errorf("expected the command to be cancelled")
+	})
+	if line, err := scanner.ReadString('\n'); ok || line != "scan\n" || err != nil {
+		t.Errorf("expected the cancel & the next line, got %v %q %v", ok, line, err)
+	}
+	replEditor = nil
+
+	for input, want := range map[string]bool{
+		`select 'it''s'`: false, `select 'it`: true, `select "a" \`: true, `get users`: false, `a"b'c"`: false,
+	} {
+		if continued(input) != want {
+			t.Errorf("expected %v for %q", want, input)
+		}
+	}
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	_, stderr := captureOutput(t, func() { repl(db, strings.NewReader("drop \\\n  nothing\n"), true) })
+	if stderr != "line 1: Error dropping table: table 'nothing' does not exist\n" {
+		t.Errorf("expected the lines joined, got %q", stderr)
+	}
+
+	td, err := helper.ParseTableInput("t cols=id,a,b types=1,2,3 indexes=a unique=b+a autoinc notnull=a defaults=b=1,a=x foreign=a>p.id:cascade")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if again, err := helper.ParseTableInput(td.Line()); err != nil || !reflect.DeepEqual(td, again) {
+		t.Errorf("expected %+v to read back from %q, got %+v %v", td, td.Line(), again, err)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	defer func() { helper.Answer, helper.AnswerTable, helper.AnswerCols = helper.ANSWER_ANY, "", nil }()
+
+	var tx DBTX
+	db.Begin(&tx)
+	for _, name := range []string{"users", "teams"} {
+		tdef := &TableDef{Name: name, Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "name"}, PKeys: 1}
+		if err := tx.TableNew(tdef); err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	complete := func(answer int, before string) string {
+		helper.Answer = answer
+		word, matches := replCompletions(db, nil, before)
+		return word + ":" + strings.Join(matches, ",")
+	}
+	for before, want := range map[string]string{
+		"sc":              "sc:scan",
+		"SAVE":            "SAVE:savepoint",
+		"e":               "e:exit,export",
+		"scan ":           ":teams,users",
+		"GET u":           "u:users",
+		"select * from t": "t:teams",
+		"tables u":        ":",
+		"scan users i":    ":",
+	} {
+		if got := complete(helper.ANSWER_COMMAND, before); got != want {
+			t.Errorf("expected %q for %q, got %q", want, before, got)
+		}
+	}
+	if got := complete(helper.ANSWER_TABLE, "te"); got != "te:teams" {
+		t.Errorf("expected teams, got %q", got)
+	}
+	helper.AnswerTable = "users"
+	if got := complete(helper.ANSWER_COLUMNS, "id, n"); got != "n:name" {
+		t.Errorf("expected the column of users, got %q", got)
+	}
+	helper.AnswerCols = []string{"a", "ab"}
+	if got := complete(helper.ANSWER_COLUMNS, "id+a"); got != "a:a,ab" {
+		t.Errorf("expected the columns being created, got %q", got)
+	}
+	if got := complete(helper.ANSWER_ANY, "u"); got != ":" {
+		t.Errorf("expected no completion of a value, got %q", got)
+	}
+
+	// the tables of the open transaction, and of the catalog after it
+	db.Begin(&tx)
+	if err := tx.DropTable("teams"); err != nil {
+		t.Fatalf("failed to drop: %v", err)
+	}
+	helper.Answer = helper.ANSWER_TABLE
+	if _, matches := replCompletions(db, &tx, ""); !reflect.DeepEqual(matches, []string{"users"}) {
+		t.Errorf("expected the tables of the transaction, got %v", matches)
+	}
+	if _, matches := replCompletions(db, nil, ""); len(matches) != 2 {
+		t.Errorf("expected the committed tables, got %v", matches)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if _, matches := replCompletions(db, nil, ""); !reflect.DeepEqual(matches, []string{"users"}) {
+		t.Errorf("expected the table dropped to be gone, got %v", matches)
+	}
+
+	// Tab puts in the match, the start of all the matches, or lists them
+	var out strings.Builder
+	ed := &lineEditor{
+		in:     bufio.NewReader(strings.NewReader("sc\tu\tx\r" + "t\t\t\r")),
+		out:    &out,
+		prompt: func() string { return "> " },
+		complete: func(before string) (string, []string) {
+			word := before[strings.LastIndex(before, " ")+1:]
+			return word, matching([]string{"scan", "users", "teams", "tests"}, word)
+		},
+	}
+	if line, err := ed.readLine(); err != nil || line != "scan users x\n" {
+		t.Errorf("expected the words completed, got %q %v", line, err)
+	}
+	if line, err := ed.readLine(); err != nil || line != "te\n" || !strings.Contains(out.String(), "\nteams  tests\n> ") {
+		t.Errorf("expected the matches listed, got %q %v %q", line, err, out.String())
+	}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTableNotFound  error = errors.New("table not found")
+	ErrColumnNotFound error = errors.New("column not found")
+	ErrBadRange       error = errors.New("bad range")
+	ErrTypeMismatch   error = errors.New("type mismatch")
+	ErrOverflow       error = errors.New("integer overflow")
+	ErrMemoryLimit    error = errors.New("memory limit exceeded")
+)
+
+// the names of the errors of a missing row, an existing primary key & a
+// rejected commit, the same errors as ErrNotFound, ErrExists & ErrConflict
+var (
+	ErrRecordNotFound = ErrNotFound
+	ErrDuplicateKey   = ErrExists
+	ErrTxConflict     = ErrConflict
+)
+
+// an error about a table, one of its columns or one of its rows. matches Err
+// with errors.Is, see ConflictError for a rejected commit.
+type StructuredError struct {
+	Err    error
+	Table  string
+	Column string // empty if not about a column
+	PKey   Record // the primary key of the row, empty if not about a row
+}
+
+func (e *StructuredError) Error() string {
+	switch {
+	case len(e.PKey.Cols) > 0:
+		return fmt.Sprintf("%v on table %s, primary key %s", e.Err, e.Table, formatRecord(e.PKey))
+	case e.Column != "" && e.Table != "":
+		return fmt.Sprintf("%v: %s in table %s", e.Err, e.Column, e.Table)
+	case e.Column != "":
+		return fmt.Sprintf("%v: %s", e.Err, e.Column)
+	default:
+		return fmt.Sprintf("%v: %s", e.Err, e.Table)
+	}
+}
+
+func (e *StructuredError) Unwrap() error {
+	return e.Err
+}
+
+func tableNotFound(table string) error {
+	return &StructuredError{Err: ErrTableNotFound, Table: table}
+}
+
+func columnNotFound(table string, col string) error {
+	return &StructuredError{Err: ErrColumnNotFound, Table: table, Column: col}
+}
+
+// the error of a row with the primary key values
+func rowError(tdef *TableDef, pkey []Value, err error) error {
+	key := Record{Cols: tdef.Cols[:tdef.PKeys], Vals: append([]Value(nil), pkey[:tdef.PKeys]...)}
+	return &StructuredError{Err: err, Table: tdef.Name, PKey: key}
+}
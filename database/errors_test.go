@@ -0,0 +1,101 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	insertTestRecord(t, db, 1)
+	id := func(n int64) Record { return *(&Record{}).AddInt64("id", n) }
+
+	var tx DBTX
+	db.Begin(&tx)
+	defer db.Abort(&tx)
+	rec := id(1)
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id(1), Key2: id(2)}
+	for name, fn := range map[string]func() error{
+		"get":    func() error { _, err := tx.Get("missing", &rec); return err },
+		"scan":   func() error { return tx.Scan("missing", &sc) },
+		"set":    func() error { _, err := tx.Set("missing", userRecord(2, "a"), MODE_UPSERT); return err },
+		"delete": func() error { _, err := tx.Delete("missing", id(1)); return err },
+		"count":  func() error { _, err := tx.RowCount("missing"); return err },
+		"drop":   func() error { return tx.DropTable("missing") },
+	} {
+		err := fn()
+		var se *StructuredError
+		if !errors.Is(err, ErrTableNotFound) || !errors.As(err, &se) || se.Table != "missing" {
+			t.Errorf("%s: expected ErrTableNotFound of table missing, got %v", name, err)
+		}
+	}
+
+	err := tx.UpdatePartial("users", *(&Record{}).AddInt64("id", 1).AddStr("phone", nil))
+	var se *StructuredError
+	if !errors.Is(err, ErrColumnNotFound) || !errors.As(err, &se) || se.Table != "users" || se.Column != "phone" {
+		t.Errorf("expected ErrColumnNotFound of users.phone, got %v", err)
+	}
+
+	// the row errors carry the primary key
+	for _, tt := range []struct {
+		fn   func() error
+		want error
+	}{
+		{func() error { _, err := tx.Set("users", userRecord(1, "a"), MODE_INSERT_ONLY); return err }, ErrDuplicateKey},
+		{func() error { _, err := tx.Set("users", userRecord(5, "a"), MODE_UPDATE_ONLY); return err }, ErrRecordNotFound},
+		{func() error { return tx.UpdatePartial("users", *(&Record{}).AddInt64("id", 5).AddStr("name", nil)) }, ErrRecordNotFound},
+		{func() error { _, err := tx.Delete("users", id(5)); return err }, ErrRecordNotFound},
+	} {
+		err := tt.fn()
+		var se *StructuredError
+		if !errors.Is(err, tt.want) || !errors.As(err, &se) || se.Table != "users" || len(se.PKey.Cols) != 1 {
+			t.Errorf("expected %v with the primary key, got %v", tt.want, err)
+		}
+	}
+
+	for _, sc := range []Scanner{
+		{Cmp1: CMP_GE, Cmp2: CMP_GT},
+		{Cmp1: CMP_LE, Cmp2: CMP_GE, Desc: true},
+	} {
+		if err := tx.Scan("users", &sc); !errors.Is(err, ErrBadRange) {
+			t.Errorf("expected ErrBadRange, got %v", err)
+		}
+	}
+
+	// a conflict of two transactions
+	var other DBTX
+	db.Begin(&other)
+	for _, t2 := range []*DBTX{&tx, &other} {
+		if _, err := t2.Set("users", userRecord(1, "b"), MODE_UPSERT); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	if err := db.Commit(&other); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	err = db.Commit(&tx)
+	if !errors.Is(err, ErrTxConflict) {
+		t.Errorf("expected ErrTxConflict, got %v", err)
+	}
+	if _, err := tx.Get("users", &rec); !errors.Is(err, ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	users := &TableDef{Name: "u", Cols: []string{"id"}, PKeys: 1}
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{tableNotFound("orders"), "table 'orders' does not exist"},
+		{columnNotFound("u", "x"), "table 'u' has no column 'x'"},
+		{rowError(users, []Value{{Type: TYPE_INT64, I64: 7}}, ErrExists), "the row id=7 of table 'u' already exists, use UPDATE or UPSERT to change it"},
+		{ErrConflict, "another transaction changed the same rows and committed first, run the transaction again"},
+		{errors.New("something else"), "something else"},
+	} {
+		if got := friendlyError(tt.err); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
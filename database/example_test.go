@@ -0,0 +1,143 @@
+package database_test
+
+import (
+	"atomixDB/database"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a table created, filled & scanned through the public API only
+func Example() {
+	db, err := database.Open(database.MEMORY_PATH)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	err = db.Transact(func(tx *database.DBTX) error {
+		err := tx.TableNew(&database.TableDef{
+			Name:    "users",
+			Types:   []uint32{database.TYPE_INT64, database.TYPE_BYTES, database.TYPE_BYTES},
+			Cols:    []string{"id", "name", "email"},
+			PKeys:   1,
+			Indexes: [][]string{{"name"}},
+		})
+		if err != nil {
+			return err
+		}
+		for id, name := range []string{"ann", "bob", "cy"} {
+			row := (&database.Record{}).AddInt64("id", int64(id+1)).AddStr("name", []byte(name)).AddStr("email", []byte(name+"@example.com"))
+			if _, err := tx.Set("users", *row, database.MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.View(func(tx *database.DBReader) error {
+		sc, err := tx.ScanAll("users")
+		if err != nil {
+			return err
+		}
+		for ; sc.Valid(); sc.Next() {
+			var rec database.Record
+			if err := sc.Read(&rec); err != nil {
+				return err
+			}
+			fmt.Println(rec.Get("id").I64, string(rec.Get("name").Str), string(rec.Get("email").Str))
+		}
+		return sc.Err()
+	})
+	if err != nil {
+		panic(err)
+	}
+	// Output:
+	// 1 ann ann@example.com
+	// 2 bob bob@example.com
+	// 3 cy cy@example.com
+}
+
+func TestOpenOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	db, err := database.Open(path, database.WithSync(database.SYNC_PERIODIC, time.Second), database.WithCacheSize(1<<20))
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	tdef := &database.TableDef{Name: "kv", Types: []uint32{database.TYPE_BYTES, database.TYPE_INT64}, Cols: []string{"k", "v"}, PKeys: 1}
+	err = db.Transact(func(tx *database.DBTX) error {
+		if err := tx.TableNew(tdef); err != nil {
+			return err
+		}
+		for i, k := range []string{"a1", "a2", "b1"} {
+			if _, err := tx.Set("kv", *(&database.Record{}).AddStr("k", []byte(k)).AddInt64("v", int64(i)), database.MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	// an error aborts the writes of the function
+	failed := errors.New("failed")
+	err = db.Transact(func(tx *database.DBTX) error {
+		tx.Set("kv", *(&database.Record{}).AddStr("k", []byte("c1")).AddInt64("v", 9), database.MODE_INSERT_ONLY)
+		if n, err := tx.Count("kv", &database.Scanner{Cmp1: database.CMP_GE, Cmp2: database.CMP_LE}); n != 4 || err != nil {
+			t.Errorf("expected the row of the transaction counted, got %d %v", n, err)
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("expected the error of the function, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// read back read-only, without a tree in any call
+	db, err = database.Open(path, database.WithReadOnly())
+	if err != nil {
+		t.Fatalf("failed to open read-only: %v", err)
+	}
+	err = db.View(func(tx *database.DBReader) error {
+		tables, err := tx.ListTables()
+		if err != nil || !reflect.DeepEqual(tables, []string{"kv"}) {
+			t.Errorf("expected the table, got %v %v", tables, err)
+		}
+		sc, err := tx.ScanPrefix("kv", *(&database.Record{}).AddStr("k", []byte("a1")))
+		if err != nil {
+			return err
+		}
+		var rows []struct {
+			K string
+			V int64
+		}
+		if err := sc.ReadStructs(&rows); err != nil || len(rows) != 1 || rows[0].K != "a1" {
+			t.Errorf("expected the row a1, got %v %v", rows, err)
+		}
+		if n, err := tx.RowCount("kv"); n != 3 || err != nil {
+			t.Errorf("expected 3 rows, got %d %v", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("failed to read: %v", err)
+	}
+	err = db.Transact(func(tx *database.DBTX) error {
+		_, err := tx.Set("kv", *(&database.Record{}).AddStr("k", []byte("c1")).AddInt64("v", 9), database.MODE_INSERT_ONLY)
+		return err
+	})
+	if !errors.Is(err, database.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// how Scan reads the rows of a request, see Explain
+type ScanPlan struct {
+	Table     string
+	IndexNo   int      // -1: the primary key; >= 0: an index
+	Index     []string // the columns of the primary key or the index
+	Start     []byte   // the encoded key the scan starts from
+	CmpStart  int
+	End       []byte // the encoded key the scan stops at
+	CmpEnd    int
+	Desc      bool
+	Fetch     bool  // each index entry reads its primary row
+	Filter    *Expr // checked on each row, the part the range does not cover
+	Distinct  []string
+	Adjacent  bool // the rows equal on Distinct are next to each other
+	Limit     int
+	Rows      int64    // the estimated rows, -1 when unknown
+	Estimated bool     // Rows is from the statistics of Analyze, not exact
+	Actual    int64    // the rows of the scan run by ExplainAnalyze, -1 when not run
+	Warnings  []string // of the request, e.g. a prefix the range does not cover
+}
+
+// the decisions of Scan for the request, without reading a row. the request
+// is not changed.
+//
+// Deprecated: use DBTX.Explain or DBReader.Explain.
+func (db *DB) Explain(table string, req *Scanner, tree *BTree) (*ScanPlan, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	sc := *req
+	if err := scanSetup(db, tdef, &sc, tree); err != nil {
+		return nil, err
+	}
+	plan := &ScanPlan{
+		Table: tdef.Name, IndexNo: sc.indexNo, Index: tdef.Cols[:tdef.PKeys],
+		Start: sc.keyStart, CmpStart: sc.cmpStart, End: sc.keyEnd, CmpEnd: sc.cmpEnd,
+		Desc: sc.desc, Filter: sc.filter, Limit: sc.Limit, Rows: -1, Actual: -1,
+	}
+	if sc.indexNo >= 0 {
+		plan.Index = tdef.Indexes[sc.indexNo]
+		plan.Fetch = !sc.covering
+	}
+	if sc.distinct != nil {
+		plan.Distinct, plan.Adjacent = sc.DistinctCols, sc.distinct.adjacent
+	}
+	for _, e := range prefixFilters(sc.filter) {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+			"%v is checked on each row, %s is not the next column of the range of the primary key or an index", e, e.Args[0].Col))
+	}
+
+	// the exact count of the rows for a whole table, 1 for a primary key
+	switch key := sc.Key1.Vals; {
+	case sc.filter != nil || sc.distinct != nil:
+	case sc.indexNo < 0 && len(key) == tdef.PKeys && sc.Cmp1 == CMP_GE && sc.Cmp2 == CMP_LE && rowEqual(key, sc.Key2.Vals):
+		plan.Rows = 1
+	case len(key) == 0 && len(sc.Key2.Vals) == 0:
+		if _, ok, err := tree.Get(rowCountKey(tdef)); err == nil && ok {
+			if plan.Rows, err = autoincGet(tree, rowCountKey(tdef)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// the estimate of the range & the filter from the statistics
+	if plan.Rows < 0 && sc.distinct == nil {
+		stats, err := analysisGet(tree, tdef)
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			conds, _ := exprConds(sc.filter)
+			rows := stats.estimate(tdef, append(scanConds(tdef, &sc), conds...))
+			plan.Rows, plan.Estimated = int64(rows+0.5), true
+		}
+	}
+	if plan.Rows >= 0 && plan.Limit > 0 {
+		plan.Rows = min(plan.Rows, int64(plan.Limit))
+	}
+	return plan, nil
+}
+
+// Explain, then run the scan to count its rows into Actual
+//
+// Deprecated: use DBTX.ExplainAnalyze or DBReader.ExplainAnalyze.
+func (db *DB) ExplainAnalyze(table string, req *Scanner, tree *BTree) (*ScanPlan, error) {
+	plan, err := db.Explain(table, req, tree)
+	if err != nil {
+		return nil, err
+	}
+	sc := *req
+	if plan.Actual, err = db.Count(table, &sc, tree); err != nil {
+		return nil, err
+	}
+	return plan, sc.Err()
+}
+
+// the prefixes of a column in the filter
+func prefixFilters(e *Expr) []*Expr {
+	if e == nil {
+		return nil
+	}
+	if e.Kind == EXPR_CMP && e.Cmp == CMP_PREFIX && e.Args[0].Kind == EXPR_COLUMN {
+		return []*Expr{e}
+	}
+	var found []*Expr
+	for _, arg := range e.Args {
+		found = append(found, prefixFilters(arg)...)
+	}
+	return found
+}
+
+func rowEqual(v1, v2 []Value) bool {
+	if len(v1) != len(v2) {
+		return false
+	}
+	for i := range v1 {
+		if !compareValues(v1[i], v2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *ScanPlan) String() string {
+	path := "primary key"
+	if p.IndexNo >= 0 {
+		path = "index"
+	}
+	order := "ascending"
+	if p.Desc {
+		order = "descending"
+	}
+	lines := []string{
+		fmt.Sprintf("table:  %s", p.Table),
+		fmt.Sprintf("path:   %s (%s)", path, strings.Join(p.Index, ", ")),
+		fmt.Sprintf("start:  %s %x", cmpName(p.CmpStart), p.Start),
+		fmt.Sprintf("end:    %s %x", cmpName(p.CmpEnd), p.End),
+		fmt.Sprintf("order:  %s", order),
+	}
+	if p.Fetch {
+		lines = append(lines, "fetch:  the primary row of each index entry")
+	}
+	if p.Filter != nil {
+		lines = append(lines, fmt.Sprintf("filter: %v", p.Filter))
+	}
+	if p.Distinct != nil {
+		how := "in memory"
+		if p.Adjacent {
+			how = "in the order of the scan"
+		}
+		lines = append(lines, fmt.Sprintf("distinct: %s, %s", strings.Join(p.Distinct, ", "), how))
+	}
+	if p.Limit > 0 {
+		lines = append(lines, fmt.Sprintf("limit:  %d", p.Limit))
+	}
+	switch {
+	case p.Rows >= 0 && p.Estimated:
+		lines = append(lines, fmt.Sprintf("rows:   %d estimated", p.Rows))
+	case p.Rows >= 0:
+		lines = append(lines, fmt.Sprintf("rows:   %d", p.Rows))
+	default:
+		lines = append(lines, "rows:   unknown")
+	}
+	if p.Actual >= 0 {
+		lines = append(lines, fmt.Sprintf("actual: %d", p.Actual))
+	}
+	for _, w := range p.Warnings {
+		lines = append(lines, "warning: "+w)
+	}
+	return strings.Join(lines, "\n")
+}
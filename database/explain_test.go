@@ -0,0 +1,61 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 5; id++ {
+		insertIndexedRecord(t, db, id, string(rune('a'+id))+"@x")
+	}
+
+	email := func(s string) Record { return *(&Record{}).AddStr("email", []byte(s)) }
+	id := func(n int64) Record { return *(&Record{}).AddInt64("id", n) }
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	for _, tt := range []struct {
+		name  string
+		sc    Scanner
+		index int
+		fetch bool
+		rows  int64
+	}{
+		{"primary range", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id(2), Key2: id(4)}, -1, false, -1},
+		{"primary row", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id(2), Key2: id(2)}, -1, false, 1},
+		{"index", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: email("b"), Key2: email("d")}, 0, true, -1},
+		{"covering", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: email("b"), Key2: email("d"), Project: []string{"email", "id"}}, 0, false, -1},
+		{"descending", Scanner{Cmp1: CMP_LE, Cmp2: CMP_GE, Key1: id(4), Key2: id(2)}, -1, false, -1},
+		{"whole table", Scanner{Limit: 3}, -1, false, 3},
+		{"planned filter", Scanner{Filter: CompareExpr(ColumnExpr("email"), CMP_EQ, LiteralExpr(email("c@x").Vals[0]))}, 0, true, -1},
+	} {
+		req := tt.sc
+		plan, err := reader.Explain("people", &req)
+		if err != nil {
+			t.Fatalf("%s: failed to explain: %v", tt.name, err)
+		}
+		if req.Cmp1 != tt.sc.Cmp1 || req.iter != nil {
+			t.Errorf("%s: expected the request unchanged", tt.name)
+		}
+		if plan.IndexNo != tt.index || plan.Fetch != tt.fetch || plan.Rows != tt.rows {
+			t.Errorf("%s: expected index %d, fetch %v, rows %d, got\n%v", tt.name, tt.index, tt.fetch, tt.rows, plan)
+		}
+		// the plan is what Scan does
+		if err := reader.Scan("people", &req); err != nil {
+			t.Fatalf("%s: failed to scan: %v", tt.name, err)
+		}
+		if req.indexNo != plan.IndexNo || req.desc != plan.Desc || !bytes.Equal(req.keyStart, plan.Start) || !bytes.Equal(req.keyEnd, plan.End) {
+			t.Errorf("%s: expected the plan of the scan, got\n%v", tt.name, plan)
+		}
+	}
+	if plan, _ := reader.Explain("people", &Scanner{Cmp1: CMP_LE, Cmp2: CMP_GE, Key1: id(4), Key2: id(2)}); !plan.Desc {
+		t.Errorf("expected a descending scan")
+	}
+	if _, err := reader.Explain("people", &Scanner{Cmp1: CMP_GE, Cmp2: CMP_GE}); err == nil {
+		t.Errorf("expected the error of a bad range")
+	}
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// the output of Export. a CSV export reads back by Import as it was
+// written, but for a CR LF in a value that reads back as LF, see
+// encoding/csv.
+type Format int
+
+const (
+	EXPORT_CSV   Format = 0 // a header row & a row per line, a null is NULL as typed in the REPL
+	EXPORT_JSONL Format = 1 // a JSON object per line, the bytes in base64
+)
+
+// write the rows of the scan to w, the columns of req.Project or all of them.
+// a nil req is the whole table. the rows are written as they are scanned,
+// the number written is returned.
+//
+// Deprecated: use DBTX.Export or DBReader.Export.
+func (db *DB) Export(table string, req *Scanner, w io.Writer, format Format, tree *BTree) (int, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return 0, tableNotFound(table)
+	}
+	if req == nil {
+		req = &Scanner{}
+	}
+	if err := dbScan(db, tdef, req, tree); err != nil {
+		return 0, err
+	}
+	cols := req.Project
+	if len(cols) == 0 {
+		cols = tdef.Cols
+	}
+	var out exportWriter
+	switch format {
+	case EXPORT_CSV:
+		out = &csvExport{w: csv.NewWriter(w)}
+	case EXPORT_JSONL:
+		out = &jsonExport{w: bufio.NewWriter(w)}
+	default:
+		return 0, fmt.Errorf("unknown export format %d", format)
+	}
+	if err := out.header(cols); err != nil {
+		return 0, err
+	}
+	n := 0
+	rec := Record{}
+	for ; req.Valid(); req.Next() {
+		if err := req.Deref(&rec, tree); err != nil {
+			return n, err
+		}
+		if err := out.row(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := req.Err(); err != nil {
+		return n, err
+	}
+	return n, out.flush()
+}
+
+type exportWriter interface {
+	header(cols []string) error
+	row(rec Record) error
+	flush() error
+}
+
+type csvExport struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func (e *csvExport) header(cols []string) error {
+	return e.w.Write(cols)
+}
+
+func (e *csvExport) row(rec Record) error {
+	e.fields = e.fields[:0]
+	for _, v := range rec.Vals {
+		e.fields = append(e.fields, formatValue(v))
+	}
+	return e.w.Write(e.fields)
+}
+
+func (e *csvExport) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExport struct {
+	w    *bufio.Writer
+	keys [][]byte // the encoded column names
+	line []byte
+}
+
+func (e *jsonExport) header(cols []string) error {
+	for _, col := range cols {
+		key, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		e.keys = append(e.keys, key)
+	}
+	return nil
+}
+
+// the keys in the column order
+func (e *jsonExport) row(rec Record) error {
+	line := append(e.line[:0], '{')
+	for i, v := range rec.Vals {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(append(line, e.keys[i]...), ':')
+		var err error
+		if line, err = jsonValue(line, v); err != nil {
+			return fmt.Errorf("%s: %w", rec.Cols[i], err)
+		}
+	}
+	e.line = append(line, '}', '\n')
+	_, err := e.w.Write(e.line)
+	return err
+}
+
+func (e *jsonExport) flush() error {
+	return e.w.Flush()
+}
+
+func jsonValue(out []byte, v Value) ([]byte, error) {
+	if v.Null {
+		return append(out, "null"...), nil
+	}
+	switch v.Type {
+	case TYPE_INT64, TYPE_INT32:
+		return strconv.AppendInt(out, v.I64, 10), nil
+	case TYPE_BOOL:
+		return strconv.AppendBool(out, v.I64 != 0), nil
+	case TYPE_TIME:
+		return strconv.AppendQuote(out, v.Time().UTC().Format(time.RFC3339Nano)), nil
+	case TYPE_FLOAT64:
+		b, err := json.Marshal(v.F64) // fails for NaN & Inf
+		return append(out, b...), err
+	default:
+		return strconv.AppendQuote(out, base64.StdEncoding.EncodeToString(v.Str)), nil
+	}
+}
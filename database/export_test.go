@@ -0,0 +1,129 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	for _, name := range []string{"notes", "copy"} {
+		tdef := &TableDef{
+			Name:  name,
+			Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_FLOAT64, TYPE_BOOL, TYPE_TIME, TYPE_INT32},
+			Cols:  []string{"id", "text", "score", "done", "at", "n"},
+			PKeys: 1,
+		}
+		if err := tx.TableNew(tdef); err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+	texts := []string{"plain", "a, b", `say "hi"`, "two\nlines", "a\rb", "", " spaces ", "日本語", "\"\",,\n\""}
+	at := time.Date(2024, 2, 29, 13, 4, 5, 123456789, time.UTC)
+	for i, text := range texts {
+		rec := (&Record{}).AddInt64("id", int64(i)).AddStr("text", []byte(text)).
+			AddFloat64("score", 1/float64(i+3)).AddBool("done", i%2 == 0).AddTime("at", at.Add(time.Duration(i)*time.Hour))
+		if i == 3 {
+			rec.AddNull("n", TYPE_INT32)
+		} else {
+			rec.AddInt32("n", int32(-i))
+		}
+		if _, err := tx.Set("notes", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	rows := func(table string) []string {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		sc := Scanner{}
+		if err := reader.Scan(table, &sc); err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		var out []string
+		rec := Record{}
+		for ; sc.Valid(); sc.Next() {
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("failed to deref: %v", err)
+			}
+			out = append(out, fmt.Sprintf("%q", formatRecord(rec)))
+		}
+		return out
+	}
+
+	// CSV round trip through Import
+	var out bytes.Buffer
+	var reader DBReader
+	db.BeginRead(&reader)
+	n, err := reader.Export("notes", nil, &out, EXPORT_CSV)
+	db.EndRead(&reader)
+	if err != nil || n != len(texts) {
+		t.Fatalf("expected %d rows, got %d %v", len(texts), n, err)
+	}
+	if !strings.HasPrefix(out.String(), "id,text,score,done,at,n\n0,plain,") || !strings.Contains(out.String(), `"say ""hi"""`) {
+		t.Errorf("unexpected CSV %q", out.String())
+	}
+	stats, err := db.Import("copy", &out, ImportOptions{})
+	if err != nil || stats.Inserted != len(texts) || stats.Rejected != 0 {
+		t.Fatalf("failed to import the export: %+v %v", stats, err)
+	}
+	if want, got := rows("notes"), rows("copy"); strings.Join(want, "\n") != strings.Join(got, "\n") {
+		t.Errorf("expected the same rows:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// JSON Lines of a range with a projection
+	out.Reset()
+	var tx2 DBTX
+	db.Begin(&tx2)
+	sc := &Scanner{
+		Cmp1: CMP_GE, Key1: *(&Record{}).AddInt64("id", 2),
+		Cmp2: CMP_LE, Key2: *(&Record{}).AddInt64("id", 3),
+		Project: []string{"text", "n", "done", "at", "score"},
+	}
+	n, err = tx2.Export("notes", sc, &out, EXPORT_JSONL)
+	db.Abort(&tx2)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 rows, got %d %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := `{"text":"c2F5ICJoaSI=","n":-2,"done":true,"at":"2024-02-29T15:04:05.123456789Z","score":0.2}`
+	if len(lines) != 2 || lines[0] != want || !strings.Contains(lines[1], `"n":null`) {
+		t.Errorf("unexpected JSON lines %q", lines)
+	}
+	var obj struct{ Text []byte }
+	if err := json.Unmarshal([]byte(lines[1]), &obj); err != nil || string(obj.Text) != "two\nlines" {
+		t.Errorf("expected the bytes in base64, got %q %v", obj.Text, err)
+	}
+
+	db.Begin(&tx2)
+	rec := (&Record{}).AddInt64("id", 100).AddStr("text", nil).AddFloat64("score", math.NaN()).AddBool("done", false).AddTime("at", at).AddInt32("n", 0)
+	if _, err := tx2.Set("notes", *rec, MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := tx2.Export("notes", nil, &out, EXPORT_JSONL); err == nil || !strings.Contains(err.Error(), "score") {
+		t.Errorf("expected an error for NaN, got %v", err)
+	}
+	db.Abort(&tx2)
+	db.BeginRead(&reader)
+	if _, err := reader.Export("nothing", nil, &out, EXPORT_CSV); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if _, err := reader.Export("notes", nil, &out, Format(9)); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	db.EndRead(&reader)
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// the kinds of Expr
+const (
+	EXPR_COLUMN  = 1 // the value of the column Col
+	EXPR_LITERAL = 2 // the value Val
+	EXPR_CMP     = 3 // Args[0] Cmp Args[1]
+	EXPR_AND     = 4
+	EXPR_OR      = 5
+	EXPR_NOT     = 6
+	EXPR_IN      = 7 // Args[0] IN Args[1:]
+)
+
+// the comparisons of an Expr besides CMP_GE, CMP_GT, CMP_LT & CMP_LE, not
+// for the bounds of a scan
+const (
+	CMP_EQ     = +4 // =
+	CMP_NE     = -4 // !=
+	CMP_PREFIX = +5 // the string starts with the other one, LIKE 'prefix%'
+)
+
+// a predicate or a value computed from a row. a comparison with a NULL is
+// NULL, as is the AND & OR of NULL with true & false respectively, and a row
+// passes a filter only if it is true.
+type Expr struct {
+	Kind int
+	Col  string  // EXPR_COLUMN
+	Val  Value   // EXPR_LITERAL, a NULL without a type compares to any column
+	Cmp  int     // EXPR_CMP
+	Args []*Expr // 2 of EXPR_CMP, 1 or more of EXPR_AND & EXPR_OR, 1 of EXPR_NOT, 2 or more of EXPR_IN
+}
+
+func ColumnExpr(col string) *Expr {
+	return &Expr{Kind: EXPR_COLUMN, Col: col}
+}
+
+func LiteralExpr(v Value) *Expr {
+	return &Expr{Kind: EXPR_LITERAL, Val: v}
+}
+
+func CompareExpr(a *Expr, cmp int, b *Expr) *Expr {
+	return &Expr{Kind: EXPR_CMP, Cmp: cmp, Args: []*Expr{a, b}}
+}
+
+func AndExpr(args ...*Expr) *Expr {
+	return &Expr{Kind: EXPR_AND, Args: args}
+}
+
+func OrExpr(args ...*Expr) *Expr {
+	return &Expr{Kind: EXPR_OR, Args: args}
+}
+
+func NotExpr(e *Expr) *Expr {
+	return &Expr{Kind: EXPR_NOT, Args: []*Expr{e}}
+}
+
+// a IN (list), NULL when it is not in the list & the list has a NULL
+func InExpr(a *Expr, list ...*Expr) *Expr {
+	return &Expr{Kind: EXPR_IN, Args: append([]*Expr{a}, list...)}
+}
+
+func cmpName(cmp int) string {
+	switch cmp {
+	case CMP_EQ:
+		return "="
+	case CMP_NE:
+		return "!="
+	case CMP_PREFIX:
+		return "starts with"
+	case CMP_GE:
+		return ">="
+	case CMP_GT:
+		return ">"
+	case CMP_LT:
+		return "<"
+	case CMP_LE:
+		return "<="
+	default:
+		return fmt.Sprintf("cmp %d", cmp)
+	}
+}
+
+func (e *Expr) String() string {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		return e.Col
+	case EXPR_LITERAL:
+		if e.Val.Type == TYPE_BYTES && !e.Val.Null {
+			return fmt.Sprintf("%q", e.Val.Str)
+		}
+		return formatValue(e.Val)
+	case EXPR_CMP:
+		return fmt.Sprintf("%v %s %v", e.Args[0], cmpName(e.Cmp), e.Args[1])
+	case EXPR_NOT:
+		return fmt.Sprintf("NOT (%v)", e.Args[0])
+	case EXPR_IN:
+		list := make([]string, len(e.Args)-1)
+		for i, arg := range e.Args[1:] {
+			list[i] = arg.String()
+		}
+		return fmt.Sprintf("%v IN (%s)", e.Args[0], strings.Join(list, ", "))
+	case EXPR_AND, EXPR_OR:
+		op := " AND "
+		if e.Kind == EXPR_OR {
+			op = " OR "
+		}
+		var buf bytes.Buffer
+		for i, arg := range e.Args {
+			if i > 0 {
+				buf.WriteString(op)
+			}
+			fmt.Fprintf(&buf, "(%v)", arg)
+		}
+		return buf.String()
+	default:
+		return fmt.Sprintf("expr %d", e.Kind)
+	}
+}
+
+// the int types compare with each other
+func sameType(t1, t2 uint32) bool {
+	isInt := func(t uint32) bool { return t == TYPE_INT64 || t == TYPE_INT32 }
+	return t1 == t2 || t1 == 0 || t2 == 0 || (isInt(t1) && isInt(t2))
+}
+
+// the type of the expression over the columns of the table, 0 for an untyped
+// NULL. an error for a missing column or operands of the wrong types.
+func checkExpr(tdef *TableDef, e *Expr) (uint32, error) {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		idx := ColIndex(tdef, e.Col)
+		if idx < 0 {
+			return 0, columnNotFound(tdef.Name, e.Col)
+		}
+		return tdef.Types[idx], nil
+	case EXPR_LITERAL:
+		if e.Val.Type == 0 && !e.Val.Null {
+			return 0, fmt.Errorf("%w: literal without a type", ErrTypeMismatch)
+		}
+		return e.Val.Type, nil
+	case EXPR_CMP:
+		switch e.Cmp {
+		case CMP_EQ, CMP_NE, CMP_PREFIX, CMP_GE, CMP_GT, CMP_LT, CMP_LE:
+		default:
+			return 0, fmt.Errorf("bad comparison: %d", e.Cmp)
+		}
+		if len(e.Args) != 2 {
+			return 0, fmt.Errorf("%s takes 2 operands, got %d", cmpName(e.Cmp), len(e.Args))
+		}
+		t1, err := checkExpr(tdef, e.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		t2, err := checkExpr(tdef, e.Args[1])
+		if err != nil {
+			return 0, err
+		}
+		if !sameType(t1, t2) {
+			return 0, fmt.Errorf("%w: %v is %s, %v is %s", ErrTypeMismatch,
+				e.Args[0], typeName(t1), e.Args[1], typeName(t2))
+		}
+		if e.Cmp == CMP_PREFIX && !(sameType(t1, TYPE_BYTES) && sameType(t2, TYPE_BYTES)) {
+			return 0, fmt.Errorf("%w: a prefix of %v, not a string", ErrTypeMismatch, e.Args[0])
+		}
+		return TYPE_BOOL, nil
+	case EXPR_IN:
+		if len(e.Args) < 2 {
+			return 0, fmt.Errorf("IN takes a list, got %v", e)
+		}
+		typ, err := checkExpr(tdef, e.Args[0])
+		if err != nil {
+			return 0, err
+		}
+		for _, arg := range e.Args[1:] {
+			t, err := checkExpr(tdef, arg)
+			if err != nil {
+				return 0, err
+			}
+			if !sameType(typ, t) {
+				return 0, fmt.Errorf("%w: %v is %s, %v is %s", ErrTypeMismatch,
+					e.Args[0], typeName(typ), arg, typeName(t))
+			}
+		}
+		return TYPE_BOOL, nil
+	case EXPR_AND, EXPR_OR, EXPR_NOT:
+		if len(e.Args) == 0 || (e.Kind == EXPR_NOT && len(e.Args) != 1) {
+			return 0, fmt.Errorf("bad number of operands: %v", e)
+		}
+		for _, arg := range e.Args {
+			typ, err := checkExpr(tdef, arg)
+			if err != nil {
+				return 0, err
+			}
+			if typ != TYPE_BOOL && typ != 0 {
+				return 0, fmt.Errorf("%w: %v is %s, not bool", ErrTypeMismatch, arg, typeName(typ))
+			}
+		}
+		return TYPE_BOOL, nil
+	default:
+		return 0, fmt.Errorf("bad expression kind: %d", e.Kind)
+	}
+}
+
+// the value of a checked expression over a row of every column
+func evalExpr(e *Expr, rec *Record) Value {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		return *rec.Get(e.Col)
+	case EXPR_LITERAL:
+		return e.Val
+	case EXPR_CMP:
+		v1, v2 := evalExpr(e.Args[0], rec), evalExpr(e.Args[1], rec)
+		if v1.Null || v2.Null {
+			return Value{Type: TYPE_BOOL, Null: true}
+		}
+		if e.Cmp == CMP_PREFIX {
+			return boolValue(bytes.HasPrefix(v1.Str, v2.Str))
+		}
+		return boolValue(cmpMatch(orderValues(v1, v2), e.Cmp))
+	case EXPR_NOT:
+		v := evalExpr(e.Args[0], rec)
+		if !v.Null {
+			v.I64 ^= 1
+		}
+		return v
+	case EXPR_IN:
+		v := evalExpr(e.Args[0], rec)
+		null := v.Null
+		for _, arg := range e.Args[1:] {
+			item := evalExpr(arg, rec)
+			if item.Null {
+				null = true
+			} else if !v.Null && orderValues(v, item) == 0 {
+				return boolValue(true)
+			}
+		}
+		if null {
+			return Value{Type: TYPE_BOOL, Null: true}
+		}
+		return boolValue(false)
+	default:
+		// AND is false with a false operand, OR is true with a true one
+		stop := int64(0)
+		if e.Kind == EXPR_OR {
+			stop = 1
+		}
+		null := false
+		for _, arg := range e.Args {
+			v := evalExpr(arg, rec)
+			if v.Null {
+				null = true
+			} else if v.I64 == stop {
+				return boolValue(stop == 1)
+			}
+		}
+		if null {
+			return Value{Type: TYPE_BOOL, Null: true}
+		}
+		return boolValue(stop == 0)
+	}
+}
+
+func boolValue(b bool) Value {
+	v := Value{Type: TYPE_BOOL}
+	if b {
+		v.I64 = 1
+	}
+	return v
+}
+
+// -1, 0 or +1 for two values of the same type, not NULL
+func orderValues(v1, v2 Value) int {
+	switch v1.Type {
+	case TYPE_FLOAT64:
+		switch {
+		case v1.F64 < v2.F64:
+			return -1
+		case v1.F64 > v2.F64:
+			return +1
+		}
+		return 0
+	case TYPE_BYTES:
+		return bytes.Compare(v1.Str, v2.Str)
+	default:
+		switch {
+		case v1.I64 < v2.I64:
+			return -1
+		case v1.I64 > v2.I64:
+			return +1
+		}
+		return 0
+	}
+}
+
+func cmpMatch(order int, cmp int) bool {
+	switch cmp {
+	case CMP_EQ:
+		return order == 0
+	case CMP_NE:
+		return order != 0
+	case CMP_GE:
+		return order >= 0
+	case CMP_GT:
+		return order > 0
+	case CMP_LT:
+		return order < 0
+	default: // CMP_LE
+		return order <= 0
+	}
+}
+
+// the filter of a scan is true for the row
+func filterMatch(e *Expr, rec *Record) bool {
+	v := evalExpr(e, rec)
+	return !v.Null && v.I64 != 0
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScanFilter(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "jobs",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT32, TYPE_BOOL},
+		Cols:    []string{"id", "status", "retries", "urgent"},
+		PKeys:   1,
+		Indexes: [][]string{{"status"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	for id := int64(1); id <= 12; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddStr("status", []byte([]string{"active", "done", "failed"}[id%3]))
+		if id%4 == 0 {
+			rec.AddNull("retries", TYPE_INT32)
+		} else {
+			rec.AddInt32("retries", int32(id%5))
+		}
+		rec.AddBool("urgent", id%2 == 0)
+		if _, err := tx.Set("jobs", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	str := func(s string) *Expr { return LiteralExpr(Value{Type: TYPE_BYTES, Str: []byte(s)}) }
+	num := func(n int64) *Expr { return LiteralExpr(Value{Type: TYPE_INT64, I64: n}) }
+	active := CompareExpr(ColumnExpr("status"), CMP_EQ, str("active"))
+	few := CompareExpr(ColumnExpr("retries"), CMP_LT, num(3))
+	all := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("id", 0), Key2: *(&Record{}).AddInt64("id", 99)}
+	byStatus := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddStr("status", nil), Key2: *(&Record{}).AddStr("status", []byte("zz"))}
+
+	// status 0 active, 1 done, 2 failed by id%3. retries id%5, NULL for id%4 == 0
+	for _, tt := range []struct {
+		name   string
+		sc     Scanner
+		filter *Expr
+		want   string
+	}{
+		{"and", all, AndExpr(active, few), "[6]"},
+		{"or", all, OrExpr(active, CompareExpr(ColumnExpr("retries"), CMP_GE, num(4))), "[3 6 9 12]"},
+		{"not", all, NotExpr(few), "[3 9]"},
+		{"bool column", all, AndExpr(ColumnExpr("urgent"), CompareExpr(ColumnExpr("id"), CMP_NE, num(2))), "[4 6 8 10 12]"},
+		{"null never matches", all, CompareExpr(ColumnExpr("retries"), CMP_EQ, LiteralExpr(Value{Null: true})), "[]"},
+		{"index scan", byStatus, CompareExpr(ColumnExpr("status"), CMP_GT, str("b")), "[1 4 7 10 2 5 8 11]"},
+		{"limit", func() Scanner { sc := all; sc.Limit = 2; return sc }(), few, "[1 2]"},
+		{"desc projected", func() Scanner { sc := all; sc.Desc, sc.Project = true, []string{"id"}; return sc }(), AndExpr(active), "[12 9 6 3]"},
+	} {
+		sc := tt.sc
+		sc.Filter = tt.filter
+		var reader DBReader
+		db.BeginRead(&reader)
+		if err := reader.Scan("jobs", &sc); err != nil {
+			t.Fatalf("%s: failed to scan: %v", tt.name, err)
+		}
+		var ids []int64
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, reader.Tree()); err != nil {
+				t.Fatalf("%s: failed to deref: %v", tt.name, err)
+			}
+			ids = append(ids, rec.Get("id").I64)
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+		db.EndRead(&reader)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	for _, filter := range []*Expr{
+		CompareExpr(ColumnExpr("status"), CMP_EQ, num(1)),
+		AndExpr(ColumnExpr("retries")),
+		ColumnExpr("status"),
+	} {
+		sc := all
+		sc.Filter = filter
+		if err := db.Scan("jobs", &sc, reader.Tree()); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("%v: expected ErrTypeMismatch, got %v", filter, err)
+		}
+	}
+	sc := all
+	sc.Filter = CompareExpr(ColumnExpr("owner"), CMP_EQ, num(1))
+	if err := db.Scan("jobs", &sc, reader.Tree()); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	db.EndRead(&reader)
+
+	// only the matching rows are deleted, through the primary key & an index
+	db.Begin(&tx)
+	for _, sc := range []Scanner{all, byStatus} {
+		sc.Filter = AndExpr(few, NotExpr(ColumnExpr("urgent")))
+		if _, err := tx.DeleteRange("jobs", &sc); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	if n, err := db.RowCount("jobs", reader.Tree()); err != nil || n != 8 {
+		t.Errorf("expected 8 rows left, got %d %v", n, err)
+	}
+}
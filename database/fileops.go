@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// the writes of a KV to its file & to its log, see WithFileOps. a failed
+// write fails the commit, which is applied by none of them, e.g. on a full
+// disk the commits fail until there is space again while the reads go on.
+type FileOps interface {
+	// reserve the blocks of the file from offset, the file grows to
+	// offset+length. the pages mapped from it are written in place, a block
+	// that is not reserved would fail them out of space with a SIGBUS.
+	Allocate(fp *os.File, offset, length int64) error
+	WriteAt(fp *os.File, data []byte, offset int64) (int, error) // the master page
+	Write(fp *os.File, data []byte) (int, error)                 // appends to the log
+	Truncate(fp *os.File, size int64) error
+	Sync(fp *os.File) error
+}
+
+// the operations of the OS
+type osFileOps struct{}
+
+func (osFileOps) Allocate(fp *os.File, offset, length int64) error {
+	err := fallocateFile(fp.Fd(), offset, length)
+	if errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	// also without fallocate in the file system, the blocks are then
+	// allocated as they are written
+	return fp.Truncate(offset + length)
+}
+
+func (osFileOps) WriteAt(fp *os.File, data []byte, offset int64) (int, error) {
+	return pwriteFile(fp.Fd(), data, offset)
+}
+
+func (osFileOps) Write(fp *os.File, data []byte) (int, error) {
+	return fp.Write(data)
+}
+
+func (osFileOps) Truncate(fp *os.File, size int64) error {
+	return fp.Truncate(size)
+}
+
+func (osFileOps) Sync(fp *os.File) error {
+	return fp.Sync()
+}
+
+func (db *KV) files() FileOps {
+	if db.Files == nil {
+		return osFileOps{}
+	}
+	return db.Files
+}
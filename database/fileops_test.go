@@ -0,0 +1,165 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// fails the operations in fail with ENOSPC like a full disk, a write to the
+// log is cut in the middle
+type fullDisk struct {
+	osFileOps
+	fail map[string]bool
+}
+
+func (d *fullDisk) Allocate(fp *os.File, offset, length int64) error {
+	if d.fail["allocate"] {
+		return syscall.ENOSPC
+	}
+	return d.osFileOps.Allocate(fp, offset, length)
+}
+
+func (d *fullDisk) WriteAt(fp *os.File, data []byte, offset int64) (int, error) {
+	if d.fail["writeat"] {
+		return 0, syscall.ENOSPC
+	}
+	return d.osFileOps.WriteAt(fp, data, offset)
+}
+
+func (d *fullDisk) Write(fp *os.File, data []byte) (int, error) {
+	if d.fail["write"] {
+		n, _ := d.osFileOps.Write(fp, data[:len(data)/2])
+		return n, syscall.ENOSPC
+	}
+	return d.osFileOps.Write(fp, data)
+}
+
+func (d *fullDisk) Truncate(fp *os.File, size int64) error {
+	if d.fail["truncate"] {
+		return syscall.ENOSPC
+	}
+	return d.osFileOps.Truncate(fp, size)
+}
+
+func (d *fullDisk) Sync(fp *os.File) error {
+	if d.fail["sync"] {
+		return syscall.ENOSPC
+	}
+	return d.osFileOps.Sync(fp)
+}
+
+func TestDiskFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "full.db")
+	disk := &fullDisk{}
+	db, err := Open(path, WithFileOps(disk), WithCheckpointSize(1<<40))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	next := int64(0) // the id of the next row
+	insert := func(n int) error {
+		return db.Transact(func(tx *DBTX) error {
+			for i := next; i < next+int64(n); i++ {
+				rec := (&Record{}).AddInt64("id", i).AddStr("name", bytes.Repeat([]byte{'a' + byte(i%26)}, 100)).AddInt64("qty", i)
+				if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	// the rows committed are all there & the index agrees with them
+	check := func(db *DB, step string) {
+		t.Helper()
+		err := db.View(func(tx *DBReader) error {
+			if err := tx.Tree().Verify(); err != nil {
+				return err
+			}
+			n, err := tx.Count("items", &Scanner{})
+			if err != nil {
+				return err
+			}
+			if n != next {
+				t.Errorf("%s: expected %d rows, got %d", step, next, n)
+			}
+			problems, err := tx.CheckConsistency("items")
+			if problems != nil {
+				t.Errorf("%s: expected consistent indexes, got %v", step, problems)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+	err = db.Transact(func(tx *DBTX) error {
+		return tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64}, Cols: []string{"id", "name", "qty"},
+			PKeys: 1, Indexes: [][]string{{"name"}},
+		})
+	})
+	if err == nil {
+		err = insert(100)
+	}
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	next = 100
+
+	for _, fail := range [][]string{{"allocate"}, {"write"}, {"sync"}, {"write", "truncate"}} {
+		step := fmt.Sprint(fail)
+		disk.fail = map[string]bool{}
+		for _, op := range fail {
+			disk.fail[op] = true
+		}
+		// enough rows to grow the file, the writes fail until there is space
+		for i := 0; i < 2; i++ {
+			if err := insert(1000); !errors.Is(err, syscall.ENOSPC) {
+				t.Fatalf("%s: expected ENOSPC, got %v", step, err)
+			}
+			check(db, step)
+		}
+		// a failed append that could not be cut is cut by the next one, which
+		// grows the file too
+		delete(disk.fail, "write")
+		if len(disk.fail) > 0 {
+			if err := insert(1000); !errors.Is(err, syscall.ENOSPC) {
+				t.Fatalf("%s: expected the cut to fail, got %v", step, err)
+			}
+		}
+		disk.fail = nil
+		if err := insert(1000); err != nil {
+			t.Fatalf("%s: insert after the space is back: %v", step, err)
+		}
+		next += 1000
+		check(db, step)
+	}
+
+	// a failed checkpoint keeps the commit, it is in the log
+	db.kv.CheckpointSize = 1
+	disk.fail = map[string]bool{"writeat": true}
+	if err := insert(10); err != nil {
+		t.Fatalf("insert with a failed checkpoint: %v", err)
+	}
+	next += 10
+	check(db, "checkpoint")
+	disk.fail = nil
+	if err := insert(10); err != nil {
+		t.Fatalf("insert with a checkpoint: %v", err)
+	}
+	next += 10
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	check(db, "reopen")
+}
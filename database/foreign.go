@@ -0,0 +1,280 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	FK_RESTRICT = 0 // a parent row with child rows is not deleted
+	FK_CASCADE  = 1 // the child rows are deleted with the parent row
+)
+
+var ErrForeignKey error = errors.New("foreign key constraint violated")
+
+// the child columns of a row reference the row of the parent table with
+// the same values in RefCols, the primary key or a unique index of it
+type ForeignKey struct {
+	Cols     []string
+	Parent   string
+	RefCols  []string
+	OnDelete int // FK_RESTRICT or FK_CASCADE
+}
+
+// a foreign key check of a write, done again by the commit against the
+// commits made since the transaction began. it only applies while the guard
+// is still there, or still gone, in the transaction.
+type fkCommit struct {
+	guard  []byte // the child row referencing, or the parent row deleted or changed
+	prefix []byte // the key prefix of the parent row, or of its child rows
+	absent bool   // the prefix was checked to be gone
+}
+
+// check the foreign keys of a new table & list it in its parents, under TableNew
+func fkDefine(db *DB, tdef *TableDef, kvtx *KVTX) error {
+	for _, fk := range tdef.ForeignKeys {
+		name := fmt.Sprintf("foreign key (%s)", strings.Join(fk.Cols, ","))
+		if len(fk.Cols) == 0 || len(fk.Cols) != len(fk.RefCols) {
+			return fmt.Errorf("%s: the columns do not match the referenced columns", name)
+		}
+		if fk.OnDelete != FK_RESTRICT && fk.OnDelete != FK_CASCADE {
+			return fmt.Errorf("%s: invalid ON DELETE action %d", name, fk.OnDelete)
+		}
+		parent := tdef
+		if fk.Parent != tdef.Name {
+			if fk.Parent == TDEF_META.Name || fk.Parent == TDEF_TABLE.Name {
+				return fmt.Errorf("%s: cannot reference the internal table %s", name, fk.Parent)
+			}
+			if parent = getTableDefDB(db, fk.Parent, &kvtx.Tree); parent == nil {
+				return fmt.Errorf("%s: %w", name, tableNotFound(fk.Parent))
+			}
+		}
+		if fkRefIndex(parent, fk.RefCols) < -1 {
+			return fmt.Errorf("%s: %s(%s) is not the primary key or a unique index",
+				name, fk.Parent, strings.Join(fk.RefCols, ","))
+		}
+		for i, col := range fk.Cols {
+			c := ColIndex(tdef, col)
+			if c < 0 {
+				return fmt.Errorf("%s: %w", name, columnNotFound(tdef.Name, col))
+			}
+			if tdef.Types[c] != parent.Types[ColIndex(parent, fk.RefCols[i])] {
+				return fmt.Errorf("%s: the type of %s does not match %s", name, col, fk.RefCols[i])
+			}
+		}
+		// the child rows of a parent row are found by a scan
+		if _, err := findIndex(tdef, fk.Cols); err != nil {
+			return fmt.Errorf("%s: needs an index starting with its columns", name)
+		}
+		if slices.Contains(parent.Referenced, tdef.Name) {
+			continue
+		}
+		parent.Referenced = append(parent.Referenced, tdef.Name)
+		if parent != tdef {
+			if err := tableDefUpdate(db, parent, kvtx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// -1 for the primary key, the unique index with the columns, or -2
+func fkRefIndex(tdef *TableDef, cols []string) int {
+	if slices.Equal(cols, tdef.Cols[:tdef.PKeys]) {
+		return -1
+	}
+	for i := range tdef.Indexes {
+		if indexUnique(tdef, i) && slices.Equal(cols, uniqueCols(tdef, i)) {
+			return i
+		}
+	}
+	return -2
+}
+
+// the values of the columns of a full row
+func fkValues(rec Record, cols []string) []Value {
+	vals := make([]Value, len(cols))
+	for i, col := range cols {
+		vals[i] = *rec.Get(col)
+	}
+	return vals
+}
+
+// a child row with a null in its columns references no row
+func fkNull(vals []Value) bool {
+	for _, v := range vals {
+		if v.Null {
+			return true
+		}
+	}
+	return false
+}
+
+// the keys with the values in the primary key or the index
+func fkPrefix(tdef *TableDef, index int, vals []Value) []byte {
+	if index < 0 {
+		return encodeKey(nil, tdef.Prefix, vals)
+	}
+	return encodeKey(nil, tdef.IndexPrefix[index], vals)
+}
+
+// the parent rows of the full row exist in the transaction
+func fkCheck(db *DB, tdef *TableDef, rec Record, kvtx *KVTX) error {
+	for _, fk := range tdef.ForeignKeys {
+		parent := GetTableDef(db, fk.Parent, &kvtx.Tree)
+		if parent == nil {
+			return tableNotFound(fk.Parent)
+		}
+		vals := fkValues(rec, fk.Cols)
+		if fkNull(vals) {
+			continue // references no row
+		}
+		prefix := fkPrefix(parent, fkRefIndex(parent, fk.RefCols), vals)
+		if prefixFind(&kvtx.Tree, prefix, nil) == nil {
+			return fmt.Errorf("%w: no row of %s with %s", ErrForeignKey, fk.Parent,
+				formatRecord(Record{fk.RefCols, vals}))
+		}
+		guard := encodeKey(nil, tdef.Prefix, rec.Vals[:tdef.PKeys])
+		kvtx.foreign = append(kvtx.foreign, fkCommit{guard: guard, prefix: prefix})
+	}
+	return nil
+}
+
+// call fn with the foreign keys referencing the table & a scan of the child
+// rows of the full row
+func fkChildren(db *DB, tdef *TableDef, rec Record, kvtx *KVTX,
+	fn func(child *TableDef, fk *ForeignKey, sc *Scanner) error,
+) error {
+	for _, name := range tdef.Referenced {
+		child := GetTableDef(db, name, &kvtx.Tree)
+		if child == nil {
+			continue
+		}
+		for i := range child.ForeignKeys {
+			fk := &child.ForeignKeys[i]
+			if fk.Parent != tdef.Name {
+				continue
+			}
+			key := Record{fk.Cols, fkValues(rec, fk.RefCols)}
+			if fkNull(key.Vals) {
+				continue
+			}
+			sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+			if err := fn(child, fk, &sc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// the child rows of a row about to be deleted or changed. for a delete, a
+// RESTRICT key fails with child rows & the child rows of a CASCADE key are
+// left to fkCascade. a change of the referenced columns fails with child rows.
+func fkRestrict(db *DB, tdef *TableDef, old Record, rec *Record, kvtx *KVTX) error {
+	return fkChildren(db, tdef, old, kvtx, func(child *TableDef, fk *ForeignKey, sc *Scanner) error {
+		if rec == nil && fk.OnDelete == FK_CASCADE {
+			return nil
+		}
+		if rec != nil && bytes.Equal(encodeValues(nil, sc.Key1.Vals), encodeValues(nil, fkValues(*rec, fk.RefCols))) {
+			return nil
+		}
+		sc.Limit = 1
+		if err := dbScan(db, child, sc, &kvtx.Tree); err != nil {
+			return err
+		}
+		if sc.Valid() {
+			return fmt.Errorf("%w: the row of %s with %s is referenced by %s", ErrForeignKey,
+				tdef.Name, formatRecord(Record{fk.RefCols, sc.Key1.Vals}), child.Name)
+		}
+		fkGone(tdef, child, fk, sc, kvtx)
+		return nil
+	})
+}
+
+// delete the child rows of the CASCADE keys of a deleted row
+func fkCascade(db *DB, tdef *TableDef, old Record, kvtx *KVTX) error {
+	return fkChildren(db, tdef, old, kvtx, func(child *TableDef, fk *ForeignKey, sc *Scanner) error {
+		if fk.OnDelete != FK_CASCADE {
+			return nil
+		}
+		if _, err := dbDeleteRange(db, child, sc, kvtx); err != nil {
+			return fmt.Errorf("cascade to %s: %w", child.Name, err)
+		}
+		fkGone(tdef, child, fk, sc, kvtx)
+		return nil
+	})
+}
+
+// the commit checks that no child row was added in the meantime
+func fkGone(tdef *TableDef, child *TableDef, fk *ForeignKey, sc *Scanner, kvtx *KVTX) {
+	index, _ := findIndex(child, fk.Cols)
+	kvtx.foreign = append(kvtx.foreign, fkCommit{
+		guard:  fkPrefix(tdef, fkRefIndex(tdef, fk.RefCols), sc.Key1.Vals),
+		prefix: fkPrefix(child, index, sc.Key1.Vals),
+		absent: true,
+	})
+}
+
+// check the foreign keys of the transaction again on the latest version
+func commitForeign(tx *KVTX, w *kvWriter) error {
+	mine := func(key []byte) bool {
+		_, ok := tx.writes[string(key)]
+		return ok
+	}
+	for _, c := range tx.foreign {
+		if (prefixFind(&tx.Tree, c.guard, nil) != nil) == c.absent {
+			continue // rolled back since
+		}
+		// the keys after the commit: the others' that it keeps & its own
+		found := prefixFind(&w.Tree, c.prefix, mine) != nil ||
+			prefixFind(&tx.Tree, c.prefix, func(key []byte) bool { return !mine(key) }) != nil
+		if found == c.absent {
+			return fmt.Errorf("%w: the referenced rows were changed by a concurrent commit", ErrForeignKey)
+		}
+	}
+	return nil
+}
+
+// a table referenced by another one is not dropped, the parents of a
+// dropped table no longer list it
+func fkDrop(db *DB, tdef *TableDef, kvtx *KVTX) error {
+	for _, name := range tdef.Referenced {
+		if name != tdef.Name {
+			return fmt.Errorf("table %s is referenced by a foreign key of %s", tdef.Name, name)
+		}
+	}
+	for _, fk := range tdef.ForeignKeys {
+		parent := getTableDefDB(db, fk.Parent, &kvtx.Tree)
+		if parent == nil || parent.Name == tdef.Name || !slices.Contains(parent.Referenced, tdef.Name) {
+			continue
+		}
+		parent.Referenced = slices.DeleteFunc(parent.Referenced, func(name string) bool {
+			return name == tdef.Name
+		})
+		if err := tableDefUpdate(db, parent, kvtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write the changed definition of a table to the catalog
+func tableDefUpdate(db *DB, tdef *TableDef, kvtx *KVTX) error {
+	tdef.Version++
+	val, err := json.Marshal(tdef)
+	if err != nil {
+		return fmt.Errorf("failed to marshal table definition: %w", err)
+	}
+	rec := (&Record{}).AddStr("name", []byte(tdef.Name)).AddStr("def", val)
+	if _, err := dbUpdate(db, TDEF_TABLE, *rec, MODE_UPDATE_ONLY, kvtx); err != nil {
+		return fmt.Errorf("failed to update table definition: %w", err)
+	}
+	db.uncache(tdef.Name)
+	return nil
+}
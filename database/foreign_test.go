@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestForeignKeys(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	create := func(tdef *TableDef) error {
+		t.Helper()
+		db.Begin(&tx)
+		if err := tx.TableNew(tdef); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+	tables := []*TableDef{{
+		Name:    "customers",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"id", "email", "name"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}},
+		Unique:  []bool{true},
+	}, {
+		Name:        "orders",
+		Types:       []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:        []string{"id", "customer", "note"},
+		PKeys:       1,
+		Indexes:     [][]string{{"customer"}},
+		ForeignKeys: []ForeignKey{{Cols: []string{"customer"}, Parent: "customers", RefCols: []string{"id"}}},
+	}, {
+		Name:    "items",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64},
+		Cols:    []string{"order", "no"},
+		PKeys:   1,
+		Indexes: [][]string{},
+		ForeignKeys: []ForeignKey{{
+			Cols: []string{"order"}, Parent: "orders", RefCols: []string{"id"}, OnDelete: FK_CASCADE,
+		}},
+	}, {
+		Name:    "reviews",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"id", "email", "text"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}},
+		ForeignKeys: []ForeignKey{{
+			Cols: []string{"email"}, Parent: "customers", RefCols: []string{"email"},
+		}},
+	}}
+	for _, tdef := range tables {
+		if err := create(tdef); err != nil {
+			t.Fatalf("failed to create %s: %v", tdef.Name, err)
+		}
+	}
+	for _, tdef := range []*TableDef{{
+		Name:        "bad_index",
+		Types:       []uint32{TYPE_INT64, TYPE_INT64},
+		Cols:        []string{"id", "customer"},
+		PKeys:       1,
+		ForeignKeys: []ForeignKey{{Cols: []string{"customer"}, Parent: "customers", RefCols: []string{"id"}}},
+	}, {
+		Name:        "bad_ref",
+		Types:       []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:        []string{"id", "order", "note"},
+		PKeys:       1,
+		Indexes:     [][]string{{"order"}},
+		ForeignKeys: []ForeignKey{{Cols: []string{"order"}, Parent: "orders", RefCols: []string{"customer"}}},
+	}} {
+		if err := create(tdef); err == nil {
+			t.Errorf("expected %s to be rejected", tdef.Name)
+		}
+	}
+
+	customer := func(id int64, email string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("email", []byte(email)).AddStr("name", []byte("c"))
+	}
+	order := func(id, customer int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddInt64("customer", customer).AddStr("note", []byte("o"))
+	}
+	item := func(order, no int64) Record {
+		return *(&Record{}).AddInt64("order", order).AddInt64("no", no)
+	}
+	byID := func(id int64) *Scanner {
+		key := *(&Record{}).AddInt64("id", id)
+		return &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+	}
+	count := func(table string) int {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+		if err := reader.Scan(table, &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		n := 0
+		for ; sc.Valid(); sc.Next() {
+			n++
+		}
+		return n
+	}
+	write := func(fn func() error) error {
+		t.Helper()
+		db.Begin(&tx)
+		if err := fn(); err != nil {
+			db.Abort(&tx)
+			return err
+		}
+		return db.Commit(&tx)
+	}
+	set := func(table string, rec Record) error {
+		_, err := tx.Set(table, rec, MODE_INSERT_ONLY)
+		return err
+	}
+
+	// a missing parent row, a parent inserted earlier in the same transaction
+	if err := write(func() error { return set("orders", order(1, 1)) }); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey, got %v", err)
+	}
+	err := write(func() error {
+		for id := int64(1); id <= 3; id++ {
+			if err := set("customers", customer(id, string(rune('a'+id-1))+"@x")); err != nil {
+				return err
+			}
+		}
+		if err := set("orders", order(1, 1)); err != nil {
+			return err
+		}
+		if err := set("reviews", *(&Record{}).AddInt64("id", 1).AddStr("email", []byte("a@x"))); err != nil {
+			return err
+		}
+		return tx.BulkInsert("items", []Record{item(1, 1)})
+	})
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := write(func() error { return tx.BulkInsert("items", []Record{item(2, 1)}) }); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey, got %v", err)
+	}
+
+	// RESTRICT, also on a change of a referenced unique column
+	if err := write(func() error { _, err := tx.DeleteRange("customers", byID(1)); return err }); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey, got %v", err)
+	}
+	if err := write(func() error { _, err := tx.Set("customers", customer(1, "z@x"), MODE_UPDATE_ONLY); return err }); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey, got %v", err)
+	}
+	// CASCADE
+	if err := write(func() error { _, err := tx.DeleteRange("orders", byID(1)); return err }); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if n := count("items"); n != 0 {
+		t.Errorf("expected the items to be deleted with the order, got %d", n)
+	}
+
+	// the checks are done again by the commit
+	var tx1, tx2 DBTX
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	if _, err := tx1.DeleteRange("customers", byID(2)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := tx2.Set("orders", order(2, 2), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx2); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Commit(&tx1); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey for a child row inserted meanwhile, got %v", err)
+	}
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	if _, err := tx1.DeleteRange("customers", byID(3)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := tx2.Set("orders", order(3, 3), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx1); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := db.Commit(&tx2); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("expected ErrForeignKey for a parent row deleted meanwhile, got %v", err)
+	}
+
+	// a referenced table is dropped after its child tables
+	if err := write(func() error { return tx.DropTable("orders") }); err == nil {
+		t.Errorf("expected a referenced table not to be dropped")
+	}
+	for _, name := range []string{"items", "orders"} {
+		if err := write(func() error { return tx.DropTable(name) }); err != nil {
+			t.Fatalf("failed to drop %s: %v", name, err)
+		}
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	if tdef := GetTableDef(db, "customers", &reader.kv.Tree); len(tdef.Referenced) != 1 || tdef.Referenced[0] != "reviews" {
+		t.Errorf("expected customers to be referenced by reviews only, got %v", tdef.Referenced)
+	}
+}
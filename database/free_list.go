@@ -4,22 +4,22 @@ import (
 	"encoding/binary"
 )
 
+// the free list is a queue of unused pages stored in a linked list of nodes.
+// pages freed by a transaction are pushed to the tail, pops take them from
+// the head. items are addressed by sequence numbers that only grow, so the
+// state is just the 2 ends & the list nodes are only ever appended to.
 type FreeListData struct {
-	head uint64
-	// cached pointers to list nodes for accessing both ends
-	nodes []uint64 // from tail to head
-	// cached total number of items; stored in the head node
-	total int
-	// cached number of discarded items in the tail nodes
-	offset int
+	headPage uint64 // the node holding the next item to pop
+	headSeq  uint64 // the sequence number of the next item to pop
+	tailPage uint64 // the node holding the next item to push
+	tailSeq  uint64 // the sequence number of the next item to push
 }
 
 type FreeList struct {
 	FreeListData
 	// for each transaction
-	version   uint64   // current version
-	minReader uint64   // minimum reader version
-	freed     []uint64 // pages that will be added to the free list
+	version   uint64 // current version
+	minReader uint64 // minimum reader version
 
 	// callbacks for managing on-disk pages
 	get func(uint64) BNode  // de-reference a pointer
@@ -28,199 +28,120 @@ type FreeList struct {
 }
 
 // Free List Node Format
-// | type | size | total | next |  pointers-version-pairs |
-// |  2B  |  2B  |   8B  |  8B  |       size * 16B        |
+// | type | unused | next |  pointer-version pairs |
+// |  2B  |   2B   |  8B  |       cap * 16B        |
+// the version is when the page became unreachable, it can be reused once
+// every reader is at this version or later.
 
 const (
 	BNODE_FREE_LIST  = 3
-	FREE_LIST_HEADER = 4 + 8 + 8
-	FREE_LIST_CAP    = (BTREE_PAGE_SIZE - FREE_LIST_HEADER) / 8
+	FREE_LIST_HEADER = 4 + 8
+	FREE_LIST_CAP    = (BTREE_NODE_SIZE - FREE_LIST_HEADER) / 16
 )
 
+// the number of items in the list
+func (fl *FreeList) Total() int {
+	return int(fl.tailSeq - fl.headSeq)
+}
+
+// get a page that no reader can reach, 0 if there is none
 func (fl *FreeList) Pop() uint64 {
-	fl.loadCache()
-	return flPop1(fl)
+	ptr, head := flPop(fl)
+	if head != 0 {
+		// the emptied head node is a free page as well
+		fl.push(head)
+	}
+	return ptr
 }
 
+// add pages that become unreachable once the transaction commits
 func (fl *FreeList) Add(freed []uint64) {
-	if len(freed) == 0 {
-		return
-	}
-	total := fl.Total() + len(freed)
-	flPush(fl, freed, nil)
-	if fl.head != 0 {
-		flnSetTotal(fl.get(fl.head), uint64(total))
+	for _, ptr := range freed {
+		fl.push(ptr)
 	}
 }
 
-func (fl *FreeList) loadCache() {
-	if len(fl.nodes) > 0 {
-		return
+func (fl *FreeList) push(ptr uint64) {
+	if fl.tailPage == 0 {
+		// the first node
+		node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		flnSetHeader(node, 0)
+		fl.tailPage = fl.new(node)
+		fl.headPage = fl.tailPage
 	}
-
-	curr := fl.head
-	if curr == 0 {
-		fl.total = 0
-		fl.offset = 0
+	node := flnCopy(fl.get(fl.tailPage))
+	flnSetItem(node, seq2idx(fl.tailSeq), ptr, fl.version+1)
+	fl.use(fl.tailPage, node)
+	fl.tailSeq++
+	if seq2idx(fl.tailSeq) != 0 {
 		return
 	}
-
-	var nodes []uint64
-	for curr != 0 {
-		nodes = append(nodes, curr)
-		node := fl.get(curr)
-		curr = flnNext(node)
+	// the tail node is full, link a new one
+	next, head := flPop(fl)
+	if next == 0 {
+		next = fl.new(BNode{data: make([]byte, BTREE_PAGE_SIZE)})
 	}
-
-	for i := 0; i < len(nodes)/2; i++ {
-		nodes[i], nodes[len(nodes)-1-i] = nodes[len(nodes)-1-i], nodes[i]
+	empty := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	flnSetHeader(empty, 0)
+	fl.use(next, empty)
+	flnSetHeader(node, next)
+	fl.use(fl.tailPage, node)
+	fl.tailPage = next
+	if head != 0 {
+		fl.push(head)
 	}
-
-	fl.nodes = nodes
-	headNode := fl.get(fl.head)
-	fl.total = flnSize(headNode)
-	fl.offset = 0
 }
 
-func flPop1(fl *FreeList) uint64 {
-	if fl.total == 0 {
-		return 0
+// returns the popped item & the head node if it was emptied
+func flPop(fl *FreeList) (ptr uint64, head uint64) {
+	if fl.headSeq == fl.tailSeq {
+		return 0, 0
 	}
-
-	assert(fl.offset < flnSize(fl.get(fl.nodes[0])))
-	ptr, ver := flnItem(fl.get(fl.nodes[0]), fl.offset)
+	node := fl.get(fl.headPage)
+	ptr, ver := flnItem(node, seq2idx(fl.headSeq))
 	if versionBefore(fl.minReader, ver) {
 		// cannot use; possibly reachable by the minimum version reader
-		return 0
+		return 0, 0
 	}
-	fl.offset++
-	fl.total--
-	if fl.offset >= flnSize(fl.get(fl.nodes[0])) {
-		fl.nodes = fl.nodes[1:]
-		fl.offset = 0
+	fl.headSeq++
+	if seq2idx(fl.headSeq) == 0 {
+		head, fl.headPage = fl.headPage, flnNext(node)
+		assert(fl.headPage != 0)
 	}
-	return ptr
+	return ptr, head
+}
+
+func seq2idx(seq uint64) int {
+	return int(seq % FREE_LIST_CAP)
 }
 
 func versionBefore(u uint64, ver uint64) bool {
 	return int64(u-ver) < 0
 }
 
-func flnItem(node BNode, offset int) (uint64, uint64) {
-	pos := FREE_LIST_HEADER + offset*16
-	if len(node.data) < int(pos)+16 {
-		return 0, 0
-	}
-	ptr := binary.LittleEndian.Uint64(node.data[pos : pos+8])
-	ver := binary.LittleEndian.Uint64(node.data[pos+8 : pos+16])
+func flnItem(node BNode, idx int) (uint64, uint64) {
+	pos := FREE_LIST_HEADER + idx*16
+	ptr := binary.LittleEndian.Uint64(node.data[pos:])
+	ver := binary.LittleEndian.Uint64(node.data[pos+8:])
 	return ptr, ver
 }
 
-func flnSize(node BNode) int {
-	return int(node.nKeys())
+func flnSetItem(node BNode, idx int, ptr uint64, ver uint64) {
+	pos := FREE_LIST_HEADER + idx*16
+	binary.LittleEndian.PutUint64(node.data[pos:], ptr)
+	binary.LittleEndian.PutUint64(node.data[pos+8:], ver)
 }
 
 func flnNext(node BNode) uint64 {
-	return binary.LittleEndian.Uint64(node.data[4+8:])
-}
-
-func flnPtr(node BNode, idx int) uint64 {
-	return binary.LittleEndian.Uint64(node.data[FREE_LIST_HEADER+idx*8:])
-}
-
-func flnSetPtr(node BNode, idx int, ptr uint64) {
-	binary.LittleEndian.PutUint64(node.data[FREE_LIST_HEADER+idx*8:], ptr)
+	return binary.LittleEndian.Uint64(node.data[4:])
 }
 
-func flnSetHeader(node BNode, size uint16, next uint64) {
-	binary.LittleEndian.PutUint16(node.data[2:], size)
-	binary.LittleEndian.PutUint64(node.data[4+8:], next)
+func flnSetHeader(node BNode, next uint64) {
+	binary.LittleEndian.PutUint16(node.data[0:], BNODE_FREE_LIST)
+	binary.LittleEndian.PutUint64(node.data[4:], next)
 }
 
-func flnSetTotal(node BNode, total uint64) {
-	binary.LittleEndian.PutUint64(node.data[4:], total)
-}
-
-func (fl *FreeList) Get(pgnum int) uint64 {
-	node := fl.get(fl.head)
-	for flnSize(node) < pgnum {
-		pgnum -= flnSize(node)
-		next := flnNext(node)
-		node = fl.get(next)
-	}
-	return flnPtr(node, flnSize(node)-pgnum-1)
-}
-
-// calculates the number of page `pointers` across all nodes
-func (fl *FreeList) Total() int {
-	if fl == nil || fl.head == 0 {
-		return 0 // Handle nil case or empty list
-	}
-
-	total := 0
-	nodeId := fl.head
-
-	for nodeId > 0 {
-		node := fl.get(nodeId)
-		total += flnSize(node)
-		nodeId = flnNext(node)
-	}
-	return total
-}
-
-// remove the `popn` pointers & add new `freed` pointers
-func (fl *FreeList) Update(popn int, freed []uint64) {
-	if popn == 0 || len(freed) == 0 {
-		return // no change to be done
-	}
-
-	total := fl.Total()
-	reuse := []uint64{} // construct the new list
-
-	for fl.head != 0 && len(reuse)*FREE_LIST_CAP < len(freed) {
-		node := fl.get(fl.head)
-		freed = append(freed, fl.head) // reuse the node itself
-
-		if popn >= flnSize(node) {
-			popn -= flnSize(node)
-		} else {
-			remain := flnSize(node) - popn
-			popn = 0
-			// collect resuable pointers
-			for remain > 0 && len(reuse)*FREE_LIST_CAP < len(freed)+remain {
-				remain--
-				reuse = append(reuse, flnPtr(node, remain))
-			}
-			for i := 0; i < remain; i++ {
-				freed = append(freed, flnPtr(node, i))
-			}
-		}
-		total -= flnSize(node)
-		fl.head = flnNext(node)
-	}
-	assert(len(reuse)*FREE_LIST_CAP >= len(freed) || fl.head == 0)
-	flPush(fl, freed, reuse)
-	flnSetTotal(fl.get(fl.head), uint64(total+len(freed)))
-}
-
-func flPush(fl *FreeList, freed []uint64, reuse []uint64) {
-	for len(freed) > 0 {
-		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
-
-		size := len(freed)
-		if size > FREE_LIST_CAP {
-			size = FREE_LIST_CAP
-		}
-		flnSetHeader(new, uint16(size), fl.head)
-		for i, ptr := range freed[:size] {
-			flnSetPtr(new, i, ptr)
-		}
-		freed = freed[size:]
-		if len(reuse) > 0 {
-			fl.head, reuse = reuse[0], reuse[1:]
-			fl.use(fl.head, new)
-		} else {
-			fl.head = fl.new(new)
-		}
-	}
+// pages are updated by a copy, the mapped page may still be in use
+func flnCopy(node BNode) BNode {
+	return BNode{data: append([]byte(nil), node.data[:BTREE_PAGE_SIZE]...)}
 }
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+// a free list backed by an in-memory page map
+type testFreeList struct {
+	fl    FreeList
+	pages map[uint64][]byte
+	next  uint64
+}
+
+func newTestFreeList() *testFreeList {
+	c := &testFreeList{pages: map[uint64][]byte{}, next: 1}
+	c.fl.get = func(ptr uint64) BNode {
+		page, ok := c.pages[ptr]
+		assertWithSrc(ok, "bad ptr")
+		return BNode{page}
+	}
+	c.fl.new = func(node BNode) uint64 {
+		ptr := c.next
+		c.next++
+		c.pages[ptr] = node.data
+		return ptr
+	}
+	c.fl.use = func(ptr uint64, node BNode) {
+		c.pages[ptr] = node.data
+	}
+	return c
+}
+
+// the items in the list from the head, without popping them
+func (c *testFreeList) items() []uint64 {
+	var out []uint64
+	ptr := c.fl.headPage
+	for seq := c.fl.headSeq; seq < c.fl.tailSeq; seq++ {
+		item, _ := flnItem(c.fl.get(ptr), seq2idx(seq))
+		out = append(out, item)
+		if seq2idx(seq+1) == 0 {
+			ptr = flnNext(c.fl.get(ptr))
+		}
+	}
+	return out
+}
+
+func TestFreeList(t *testing.T) {
+	c := newTestFreeList()
+	if c.fl.Pop() != 0 {
+		t.Fatal("expected nothing to pop from an empty list")
+	}
+
+	// 3 nodes worth of pages, freed by the transaction of version 1
+	n := 3 * FREE_LIST_CAP
+	freed := make([]uint64, n)
+	for i := range freed {
+		freed[i] = uint64(10000 + i)
+	}
+	c.fl.version, c.fl.minReader = 0, 0
+	c.fl.Add(freed)
+	if c.fl.Total() != n {
+		t.Fatalf("expected %d items, got %d", n, c.fl.Total())
+	}
+	// not reusable while a reader may still reach them
+	if c.fl.Pop() != 0 {
+		t.Fatal("expected the pages freed at version 1 to be kept for readers of version 0")
+	}
+
+	// popped in the order they were freed
+	c.fl.version, c.fl.minReader = 1, 1
+	for i := 0; i < FREE_LIST_CAP+10; i++ {
+		if ptr := c.fl.Pop(); ptr != freed[i] {
+			t.Fatalf("pop %d: expected %d, got %d", i, freed[i], ptr)
+		}
+	}
+	// the emptied head node was pushed back with version 2
+	items := c.items()
+	if len(items) != n-FREE_LIST_CAP-10+1 || items[len(items)-1] >= 10000 {
+		t.Fatalf("expected the old head node at the tail, got %d items ending with %d", len(items), items[len(items)-1])
+	}
+	if c.fl.Total() != len(items) {
+		t.Errorf("expected a total of %d, got %d", len(items), c.fl.Total())
+	}
+
+	// everything is reusable at version 2
+	c.fl.version, c.fl.minReader = 2, 2
+	got := map[uint64]bool{}
+	for ptr := c.fl.Pop(); ptr != 0; ptr = c.fl.Pop() {
+		if got[ptr] {
+			t.Fatalf("page %d popped twice", ptr)
+		}
+		got[ptr] = true
+	}
+	// the emptied nodes pushed in this transaction are kept
+	if len(got) != len(items) || c.fl.Total() == 0 {
+		t.Errorf("expected %d pages & the recycled nodes left, got %d & %d left", len(items), len(got), c.fl.Total())
+	}
+}
+
+func TestFreeListReuse(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	round := func() {
+		var tx DBTX
+		db.Begin(&tx)
+		for id := int64(1); id <= 200; id++ {
+			rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte("john@example.com"))
+			if _, err := tx.Set("users", *rec, MODE_INSERT_ONLY); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		db.Begin(&tx)
+		for id := int64(1); id <= 200; id++ {
+			if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", id)); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+	fileSize := func() int64 {
+		fi, err := os.Stat(db.Path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		return fi.Size()
+	}
+
+	for i := 0; i < 10; i++ {
+		round()
+	}
+	pages, size := db.kv.page.flushed, fileSize()
+	for i := 0; i < 40; i++ {
+		round()
+	}
+	if db.kv.page.flushed != pages || fileSize() != size {
+		t.Errorf("expected the file to stay at %d pages, %d bytes, got %d pages, %d bytes",
+			pages, size, db.kv.page.flushed, fileSize())
+	}
+
+	// the free list survives a restart
+	free, version := db.kv.free, db.kv.version
+	db.kv.Close()
+	db.kv = *newKV(db.Path)
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	if db.kv.free != free || db.kv.version != version {
+		t.Fatalf("expected the free list %+v at version %d, got %+v at version %d",
+			free, version, db.kv.free, db.kv.version)
+	}
+	round()
+	if db.kv.page.flushed != pages {
+		t.Errorf("expected the file to stay at %d pages after a restart, got %d", pages, db.kv.page.flushed)
+	}
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// the groups GroupBy keeps in memory for the columns not in the order of the
+// scan, see DB.GroupRows
+const GROUP_MEMORY_ROWS = 100_000
+
+// the aggregates of the rows with the same group values
+type aggGroup struct {
+	key    []byte // the encoded group values
+	vals   []Value
+	states []aggState
+}
+
+// the aggregates of the rows of the request for each distinct combination of
+// the group columns, a record of the group columns & then the aggregates for
+// each, in the order of the group columns, NULLs first. when the group
+// columns are the leading columns of the scan after its equal ones, the rows
+// of a group are next to each other & the groups are streamed, else they are
+// kept in a hash table of at most DB.GroupRows groups. the request is
+// consumed, its Project is replaced.
+//
+// Deprecated: use DBTX.GroupBy or DBReader.GroupBy.
+func (db *DB) GroupBy(table string, req *Scanner, groupCols []string, aggs []AggSpec, tree *BTree) ([]Record, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	if len(groupCols) == 0 {
+		return nil, fmt.Errorf("no group columns")
+	}
+	for i, col := range groupCols {
+		if ColIndex(tdef, col) < 0 {
+			return nil, columnNotFound(tdef.Name, col)
+		}
+		if slices.Contains(groupCols[:i], col) {
+			return nil, fmt.Errorf("the group column %s twice", col)
+		}
+	}
+	states, cols, err := aggSetup(tdef, aggs, slices.Clone(groupCols))
+	if err != nil {
+		return nil, err
+	}
+	budget := db.GroupRows
+	if budget <= 0 {
+		budget = GROUP_MEMORY_ROWS
+	}
+
+	req.Project = cols
+	if err := dbScan(db, tdef, req, tree); err != nil {
+		return nil, err
+	}
+	stream := keyAdjacent(tdef, req, groupCols)
+	var groups []*aggGroup
+	hashed := map[string]*aggGroup{}
+	rec := Record{}
+	var key []byte
+	for ; req.valid(); req.Next() {
+		if err := req.Deref(&rec, tree); err != nil {
+			return nil, err
+		}
+		key = encodeValues(key[:0], rec.Vals[:len(groupCols)])
+		var g *aggGroup
+		switch {
+		case stream && len(groups) > 0 && bytes.Equal(groups[len(groups)-1].key, key):
+			g = groups[len(groups)-1]
+		case !stream:
+			g = hashed[string(key)]
+		}
+		if g == nil {
+			if !stream && len(groups) == budget {
+				return nil, fmt.Errorf("%w: more than %d groups", ErrMemoryLimit, budget)
+			}
+			g = &aggGroup{
+				key:    slices.Clone(key),
+				vals:   slices.Clone(rec.Vals[:len(groupCols)]),
+				states: slices.Clone(states),
+			}
+			groups = append(groups, g)
+			if !stream {
+				hashed[string(g.key)] = g
+			}
+		}
+		for i := range g.states {
+			if err := g.states[i].add(aggs[i], rec.Vals); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := req.iterErr(); err != nil {
+		return nil, err
+	}
+	// the streamed groups are in the order already, unless the scan is
+	// descending or its index has the group columns in another order
+	slices.SortFunc(groups, func(a, b *aggGroup) int { return bytes.Compare(a.key, b.key) })
+
+	out := make([]Record, len(groups))
+	for i, g := range groups {
+		res := aggResult(aggs, g.states)
+		out[i] = Record{
+			Cols: append(slices.Clone(groupCols), res.Cols...),
+			Vals: append(g.vals, res.Vals...),
+		}
+	}
+	return out, nil
+}
+
+// the rows equal on the columns are next to each other in the scan: the
+// columns are the leading columns of its index after the equal ones, in any
+// order, or have the primary key & no two rows are equal
+func keyAdjacent(tdef *TableDef, req *Scanner, cols []string) bool {
+	if isSubset(cols, tdef.Cols[:tdef.PKeys]) {
+		return true
+	}
+	index := tdef.Cols[:tdef.PKeys]
+	if req.indexNo >= 0 {
+		index = tdef.Indexes[req.indexNo]
+	}
+	eq := eqPrefix(req)
+	n := 0
+	for _, col := range cols {
+		if !slices.Contains(index[:eq], col) {
+			n++
+		}
+	}
+	if eq+n > len(index) {
+		return false
+	}
+	for _, col := range index[eq : eq+n] {
+		if !slices.Contains(cols, col) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "items",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_INT64},
+		Cols:    []string{"id", "cat", "sub", "n"},
+		PKeys:   1,
+		Indexes: [][]string{{"cat", "sub"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	// a NULL & an empty cat are different groups
+	for id := int64(1); id <= 12; id++ {
+		rec := (&Record{}).AddInt64("id", id)
+		switch id % 4 {
+		case 0:
+			rec.AddNull("cat", TYPE_BYTES)
+		case 1:
+			rec.AddStr("cat", []byte("a"))
+		case 2:
+			rec.AddStr("cat", []byte("b"))
+		case 3:
+			rec.AddStr("cat", []byte{})
+		}
+		rec.AddInt64("sub", (id/4)%2).AddInt64("n", id)
+		if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	str := func(s string) *Expr { return LiteralExpr(Value{Type: TYPE_BYTES, Str: []byte(s)}) }
+	aggs := []AggSpec{{Func: AGG_COUNT}, {Func: AGG_SUM, Col: "n"}}
+
+	for _, tt := range []struct {
+		name   string
+		sc     Scanner
+		cols   []string
+		stream bool
+		want   string
+	}{
+		{"index", Scanner{Filter: CompareExpr(ColumnExpr("cat"), CMP_GE, str(""))}, []string{"cat"}, true,
+			`cat="" COUNT(*)=3 SUM(n)=21; cat="a" COUNT(*)=3 SUM(n)=15; cat="b" COUNT(*)=3 SUM(n)=18`},
+		{"hash", Scanner{}, []string{"cat"}, false,
+			`cat=NULL COUNT(*)=3 SUM(n)=24; cat="" COUNT(*)=3 SUM(n)=21; cat="a" COUNT(*)=3 SUM(n)=15; cat="b" COUNT(*)=3 SUM(n)=18`},
+		{"index in another order", Scanner{Filter: CompareExpr(ColumnExpr("cat"), CMP_GE, str(""))}, []string{"sub", "cat"}, true,
+			`sub=0 cat="" COUNT(*)=2 SUM(n)=14; sub=0 cat="a" COUNT(*)=2 SUM(n)=10; sub=0 cat="b" COUNT(*)=2 SUM(n)=12; ` +
+				`sub=1 cat="" COUNT(*)=1 SUM(n)=7; sub=1 cat="a" COUNT(*)=1 SUM(n)=5; sub=1 cat="b" COUNT(*)=1 SUM(n)=6`},
+		{"after the equal column", Scanner{Filter: CompareExpr(ColumnExpr("cat"), CMP_EQ, str("a"))}, []string{"sub"}, true,
+			`sub=0 COUNT(*)=2 SUM(n)=10; sub=1 COUNT(*)=1 SUM(n)=5`},
+		{"hash of an index column", Scanner{}, []string{"sub"}, false,
+			`sub=0 COUNT(*)=7 SUM(n)=44; sub=1 COUNT(*)=5 SUM(n)=34`},
+	} {
+		sc := tt.sc
+		rows, err := tx.GroupBy("items", &sc, tt.cols, aggs)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if keyAdjacent(tdef, &sc, tt.cols) != tt.stream {
+			t.Errorf("%s: expected streamed %v", tt.name, tt.stream)
+		}
+		var got []string
+		for _, rec := range rows {
+			got = append(got, formatRecord(rec))
+		}
+		if strings.Join(got, "; ") != tt.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.want, strings.Join(got, "; "))
+		}
+	}
+
+	// the cap is of the hash table only
+	db.GroupRows = 3
+	if _, err := tx.GroupBy("items", &Scanner{}, []string{"cat"}, aggs); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("expected ErrMemoryLimit, got %v", err)
+	}
+	sc := Scanner{Filter: CompareExpr(ColumnExpr("cat"), CMP_GE, str(""))}
+	if _, err := tx.GroupBy("items", &sc, []string{"cat", "sub"}, aggs); err != nil {
+		t.Errorf("expected the streamed groups, got %v", err)
+	}
+	for _, cols := range [][]string{nil, {"color"}, {"cat", "cat"}} {
+		if _, err := tx.GroupBy("items", &Scanner{}, cols, aggs); err == nil {
+			t.Errorf("%v: expected an error", cols)
+		}
+	}
+	db.Abort(&tx)
+}
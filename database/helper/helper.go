@@ -3,6 +3,8 @@ package helper
 import (
 	"bufio"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -11,51 +13,447 @@ type TableInput struct {
 	Types   []uint32
 	Cols    []string
 	Indexes [][]string
+	Unique  []bool // by index
+	NotNull []string
+	AutoInc bool              // the int64 primary key
+	Default map[string]string // the raw default of a column
+	Foreign []ForeignKeyInput
 }
 
-func GetTableInput(scanner *bufio.Reader) TableInput {
-	name := GetTableName(scanner)
+// col1+col2>parent.ref1+ref2, with " cascade" for ON DELETE CASCADE
+type ForeignKeyInput struct {
+	Cols    []string
+	Parent  string
+	RefCols []string
+	Cascade bool
+}
+
+// the input is typed at a terminal, the prompts are printed. a script is
+// read without them.
+var Interactive bool
+
+// what the line asked by the last prompt answers, for the completion of a
+// terminal
+const (
+	ANSWER_ANY     = 0
+	ANSWER_COMMAND = 1 // a command or a statement
+	ANSWER_TABLE   = 2
+	ANSWER_COLUMNS = 3 // a list of the columns of AnswerTable, or of AnswerCols
+)
+
+var (
+	Answer      = ANSWER_ANY
+	AnswerTable string   // the table of the command, set by GetTableName
+	AnswerCols  []string // the columns of a table being created
+)
 
-	fmt.Print("Enter column names (comma-separated): ")
-	colsInput, _ := scanner.ReadString('\n')
-	colsInput = strings.TrimSpace(colsInput)
-	cols := strings.Split(colsInput, ",")
+// the last line of the last prompt
+var lastPrompt string
 
-	fmt.Print("Enter column types (comma-separated as numbers): ")
-	typesInput, _ := scanner.ReadString('\n')
-	typesInput = strings.TrimSpace(typesInput)
-	typesStr := strings.Split(typesInput, ",")
-	types := make([]uint32, len(typesStr))
-	for i, t := range typesStr {
-		var typeValue uint32
-		fmt.Sscanf(t, "%d", &typeValue)
-		types[i] = typeValue
+// a prompt for the next line of the input, printed at a terminal only
+func Prompt(format string, a ...any) {
+	PromptFor(ANSWER_ANY, format, a...)
+}
+
+// a prompt for a line answering kind, one of ANSWER_
+func PromptFor(kind int, format string, a ...any) {
+	Answer = kind
+	if Interactive {
+		text := fmt.Sprintf(format, a...)
+		fmt.Print(text)
+		lastPrompt = text[strings.LastIndex(text, "\n")+1:]
 	}
+}
 
-	fmt.Print("Enter indexes (format: col1+col2,col3, ... or leave empty): ")
-	indexInput, _ := scanner.ReadString('\n')
-	indexInput = strings.TrimSpace(indexInput)
+// the prompt the line is typed after, to print it again
+func LastPrompt() string {
+	return lastPrompt
+}
 
-	indexes := [][]string{}
+// the table asked field by field, or typed in a line of ParseTableInput at
+// the name prompt. an invalid answer is asked again at a terminal, and is
+// the error of a script.
+func GetTableInput(scanner *bufio.Reader) (TableInput, error) {
+	Prompt("Enter table name (or the table in a line: name cols=... types=...): ")
+	line, _ := scanner.ReadString('\n')
+	if len(strings.Fields(line)) > 1 {
+		return ParseTableInput(line)
+	}
+	td := TableInput{Name: strings.TrimSpace(line), Unique: []bool{}}
+
+	err := ask(scanner, ANSWER_ANY, "Enter column names (comma-separated): ", func(answer string) error {
+		td.Cols = parseList(answer)
+		return checkCols(td.Cols)
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
+	err = ask(scanner, ANSWER_ANY, "Enter column types (comma-separated: int64, bytes, int32, float64, bool, time, or 1-6): ", func(answer string) (err error) {
+		if td.Types, err = parseTypes(answer); err != nil {
+			return err
+		}
+		return checkTypes(td.Cols, td.Types)
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
+	AnswerCols = td.Cols
+	var indexInput string
+	err = ask(scanner, ANSWER_COLUMNS, "Enter indexes (format: col1+col2,col3, ... or leave empty): ", func(answer string) error {
+		indexInput, td.Indexes = answer, parseIndexes(answer)
+		for _, index := range td.Indexes {
+			if err := checkColumns(td.Cols, "index", index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
 	if indexInput != "" {
-		indexList := strings.Split(indexInput, ",")
-		for _, indexCols := range indexList {
-			indexes = append(indexes, strings.Split(indexCols, "+"))
+		for _, index := range strings.Split(indexInput, ",") {
+			Prompt("Unique index on %s? [y/N]: ", index)
+			answer, _ := scanner.ReadString('\n')
+			td.Unique = append(td.Unique, isYes(answer))
 		}
 	}
-	tdef := TableInput{
-		Name:    name,
-		Cols:    cols,
-		Types:   types,
-		Indexes: indexes,
+
+	Prompt("Auto-increment %s? [y/N]: ", td.Cols[0])
+	autoInput, _ := scanner.ReadString('\n')
+	td.AutoInc = isYes(autoInput)
+
+	err = ask(scanner, ANSWER_COLUMNS, "Enter NOT NULL columns (comma-separated, or leave empty): ", func(answer string) error {
+		td.NotNull = parseList(answer)
+		return checkColumns(td.Cols, "NOT NULL", td.NotNull)
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
+	err = ask(scanner, ANSWER_ANY, "Enter defaults (format: col=value,... or leave empty): ", func(answer string) error {
+		td.Default = parseDefaults(answer)
+		for col := range td.Default {
+			if err := checkColumns(td.Cols, "default", []string{col}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
+	err = ask(scanner, ANSWER_ANY, "Enter foreign keys (format: col>parent.col [cascade],... or leave empty): ", func(answer string) error {
+		td.Foreign = []ForeignKeyInput{}
+		for _, decl := range parseList(answer) {
+			fields := strings.Fields(decl)
+			if len(fields) == 0 {
+				continue
+			}
+			cascade := len(fields) > 1 && strings.EqualFold(fields[1], "cascade")
+			fk := parseForeignKey(fields[0], cascade)
+			if err := checkColumns(td.Cols, "foreign key", fk.Cols); err != nil {
+				return err
+			}
+			td.Foreign = append(td.Foreign, fk)
+		}
+		return nil
+	})
+	if err != nil {
+		return TableInput{}, err
+	}
+	return td, nil
+}
+
+// asks until parse takes the answer, a script fails with its error
+func ask(scanner *bufio.Reader, kind int, prompt string, parse func(answer string) error) error {
+	for {
+		PromptFor(kind, prompt)
+		line, rerr := scanner.ReadString('\n')
+		err := parse(strings.TrimSpace(line))
+		if err == nil || !Interactive || rerr != nil {
+			return err
+		}
+		Prompt("%v, please enter again.\n", err)
+	}
+}
+
+// the input in the line of ParseTableInput, a name or a value with a space
+// or a comma does not read back
+func (td TableInput) Line() string {
+	fields := []string{td.Name, "cols=" + strings.Join(td.Cols, ",")}
+	types := make([]string, len(td.Types))
+	for i, typ := range td.Types {
+		if int(typ) < len(typeNames) {
+			types[i] = typeNames[typ]
+		} else {
+			types[i] = strconv.FormatUint(uint64(typ), 10)
+		}
+	}
+	fields = append(fields, "types="+strings.Join(types, ","))
+	// the indexes in their order, a field for each run of them unique or not
+	for i := 0; i < len(td.Indexes); {
+		unique := i < len(td.Unique) && td.Unique[i]
+		var run []string
+		for ; i < len(td.Indexes) && (i < len(td.Unique) && td.Unique[i]) == unique; i++ {
+			run = append(run, strings.Join(td.Indexes[i], "+"))
+		}
+		key := "indexes="
+		if unique {
+			key = "unique="
+		}
+		fields = append(fields, key+strings.Join(run, ","))
+	}
+	if td.AutoInc {
+		fields = append(fields, "autoinc")
+	}
+	if len(td.NotNull) > 0 {
+		fields = append(fields, "notnull="+strings.Join(td.NotNull, ","))
+	}
+	if len(td.Default) > 0 {
+		defaults := make([]string, 0, len(td.Default))
+		for col, v := range td.Default {
+			defaults = append(defaults, col+"="+v)
+		}
+		sort.Strings(defaults)
+		fields = append(fields, "defaults="+strings.Join(defaults, ","))
+	}
+	if len(td.Foreign) > 0 {
+		foreign := make([]string, len(td.Foreign))
+		for i, fk := range td.Foreign {
+			foreign[i] = strings.Join(fk.Cols, "+") + ">" + fk.Parent + "." + strings.Join(fk.RefCols, "+")
+			if fk.Cascade {
+				foreign[i] += ":cascade"
+			}
+		}
+		fields = append(fields, "foreign="+strings.Join(foreign, ","))
+	}
+	return strings.Join(fields, " ")
+}
+
+// the input of GetTableInput in a single line, for scripts:
+//
+//	users cols=id,name,age types=int64,bytes,int32 indexes=age unique=name autoinc
+//	notnull=name defaults=age=0 foreign=team>teams.id:cascade
+//
+// the indexes of unique are unique indexes, and a foreign key ending with
+// :cascade deletes its rows with the parent.
+func ParseTableInput(line string) (TableInput, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return TableInput{}, fmt.Errorf("no table name")
+	}
+	td := TableInput{
+		Name:    fields[0],
+		Indexes: [][]string{},
+		Unique:  []bool{},
+		NotNull: []string{},
+		Default: map[string]string{},
+		Foreign: []ForeignKeyInput{},
+	}
+	for _, field := range fields[1:] {
+		key, val, _ := strings.Cut(field, "=")
+		switch strings.ToLower(key) {
+		case "cols":
+			td.Cols = parseList(val)
+		case "types":
+			var err error
+			if td.Types, err = parseTypes(val); err != nil {
+				return TableInput{}, err
+			}
+		case "indexes", "unique":
+			for _, index := range parseIndexes(val) {
+				td.Indexes = append(td.Indexes, index)
+				td.Unique = append(td.Unique, strings.EqualFold(key, "unique"))
+			}
+		case "autoinc":
+			td.AutoInc = val == "" || isYes(val)
+		case "notnull":
+			td.NotNull = append(td.NotNull, parseList(val)...)
+		case "defaults":
+			for col, v := range parseDefaults(val) {
+				td.Default[col] = v
+			}
+		case "foreign":
+			for _, decl := range parseList(val) {
+				decl, cascade := strings.CutSuffix(decl, ":cascade")
+				td.Foreign = append(td.Foreign, parseForeignKey(decl, cascade))
+			}
+		default:
+			return TableInput{}, fmt.Errorf("unknown field %q, expected cols=, types=, indexes=, unique=, autoinc, notnull=, defaults= or foreign=", field)
+		}
+	}
+	if len(td.Cols) == 0 || len(td.Types) == 0 {
+		return TableInput{}, fmt.Errorf("table %s needs cols= and types=", td.Name)
+	}
+	if err := td.check(); err != nil {
+		return TableInput{}, err
+	}
+	return td, nil
+}
+
+// the types of the columns, and the columns of the indexes & the constraints
+func (td TableInput) check() error {
+	if err := checkCols(td.Cols); err != nil {
+		return err
 	}
-	return tdef
+	if err := checkTypes(td.Cols, td.Types); err != nil {
+		return err
+	}
+	for _, index := range td.Indexes {
+		if err := checkColumns(td.Cols, "index", index); err != nil {
+			return err
+		}
+	}
+	if err := checkColumns(td.Cols, "NOT NULL", td.NotNull); err != nil {
+		return err
+	}
+	for col := range td.Default {
+		if err := checkColumns(td.Cols, "default", []string{col}); err != nil {
+			return err
+		}
+	}
+	for _, fk := range td.Foreign {
+		if err := checkColumns(td.Cols, "foreign key", fk.Cols); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the names of the columns, none empty or twice
+func checkCols(cols []string) error {
+	if len(cols) == 0 {
+		return fmt.Errorf("no columns")
+	}
+	for i, col := range cols {
+		if col == "" {
+			return fmt.Errorf("column %d has no name", i+1)
+		}
+		for _, other := range cols[:i] {
+			if other == col {
+				return fmt.Errorf("the column %s is twice", col)
+			}
+		}
+	}
+	return nil
+}
+
+func checkTypes(cols []string, types []uint32) error {
+	if len(types) != len(cols) {
+		return fmt.Errorf("%d types for %d columns", len(types), len(cols))
+	}
+	return nil
+}
+
+// the columns of the index or the constraint are columns of the table
+func checkColumns(cols []string, what string, names []string) error {
+	for _, name := range names {
+		found := false
+		for _, col := range cols {
+			found = found || col == name
+		}
+		if !found {
+			return fmt.Errorf("the %s column %q is not a column of the table", what, name)
+		}
+	}
+	return nil
+}
+
+// the comma-separated items, none for an empty string
+func parseList(s string) []string {
+	items := []string{}
+	if s == "" {
+		return items
+	}
+	for _, item := range strings.Split(s, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+	return items
+}
+
+// the names of the column types, lower case, by their numbers
+var typeNames = []string{1: "int64", 2: "bytes", 3: "int32", 4: "float64", 5: "bool", 6: "time"}
+
+// the other names of the types, as in SQL
+var typeAliases = map[string]uint32{
+	"int": 1, "bigint": 1, "integer": 3, "text": 2, "varchar": 2, "string": 2,
+	"float": 4, "double": 4, "boolean": 5, "timestamp": 6,
+}
+
+// a name of typeNames or of SQL in any case, or the number, 1-6
+func ParseType(s string) (uint32, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		if n == 0 || n >= uint64(len(typeNames)) {
+			return 0, fmt.Errorf("unknown type %s, the numbers are 1-%d", s, len(typeNames)-1)
+		}
+		return uint32(n), nil
+	}
+	for typ, name := range typeNames {
+		if name != "" && name == s {
+			return uint32(typ), nil
+		}
+	}
+	if typ, ok := typeAliases[s]; ok {
+		return typ, nil
+	}
+	return 0, fmt.Errorf("unknown type %q, expected %s", s, strings.Join(typeNames[1:], ", "))
+}
+
+// int64,bytes,3
+func parseTypes(s string) ([]uint32, error) {
+	types := []uint32{}
+	for _, item := range parseList(s) {
+		typ, err := ParseType(item)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+// col1+col2,col3
+func parseIndexes(s string) [][]string {
+	indexes := [][]string{}
+	for _, index := range parseList(s) {
+		indexes = append(indexes, strings.Split(index, "+"))
+	}
+	return indexes
+}
+
+// col=value,...
+func parseDefaults(s string) map[string]string {
+	defaults := map[string]string{}
+	for _, pair := range parseList(s) {
+		col, val, _ := strings.Cut(pair, "=")
+		defaults[strings.TrimSpace(col)] = strings.TrimSpace(val)
+	}
+	return defaults
+}
+
+// col1+col2>parent.ref1+ref2
+func parseForeignKey(decl string, cascade bool) ForeignKeyInput {
+	cols, ref, _ := strings.Cut(decl, ">")
+	parent, refCols, _ := strings.Cut(ref, ".")
+	return ForeignKeyInput{
+		Cols:    strings.Split(cols, "+"),
+		Parent:  parent,
+		RefCols: strings.Split(refCols, "+"),
+		Cascade: cascade,
+	}
+}
+
+func isYes(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 func GetTableName(scanner *bufio.Reader) string {
-	fmt.Print("Enter table name: ")
+	PromptFor(ANSWER_TABLE, "Enter table name: ")
 	name, _ := scanner.ReadString('\n')
 	name = strings.TrimSpace(name)
+	AnswerTable, AnswerCols = name, nil
 	return name
 }
 
@@ -65,13 +463,36 @@ func PrintWelcomeMessage(isWelcome bool) {
 	}
 	fmt.Println("Available Commands:")
 	fmt.Println("  CREATE       - Create a new table")
-	fmt.Println("  INSERT       - Add a record to a table")
+	fmt.Println("  DROP         - Remove a table with its records & indexes")
+	fmt.Println("  RENAME       - Rename a table or a column")
+	fmt.Println("  INSERT       - Add a record to a table, or INSERT users 1 \"ann\" on one line")
 	fmt.Println("  DELETE       - Delete a record from a table")
 	fmt.Println("  GET          - Retrieve a record from a table")
+	fmt.Println("  SCAN         - List all records of a table")
 	fmt.Println("  UPDATE       - Update a record in a table")
-	fmt.Println("  BEGIN        - Begin new transaction")
+	fmt.Println("  UPSERT       - Insert a record or replace the one with its primary key")
+	fmt.Println("  CHECK        - Verify the database structure & the indexes, CHECK <name> REPAIR fixes a table")
+	fmt.Println("  VERIFY       - Check the checksums of all pages")
+	fmt.Println("  BACKUP       - Copy a snapshot of the database to a file")
+	fmt.Println("  VACUUM       - Rewrite the database file without the free pages")
+	fmt.Println("  IMPORT       - Load the rows of a CSV file into a table")
+	fmt.Println("  EXPORT       - Write the rows of a table to a CSV or JSON Lines file")
+	fmt.Println("  MODE         - Print the rows as a table, CSV or JSON, MODE table width=100")
+	fmt.Println("  DUMP         - Write the tables & rows as a script replayed with -f")
+	fmt.Println("  TIMER        - Print the time & the rows examined after the data commands, TIMER on|off")
+	fmt.Println("  STATS        - Show the tree layout of a table")
+	fmt.Println("  ANALYZE      - Compute the statistics of a table for the planner, ANALYZE <name>")
+	fmt.Println("  TABLES       - List the tables")
+	fmt.Println("  DESC         - Show the columns, indexes & keys of a table, DESC <name>")
+	fmt.Println("  BEGIN        - Begin new transaction, aborted after 10m without a command")
 	fmt.Println("  COMMIT       - Commit transaction")
 	fmt.Println("  ABORT        - Rollback transaction")
+	fmt.Println("  SAVEPOINT    - Mark a savepoint in the transaction")
+	fmt.Println("  ROLLBACK     - Roll back the transaction to a savepoint")
+	fmt.Println("  RELEASE      - Forget a savepoint, keeping its changes")
+	fmt.Println("  SQL;         - CREATE TABLE, INSERT, SELECT, UPDATE & DELETE statements ending with ;")
+	fmt.Println("  EXPLAIN ...; - Print the scan of a SELECT, UPDATE or DELETE without running it")
+	fmt.Println("  EXPLAIN ANALYZE ...; - Run the scan too, with the actual rows after the estimated ones")
 	fmt.Println("  HELP         - List all commands")
 	fmt.Println("  EXIT         - Exit the program")
 	fmt.Println()
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrHookPanic error = errors.New("a hook panicked")
+
+// queue a callback run after the transaction commits, in the order they were
+// queued. it runs outside of the locks of the DB & can use the DB.
+func (tx *DBTX) OnCommit(fn func()) {
+	tx.mu.Lock()
+	tx.onCommit = append(tx.onCommit, fn)
+	tx.mu.Unlock()
+}
+
+// queue a callback run after the transaction is aborted or fails to commit
+func (tx *DBTX) OnRollback(fn func()) {
+	tx.mu.Lock()
+	tx.onRollback = append(tx.onRollback, fn)
+	tx.mu.Unlock()
+}
+
+// register a callback run with the table & the row after each autocommit
+// write of a single row, e.g. an INSERT outside of BEGIN. registered before
+// the DB is used by other goroutines.
+func (db *DB) OnAutocommit(fn func(table string, rec Record)) {
+	db.hooks.autocommit = append(db.hooks.autocommit, fn)
+}
+
+// run a single row write in a transaction of its own, the hooks get the row
+// as the write left it, e.g. with an AUTO_INCREMENT key
+func (db *DB) autocommit(table string, rec *Record, write func(tx *DBTX) (bool, error)) (bool, error) {
+	var tx DBTX
+	db.Begin(&tx)
+	done, err := write(&tx)
+	if err != nil || !done {
+		if herr := db.Abort(&tx); err == nil {
+			err = herr
+		}
+		return done, err
+	}
+	for _, fn := range db.hooks.autocommit {
+		tx.OnCommit(func() { fn(table, *rec) })
+	}
+	return true, db.Commit(&tx)
+}
+
+// every hook runs, a panic is returned as the error of the first one
+func runHooks(hooks []func()) error {
+	var first error
+	for _, fn := range hooks {
+		if err := runHook(fn); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func runHook(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrHookPanic, r)
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	insertTestRecord(t, db, 1)
+	var calls []string
+	committed := func(id int64) bool {
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		ok, _ := reader.Get("users", (&Record{}).AddInt64("id", id))
+		return ok
+	}
+
+	// in order, after the commit & outside of the locks
+	var tx DBTX
+	db.Begin(&tx)
+	if _, err := tx.Set("users", userRecord(2, "a"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	tx.OnCommit(func() {
+		calls = append(calls, "commit 1")
+		if !committed(2) {
+			t.Errorf("expected the commit to be visible in the hook")
+		}
+		var inner DBTX
+		db.Begin(&inner)
+		if _, err := inner.Set("users", userRecord(3, "hook"), MODE_INSERT_ONLY); err != nil {
+			t.Errorf("failed to insert in the hook: %v", err)
+		}
+		if err := db.Commit(&inner); err != nil {
+			t.Errorf("failed to commit in the hook: %v", err)
+		}
+	})
+	tx.OnCommit(func() { calls = append(calls, "commit 2") })
+	tx.OnRollback(func() { calls = append(calls, "rollback") })
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if want := []string{"commit 1", "commit 2"}; !reflect.DeepEqual(calls, want) || !committed(3) {
+		t.Errorf("expected %v, got %v", want, calls)
+	}
+
+	// abort & a failed commit run the rollback hooks
+	calls = nil
+	db.Begin(&tx)
+	tx.OnCommit(func() { calls = append(calls, "commit") })
+	tx.OnRollback(func() { calls = append(calls, "rollback") })
+	if err := db.Abort(&tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var other DBTX
+	db.Begin(&tx)
+	db.Begin(&other)
+	for _, tx := range []*DBTX{&tx, &other} {
+		if _, err := tx.Set("users", userRecord(1, "x"), MODE_UPDATE_ONLY); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+	}
+	if err := db.Commit(&other); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	tx.OnCommit(func() { calls = append(calls, "commit") })
+	tx.OnRollback(func() { calls = append(calls, "conflict") })
+	if err := db.Commit(&tx); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if want := []string{"rollback", "conflict"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected %v, got %v", want, calls)
+	}
+
+	// a panic is an error, the commit & the other hooks are kept
+	calls = nil
+	db.Begin(&tx)
+	if _, err := tx.Set("users", userRecord(4, "a"), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	tx.OnCommit(func() { panic("boom") })
+	tx.OnCommit(func() { calls = append(calls, "after") })
+	if err := db.Commit(&tx); !errors.Is(err, ErrHookPanic) {
+		t.Errorf("expected ErrHookPanic, got %v", err)
+	}
+	if !committed(4) || !reflect.DeepEqual(calls, []string{"after"}) {
+		t.Errorf("expected the commit & the next hook, got %v %v", committed(4), calls)
+	}
+	db.Begin(&tx)
+	tx.OnRollback(func() { panic("boom") })
+	if err := db.Abort(&tx); !errors.Is(err, ErrHookPanic) {
+		t.Errorf("expected ErrHookPanic, got %v", err)
+	}
+	// the hooks end with the transaction
+	db.Begin(&tx)
+	if err := db.Commit(&tx); err != nil {
+		t.Errorf("expected no hooks in a new transaction, got %v", err)
+	}
+
+	// the autocommit writes
+	var rows []int64
+	db.OnAutocommit(func(table string, rec Record) {
+		if table == "users" {
+			rows = append(rows, rec.Get("id").I64)
+		}
+	})
+	insert := func(id int64) (bool, error) {
+		rec := userRecord(id, "auto")
+		return db.autocommit("users", &rec, func(tx *DBTX) (bool, error) {
+			return tx.Set("users", rec, MODE_INSERT_ONLY)
+		})
+	}
+	if ok, err := insert(5); !ok || err != nil {
+		t.Fatalf("failed to insert: %v %v", ok, err)
+	}
+	if _, err := insert(5); err == nil {
+		t.Fatalf("expected the duplicate to fail")
+	}
+	if !reflect.DeepEqual(rows, []int64{5}) || !committed(5) {
+		t.Errorf("expected the hook for the commit only, got %v", rows)
+	}
+}
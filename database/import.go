@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// the header row of a CSV import
+const (
+	IMPORT_HEADER      = 0 // the fields are matched to the columns by the names of the header
+	IMPORT_SKIP_HEADER = 1 // the header is skipped, the fields are the columns in order
+	IMPORT_NO_HEADER   = 2 // the fields of every row are the columns in order
+)
+
+// the rows inserted by each transaction of an import by default
+const IMPORT_BATCH = 1000
+
+// the errors of the rejected rows kept by ImportStats
+const IMPORT_MAX_ERRORS = 100
+
+type ImportOptions struct {
+	Header    int  // IMPORT_HEADER, IMPORT_SKIP_HEADER or IMPORT_NO_HEADER
+	Delimiter rune // ',' when 0
+	EmptyNull bool // an empty field gets the default of its column, or is a null
+	BatchSize int  // the rows inserted by each transaction, IMPORT_BATCH when 0
+}
+
+type ImportStats struct {
+	Inserted int
+	Rejected int     // the rows that failed to parse or to insert
+	Errors   []error // of the first IMPORT_MAX_ERRORS rejected rows, with their lines
+	Elapsed  time.Duration
+}
+
+// insert the rows of the CSV input into the table, committed every
+// BatchSize rows. a row that fails is rejected & the import goes on, the
+// error is for the input or the commits: the batches before it are kept.
+func (db *DB) Import(table string, src io.Reader, opts ImportOptions) (ImportStats, error) {
+	return db.ImportContext(context.Background(), table, src, opts)
+}
+
+// Import stopped once ctx is done, with the error of ctx. the batch of the
+// rows read since the last commit is rolled back, the ones committed before
+// are kept & counted by stats.Inserted.
+func (db *DB) ImportContext(ctx context.Context, table string, src io.Reader, opts ImportOptions) (stats ImportStats, err error) {
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	start := time.Now()
+	batch := opts.BatchSize
+	if batch <= 0 {
+		batch = IMPORT_BATCH
+	}
+	r := csv.NewReader(src)
+	if opts.Delimiter != 0 {
+		r.Comma = opts.Delimiter
+	}
+	r.FieldsPerRecord = -1 // checked by the row
+	r.ReuseRecord = true
+
+	tx := &DBTX{}
+	db.Begin(tx)
+	defer func() {
+		if tx != nil {
+			db.Abort(tx)
+		}
+		stats.Elapsed = time.Since(start)
+		db.countRows(int64(stats.Inserted+stats.Rejected), 0)
+	}()
+	tdef, err := tx.Describe(table)
+	if err != nil {
+		return stats, err
+	}
+	cols, err := importColumns(tdef, r, opts.Header)
+	if err != nil {
+		return stats, err
+	}
+	types := make([]uint32, len(cols))
+	for i, col := range cols {
+		types[i] = tdef.Types[ColIndex(tdef, col)]
+	}
+
+	reject := func(line int, err error) {
+		stats.Rejected++
+		if len(stats.Errors) < IMPORT_MAX_ERRORS {
+			stats.Errors = append(stats.Errors, fmt.Errorf("line %d: %w", line, err))
+		}
+	}
+	pending := 0
+	for {
+		if ctx.Err() != nil {
+			return stats, context.Cause(ctx)
+		}
+		fields, err := r.Read()
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			reject(perr.Line, perr.Err)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		line, _ := r.FieldPos(0)
+		rec, err := importRecord(cols, types, fields, opts.EmptyNull)
+		if err != nil {
+			reject(line, err)
+			continue
+		}
+		if _, err := tx.InsertAuto(table, rec); err != nil {
+			if tx.Err() != nil {
+				return stats, err
+			}
+			reject(line, err)
+			continue
+		}
+		pending++
+		if pending == batch {
+			if err := db.Commit(tx); err != nil {
+				tx = nil
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.Inserted += pending
+			pending = 0
+			tx = &DBTX{}
+			db.Begin(tx)
+		}
+	}
+	err = db.Commit(tx)
+	tx = nil
+	if err != nil {
+		return stats, err
+	}
+	stats.Inserted += pending
+	return stats, nil
+}
+
+// the column of each field, by the header or in the order of the table
+func importColumns(tdef *TableDef, r *csv.Reader, header int) ([]string, error) {
+	if header == IMPORT_NO_HEADER {
+		return tdef.Cols, nil
+	}
+	names, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV input has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header == IMPORT_SKIP_HEADER {
+		return tdef.Cols, nil
+	}
+	cols := make([]string, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // the BOM of a spreadsheet
+		}
+		if ColIndex(tdef, name) < 0 {
+			return nil, columnNotFound(tdef.Name, name)
+		}
+		for _, col := range cols[:i] {
+			if col == name {
+				return nil, fmt.Errorf("the column %s is twice in the header", name)
+			}
+		}
+		cols[i] = name
+	}
+	return cols, nil
+}
+
+// the values of the fields, a field of a type other than bytes may have
+// spaces around it. an empty field is left out with emptyNull.
+func importRecord(cols []string, types []uint32, fields []string, emptyNull bool) (*Record, error) {
+	if len(fields) != len(cols) {
+		return nil, fmt.Errorf("%d fields for %d columns", len(fields), len(cols))
+	}
+	rec := &Record{}
+	for i, field := range fields {
+		if types[i] != TYPE_BYTES {
+			field = strings.TrimSpace(field)
+		}
+		if field == "" && emptyNull {
+			continue
+		}
+		v, err := parseValue(types[i], field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", cols[i], field, err)
+		}
+		rec.Cols = append(rec.Cols, cols[i])
+		rec.Vals = append(rec.Vals, v)
+	}
+	return rec, nil
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:          "people",
+		Types:         []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT32, TYPE_FLOAT64, TYPE_BOOL},
+		Cols:          []string{"id", "name", "age", "score", "active"},
+		PKeys:         1,
+		Indexes:       [][]string{{"name"}},
+		Unique:        []bool{true},
+		AutoIncrement: true,
+		NotNull:       []bool{false, false, false, false, false},
+		Defaults:      []Value{{}, {}, {Type: TYPE_INT32, I64: 18}, {}, {}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	count := func() int {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		n, err := reader.RowCount("people")
+		if err != nil {
+			t.Fatalf("failed to count: %v", err)
+		}
+		return int(n)
+	}
+
+	// the header in another order, a row per line
+	var csv strings.Builder
+	csv.WriteString("\ufeffname, active,score,age,id\n")
+	for i := 1; i <= 250; i++ {
+		fmt.Fprintf(&csv, "p%d,%v, %d.5 ,%d,%d\n", i, i%2 == 0, i, i%90, i)
+	}
+	csv.WriteString("bad,maybe,1,1,1000\n")        // line 252
+	csv.WriteString("p1,true,1,1,1001\n")          // line 253, the unique name
+	csv.WriteString("short,true\n")                // line 254
+	csv.WriteString("\"quoted, name\",true,2,,\n") // the default age & an assigned id
+	stats, err := db.Import("people", strings.NewReader(csv.String()), ImportOptions{BatchSize: 100, EmptyNull: true})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if stats.Inserted != 251 || stats.Rejected != 3 || len(stats.Errors) != 3 {
+		t.Fatalf("expected 251 rows & 3 rejected, got %+v", stats)
+	}
+	for i, prefix := range []string{"line 252: invalid active", "line 253: ", "line 254: 2 fields for 5 columns"} {
+		if !strings.HasPrefix(stats.Errors[i].Error(), prefix) {
+			t.Errorf("expected %q, got %v", prefix, stats.Errors[i])
+		}
+	}
+	if !errors.Is(stats.Errors[1], ErrUniqueViolation) {
+		t.Errorf("expected the unique violation, got %v", stats.Errors[1])
+	}
+	if n := count(); n != 251 {
+		t.Errorf("expected 251 rows, got %d", n)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	rec := (&Record{}).AddInt64("id", 7)
+	if ok, err := reader.Get("people", rec); err != nil || !ok || string(rec.Get("name").Str) != "p7" || rec.Get("score").F64 != 7.5 {
+		t.Errorf("expected p7, got %v %v %v", rec, ok, err)
+	}
+	// the rejected id 1001 was taken by the counter
+	rec = (&Record{}).AddInt64("id", 1002)
+	if ok, err := reader.Get("people", rec); err != nil || !ok || string(rec.Get("name").Str) != "quoted, name" || rec.Get("age").I64 != 18 {
+		t.Errorf("expected the row with the default age, got %v %v %v", rec, ok, err)
+	}
+	db.EndRead(&reader)
+
+	// a delimiter & no header, the empty name is a value without EmptyNull
+	stats, err = db.Import("people", strings.NewReader("500;;30;1;false\n"), ImportOptions{Header: IMPORT_NO_HEADER, Delimiter: ';'})
+	if err != nil || stats.Inserted != 1 {
+		t.Errorf("expected a row, got %+v %v", stats, err)
+	}
+	stats, err = db.Import("people", strings.NewReader("a,b,c,d,e\n501,x,3,1,true\n"), ImportOptions{Header: IMPORT_SKIP_HEADER})
+	if err != nil || stats.Inserted != 1 {
+		t.Errorf("expected a row, got %+v %v", stats, err)
+	}
+	if n := count(); n != 253 {
+		t.Errorf("expected 253 rows, got %d", n)
+	}
+
+	if _, err := db.Import("people", strings.NewReader("id,colour\n"), ImportOptions{}); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	if _, err := db.Import("people", strings.NewReader("id,id\n"), ImportOptions{}); err == nil {
+		t.Errorf("expected an error for a column twice")
+	}
+	if _, err := db.Import("nothing", strings.NewReader("id\n"), ImportOptions{}); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+
+	opts, err := parseImportOptions("header=skip delimiter=tab empty=null batch=10")
+	if err != nil || opts.Header != IMPORT_SKIP_HEADER || opts.Delimiter != '\t' || !opts.EmptyNull || opts.BatchSize != 10 {
+		t.Errorf("unexpected options %+v %v", opts, err)
+	}
+	if _, err := parseImportOptions("batch=0"); err == nil {
+		t.Errorf("expected an error for batch=0")
+	}
+}
+
+func TestImportContext(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	var csv strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&csv, "%d,u%d,u%d@x\n", i, i, i)
+	}
+	lines := strings.SplitAfter(csv.String(), "\n")
+	count := func() int64 {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		n, err := reader.RowCount("users")
+		if err != nil {
+			t.Fatalf("failed to count: %v", err)
+		}
+		return n
+	}
+
+	// stopped at the row 36, the batch of the rows 31 to 35 is rolled back
+	ctx := &countdownCtx{Context: context.Background(), n: 36}
+	opts := ImportOptions{Header: IMPORT_NO_HEADER, BatchSize: 10}
+	stats, err := db.ImportContext(ctx, "users", strings.NewReader(csv.String()), opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the error of the context, got %v", err)
+	}
+	if stats.Inserted != 30 || count() != 30 {
+		t.Fatalf("expected the 30 rows committed, got %+v & %d rows", stats, count())
+	}
+
+	// restarted after the rows committed
+	rest := strings.NewReader(strings.Join(lines[stats.Inserted:], ""))
+	if stats, err = db.Import("users", rest, opts); err != nil || stats.Inserted != 70 {
+		t.Fatalf("expected the other 70 rows, got %+v %v", stats, err)
+	}
+	if n := count(); n != 100 {
+		t.Errorf("expected 100 rows, got %d", n)
+	}
+}
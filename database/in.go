@@ -0,0 +1,135 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// the rows of ScanIn, in the order of the index of the column
+type InScan struct {
+	tx   *DBTX // of DBTX.ScanIn, the rows stop once it ended
+	db   *DB
+	tree *BTree
+	tdef *TableDef
+	col  string
+	vals []Value // sorted, without duplicates & NULLs
+	pos  int     // the value of sc
+	sc   Scanner // of the rows equal to the value, reused
+	ok   bool
+	err  error
+}
+
+// the rows with the column equal to one of the values, by a lookup of each
+// on the primary key or the index that starts with the column. a NULL is never
+// equal, a value of another type than the column is an error.
+//
+// Deprecated: use DBTX.ScanIn or DBReader.ScanIn.
+func (db *DB) ScanIn(table string, col string, vals []Value, tree *BTree) (*InScan, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	idx := ColIndex(tdef, col)
+	if idx < 0 {
+		return nil, columnNotFound(tdef.Name, col)
+	}
+	if _, err := findIndex(tdef, []string{col}); err != nil {
+		return nil, fmt.Errorf("%s is not the first column of the primary key or an index of %s", col, tdef.Name)
+	}
+	s := &InScan{db: db, tree: tree, tdef: tdef, col: col}
+	for _, v := range vals {
+		cond := Cond{Col: col, Cmp: CMP_EQ, Val: v}
+		switch {
+		case v.Null:
+		case !keyable(tdef, cond):
+			return nil, fmt.Errorf("%w: %s is %s, %s is %s", ErrTypeMismatch,
+				col, typeName(tdef.Types[idx]), formatValue(v), typeName(v.Type))
+		default:
+			s.vals = append(s.vals, keyValue(tdef, cond))
+		}
+	}
+	// the order of the keys
+	slices.SortFunc(s.vals, func(v1, v2 Value) int {
+		return bytes.Compare(encodeValues(nil, []Value{v1}), encodeValues(nil, []Value{v2}))
+	})
+	s.vals = slices.CompactFunc(s.vals, func(v1, v2 Value) bool { return orderValues(v1, v2) == 0 })
+	s.load()
+	return s, nil
+}
+
+// the first row of the values from the current one
+func (s *InScan) load() {
+	for s.ok = false; s.pos < len(s.vals); s.pos++ {
+		key := Record{Cols: []string{s.col}, Vals: []Value{s.vals[s.pos]}}
+		s.sc = Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+		if err := dbScan(s.db, s.tdef, &s.sc, s.tree); err != nil {
+			s.err = err
+			return
+		}
+		if s.sc.valid() {
+			s.ok = true
+			return
+		}
+		if err := s.sc.iterErr(); err != nil {
+			s.err = err
+			return
+		}
+	}
+}
+
+func (s *InScan) Valid() bool {
+	if s.tx != nil {
+		if s.tx.enter() != nil {
+			return false
+		}
+		defer s.tx.mu.Unlock()
+	}
+	return s.ok && s.err == nil
+}
+
+func (s *InScan) Next() {
+	if s.tx != nil {
+		if s.tx.enter() != nil {
+			return
+		}
+		defer s.tx.mu.Unlock()
+	}
+	if !s.ok || s.err != nil {
+		return
+	}
+	s.sc.Next()
+	if s.sc.valid() {
+		return
+	}
+	if err := s.sc.iterErr(); err != nil {
+		s.err = err
+		return
+	}
+	s.pos++
+	s.load()
+}
+
+// the current row, of every column
+func (s *InScan) Deref(rec *Record) error {
+	if s.tx != nil {
+		if err := s.tx.enter(); err != nil {
+			return err
+		}
+		defer s.tx.mu.Unlock()
+	}
+	if !s.ok || s.err != nil {
+		return s.err
+	}
+	return s.sc.deref(rec, s.tree, true)
+}
+
+// the reason the rows stopped early, nil when they ran out
+func (s *InScan) Err() error {
+	if s.tx != nil {
+		if err := s.tx.Err(); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScanIn(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "items",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT32, TYPE_INT64},
+		Cols:    []string{"id", "sku", "cat", "n"},
+		PKeys:   1,
+		Indexes: [][]string{{"sku"}, {"cat", "n"}},
+		Unique:  []bool{true, false},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	for id := int64(1); id <= 20; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddStr("sku", []byte{'a' + byte(id)})
+		if id == 20 {
+			rec.AddNull("cat", TYPE_INT32)
+		} else {
+			rec.AddInt32("cat", int32(id%4))
+		}
+		rec.AddInt64("n", -id)
+		if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	i64 := func(v int64) Value { return Value{Type: TYPE_INT64, I64: v} }
+	i32 := func(v int32) Value { return Value{Type: TYPE_INT32, I64: int64(v)} }
+	str := func(s string) Value { return Value{Type: TYPE_BYTES, Str: []byte(s)} }
+	null := Value{Type: TYPE_INT64, Null: true}
+
+	for _, tt := range []struct {
+		col  string
+		vals []Value
+		want string // the ids
+	}{
+		// sorted & without duplicates, the missing keys skipped
+		{"id", []Value{i64(7), i64(3), i64(99), i64(7), i64(-1), null}, "3 7"},
+		{"id", []Value{i32(2), i64(1)}, "1 2"},
+		{"sku", []Value{str("f"), str("c"), str("zz"), str("")}, "2 5"},
+		// each value a range of the index, in the order of the index
+		{"cat", []Value{i32(3), i64(1), null}, "17 13 9 5 1 19 15 11 7 3"},
+		{"cat", nil, ""},
+	} {
+		rows, err := tx.ScanIn("items", tt.col, tt.vals)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.col, tt.vals, err)
+		}
+		var got []string
+		rec := Record{}
+		for ; rows.Valid(); rows.Next() {
+			if err := rows.Deref(&rec); err != nil {
+				t.Fatalf("failed to deref: %v", err)
+			}
+			got = append(got, formatValue(*rec.Get("id")))
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("%s: %v", tt.col, err)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s %v: expected %q, got %q", tt.col, tt.vals, tt.want, strings.Join(got, " "))
+		}
+	}
+
+	for _, vals := range [][]Value{{i64(1), str("x")}, {i64(1 << 40)}} {
+		if _, err := tx.ScanIn("items", "cat", vals); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("%v: expected ErrTypeMismatch, got %v", vals, err)
+		}
+	}
+	if _, err := tx.ScanIn("items", "color", nil); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	if _, err := tx.ScanIn("items", "n", []Value{i64(1)}); err == nil {
+		t.Errorf("expected an error for a column without an index")
+	}
+
+	// the same in a filter
+	in := InExpr(ColumnExpr("cat"), LiteralExpr(i32(3)), LiteralExpr(Value{Null: true}))
+	if in.String() != "cat IN (3, NULL)" {
+		t.Errorf("unexpected %v", in)
+	}
+	sc := Scanner{Filter: in}
+	if err := tx.Scan("items", &sc); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if n := sc.Count(); n != 5 {
+		t.Errorf("expected 5 rows of the filter, got %d", n)
+	}
+	if err := tx.Scan("items", &Scanner{Filter: InExpr(ColumnExpr("cat"), LiteralExpr(str("x")))}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+	db.Abort(&tx)
+}
@@ -1,41 +1,74 @@
 package database
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"strings"
 )
 
-const (
-	INDEX_ADD = 1
-	INDEX_DEL = 2
-)
+var ErrUniqueViolation error = errors.New("unique constraint violated")
 
-func indexOp(_ *DB, tdef *TableDef, rec Record, op int, kvtx *KVTX) {
-	key := make([]byte, 0, 256)
-	irec := make([]Value, len(rec.Cols))
+// a value of a unique index taken by another row, matches ErrUniqueViolation
+// with errors.Is
+type UniqueError struct {
+	Table  string
+	Index  []string // the unique columns
+	Value  Record   // the value taken
+	prefix []byte   // the index keys of the value
+}
+
+func (e *UniqueError) Error() string {
+	return fmt.Sprintf("%v: index %s(%s), %s", ErrUniqueViolation, e.Table, strings.Join(e.Index, ","), formatRecord(e.Value))
+}
+
+func (e *UniqueError) Unwrap() error {
+	return ErrUniqueViolation
+}
+
+// replace the entries of the old row by the keys of the new row in the
+// indexes where they differ, the keys are checked by indexKeysCheck first
+func indexUpdate(tdef *TableDef, old Record, keys [][]byte, kvtx *KVTX) error {
+	oldKeys := indexKeys(tdef, old)
+	for i := range tdef.Indexes {
+		if bytes.Equal(oldKeys[i], keys[i]) {
+			continue
+		}
+		kvtx.Del(&DeleteReq{Key: oldKeys[i]})
+		if _, err := kvtx.SetWithMode(&InsertReq{Key: keys[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// the key of the row in each index, rec must have every column
+func indexKeys(tdef *TableDef, rec Record) [][]byte {
+	keys := make([][]byte, len(tdef.Indexes))
+	irec := make([]Value, len(rec.Cols))
 	for i, index := range tdef.Indexes {
-		// indexed key
 		for j, c := range index {
 			irec[j] = *rec.Get(c)
 		}
-		key = encodeKey(key[:0], tdef.IndexPrefix[i], irec[:len(index)])
-		done, err := false, error(nil)
-		switch op {
-		case INDEX_ADD:
-			done, err = kvtx.SetWithMode(&InsertReq{Key: key})
-		case INDEX_DEL:
-			done, err = kvtx.Delete(&DeleteReq{Key: key})
-		default:
-			panic("invalid index op")
-		}
-		if err != nil {
-			//! TODO
+		keys[i] = encodeKey(nil, tdef.IndexPrefix[i], irec[:len(index)])
+	}
+	return keys
+}
+
+// the index keys of a row must fit in the tree like its primary key, checked
+// before the row is written so it never lands without its entries
+func indexKeysCheck(tdef *TableDef, keys [][]byte) error {
+	for i, key := range keys {
+		if len(key) > BTREE_MAX_KEY_SIZE {
+			return fmt.Errorf("index %s(%s): key size not valid", tdef.Name, strings.Join(tdef.Indexes[i], ","))
 		}
-		assert(done)
 	}
+	return nil
 }
 
+// the key of the leading values of an index as a bound of a range, appended
+// to out so a caller can reuse its buffer
 func encodeKeyPartial(
 	out []byte,
 	prefix uint32,
@@ -45,20 +78,9 @@ func encodeKeyPartial(
 	cmp int,
 ) []byte {
 	out = encodeKey(out, prefix, values)
-	max := cmp == CMP_GT || cmp == CMP_LE
-
-loop:
-	for i := len(values); max && i < len(keys); i++ {
-		switch tdef.Types[ColIndex(tdef, keys[i])] {
-		case TYPE_BYTES:
-			out = append(out, 0xff)
-			//	Any byte string with a prefix of [X, 0xFF] will be greater than all byte strings with prefix [X]
-			break loop
-		case TYPE_INT64:
-			out = append(out, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
-		default:
-			panic("type mismatch encodeKeyPartial")
-		}
+	// any value of the next column starts with a smaller byte, see encodeValues
+	if (cmp == CMP_GT || cmp == CMP_LE) && len(values) < len(keys) {
+		out = append(out, 0xff)
 	}
 	return out
 }
@@ -99,6 +121,16 @@ func isPrefix(long []string, short []string) bool {
 	return true
 }
 
+// checks if every item of `short` appears in `long`
+func isSubset(long []string, short []string) bool {
+	for _, c := range short {
+		if !contains(long, c) {
+			return false
+		}
+	}
+	return true
+}
+
 func checkIndexKeys(tdef *TableDef, index []string) ([]string, error) {
 	icols := map[string]bool{}
 
@@ -138,3 +170,109 @@ func ColIndex(tdef *TableDef, col string) int {
 	}
 	return -1
 }
+
+func indexUnique(tdef *TableDef, i int) bool {
+	return i < len(tdef.Unique) && tdef.Unique[i]
+}
+
+// the columns of a unique index, the ones before the primary key columns
+func uniqueCols(tdef *TableDef, i int) []string {
+	index := tdef.Indexes[i]
+	for j, col := range index {
+		if ColIndex(tdef, col) < tdef.PKeys {
+			return index[:j]
+		}
+	}
+	return index
+}
+
+// the rows with the same unique value share the prefix of their index keys
+func uniquePrefix(tdef *TableDef, i int, rec Record) []byte {
+	cols := uniqueCols(tdef, i)
+	vals := make([]Value, len(cols))
+	for j, col := range cols {
+		vals[j] = *rec.Get(col)
+	}
+	return encodeKey(nil, tdef.IndexPrefix[i], vals)
+}
+
+// a null is not equal to another null, a row with one takes no unique value
+func uniqueNull(tdef *TableDef, i int, rec Record) bool {
+	for _, col := range uniqueCols(tdef, i) {
+		if rec.Get(col).Null {
+			return true
+		}
+	}
+	return false
+}
+
+// the unique values of a row written with the mode are not taken by another
+// row of the transaction's tree. the prefixes are checked again by the commit,
+// against the commits made since the transaction began.
+func uniqueCheck(tdef *TableDef, rec Record, mode int, kvtx *KVTX) error {
+	if len(tdef.Unique) == 0 {
+		return nil
+	}
+	key := encodeKey(nil, tdef.Prefix, rec.Vals[:tdef.PKeys])
+	_, exists, err := kvtx.Tree.Get(key)
+	if err != nil {
+		return err
+	}
+	if mode == MODE_INSERT_ONLY && exists || mode == MODE_UPDATE_ONLY && !exists {
+		return nil // not written
+	}
+	for i, ikey := range indexKeys(tdef, rec) {
+		if !indexUnique(tdef, i) || uniqueNull(tdef, i, rec) {
+			continue
+		}
+		prefix := uniquePrefix(tdef, i, rec)
+		if prefixFind(&kvtx.Tree, prefix, func(key []byte) bool { return bytes.Equal(key, ikey) }) != nil {
+			return uniqueError(tdef, i, prefix)
+		}
+		kvtx.unique = append(kvtx.unique, prefix)
+	}
+	return nil
+}
+
+func uniqueError(tdef *TableDef, i int, prefix []byte) *UniqueError {
+	cols := uniqueCols(tdef, i)
+	vals := make([]Value, len(cols))
+	for j, col := range cols {
+		vals[j].Type = tdef.Types[ColIndex(tdef, col)]
+	}
+	decodeValues(prefix[4:], vals)
+	return &UniqueError{Table: tdef.Name, Index: cols, Value: Record{cols, vals}, prefix: prefix}
+}
+
+// the unique values the transaction kept are still not taken by another row
+// once the commits since it began are applied, under the writer lock
+func commitUnique(tx *KVTX, w *kvWriter) error {
+	mine := func(key []byte) bool {
+		if _, ok := tx.writes[string(key)]; ok {
+			return true
+		}
+		_, ok, _ := tx.Tree.Get(key)
+		return ok
+	}
+	for _, prefix := range tx.unique {
+		if prefixFind(&tx.Tree, prefix, nil) == nil {
+			continue // deleted since
+		}
+		if prefixFind(&w.Tree, prefix, mine) != nil {
+			return &UniqueError{prefix: prefix}
+		}
+	}
+	return nil
+}
+
+// name the index & the value of a violation found by the commit
+func uniqueResolve(db *DB, e *UniqueError) {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	tdefs, indexes := prefixDefs(db, &reader.Tree)
+	prefix := binary.BigEndian.Uint32(e.prefix)
+	if i, ok := indexes[prefix]; ok {
+		*e = *uniqueError(tdefs[prefix], i, e.prefix)
+	}
+}
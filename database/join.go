@@ -0,0 +1,214 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+)
+
+// left.Left = right.Right
+type JoinCond struct {
+	Left  string
+	Right string
+}
+
+// the rows of Join, the columns of the left table & then of the right one,
+// each named table.col
+type JoinScan struct {
+	tx        *DBTX // of DBTX.Join, the rows stop once it ended
+	db        *DB
+	tree      *BTree
+	left      *Scanner
+	ltdef     *TableDef
+	rtdef     *TableDef
+	on        []JoinCond // in the order of the probed index
+	leftOuter bool
+	cols      []string
+	probe     Scanner // of the right rows of the left row, reused
+	probing   bool    // the probe is on a right row
+	lrow      Record
+	rrow      Record
+	ok        bool
+	err       error
+}
+
+// the inner join of the rows of the request on the left table with the rows
+// of the right table equal on the columns. each left row probes the right
+// table by its primary key or the index with the right columns first, in any
+// order. with leftOuter, a left row without a right one is joined with NULLs.
+// a NULL never joins.
+//
+// Deprecated: use DBTX.Join or DBReader.Join.
+func (db *DB) Join(left string, right string, on []JoinCond, req *Scanner, leftOuter bool, tree *BTree) (*JoinScan, error) {
+	ltdef := GetTableDef(db, left, tree)
+	if ltdef == nil {
+		return nil, tableNotFound(left)
+	}
+	rtdef := GetTableDef(db, right, tree)
+	if rtdef == nil {
+		return nil, tableNotFound(right)
+	}
+	if len(on) == 0 {
+		return nil, fmt.Errorf("no join columns")
+	}
+	rcols := make([]string, len(on))
+	for i, c := range on {
+		l, r := ColIndex(ltdef, c.Left), ColIndex(rtdef, c.Right)
+		switch {
+		case l < 0:
+			return nil, columnNotFound(ltdef.Name, c.Left)
+		case r < 0:
+			return nil, columnNotFound(rtdef.Name, c.Right)
+		case !sameType(ltdef.Types[l], rtdef.Types[r]):
+			return nil, fmt.Errorf("%w: %s.%s is %s, %s.%s is %s", ErrTypeMismatch,
+				ltdef.Name, c.Left, typeName(ltdef.Types[l]), rtdef.Name, c.Right, typeName(rtdef.Types[r]))
+		case slices.Contains(rcols[:i], c.Right):
+			return nil, fmt.Errorf("the join column %s.%s twice", rtdef.Name, c.Right)
+		}
+		rcols[i] = c.Right
+	}
+	// the right columns in the order of the primary key or an index
+	for _, index := range append([][]string{rtdef.Cols[:rtdef.PKeys]}, rtdef.Indexes...) {
+		if len(index) >= len(rcols) && isSubset(index[:len(rcols)], rcols) {
+			rcols = index[:len(rcols)]
+			break
+		}
+	}
+	if _, err := findIndex(rtdef, rcols); err != nil {
+		return nil, fmt.Errorf("the join columns %v are not a prefix of the primary key or an index of %s", rcols, rtdef.Name)
+	}
+	s := &JoinScan{db: db, tree: tree, left: req, ltdef: ltdef, rtdef: rtdef, leftOuter: leftOuter}
+	for _, col := range rcols {
+		s.on = append(s.on, on[slices.IndexFunc(on, func(c JoinCond) bool { return c.Right == col })])
+	}
+	for _, col := range ltdef.Cols {
+		s.cols = append(s.cols, ltdef.Name+"."+col)
+	}
+	for _, col := range rtdef.Cols {
+		s.cols = append(s.cols, rtdef.Name+"."+col)
+	}
+
+	req.Project = nil
+	if err := dbScan(db, ltdef, req, tree); err != nil {
+		return nil, err
+	}
+	s.load()
+	return s, nil
+}
+
+// the first joined row of the left rows from the current one
+func (s *JoinScan) load() {
+	for s.ok = false; s.left.valid(); s.left.Next() {
+		if err := s.left.deref(&s.lrow, s.tree, true); err != nil {
+			s.err = err
+			return
+		}
+		found, err := s.lookup()
+		if err != nil {
+			s.err = err
+			return
+		}
+		if found || s.leftOuter {
+			s.ok = true
+			return
+		}
+	}
+	s.err = s.left.iterErr()
+}
+
+// probe the right table for the first row of the left row, NULLs if none
+func (s *JoinScan) lookup() (bool, error) {
+	s.probing = false
+	key := Record{}
+	for _, c := range s.on {
+		v := *s.lrow.Get(c.Left)
+		cond := Cond{Col: c.Right, Cmp: CMP_EQ, Val: v}
+		if v.Null || !keyable(s.rtdef, cond) {
+			return s.nulls(), nil
+		}
+		key.Cols, key.Vals = append(key.Cols, c.Right), append(key.Vals, keyValue(s.rtdef, cond))
+	}
+	s.probe = Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key, Key2: key}
+	if err := dbScan(s.db, s.rtdef, &s.probe, s.tree); err != nil {
+		return false, err
+	}
+	return s.match()
+}
+
+// the right row of the probe, NULLs at its end
+func (s *JoinScan) match() (bool, error) {
+	if !s.probe.valid() {
+		return s.nulls(), s.probe.iterErr()
+	}
+	if err := s.probe.deref(&s.rrow, s.tree, true); err != nil {
+		return false, err
+	}
+	s.probing = true
+	return true, nil
+}
+
+func (s *JoinScan) nulls() bool {
+	s.rrow.Cols, s.rrow.Vals = s.rtdef.Cols, s.rrow.Vals[:0]
+	for _, typ := range s.rtdef.Types {
+		s.rrow.Vals = append(s.rrow.Vals, Value{Type: typ, Null: true})
+	}
+	return false
+}
+
+func (s *JoinScan) Valid() bool {
+	if s.tx != nil {
+		if s.tx.enter() != nil {
+			return false
+		}
+		defer s.tx.mu.Unlock()
+	}
+	return s.ok && s.err == nil
+}
+
+func (s *JoinScan) Next() {
+	if s.tx != nil {
+		if s.tx.enter() != nil {
+			return
+		}
+		defer s.tx.mu.Unlock()
+	}
+	if !s.ok || s.err != nil {
+		return
+	}
+	if s.probing {
+		// the next right row of the left row
+		s.probe.Next()
+		found, err := s.match()
+		if err != nil || found {
+			s.err = err
+			return
+		}
+	}
+	s.left.Next()
+	s.load()
+}
+
+// the current joined row
+func (s *JoinScan) Deref(rec *Record) error {
+	if s.tx != nil {
+		if err := s.tx.enter(); err != nil {
+			return err
+		}
+		defer s.tx.mu.Unlock()
+	}
+	if !s.ok || s.err != nil {
+		return s.err
+	}
+	rec.Cols = s.cols
+	rec.Vals = append(append(rec.Vals[:0], s.lrow.Vals...), s.rrow.Vals...)
+	return nil
+}
+
+// the reason the rows stopped early, nil when they ran out
+func (s *JoinScan) Err() error {
+	if s.tx != nil {
+		if err := s.tx.Err(); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	for _, tdef := range []*TableDef{
+		{
+			Name:    "orders",
+			Types:   []uint32{TYPE_INT64, TYPE_INT32, TYPE_INT64},
+			Cols:    []string{"id", "code", "amount"},
+			PKeys:   1,
+			Indexes: [][]string{{"code"}},
+		},
+		{
+			Name:    "customers",
+			Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+			Cols:    []string{"id", "code", "name"},
+			PKeys:   1,
+			Indexes: [][]string{{"code"}},
+			Unique:  []bool{true},
+		},
+	} {
+		if err := tx.TableNew(tdef); err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+	for id := int64(1); id <= 100; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddInt64("code", 1000+id).AddStr("name", []byte(fmt.Sprintf("c%d", id)))
+		if _, err := tx.Set("customers", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	// the codes 1101 to 1105 have no customer, the order 1 has no code
+	for id := int64(1); id <= 10_000; id++ {
+		rec := (&Record{}).AddInt64("id", id)
+		if id == 1 {
+			rec.AddNull("code", TYPE_INT32)
+		} else {
+			rec.AddInt32("code", int32(1001+id%105))
+		}
+		rec.AddInt64("amount", id)
+		if _, err := tx.Set("orders", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	// the rows of the join, checked by the function
+	join := func(left, right string, on []JoinCond, sc Scanner, outer bool, check func(rec Record) error) int {
+		t.Helper()
+		rows, err := reader.Join(left, right, on, &sc, outer)
+		if err != nil {
+			t.Fatalf("failed to join: %v", err)
+		}
+		n := 0
+		rec := Record{}
+		for ; rows.Valid(); rows.Next() {
+			if err := rows.Deref(&rec); err != nil {
+				t.Fatalf("failed to deref: %v", err)
+			}
+			if err := check(rec); err != nil {
+				t.Fatalf("row %d: %v", n, err)
+			}
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return n
+	}
+	byCode := []JoinCond{{Left: "code", Right: "code"}}
+	matched := func(rec Record) error {
+		code := rec.Get("orders.code")
+		if name := rec.Get("customers.name"); string(name.Str) != fmt.Sprintf("c%d", code.I64-1000) {
+			return fmt.Errorf("order %d of code %d joined with %s", rec.Get("orders.id").I64, code.I64, name.Str)
+		}
+		return nil
+	}
+
+	// 1 + 5 of each 105 orders have no customer
+	unmatched := 1
+	for id := 2; id <= 10_000; id++ {
+		if 1001+id%105 > 1100 {
+			unmatched++
+		}
+	}
+	if n := join("orders", "customers", byCode, Scanner{}, false, matched); n != 10_000-unmatched {
+		t.Errorf("expected %d rows of the inner join, got %d", 10_000-unmatched, n)
+	}
+	outer := 0
+	n := join("orders", "customers", byCode, Scanner{}, true, func(rec Record) error {
+		if rec.Get("customers.id").Null {
+			outer++
+			return nil
+		}
+		return matched(rec)
+	})
+	if n != 10_000 || outer != unmatched {
+		t.Errorf("expected 10000 rows with %d NULLs, got %d with %d", unmatched, n, outer)
+	}
+
+	// the range of the left table, each customer with its orders by the index
+	want := map[int64]int{}
+	for id := int64(2); id <= 10_000; id++ {
+		if code := 1001 + id%105; code <= 1003 {
+			want[code]++
+		}
+	}
+	counts := map[int64]int{}
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("id", 1), Key2: *(&Record{}).AddInt64("id", 3)}
+	join("customers", "orders", byCode, sc, false, func(rec Record) error {
+		if rec.Get("orders.code").I64 != rec.Get("customers.code").I64 {
+			return fmt.Errorf("order %d of another code", rec.Get("orders.id").I64)
+		}
+		counts[rec.Get("customers.code").I64]++
+		return nil
+	})
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("expected the orders %v, got %v", want, counts)
+	}
+
+	// by the primary key
+	sc = Scanner{Filter: CompareExpr(ColumnExpr("id"), CMP_LE, LiteralExpr(Value{Type: TYPE_INT64, I64: 150}))}
+	n = join("orders", "customers", []JoinCond{{Left: "amount", Right: "id"}}, sc, false, func(rec Record) error {
+		if rec.Get("orders.amount").I64 != rec.Get("customers.id").I64 {
+			return fmt.Errorf("order %d joined with the customer %d", rec.Get("orders.id").I64, rec.Get("customers.id").I64)
+		}
+		return nil
+	})
+	if n != 100 {
+		t.Errorf("expected 100 rows by the primary key, got %d", n)
+	}
+
+	for _, on := range [][]JoinCond{nil, {{Left: "code", Right: "name"}}, {{Left: "code", Right: "code"}, {Left: "amount", Right: "code"}}} {
+		if _, err := reader.Join("orders", "customers", on, &Scanner{}, false); err == nil {
+			t.Errorf("%v: expected an error", on)
+		}
+	}
+	if _, err := reader.Join("orders", "customers", []JoinCond{{Left: "amount", Right: "phone"}}, &Scanner{}, false); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	// no index of orders starts with amount
+	if _, err := reader.Join("customers", "orders", []JoinCond{{Left: "id", Right: "amount"}}, &Scanner{}, false); err == nil {
+		t.Errorf("expected an error for a join column without an index")
+	}
+}
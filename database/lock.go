@@ -0,0 +1,33 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrLocked error = errors.New("database is locked by another process")
+
+// the interval between the attempts while waiting for a lock
+const LOCK_RETRY = 10 * time.Millisecond
+
+// lock the file against the other processes, exclusive for a writer &
+// shared for the read-only opens. the lock goes with the file descriptor,
+// it is released when the file is closed or the process dies.
+// waits up to KV.LockTimeout for the lock.
+func fileLock(db *KV) error {
+	deadline := time.Now().Add(db.LockTimeout)
+	for {
+		err := lockFile(db.fp.Fd(), !db.ReadOnly)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrLocked) {
+			return fmt.Errorf("lock: %w", err)
+		}
+		if !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(LOCK_RETRY)
+	}
+}
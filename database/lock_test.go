@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run by the child process of TestFileLock: opens the DB & exits
+func TestFileLockChild(t *testing.T) {
+	path := os.Getenv("ATOMIXDB_LOCK_PATH")
+	if path == "" {
+		t.Skip("only run by TestFileLock")
+	}
+	kv := KV{Path: path, ReadOnly: os.Getenv("ATOMIXDB_LOCK_READONLY") != ""}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	kv.Close()
+}
+
+// opens the DB in another process, returns its output on failure
+func openProcess(t *testing.T, path string, readOnly bool) (string, bool) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileLockChild$")
+	cmd.Env = append(os.Environ(), "ATOMIXDB_LOCK_PATH="+path)
+	if readOnly {
+		cmd.Env = append(cmd.Env, "ATOMIXDB_LOCK_READONLY=1")
+	}
+	out, err := cmd.CombinedOutput()
+	return string(out), err == nil
+}
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+	writer := KV{Path: path}
+	if err := writer.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, readOnly := range []bool{false, true} {
+		out, ok := openProcess(t, path, readOnly)
+		if ok || !strings.Contains(out, "database is locked by another process") {
+			t.Errorf("read-only %v: expected the open to fail on the lock, got %v %q", readOnly, ok, out)
+		}
+	}
+	writer.Close()
+
+	// readers share the file
+	reader := KV{Path: path, ReadOnly: true}
+	if err := reader.Open(); err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	if out, ok := openProcess(t, path, true); !ok {
+		t.Errorf("expected a second reader to open the file, got %q", out)
+	}
+	if out, ok := openProcess(t, path, false); ok {
+		t.Errorf("expected a writer not to open the file, got %q", out)
+	}
+	reader.Close()
+	if out, ok := openProcess(t, path, false); !ok {
+		t.Errorf("expected the lock to be released on close, got %q", out)
+	}
+}
+
+func TestFileLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+	holder := KV{Path: path}
+	if err := holder.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	kv := KV{Path: path, LockTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	if err := kv.Open(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if waited := time.Since(start); waited < kv.LockTimeout {
+		t.Errorf("expected to wait for the lock, waited %v", waited)
+	}
+
+	// the lock is taken once the holder closes
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		holder.Close()
+	}()
+	kv.LockTimeout = 5 * time.Second
+	if err := kv.Open(); err != nil {
+		t.Fatalf("expected to get the lock after the close, got %v", err)
+	}
+	kv.Close()
+}
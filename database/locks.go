@@ -0,0 +1,304 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLockTimeout error = errors.New("row lock wait timeout")
+	ErrDeadlock    error = errors.New("deadlock")
+)
+
+const ROW_LOCK_TIMEOUT = 5 * time.Second
+
+// the exclusive row locks of GetForUpdate, held until the transaction ends.
+// the writes of the other transactions wait for them without taking them.
+type lockTable struct {
+	mu     sync.Mutex
+	owners map[string]*rowLock // by the row key
+	waits  map[*DBTX]*DBTX     // the wait-for graph, for the deadlock check
+}
+
+type rowLock struct {
+	owner *DBTX
+	free  chan struct{} // closed once released
+}
+
+// read a row & lock it until the transaction ends, the other transactions
+// writing or locking the row wait for that. a missing row is locked too.
+// like a locking read of InnoDB, the row is the latest committed one, not the
+// one of the snapshot. fails with ErrConflict if the transaction wrote the row
+// before a later commit did, with ErrLockTimeout or ErrDeadlock if the lock is
+// not taken. the transaction should be aborted & retried then.
+func (db *DB) GetForUpdate(table string, rec *Record, tx *DBTX) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	tdef := GetTableDef(db, table, &tx.kv.Tree)
+	if tdef == nil {
+		return false, tableNotFound(table)
+	}
+	key, err := rowKey(tdef, *rec)
+	if err != nil {
+		return false, err
+	}
+	if err := db.locks.acquire(tx, key, true); err != nil {
+		return false, err
+	}
+	if commitChanged(&db.kv, &tx.kv, key) {
+		if _, ok := tx.kv.writes[string(key)]; ok {
+			conflict := &ConflictError{Key: key, keys: [][]byte{key}}
+			conflictResolve(db, conflict)
+			return false, conflict
+		}
+		if err := lockRefresh(db, tdef, &tx.kv, key); err != nil {
+			return false, err
+		}
+	}
+	return dbGet(db, tdef, rec, &tx.kv.Tree)
+}
+
+// change the columns of update of the row with the primary key of expect if
+// its columns have the values of expect, in a transaction of its own. the row
+// is locked by GetForUpdate while it is compared & written. false without an
+// error on a mismatch or a missing row, the caller can reload & retry.
+func (db *DB) CompareAndSet(table string, expect Record, update Record) (bool, error) {
+	for {
+		var tx DBTX
+		db.Begin(&tx)
+		ok, err := compareAndSet(db, table, expect, update, &tx)
+		if err != nil || !ok {
+			db.Abort(&tx)
+			return false, err
+		}
+		// a write made before the row was locked
+		if err := db.Commit(&tx); !errors.Is(err, ErrConflict) {
+			return err == nil, err
+		}
+	}
+}
+
+func compareAndSet(db *DB, table string, expect Record, update Record, tx *DBTX) (bool, error) {
+	tdef := GetTableDef(db, table, &tx.kv.Tree)
+	if tdef == nil {
+		return false, tableNotFound(table)
+	}
+	for _, cols := range [][]string{expect.Cols, update.Cols} {
+		for _, col := range cols {
+			if ColIndex(tdef, col) < 0 {
+				return false, columnNotFound(tdef.Name, col)
+			}
+		}
+	}
+	pkey := Record{}
+	for _, col := range tdef.Cols[:tdef.PKeys] {
+		v := expect.Get(col)
+		if v == nil {
+			return false, fmt.Errorf("missing primary key column: %s", col)
+		}
+		pkey.Cols = append(pkey.Cols, col)
+		pkey.Vals = append(pkey.Vals, *v)
+	}
+	row := Record{Cols: pkey.Cols, Vals: append([]Value{}, pkey.Vals...)}
+	if ok, err := db.GetForUpdate(table, &row, tx); err != nil || !ok {
+		return false, err
+	}
+	for i, col := range expect.Cols {
+		if !compareValues(*row.Get(col), expect.Vals[i]) {
+			return false, nil
+		}
+	}
+	for i, col := range update.Cols {
+		if ColIndex(tdef, col) >= tdef.PKeys {
+			pkey.Cols = append(pkey.Cols, col)
+			pkey.Vals = append(pkey.Vals, update.Vals[i])
+		} else if !compareValues(*row.Get(col), update.Vals[i]) {
+			return false, fmt.Errorf("cannot change the primary key column: %s", col)
+		}
+	}
+	return true, tx.UpdatePartial(table, pkey)
+}
+
+// replace the row of the snapshot & its index entries with the latest
+// committed ones, under the row lock. they are not writes, their conflict
+// check starts from the version read.
+func lockRefresh(db *DB, tdef *TableDef, tx *KVTX, key []byte) error {
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	keys := [][]byte{key}
+	old, ok, err := tx.Tree.Get(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		ikeys := indexKeys(tdef, rowDecode(tdef, key, old))
+		for _, ikey := range ikeys {
+			tx.Tree.Delete(ikey)
+		}
+		tx.Tree.Delete(key)
+		keys = append(keys, ikeys...)
+	}
+	val, ok, err := reader.Tree.Get(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := tx.Tree.Insert(key, val); err != nil {
+			return err
+		}
+		ikeys := indexKeys(tdef, rowDecode(tdef, key, val))
+		for _, ikey := range ikeys {
+			if err := tx.Tree.Insert(ikey, nil); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, ikeys...)
+	}
+	if tx.refreshed == nil {
+		tx.refreshed = map[string]uint64{}
+	}
+	for _, key := range keys {
+		tx.refreshed[string(key)] = reader.version
+	}
+	return nil
+}
+
+// wait for the locks of the other transactions on the rows a write changes
+func (tx *DBTX) lockWait(table string, recs []Record) error {
+	tdef := GetTableDef(tx.db, table, &tx.kv.Tree)
+	if tdef == nil {
+		return nil // fails in the write
+	}
+	for _, rec := range recs {
+		key, err := rowKey(tdef, rec)
+		if err != nil {
+			return nil
+		}
+		if err := tx.db.locks.acquire(tx, key, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the rows deleted by DeleteRange, from the same scan
+func (tx *DBTX) lockWaitRange(table string, req *Scanner) error {
+	tdef := GetTableDef(tx.db, table, &tx.kv.Tree)
+	if tdef == nil {
+		return nil
+	}
+	sc := Scanner{
+		Cmp1: req.Cmp1, Cmp2: req.Cmp2, Key1: req.Key1, Key2: req.Key2,
+		Limit: req.Limit, Desc: req.Desc, StartAfter: req.StartAfter,
+	}
+	if err := dbScan(tx.db, tdef, &sc, &tx.kv.Tree); err != nil {
+		return nil
+	}
+	var recs []Record
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &tx.kv.Tree); err != nil {
+			return nil
+		}
+		recs = append(recs, rec)
+	}
+	return tx.lockWait(table, recs)
+}
+
+// the row of a key & a value of the table
+func rowDecode(tdef *TableDef, key []byte, val []byte) Record {
+	values := make([]Value, len(tdef.Cols))
+	for i := range values {
+		values[i].Type = tdef.Types[i]
+	}
+	decodeValues(key[4:], values[:tdef.PKeys])
+	decodeRow(tdef, val, values[tdef.PKeys:])
+	return Record{tdef.Cols, values}
+}
+
+func rowKey(tdef *TableDef, rec Record) ([]byte, error) {
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return nil, err
+	}
+	return encodeKey(nil, tdef.Prefix, values[:tdef.PKeys]), nil
+}
+
+// wait until no other transaction holds the lock on the key, then take it if lock.
+// a wait that would close a cycle in the wait-for graph fails at once.
+func (lt *lockTable) acquire(tx *DBTX, key []byte, lock bool) error {
+	timeout := tx.db.LockTimeout
+	if timeout <= 0 {
+		timeout = ROW_LOCK_TIMEOUT
+	}
+	var expired <-chan time.Time
+	var done <-chan struct{}
+	if tx.ctx != nil {
+		done = tx.ctx.Done()
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for {
+		l := lt.owners[string(key)]
+		if l == nil {
+			break
+		}
+		if l.owner == tx {
+			return nil
+		}
+		for t := l.owner; t != nil; t = lt.waits[t] {
+			if t == tx {
+				return fmt.Errorf("%w: the row is locked by a transaction waiting for this one", ErrDeadlock)
+			}
+		}
+		if expired == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		if lt.waits == nil {
+			lt.waits = map[*DBTX]*DBTX{}
+		}
+		lt.waits[tx] = l.owner
+		lt.mu.Unlock()
+		var err error
+		select {
+		case <-l.free:
+		case <-expired:
+			err = fmt.Errorf("%w after %v", ErrLockTimeout, timeout)
+		case <-done:
+			err = fmt.Errorf("%w: %w", ErrTxDone, context.Cause(tx.ctx))
+		}
+		lt.mu.Lock()
+		delete(lt.waits, tx)
+		if err != nil {
+			return err
+		}
+	}
+	if lock {
+		if lt.owners == nil {
+			lt.owners = map[string]*rowLock{}
+		}
+		lt.owners[string(key)] = &rowLock{owner: tx, free: make(chan struct{})}
+		tx.locks = append(tx.locks, string(key))
+	}
+	return nil
+}
+
+// at the end of the transaction
+func (lt *lockTable) release(tx *DBTX) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, key := range tx.locks {
+		close(lt.owners[key].free)
+		delete(lt.owners, key)
+	}
+	tx.locks = nil
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// read-modify-write under the row lock, no commit conflicts
+func TestGetForUpdate(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	insertTestRecord(t, db, 1)
+	var reader DBReader
+	db.BeginRead(&reader)
+	GetTableDef(db, "users", &reader.kv.Tree) // cached before the goroutines
+	db.EndRead(&reader)
+
+	const workers, appends = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < appends; i++ {
+				var tx DBTX
+				db.Begin(&tx)
+				rec := (&Record{}).AddInt64("id", 1)
+				_, err := db.GetForUpdate("users", rec, &tx)
+				if err == nil {
+					name := string(rec.Get("name").Str) + "x"
+					_, err = tx.Set("users", userRecord(1, name), MODE_UPDATE_ONLY)
+				}
+				if err != nil {
+					db.Abort(&tx)
+					errs <- err
+					return
+				}
+				if err := db.Commit(&tx); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.BeginRead(&reader)
+	rec := (&Record{}).AddInt64("id", 1)
+	if ok, err := reader.Get("users", rec); !ok || err != nil {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	db.EndRead(&reader)
+	if got, want := len(rec.Get("name").Str), len("John")+workers*appends; got != want {
+		t.Errorf("expected %d bytes after the appends, got %d", want, got)
+	}
+}
+
+func TestRowLockWait(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	for id := int64(1); id <= 3; id++ {
+		insertTestRecord(t, db, id)
+	}
+	db.LockTimeout = 20 * time.Millisecond
+	lock := func(tx *DBTX, id int64) error {
+		_, err := db.GetForUpdate("users", (&Record{}).AddInt64("id", id), tx)
+		return err
+	}
+
+	// the writes & the locks of the others time out
+	var tx1, tx2 DBTX
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	if err := lock(&tx1, 1); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if err := lock(&tx1, 1); err != nil {
+		t.Errorf("expected a lock to be taken again by its owner, got %v", err)
+	}
+	if _, err := tx2.Set("users", userRecord(1, "other"), MODE_UPDATE_ONLY); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got %v", err)
+	}
+	if _, err := tx2.Delete("users", *(&Record{}).AddInt64("id", 1)); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got %v", err)
+	}
+	if err := lock(&tx2, 1); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got %v", err)
+	}
+	if _, err := tx2.Set("users", userRecord(2, "other"), MODE_UPDATE_ONLY); err != nil {
+		t.Errorf("expected another row to be written, got %v", err)
+	}
+
+	// a waiting write goes on once the lock is released
+	db.LockTimeout = 0
+	written := make(chan error)
+	go func() {
+		_, err := tx2.Set("users", userRecord(1, "other"), MODE_UPDATE_ONLY)
+		written <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	db.Abort(&tx1)
+	if err := <-written; err != nil {
+		t.Errorf("expected the write after the abort, got %v", err)
+	}
+	if err := db.Commit(&tx2); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// a wait-for cycle
+	db.Begin(&tx1)
+	db.Begin(&tx2)
+	if err := lock(&tx1, 1); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if err := lock(&tx2, 2); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	locked := make(chan error)
+	go func() { locked <- lock(&tx1, 2) }()
+	for waiting := false; !waiting; {
+		time.Sleep(time.Millisecond)
+		db.locks.mu.Lock()
+		waiting = db.locks.waits[&tx1] == &tx2
+		db.locks.mu.Unlock()
+	}
+	if err := lock(&tx2, 1); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("expected ErrDeadlock, got %v", err)
+	}
+	db.Abort(&tx2)
+	if err := <-locked; err != nil {
+		t.Errorf("expected the lock after the abort, got %v", err)
+	}
+	db.Abort(&tx1)
+
+	// the locks of a transaction aborted by its context are released
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := db.BeginTx(ctx, &tx1); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := lock(&tx1, 3); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	cancel()
+	for tx1.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	db.LockTimeout = 20 * time.Millisecond
+	db.Begin(&tx2)
+	if err := lock(&tx2, 3); err != nil {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+	db.Abort(&tx2)
+}
+
+// the increments of a counter by CompareAndSet, reloaded on a mismatch
+func TestCompareAndSet(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "counters",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "n", "note"},
+		PKeys:   1,
+		Indexes: [][]string{{"n"}},
+	})
+	if err == nil {
+		_, err = tx.Set("counters", *(&Record{}).AddInt64("id", 1).AddInt64("n", 0).AddStr("note", []byte("c")), MODE_INSERT_ONLY)
+	}
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	load := func() int64 {
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		rec := (&Record{}).AddInt64("id", 1)
+		if ok, err := reader.Get("counters", rec); !ok || err != nil {
+			t.Errorf("get: %v %v", ok, err)
+			return 0
+		}
+		return rec.Get("n").I64
+	}
+
+	const workers, increments = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				n := load()
+				ok, err := db.CompareAndSet("counters",
+					*(&Record{}).AddInt64("id", 1).AddInt64("n", n),
+					*(&Record{}).AddInt64("n", n+1))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if ok {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := load(); n != workers*increments {
+		t.Errorf("expected %d after the increments, got %d", workers*increments, n)
+	}
+
+	// a mismatch & a missing row change nothing
+	for _, expect := range []*Record{
+		(&Record{}).AddInt64("id", 1).AddInt64("n", 0),
+		(&Record{}).AddInt64("id", 1).AddStr("note", []byte("x")),
+		(&Record{}).AddInt64("id", 2),
+	} {
+		if ok, err := db.CompareAndSet("counters", *expect, *(&Record{}).AddInt64("n", -1)); ok || err != nil {
+			t.Errorf("expected no change for %s, got %v %v", formatRecord(*expect), ok, err)
+		}
+	}
+	if _, err := db.CompareAndSet("counters", *(&Record{}).AddInt64("n", 0), Record{}); err == nil {
+		t.Errorf("expected an error without the primary key")
+	}
+	if _, err := db.CompareAndSet("counters", *(&Record{}).AddInt64("id", 1), *(&Record{}).AddInt64("id", 2)); err == nil {
+		t.Errorf("expected an error for a change of the primary key")
+	}
+
+	// a single index entry, with the latest value
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: Record{Cols: []string{"n"}}, Key2: Record{Cols: []string{"n"}}}
+	if err := reader.Scan("counters", &sc); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var got []string
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+			t.Fatalf("deref: %v", err)
+		}
+		got = append(got, formatRecord(rec))
+	}
+	if want := fmt.Sprintf(`id=1 n=%d note="c"`, workers*increments); len(got) != 1 || got[0] != want {
+		t.Errorf("expected [%s] in the index, got %v", want, got)
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the diagnostics of the DB, e.g. a log replayed on open or a slow
+// operation, with the pairs of keys & values after the message. set by
+// WithLogger, nothing is logged by default & the library never prints.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// a Logger writing a line per message to w, as `WARN slow operation op=get elapsed=3ms`
+type TextLogger struct {
+	mu      sync.Mutex
+	w       io.Writer
+	Verbose bool // the Debug messages are written too
+}
+
+func NewTextLogger(w io.Writer) *TextLogger {
+	return &TextLogger{w: w}
+}
+
+func (l *TextLogger) log(level, msg string, kv []any) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", kv[i])
+		}
+	}
+	b.WriteByte('\n')
+	l.mu.Lock()
+	io.WriteString(l.w, b.String())
+	l.mu.Unlock()
+}
+
+func (l *TextLogger) Debug(msg string, kv ...any) {
+	if l.Verbose {
+		l.log("DEBUG", msg, kv)
+	}
+}
+
+func (l *TextLogger) Info(msg string, kv ...any)  { l.log("INFO", msg, kv) }
+func (l *TextLogger) Warn(msg string, kv ...any)  { l.log("WARN", msg, kv) }
+func (l *TextLogger) Error(msg string, kv ...any) { l.log("ERROR", msg, kv) }
+
+// the Logger of the KV, a no-op without one
+func (db *KV) logger() Logger {
+	if db.Logger == nil {
+		return nopLogger{}
+	}
+	return db.Logger
+}
+
+func (db *DB) logger() Logger {
+	return db.kv.logger()
+}
+
+// warn of an operation slower than DB.SlowThreshold
+func (db *DB) slowCheck(op string, elapsed time.Duration) {
+	if db.SlowThreshold > 0 && elapsed >= db.SlowThreshold {
+		db.logger().Warn("slow operation", "op", op, "elapsed", elapsed)
+	}
+}
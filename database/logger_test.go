@@ -0,0 +1,76 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var logged bytes.Buffer
+	path := filepath.Join(t.TempDir(), "log.db")
+	stdout, stderr := captureOutput(t, func() {
+		db, err := Open(path, WithLogger(NewTextLogger(&logged)), WithSlowThreshold(1))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		err = db.Transact(func(tx *DBTX) error {
+			tdef := &TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64}, Cols: []string{"id", "name", "qty"}, PKeys: 1}
+			if err := tx.TableNew(tdef); err != nil {
+				return err
+			}
+			for i := int64(0); i < 5; i++ {
+				if _, err := tx.Set("items", *(&Record{}).AddInt64("id", i).AddStr("name", []byte{'a' + byte(i)}).AddInt64("qty", i), MODE_INSERT_ONLY); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		err = db.Transact(func(tx *DBTX) error {
+			sc, err := tx.ScanAll("items")
+			for ; err == nil && sc.Valid(); sc.Next() {
+				rec := Record{}
+				err = sc.Read(&rec)
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Delete("items", *(&Record{}).AddInt64("id", 2))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	})
+	if stdout != "" || stderr != "" {
+		t.Errorf("expected nothing printed, got %q %q", stdout, stderr)
+	}
+	if !strings.Contains(logged.String(), "WARN slow operation op=insert elapsed=") {
+		t.Errorf("expected the slow operations logged, got %q", logged.String())
+	}
+
+	// the torn record of a commit that never finished is dropped with a warning
+	fp, err := os.OpenFile(walPath(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	fp.Write([]byte{0, 1, 0, 0, 1, 2, 3})
+	fp.Close()
+	logged.Reset()
+	db, err := Open(path, WithLogger(NewTextLogger(&logged)))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	db.Close()
+	if !strings.HasPrefix(logged.String(), "WARN dropped a torn record at the end of the log path=") {
+		t.Errorf("expected the torn record logged, got %q", logged.String())
+	}
+}
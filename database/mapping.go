@@ -0,0 +1,392 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// the rows of a table as Go structs. a field is mapped to the column of its
+// `atomix:"col"` tag, or of its name in any case without one. `atomix:"-"`
+// skips the field. a nil pointer field is NULL.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type structField struct {
+	name  string // of the field, for the errors
+	index []int
+	col   int
+}
+
+// the fields of the struct type mapped to the columns of the table
+func structFields(tdef *TableDef, typ reflect.Type) ([]structField, error) {
+	var fields []structField
+	mapped := map[int]string{}
+	for _, f := range reflect.VisibleFields(typ) {
+		tag, tagged := f.Tag.Lookup("atomix")
+		if !f.IsExported() || tag == "-" || (f.Anonymous && !tagged && indirect(f.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		col := -1
+		for i, name := range tdef.Cols {
+			if (tagged && name == tag) || (!tagged && strings.EqualFold(name, f.Name)) {
+				col = i
+				break
+			}
+		}
+		if !tagged {
+			tag = f.Name
+		}
+		if col < 0 {
+			return nil, fmt.Errorf("field %s: %w", f.Name, columnNotFound(tdef.Name, tag))
+		}
+		if other, ok := mapped[col]; ok {
+			return nil, fmt.Errorf("field %s: column %s is mapped by the field %s", f.Name, tdef.Cols[col], other)
+		}
+		if !structKind(f.Type, tdef.Types[col]) {
+			return nil, fmt.Errorf("field %s: a %s does not map to the %s column %s", f.Name, f.Type, typeName(tdef.Types[col]), tdef.Cols[col])
+		}
+		mapped[col] = f.Name
+		fields = append(fields, structField{name: f.Name, index: f.Index, col: col})
+	}
+	return fields, nil
+}
+
+func indirect(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// the Go types of a column type, or pointers to them
+func structKind(typ reflect.Type, col uint32) bool {
+	typ = indirect(typ)
+	switch col {
+	case TYPE_INT64, TYPE_INT32:
+		return typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64
+	case TYPE_BYTES:
+		return typ.Kind() == reflect.String || (typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8)
+	case TYPE_FLOAT64:
+		return typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64
+	case TYPE_BOOL:
+		return typ.Kind() == reflect.Bool
+	case TYPE_TIME:
+		return typ == timeType
+	default:
+		return false
+	}
+}
+
+// the struct or the struct a pointer points to
+func structOf(v any) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("expected a struct, got %T", v)
+	}
+	return rv, nil
+}
+
+// the value of a field for a column of the type
+func structValue(fv reflect.Value, typ uint32) (Value, error) {
+	for fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return Value{Type: typ, Null: true}, nil
+		}
+		fv = fv.Elem()
+	}
+	v := Value{Type: typ}
+	switch typ {
+	case TYPE_INT64, TYPE_INT32:
+		if fv.CanUint() {
+			if fv.Uint() > math.MaxInt64 {
+				return Value{}, fmt.Errorf("%d overflows a %s column", fv.Uint(), typeName(typ))
+			}
+			v.I64 = int64(fv.Uint())
+		} else {
+			v.I64 = fv.Int()
+		}
+		if typ == TYPE_INT32 && v.I64 != int64(int32(v.I64)) {
+			return Value{}, fmt.Errorf("%d overflows a %s column", v.I64, typeName(typ))
+		}
+	case TYPE_BYTES:
+		if fv.Kind() == reflect.String {
+			v.Str = []byte(fv.String())
+		} else {
+			v.Str = fv.Bytes()
+		}
+	case TYPE_FLOAT64:
+		v.F64 = fv.Float()
+	case TYPE_BOOL:
+		if fv.Bool() {
+			v.I64 = 1
+		}
+	case TYPE_TIME:
+		v.I64 = fv.Interface().(time.Time).UnixNano()
+	}
+	return v, nil
+}
+
+// set a field from the value of its column, NULL is the zero value
+func structSet(fv reflect.Value, v Value) error {
+	if v.Null {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	for fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+	switch v.Type {
+	case TYPE_INT64, TYPE_INT32:
+		if fv.CanUint() {
+			if v.I64 < 0 || fv.OverflowUint(uint64(v.I64)) {
+				return fmt.Errorf("%d overflows a %s", v.I64, fv.Type())
+			}
+			fv.SetUint(uint64(v.I64))
+		} else {
+			if fv.OverflowInt(v.I64) {
+				return fmt.Errorf("%d overflows a %s", v.I64, fv.Type())
+			}
+			fv.SetInt(v.I64)
+		}
+	case TYPE_BYTES:
+		if fv.Kind() == reflect.String {
+			fv.SetString(string(v.Str))
+		} else {
+			fv.SetBytes(append([]byte(nil), v.Str...))
+		}
+	case TYPE_FLOAT64:
+		fv.SetFloat(v.F64)
+	case TYPE_BOOL:
+		fv.SetBool(v.I64 != 0)
+	case TYPE_TIME:
+		fv.Set(reflect.ValueOf(v.Time()))
+	}
+	return nil
+}
+
+// the record of the mapped fields of a struct. a column without a field
+// gets its default, the zero AUTO_INCREMENT key is assigned on insert.
+func structRecord(tdef *TableDef, rv reflect.Value) (Record, error) {
+	fields, err := structFields(tdef, rv.Type())
+	if err != nil {
+		return Record{}, err
+	}
+	rec := Record{}
+	mapped := make([]bool, len(tdef.Cols))
+	for _, f := range fields {
+		mapped[f.col] = true
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			return Record{}, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		col := tdef.Cols[f.col]
+		if f.col < tdef.PKeys && tdef.AutoIncrement && fv.IsZero() {
+			continue
+		}
+		v, err := structValue(fv, tdef.Types[f.col])
+		if err != nil {
+			return Record{}, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		notNull := f.col < tdef.PKeys || (f.col < len(tdef.NotNull) && tdef.NotNull[f.col])
+		if v.Null && notNull {
+			return Record{}, fmt.Errorf("%w: field %s of the column %s is nil", ErrNotNull, f.name, col)
+		}
+		rec.Cols = append(rec.Cols, col)
+		rec.Vals = append(rec.Vals, v)
+	}
+	for i, col := range tdef.Cols {
+		switch {
+		case mapped[i]:
+		case i < tdef.PKeys && !tdef.AutoIncrement:
+			return Record{}, fmt.Errorf("%s has no field for the primary key column %s", rv.Type(), col)
+		case i < len(tdef.NotNull) && tdef.NotNull[i] && (i >= len(tdef.Defaults) || tdef.Defaults[i].Type == 0):
+			return Record{}, fmt.Errorf("%w: %s has no field for the column %s", ErrNotNull, rv.Type(), col)
+		}
+	}
+	return rec, nil
+}
+
+// fill the mapped fields of a struct from the columns of the record
+func structFill(tdef *TableDef, rec Record, rv reflect.Value) error {
+	fields, err := structFields(tdef, rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		v := rec.Get(tdef.Cols[f.col])
+		if v == nil {
+			continue // not projected
+		}
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.name, err)
+		}
+		if err := structSet(fv, *v); err != nil {
+			return fmt.Errorf("field %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// the primary key of a row: a Record, a struct with the key fields, or the
+// key value of a table with a single key column
+func structKey(tdef *TableDef, key any) (Record, error) {
+	// a copy, dbGet reuses the values
+	switch k := key.(type) {
+	case Record:
+		return Record{Cols: k.Cols, Vals: append([]Value(nil), k.Vals...)}, nil
+	case *Record:
+		return Record{Cols: k.Cols, Vals: append([]Value(nil), k.Vals...)}, nil
+	}
+	if rv, err := structOf(key); err == nil {
+		fields, err := structFields(tdef, rv.Type())
+		if err != nil {
+			return Record{}, err
+		}
+		rec := Record{}
+		for _, f := range fields {
+			if f.col >= tdef.PKeys {
+				continue
+			}
+			fv, err := rv.FieldByIndexErr(f.index)
+			if err != nil {
+				return Record{}, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			v, err := structValue(fv, tdef.Types[f.col])
+			if err != nil {
+				return Record{}, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			rec.Cols = append(rec.Cols, tdef.Cols[f.col])
+			rec.Vals = append(rec.Vals, v)
+		}
+		return rec, nil
+	}
+	rv := reflect.ValueOf(key)
+	if tdef.PKeys != 1 || !rv.IsValid() || !structKind(rv.Type(), tdef.Types[0]) {
+		return Record{}, fmt.Errorf("a %T is not a primary key of %s", key, tdef.Name)
+	}
+	v, err := structValue(rv, tdef.Types[0])
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Cols: []string{tdef.Cols[0]}, Vals: []Value{v}}, nil
+}
+
+// the record of a struct for InsertStruct
+func (db *DB) structInsert(table string, v any, tree *BTree) (Record, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return Record{}, tableNotFound(table)
+	}
+	rv, err := structOf(v)
+	if err != nil {
+		return Record{}, err
+	}
+	return structRecord(tdef, rv)
+}
+
+// insert a struct as a row. the assigned AUTO_INCREMENT key is set in the
+// struct v points to.
+//
+// Deprecated: use DBTX.InsertStruct.
+func (db *DB) InsertStruct(table string, v any, kvtx *KVTX) (bool, error) {
+	rec, err := db.structInsert(table, v, &kvtx.Tree)
+	if err != nil {
+		return false, err
+	}
+	return db.insertStruct(table, rec, v, kvtx)
+}
+
+func (db *DB) insertStruct(table string, rec Record, v any, kvtx *KVTX) (bool, error) {
+	added, err := db.InsertAuto(table, &rec, kvtx)
+	if err != nil || !added {
+		return added, err
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if rv := reflect.ValueOf(v); tdef.AutoIncrement && rv.Kind() == reflect.Pointer {
+		key := Record{Cols: []string{tdef.Cols[0]}, Vals: []Value{*rec.Get(tdef.Cols[0])}}
+		return true, structFill(tdef, key, rv.Elem())
+	}
+	return true, nil
+}
+
+// get the row of the primary key into the struct out points to
+//
+// Deprecated: use DBTX.GetStruct or DBReader.GetStruct.
+func (db *DB) GetStruct(table string, key any, out any, kvReader *KVReader) (bool, error) {
+	tdef := GetTableDef(db, table, &kvReader.Tree)
+	if tdef == nil {
+		return false, tableNotFound(table)
+	}
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return false, fmt.Errorf("expected a pointer to a struct, got %T", out)
+	}
+	if _, err := structFields(tdef, rv.Elem().Type()); err != nil {
+		return false, err
+	}
+	rec, err := structKey(tdef, key)
+	if err != nil {
+		return false, err
+	}
+	ok, err := dbGet(db, tdef, &rec, &kvReader.Tree)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, structFill(tdef, rec, rv.Elem())
+}
+
+// append the remaining rows of the scanner to the slice out points to, of
+// structs or of pointers to them, from the tree it scans
+func (sc *Scanner) ReadStructs(out any) error {
+	return sc.DerefStructs(out, sc.tree)
+}
+
+// Deprecated: use ReadStructs.
+func (sc *Scanner) DerefStructs(out any, tree *BTree) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("expected a pointer to a slice, got %T", out)
+	}
+	slice := rv.Elem()
+	elem := slice.Type().Elem()
+	ptr := elem.Kind() == reflect.Pointer
+	if ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return fmt.Errorf("expected a slice of structs, got %T", out)
+	}
+	if sc.tdef == nil {
+		return fmt.Errorf("the scanner is not started")
+	}
+	if _, err := structFields(sc.tdef, elem); err != nil {
+		return err
+	}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec, tree); err != nil {
+			return err
+		}
+		item := reflect.New(elem)
+		if err := structFill(sc.tdef, rec, item.Elem()); err != nil {
+			return err
+		}
+		if ptr {
+			slice = reflect.Append(slice, item)
+		} else {
+			slice = reflect.Append(slice, item.Elem())
+		}
+	}
+	rv.Elem().Set(slice)
+	return sc.Err()
+}
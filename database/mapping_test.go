@@ -0,0 +1,159 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type account struct {
+	ID      int64  `atomix:"id"`
+	Email   string `atomix:"email"`
+	Balance *float64
+	Active  bool      `atomix:"active"`
+	Since   time.Time `atomix:"since"`
+	Note    []byte    `atomix:"-"`
+	hidden  int
+}
+
+func TestStructMapping(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:          "accounts",
+		Types:         []uint32{TYPE_INT64, TYPE_BYTES, TYPE_FLOAT64, TYPE_BOOL, TYPE_TIME, TYPE_INT32},
+		Cols:          []string{"id", "email", "balance", "active", "since", "level"},
+		PKeys:         1,
+		Indexes:       [][]string{{"email"}},
+		NotNull:       []bool{false, true, false, false, false, false},
+		AutoIncrement: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	since := time.Unix(1700000000, 0)
+	balance := 2.5
+	accounts := []*account{
+		{Email: "a@x", Balance: &balance, Active: true, Since: since, Note: []byte("note")},
+		{Email: "b@x", Since: since},
+	}
+	for _, a := range accounts {
+		if _, err := tx.InsertStruct("accounts", a); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if accounts[0].ID != 1 || accounts[1].ID != 2 {
+		t.Errorf("expected the assigned keys 1 and 2, got %d and %d", accounts[0].ID, accounts[1].ID)
+	}
+	// by value, with the key set
+	if _, err := tx.InsertStruct("accounts", account{ID: 10, Email: "c@x"}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	for _, key := range []any{int64(1), 1, account{ID: 1}, *(&Record{}).AddInt64("id", 1)} {
+		var got account
+		ok, err := reader.GetStruct("accounts", key, &got)
+		if !ok || err != nil {
+			t.Fatalf("failed to get %v: %v %v", key, ok, err)
+		}
+		if got.ID != 1 || got.Email != "a@x" || got.Balance == nil || *got.Balance != 2.5 || !got.Active || !got.Since.Equal(since) || got.Note != nil {
+			t.Errorf("unexpected row %+v", got)
+		}
+	}
+	var got account
+	if ok, err := reader.GetStruct("accounts", 3, &got); ok || err != nil {
+		t.Errorf("expected no row, got %v %v", ok, err)
+	}
+	if ok, _ := reader.GetStruct("accounts", 2, &got); !ok || got.Balance != nil || got.Active {
+		t.Errorf("expected a NULL balance, got %+v", got)
+	}
+
+	// the rows of a scan, by value & by pointer
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("id", 2), Key2: *(&Record{}).AddInt64("id", 10)}
+	if err := reader.Scan("accounts", &sc); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	var rows []account
+	if err := sc.DerefStructs(&rows, &reader.kv.Tree); err != nil {
+		t.Fatalf("failed to deref: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Email != "b@x" || rows[1].ID != 10 {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+	sc = Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddStr("email", []byte("a")), Key2: *(&Record{}).AddStr("email", []byte("b~"))}
+	if err := reader.Scan("accounts", &sc); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	var ptrs []*account
+	if err := sc.DerefStructs(&ptrs, &reader.kv.Tree); err != nil {
+		t.Fatalf("failed to deref: %v", err)
+	}
+	if len(ptrs) != 2 || ptrs[0].Email != "a@x" || ptrs[1].Email != "b@x" {
+		t.Errorf("unexpected rows %v", ptrs)
+	}
+
+	// the mistakes are found before the write, with the name of the field
+	type typo struct {
+		ID    int64  `atomix:"id"`
+		Email string `atomix:"emial"`
+	}
+	type noEmail struct {
+		ID int64 `atomix:"id"`
+	}
+	type wrongType struct {
+		Email int64 `atomix:"email"`
+	}
+	type small struct {
+		Email string `atomix:"email"`
+		Level int64  `atomix:"level"`
+	}
+	type narrow struct {
+		ID    int8 `atomix:"id"`
+		Email string
+	}
+	db.Begin(&tx)
+	defer db.Abort(&tx)
+	for _, tt := range []struct {
+		v    any
+		want string
+	}{
+		{typo{Email: "d@x"}, "field Email: column not found: emial"},
+		{noEmail{}, "has no field for the column email"},
+		{&account{Email: "d@x", Balance: &balance}, ""},
+		{wrongType{Email: 1}, "field Email: a int64 does not map to the bytes column email"},
+		{small{Email: "e@x", Level: 1 << 40}, "field Level: 1099511627776 overflows a int32 column"},
+		{struct{ Email *string }{}, "field Email of the column email is nil"},
+		{3, "expected a struct"},
+	} {
+		_, err := tx.InsertStruct("accounts", tt.v)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected %q, got %v", tt.want, err)
+		}
+	}
+	if _, err := tx.InsertStruct("accounts", struct{ Email *string }{}); !errors.Is(err, ErrNotNull) {
+		t.Errorf("expected ErrNotNull, got %v", err)
+	}
+	if _, err := tx.Set("accounts", *(&Record{}).AddInt64("id", 300).AddStr("email", []byte("f@x")), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	var n narrow
+	if _, err := tx.GetStruct("accounts", 300, &n); err == nil || !strings.Contains(err.Error(), "field ID: 300 overflows a int8") {
+		t.Errorf("expected an overflow, got %v", err)
+	}
+	if _, err := tx.GetStruct("accounts", "a@x", &got); err == nil {
+		t.Errorf("expected an error for a key of the wrong type")
+	}
+}
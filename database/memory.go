@@ -0,0 +1,46 @@
+package database
+
+import "sync"
+
+// opens a DB without a file, the pages are kept in memory & lost on Close.
+// everything else behaves as with a file.
+const MEMORY_PATH = ":memory:"
+
+// the pages of an in-memory DB, in place of the mmap.
+// committed pages are only replaced once no reader can reach them,
+// the lock only guards the map itself.
+type memPages struct {
+	mu    sync.RWMutex
+	pages map[uint64][]byte
+}
+
+func (mem *memPages) get(ptr uint64) ([]byte, bool) {
+	mem.mu.RLock()
+	page, ok := mem.pages[ptr]
+	mem.mu.RUnlock()
+	return page, ok
+}
+
+// store the pages of a commit
+func (mem *memPages) set(updates map[uint64][]byte) {
+	mem.mu.Lock()
+	for ptr, page := range updates {
+		if page != nil {
+			mem.pages[ptr] = page
+		}
+	}
+	mem.mu.Unlock()
+}
+
+func (db *KV) inMemory() bool {
+	return db.Path == MEMORY_PATH
+}
+
+func memOpen(db *KV) {
+	db.mem = &memPages{pages: map[uint64][]byte{}}
+	db.tree.root = 0
+	db.page.flushed = 1 // reserved for the master page
+	db.free = FreeListData{}
+	db.version = 0
+	db.flags = 0 // nothing to corrupt
+}
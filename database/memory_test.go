@@ -0,0 +1,178 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupMemoryDB(t testing.TB) *DB {
+	db := &DB{
+		Path:   MEMORY_PATH,
+		kv:     *newKV(MEMORY_PATH),
+		tables: make(map[string]cachedDef),
+		pool:   NewPool(3),
+	}
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if err := initializeInternalTables(db); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+	return db
+}
+
+func TestMemoryDB(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	setupIndexedTable(t, db)
+	emails := []string{"c@x", "a@x", "e@x", "b@x", "d@x"}
+	for i, email := range emails {
+		insertIndexedRecord(t, db, int64(i+1), email)
+	}
+	setupTestTable(t, db)
+	for id := int64(1); id <= 5; id++ {
+		insertTestRecord(t, db, id)
+	}
+	for _, name := range []string{MEMORY_PATH, walPath(MEMORY_PATH)} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("expected no file %q, got %v", name, err)
+		}
+	}
+
+	// a snapshot is kept while a transaction deletes rows
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	var tx DBTX
+	db.Begin(&tx)
+	for id := int64(1); id <= 3; id++ {
+		if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", id)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	// an aborted transaction leaves no trace
+	db.Begin(&tx)
+	if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", 4)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	db.Abort(&tx)
+
+	ids := func(tree *BTree) []int64 {
+		sc, err := db.ScanAll("users", tree)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return scanIDs(sc, tree)
+	}
+	emailScan := func(tree *BTree) string {
+		sc := Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddStr("email", []byte("a@x")),
+			Key2: *(&Record{}).AddStr("email", []byte("z@x")),
+		}
+		if err := db.Scan("people", &sc, tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for sc.Valid() {
+			rec := Record{}
+			if err := sc.Deref(&rec, tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, string(rec.Get("email").Str))
+			sc.Next()
+		}
+		return strings.Join(got, ",")
+	}
+	if got := ids(&reader.Tree); !equalIDs(got, []int64{1, 2, 3, 4, 5}) {
+		t.Errorf("expected the snapshot to keep every row, got %v", got)
+	}
+	var latest KVReader
+	db.kv.BeginRead(&latest)
+	if got := ids(&latest.Tree); !equalIDs(got, []int64{4, 5}) {
+		t.Errorf("expected the rows left by the commit, got %v", got)
+	}
+	if got := emailScan(&latest.Tree); got != "a@x,b@x,c@x,d@x,e@x" {
+		t.Errorf("expected the index scan in order, got %v", got)
+	}
+	if err := latest.Tree.Verify(); err != nil {
+		t.Errorf("invalid tree: %v", err)
+	}
+	db.kv.EndRead(&latest)
+
+	// a backup of the memory DB is a regular file
+	var buf bytes.Buffer
+	if err := db.Backup(&buf); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if err := Restore(&buf, filepath.Join(t.TempDir(), "copy.db")); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+}
+
+// random sets & deletes against a map, in small transactions
+func FuzzMemoryKV(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Add(bytes.Repeat([]byte{0x81, 0x02, 0x43}, 200))
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		kv := KV{Path: MEMORY_PATH}
+		if err := kv.Open(); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer kv.Close()
+		ref := map[string][]byte{}
+
+		var tx KVTX
+		kv.Begin(&tx)
+		for i, op := range ops {
+			key := binary.BigEndian.AppendUint16([]byte("k"), uint16(op&0x3f))
+			if op&0x80 == 0 {
+				val := bytes.Repeat([]byte{op}, int(op)*40+1)
+				if err := tx.Set(key, val); err != nil {
+					t.Fatalf("set: %v", err)
+				}
+				ref[string(key)] = val
+			} else if _, ok := ref[string(key)]; ok {
+				if _, err := tx.Delete(&DeleteReq{Key: key}); err != nil {
+					t.Fatalf("delete: %v", err)
+				}
+				delete(ref, string(key))
+			}
+			if i%16 == 15 {
+				if err := kv.Commit(&tx); err != nil {
+					t.Fatalf("commit: %v", err)
+				}
+				kv.Begin(&tx)
+			}
+		}
+		if err := kv.Commit(&tx); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		var reader KVReader
+		kv.BeginRead(&reader)
+		defer kv.EndRead(&reader)
+		if err := reader.Tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		for i := 0; i < 0x40; i++ {
+			key := binary.BigEndian.AppendUint16([]byte("k"), uint16(i))
+			val, ok, err := reader.Tree.Get(key)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			if want, exists := ref[string(key)]; ok != exists || !bytes.Equal(val, want) {
+				t.Fatalf("key %d: expected %v %d bytes, got %v %d bytes", i, exists, len(want), ok, len(val))
+			}
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// the operations counted & timed by the metrics of the DB
+const (
+	OP_GET    = "get"    // a lookup by the primary key
+	OP_INSERT = "insert" // a write of a row, in any mode
+	OP_DELETE = "delete" // a delete of a row by the primary key
+	OP_COMMIT = "commit" // a commit, the conflicts too
+)
+
+// the counters given to MetricsSink.Add besides the operations
+const (
+	METRIC_SCAN_ROWS   = "scan_rows" // the rows of the tables returned by the scanners
+	METRIC_ABORTS      = "aborts"
+	METRIC_CONFLICTS   = "conflicts" // the commits rejected by a ConflictError
+	METRIC_PAGE_READS  = "page_reads"
+	METRIC_PAGE_WRITES = "page_writes"
+)
+
+// the buckets of a LatencyHistogram, the last one is for the slower operations
+const LATENCY_BUCKETS = 24
+
+// receives the measurements of a DB as they are made, see WithMetrics. it is
+// called from the goroutines using the DB, on the paths of the operations, &
+// must be cheap & safe for concurrent use. the gauges are read by DB.Metrics.
+type MetricsSink interface {
+	Add(counter string, delta int64)          // an operation or a METRIC_ counter
+	Observe(op string, elapsed time.Duration) // the time of an operation
+}
+
+// the counters of a DB since it was opened, see DB.Metrics
+type Metrics struct {
+	Gets      int64
+	Inserts   int64
+	Deletes   int64
+	ScanRows  int64 // the rows returned by the scanners, the lookups too
+	Commits   int64 // the successful ones
+	Aborts    int64
+	Conflicts int64
+	// of the pages read from the file, the cached pages are not read
+	PageReads   int64
+	PageWrites  int64
+	CacheHits   uint64
+	CacheMisses uint64
+	// gauges of the last commit
+	FileSize  int64                       // the bytes used in the file, 0 in memory
+	FreePages int                         // the items of the free list
+	Latency   map[string]LatencyHistogram // by the operation
+}
+
+// the latencies of an operation. bucket i counts those below 2^i µs.
+type LatencyHistogram struct {
+	Count   int64
+	Sum     time.Duration
+	Buckets [LATENCY_BUCKETS]int64
+}
+
+// the upper bound of a bucket of LatencyHistogram, the last one has none
+func LatencyBound(i int) time.Duration {
+	return time.Microsecond << i
+}
+
+// the latency below which the fraction q of the operations were, in the
+// precision of the buckets
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	rank := int64(q * float64(h.Count))
+	seen := int64(0)
+	for i, n := range h.Buckets {
+		seen += n
+		if seen > rank && n > 0 {
+			return LatencyBound(i)
+		}
+	}
+	return LatencyBound(LATENCY_BUCKETS - 1)
+}
+
+type latencyCounters struct {
+	count   atomic.Int64
+	sum     atomic.Int64
+	buckets [LATENCY_BUCKETS]atomic.Int64
+}
+
+func (h *latencyCounters) observe(d time.Duration) {
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	i := 0
+	if us := uint64(d / time.Microsecond); us > 0 {
+		i = min(bits.Len64(us), LATENCY_BUCKETS-1)
+	}
+	h.buckets[i].Add(1)
+}
+
+func (h *latencyCounters) load() LatencyHistogram {
+	out := LatencyHistogram{Count: h.count.Load(), Sum: time.Duration(h.sum.Load())}
+	for i := range h.buckets {
+		out.Buckets[i] = h.buckets[i].Load()
+	}
+	return out
+}
+
+// the counters of the operations of a DB, atomic to be always on
+type dbMetrics struct {
+	sink      MetricsSink // of WithMetrics, nil for none
+	gets      atomic.Int64
+	inserts   atomic.Int64
+	deletes   atomic.Int64
+	scanRows  atomic.Int64
+	commits   atomic.Int64
+	aborts    atomic.Int64
+	conflicts atomic.Int64
+	latency   struct {
+		get, insert, delete, commit latencyCounters
+	}
+}
+
+// the counters of the pages of a KV, shared with its readers
+type kvMetrics struct {
+	sink   MetricsSink
+	reads  atomic.Int64
+	writes atomic.Int64
+}
+
+// nil-safe, for the readers made without BeginRead
+func (m *kvMetrics) pageRead() {
+	if m == nil {
+		return
+	}
+	m.reads.Add(1)
+	if m.sink != nil {
+		m.sink.Add(METRIC_PAGE_READS, 1)
+	}
+}
+
+func (m *kvMetrics) pageWrites(n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.writes.Add(int64(n))
+	if m.sink != nil {
+		m.sink.Add(METRIC_PAGE_WRITES, int64(n))
+	}
+}
+
+// the timer of an operation, stopped with its outcome. nil-safe on the DB.
+type opTimer struct {
+	db    *DB
+	op    string
+	start time.Time
+}
+
+func (db *DB) startOp(op string) opTimer {
+	return opTimer{db, op, time.Now()}
+}
+
+// count the operation, the failed ones are timed but not counted. deferred
+// with the named error of the operation.
+func (t opTimer) stop(errp *error) {
+	if t.db == nil {
+		return
+	}
+	m := &t.db.metrics
+	elapsed := time.Since(t.start)
+	var counter *atomic.Int64
+	var latency *latencyCounters
+	switch t.op {
+	case OP_GET:
+		counter, latency = &m.gets, &m.latency.get
+	case OP_INSERT:
+		counter, latency = &m.inserts, &m.latency.insert
+	case OP_DELETE:
+		counter, latency = &m.deletes, &m.latency.delete
+	case OP_COMMIT:
+		counter, latency = &m.commits, &m.latency.commit
+	}
+	latency.observe(elapsed)
+	t.db.slowCheck(t.op, elapsed)
+	ok := *errp == nil
+	if ok {
+		counter.Add(1)
+	}
+	if m.sink != nil {
+		if ok {
+			m.sink.Add(t.op, 1)
+		}
+		m.sink.Observe(t.op, elapsed)
+	}
+}
+
+// the catalog, its lookups are not counted as the scans of the tables
+func (tdef *TableDef) internal() bool {
+	return tdef.Prefix == TDEF_META.Prefix || tdef.Prefix == TDEF_TABLE.Prefix
+}
+
+func (db *DB) countMetric(counter string, delta int64) {
+	if db == nil {
+		return
+	}
+	m := &db.metrics
+	switch counter {
+	case METRIC_SCAN_ROWS:
+		m.scanRows.Add(delta)
+	case METRIC_ABORTS:
+		m.aborts.Add(delta)
+	case METRIC_CONFLICTS:
+		m.conflicts.Add(delta)
+	}
+	if m.sink != nil {
+		m.sink.Add(counter, delta)
+	}
+}
+
+// the counters since the DB was opened with the size of the file & of the
+// free list. cheap enough to poll.
+func (db *DB) Metrics() Metrics {
+	m := &db.metrics
+	cache := db.CacheStats()
+	out := Metrics{
+		Gets:        m.gets.Load(),
+		Inserts:     m.inserts.Load(),
+		Deletes:     m.deletes.Load(),
+		ScanRows:    m.scanRows.Load(),
+		Commits:     m.commits.Load(),
+		Aborts:      m.aborts.Load(),
+		Conflicts:   m.conflicts.Load(),
+		PageReads:   db.kv.metrics.reads.Load(),
+		PageWrites:  db.kv.metrics.writes.Load(),
+		CacheHits:   cache.Hits,
+		CacheMisses: cache.Misses,
+		Latency: map[string]LatencyHistogram{
+			OP_GET:    m.latency.get.load(),
+			OP_INSERT: m.latency.insert.load(),
+			OP_DELETE: m.latency.delete.load(),
+			OP_COMMIT: m.latency.commit.load(),
+		},
+	}
+	db.kv.mu.Lock()
+	if !db.kv.inMemory() {
+		out.FileSize = int64(db.kv.page.flushed) * BTREE_PAGE_SIZE
+	}
+	out.FreePages = int(db.kv.free.tailSeq - db.kv.free.headSeq)
+	db.kv.mu.Unlock()
+	return out
+}
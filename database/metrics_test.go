@@ -0,0 +1,101 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingSink struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	observed map[string]int
+}
+
+func (s *countingSink) Add(counter string, delta int64) {
+	s.mu.Lock()
+	s.counters[counter] += delta
+	s.mu.Unlock()
+}
+
+func (s *countingSink) Observe(op string, elapsed time.Duration) {
+	s.mu.Lock()
+	s.observed[op]++
+	s.mu.Unlock()
+}
+
+func TestMetrics(t *testing.T) {
+	sink := &countingSink{counters: map[string]int64{}, observed: map[string]int{}}
+	db, err := Open(filepath.Join(t.TempDir(), "metrics.db"), WithMetrics(sink), WithCacheSize(4*BTREE_PAGE_SIZE))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Transact(func(tx *DBTX) error {
+		tdef := &TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "qty"}, PKeys: 1}
+		if err := tx.TableNew(tdef); err != nil {
+			return err
+		}
+		for i := int64(0); i < 10; i++ {
+			if _, err := tx.Set("items", *(&Record{}).AddInt64("id", i).AddInt64("qty", i), MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	err = db.Transact(func(tx *DBTX) error {
+		// a failed insert is timed but not counted
+		if _, err := tx.Set("items", *(&Record{}).AddInt64("id", 1).AddInt64("qty", 1), MODE_INSERT_ONLY); !errors.Is(err, ErrExists) {
+			t.Errorf("expected ErrExists, got %v", err)
+		}
+		if _, err := tx.Delete("items", *(&Record{}).AddInt64("id", 3)); err != nil {
+			return err
+		}
+		return errors.New("rolled back")
+	})
+	if err == nil {
+		t.Fatalf("expected the abort")
+	}
+	db.View(func(tx *DBReader) error {
+		tx.Get("items", (&Record{}).AddInt64("id", 4))
+		sc, err := tx.ScanAll("items")
+		for ; err == nil && sc.Valid(); sc.Next() {
+		}
+		return err
+	})
+
+	m := db.Metrics()
+	if m.Inserts != 10 || m.Deletes != 1 || m.Gets != 1 || m.Commits != 1 || m.Aborts != 1 || m.Conflicts != 0 {
+		t.Errorf("unexpected operation counts %+v", m)
+	}
+	if m.ScanRows != 11 {
+		t.Errorf("expected the rows of the scan & of the get, got %d", m.ScanRows)
+	}
+	if m.PageWrites == 0 || m.FileSize == 0 || m.CacheHits+m.CacheMisses == 0 {
+		t.Errorf("expected the pages counted, got %+v", m)
+	}
+	if h := m.Latency[OP_INSERT]; h.Count != 11 || h.Sum <= 0 || h.Quantile(0.5) <= 0 {
+		t.Errorf("expected the latency of 11 inserts, got %+v", h)
+	}
+	sink.mu.Lock()
+	if sink.counters[OP_INSERT] != 10 || sink.observed[OP_INSERT] != 11 || sink.counters[METRIC_ABORTS] != 1 ||
+		sink.counters[METRIC_SCAN_ROWS] != 11 || sink.counters[METRIC_PAGE_WRITES] != m.PageWrites {
+		t.Errorf("expected the sink given the same counts, got %v %v", sink.counters, sink.observed)
+	}
+	sink.mu.Unlock()
+
+	// the buckets are powers of 2 in µs
+	var h latencyCounters
+	for _, d := range []time.Duration{500 * time.Nanosecond, 3 * time.Microsecond, time.Hour} {
+		h.observe(d)
+	}
+	if got := h.load(); got.Buckets[0] != 1 || got.Buckets[2] != 1 || got.Buckets[LATENCY_BUCKETS-1] != 1 {
+		t.Errorf("unexpected buckets %v", got.Buckets)
+	}
+}
@@ -16,12 +16,24 @@ func unmapFile(data []byte) error {
 	return syscall.Munmap(data)
 }
 
+// reserves the blocks after the end of the file, the size is set by the caller
 func fallocateFile(fd uintptr, offset int64, length int64) error {
-	// use mmap let file map to memory
-	_, err := unix.Mmap(int(fd), 0, int(offset+length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
-	return err
+	store := unix.Fstore_t{Flags: unix.F_ALLOCATEALL, Posmode: unix.F_PEOFPOSMODE, Length: length}
+	return unix.FcntlFstore(fd, unix.F_PREALLOCATE, &store)
 }
 
 func pwriteFile(fd uintptr, data []byte, offset int64) (int, error) {
 	return syscall.Pwrite(int(fd), data, offset)
 }
+
+func lockFile(fd uintptr, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(fd), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
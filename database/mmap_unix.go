@@ -19,3 +19,15 @@ func fallocateFile(fd uintptr, offset int64, length int64) error {
 func pwriteFile(fd uintptr, data []byte, offset int64) (int, error) {
 	return syscall.Pwrite(int(fd), data, offset)
 }
+
+func lockFile(fd uintptr, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(fd), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
@@ -7,15 +7,29 @@ import (
 	"unsafe"
 )
 
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	LOCKFILE_FAIL_IMMEDIATELY = 0x1
+	LOCKFILE_EXCLUSIVE_LOCK   = 0x2
+	ERROR_LOCK_VIOLATION      = syscall.Errno(33)
+	// a byte far past the data, the locks of windows also block reads & writes
+	LOCK_OFFSET = 1 << 62
+)
+
 func mmapFile(fd uintptr, offset int64, length int, prot, flags int) ([]byte, error) {
-	h, err := syscall.CreateFileMapping(syscall.Handle(fd), nil, uint32(syscall.PAGE_READWRITE),
+	protect, access := uint32(syscall.PAGE_READWRITE), uint32(syscall.FILE_MAP_WRITE)
+	if prot&PROT_WRITE == 0 {
+		protect, access = syscall.PAGE_READONLY, syscall.FILE_MAP_READ
+	}
+	h, err := syscall.CreateFileMapping(syscall.Handle(fd), nil, protect,
 		uint32(offset>>32), uint32(offset&0xffffffff), nil)
 	if err != nil {
 		return nil, err
 	}
 	defer syscall.CloseHandle(h)
 
-	addr, err := syscall.MapViewOfFile(h, syscall.FILE_MAP_WRITE,
+	addr, err := syscall.MapViewOfFile(h, access,
 		uint32(offset>>32), uint32(offset&0xffffffff), uintptr(length))
 	if err != nil {
 		return nil, err
@@ -50,3 +64,21 @@ func pwriteFile(fd uintptr, data []byte, offset int64) (int, error) {
 	err := syscall.WriteFile(syscall.Handle(fd), data, &bytesWritten, &overlapped)
 	return int(bytesWritten), err
 }
+
+func lockFile(fd uintptr, exclusive bool) error {
+	flags := uint32(LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= LOCKFILE_EXCLUSIVE_LOCK
+	}
+	var overlapped syscall.Overlapped
+	overlapped.Offset = uint32(LOCK_OFFSET & 0xffffffff)
+	overlapped.OffsetHigh = uint32(LOCK_OFFSET >> 32)
+	r, _, err := procLockFileEx.Call(fd, uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+	return err
+}
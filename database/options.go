@@ -0,0 +1,66 @@
+package database
+
+import "time"
+
+// a setting of the DB opened by Open
+type Option func(db *DB)
+
+// the file is only read, see OpenReadOnly
+func WithReadOnly() Option {
+	return func(db *DB) { db.kv.ReadOnly = true }
+}
+
+// SYNC_EVERY_COMMIT, or SYNC_PERIODIC at most once per interval, 0 for
+// WAL_SYNC_INTERVAL
+func WithSync(mode int, interval time.Duration) Option {
+	return func(db *DB) { db.kv.Sync, db.kv.SyncInterval = mode, interval }
+}
+
+// the bytes of the pages kept in memory by the page cache
+func WithCacheSize(bytes int) Option {
+	return func(db *DB) { db.kv.CacheSize = bytes }
+}
+
+// the size of the log written into the file by a checkpoint, 0 for
+// WAL_CHECKPOINT_SIZE
+func WithCheckpointSize(bytes int64) Option {
+	return func(db *DB) { db.kv.CheckpointSize = bytes }
+}
+
+// how long a commit waits for other commits to share its fsync
+func WithCommitWindow(d time.Duration) Option {
+	return func(db *DB) { db.kv.CommitWindow = d }
+}
+
+// how long Open waits for another process to close the file
+func WithFileLockTimeout(d time.Duration) Option {
+	return func(db *DB) { db.kv.LockTimeout = d }
+}
+
+// the sink given the counters & the latencies of the operations as they are
+// made, besides DB.Metrics
+func WithMetrics(sink MetricsSink) Option {
+	return func(db *DB) { db.metrics.sink, db.kv.metrics.sink = sink, sink }
+}
+
+// the Logger of the diagnostics, none by default
+func WithLogger(l Logger) Option {
+	return func(db *DB) { db.kv.Logger = l }
+}
+
+// log the operations at least this long as a warning
+func WithSlowThreshold(d time.Duration) Option {
+	return func(db *DB) { db.SlowThreshold = d }
+}
+
+// keep a bloom filter of the primary keys of each table read by Get, on by
+// default
+func WithBloomFilters(enabled bool) Option {
+	return func(db *DB) { db.kv.NoBloom = !enabled }
+}
+
+// the writes to the file & to its log go through ops, e.g. to fail them
+// like a full disk would in a test
+func WithFileOps(ops FileOps) Option {
+	return func(db *DB) { db.kv.Files = ops }
+}
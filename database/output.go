@@ -0,0 +1,186 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the output of the read commands of the REPL, switched by MODE
+const (
+	OUTPUT_TABLE = 0 // an aligned table & the number of rows
+	OUTPUT_CSV   = 1 // a header row & a row per line, as EXPORT_CSV
+	OUTPUT_JSON  = 2 // a JSON object per row, as EXPORT_JSONL
+)
+
+var outputNames = []string{"table", "csv", "json"}
+
+// the widest value of a table, a longer one is cut & ends with OUTPUT_MORE
+const OUTPUT_MAX_CELL = 48
+
+// the narrowest a column is cut to, to fit a table in its width
+const OUTPUT_MIN_CELL = 4
+
+const OUTPUT_MORE = "…"
+
+var (
+	replOutput = OUTPUT_TABLE
+	replWidth  = 0 // the width of a table, the terminal's when 0
+)
+
+// the control characters of a value would break the lines of a table
+var tableEscapes = strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// print the rows in the output mode of the REPL. a table of no rows prints
+// that none were found, a nil cols prints nothing in the other modes.
+func PrintRows(w io.Writer, cols []string, rows [][]Value) error {
+	switch replOutput {
+	case OUTPUT_CSV:
+		return printExport(&csvExport{w: csv.NewWriter(w)}, cols, rows)
+	case OUTPUT_JSON:
+		return printExport(&jsonExport{w: bufio.NewWriter(w)}, cols, rows)
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "No records found")
+		return nil
+	}
+	width := replWidth
+	if width == 0 {
+		width = terminalWidth()
+	}
+	printTable(w, cols, rows, width)
+	return nil
+}
+
+// the records of a table, of the same columns
+func printRecords(records []*Record) {
+	var cols []string
+	rows := make([][]Value, len(records))
+	for i, rec := range records {
+		cols, rows[i] = rec.Cols, rec.Vals
+	}
+	if err := PrintRows(os.Stdout, cols, rows); err != nil {
+		replError("Error: %v", err)
+	}
+}
+
+func printExport(out exportWriter, cols []string, rows [][]Value) error {
+	if cols == nil {
+		return nil
+	}
+	if err := out.header(cols); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := out.row(Record{Cols: cols, Vals: row}); err != nil {
+			out.flush()
+			return err
+		}
+	}
+	return out.flush()
+}
+
+// an aligned table of at most width characters, 0 for any. the widest
+// columns are cut until it fits, to OUTPUT_MIN_CELL at the least.
+func printTable(w io.Writer, cols []string, rows [][]Value, width int) {
+	cells := make([][]string, 0, len(rows)+1)
+	cells = append(cells, cols)
+	for _, row := range rows {
+		line := make([]string, len(row))
+		for i, v := range row {
+			line[i] = tableEscapes.Replace(formatValue(v))
+		}
+		cells = append(cells, line)
+	}
+	widths := make([]int, len(cols))
+	for _, line := range cells {
+		for i, cell := range line {
+			widths[i] = max(widths[i], min(utf8.RuneCountInString(cell), OUTPUT_MAX_CELL))
+		}
+	}
+	total := 1 // the borders, 3 for each column
+	for _, n := range widths {
+		total += n + 3
+	}
+	for width > 0 && total > width {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= OUTPUT_MIN_CELL {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+
+	border := "+"
+	for _, n := range widths {
+		border += strings.Repeat("-", n+2) + "+"
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, border)
+	for n, line := range cells {
+		out.WriteString("|")
+		for i, cell := range line {
+			fmt.Fprintf(out, " %s |", fitCell(cell, widths[i]))
+		}
+		out.WriteString("\n")
+		if n == 0 {
+			fmt.Fprintln(out, border)
+		}
+	}
+	fmt.Fprintln(out, border)
+	fmt.Fprintf(out, "(%d rows)\n", len(rows))
+	out.Flush()
+}
+
+// the cell padded to width, or cut with OUTPUT_MORE
+func fitCell(cell string, width int) string {
+	n := utf8.RuneCountInString(cell)
+	if n > width {
+		cell, n = string([]rune(cell)[:width-1])+OUTPUT_MORE, width
+	}
+	return cell + strings.Repeat(" ", width-n)
+}
+
+// COLUMNS when set, or the width of the terminal of stdout, 0 when it is not
+// one
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return terminalColumns(os.Stdout.Fd())
+}
+
+// the mode & the width of MODE: table, csv or json, and width=N for a table,
+// 0 for the terminal's. the one left out is kept.
+func parseOutputMode(input string, mode, width int) (int, int, error) {
+	for _, field := range strings.Fields(input) {
+		if value, ok := strings.CutPrefix(field, "width="); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("invalid width %q", value)
+			}
+			width = n
+			continue
+		}
+		found := false
+		for i, name := range outputNames {
+			if strings.EqualFold(field, name) {
+				mode, found = i, true
+			}
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("unknown output mode %q, expected table, csv or json", field)
+		}
+	}
+	return mode, width, nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	t.Setenv("COLUMNS", "")
+	defer func() { replOutput, replWidth = OUTPUT_TABLE, 0 }()
+
+	cols := []string{"id", "name", "note"}
+	rows := [][]Value{
+		{{Type: TYPE_INT64, I64: 1}, {Type: TYPE_BYTES, Str: []byte("ann")}, {Type: TYPE_BYTES, Null: true}},
+		{{Type: TYPE_INT64, I64: 22}, {Type: TYPE_BYTES, Str: []byte("bob")}, {Type: TYPE_BYTES, Str: []byte(strings.Repeat("x", 60) + "\n")}},
+	}
+	var out bytes.Buffer
+	if err := PrintRows(&out, cols, rows); err != nil {
+		t.Fatalf("failed to print: %v", err)
+	}
+	long := strings.Repeat("x", OUTPUT_MAX_CELL-1) + OUTPUT_MORE
+	want := "+----+------+" + strings.Repeat("-", OUTPUT_MAX_CELL+2) + "+\n" +
+		"| id | name | note" + strings.Repeat(" ", OUTPUT_MAX_CELL-4) + " |\n" +
+		"+----+------+" + strings.Repeat("-", OUTPUT_MAX_CELL+2) + "+\n" +
+		"| 1  | ann  | NULL" + strings.Repeat(" ", OUTPUT_MAX_CELL-4) + " |\n" +
+		"| 22 | bob  | " + long + " |\n" +
+		"+----+------+" + strings.Repeat("-", OUTPUT_MAX_CELL+2) + "+\n" +
+		"(2 rows)\n"
+	if out.String() != want {
+		t.Errorf("expected the table\n%s\ngot\n%s", want, out.String())
+	}
+
+	// the widest column is cut to fit the width
+	out.Reset()
+	printTable(&out, cols, rows, 30)
+	lines := strings.Split(out.String(), "\n")
+	if len(lines[0]) != 30 || !strings.HasPrefix(lines[4], "| 22 | bob  | "+strings.Repeat("x", 13)+OUTPUT_MORE+" |") {
+		t.Errorf("expected a table of 30 columns, got\n%s", out.String())
+	}
+	out.Reset()
+	printTable(&out, cols, rows, 5)
+	if !strings.HasPrefix(out.String(), "+----+------+------+\n") {
+		t.Errorf("expected the columns cut to OUTPUT_MIN_CELL at the least, got\n%s", out.String())
+	}
+
+	mode, width, err := parseOutputMode("CSV", OUTPUT_TABLE, 80)
+	if err != nil || mode != OUTPUT_CSV || width != 80 {
+		t.Errorf("expected csv & the width kept, got %d %d %v", mode, width, err)
+	}
+	if mode, width, err = parseOutputMode("width=0 json", OUTPUT_CSV, 80); err != nil || mode != OUTPUT_JSON || width != 0 {
+		t.Errorf("expected json & the terminal's width, got %d %d %v", mode, width, err)
+	}
+	for _, input := range []string{"yaml", "width=-1", "table width=wide"} {
+		if _, _, err := parseOutputMode(input, OUTPUT_TABLE, 0); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+
+	// MODE switches the output of the same commands
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	script := "create users cols=id,name types=1,2\n" +
+		"insert users\n1\nann\n" +
+		"insert users\n2\nbob, jr\n" +
+		"mode csv\nscan users\n" +
+		"mode json\nget users\n1\nid\n2\n" +
+		"mode table\nget users\n1\nid\n3\n"
+	var failed int
+	stdout, stderr := captureOutput(t, func() { failed = repl(db, strings.NewReader(script), false) })
+	if failed != 0 || stderr != "" {
+		t.Fatalf("expected the script to pass, got %d %q", failed, stderr)
+	}
+	want = "id,name\n1,ann\n2,\"bob, jr\"\n" +
+		`{"id":2,"name":"Ym9iLCBqcg=="}` + "\n" +
+		"No records found\n"
+	if stdout != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, stdout)
+	}
+}
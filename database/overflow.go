@@ -0,0 +1,147 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/// Overflow pages
+// A value larger than BTREE_MAX_INLINE_VAL_SIZE is stored in a chain of
+// overflow pages, the leaf keeps a stub & sets VAL_OVERFLOW in the vlen.
+// stub: | total len | first page |
+//       | 8B        | 8B         |
+// page: | type | size | next | data |
+//       | 2B   | 2B   | 8B   | ...  |
+
+const (
+	VAL_OVERFLOW       = 0x8000 // flag in the vlen of a leaf KV
+	OVERFLOW_HEADER    = 12
+	OVERFLOW_CAPACITY  = BTREE_NODE_SIZE - OVERFLOW_HEADER
+	OVERFLOW_STUB_SIZE = 16
+)
+
+func (node BNode) isOverflow(idx uint16) bool {
+	pos := node.kvPos(idx)
+	return binary.LittleEndian.Uint16(node.data[pos+2:])&VAL_OVERFLOW != 0
+}
+
+func (node BNode) setOverflow(idx uint16) {
+	pos := node.kvPos(idx)
+	vlen := binary.LittleEndian.Uint16(node.data[pos+2:])
+	binary.LittleEndian.PutUint16(node.data[pos+2:], vlen|VAL_OVERFLOW)
+}
+
+// the length of the whole value
+func (node BNode) valLen(idx uint16) int {
+	val := node.getVal(idx)
+	if node.isOverflow(idx) {
+		return int(binary.LittleEndian.Uint64(val))
+	}
+	return len(val)
+}
+
+// returns the bytes to keep in the leaf & whether they are a stub
+func (tree *BTree) storeVal(val []byte) ([]byte, bool) {
+	if len(val) <= BTREE_MAX_INLINE_VAL_SIZE {
+		return val, false
+	}
+	// from the last page so each page knows the next one
+	next := uint64(0)
+	for end := len(val); end > 0; {
+		start := (end - 1) / OVERFLOW_CAPACITY * OVERFLOW_CAPACITY
+		page := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		binary.LittleEndian.PutUint16(page.data[0:], BNODE_OVERFLOW)
+		binary.LittleEndian.PutUint16(page.data[2:], uint16(end-start))
+		binary.LittleEndian.PutUint64(page.data[4:], next)
+		copy(page.data[OVERFLOW_HEADER:], val[start:end])
+		next = tree.new(page)
+		end = start
+	}
+	stub := make([]byte, OVERFLOW_STUB_SIZE)
+	binary.LittleEndian.PutUint64(stub[0:], uint64(len(val)))
+	binary.LittleEndian.PutUint64(stub[8:], next)
+	return stub, true
+}
+
+// the value of a leaf KV, reassembled from the overflow pages if needed
+func (tree *BTree) loadVal(node BNode, idx uint16) []byte {
+	val := node.getVal(idx)
+	if !node.isOverflow(idx) {
+		return val
+	}
+	total := binary.LittleEndian.Uint64(val)
+	out := make([]byte, 0, total)
+	for ptr := binary.LittleEndian.Uint64(val[8:]); ptr != 0; {
+		page := tree.get(ptr)
+		assertWithSrc(overflowType(page) == BNODE_OVERFLOW, "Failed in loadVal")
+		out = append(out, overflowData(page)...)
+		ptr = overflowNext(page)
+	}
+	assertWithSrc(uint64(len(out)) == total, "Failed in loadVal")
+	return out
+}
+
+// free the overflow pages of a leaf KV, if any
+func (tree *BTree) freeVal(node BNode, idx uint16) {
+	if !node.isOverflow(idx) {
+		return
+	}
+	for ptr := binary.LittleEndian.Uint64(node.getVal(idx)[8:]); ptr != 0; {
+		next := overflowNext(tree.get(ptr))
+		tree.del(ptr)
+		ptr = next
+	}
+}
+
+// the pages a value of the given length takes beyond the leaf
+func overflowPages(vlen int) int {
+	if vlen <= BTREE_MAX_INLINE_VAL_SIZE {
+		return 0
+	}
+	return (vlen + OVERFLOW_CAPACITY - 1) / OVERFLOW_CAPACITY
+}
+
+func overflowType(page BNode) uint16 {
+	return binary.LittleEndian.Uint16(page.data[0:])
+}
+
+func overflowData(page BNode) []byte {
+	size := binary.LittleEndian.Uint16(page.data[2:])
+	return page.data[OVERFLOW_HEADER:][:size]
+}
+
+func overflowNext(page BNode) uint64 {
+	return binary.LittleEndian.Uint64(page.data[4:])
+}
+
+// checks the overflow chain of a leaf KV
+func verifyOverflow(tree *BTree, node BNode, idx uint16) error {
+	stub := node.getVal(idx)
+	if len(stub) != OVERFLOW_STUB_SIZE {
+		return fmt.Errorf("bad overflow stub")
+	}
+	total := binary.LittleEndian.Uint64(stub)
+	size := uint64(0)
+	for ptr := binary.LittleEndian.Uint64(stub[8:]); ptr != 0; {
+		page, err := verifyGet(tree, ptr)
+		if err != nil {
+			return err
+		}
+		if overflowType(page) != BNODE_OVERFLOW {
+			return fmt.Errorf("overflow page %d: bad page type %d", ptr, overflowType(page))
+		}
+		// files without checksums have a larger capacity
+		if binary.LittleEndian.Uint16(page.data[2:]) > BTREE_PAGE_SIZE-OVERFLOW_HEADER {
+			return fmt.Errorf("overflow page %d: out of the page", ptr)
+		}
+		size += uint64(len(overflowData(page)))
+		if size > total {
+			break
+		}
+		ptr = overflowNext(page)
+	}
+	if size != total {
+		return fmt.Errorf("overflow chain holds %d bytes, expected %d", size, total)
+	}
+	return nil
+}
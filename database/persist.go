@@ -5,22 +5,66 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 const DB_SIG = "AtomixDB"
 
+const (
+	// the layout of the file, checked on open & bumped on format changes
+	FORMAT_VERSION = 3
+	MASTER_SIZE    = 80
+)
+
+var (
+	ErrNotDatabase        error = errors.New("not an AtomixDB file")
+	ErrUnsupportedVersion error = errors.New("unsupported version")
+)
+
 const (
 	PROT_READ  = 0x1
 	PROT_WRITE = 0x2
 	MAP_SHARED = 0x1
 )
 
+// the pages of a DB file, its methods are safe for concurrent use once opened
+// like those of the DB, Open & Close aside. a KVReader or KVTX is of one
+// goroutine.
 type KV struct {
 	Path string
+	// SYNC_EVERY_COMMIT or SYNC_PERIODIC, a periodic sync can lose
+	// the last commits on a crash but never leaves the DB corrupted
+	Sync           int
+	SyncInterval   time.Duration // 0 for WAL_SYNC_INTERVAL
+	CheckpointSize int64         // 0 for WAL_CHECKPOINT_SIZE
+	ReadOnly       bool          // see OpenReadOnly
+	NoBloom        bool          // no bloom filters of the primary keys, see WithBloomFilters
+	Replica        bool          // see OpenReplica
+	LogRetain      int           // the entries kept by the replication log, 0 for LOG_RETAIN
+	CacheSize      int           // bytes of pages kept by the page cache, 0 for none
+	LockTimeout    time.Duration // how long to wait for another process to close the file
+	// how long a commit waits for other commits to share its log record &
+	// fsync, 0 to take the commits queued while the last fsync ran
+	CommitWindow time.Duration
+	Logger       Logger  // the diagnostics, nil for none, see WithLogger
+	Files        FileOps // the writes to the files, nil for the OS, see WithFileOps
 	// internals
-	fp *os.File
+	fp      *os.File
+	mem     *memPages         // in place of the file, see MEMORY_PATH
+	logged  map[uint64][]byte // the pages of the log in read-only mode
+	cache   *pageCache
+	metrics kvMetrics // the pages read & written, see DB.Metrics
+	blooms  bloomSet  // of the primary keys of the tables read by Get
+	wal     struct {
+		fp      *os.File
+		size    int64
+		synced  time.Time // the last fsync of the log
+		durable uint64    // the last version that survives a crash
+		torn    bool      // a failed append is left after size, cut by the next one
+	}
 
 	tree struct {
 		root uint64
@@ -36,11 +80,24 @@ type KV struct {
 		flushed uint64 // DB size in number of pages
 	}
 
-	mu     sync.Mutex
-	writer sync.Mutex
+	mu      sync.Mutex
+	writer  sync.Mutex
+	commits struct {
+		mu    sync.Mutex
+		queue []*commitReq // waiting for the writer lock, see Commit
+	}
 
+	flags   uint64 // from the master page, MASTER_CHECKSUMS
+	format  uint32 // written to the master page, 2 until the values are rewritten, see valuesRewrite
 	version uint64
-	readers ReaderList // heap, for tranking the minimum reader version
+	catalog uint64         // the commits writing the catalog, the version is catalog + 1
+	readers ReaderList     // heap, for tranking the minimum reader version
+	history []commitKeys   // the keys of the commits the open transactions may conflict with
+	pending map[uint32]int // the open transactions that wrote under each key prefix, see Truncate
+	watch   *watchSet      // the tables of DB.Watch, under writer
+	replLog bool           // the commits are logged once the log is started, see WithReplicationLog
+	logWait chan struct{}  // closed by the next commit, see WaitLog
+	swap    chan struct{}  // while a compaction replaces the file, closed after it, see Compact
 }
 
 // implements heap.Interface
@@ -51,41 +108,67 @@ func (rl ReaderList) Len() int {
 }
 
 func (rl ReaderList) Less(i int, j int) bool {
-	if rl[i] == nil || rl[j] == nil {
-		return false
-	}
-	return rl[i].index < rl[j].index
+	return versionBefore(rl[i].version, rl[j].version)
 }
 
 func (rl ReaderList) Swap(i, j int) {
 	rl[i], rl[j] = rl[j], rl[i]
+	rl[i].index = i
+	rl[j].index = j
 }
 
 func (rl *ReaderList) Push(item interface{}) {
-	*rl = append(*rl, item.(*KVReader))
+	tx := item.(*KVReader)
+	tx.index = len(*rl)
+	*rl = append(*rl, tx)
 }
 
 func (rl *ReaderList) Pop() interface{} {
 	old := *rl
 	n := len(old)
 	x := old[n-1]
+	x.index = -1
 	*rl = old[0 : n-1]
 	return x
 }
 
 // the master page format.
 // it contains the pointer to the root and other important bits.
-// | sig | btree_root | page_used | free_list | version |
-// |  8B | 	   8B 	  | 	 8B	  |		8B	  |   8B    |
+// | sig | btree_root | page_used | free_list | version | flags | format | page_size |
+// |  8B | 	   8B 	  | 	 8B	  |	   32B	  |   8B    |  8B   |   4B   |    4B     |
+// free_list: | head page | head seq | tail page | tail seq |
+// files of version 1 end at the flags, the format reads as 0.
+// it is written by a checkpoint, the commits after it are in the log.
 
 func (db *KV) Open() error {
-	fp, err := os.OpenFile(db.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	db.cache = newPageCache(db.CacheSize)
+	db.format = FORMAT_VERSION
+	if db.inMemory() {
+		memOpen(db)
+		return nil
+	}
+	flag, prot := os.O_RDWR|os.O_CREATE, PROT_READ|PROT_WRITE
+	if db.ReadOnly {
+		flag, prot = os.O_RDONLY, PROT_READ
+	}
+	fp, err := os.OpenFile(db.Path, flag, 0o644)
 	if err != nil {
 		return fmt.Errorf("OpenFile: %w", err)
 	}
 	db.fp = fp
+	var format uint32
+	var sz int
+	var chunk []byte
+	err = fileLock(db)
+	if err != nil {
+		goto fail
+	}
+	format, err = headerCheck(db.fp)
+	if err != nil {
+		goto fail
+	}
 	// create the inital mmap
-	sz, chunk, err := mmapInit(db.fp)
+	sz, chunk, err = mmapInit(db.fp, prot)
 	if err != nil {
 		goto fail
 	}
@@ -93,28 +176,93 @@ func (db *KV) Open() error {
 	db.mmap.total = len(chunk)
 	db.mmap.chunks = [][]byte{chunk}
 
-	db.free = FreeListData{
-		head: 0,
-	}
+	db.free = FreeListData{}
 	err = masterLoad(db)
 	if err != nil {
 		goto fail
 	}
+	err = formatMigrate(db, format)
+	if err != nil {
+		goto fail
+	}
+	if db.ReadOnly {
+		// nothing is written, not even the recovery
+		err = walLoad(db)
+		if err != nil {
+			goto fail
+		}
+		if db.tree.root == 0 {
+			db.format = FORMAT_VERSION
+		}
+		return nil
+	}
+	if db.mmap.file == 0 {
+		// a new file, write the master page so it is never left without one
+		db.flags = MASTER_CHECKSUMS
+		if err = extendFile(db, 1); err != nil {
+			goto fail
+		}
+		if err = masterStore(db); err != nil {
+			goto fail
+		}
+		if err = db.files().Sync(db.fp); err != nil {
+			goto fail
+		}
+	}
+	err = walOpen(db)
+	if err != nil {
+		goto fail
+	}
+	err = walReplay(db)
+	if err != nil {
+		goto fail
+	}
+	if db.tree.root == 0 {
+		db.format = FORMAT_VERSION // no values to rewrite
+	}
+	db.wal.durable = db.version
 	return nil
 
 fail:
+	if db.wal.fp != nil {
+		// keep the log as it is for the next open
+		_ = db.wal.fp.Close()
+		db.wal.fp = nil
+	}
 	db.Close()
 	return fmt.Errorf("KV Open: %w", err)
 }
 
-func (db *KV) Close() {
+// checkpoints the log into the file, unmaps it & releases its lock
+func (db *KV) Close() error {
+	db.cache = nil
+	if db.mem != nil {
+		db.mem = nil
+		return nil
+	}
+	var errs []error
+	if db.wal.fp != nil {
+		if err := checkpoint(db); err != nil {
+			// the log is kept & replayed on the next open
+			errs = append(errs, fmt.Errorf("checkpoint: %w", err))
+			_ = db.wal.fp.Close()
+		} else {
+			_ = db.wal.fp.Close()
+			_ = os.Remove(walPath(db.Path))
+		}
+		db.wal.fp = nil
+	}
 	for _, chunk := range db.mmap.chunks {
-		err := unmapFile(chunk)
-		if err != nil {
-			fmt.Println("Error while closing DB")
+		if err := unmapFile(chunk); err != nil {
+			errs = append(errs, fmt.Errorf("unmap: %w", err))
 		}
 	}
-	_ = db.fp.Close()
+	db.mmap.chunks = nil
+	if db.fp != nil {
+		errs = append(errs, db.fp.Close()) // releases the lock
+		db.fp = nil
+	}
+	return errors.Join(errs...)
 }
 
 func (db *KVTX) Get(key []byte) ([]byte, bool, error) {
@@ -122,33 +270,50 @@ func (db *KVTX) Get(key []byte) ([]byte, bool, error) {
 }
 
 func (db *KVTX) Set(key, val []byte) error {
-	db.Tree.Insert(key, val)
-	return flushPages(db)
+	if db.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := db.Tree.Insert(key, val); err != nil {
+		return err
+	}
+	db.written(key)
+	return nil
+}
+
+// keys must be sorted, see BTree.BulkLoad
+func (db *KVTX) BulkSet(keys, vals [][]byte) error {
+	if db.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := db.Tree.BulkLoad(keys, vals); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		db.written(key)
+	}
+	return nil
 }
 
 func (db *KVTX) Delete(req *DeleteReq) (bool, error) {
+	if db.kv.ReadOnly {
+		return false, ErrReadOnly
+	}
 	val, _, err := db.Get(req.Key)
 	if err != nil {
 		return false, err
 	} else if len(val) == 0 {
-		return false, errors.New("record not found")
+		return false, ErrNotFound
 	}
 	deleted := db.Tree.Delete(req.Key)
 	if deleted {
 		req.Old = val
+		db.written(req.Key)
 	}
-	return deleted, flushPages(db)
-}
-
-// persist the newly allocated pages after updates
-func flushPages(db *KVTX) error {
-	if err := writePages(db); err != nil {
-		return err
-	}
-	return syncPages(db)
+	return deleted, nil
 }
 
-func writePages(db *KVTX) error {
+// log the updates & make them visible, see Commit
+func writePages(db *kvWriter) error {
 	freed := []uint64{}
 
 	for ptr, page := range db.page.updates {
@@ -158,6 +323,23 @@ func writePages(db *KVTX) error {
 	}
 	db.free.Add(freed)
 	npages := int(db.page.nappend) + int(db.kv.page.flushed)
+	db.kv.metrics.pageWrites(len(db.page.updates) - len(freed))
+	for ptr, page := range db.page.updates {
+		if page == nil {
+			continue
+		}
+		if len(page) < BTREE_PAGE_SIZE {
+			page = append(page, make([]byte, BTREE_PAGE_SIZE-len(page))...)
+			db.page.updates[ptr] = page
+		}
+		if db.kv.Checksums() {
+			pageSetChecksum(page)
+		}
+	}
+	if db.kv.mem != nil {
+		db.kv.mem.set(db.page.updates)
+		return nil
+	}
 
 	// extends mmap & file if needed
 	if err := extendFile(db.kv, npages); err != nil {
@@ -167,26 +349,68 @@ func writePages(db *KVTX) error {
 		return err
 	}
 
+	// the pages must be in the log before the main file
+	master := masterState{
+		version: db.kv.version + 1,
+		root:    db.Tree.root,
+		used:    uint64(npages),
+		free:    db.free.FreeListData,
+	}
+	if err := walAppend(db, master); err != nil {
+		return err
+	}
 	for ptr, page := range db.page.updates {
 		if page != nil {
-			copy(db.pageGetMapped(ptr).data, page)
+			copy(mappedPage(db.kv.mmap.chunks, ptr).data, page)
 		}
 	}
 	return nil
 }
 
-func syncPages(db *KVTX) error {
-	if err := db.kv.fp.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
+// checks the file is a DB of a known layout before mapping it,
+// returns the format version, 0 for an empty file
+func headerCheck(fp *os.File) (uint32, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat: %w", err)
 	}
-	db.kv.page.flushed += uint64(db.page.nappend)
-	db.page.updates = map[uint64][]byte{}
-
-	if err := masterStore(db.kv); err != nil {
-		return err
+	if fi.Size() == 0 {
+		return 0, nil
 	}
-	if err := db.kv.fp.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
+	var data [MASTER_SIZE]byte
+	n, err := fp.ReadAt(data[:], 0)
+	if n < len(data) || !bytes.Equal([]byte(DB_SIG), data[:8]) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("read header: %w", err)
+		}
+		return 0, ErrNotDatabase
+	}
+	format := binary.LittleEndian.Uint32(data[72:])
+	if format == 0 {
+		return 1, nil // before the format field
+	}
+	if format > FORMAT_VERSION {
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, format)
+	}
+	if size := binary.LittleEndian.Uint32(data[76:]); size != BTREE_PAGE_SIZE {
+		return 0, fmt.Errorf("unsupported page size %d", size)
+	}
+	return format, nil
+}
+
+// upgrades a file of an older format one version at a time, the master
+// page is rewritten in the current format by the next checkpoint
+func formatMigrate(db *KV, format uint32) error {
+	for ; format != 0 && format < FORMAT_VERSION; format++ {
+		switch format {
+		case 1:
+			// 1 -> 2: only adds the format & page size to the master page
+		case 2:
+			// 2 -> 3: a leading byte for each value, rewritten by the DB
+			db.format = 2
+		default:
+			return fmt.Errorf("%w %d", ErrUnsupportedVersion, format)
+		}
 	}
 	return nil
 }
@@ -201,13 +425,23 @@ func masterLoad(db *KV) error {
 	data := db.mmap.chunks[0]
 	root := binary.LittleEndian.Uint64(data[8:])
 	pagesUsed := binary.LittleEndian.Uint64(data[16:])
-	freeListPtr := binary.LittleEndian.Uint64(data[24:])
+	free := FreeListData{
+		headPage: binary.LittleEndian.Uint64(data[24:]),
+		headSeq:  binary.LittleEndian.Uint64(data[32:]),
+		tailPage: binary.LittleEndian.Uint64(data[40:]),
+		tailSeq:  binary.LittleEndian.Uint64(data[48:]),
+	}
+	version := binary.LittleEndian.Uint64(data[56:])
+	flags := binary.LittleEndian.Uint64(data[64:])
 
 	if !bytes.Equal([]byte(DB_SIG), data[:8]) {
 		return errors.New("bad signature")
 	}
 	isBad := 1 > pagesUsed || pagesUsed > uint64(db.mmap.file/BTREE_PAGE_SIZE)
 	isBad = isBad || (root >= pagesUsed)
+	isBad = isBad || free.headPage >= pagesUsed || free.tailPage >= pagesUsed
+	isBad = isBad || free.headSeq > free.tailSeq
+	isBad = isBad || flags&^MASTER_CHECKSUMS != 0
 
 	if isBad {
 		return errors.New("bad master page")
@@ -215,25 +449,48 @@ func masterLoad(db *KV) error {
 
 	db.tree.root = root
 	db.page.flushed = pagesUsed
-	db.free.head = freeListPtr
+	if free.tailPage == 0 {
+		// written before the free list had both ends, start with an empty one
+		free = FreeListData{}
+	}
+	db.free = free
+	db.version = version
+	db.flags = flags
 	return nil
 }
 
 func masterStore(db *KV) error {
-	var data [32]byte
-	copy(data[:8], []byte(DB_SIG))
-	binary.LittleEndian.PutUint64(data[8:16], db.tree.root)
-	binary.LittleEndian.PutUint64(data[16:24], db.page.flushed)
-	binary.LittleEndian.PutUint64(data[24:32], db.free.head)
+	data := masterEncode(masterState{
+		version: db.version,
+		root:    db.tree.root,
+		used:    db.page.flushed,
+		free:    db.free,
+	}, db.flags, db.format)
 	// Pwrite ensures that updating the page is atomic
-	_, err := pwriteFile(db.fp.Fd(), data[:], 0)
+	_, err := db.files().WriteAt(db.fp, data[:], 0)
 	if err != nil {
 		return fmt.Errorf("write master page: %w", err)
 	}
 	return nil
 }
 
-func mmapInit(fp *os.File) (int, []byte, error) {
+func masterEncode(master masterState, flags uint64, format uint32) [MASTER_SIZE]byte {
+	var data [MASTER_SIZE]byte
+	copy(data[:8], []byte(DB_SIG))
+	binary.LittleEndian.PutUint64(data[8:16], master.root)
+	binary.LittleEndian.PutUint64(data[16:24], master.used)
+	binary.LittleEndian.PutUint64(data[24:32], master.free.headPage)
+	binary.LittleEndian.PutUint64(data[32:40], master.free.headSeq)
+	binary.LittleEndian.PutUint64(data[40:48], master.free.tailPage)
+	binary.LittleEndian.PutUint64(data[48:56], master.free.tailSeq)
+	binary.LittleEndian.PutUint64(data[56:64], master.version)
+	binary.LittleEndian.PutUint64(data[64:72], flags)
+	binary.LittleEndian.PutUint32(data[72:76], format)
+	binary.LittleEndian.PutUint32(data[76:80], BTREE_PAGE_SIZE)
+	return data
+}
+
+func mmapInit(fp *os.File, prot int) (int, []byte, error) {
 	fi, err := fp.Stat()
 	if err != nil {
 		return 0, nil, fmt.Errorf("stat: %w", err)
@@ -249,7 +506,7 @@ func mmapInit(fp *os.File) (int, []byte, error) {
 	}
 
 	// maps the file data into the process's virtual address space
-	chunk, err := mmapFile(fp.Fd(), 0, mmapSize, PROT_READ|PROT_WRITE, MAP_SHARED)
+	chunk, err := mmapFile(fp.Fd(), 0, mmapSize, prot, MAP_SHARED)
 	if err != nil {
 		return 0, nil, fmt.Errorf("mmap: %w", err)
 	}
@@ -258,16 +515,15 @@ func mmapInit(fp *os.File) (int, []byte, error) {
 }
 
 func extendMmap(db *KV, npages int) error {
-	if db.mmap.total >= npages*BTREE_PAGE_SIZE {
-		return nil
-	}
-
-	chunk, err := mmapFile(db.fp.Fd(), int64(db.mmap.total), db.mmap.total, PROT_READ|PROT_WRITE, MAP_SHARED)
-	if err != nil {
-		return fmt.Errorf("mmap: %w", err)
+	// double the address space until it fits, a commit can more than double the file
+	for db.mmap.total < npages*BTREE_PAGE_SIZE {
+		chunk, err := mmapFile(db.fp.Fd(), int64(db.mmap.total), db.mmap.total, PROT_READ|PROT_WRITE, MAP_SHARED)
+		if err != nil {
+			return fmt.Errorf("mmap: %w", err)
+		}
+		db.mmap.total += db.mmap.total
+		db.mmap.chunks = append(db.mmap.chunks, chunk)
 	}
-	db.mmap.total += db.mmap.total
-	db.mmap.chunks = append(db.mmap.chunks, chunk)
 	return nil
 }
 
@@ -285,21 +541,42 @@ func extendFile(db *KV, npages int) error {
 		filePages += inc
 	}
 
+	// the pages are written through the mmap, a page without its blocks
+	// would fail the write with a SIGBUS once the disk is full
 	fileSize := filePages * BTREE_PAGE_SIZE
-	err := fallocateFile(db.fp.Fd(), 0, 0)
+	err := db.files().Allocate(db.fp, int64(db.mmap.file), int64(fileSize-db.mmap.file))
 	if err != nil {
-		// Fallback to truncate
-		err = db.fp.Truncate(int64(fileSize))
-		if err != nil {
-			return fmt.Errorf("fallocate: %w", err)
-		}
+		return fmt.Errorf("fallocate: %w", err)
 	}
 	db.mmap.file = fileSize
 	return nil
 }
 
-// callbacks for BTree & Freelist, dereference a pointer
+// callbacks for BTree, the pages of the transaction are kept in memory
 func (db *KVTX) pageGet(ptr uint64) BNode {
+	if ptr&TX_PAGE != 0 {
+		return BNode{db.page.updates[ptr]}
+	}
+	return db.pageGetMapped(ptr)
+}
+
+func (db *KVTX) pageNew(node BNode) uint64 {
+	assert(len(node.data) <= BTREE_PAGE_SIZE)
+	ptr := db.page.next
+	db.page.next++
+	db.page.updates[ptr] = node.data
+	return ptr
+}
+
+// the pages of the snapshot are freed by the commit
+func (db *KVTX) pageDel(ptr uint64) {
+	if ptr&TX_PAGE != 0 {
+		delete(db.page.updates, ptr)
+	}
+}
+
+// callbacks for BTree & Freelist, dereference a pointer
+func (db *kvWriter) pageGet(ptr uint64) BNode {
 	if page, ok := db.page.updates[ptr]; ok {
 		return BNode{page}
 	}
@@ -307,7 +584,7 @@ func (db *KVTX) pageGet(ptr uint64) BNode {
 }
 
 // callback for BTree, allocate a new page
-func (db *KVTX) pageNew(node BNode) uint64 {
+func (db *kvWriter) pageNew(node BNode) uint64 {
 	assert(len(node.data) <= BTREE_PAGE_SIZE)
 	ptr := db.free.Pop()
 	if ptr == 0 {
@@ -317,13 +594,58 @@ func (db *KVTX) pageNew(node BNode) uint64 {
 	return ptr
 }
 
-func (db *KVTX) pageDel(ptr uint64) {
+func (db *kvWriter) pageDel(ptr uint64) {
 	db.page.updates[ptr] = nil
 }
 
 func (db *KVReader) pageGetMapped(ptr uint64) BNode {
+	if node, ok := db.cache.get(ptr); ok {
+		return node
+	}
+	node := db.pageRead(ptr)
+	db.cache.add(ptr, node)
+	return node
+}
+
+func (db *KVReader) pageRead(ptr uint64) BNode {
+	db.metrics.pageRead()
+	if db.mem != nil {
+		page, ok := db.mem.get(ptr)
+		if !ok {
+			panic("bad ptr")
+		}
+		return BNode{page}
+	}
+	page, ok := db.logged[ptr]
+	if !ok {
+		page = mappedPage(db.mmap.chunks, ptr).data
+	}
+	if db.checksums {
+		if err := pageCheck(page, ptr); err != nil {
+			panic(err)
+		}
+	}
+	return BNode{page}
+}
+
+// the page as stored, without checking it
+func (db *KVReader) pageRaw(ptr uint64) []byte {
+	if db.mem != nil {
+		if page, ok := db.mem.get(ptr); ok {
+			return page
+		}
+		// allocated & freed before it was written
+		return make([]byte, BTREE_PAGE_SIZE)
+	}
+	if page, ok := db.logged[ptr]; ok {
+		return page
+	}
+	return mappedPage(db.mmap.chunks, ptr).data
+}
+
+func mappedPage(chunks [][]byte, ptr uint64) BNode {
 	start := uint64(0)
-	for _, chunk := range db.mmap.chunks {
+	for _, chunk := range chunks {
 		end := start + uint64(len(chunk))/BTREE_PAGE_SIZE
 		if ptr < end {
 			offset := BTREE_PAGE_SIZE * (ptr - start)
@@ -335,7 +657,7 @@ func (db *KVReader) pageGetMapped(ptr uint64) BNode {
 }
 
 // callback for Freelist, allocate new page
-func (db *KVTX) pageAppend(node BNode) uint64 {
+func (db *kvWriter) pageAppend(node BNode) uint64 {
 	assert(len(node.data) <= BTREE_PAGE_SIZE)
 	ptr := uint64(db.page.nappend) + db.kv.page.flushed
 	db.page.nappend++
@@ -343,6 +665,6 @@ func (db *KVTX) pageAppend(node BNode) uint64 {
 	return ptr
 }
 
-func (db *KVTX) pageUse(ptr uint64, node BNode) {
+func (db *kvWriter) pageUse(ptr uint64, node BNode) {
 	db.page.updates[ptr] = node.data
 }
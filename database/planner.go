@@ -0,0 +1,295 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// a condition of a query: the column compared to a value
+type Cond struct {
+	Col string
+	Cmp int // CMP_EQ, CMP_NE, CMP_PREFIX, CMP_GE, CMP_GT, CMP_LT or CMP_LE
+	Val Value
+}
+
+func (c Cond) expr() *Expr {
+	return CompareExpr(ColumnExpr(c.Col), c.Cmp, LiteralExpr(c.Val))
+}
+
+// the access path chosen for the conditions of a query
+type QueryPlan struct {
+	Table   string
+	IndexNo int      // -1: the primary key; >= 0: an index
+	Index   []string // the columns of the primary key or the index
+	Eq      int      // the leading columns of Index with an equal value
+	Range   bool     // a range of the column after them
+	Filter  *Expr    // the conditions the range does not cover, nil for none
+	Scanner Scanner  // the range & the filter, for Scan
+}
+
+// nothing is bound, every row of the table is read
+func (p *QueryPlan) Full() bool {
+	return p.Eq == 0 && !p.Range
+}
+
+func (p *QueryPlan) String() string {
+	path := "primary key"
+	if p.IndexNo >= 0 {
+		path = "index"
+	}
+	var b strings.Builder
+	if p.Full() {
+		fmt.Fprintf(&b, "full scan of %s by %s (%s)", p.Table, path, strings.Join(p.Index, ", "))
+	} else {
+		fmt.Fprintf(&b, "scan of %s by %s (%s)", p.Table, path, strings.Join(p.Index, ", "))
+		var bound []string
+		if p.Eq > 0 {
+			bound = append(bound, "equal "+strings.Join(p.Index[:p.Eq], ", "))
+		}
+		if p.Range {
+			bound = append(bound, "range of "+p.Index[p.Eq])
+		}
+		fmt.Fprintf(&b, ": %s", strings.Join(bound, ", "))
+	}
+	if p.Filter != nil {
+		fmt.Fprintf(&b, ", filter %v", p.Filter)
+	}
+	return b.String()
+}
+
+// choose the primary key or the index binding the most leading columns with
+// the conditions: the equal ones first, then a range of the next column, a
+// prefix of a string being the range of the keys starting with it. once the
+// table is analyzed, the one with the fewest estimated rows is chosen
+// instead. the rest of the conditions filter the rows, nothing bound is a
+// full scan.
+//
+// Deprecated: use DBTX.Plan or DBReader.Plan.
+func (db *DB) Plan(table string, conds []Cond, tree *BTree) (*QueryPlan, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	stats, err := planStats(tdef, conds, tree)
+	if err != nil {
+		return nil, err
+	}
+	return planConds(tdef, conds, nil, stats)
+}
+
+// the statistics of the table when there is a choice of the path, see Analyze
+func planStats(tdef *TableDef, conds []Cond, tree *BTree) (*TableAnalysis, error) {
+	if len(conds) == 0 || len(tdef.Indexes) == 0 || tdef.internal() {
+		return nil, nil
+	}
+	return analysisGet(tree, tdef)
+}
+
+// the plan of the conditions, the other expressions are added to the filter.
+// the path reading the fewest estimated rows is chosen with the statistics.
+func planConds(tdef *TableDef, conds []Cond, rest []*Expr, stats *TableAnalysis) (*QueryPlan, error) {
+	all := append([]*Expr{}, rest...)
+	for _, c := range conds {
+		all = append(all, c.expr())
+	}
+	if len(all) > 0 {
+		if _, err := checkExpr(tdef, AndExpr(all...)); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &QueryPlan{}
+	var used []bool
+	best, bestRows := -1, -1.0
+	for i := -1; i < len(tdef.Indexes); i++ {
+		index := tdef.Cols[:tdef.PKeys]
+		if i >= 0 {
+			index = tdef.Indexes[i]
+		}
+		eqs, lo, hi, u := planIndex(tdef, index, conds)
+		// an equal column beats any range, the primary key wins a tie & is
+		// the best for a single row
+		score := 2 * len(eqs)
+		if lo >= 0 || hi >= 0 {
+			score++
+		}
+		if i < 0 && len(eqs) == len(index) {
+			score = math.MaxInt
+		}
+		rows := -1.0
+		if stats != nil && stats.Column(index[0]) != nil {
+			var bound []Cond
+			for c := range conds {
+				if u[c] {
+					bound = append(bound, conds[c])
+				}
+			}
+			rows = stats.estimate(tdef, bound)
+		}
+		if score == math.MaxInt || best == math.MaxInt || rows < 0 || bestRows < 0 {
+			if score <= best {
+				continue
+			}
+		} else if rows > bestRows || (rows == bestRows && score <= best) {
+			continue
+		}
+		best, bestRows, used = score, rows, u
+		*plan = QueryPlan{Table: tdef.Name, IndexNo: i, Index: index, Eq: len(eqs), Range: lo >= 0 || hi >= 0}
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+		for _, c := range eqs {
+			v := keyValue(tdef, conds[c])
+			sc.Key1.Cols, sc.Key1.Vals = append(sc.Key1.Cols, conds[c].Col), append(sc.Key1.Vals, v)
+			sc.Key2.Cols, sc.Key2.Vals = append(sc.Key2.Cols, conds[c].Col), append(sc.Key2.Vals, v)
+		}
+		planRange(tdef, &sc, index, len(eqs), conds, lo, hi)
+		plan.Scanner = sc
+	}
+
+	for i, c := range conds {
+		if !used[i] {
+			rest = append(rest, c.expr())
+		}
+	}
+	switch len(rest) {
+	case 0:
+	case 1:
+		plan.Filter = rest[0]
+	default:
+		plan.Filter = AndExpr(rest...)
+	}
+	plan.Scanner.Filter = plan.Filter
+	// the index Scan picks for the columns of Key1 binds the same columns
+	if no, err := findIndex(tdef, plan.Scanner.Key1.Cols); err == nil && no != plan.IndexNo {
+		plan.IndexNo, plan.Index = no, tdef.Cols[:tdef.PKeys]
+		if no >= 0 {
+			plan.Index = tdef.Indexes[no]
+		}
+	}
+	return plan, nil
+}
+
+// the conditions of the equal values of the leading columns of the index, of
+// a lower & an upper bound of the next column, -1 for none, and the
+// conditions used. a prefix is both bounds.
+func planIndex(tdef *TableDef, index []string, conds []Cond) ([]int, int, int, []bool) {
+	used := make([]bool, len(conds))
+	var eqs []int
+	for len(eqs) < len(index) {
+		i := findCond(tdef, conds, used, index[len(eqs)], CMP_EQ)
+		if i < 0 {
+			break
+		}
+		used[i] = true
+		eqs = append(eqs, i)
+	}
+	lo, hi := -1, -1
+	if eq := len(eqs); eq < len(index) {
+		if p := findCond(tdef, conds, used, index[eq], CMP_PREFIX); p >= 0 {
+			used[p] = true
+			return eqs, p, p, used
+		}
+		if lo = findCond(tdef, conds, used, index[eq], CMP_GE, CMP_GT); lo >= 0 {
+			used[lo] = true
+		}
+		if hi = findCond(tdef, conds, used, index[eq], CMP_LE, CMP_LT); hi >= 0 {
+			used[hi] = true
+		}
+	}
+	return eqs, lo, hi, used
+}
+
+// the first unused condition of the column with one of the comparisons that
+// can be a key value
+func findCond(tdef *TableDef, conds []Cond, used []bool, col string, cmps ...int) int {
+	for i, c := range conds {
+		if used[i] || c.Col != col || c.Val.Null {
+			continue
+		}
+		for _, cmp := range cmps {
+			if c.Cmp == cmp && keyable(tdef, c) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// the value fits in the key of the column
+func keyable(tdef *TableDef, c Cond) bool {
+	typ := tdef.Types[ColIndex(tdef, c.Col)]
+	switch {
+	case c.Val.Type == typ:
+		return true
+	case typ == TYPE_INT32 && c.Val.Type == TYPE_INT64:
+		return c.Val.I64 >= math.MinInt32 && c.Val.I64 <= math.MaxInt32
+	default:
+		return typ == TYPE_INT64 && c.Val.Type == TYPE_INT32
+	}
+}
+
+// the value of the condition with the type of the column
+func keyValue(tdef *TableDef, c Cond) Value {
+	v := c.Val
+	v.Type = tdef.Types[ColIndex(tdef, c.Col)]
+	return v
+}
+
+// the bounds of the column after the equal ones
+func planRange(tdef *TableDef, sc *Scanner, index []string, eq int, conds []Cond, lo int, hi int) {
+	if eq == len(index) {
+		return
+	}
+	col := index[eq]
+	// a NULL sorts first and is never in a range. Key1 also picks the index.
+	null := Value{Type: tdef.Types[ColIndex(tdef, col)], Null: true}
+	switch {
+	case lo >= 0 && conds[lo].Cmp == CMP_PREFIX:
+		v := keyValue(tdef, conds[lo])
+		sc.Key1.Cols, sc.Key1.Vals = append(sc.Key1.Cols, col), append(sc.Key1.Vals, v)
+		sc.Key2.Cols, sc.Key2.Vals = append(sc.Key2.Cols, col), append(sc.Key2.Vals, v)
+		sc.Cmp1, sc.Cmp2, sc.prefix = CMP_GE, CMP_LT, true
+		return
+	case lo >= 0:
+		sc.Key1.Cols, sc.Key1.Vals = append(sc.Key1.Cols, col), append(sc.Key1.Vals, keyValue(tdef, conds[lo]))
+		sc.Cmp1 = conds[lo].Cmp
+	case hi >= 0:
+		sc.Key1.Cols, sc.Key1.Vals = append(sc.Key1.Cols, col), append(sc.Key1.Vals, null)
+		sc.Cmp1 = CMP_GT
+	}
+	if hi >= 0 {
+		sc.Key2.Cols, sc.Key2.Vals = append(sc.Key2.Cols, col), append(sc.Key2.Vals, keyValue(tdef, conds[hi]))
+		sc.Cmp2 = conds[hi].Cmp
+	}
+}
+
+// split the ANDed comparisons of a column & a value off the expression
+func exprConds(e *Expr) ([]Cond, []*Expr) {
+	if e == nil {
+		return nil, nil
+	}
+	switch {
+	case e.Kind == EXPR_AND:
+		var conds []Cond
+		var rest []*Expr
+		for _, arg := range e.Args {
+			c, r := exprConds(arg)
+			conds, rest = append(conds, c...), append(rest, r...)
+		}
+		return conds, rest
+	case e.Kind == EXPR_CMP && e.Args[0].Kind == EXPR_COLUMN && e.Args[1].Kind == EXPR_LITERAL:
+		return []Cond{{Col: e.Args[0].Col, Cmp: e.Cmp, Val: e.Args[1].Val}}, nil
+	case e.Kind == EXPR_CMP && e.Args[0].Kind == EXPR_LITERAL && e.Args[1].Kind == EXPR_COLUMN:
+		// 3 < a is a > 3, = & != are the same both ways
+		cmp := e.Cmp
+		if cmp == CMP_PREFIX {
+			return nil, []*Expr{e}
+		}
+		if cmp != CMP_EQ && cmp != CMP_NE {
+			cmp = -cmp
+		}
+		return []Cond{{Col: e.Args[1].Col, Cmp: cmp, Val: e.Args[0].Val}}, nil
+	default:
+		return nil, []*Expr{e}
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "items",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "a", "b", "c"},
+		PKeys:   1,
+		Indexes: [][]string{{"a"}, {"a", "b"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	for id := int64(1); id <= 24; id++ {
+		rec := (&Record{}).AddInt64("id", id).AddInt64("a", id%3).AddInt64("b", id%4).AddStr("c", []byte(fmt.Sprint(id%2)))
+		if _, err := tx.Set("items", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	num := func(n int64) Value { return Value{Type: TYPE_INT64, I64: n} }
+	str := func(s string) Value { return Value{Type: TYPE_BYTES, Str: []byte(s)} }
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	for _, tt := range []struct {
+		conds []Cond
+		want  string
+		rows  string
+	}{
+		// the two-column index binds both
+		{[]Cond{{"b", CMP_EQ, num(2)}, {"a", CMP_EQ, num(1)}},
+			"scan of items by index (a, b, id): equal a, b", "[10 22]"},
+		{[]Cond{{"a", CMP_EQ, num(1)}},
+			"scan of items by index (a, id): equal a", "[1 4 7 10 13 16 19 22]"},
+		{[]Cond{{"a", CMP_EQ, num(1)}, {"b", CMP_GT, num(2)}},
+			"scan of items by index (a, b, id): equal a, range of b", "[7 19]"},
+		{[]Cond{{"id", CMP_GT, num(20)}, {"a", CMP_EQ, num(0)}},
+			"scan of items by index (a, id): equal a, range of id", "[21 24]"},
+		{[]Cond{{"id", CMP_LE, num(3)}, {"c", CMP_EQ, str("1")}},
+			"scan of items by primary key (id): range of id, filter c = \"1\"", "[1 3]"},
+		{[]Cond{{"id", CMP_EQ, num(5)}, {"a", CMP_EQ, num(2)}},
+			"scan of items by primary key (id): equal id, filter a = 2", "[5]"},
+		{[]Cond{{"b", CMP_EQ, num(3)}, {"a", CMP_NE, num(0)}},
+			"full scan of items by primary key (id), filter (b = 3) AND (a != 0)", "[7 11 19 23]"},
+		{nil, "full scan of items by primary key (id)", ""},
+	} {
+		plan, err := reader.Plan("items", tt.conds)
+		if err != nil {
+			t.Fatalf("failed to plan %v: %v", tt.conds, err)
+		}
+		if plan.String() != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.conds, tt.want, plan.String())
+		}
+		if tt.rows == "" {
+			continue
+		}
+
+		// Scan plans the same range from a filter without one
+		var exprs []*Expr
+		for _, c := range tt.conds {
+			exprs = append(exprs, c.expr())
+		}
+		for _, sc := range []Scanner{plan.Scanner, {Filter: AndExpr(exprs...)}} {
+			if err := reader.Scan("items", &sc); err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+			var ids []int64
+			for ; sc.Valid(); sc.Next() {
+				rec := Record{}
+				if err := sc.Deref(&rec, reader.Tree()); err != nil {
+					t.Fatalf("failed to deref: %v", err)
+				}
+				ids = append(ids, rec.Get("id").I64)
+			}
+			if got := fmt.Sprint(ids); got != tt.rows {
+				t.Errorf("%v: expected %s, got %s", tt.conds, tt.rows, got)
+			}
+			if sc.filter != plan.Filter && fmt.Sprint(sc.filter) != fmt.Sprint(plan.Filter) {
+				t.Errorf("%v: expected the filter %v, got %v", tt.conds, plan.Filter, sc.filter)
+			}
+		}
+	}
+
+	// a literal on the left
+	sc := Scanner{Filter: CompareExpr(LiteralExpr(num(22)), CMP_LT, ColumnExpr("id"))}
+	if err := reader.Scan("items", &sc); err != nil || sc.Count() != 2 || sc.filter != nil {
+		t.Errorf("expected 2 rows by the range of id, got %v, filter %v", err, sc.filter)
+	}
+	if _, err := reader.Plan("items", []Cond{{"a", CMP_EQ, str("x")}}); err == nil {
+		t.Errorf("expected a type mismatch")
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrefixMatch(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "words",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES, TYPE_INT64},
+		Cols:    []string{"id", "name", "tag", "n"},
+		PKeys:   1,
+		Indexes: [][]string{{"name"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	// the tag is the name without an index
+	names := []string{"", "a", "ab", "abc", "abd", "b", "日", "日本", "日本語", "本",
+		"\xff", "\xff\xff", "\xff\xff\x01", "\xfe\xff", "a\x00", "a\x00b", "a\x01", "a\xff"}
+	for i, name := range names {
+		rec := (&Record{}).AddInt64("id", int64(i)).AddStr("name", []byte(name)).AddStr("tag", []byte(name)).AddInt64("n", 0)
+		if _, err := tx.Set("words", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	rec := (&Record{}).AddInt64("id", 100).AddNull("name", TYPE_BYTES).AddNull("tag", TYPE_BYTES).AddInt64("n", 0)
+	if _, err := tx.Set("words", *rec, MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	// the names of the rows, in the order of the scan
+	scanned := func(sc *Scanner, col string) []string {
+		t.Helper()
+		var got []string
+		rec := Record{}
+		for ; sc.Valid(); sc.Next() {
+			if err := sc.Deref(&rec, tx.Tree()); err != nil {
+				t.Fatalf("failed to deref: %v", err)
+			}
+			got = append(got, string(rec.Get(col).Str))
+		}
+		if err := sc.Err(); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return got
+	}
+
+	for _, prefix := range []string{"ab", "a", "", "日本", "日", "\xff", "\xff\xff", "a\x00", "a\x01", "zz"} {
+		var want []string
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				want = append(want, name)
+			}
+		}
+		slices.Sort(want)
+
+		sc, err := tx.PrefixMatch("words", "name", []byte(prefix))
+		if err != nil {
+			t.Fatalf("%q: %v", prefix, err)
+		}
+		if sc.filter != nil {
+			t.Errorf("%q: expected a range of the index, got the filter %v", prefix, sc.filter)
+		}
+		if got := scanned(sc, "name"); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%q: expected %q, got %q", prefix, want, got)
+		}
+
+		// the same rows by the filter
+		sc, err = tx.PrefixMatch("words", "tag", []byte(prefix))
+		if err != nil {
+			t.Fatalf("%q: %v", prefix, err)
+		}
+		got := scanned(sc, "tag")
+		slices.Sort(got)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%q: expected %q by the filter, got %q", prefix, want, got)
+		}
+	}
+
+	// descending
+	sc := Scanner{Filter: CompareExpr(ColumnExpr("name"), CMP_PREFIX, LiteralExpr(Value{Type: TYPE_BYTES, Str: []byte("ab")})), Desc: true}
+	if err := tx.Scan("words", &sc); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if got := strings.Join(scanned(&sc, "name"), " "); got != "abd abc ab" {
+		t.Errorf("expected the names descending, got %q", got)
+	}
+	// the equal primary key beats the prefix of the index
+	plan, err := db.Plan("words", []Cond{
+		{Col: "id", Cmp: CMP_EQ, Val: Value{Type: TYPE_INT64, I64: 2}},
+		{Col: "name", Cmp: CMP_PREFIX, Val: Value{Type: TYPE_BYTES, Str: []byte("a")}},
+		{Col: "tag", Cmp: CMP_PREFIX, Val: Value{Type: TYPE_BYTES, Str: []byte("a")}},
+	}, tx.Tree())
+	if err != nil || plan.Eq != 1 || plan.Range || plan.Filter == nil {
+		t.Errorf("expected the primary key with a filter of the prefixes, got %v %v", plan, err)
+	}
+
+	// EXPLAIN warns of a prefix that is a filter
+	sc1, err := tx.PrefixMatch("words", "name", []byte("a"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	explain, err := tx.Explain("words", sc1)
+	if err != nil || explain.Filter != nil || len(explain.Warnings) != 0 {
+		t.Errorf("expected a range without warnings, got %v %v", explain, err)
+	}
+	sc2, err := tx.PrefixMatch("words", "tag", []byte("a"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	explain, err = tx.Explain("words", sc2)
+	if err != nil || len(explain.Warnings) != 1 || !strings.Contains(explain.String(), "warning: tag starts with \"a\"") {
+		t.Errorf("expected a warning of the filter, got %v %v", explain, err)
+	}
+
+	if _, err := tx.PrefixMatch("words", "n", []byte("a")); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+	if _, err := tx.PrefixMatch("words", "color", []byte("a")); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	if err := tx.Scan("words", &Scanner{Cmp1: CMP_PREFIX, Cmp2: CMP_LE}); !errors.Is(err, ErrBadRange) {
+		t.Errorf("expected ErrBadRange, got %v", err)
+	}
+
+	if got := keySuccessor([]byte{0, 1, 'a', 0xff, 0xff}); fmt.Sprintf("%x", got) != "000162" {
+		t.Errorf("unexpected successor %x", got)
+	}
+	db.Abort(&tx)
+}
@@ -2,7 +2,12 @@ package database
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 )
 
 const (
@@ -12,39 +17,308 @@ const (
 	CMP_LE = -3 // <=
 )
 
-// the iterator for range queries
+// returned when a secondary index entry points at a primary row that does not exist
+var ErrDanglingIndex error = errors.New("dangling index entry")
+
+// returned when the tree was updated while an iterator over it was open
+var ErrIterInvalidated error = errors.New("iterator invalidated by a tree update")
+
+// the iterator for range queries, of one goroutine
 type Scanner struct {
-	// the range, from Key1 to Key2
+	// the range, from Key1 to Key2. the bounds name the leading columns
+	// of an index in its order, their values are of the leading columns
+	// with the types of the columns. the columns of one bound are a prefix
+	// of the ones of the other, a shorter or an empty bound leaves the rest
+	// of the columns open.
 	db      *DB
 	indexNo int // -1: use primary key; >= 0: use an index
 	Cmp1    int
 	Cmp2    int
 	Key1    Record
 	Key2    Record
+	Limit   int      // max number of rows to return, 0: unlimited
+	Desc    bool     // iterate from Key2 down to Key1
+	Project []string // columns to return, nil: all columns
+	// resume after this token from Position(), instead of from the start key
+	StartAfter []byte
+	// skip the rows it is not true for, checked against the table by Scan.
+	// with Cmp1 & Cmp2 of 0, Scan plans the range from its conditions.
+	Filter *Expr
+	// skip the rows equal on the columns to a row returned before, the Limit
+	// is of the distinct rows
+	DistinctCols []string
 	// internal
+	ctx      context.Context // of SetContext
+	tx       *DBTX           // of DBTX.Scan, the iteration stops once it ended
 	tdef     *TableDef
+	count    int    // rows returned so far
+	desc     bool   // the effective direction
 	iter     *BIter // underlying BTree iterator
-	keyEnd   []byte // the encoded Key2
-	keyStart []byte // the encoded Key2
+	keyStart []byte // the encoded key where the scan begins
+	keyEnd   []byte // the encoded key where the scan stops
+	cmpStart int    // comparison against keyStart
+	cmpEnd   int    // comparison against keyEnd
+	proj     []int  // column indexes of Project
+	covering bool   // the index contains every projected column
+	tree     *BTree // of the scan, for the primary rows of the filter
+	err      error  // of reading a row for the filter
+	filter   *Expr  // the part of Filter the range does not cover
+	planned  bool   // the range is the plan of Filter
+	prefix   bool   // the last value of Key1 & Key2 is a prefix of the string
+	distinct *distinctSet
+	vals     []Value // the scratch row of a projection, see rowValues
+	ivals    []Value // the values of the index key
+	pkey     []byte  // the primary key of an index entry
+	examined int64   // the keys of the range read, see Stats
+	returned int64   // those the filter & DistinctCols kept
 }
 
+// Deprecated: use DBTX.Scan or DBReader.Scan.
 func (db *DB) Scan(table string, req *Scanner, tree *BTree) error {
 	tdef := GetTableDef(db, table, tree)
 	if tdef == nil {
-		return fmt.Errorf("table not found: %s", table)
+		return tableNotFound(table)
 	}
 	return dbScan(db, tdef, req, tree)
 }
 
+// count the rows in the range using only key comparisons, the rows are
+// decoded only for a Filter
+//
+// Deprecated: use DBTX.Count or DBReader.Count.
+func (db *DB) Count(table string, req *Scanner, tree *BTree) (int64, error) {
+	if err := db.Scan(table, req, tree); err != nil {
+		return 0, err
+	}
+	return req.Count(), nil
+}
+
+// scan every row of the table in primary key order
+//
+// Deprecated: use DBTX.ScanAll or DBReader.ScanAll.
+func (db *DB) ScanAll(table string, tree *BTree) (*Scanner, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	sc := &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, tdef, sc, tree)
+	return sc, nil
+}
+
+// scan the rows whose key starts with the columns of `prefix`,
+// the columns must be a prefix of the primary key or an index
+//
+// Deprecated: use DBTX.ScanPrefix or DBReader.ScanPrefix.
+func (db *DB) ScanPrefix(table string, prefix Record, tree *BTree) (*Scanner, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	if _, err := findIndex(tdef, prefix.Cols); err != nil {
+		return nil, fmt.Errorf("columns %v are not a prefix of the primary key or any index", prefix.Cols)
+	}
+	sc := &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: prefix,
+		Key2: prefix,
+	}
+	if err := dbScan(db, tdef, sc, tree); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// scan the rows whose string column starts with the prefix, a range of the
+// primary key or the index the column leads, else a filter of every row
+//
+// Deprecated: use DBTX.PrefixMatch or DBReader.PrefixMatch.
+func (db *DB) PrefixMatch(table string, col string, prefix []byte, tree *BTree) (*Scanner, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	idx := ColIndex(tdef, col)
+	if idx < 0 {
+		return nil, columnNotFound(tdef.Name, col)
+	}
+	if tdef.Types[idx] != TYPE_BYTES {
+		return nil, fmt.Errorf("%w: a prefix of %s, %s is %s", ErrTypeMismatch, col, col, typeName(tdef.Types[idx]))
+	}
+	sc := &Scanner{Filter: CompareExpr(ColumnExpr(col), CMP_PREFIX, LiteralExpr(Value{Type: TYPE_BYTES, Str: prefix}))}
+	if err := dbScan(db, tdef, sc, tree); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// the first key after every key starting with the key, the last byte below
+// 0xff incremented. the type byte of a value is never 0xff.
+func keySuccessor(key []byte) []byte {
+	n := len(key)
+	for key[n-1] == 0xff {
+		n--
+	}
+	out := append([]byte{}, key[:n]...)
+	out[n-1]++
+	return out
+}
+
+// the Next calls of MultiGet toward the next key before it seeks again
+const MULTIGET_STEPS = 16
+
+// get the rows of the primary keys, in the order of the keys. the keys are
+// looked up in key order with one iterator, close keys are reached with Next
+// instead of a seek from the root. found tells which rows exist.
+//
+// Deprecated: use DBTX.MultiGet or DBReader.MultiGet.
+func (db *DB) MultiGet(table string, keys []Record, tree *BTree) ([]Record, []bool, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, nil, tableNotFound(table)
+	}
+	encoded := make([][]byte, len(keys))
+	for i, rec := range keys {
+		key, err := rowKey(tdef, rec)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded[i] = key
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return bytes.Compare(encoded[order[i]], encoded[order[j]]) < 0
+	})
+
+	rows, found := make([]Record, len(keys)), make([]bool, len(keys))
+	var iter *BIter
+	for _, i := range order {
+		key := encoded[i]
+		near := false
+		for step := 0; iter != nil && iter.Valid() && step <= MULTIGET_STEPS; step++ {
+			if cur, _ := iter.Deref(); bytes.Compare(cur, key) >= 0 {
+				near = true
+				break
+			}
+			iter.Next()
+		}
+		if !near {
+			iter = tree.SeekLE(key) // far from the previous key
+		}
+		if !iter.Valid() {
+			continue
+		}
+		if cur, val := iter.Deref(); bytes.Equal(cur, key) {
+			rows[i], found[i] = rowDecode(tdef, cur, val), true
+		}
+	}
+	return rows, found, nil
+}
+
+func dbScanAll(db *DB, tdef *TableDef, req *Scanner, tree *BTree) {
+	pk := tdef.Cols[:tdef.PKeys]
+
+	req.db = db
+	req.tdef = tdef
+	req.indexNo = -1
+	req.count = 0
+	req.desc = false
+	req.proj = nil
+	req.tree, req.err, req.filter, req.distinct = tree, nil, nil, nil
+	req.examined, req.returned = 0, 0
+	// the range covers the whole key space of the table prefix
+	req.keyStart = encodeKeyPartial(nil, tdef.Prefix, nil, tdef, pk, CMP_GE)
+	req.keyEnd = encodeKeyPartial(nil, tdef.Prefix, nil, tdef, pk, CMP_LE)
+	req.cmpStart, req.cmpEnd = CMP_GE, CMP_LE
+	req.seek(tree)
+	req.skip()
+}
+
 func dbScan(db *DB, tdef *TableDef, req *Scanner, tree *BTree) error {
+	if err := scanSetup(db, tdef, req, tree); err != nil {
+		return err
+	}
+	req.seek(tree)
+	req.skip()
+	return nil
+}
+
+// check the columns & the values of the bounds, see Scanner. the longer
+// bound picks the index.
+func scanIndex(tdef *TableDef, key1, key2 Record) (int, error) {
+	for _, key := range []Record{key1, key2} {
+		if len(key.Vals) > len(key.Cols) {
+			return 0, fmt.Errorf("%w: a bound with %d columns & %d values", ErrBadRange, len(key.Cols), len(key.Vals))
+		}
+		for i, col := range key.Cols {
+			idx := ColIndex(tdef, col)
+			if idx < 0 {
+				return 0, columnNotFound(tdef.Name, col)
+			}
+			if i >= len(key.Vals) {
+				continue
+			}
+			if v := key.Vals[i]; v.Type != tdef.Types[idx] {
+				return 0, fmt.Errorf("%w: %s is %s, %s is %s", ErrTypeMismatch,
+					col, typeName(tdef.Types[idx]), formatValue(v), typeName(v.Type))
+			}
+		}
+	}
+	long, short := key1.Cols, key2.Cols
+	if len(short) > len(long) {
+		long, short = short, long
+	}
+	if !isPrefix(long, short) {
+		return 0, fmt.Errorf("%w: the bounds (%s) & (%s) are not on the same columns",
+			ErrBadRange, strings.Join(key1.Cols, ","), strings.Join(key2.Cols, ","))
+	}
+	return findIndex(tdef, long)
+}
+
+// check the request & choose the path, the bounds & the direction of the
+// scan, without reading the rows. shared by dbScan & Explain.
+func scanSetup(db *DB, tdef *TableDef, req *Scanner, tree *BTree) error {
+	req.tx = nil // set by DBTX.Scan
+	req.filter = req.Filter
+	if req.planned || (req.Cmp1 == 0 && req.Cmp2 == 0) {
+		conds, rest := exprConds(req.Filter)
+		stats, err := planStats(tdef, conds, tree)
+		if err != nil {
+			return err
+		}
+		plan, err := planConds(tdef, conds, rest, stats)
+		if err != nil {
+			return err
+		}
+		req.Cmp1, req.Cmp2 = plan.Scanner.Cmp1, plan.Scanner.Cmp2
+		req.Key1, req.Key2 = plan.Scanner.Key1, plan.Scanner.Key2
+		req.filter, req.planned, req.prefix = plan.Filter, true, plan.Scanner.prefix
+	} else {
+		req.prefix = false
+	}
 	// sanity checks
+	desc := req.Desc
 	switch {
+	case req.Cmp1 >= CMP_EQ || req.Cmp1 <= CMP_NE || req.Cmp2 >= CMP_EQ || req.Cmp2 <= CMP_NE:
+		return fmt.Errorf("%w: CMP_EQ, CMP_NE & CMP_PREFIX are for an Expr", ErrBadRange)
 	case req.Cmp1 > 0 && req.Cmp2 < 0:
 	case req.Cmp2 > 0 && req.Cmp1 < 0:
+		// Key1 is the upper bound, the scan is descending
+		if req.Desc {
+			return fmt.Errorf("%w: Desc requires Key1 to be the lower bound", ErrBadRange)
+		}
+		desc = true
 	default:
-		return fmt.Errorf("bad range")
+		return ErrBadRange
+	}
+	if req.Limit < 0 {
+		return fmt.Errorf("bad limit: %d", req.Limit)
 	}
-	indexNo, err := findIndex(tdef, req.Key1.Cols)
+	indexNo, err := scanIndex(tdef, req.Key1, req.Key2)
 	if err != nil {
 		return err
 	}
@@ -57,97 +331,369 @@ func dbScan(db *DB, tdef *TableDef, req *Scanner, tree *BTree) error {
 
 	req.tdef = tdef
 	req.indexNo = indexNo
-	// seek to the start key
-	req.keyStart = encodeKeyPartial(nil, prefix, req.Key1.Vals, tdef, index, req.Cmp1)
-	req.keyEnd = encodeKeyPartial(nil, prefix, req.Key2.Vals, tdef, index, req.Cmp2)
-	req.iter = tree.Seek(req.keyStart, req.Cmp1)
+	req.count = 0
+	req.desc = desc
+	req.proj = nil
+	if len(req.Project) > 0 {
+		if err := req.resolveProject(); err != nil {
+			return err
+		}
+	}
+	if req.Filter != nil {
+		typ, err := checkExpr(tdef, req.Filter)
+		if err != nil {
+			return err
+		}
+		if typ != TYPE_BOOL && typ != 0 {
+			return fmt.Errorf("%w: the filter %v is %s, not bool", ErrTypeMismatch, req.Filter, typeName(typ))
+		}
+	}
+	req.tree, req.err = tree, nil
+	req.examined, req.returned = 0, 0
+	if err := distinctSetup(db, tdef, req); err != nil {
+		return err
+	}
+	key1 := encodeKeyPartial(nil, prefix, req.Key1.Vals, tdef, index, req.Cmp1)
+	key2 := encodeKeyPartial(nil, prefix, req.Key2.Vals, tdef, index, req.Cmp2)
+	if req.prefix {
+		// the string without its end, up to the next key after the keys
+		// starting with it
+		key1 = key1[:len(key1)-1]
+		key2 = keySuccessor(key1)
+	}
+	req.keyStart, req.cmpStart = key1, req.Cmp1
+	req.keyEnd, req.cmpEnd = key2, req.Cmp2
+	if req.Desc {
+		// start from the upper bound
+		req.keyStart, req.cmpStart = key2, req.Cmp2
+		req.keyEnd, req.cmpEnd = key1, req.Cmp1
+	}
+	if req.StartAfter != nil {
+		if !bytes.HasPrefix(req.StartAfter, key1[:4]) {
+			return fmt.Errorf("bad resume token")
+		}
+		// a token before the start key resumes from the start key
+		if cmpOK(req.StartAfter, req.cmpStart, req.keyStart) {
+			req.keyStart = req.StartAfter
+			req.cmpStart = CMP_GT
+			if req.desc {
+				req.cmpStart = CMP_LT
+			}
+		}
+	}
 	return nil
 }
 
-func (sc *Scanner) Valid() bool {
+// seek to the start key
+func (sc *Scanner) seek(tree *BTree) {
+	sc.iter = tree.Seek(sc.keyStart, sc.cmpStart)
 	if !sc.iter.Valid() {
-		return false
+		return
 	}
+	// the seek stops short of the start key when no key satisfies it.
+	// past this point the iterator only moves away from the start key,
+	// so Valid() only has to check the far bound.
 	key, _ := sc.iter.Deref()
+	if !cmpOK(key, sc.cmpStart, sc.keyStart) {
+		sc.iter = &BIter{}
+	}
+}
 
-	// First check if we've reached the end of valid keys
-	if bytes.Compare(key, sc.keyEnd) > 0 {
-		return false
+func (sc *Scanner) Valid() bool {
+	if sc.tx != nil {
+		if sc.tx.enter() != nil {
+			return false
+		}
+		defer sc.tx.mu.Unlock()
 	}
+	return sc.valid()
+}
 
-	// Check if we're still within range
-	if bytes.Compare(key, sc.keyStart) < 0 {
+func (sc *Scanner) valid() bool {
+	if sc.err != nil {
 		return false
 	}
-
-	return true
+	if sc.Limit > 0 && sc.count >= sc.Limit {
+		return false
+	}
+	if !sc.iter.Valid() {
+		return false
+	}
+	key, _ := sc.iter.Deref()
+	// check if we've reached the end of the range
+	return cmpOK(key, sc.cmpEnd, sc.keyEnd)
 }
 
 func (sc *Scanner) Next() {
-	if !sc.iter.Valid() {
+	if sc.tx != nil {
+		if sc.tx.enter() != nil {
+			return
+		}
+		defer sc.tx.mu.Unlock()
+	}
+	if !sc.valid() {
 		return
 	}
+	sc.count++
+	sc.advance()
+	sc.skip()
+}
 
-	currentKey, _ := sc.iter.Deref()
-	sc.iter.Next()
+// move the iterator to the next key of the direction
+func (sc *Scanner) advance() {
+	if sc.desc {
+		sc.iter.Prev()
+	} else {
+		sc.iter.Next()
+	}
+}
 
-	// If after moving Next(), we get the same key or invalid iterator,
-	// we've reached the end of valid data
-	if !sc.iter.Valid() {
+// move past the rows the filter is not true for & the duplicates of the rows
+// returned before, counting the rows read for Stats & DB.Measure
+func (sc *Scanner) skip() {
+	for sc.valid() {
+		if sc.examined > 0 && sc.examined%CTX_CHECK_ROWS == 0 {
+			if err := sc.ctxErr(); err != nil {
+				sc.err = err
+				return
+			}
+		}
+		sc.examined++
+		if sc.filter != nil {
+			rec := Record{}
+			if err := sc.deref(&rec, sc.tree, true); err != nil {
+				sc.err = err
+				return
+			}
+			if !filterMatch(sc.filter, &rec) {
+				sc.db.countRows(1, 0)
+				sc.advance()
+				continue
+			}
+		}
+		if sc.distinct != nil {
+			dup, err := sc.duplicate()
+			if err != nil {
+				sc.err = err
+				return
+			}
+			if dup {
+				sc.db.countRows(1, 0)
+				sc.advance()
+				continue
+			}
+		}
+		sc.returned++
+		sc.db.countRows(1, 1)
+		if !sc.tdef.internal() {
+			sc.db.countMetric(METRIC_SCAN_ROWS, 1)
+		}
 		return
 	}
+}
 
-	nextKey, _ := sc.iter.Deref()
-	if bytes.Equal(currentKey, nextKey) {
-		// If we get the same key, invalidate iterator to stop
-		sc.iter = &BIter{}
-		return
+// stop the scan once ctx is done, Valid turns false & Err is the error of
+// ctx. it is checked every CTX_CHECK_ROWS rows read, the ones skipped by the
+// filter too. the scans of a transaction of DB.BeginTx stop with its context.
+func (sc *Scanner) SetContext(ctx context.Context) {
+	sc.ctx = ctx
+}
+
+func (sc *Scanner) ctxErr() error {
+	if sc.ctx != nil && sc.ctx.Err() != nil {
+		return context.Cause(sc.ctx)
 	}
+	return sc.tree.ctxErr()
 }
 
-// fetch the current row
-func (sc *Scanner) Deref(rec *Record, tree *BTree) {
+// the rows the scan read & found so far, without the Elapsed time
+func (sc *Scanner) Stats() ExecStats {
+	return ExecStats{Examined: sc.examined, Returned: sc.returned}
+}
+
+// the reason the scanner stopped early, nil when it ran out of rows
+func (sc *Scanner) Err() error {
+	if sc.tx != nil {
+		if err := sc.tx.Err(); err != nil {
+			return err
+		}
+	}
+	return sc.iterErr()
+}
+
+func (sc *Scanner) iterErr() error {
+	if sc.err != nil {
+		return sc.err
+	}
+	if sc.iter != nil && sc.iter.stale() {
+		return ErrIterInvalidated
+	}
+	return nil
+}
+
+// an opaque token of the current row for resuming the scan with StartAfter
+func (sc *Scanner) Position() []byte {
 	if !sc.Valid() {
-		return
+		return nil
+	}
+	key, _ := sc.iter.Deref()
+	return append([]byte{}, key...)
+}
+
+// consume the remaining rows without decoding them
+func (sc *Scanner) Count() int64 {
+	count := int64(0)
+	for sc.Valid() {
+		count++
+		sc.Next()
+	}
+	return count
+}
+
+// fetch the current row from the tree it scans
+func (sc *Scanner) Read(rec *Record) error {
+	return sc.Deref(rec, sc.tree)
+}
+
+// fetch the current row
+//
+// Deprecated: use Read.
+func (sc *Scanner) Deref(rec *Record, tree *BTree) error {
+	if sc.tx != nil {
+		if err := sc.tx.enter(); err != nil {
+			return err
+		}
+		defer sc.tx.mu.Unlock()
+	}
+	if !sc.valid() {
+		return sc.iterErr()
+	}
+	if len(sc.Project) > 0 && sc.proj == nil {
+		if err := sc.resolveProject(); err != nil {
+			return err
+		}
+	}
+	return sc.deref(rec, tree, false)
+}
+
+// decode the current row, every column if full instead of the projected ones.
+// a full row is decoded in place in rec.Vals, a projected one in the scratch
+// row of the scanner & copied. the bytes alias the pages of the snapshot,
+// never a buffer of the scanner.
+func (sc *Scanner) deref(rec *Record, tree *BTree, full bool) error {
+	proj, covering := sc.proj, sc.covering
+	if full {
+		proj, covering = nil, false
 	}
 	tdef := sc.tdef
 	rec.Cols = tdef.Cols
 	rec.Vals = rec.Vals[:0]
 	key, val := sc.iter.Deref()
 	if sc.indexNo < 0 {
-		values := make([]Value, len(rec.Cols))
-		for i := range rec.Cols {
-			values[i].Type = tdef.Types[i]
+		// only decode up to the last requested column
+		last := len(tdef.Cols) - 1
+		if proj != nil {
+			last = 0
+			for _, idx := range proj {
+				last = max(last, idx)
+			}
 		}
-		decodeValues(key[4:], values[:tdef.PKeys])
-		decodeValues(val, values[tdef.PKeys:])
-		rec.Vals = append(rec.Vals, values...)
-	} else {
-		index := tdef.Indexes[sc.indexNo]
-		ival := make([]Value, len(index))
-		for i, col := range index {
-			ival[i].Type = tdef.Types[ColIndex(tdef, col)]
+		values := sc.rowValues(rec, proj)
+		decodeValues(key[4:], values[:min(last+1, tdef.PKeys)])
+		if last >= tdef.PKeys {
+			decodeRow(tdef, val, values[tdef.PKeys:last+1])
 		}
-		decodeValues(key[4:], ival)
-		icol := Record{index, ival}
+		sc.project(rec, values, proj)
+		return nil
+	}
 
-		rec.Cols = rec.Cols[:tdef.PKeys]
-		for _, col := range rec.Cols {
+	index := tdef.Indexes[sc.indexNo]
+	sc.ivals = slices.Grow(sc.ivals[:0], len(index))[:len(index)]
+	for i, col := range index {
+		sc.ivals[i] = Value{Type: tdef.Types[ColIndex(tdef, col)]}
+	}
+	decodeValues(key[4:], sc.ivals)
+	icol := Record{index, sc.ivals}
+	if covering {
+		// every requested column is in the index, skip the primary row
+		rec.Cols = sc.Project
+		for _, col := range sc.Project {
 			rec.Vals = append(rec.Vals, *icol.Get(col))
 		}
+		return nil
+	}
+
+	// the primary row by its key
+	values := sc.rowValues(rec, proj)
+	for i := 0; i < tdef.PKeys; i++ {
+		values[i] = *icol.Get(tdef.Cols[i])
+	}
+	sc.pkey = encodeKey(sc.pkey[:0], tdef.Prefix, values[:tdef.PKeys])
+	row, ok, err := tree.Get(sc.pkey)
+	if err != nil {
+		return fmt.Errorf("fetch primary row: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s index %v", ErrDanglingIndex, tdef.Name, index)
+	}
+	decodeRow(tdef, row, values[tdef.PKeys:])
+	sc.project(rec, values, proj)
+	return nil
+}
+
+// the values a row is decoded into, with the types of the columns: rec.Vals
+// for a full row, or the scratch row of the scanner for a projection
+func (sc *Scanner) rowValues(rec *Record, proj []int) []Value {
+	n := len(sc.tdef.Cols)
+	var values []Value
+	if proj == nil {
+		rec.Vals = slices.Grow(rec.Vals[:0], n)[:n]
+		values = rec.Vals
+	} else {
+		sc.vals = slices.Grow(sc.vals[:0], n)[:n]
+		values = sc.vals
+	}
+	for i := range values {
+		values[i] = Value{Type: sc.tdef.Types[i]}
+	}
+	return values
+}
 
-		ok, err := dbGet(sc.db, tdef, rec, tree)
-		if !ok && err != nil {
-			fmt.Println("Error getting record from DB")
+// validate the projected columns & check if the index covers them
+func (sc *Scanner) resolveProject() error {
+	proj := make([]int, len(sc.Project))
+	for i, col := range sc.Project {
+		proj[i] = ColIndex(sc.tdef, col)
+		if proj[i] < 0 {
+			return columnNotFound(sc.tdef.Name, col)
 		}
 	}
+	sc.proj = proj
+	sc.covering = false
+	if sc.indexNo >= 0 {
+		sc.covering = isSubset(sc.tdef.Indexes[sc.indexNo], sc.Project)
+	}
+	return nil
+}
+
+// keep only the projected columns of a full row, in place without a projection
+func (sc *Scanner) project(rec *Record, values []Value, proj []int) {
+	if proj == nil {
+		rec.Cols = sc.tdef.Cols
+		return
+	}
+	rec.Cols = sc.Project
+	for _, idx := range proj {
+		rec.Vals = append(rec.Vals, values[idx])
+	}
 }
 
 // B-Tree Iterator
 type BIter struct {
-	tree *BTree
-	path []BNode  // from root to leaf
-	pos  []uint16 // indexes into nodes
+	tree    *BTree
+	path    []BNode  // from root to leaf
+	pos     []uint16 // indexes into nodes
+	version uint64   // the tree version the path was read from
 }
 
 // get current KV pair
@@ -155,26 +701,42 @@ func (iter *BIter) Deref() (key []byte, val []byte) {
 	currentNode := iter.path[len(iter.path)-1]
 	idx := iter.pos[len(iter.pos)-1]
 	key = currentNode.getKey(idx)
-	val = currentNode.getVal(idx)
+	val = iter.tree.loadVal(currentNode, idx)
 	return
 }
 
 // precondition of the Deref()
 func (iter *BIter) Valid() bool {
-	if len(iter.path) == 0 {
+	if len(iter.path) == 0 || iter.stale() {
 		return false
 	}
 	lastNode := iter.path[len(iter.path)-1]
 	return lastNode.data != nil && iter.pos[len(iter.pos)-1] < lastNode.nKeys()
 }
 
-// moving backward and forward
+// the cached path may point at pages the tree has since replaced
+func (iter *BIter) stale() bool {
+	return iter.tree != nil && iter.version != iter.tree.version
+}
+
+// moving backward and forward, past the first or the last key the iterator
+// is no longer valid
 func (iter *BIter) Prev() {
-	iterPrev(iter, len(iter.path)-1)
+	if iter.Valid() && !iterPrev(iter, len(iter.path)-1) {
+		iter.end()
+	}
 }
 
 func (iter *BIter) Next() {
-	iterNext(iter, 0)
+	if iter.Valid() && !iterNext(iter, len(iter.path)-1) {
+		iter.end()
+	}
+}
+
+// move past the keys of the leaf, see Valid
+func (iter *BIter) end() {
+	last := len(iter.path) - 1
+	iter.pos[last] = iter.path[last].nKeys()
 }
 
 func (tree *BTree) Seek(key []byte, cmp int) *BIter {
@@ -193,7 +755,7 @@ func (tree *BTree) Seek(key []byte, cmp int) *BIter {
 }
 
 func (tree *BTree) SeekLE(key []byte) *BIter {
-	iter := &BIter{tree: tree}
+	iter := &BIter{tree: tree, version: tree.version}
 	for ptr := tree.root; ptr != 0; {
 		node := tree.get(ptr)
 		idx := nodeLookupLE(node, key)
@@ -208,6 +770,52 @@ func (tree *BTree) SeekLE(key []byte) *BIter {
 	return iter
 }
 
+// seek to the smallest key, skipping the dummy key
+func (tree *BTree) SeekFirst() *BIter {
+	iter := tree.seekEdge(false)
+	if !iter.Valid() {
+		return iter
+	}
+	if key, _ := iter.Deref(); len(key) == 0 {
+		iter.Next() // invalid when only the dummy key is left
+	}
+	return iter
+}
+
+// seek to the largest key
+func (tree *BTree) SeekLast() *BIter {
+	iter := tree.seekEdge(true)
+	if iter.Valid() {
+		if key, _ := iter.Deref(); len(key) == 0 {
+			return &BIter{tree: tree, version: tree.version}
+		}
+	}
+	return iter
+}
+
+// descend the leftmost or the rightmost path
+func (tree *BTree) seekEdge(last bool) *BIter {
+	iter := &BIter{tree: tree, version: tree.version}
+	for ptr := tree.root; ptr != 0; {
+		node := tree.get(ptr)
+		if node.nKeys() == 0 {
+			return &BIter{tree: tree, version: tree.version}
+		}
+		idx := uint16(0)
+		if last {
+			idx = node.nKeys() - 1
+		}
+		iter.path = append(iter.path, node)
+		iter.pos = append(iter.pos, idx)
+		if node.bNodeType() == BNODE_INODE {
+			ptr = node.getPtr(idx)
+		} else {
+			ptr = 0
+		}
+	}
+	return iter
+}
+
 // compares current key & ref key & checks if cmp is valid
 func cmpOK(key []byte, cmp int, ref []byte) bool {
 	r := bytes.Compare(key, ref)
@@ -225,13 +833,16 @@ func cmpOK(key []byte, cmp int, ref []byte) bool {
 	}
 }
 
-func iterPrev(iter *BIter, level int) {
+// returns false when already at the first key, the iterator is unchanged then
+func iterPrev(iter *BIter, level int) bool {
 	if iter.pos[level] > 0 {
 		iter.pos[level]-- // move within this node
 	} else if level > 0 { // make sure the level is not less than the `root`
-		iterPrev(iter, level-1)
+		if !iterPrev(iter, level-1) {
+			return false
+		}
 	} else {
-		return
+		return false
 	}
 	if level+1 < len(iter.pos) {
 		// update the kid prevNode
@@ -240,16 +851,20 @@ func iterPrev(iter *BIter, level int) {
 		iter.path[level+1] = kid
 		iter.pos[level+1] = kid.nKeys() - 1
 	}
+	return true
 }
 
-func iterNext(iter *BIter, level int) {
+// returns false when already at the last key, the iterator is unchanged then
+func iterNext(iter *BIter, level int) bool {
 	currentNode := iter.path[level]
-	if iter.pos[level] < uint16(currentNode.nKeys())-1 {
+	if iter.pos[level]+1 < currentNode.nKeys() {
 		iter.pos[level]++ // move within this node
-	} else if level < len(iter.path)-1 {
-		iterNext(iter, level+1)
+	} else if level > 0 { // move to the sibling through the parent
+		if !iterNext(iter, level-1) {
+			return false
+		}
 	} else {
-		return
+		return false
 	}
 	if level+1 < len(iter.pos) {
 		// update the kid nextNode
@@ -258,4 +873,5 @@ func iterNext(iter *BIter, level int) {
 		iter.path[level+1] = kid
 		iter.pos[level+1] = 0
 	}
+	return true
 }
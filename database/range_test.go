@@ -0,0 +1,2217 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 10; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	tests := []struct {
+		name     string
+		start    int64
+		end      int64
+		limit    int
+		expected []int64
+	}{
+		{
+			name:     "no limit",
+			start:    2,
+			end:      5,
+			limit:    0,
+			expected: []int64{2, 3, 4, 5},
+		},
+		{
+			name:     "limit hit mid range",
+			start:    2,
+			end:      8,
+			limit:    3,
+			expected: []int64{2, 3, 4},
+		},
+		{
+			name:     "limit at end of range",
+			start:    2,
+			end:      5,
+			limit:    4,
+			expected: []int64{2, 3, 4, 5},
+		},
+		{
+			name:     "limit past end of range",
+			start:    9,
+			end:      20,
+			limit:    5,
+			expected: []int64{9, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader KVReader
+			db.kv.BeginRead(&reader)
+			defer db.kv.EndRead(&reader)
+
+			sc := Scanner{
+				Cmp1:  CMP_GE,
+				Cmp2:  CMP_LE,
+				Key1:  *(&Record{}).AddInt64("id", tt.start),
+				Key2:  *(&Record{}).AddInt64("id", tt.end),
+				Limit: tt.limit,
+			}
+			if err := db.Scan("users", &sc, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := scanIDs(&sc, &reader.Tree)
+			if !equalIDs(got, tt.expected) {
+				t.Errorf("expected ids %v, got %v", tt.expected, got)
+			}
+			if sc.Valid() {
+				t.Error("expected scanner to be invalid after the last row")
+			}
+		})
+	}
+}
+
+func TestScanBadLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc := Scanner{
+		Cmp1:  CMP_GE,
+		Cmp2:  CMP_LE,
+		Key1:  *(&Record{}).AddInt64("id", 1),
+		Key2:  *(&Record{}).AddInt64("id", 2),
+		Limit: -1,
+	}
+	err := db.Scan("users", &sc, &reader.Tree)
+	if err == nil || !isEqual(err.Error(), "bad limit") {
+		t.Errorf("expected error containing %q, got %v", "bad limit", err)
+	}
+}
+
+// collects the primary keys of every remaining row in the scanner
+func scanIDs(sc *Scanner, tree *BTree) []int64 {
+	var ids []int64
+	for sc.Valid() {
+		rec := Record{}
+		sc.Deref(&rec, tree)
+		ids = append(ids, rec.Get("id").I64)
+		sc.Next()
+	}
+	return ids
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScanAll(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 5; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	// a second table right after `users` in the key space
+	var writer KVTX
+	db.kv.Begin(&writer)
+	tdef := &TableDef{
+		Name:  "orders",
+		Types: []uint32{TYPE_INT64, TYPE_BYTES},
+		Cols:  []string{"id", "item"},
+		PKeys: 1,
+	}
+	if err := db.TableNew(tdef, &writer); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	order := (&Record{}).AddInt64("id", 100).AddStr("item", []byte("book"))
+	if _, err := db.Insert("orders", *order, &writer); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.kv.Commit(&writer)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc, err := db.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := scanIDs(sc, &reader.Tree)
+	if !equalIDs(got, []int64{1, 2, 3, 4, 5}) {
+		t.Errorf("expected ids [1 2 3 4 5], got %v", got)
+	}
+
+	sc, err = db.ScanAll("orders", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got = scanIDs(sc, &reader.Tree)
+	if !equalIDs(got, []int64{100}) {
+		t.Errorf("expected ids [100], got %v", got)
+	}
+
+	if _, err := db.ScanAll("missing", &reader.Tree); err == nil {
+		t.Error("expected error for a missing table")
+	}
+}
+
+func TestScanAllCompositeKey(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	// tableDefCheck rejects composite keys, so register the definition directly
+	tdef := &TableDef{
+		Name:   "events",
+		Types:  []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:   []string{"id", "kind", "data"},
+		PKeys:  2,
+		Prefix: 100,
+	}
+	db.tables[tdef.Name] = cachedDef{tdef: tdef, catalog: db.SchemaVersion()}
+
+	var writer KVTX
+	db.kv.Begin(&writer)
+	for _, id := range []int64{3, 1, 2} {
+		for _, kind := range []string{"b", "a"} {
+			rec := (&Record{}).AddInt64("id", id).AddStr("kind", []byte(kind)).AddStr("data", []byte("x"))
+			if _, err := dbUpdate(db, tdef, *rec, MODE_INSERT_ONLY, &writer); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	db.kv.Commit(&writer)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc, err := db.ScanAll("events", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for sc.Valid() {
+		rec := Record{}
+		sc.Deref(&rec, &reader.Tree)
+		got = append(got, fmt.Sprintf("%d%s", rec.Get("id").I64, rec.Get("kind").Str))
+		sc.Next()
+	}
+	expected := []string{"1a", "1b", "2a", "2b", "3a", "3b"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestDerefDanglingIndex(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	tdef := setupIndexedTable(t, db)
+	insertIndexedRecord(t, db, 1, "a@example.com")
+
+	// remove only the primary row, leaving the index entry behind
+	var writer KVTX
+	db.kv.Begin(&writer)
+	key := encodeKey(nil, tdef.Prefix, []Value{{Type: TYPE_INT64, I64: 1}})
+	if _, err := writer.Delete(&DeleteReq{Key: key}); err != nil {
+		t.Fatalf("failed to delete primary row: %v", err)
+	}
+	db.kv.Commit(&writer)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	rec := (&Record{}).AddStr("email", []byte("a@example.com"))
+	_, err := db.Get("people", rec, &reader)
+	if !errors.Is(err, ErrDanglingIndex) {
+		t.Errorf("expected ErrDanglingIndex, got %v", err)
+	}
+}
+
+func setupIndexedTable(t testing.TB, db *DB) *TableDef {
+	var writer KVTX
+	db.kv.Begin(&writer)
+
+	tdef := &TableDef{
+		Name:    "people",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"id", "name", "email"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}},
+	}
+	if err := db.TableNew(tdef, &writer); err != nil {
+		t.Fatalf("failed to create test table: %v", err)
+	}
+	db.kv.Commit(&writer)
+	return tdef
+}
+
+func insertIndexedRecord(t *testing.T, db *DB, id int64, email string) {
+	var writer KVTX
+	db.kv.Begin(&writer)
+
+	rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email))
+	inserted, err := db.Insert("people", *rec, &writer)
+	if err != nil {
+		t.Fatalf("failed to insert test record: %v", err)
+	}
+	if !inserted {
+		t.Fatal("failed to insert test record")
+	}
+	db.kv.Commit(&writer)
+}
+
+func TestScanDesc(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 10; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	tests := []struct {
+		name     string
+		scanner  Scanner
+		expected []int64
+	}{
+		{
+			name: "inclusive bounds",
+			scanner: Scanner{
+				Cmp1: CMP_GE,
+				Cmp2: CMP_LE,
+				Key1: *(&Record{}).AddInt64("id", 3),
+				Key2: *(&Record{}).AddInt64("id", 7),
+				Desc: true,
+			},
+			expected: []int64{7, 6, 5, 4, 3},
+		},
+		{
+			name: "exclusive bounds",
+			scanner: Scanner{
+				Cmp1: CMP_GT,
+				Cmp2: CMP_LT,
+				Key1: *(&Record{}).AddInt64("id", 3),
+				Key2: *(&Record{}).AddInt64("id", 7),
+				Desc: true,
+			},
+			expected: []int64{6, 5, 4},
+		},
+		{
+			name: "upper bound past the last row",
+			scanner: Scanner{
+				Cmp1: CMP_GE,
+				Cmp2: CMP_LE,
+				Key1: *(&Record{}).AddInt64("id", 8),
+				Key2: *(&Record{}).AddInt64("id", 50),
+				Desc: true,
+			},
+			expected: []int64{10, 9, 8},
+		},
+		{
+			name: "lower bound before the first row",
+			scanner: Scanner{
+				Cmp1: CMP_GE,
+				Cmp2: CMP_LE,
+				Key1: *(&Record{}).AddInt64("id", -5),
+				Key2: *(&Record{}).AddInt64("id", 2),
+				Desc: true,
+			},
+			expected: []int64{2, 1},
+		},
+		{
+			name: "with limit",
+			scanner: Scanner{
+				Cmp1:  CMP_GE,
+				Cmp2:  CMP_LE,
+				Key1:  *(&Record{}).AddInt64("id", 1),
+				Key2:  *(&Record{}).AddInt64("id", 10),
+				Desc:  true,
+				Limit: 2,
+			},
+			expected: []int64{10, 9},
+		},
+		{
+			name: "upper bound as Key1",
+			scanner: Scanner{
+				Cmp1: CMP_LE,
+				Cmp2: CMP_GE,
+				Key1: *(&Record{}).AddInt64("id", 7),
+				Key2: *(&Record{}).AddInt64("id", 3),
+			},
+			expected: []int64{7, 6, 5, 4, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader KVReader
+			db.kv.BeginRead(&reader)
+			defer db.kv.EndRead(&reader)
+
+			sc := tt.scanner
+			if err := db.Scan("users", &sc, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := scanIDs(&sc, &reader.Tree)
+			if !equalIDs(got, tt.expected) {
+				t.Errorf("expected ids %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("conflicting direction", func(t *testing.T) {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+
+		sc := Scanner{
+			Cmp1: CMP_LE,
+			Cmp2: CMP_GE,
+			Key1: *(&Record{}).AddInt64("id", 7),
+			Key2: *(&Record{}).AddInt64("id", 3),
+			Desc: true,
+		}
+		err := db.Scan("users", &sc, &reader.Tree)
+		if err == nil || !isEqual(err.Error(), "bad range") {
+			t.Errorf("expected error containing %q, got %v", "bad range", err)
+		}
+	})
+}
+
+func TestScanDescIndex(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	emails := []string{"c@x", "a@x", "e@x", "b@x", "d@x"}
+	for i, email := range emails {
+		insertIndexedRecord(t, db, int64(i+1), email)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc := Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddStr("email", []byte("b@x")),
+		Key2: *(&Record{}).AddStr("email", []byte("d@x")),
+		Desc: true,
+	}
+	if err := db.Scan("people", &sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for sc.Valid() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, string(rec.Get("email").Str))
+		sc.Next()
+	}
+	expected := []string{"d@x", "c@x", "b@x"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// the int64 keys are biased by 1<<63 so the negatives sort first
+func TestScanSignedRange(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "readings",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "delta", "note"},
+		PKeys:   1,
+		Indexes: [][]string{{"delta"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	for _, id := range []int64{5, -1, math.MinInt64, 0, 10, -10, math.MaxInt64, 1, -5} {
+		rec := (&Record{}).AddInt64("id", id).AddInt64("delta", -id).AddStr("note", nil)
+		if id == math.MinInt64 {
+			rec.Vals[1].I64 = math.MaxInt64
+		}
+		if _, err := tx.Set("readings", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert %d: %v", id, err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	key := func(col string, n int64) Record {
+		return *(&Record{}).AddInt64(col, n)
+	}
+	tests := []struct {
+		name     string
+		scanner  Scanner
+		expected []int64
+	}{
+		{"primary key", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key("id", -10), Key2: key("id", 10)},
+			[]int64{-10, -5, -1, 0, 1, 5, 10}},
+		{"primary key desc", Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: key("id", -6), Key2: key("id", 6), Desc: true},
+			[]int64{5, 1, 0, -1, -5}},
+		{"primary key from the top", Scanner{Cmp1: CMP_LE, Cmp2: CMP_GE, Key1: key("id", 0), Key2: key("id", math.MinInt64)},
+			[]int64{0, -1, -5, -10, math.MinInt64}},
+		{"the whole range", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key("id", math.MinInt64), Key2: key("id", math.MaxInt64)},
+			[]int64{math.MinInt64, -10, -5, -1, 0, 1, 5, 10, math.MaxInt64}},
+		// by delta = -id
+		{"index", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: key("delta", -5), Key2: key("delta", 5)},
+			[]int64{5, 1, 0, -1, -5}},
+		{"index desc", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: key("delta", -10), Key2: key("delta", 1), Desc: true},
+			[]int64{0, 1, 5, 10}},
+		{"index from the top", Scanner{Cmp1: CMP_LT, Cmp2: CMP_GT, Key1: key("delta", 10), Key2: key("delta", -1)},
+			[]int64{-5, -1, 0}},
+	}
+	for _, tt := range tests {
+		sc := tt.scanner
+		if err := reader.Scan("readings", &sc); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := scanIDs(&sc, &reader.kv.Tree); !equalIDs(got, tt.expected) {
+			t.Errorf("%s: expected ids %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// strings with the bytes of the escapes & the terminator in composite keys
+func TestScanBinaryStrings(t *testing.T) {
+	pairs := [][2]string{
+		{"a", "b"}, {"a\x00", "b"}, {"a", "\x00b"}, {"a\x00b", ""}, {"a", "\x00"}, {"", "a\x00"},
+		{"a\x01", "b"}, {"a\x01\x01", ""}, {"a\x01\x02", ""}, {"a", "\x01\x01"}, {"a", "\x01\x02"},
+		{"\xfe\x41", ""}, {"\xff\x00", "x"}, {"\xff", "\xff"}, {"", ""}, {"a", ""}, {"\x00", "\x00"},
+	}
+	// distinct values encode to distinct keys in the same order
+	key := func(p [2]string) []byte {
+		return encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: []byte(p[0])}, {Type: TYPE_BYTES, Str: []byte(p[1])}})
+	}
+	less := func(a, b [2]string) bool {
+		if c := bytes.Compare([]byte(a[0]), []byte(b[0])); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare([]byte(a[1]), []byte(b[1])) < 0
+	}
+	for _, a := range pairs {
+		out := []Value{{Type: TYPE_BYTES}, {Type: TYPE_BYTES}}
+		if n := decodeValues(key(a), out); n != 2 || string(out[0].Str) != a[0] || string(out[1].Str) != a[1] {
+			t.Errorf("%q: decoded as %q %q", a, out[0].Str, out[1].Str)
+		}
+		for _, b := range pairs {
+			if less(a, b) != (bytes.Compare(key(a), key(b)) < 0) {
+				t.Errorf("%q & %q: the keys are not in order", a, b)
+			}
+		}
+	}
+
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "files",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES, TYPE_BYTES},
+		Cols:    []string{"n", "dir", "name", "note"},
+		PKeys:   1,
+		Indexes: [][]string{{"dir", "name"}, {"name"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	for i, p := range pairs {
+		rec := (&Record{}).AddInt64("n", int64(i)).AddStr("dir", []byte(p[0])).AddStr("name", []byte(p[1])).AddStr("note", nil)
+		if _, err := tx.Set("files", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("%q: failed to insert: %v", p, err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	scan := func(sc Scanner) [][2]string {
+		t.Helper()
+		if err := reader.Scan("files", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var got [][2]string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			got = append(got, [2]string{string(rec.Get("dir").Str), string(rec.Get("name").Str)})
+		}
+		return got
+	}
+	check := func(name string, got [][2]string, expected [][2]string) {
+		t.Helper()
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", expected) {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+	sorted := append([][2]string(nil), pairs...)
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	both := Record{Cols: []string{"dir", "name"}}
+	check("all rows", scan(Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: both, Key2: both}), sorted)
+
+	// a key prefix matches the exact value of the column, not a longer one
+	var dirA [][2]string
+	for _, p := range sorted {
+		if p[0] == "a" {
+			dirA = append(dirA, p)
+		}
+	}
+	dir := *(&Record{}).AddStr("dir", []byte("a"))
+	check("dir a", scan(Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: dir, Key2: dir}), dirA)
+	empty := *(&Record{}).AddStr("name", []byte{})
+	var names [][2]string
+	for _, p := range pairs {
+		if p[1] == "" {
+			names = append(names, p)
+		}
+	}
+	check("empty name", scan(Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: empty, Key2: empty}), names)
+	nul := *(&Record{}).AddStr("name", []byte("\x00"))
+	check("names after a nul", scan(Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: nul, Key2: *(&Record{}).AddStr("name", []byte("\x01\x02"))}),
+		[][2]string{{"a", "\x00b"}, {"a", "\x01\x01"}})
+}
+
+func TestScanPrefix(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	tdef := &TableDef{
+		Name:   "events",
+		Types:  []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:   []string{"user_id", "ts", "data"},
+		PKeys:  2,
+		Prefix: 100,
+	}
+	db.tables[tdef.Name] = cachedDef{tdef: tdef, catalog: db.SchemaVersion()}
+
+	var writer KVTX
+	db.kv.Begin(&writer)
+	for _, user := range []int64{1, 2, 3} {
+		for _, ts := range []int64{20, 10} {
+			rec := (&Record{}).AddInt64("user_id", user).AddInt64("ts", ts).AddStr("data", []byte("x"))
+			if _, err := dbUpdate(db, tdef, *rec, MODE_INSERT_ONLY, &writer); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	db.kv.Commit(&writer)
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc, err := db.ScanPrefix("events", *(&Record{}).AddInt64("user_id", 2), &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for sc.Valid() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d/%d", rec.Get("user_id").I64, rec.Get("ts").I64))
+		sc.Next()
+	}
+	expected := []string{"2/10", "2/20"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	_, err = db.ScanPrefix("events", *(&Record{}).AddInt64("ts", 10), &reader.Tree)
+	if err == nil || !isEqual(err.Error(), "not a prefix") {
+		t.Errorf("expected error containing %q, got %v", "not a prefix", err)
+	}
+}
+
+func TestScanPrefixIndex(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	insertIndexedRecord(t, db, 1, "a@x")
+	insertIndexedRecord(t, db, 2, "b@x")
+	insertIndexedRecord(t, db, 3, "b@xy")
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc, err := db.ScanPrefix("people", *(&Record{}).AddStr("email", []byte("b@x")), &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := scanIDs(sc, &reader.Tree)
+	if !equalIDs(got, []int64{2}) {
+		t.Errorf("expected ids [2], got %v", got)
+	}
+}
+
+func TestScanPartialKeyBounds(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	var writer KVTX
+	db.kv.Begin(&writer)
+	tdef := &TableDef{
+		Name:    "scores",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "team", "score", "name"},
+		PKeys:   1,
+		Indexes: [][]string{{"team", "score"}, {"score", "team"}},
+	}
+	if err := db.TableNew(tdef, &writer); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	rows := []struct {
+		team  string
+		score int64
+	}{
+		{"a", 10}, {"b", 20}, {"b", 5}, {"c", 30}, {"c", 10}, {"d", 40}, {"e", 15},
+	}
+	for i, row := range rows {
+		rec := (&Record{}).AddInt64("id", int64(i+1)).AddStr("team", []byte(row.team)).
+			AddInt64("score", row.score).AddStr("name", []byte("x"))
+		if _, err := db.Insert("scores", *rec, &writer); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	db.kv.Commit(&writer)
+
+	team := func(v string) Record { return *(&Record{}).AddStr("team", []byte(v)) }
+	score := func(v int64) Record { return *(&Record{}).AddInt64("score", v) }
+
+	tests := []struct {
+		name     string
+		scanner  Scanner
+		expected []int64
+	}{
+		{
+			name:     "bytes column, exclusive start",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LE, Key1: team("b"), Key2: team("d")},
+			expected: []int64{5, 4, 6},
+		},
+		{
+			name:     "bytes column, exclusive both",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: team("a"), Key2: team("c")},
+			expected: []int64{3, 2},
+		},
+		{
+			name:     "bytes column, inclusive both",
+			scanner:  Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: team("b"), Key2: team("c")},
+			expected: []int64{3, 2, 5, 4},
+		},
+		{
+			name:     "bytes column, descending",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LE, Key1: team("b"), Key2: team("d"), Desc: true},
+			expected: []int64{6, 4, 5},
+		},
+		{
+			name:     "int column, exclusive start",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LE, Key1: score(10), Key2: score(30)},
+			expected: []int64{7, 2, 4},
+		},
+		{
+			name:     "int column, descending exclusive both",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: score(10), Key2: score(30), Desc: true},
+			expected: []int64{2, 7},
+		},
+		{
+			name:     "start past every key",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LE, Key1: team("e"), Key2: team("z")},
+			expected: nil,
+		},
+		{
+			name:     "descending start before every key",
+			scanner:  Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: score(0), Key2: score(5), Desc: true},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reader KVReader
+			db.kv.BeginRead(&reader)
+			defer db.kv.EndRead(&reader)
+
+			sc := tt.scanner
+			if err := db.Scan("scores", &sc, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := scanIDs(&sc, &reader.Tree)
+			if !equalIDs(got, tt.expected) {
+				t.Errorf("expected ids %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestScanStartPastEnd(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 3; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc := Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddInt64("id", 50),
+		Key2: *(&Record{}).AddInt64("id", 60),
+	}
+	if err := db.Scan("users", &sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc.Valid() {
+		t.Error("expected an empty scan")
+	}
+}
+
+func TestScanProject(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	tdef := setupIndexedTable(t, db)
+	insertIndexedRecord(t, db, 1, "a@x")
+	insertIndexedRecord(t, db, 2, "b@x")
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	rangeOn := func(col string, lo, hi Value, project []string) *Scanner {
+		sc := &Scanner{
+			Cmp1:    CMP_GE,
+			Cmp2:    CMP_LE,
+			Key1:    Record{Cols: []string{col}, Vals: []Value{lo}},
+			Key2:    Record{Cols: []string{col}, Vals: []Value{hi}},
+			Project: project,
+		}
+		if err := db.Scan("people", sc, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return sc
+	}
+	collect := func(sc *Scanner) []string {
+		var rows []string
+		for sc.Valid() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			rows = append(rows, fmt.Sprintf("%v=%s", rec.Cols, formatValues(rec.Vals)))
+			sc.Next()
+		}
+		return rows
+	}
+
+	t.Run("primary key", func(t *testing.T) {
+		sc := rangeOn("id", Value{Type: TYPE_INT64, I64: 1}, Value{Type: TYPE_INT64, I64: 2}, []string{"email", "id"})
+		got := strings.Join(collect(sc), ";")
+		expected := "[email id]=a@x,1;[email id]=b@x,2"
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("index without covering", func(t *testing.T) {
+		sc := rangeOn("email", Value{Type: TYPE_BYTES, Str: []byte("a")}, Value{Type: TYPE_BYTES, Str: []byte("z")}, []string{"name"})
+		got := strings.Join(collect(sc), ";")
+		expected := "[name]=John;[name]=John"
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("covering index", func(t *testing.T) {
+		// drop the primary row, a covering read must not need it
+		var writer KVTX
+		db.kv.Begin(&writer)
+		key := encodeKey(nil, tdef.Prefix, []Value{{Type: TYPE_INT64, I64: 2}})
+		if _, err := writer.Delete(&DeleteReq{Key: key}); err != nil {
+			t.Fatalf("failed to delete primary row: %v", err)
+		}
+		db.kv.Commit(&writer)
+
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+		sc := &Scanner{
+			Cmp1:    CMP_GE,
+			Cmp2:    CMP_LE,
+			Key1:    *(&Record{}).AddStr("email", []byte("a")),
+			Key2:    *(&Record{}).AddStr("email", []byte("z")),
+			Project: []string{"id", "email"},
+		}
+		if err := db.Scan("people", sc, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for sc.Valid() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, formatValues(rec.Vals))
+			sc.Next()
+		}
+		expected := "1,a@x;2,b@x"
+		if strings.Join(got, ";") != expected {
+			t.Errorf("expected %q, got %q", expected, strings.Join(got, ";"))
+		}
+	})
+
+	t.Run("unknown column", func(t *testing.T) {
+		sc := &Scanner{
+			Cmp1:    CMP_GE,
+			Cmp2:    CMP_LE,
+			Key1:    *(&Record{}).AddInt64("id", 1),
+			Key2:    *(&Record{}).AddInt64("id", 2),
+			Project: []string{"phone"},
+		}
+		err := db.Scan("people", sc, &reader.Tree)
+		if err == nil || !isEqual(err.Error(), "column not found: phone") {
+			t.Errorf("expected error containing %q, got %v", "column not found: phone", err)
+		}
+	})
+}
+
+func formatValues(vals []Value) string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = formatValue(v)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestCount(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 30; id++ {
+		insertIndexedRecord(t, db, id, fmt.Sprintf("user%03d@x", id))
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	id := func(v int64) Record { return *(&Record{}).AddInt64("id", v) }
+	email := func(v string) Record { return *(&Record{}).AddStr("email", []byte(v)) }
+
+	tests := []struct {
+		name     string
+		scanner  Scanner
+		expected int64
+	}{
+		{
+			name:     "primary key",
+			scanner:  Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id(10), Key2: id(19)},
+			expected: 10,
+		},
+		{
+			name:     "empty range",
+			scanner:  Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: id(10), Key2: id(11)},
+			expected: 0,
+		},
+		{
+			name:     "secondary index",
+			scanner:  Scanner{Cmp1: CMP_GE, Cmp2: CMP_LT, Key1: email("user005@x"), Key2: email("user015@x")},
+			expected: 10,
+		},
+		{
+			name:     "with limit",
+			scanner:  Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id(1), Key2: id(30), Limit: 25},
+			expected: 25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := tt.scanner
+			count, err := db.Count("people", &sc, &reader.Tree)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count != tt.expected {
+				t.Errorf("expected count %d, got %d", tt.expected, count)
+			}
+		})
+	}
+}
+
+func TestCountMultipleLeaves(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 300; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	if reader.Tree.get(reader.Tree.root).bNodeType() != BNODE_INODE {
+		t.Fatal("expected the rows to span multiple leaves")
+	}
+
+	for _, desc := range []bool{false, true} {
+		sc := Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddInt64("id", 1),
+			Key2: *(&Record{}).AddInt64("id", 300),
+			Desc: desc,
+		}
+		count, err := db.Count("users", &sc, &reader.Tree)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 300 {
+			t.Errorf("desc=%v: expected count 300, got %d", desc, count)
+		}
+	}
+
+	sc, err := db.ScanAll("users", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := scanIDs(sc, &reader.Tree)
+	if len(ids) != 300 || ids[0] != 1 || ids[299] != 300 {
+		t.Errorf("expected ids 1 to 300 from ScanAll, got %d ids", len(ids))
+	}
+}
+
+func TestScanInvalidatedByUpdate(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 5; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	var writer KVTX
+	db.kv.Begin(&writer)
+	defer db.kv.Abort(&writer)
+
+	sc := Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddInt64("id", 1),
+		Key2: *(&Record{}).AddInt64("id", 5),
+	}
+	if err := db.Scan("users", &sc, &writer.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec := Record{}
+	if err := sc.Deref(&rec, &writer.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sc.Next()
+
+	insert := (&Record{}).AddInt64("id", 6).AddStr("name", []byte("Jane")).AddStr("email", []byte("jane@example.com"))
+	if _, err := db.Insert("users", *insert, &writer); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	if sc.Valid() {
+		t.Error("expected the scanner to be invalidated by the insert")
+	}
+	if !errors.Is(sc.Err(), ErrIterInvalidated) {
+		t.Errorf("expected ErrIterInvalidated, got %v", sc.Err())
+	}
+	if err := sc.Deref(&rec, &writer.Tree); !errors.Is(err, ErrIterInvalidated) {
+		t.Errorf("expected ErrIterInvalidated from Deref, got %v", err)
+	}
+}
+
+func TestScanReaderSnapshot(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 10; id += 2 {
+		insertTestRecord(t, db, id)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	sc := Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddInt64("id", 1),
+		Key2: *(&Record{}).AddInt64("id", 10),
+	}
+	if err := db.Scan("users", &sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// interleave committed inserts with the scan, the reader keeps its snapshot
+	var got []int64
+	next := int64(2)
+	for sc.Valid() {
+		rec := Record{}
+		if err := sc.Deref(&rec, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, rec.Get("id").I64)
+		insertTestRecord(t, db, next)
+		next += 2
+		sc.Next()
+	}
+	if sc.Err() != nil {
+		t.Errorf("unexpected error: %v", sc.Err())
+	}
+	if !equalIDs(got, []int64{1, 3, 5, 7, 9}) {
+		t.Errorf("expected ids [1 3 5 7 9], got %v", got)
+	}
+}
+
+func TestReaderConcurrentWriter(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 100; id++ {
+		insertIndexedRecord(t, db, id, fmt.Sprintf("%03d@x", id))
+	}
+
+	var tx DBReader
+	db.BeginRead(&tx)
+
+	done := make(chan error)
+	go func() {
+		// each commit updates the row & the index together
+		for id := int64(101); id <= 300; id++ {
+			var w DBTX
+			db.Begin(&w)
+			rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("Jane")).AddStr("email", []byte(fmt.Sprintf("%03d@x", id)))
+			if _, err := w.Set("people", *rec, MODE_INSERT_ONLY); err != nil {
+				db.Abort(&w)
+				done <- err
+				return
+			}
+			if err := db.Commit(&w); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	scans := 0
+	for writing := true; writing || scans == 0; scans++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("writer failed: %v", err)
+			}
+			writing = false
+		default:
+		}
+		sc := Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddStr("email", []byte("000@x")),
+			Key2: *(&Record{}).AddStr("email", []byte("999@x")),
+		}
+		if err := tx.Scan("people", &sc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := len(scanIDs(&sc, &tx.kv.Tree)); n != 100 {
+			t.Fatalf("scan %d: expected the 100 rows of the snapshot, got %d", scans, n)
+		}
+		rec := (&Record{}).AddInt64("id", 150)
+		if found, err := tx.Get("people", rec); err != nil || found {
+			t.Fatalf("scan %d: expected a row inserted after the snapshot to be missing, got %v, %v", scans, found, err)
+		}
+	}
+	db.EndRead(&tx)
+
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	sc, _ := db.ScanAll("people", &tx.kv.Tree)
+	if n := len(scanIDs(sc, &tx.kv.Tree)); n != 300 {
+		t.Errorf("expected 300 rows in a new snapshot, got %d", n)
+	}
+}
+
+func TestReaderVersions(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	readers := make([]KVReader, 4)
+	for i := range readers {
+		db.kv.BeginRead(&readers[i])
+		insertTestRecord(t, db, int64(i))
+	}
+	minReader := func() uint64 {
+		db.kv.mu.Lock()
+		defer db.kv.mu.Unlock()
+		return db.kv.readers[0].version
+	}
+	if minReader() != readers[0].version {
+		t.Fatalf("expected the min version %d, got %d", readers[0].version, minReader())
+	}
+	// end out of order, the heap tracks the oldest remaining reader
+	for _, i := range []int{2, 0, 3} {
+		db.kv.EndRead(&readers[i])
+	}
+	if minReader() != readers[1].version || len(db.kv.readers) != 1 {
+		t.Errorf("expected only the reader at version %d, got %d readers from %d",
+			readers[1].version, len(db.kv.readers), minReader())
+	}
+	db.kv.EndRead(&readers[1])
+
+	// a writer can reuse pages freed before the oldest reader
+	var w kvWriter
+	db.kv.BeginRead(&readers[0])
+	db.kv.writer.Lock()
+	writerBegin(&db.kv, &w)
+	if w.free.minReader != readers[0].version {
+		t.Errorf("expected the writer to see the min reader version %d, got %d", readers[0].version, w.free.minReader)
+	}
+	db.kv.writer.Unlock()
+	db.kv.EndRead(&readers[0])
+}
+
+func TestScanResumeToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupTestTable(t, db)
+	for id := int64(1); id <= 10; id++ {
+		insertTestRecord(t, db, id)
+	}
+
+	page := func(token []byte, desc bool) ([]int64, []byte) {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+
+		sc := Scanner{
+			Cmp1:       CMP_GE,
+			Cmp2:       CMP_LE,
+			Key1:       *(&Record{}).AddInt64("id", 2),
+			Key2:       *(&Record{}).AddInt64("id", 9),
+			Desc:       desc,
+			StartAfter: token,
+		}
+		if err := db.Scan("users", &sc, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ids []int64
+		for i := 0; i < 3 && sc.Valid(); i++ {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.Tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ids = append(ids, rec.Get("id").I64)
+			token = sc.Position()
+			sc.Next()
+		}
+		return ids, token
+	}
+
+	t.Run("ascending pages", func(t *testing.T) {
+		var got [][]int64
+		var token []byte
+		for {
+			ids, next := page(token, false)
+			if len(ids) == 0 {
+				break
+			}
+			got = append(got, ids)
+			token = next
+		}
+		if fmt.Sprint(got) != "[[2 3 4] [5 6 7] [8 9]]" {
+			t.Errorf("unexpected pages %v", got)
+		}
+	})
+
+	t.Run("descending pages", func(t *testing.T) {
+		first, token := page(nil, true)
+		second, _ := page(token, true)
+		if fmt.Sprint(first, second) != "[9 8 7] [6 5 4]" {
+			t.Errorf("unexpected pages %v %v", first, second)
+		}
+	})
+
+	t.Run("token of a deleted row", func(t *testing.T) {
+		_, token := page(nil, false)
+
+		var writer KVTX
+		db.kv.Begin(&writer)
+		if _, err := db.Delete("users", *(&Record{}).AddInt64("id", 4), &writer); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		db.kv.Commit(&writer)
+
+		ids, _ := page(token, false)
+		if !equalIDs(ids, []int64{5, 6, 7}) {
+			t.Errorf("expected ids [5 6 7], got %v", ids)
+		}
+	})
+
+	t.Run("token past the range", func(t *testing.T) {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		prefix := GetTableDef(db, "users", &reader.Tree).Prefix
+		db.kv.EndRead(&reader)
+		token := encodeKey(nil, prefix, []Value{{Type: TYPE_INT64, I64: 50}})
+		ids, _ := page(token, false)
+		if len(ids) != 0 {
+			t.Errorf("expected no rows, got %v", ids)
+		}
+	})
+
+	t.Run("token of another table", func(t *testing.T) {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+
+		sc := Scanner{
+			Cmp1:       CMP_GE,
+			Cmp2:       CMP_LE,
+			Key1:       *(&Record{}).AddInt64("id", 2),
+			Key2:       *(&Record{}).AddInt64("id", 9),
+			StartAfter: encodeKey(nil, TDEF_TABLE.Prefix, nil),
+		}
+		err := db.Scan("users", &sc, &reader.Tree)
+		if err == nil || !isEqual(err.Error(), "bad resume token") {
+			t.Errorf("expected error containing %q, got %v", "bad resume token", err)
+		}
+	})
+}
+
+func TestBulkInsert(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	insertIndexedRecord(t, db, 1000, "zz@x")
+
+	row := func(id int64) Record {
+		// the emails sort in the reverse order of the ids
+		email := fmt.Sprintf("%04d@x", 1000-id)
+		return *(&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email))
+	}
+	var rows []Record
+	for id := int64(1); id <= 500; id++ {
+		rows = append(rows, row(id))
+	}
+	var writer KVTX
+	db.kv.Begin(&writer)
+	if err := db.BulkInsert("people", rows, &writer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.kv.Commit(&writer); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Errorf("invalid tree: %v", err)
+	}
+	sc, err := db.ScanAll("people", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var expected []int64
+	for id := int64(1); id <= 500; id++ {
+		expected = append(expected, id)
+	}
+	expected = append(expected, 1000)
+	if got := scanIDs(sc, &reader.Tree); !equalIDs(got, expected) {
+		t.Errorf("expected ids 1..500 & 1000, got %v", got)
+	}
+
+	// the index is maintained
+	sc = &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddStr("email", []byte("0500@x")),
+		Key2: *(&Record{}).AddStr("email", []byte("0502@x")),
+	}
+	if err := db.Scan("people", sc, &reader.Tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &reader.Tree); !equalIDs(got, []int64{500, 499, 498}) {
+		t.Errorf("expected ids [500 499 498] from the index, got %v", got)
+	}
+	db.kv.EndRead(&reader)
+
+	tests := []struct {
+		name   string
+		rows   []Record
+		errMsg string
+	}{
+		{"unsorted", []Record{row(600), row(502)}, "row 1: rows must be sorted"},
+		{"duplicate", []Record{row(600), row(600)}, "row 1: rows must be sorted"},
+		{"existing row", []Record{row(600), row(1000)}, "record already exists"},
+		{"bad type", []Record{*(&Record{}).AddStr("id", []byte("1")).AddStr("name", nil).AddStr("email", nil)}, "row 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var writer KVTX
+			db.kv.Begin(&writer)
+			defer db.kv.Abort(&writer)
+			err := db.BulkInsert("people", tt.rows, &writer)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !isEqual(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+// the rows of even ids up to 2*n, as one bulk insert
+func insertEvenRows(t testing.TB, db *DB, n int) {
+	var rows []Record
+	for id := int64(2); id <= int64(2*n); id += 2 {
+		email := fmt.Sprintf("%d@x", id)
+		rows = append(rows, *(&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email)))
+	}
+	var writer KVTX
+	db.kv.Begin(&writer)
+	if err := db.BulkInsert("people", rows, &writer); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.kv.Commit(&writer); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	insertEvenRows(t, db, 2000)
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	// unsorted, duplicated, missing, close & far apart keys
+	ids := []int64{3000, 2, 4, 5, 3000, -1, 4001, 4000, 10, 12, 3998, 7, 100, 2}
+	var keys []Record
+	for _, id := range ids {
+		keys = append(keys, *(&Record{}).AddInt64("id", id))
+	}
+	rows, found, err := reader.MultiGet("people", keys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != len(ids) || len(found) != len(ids) {
+		t.Fatalf("expected %d results, got %d %d", len(ids), len(rows), len(found))
+	}
+	for i, id := range ids {
+		rec := (&Record{}).AddInt64("id", id)
+		ok, err := reader.Get("people", rec)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if found[i] != ok {
+			t.Errorf("id %d: expected found %v, got %v", id, ok, found[i])
+		} else if ok && formatRecord(rows[i]) != formatRecord(*rec) {
+			t.Errorf("id %d: expected %s, got %s", id, formatRecord(*rec), formatRecord(rows[i]))
+		}
+	}
+
+	if _, _, err := reader.MultiGet("people", []Record{*(&Record{}).AddStr("name", []byte("John"))}); err == nil {
+		t.Errorf("expected an error for a key without the primary key")
+	}
+	if _, _, err := reader.MultiGet("missing", nil); err == nil {
+		t.Errorf("expected an error for a missing table")
+	}
+}
+
+// 500 close keys in one call or 500 calls
+func BenchmarkMultiGet(b *testing.B) {
+	db := setupMemoryDB(b)
+	defer db.kv.Close()
+	setupIndexedTable(b, db)
+	insertEvenRows(b, db, 20000)
+	var keys []Record
+	for id := int64(10000); id < 11000; id += 2 {
+		keys = append(keys, *(&Record{}).AddInt64("id", id))
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+
+	b.Run("multi get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := reader.MultiGet("people", keys); err != nil {
+				b.Fatalf("multi get: %v", err)
+			}
+		}
+	})
+	b.Run("get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				rec := key
+				if _, err := reader.Get("people", &rec); err != nil {
+					b.Fatalf("get: %v", err)
+				}
+			}
+		}
+	})
+}
+
+func TestDeleteRangeTable(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	var rows []Record
+	for id := int64(1); id <= 300; id++ {
+		email := fmt.Sprintf("%03d@x", id)
+		rows = append(rows, *(&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email)))
+	}
+	var writer KVTX
+	db.kv.Begin(&writer)
+	if err := db.BulkInsert("people", rows, &writer); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.kv.Commit(&writer)
+
+	idRange := func(cmp1, cmp2 int, id1, id2 int64) *Scanner {
+		return &Scanner{
+			Cmp1: cmp1,
+			Cmp2: cmp2,
+			Key1: *(&Record{}).AddInt64("id", id1),
+			Key2: *(&Record{}).AddInt64("id", id2),
+		}
+	}
+	remaining := func(t *testing.T) []int64 {
+		var reader KVReader
+		db.kv.BeginRead(&reader)
+		defer db.kv.EndRead(&reader)
+		if err := reader.Tree.Verify(); err != nil {
+			t.Errorf("invalid tree: %v", err)
+		}
+		sc, _ := db.ScanAll("people", &reader.Tree)
+		ids := scanIDs(sc, &reader.Tree)
+		// the index has the same rows
+		isc := &Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddStr("email", []byte("000@x")),
+			Key2: *(&Record{}).AddStr("email", []byte("999@x")),
+		}
+		if err := db.Scan("people", isc, &reader.Tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if iids := scanIDs(isc, &reader.Tree); !equalIDs(ids, iids) {
+			t.Errorf("the index has %v, the table has %v", iids, ids)
+		}
+		return ids
+	}
+	deleteRange := func(t *testing.T, sc *Scanner, expected int) {
+		var tx DBTX
+		db.Begin(&tx)
+		n, err := tx.DeleteRange("people", sc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != expected {
+			t.Errorf("expected %d rows deleted, got %d", expected, n)
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+
+	deleteRange(t, idRange(CMP_GT, CMP_LE, 10, 250), 240)
+	if ids := remaining(t); len(ids) != 60 || ids[9] != 10 || ids[10] != 251 {
+		t.Errorf("expected ids 1..10 & 251..300, got %v", ids)
+	}
+
+	// by an index, with a limit
+	sc := &Scanner{
+		Cmp1:  CMP_GE,
+		Cmp2:  CMP_LE,
+		Key1:  *(&Record{}).AddStr("email", []byte("000@x")),
+		Key2:  *(&Record{}).AddStr("email", []byte("999@x")),
+		Desc:  true,
+		Limit: 5,
+	}
+	deleteRange(t, sc, 5)
+	if ids := remaining(t); len(ids) != 55 || ids[len(ids)-1] != 295 {
+		t.Errorf("expected ids 1..10 & 251..295, got %v", ids)
+	}
+
+	deleteRange(t, idRange(CMP_GE, CMP_LE, 500, 600), 0)
+
+	t.Run("aborted", func(t *testing.T) {
+		var tx DBTX
+		db.Begin(&tx)
+		n, err := tx.DeleteRange("people", idRange(CMP_GE, CMP_LE, 1, 300))
+		if err != nil || n != 55 {
+			t.Fatalf("expected 55 rows deleted, got %d, %v", n, err)
+		}
+		db.Abort(&tx)
+		if ids := remaining(t); len(ids) != 55 {
+			t.Errorf("expected the rows to be kept, got %v", ids)
+		}
+	})
+}
+
+func TestDeleteUpdateBy(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	var rows []Record
+	for id, email := range []string{"a@x", "b@x", "b@x", "c@x", "d@x"} {
+		rows = append(rows, *(&Record{}).AddInt64("id", int64(id+1)).AddStr("name", []byte("John")).AddStr("email", []byte(email)))
+	}
+	var tx DBTX
+	write := func(fn func() (int, error)) (int, error) {
+		t.Helper()
+		db.Begin(&tx)
+		n, err := fn()
+		if err != nil {
+			db.Abort(&tx)
+			return n, err
+		}
+		return n, db.Commit(&tx)
+	}
+	if _, err := write(func() (int, error) { return 0, tx.BulkInsert("people", rows) }); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	email := func(s string) Record {
+		return *(&Record{}).AddStr("email", []byte(s))
+	}
+	// the ids of the table & of the index entries of the email
+	ids := func(s string) ([]int64, []int64) {
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		if err := reader.kv.Tree.Verify(); err != nil {
+			t.Errorf("invalid tree: %v", err)
+		}
+		sc, _ := db.ScanAll("people", &reader.kv.Tree)
+		all := scanIDs(sc, &reader.kv.Tree)
+		sc = &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: email(s), Key2: email(s)}
+		if err := reader.Scan("people", sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		return all, scanIDs(sc, &reader.kv.Tree)
+	}
+
+	if n, err := write(func() (int, error) { return tx.DeleteBy("people", email("b@x")) }); n != 2 || err != nil {
+		t.Fatalf("expected 2 rows deleted, got %d %v", n, err)
+	}
+	if all, matched := ids("b@x"); !equalIDs(all, []int64{1, 4, 5}) || len(matched) != 0 {
+		t.Errorf("expected ids [1 4 5] & no index entry, got %v %v", all, matched)
+	}
+	if n, err := write(func() (int, error) { return tx.DeleteBy("people", email("z@x")) }); n != 0 || err != nil {
+		t.Errorf("expected no row deleted, got %d %v", n, err)
+	}
+
+	update := *(&Record{}).AddStr("name", []byte("Jane")).AddStr("email", []byte("e@x"))
+	if n, err := write(func() (int, error) { return tx.UpdateBy("people", email("c@x"), update) }); n != 1 || err != nil {
+		t.Fatalf("expected 1 row updated, got %d %v", n, err)
+	}
+	if _, matched := ids("c@x"); len(matched) != 0 {
+		t.Errorf("expected no index entry for the old email, got %v", matched)
+	}
+	if _, matched := ids("e@x"); !equalIDs(matched, []int64{4}) {
+		t.Errorf("expected id 4 for the new email, got %v", matched)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	rec := (&Record{}).AddInt64("id", 4)
+	if ok, err := reader.Get("people", rec); !ok || err != nil {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	db.EndRead(&reader)
+	if got := formatRecord(*rec); got != `id=4 name="Jane" email="e@x"` {
+		t.Errorf("unexpected row %s", got)
+	}
+
+	tests := []struct {
+		name   string
+		fn     func() (int, error)
+		errMsg string
+	}{
+		{"not indexed", func() (int, error) {
+			return tx.DeleteBy("people", *(&Record{}).AddStr("name", []byte("John")))
+		}, "no index starts with (name), the indexes of people: primary key (id), (email,id)"},
+		{"missing column", func() (int, error) {
+			return tx.UpdateBy("people", *(&Record{}).AddStr("phone", nil), update)
+		}, "column not found: phone in table people"},
+		{"primary key", func() (int, error) {
+			return tx.UpdateBy("people", email("a@x"), *(&Record{}).AddInt64("id", 9))
+		}, "cannot change the primary key column: id"},
+	}
+	for _, tt := range tests {
+		if _, err := write(tt.fn); err == nil || err.Error() != tt.errMsg {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.errMsg, err)
+		}
+	}
+}
+
+func TestTableStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer cleanupTestDB(t, db)
+
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 50; id++ {
+		insertIndexedRecord(t, db, id, fmt.Sprintf("%02d@x", id))
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+
+	stats, err := db.TableStats("people", &reader.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Primary.Keys != 50 || stats.Primary.BytesVals == 0 {
+		t.Errorf("unexpected primary stats %+v", stats.Primary)
+	}
+	if len(stats.Indexes) != 1 || stats.Indexes[0].Keys != 50 || stats.Indexes[0].BytesVals != 0 {
+		t.Fatalf("unexpected index stats %+v", stats.Indexes)
+	}
+	// prefix + 1 "NN@x\x00" + 1 id
+	if stats.Indexes[0].BytesKeys != 50*(4+1+5+1+8) {
+		t.Errorf("expected %d index key bytes, got %d", 50*(4+1+5+1+8), stats.Indexes[0].BytesKeys)
+	}
+	// the whole tree includes the internal tables
+	if all := reader.Tree.Stats(); all.Keys <= stats.Primary.Keys+stats.Indexes[0].Keys {
+		t.Errorf("expected more keys in the whole tree, got %+v", all)
+	}
+
+	if _, err := db.TableStats("missing", &reader.Tree); err == nil || !isEqual(err.Error(), "table not found") {
+		t.Errorf("expected a table not found error, got %v", err)
+	}
+}
+
+// the scans of a transaction see its inserts, updates & deletes, the others don't
+func TestScanOwnWrites(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	setupIndexedTable(t, db)
+	for id := int64(1); id <= 6; id++ {
+		insertTestRecord(t, db, id)
+	}
+	insertIndexedRecord(t, db, 1, "b@x")
+
+	var tx DBTX
+	db.Begin(&tx)
+	for _, id := range []int64{8, 0} {
+		if _, err := tx.Set("users", userRecord(id, "new"), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if _, err := tx.Set("users", userRecord(3, "updated"), MODE_UPDATE_ONLY); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", 2)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	del := &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddInt64("id", 5),
+		Key2: *(&Record{}).AddInt64("id", 6),
+	}
+	if n, err := tx.DeleteRange("users", del); n != 2 || err != nil {
+		t.Fatalf("expected 2 rows deleted, got %d %v", n, err)
+	}
+	if _, err := tx.Set("people", *(&Record{}).AddInt64("id", 2).AddStr("name", []byte("new")).AddStr("email", []byte("a@x")), MODE_INSERT_ONLY); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	scan := func(tree *BTree, desc bool) ([]int64, []string) {
+		t.Helper()
+		sc := &Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddInt64("id", 0),
+			Key2: *(&Record{}).AddInt64("id", 10),
+			Desc: desc,
+		}
+		if err := db.Scan("users", sc, tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ids []int64
+		var names []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ids = append(ids, rec.Get("id").I64)
+			names = append(names, string(rec.Get("name").Str))
+		}
+		return ids, names
+	}
+	ids, names := scan(&tx.kv.Tree, false)
+	if !equalIDs(ids, []int64{0, 1, 3, 4, 8}) || names[2] != "updated" {
+		t.Errorf("expected the transaction's writes, got %v %v", ids, names)
+	}
+	if ids, _ := scan(&tx.kv.Tree, true); !equalIDs(ids, []int64{8, 4, 3, 1, 0}) {
+		t.Errorf("expected the transaction's writes in reverse, got %v", ids)
+	}
+	sc := &Scanner{
+		Cmp1: CMP_GE,
+		Cmp2: CMP_LE,
+		Key1: *(&Record{}).AddStr("email", []byte("a")),
+		Key2: *(&Record{}).AddStr("email", []byte("z")),
+	}
+	if err := tx.Scan("people", sc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &tx.kv.Tree); !equalIDs(got, []int64{2, 1}) {
+		t.Errorf("expected the new index key, got %v", got)
+	}
+	if ok, _ := tx.Get("users", (&Record{}).AddInt64("id", 2)); ok {
+		t.Errorf("expected the deleted row to be gone in the transaction")
+	}
+
+	// the REPL queries read the open transaction
+	query := func(tx *DBTX) []int64 {
+		t.Helper()
+		response := make(chan GetResponse, 1)
+		processQueryRequest(QueryRequest{
+			tableName: "users",
+			cols:      []string{"id"},
+			startVals: []string{"0"},
+			endVals:   []string{"10"},
+			queryType: RangeQuery,
+			response:  response,
+			tx:        tx,
+		}, db)
+		resp := <-response
+		if resp.err != nil {
+			t.Fatalf("unexpected error: %v", resp.err)
+		}
+		var ids []int64
+		for _, rec := range resp.records {
+			ids = append(ids, rec.Get("id").I64)
+		}
+		return ids
+	}
+	if got := query(&tx); !equalIDs(got, []int64{0, 1, 3, 4, 8}) {
+		t.Errorf("expected the query in the transaction to see its writes, got %v", got)
+	}
+	if got := query(nil); !equalIDs(got, []int64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("expected the query outside to see the last commit, got %v", got)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	if ids, names := scan(&reader.Tree, false); !equalIDs(ids, []int64{1, 2, 3, 4, 5, 6}) || names[2] != "John" {
+		t.Errorf("expected the last commit outside the transaction, got %v %v", ids, names)
+	}
+	db.kv.EndRead(&reader)
+
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	if ids, _ := scan(&reader.Tree, false); !equalIDs(ids, []int64{0, 1, 3, 4, 8}) {
+		t.Errorf("expected the writes after the commit, got %v", ids)
+	}
+}
+
+// the keys of the new types sort like their values
+func TestScanColumnTypes(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "samples",
+		Types:   []uint32{TYPE_FLOAT64, TYPE_TIME, TYPE_INT32, TYPE_BOOL},
+		Cols:    []string{"x", "at", "n", "ok"},
+		PKeys:   1,
+		Indexes: [][]string{{"at"}, {"n"}, {"ok"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	xs := []float64{3.5, -0.25, 0, -1e10, 1e-300, -7, 42}
+	ats := []time.Time{
+		time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(1900, 6, 1, 0, 0, 0, 0, time.UTC),
+		time.Unix(0, 0),
+		time.Date(1970, 1, 1, 0, 0, 0, 1, time.UTC),
+		time.Date(1812, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	ns := []int32{5, -5, 0, math.MinInt32, math.MaxInt32, -1, 1}
+	for i, x := range xs {
+		rec := (&Record{}).AddFloat64("x", x).AddTime("at", ats[i]).AddInt32("n", ns[i]).AddBool("ok", x > 0)
+		if _, err := tx.Set("samples", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	bad := (&Record{}).AddFloat64("x", 1).AddTime("at", ats[0]).AddInt64("n", 0).AddBool("ok", true)
+	bad.Vals[2] = Value{Type: TYPE_INT32, I64: math.MaxInt32 + 1}
+	if _, err := tx.Set("samples", *bad, MODE_INSERT_ONLY); err == nil {
+		t.Errorf("expected an int32 out of range to be rejected")
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	scan := func(sc Scanner, col string) []string {
+		t.Helper()
+		if err := reader.Scan("samples", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			got = append(got, formatValue(*rec.Get(col)))
+		}
+		return got
+	}
+	all := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	expected := map[string][]string{
+		"x":  {"-1e+10", "-7", "-0.25", "0", "1e-300", "3.5", "42"},
+		"at": {"1812-01-01T00:00:00Z", "1900-06-01T00:00:00Z", "1969-12-31T23:59:59Z", "1970-01-01T00:00:00Z", "1970-01-01T00:00:00.000000001Z", "2024-01-02T03:04:05.000000006Z", "2100-01-01T00:00:00Z"},
+		"n":  {"-2147483648", "-5", "-1", "0", "1", "5", "2147483647"},
+		"ok": {"false", "false", "false", "false", "true", "true", "true"},
+	}
+	for col, want := range expected {
+		sc := all
+		if col != "x" {
+			sc.Key1, sc.Key2 = Record{Cols: []string{col}}, Record{Cols: []string{col}}
+		}
+		if got := scan(sc, col); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: expected %v, got %v", col, want, got)
+		}
+	}
+
+	// ranges over negative floats & times before 1970
+	got := scan(Scanner{
+		Cmp1: CMP_GT, Cmp2: CMP_LT,
+		Key1: *(&Record{}).AddFloat64("x", -1e10), Key2: *(&Record{}).AddFloat64("x", 0),
+	}, "x")
+	if want := "-7,-0.25"; strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+	got = scan(Scanner{
+		Cmp1: CMP_GE, Cmp2: CMP_LT,
+		Key1: *(&Record{}).AddTime("at", ats[2]), Key2: *(&Record{}).AddTime("at", time.Unix(0, 0)),
+	}, "at")
+	if want := "1900-06-01T00:00:00Z,1969-12-31T23:59:59Z"; strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+
+	// the REPL values
+	for typ, s := range map[uint32]string{
+		TYPE_INT32: "-12", TYPE_FLOAT64: "-2.5", TYPE_BOOL: "true", TYPE_TIME: "1950-05-06T07:08:09Z",
+	} {
+		v, err := parseValue(typ, s)
+		if err != nil || formatValue(v) != s {
+			t.Errorf("type %d: expected %s, got %s %v", typ, s, formatValue(v), err)
+		}
+	}
+	if _, err := parseValue(TYPE_INT32, "3000000000"); err == nil {
+		t.Errorf("expected an int32 out of range to be rejected")
+	}
+}
+
+func TestNullValues(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	err := tx.TableNew(&TableDef{
+		Name:    "contacts",
+		Types:   []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "email", "age", "nick"},
+		PKeys:   1,
+		Indexes: [][]string{{"email"}, {"age"}},
+		Unique:  []bool{true, false},
+		NotNull: []bool{false, false, false, true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	rows := []*Record{
+		(&Record{}).AddInt64("id", 1).AddStr("email", []byte("a@x")).AddInt64("age", 30),
+		(&Record{}).AddInt64("id", 2).AddNull("email", TYPE_BYTES), // the age is omitted
+		(&Record{}).AddInt64("id", 3).AddNull("email", TYPE_BYTES).AddInt64("age", 20),
+		(&Record{}).AddInt64("id", 4).AddStr("email", []byte("b@x")).AddInt64("age", -5),
+		(&Record{}).AddInt64("id", 5).AddStr("email", []byte{}).AddInt64("age", 0),
+	}
+	for _, rec := range rows {
+		// two nulls are not the same unique value
+		if _, err := tx.Set("contacts", *rec.AddStr("nick", []byte("n")), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert %s: %v", formatRecord(*rec), err)
+		}
+	}
+	for _, rec := range []*Record{
+		(&Record{}).AddInt64("id", 6).AddNull("nick", TYPE_BYTES),
+		(&Record{}).AddNull("id", TYPE_INT64).AddStr("nick", []byte("n")),
+	} {
+		if _, err := tx.Set("contacts", *rec, MODE_INSERT_ONLY); !errors.Is(err, ErrNotNull) {
+			t.Errorf("%s: expected ErrNotNull, got %v", formatRecord(*rec), err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	scan := func(sc Scanner, col string) string {
+		t.Helper()
+		if err := reader.Scan("contacts", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			got = append(got, formatValue(*rec.Get(col)))
+		}
+		return strings.Join(got, ",")
+	}
+	null := func(col string, typ uint32) Record {
+		return *(&Record{}).AddNull(col, typ)
+	}
+	for _, c := range []struct {
+		sc       Scanner
+		col      string
+		expected string
+	}{
+		// the nulls sort first
+		{Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: Record{Cols: []string{"age"}}, Key2: Record{Cols: []string{"age"}}}, "age", "NULL,-5,0,20,30"},
+		{Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: Record{Cols: []string{"email"}}, Key2: Record{Cols: []string{"email"}}}, "email", "NULL,NULL,,a@x,b@x"},
+		// the rows where the column is null, through the index
+		{Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: null("email", TYPE_BYTES), Key2: null("email", TYPE_BYTES)}, "id", "2,3"},
+		{Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: null("age", TYPE_INT64), Key2: null("age", TYPE_INT64)}, "id", "2"},
+		{Scanner{Cmp1: CMP_GT, Cmp2: CMP_LT, Key1: null("age", TYPE_INT64), Key2: *(&Record{}).AddInt64("age", 30)}, "age", "-5,0,20"},
+		{Scanner{Cmp1: CMP_LE, Cmp2: CMP_GE, Key1: *(&Record{}).AddInt64("age", -5), Key2: null("age", TYPE_INT64)}, "age", "-5,NULL"},
+	} {
+		if got := scan(c.sc, c.col); got != c.expected {
+			t.Errorf("%s from %s to %s: expected %s, got %s", c.col, formatRecord(c.sc.Key1), formatRecord(c.sc.Key2), c.expected, got)
+		}
+	}
+
+	// a null & an empty string read back as written
+	for id, null := range map[int64]bool{2: true, 5: false} {
+		rec := (&Record{}).AddInt64("id", id)
+		if ok, err := dbGet(db, GetTableDef(db, "contacts", &reader.kv.Tree), rec, &reader.kv.Tree); !ok || err != nil {
+			t.Fatalf("failed to get %d: %v %v", id, ok, err)
+		}
+		if v := rec.Get("email"); v.Null != null || len(v.Str) != 0 {
+			t.Errorf("id %d: expected the email null %v, got %+v", id, null, *v)
+		}
+	}
+	if v, err := parseValue(TYPE_INT64, "NULL"); err != nil || !v.Null {
+		t.Errorf("expected NULL to be parsed as a null, got %+v %v", v, err)
+	}
+}
+
+// the rows of a 100k-row table by the primary key & by an index, read into
+// the same Record
+func BenchmarkScan(b *testing.B) {
+	db := setupMemoryDB(b)
+	defer db.kv.Close()
+	setupIndexedTable(b, db)
+	insertEvenRows(b, db, 100_000)
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+
+	for name, bounds := range map[string][2]Record{
+		"primary": {*(&Record{}).AddInt64("id", 0), *(&Record{}).AddInt64("id", 1<<40)},
+		"index":   {*(&Record{}).AddStr("email", []byte("0")), *(&Record{}).AddStr("email", []byte("a"))},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: bounds[0], Key2: bounds[1]}
+				if err := reader.Scan("people", &sc); err != nil {
+					b.Fatalf("scan: %v", err)
+				}
+				rec, n := Record{}, 0
+				for ; sc.Valid(); sc.Next() {
+					if err := sc.Read(&rec); err != nil {
+						b.Fatalf("read: %v", err)
+					}
+					n++
+				}
+				if n != 100_000 {
+					b.Fatalf("expected 100000 rows, got %d", n)
+				}
+			}
+		})
+	}
+}
+
+// the rows read into one Record share its values, the bytes of each row
+// are its own
+func TestScanReuse(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	insertEvenRows(t, db, 500)
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+
+	for _, project := range [][]string{nil, {"email", "name"}, {"name"}} {
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Project: project,
+			Key1: *(&Record{}).AddStr("email", []byte("0")), Key2: *(&Record{}).AddStr("email", []byte("a"))}
+		if err := reader.Scan("people", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var emails [][]byte
+		rec := Record{}
+		for ; sc.Valid(); sc.Next() {
+			if err := sc.Read(&rec); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if project == nil || project[0] == "email" {
+				emails = append(emails, rec.Get("email").Str)
+			}
+			if name := rec.Get("name"); name == nil || string(name.Str) != "John" {
+				t.Fatalf("expected the name of the row, got %v", rec)
+			}
+		}
+		for i := 1; i < len(emails); i++ {
+			if bytes.Compare(emails[i-1], emails[i]) >= 0 {
+				t.Fatalf("expected the emails kept in order, got %q then %q", emails[i-1], emails[i])
+			}
+		}
+		if project == nil && len(emails) != 500 {
+			t.Errorf("expected 500 rows, got %d", len(emails))
+		}
+	}
+}
+
+func TestScanBoundsCheck(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupIndexedTable(t, db)
+	insertEvenRows(t, db, 5)
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+
+	email := func(s string) Record { return *(&Record{}).AddStr("email", []byte(s)) }
+	tests := []struct {
+		name       string
+		key1, key2 Record
+		err        error
+	}{
+		{"unknown column", *(&Record{}).AddInt64("nope", 1), Record{}, ErrColumnNotFound},
+		{"value type", *(&Record{}).AddInt64("email", 1), email("z"), ErrTypeMismatch},
+		{"other columns", email("a"), *(&Record{}).AddInt64("id", 9), ErrBadRange},
+		{"more values than columns", Record{Vals: []Value{{Type: TYPE_INT64}}}, Record{}, ErrBadRange},
+	}
+	for _, tt := range tests {
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: tt.key1, Key2: tt.key2}
+		if err := reader.Scan("people", &sc); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddInt64("nope", 1)}
+	if err := reader.Scan("people", &sc); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected the unknown column named, got %v", err)
+	}
+
+	// an empty bound leaves its side open, the other one picks the index
+	sc = Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key2: email("4@x")}
+	if err := reader.Scan("people", &sc); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var got []string
+	for rec := (Record{}); sc.Valid(); sc.Next() {
+		if err := sc.Deref(&rec, reader.Tree()); err != nil {
+			t.Fatalf("deref: %v", err)
+		}
+		got = append(got, string(rec.Get("email").Str))
+	}
+	if strings.Join(got, ",") != "10@x,2@x,4@x" {
+		t.Errorf("expected the emails up to 4@x, got %v", got)
+	}
+}
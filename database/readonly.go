@@ -0,0 +1,32 @@
+package database
+
+import (
+	"errors"
+)
+
+var ErrReadOnly error = errors.New("the database is open read-only")
+
+// opens a DB file without writing to it: the file is mapped read-only &
+// the master page & the log are only read. the commits still in the log are
+// kept in memory. reads & transactions work, the writes fail with ErrReadOnly.
+// the file is locked shared, so other readers can open it but not a writer.
+func OpenReadOnly(path string) (*DB, error) {
+	return Open(path, WithReadOnly())
+}
+
+// writes the log into the file & unmaps it, the DB is not used after. the
+// channels of Watch are closed. the readers & transactions must have ended.
+func (db *DB) Close() error {
+	db.kv.writer.Lock()
+	db.kv.watch.close()
+	db.kv.writer.Unlock()
+	err := bloomPersist(db)
+	if err != nil {
+		db.logger().Warn("bloom filters not written", "err", err)
+	}
+	err = db.kv.Close()
+	if db.pool != nil {
+		db.pool.Stop()
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fileState struct {
+	info os.FileInfo
+	data []byte
+}
+
+func statFile(t *testing.T, path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return fileState{info, data}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owned.db")
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Fatalf("expected a missing file not to be opened")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be created, got %v", err)
+	}
+
+	// the owner leaves the last rows in the log
+	owner := &DB{Path: path, kv: KV{Path: path, CheckpointSize: 1 << 40}, tables: make(map[string]cachedDef)}
+	if err := owner.kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if err := initializeInternalTables(owner); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+	setupTestTable(t, owner)
+	for id := int64(1); id <= 10; id++ {
+		insertTestRecord(t, owner, id)
+	}
+	crashKV(&owner.kv)
+	main, log := statFile(t, path), statFile(t, walPath(path))
+	if len(log.data) == 0 {
+		t.Fatalf("expected commits in the log")
+	}
+
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("failed to open read-only: %v", err)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	if GetTableDef(db, "users", &reader.kv.Tree) == nil {
+		t.Fatalf("expected the table definition")
+	}
+	sc, err := db.ScanAll("users", &reader.kv.Tree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := scanIDs(sc, &reader.kv.Tree); !equalIDs(got, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Errorf("expected the rows of the log, got %v", got)
+	}
+	rec := (&Record{}).AddInt64("id", 10)
+	if ok, err := reader.Get("users", rec); !ok || err != nil || string(rec.Get("name").Str) != "John" {
+		t.Errorf("expected the row, got %v %v %v", ok, err, rec)
+	}
+	db.EndRead(&reader)
+
+	var tx DBTX
+	db.Begin(&tx)
+	row := *(&Record{}).AddInt64("id", 11).AddStr("name", []byte("Jane")).AddStr("email", []byte("jane@example.com"))
+	id := *(&Record{}).AddInt64("id", 1)
+	writes := map[string]error{
+		"TableNew": tx.TableNew(&TableDef{
+			Name:  "other",
+			Types: []uint32{TYPE_INT64},
+			Cols:  []string{"id"},
+			PKeys: 1,
+		}),
+		"BulkInsert": tx.BulkInsert("users", []Record{row}),
+	}
+	_, writes["Insert"] = tx.Set("users", row, MODE_INSERT_ONLY)
+	_, writes["Update"] = tx.Set("users", id, MODE_UPDATE_ONLY)
+	_, writes["Delete"] = tx.Delete("users", id)
+	_, writes["DeleteRange"] = tx.DeleteRange("users", &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: id, Key2: id})
+	writes["KV Set"] = tx.kv.Set([]byte("key"), []byte("val"))
+	for name, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Errorf("expected a commit without writes to succeed, got %v", err)
+	}
+	// a write past the checks is stopped by the commit
+	db.Begin(&tx)
+	tx.kv.Update(&InsertReq{Key: []byte("key"), Value: []byte("val")})
+	if err := db.Commit(&tx); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected the commit to fail with ErrReadOnly, got %v", err)
+	}
+	db.Close()
+
+	for name, before := range map[string]fileState{path: main, walPath(path): log} {
+		after := statFile(t, name)
+		if !after.info.ModTime().Equal(before.info.ModTime()) {
+			t.Errorf("%s: expected the mtime to be kept, got %v -> %v", name, before.info.ModTime(), after.info.ModTime())
+		}
+		if !bytes.Equal(after.data, before.data) {
+			t.Errorf("%s: expected the contents to be kept", name)
+		}
+	}
+}
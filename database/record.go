@@ -5,12 +5,25 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sync"
+	"time"
 )
 
 const (
-	TYPE_ERROR = 0
-	TYPE_INT64 = 1
-	TYPE_BYTES = 2
+	TYPE_ERROR   = 0
+	TYPE_INT64   = 1
+	TYPE_BYTES   = 2
+	TYPE_INT32   = 3 // in I64
+	TYPE_FLOAT64 = 4
+	TYPE_BOOL    = 5 // in I64, 0 or 1
+	TYPE_TIME    = 6 // in I64, unix nanoseconds
+)
+
+// the first byte of an encoded value
+const (
+	VALUE_NULL = 0
+	VALUE_SET  = 1
 )
 
 // table row
@@ -24,13 +37,42 @@ type Value struct {
 	Type uint32
 	I64  int64
 	Str  []byte
+	F64  float64
+	Null bool // no value, the other fields are zero but the Type
 }
 
+// every method of it is safe for concurrent use once opened, but Close: each
+// goroutine reads in a DBReader & writes in a DBTX of its own. the readers
+// never wait, a transaction writes to its own tree & the commits apply them
+// one at a time, so a writer never blocks the readers & the scans. the
+// settings of the fields & the hooks are made before the DB is shared.
 type DB struct {
-	Path   string
-	kv     KV
-	pool   *WorkerPool
-	tables map[string]*TableDef // cached table definition
+	Path     string
+	kv       KV
+	pool     *WorkerPool
+	tables   map[string]cachedDef // cached table definition
+	tablesMu sync.RWMutex         // guards tables
+	// how long a write waits for a row lock of GetForUpdate, 0 for ROW_LOCK_TIMEOUT
+	LockTimeout time.Duration
+	// the rows ScanOrdered sorts in memory before it spills them to temporary
+	// files, 0 for SORT_MEMORY_ROWS
+	SortRows int
+	// the groups GroupBy keeps in a hash table before it fails with
+	// ErrMemoryLimit, 0 for GROUP_MEMORY_ROWS
+	GroupRows int
+	// the distinct rows a Scanner remembers before it fails with
+	// ErrMemoryLimit, 0 for DISTINCT_MEMORY_ROWS
+	DistinctRows int
+	// the Get, write, Delete & Commit at least this long are logged as a
+	// warning, 0 for none
+	SlowThreshold time.Duration
+	locks         lockTable
+	autoinc       autoIncrement
+	exec          execCounters // see Measure
+	metrics       dbMetrics    // see Metrics
+	hooks         struct {
+		autocommit []func(table string, rec Record) // see OnAutocommit
+	}
 }
 
 type TableDef struct {
@@ -39,9 +81,21 @@ type TableDef struct {
 	Cols    []string // column names
 	PKeys   int      // the first `PKeys` columns are the pimary key
 	Indexes [][]string
+	Unique  []bool `json:",omitempty"` // by index, no two rows with the same values
+	// by column, the value of an insert without the column & of the rows
+	// written before it was added. Value{} for none.
+	Defaults []Value `json:",omitempty"`
+	NotNull  []bool  `json:",omitempty"` // by column, see rowFill
+	// the primary key of an insert without it is the next value, see autoincFill
+	AutoIncrement bool         `json:",omitempty"`
+	ForeignKeys   []ForeignKey `json:",omitempty"`
+	Referenced    []string     `json:",omitempty"` // the tables with a foreign key to this one
 	// auto-assigned B-tree key prefixes for different tables/indexes
 	Prefix      uint32
 	IndexPrefix []uint32
+	// counts the changes of the definition, with the Prefix it tells a
+	// statement prepared for the table it changed
+	Version uint64 `json:",omitempty"`
 }
 
 // internal table: metadata
@@ -78,6 +132,44 @@ func (rec *Record) AddInt64(key string, val int64) *Record {
 	return rec
 }
 
+func (rec *Record) AddInt32(key string, val int32) *Record {
+	rec.Cols = append(rec.Cols, key)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_INT32, I64: int64(val)})
+	return rec
+}
+
+func (rec *Record) AddFloat64(key string, val float64) *Record {
+	rec.Cols = append(rec.Cols, key)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_FLOAT64, F64: val})
+	return rec
+}
+
+func (rec *Record) AddBool(key string, val bool) *Record {
+	v := Value{Type: TYPE_BOOL}
+	if val {
+		v.I64 = 1
+	}
+	rec.Cols = append(rec.Cols, key)
+	rec.Vals = append(rec.Vals, v)
+	return rec
+}
+
+func (rec *Record) AddTime(key string, val time.Time) *Record {
+	rec.Cols = append(rec.Cols, key)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_TIME, I64: val.UnixNano()})
+	return rec
+}
+
+func (rec *Record) AddNull(key string, typ uint32) *Record {
+	rec.Cols = append(rec.Cols, key)
+	rec.Vals = append(rec.Vals, Value{Type: typ, Null: true})
+	return rec
+}
+
+func (v *Value) Time() time.Time {
+	return time.Unix(0, v.I64)
+}
+
 func (rec *Record) Get(key string) *Value {
 	for i, col := range rec.Cols {
 		if key == col {
@@ -87,24 +179,68 @@ func (rec *Record) Get(key string) *Value {
 	return nil
 }
 
+// a definition of DB.tables & the version of the catalog it was read from
+type cachedDef struct {
+	tdef    *TableDef
+	catalog uint64
+}
+
+// the definition of the table in the tree, nil if there is none. the one
+// read from the latest catalog is cached, a tree of an older snapshot reads
+// its own & a transaction that wrote the catalog caches its own.
 func GetTableDef(db *DB, name string, tree *BTree) *TableDef {
-	tdef, ok := db.tables[name]
-	if !ok {
-		if db.tables == nil {
-			db.tables = map[string]*TableDef{}
+	if tree.catalog == 0 {
+		tdef, ok := tree.defs[name]
+		if !ok {
+			tdef = getTableDefDB(db, name, tree)
+			if tree.defs != nil && tdef != nil {
+				tree.defs[name] = tdef
+			}
 		}
-		tdef = getTableDefDB(db, name, tree)
-		if tdef != nil {
-			db.tables[name] = tdef
+		return tdef
+	}
+	db.tablesMu.RLock()
+	cached, ok := db.tables[name]
+	db.tablesMu.RUnlock()
+	if ok && cached.catalog == tree.catalog {
+		return cached.tdef
+	}
+	tdef := getTableDefDB(db, name, tree)
+	if tdef != nil {
+		db.tablesMu.Lock()
+		if cached, ok := db.tables[name]; !ok || cached.catalog < tree.catalog {
+			if db.tables == nil {
+				db.tables = map[string]cachedDef{}
+			}
+			db.tables[name] = cachedDef{tdef: tdef, catalog: tree.catalog}
 		}
+		db.tablesMu.Unlock()
 	}
 	return tdef
 }
 
+// counts the commits that changed a table definition since the DB was
+// opened, from 1. a definition read at an unchanged version is the same.
+func (db *DB) SchemaVersion() uint64 {
+	db.kv.mu.Lock()
+	defer db.kv.mu.Unlock()
+	return db.kv.catalog + 1
+}
+
+// drop the cached definitions of the tables, read again from the catalog
+func (db *DB) uncache(names ...string) {
+	db.tablesMu.Lock()
+	for _, name := range names {
+		delete(db.tables, name)
+	}
+	db.tablesMu.Unlock()
+}
+
 func getTableDefDB(db *DB, name string, tree *BTree) *TableDef {
 	rec := (&Record{}).AddStr("name", []byte(name))
-	// get the tdef from the `BTree` using the PKey - `name`
-	ok, err := dbGet(db, TDEF_TABLE, rec, tree)
+	// get the tdef from the `BTree` using the PKey - `name`, without a DB
+	// so it is not counted in the rows of the command
+	ok, err := dbGet(nil, TDEF_TABLE, rec, tree)
 	if err != nil {
 		return nil
 	}
@@ -117,7 +253,7 @@ func getTableDefDB(db *DB, name string, tree *BTree) *TableDef {
 		err = json.Unmarshal(rec.Get("def").Str, tdef)
 	}
 	if err != nil {
-		fmt.Println("Err while Unmarshal: ", err.Error())
+		db.logger().Error("bad table definition", "table", name, "err", err)
 		return nil
 	}
 	return tdef
@@ -135,7 +271,9 @@ func dbGet(db *DB, tdef *TableDef, rec *Record, tree *BTree) (bool, error) {
 		return false, err
 	}
 	if sc.Valid() {
-		sc.Deref(rec, tree)
+		if err := sc.Deref(rec, tree); err != nil {
+			return false, err
+		}
 		return true, nil
 	} else {
 		return false, nil
@@ -169,7 +307,9 @@ func dbGetRange(db *DB, tdef *TableDef, start *Record, end *Record, tree *BTree)
 			Vals: make([]Value, len(tdef.Cols)),
 		}
 		copy(rec.Cols, tdef.Cols)
-		sc.Deref(rec, tree)
+		if err := sc.Deref(rec, tree); err != nil {
+			return nil, err
+		}
 		results = append(results, rec)
 		sc.Next()
 	}
@@ -177,14 +317,37 @@ func dbGetRange(db *DB, tdef *TableDef, start *Record, end *Record, tree *BTree)
 	return results, nil
 }
 
+// each value starts with a byte, VALUE_NULL sorts the nulls before the values
 func encodeValues(out []byte, vals []Value) []byte {
 	for _, v := range vals {
+		if v.Null {
+			out = append(out, VALUE_NULL)
+			continue
+		}
+		out = append(out, VALUE_SET)
 		switch v.Type {
-		case TYPE_INT64:
+		case TYPE_INT64, TYPE_TIME:
 			var buf [8]byte
 			u := uint64(v.I64) + (1 << 63)
 			binary.BigEndian.PutUint64(buf[:], u)
 			out = append(out, buf[:]...)
+		case TYPE_INT32:
+			out = binary.BigEndian.AppendUint32(out, uint32(int32(v.I64))+(1<<31))
+		case TYPE_FLOAT64:
+			// the negative numbers are flipped to sort before the positive ones
+			u := math.Float64bits(v.F64)
+			if u&(1<<63) != 0 {
+				u = ^u
+			} else {
+				u |= 1 << 63
+			}
+			out = binary.BigEndian.AppendUint64(out, u)
+		case TYPE_BOOL:
+			if v.I64 != 0 {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+			}
 		case TYPE_BYTES:
 			if v.Str == nil {
 				out = append(out, 0)
@@ -199,37 +362,87 @@ func encodeValues(out []byte, vals []Value) []byte {
 	return out
 }
 
-func decodeValues(in []byte, out []Value) {
+// returns the number of values decoded, the input may end before out
+func decodeValues(in []byte, out []Value) int {
 	remaining := in
 	for i, v := range out {
-		switch v.Type {
-		case TYPE_INT64:
-			if len(remaining) < 8 {
-				return
-			}
-			u := binary.BigEndian.Uint64(remaining[:8])
-			val := int64(u - (1 << 63))
-			out[i] = Value{Type: TYPE_INT64, I64: val}
-			remaining = remaining[8:]
-		case TYPE_BYTES:
-			end := 0
-			for end < len(remaining) && remaining[end] != 0 {
-				end++
-			}
-			if end >= len(remaining) {
-				return
-			}
-			unEscStr := unEscapeString(remaining[:end])
-			out[i] = Value{Type: TYPE_BYTES, Str: unEscStr}
-			remaining = remaining[end+1:]
-		default:
-			panic("invalid type while decodeValues")
+		if len(remaining) < 1 {
+			return i
+		}
+		tag := remaining[0]
+		remaining = remaining[1:]
+		if tag == VALUE_NULL {
+			out[i] = Value{Type: v.Type, Null: true}
+			continue
+		}
+		n := 0
+		if out[i], n = decodeValue(remaining, v.Type); n < 0 {
+			return i
+		}
+		remaining = remaining[n:]
+	}
+	return len(out)
+}
+
+// a value without the leading byte & its length, -1 if the input ends before it
+func decodeValue(in []byte, typ uint32) (Value, int) {
+	switch typ {
+	case TYPE_INT64, TYPE_TIME:
+		if len(in) < 8 {
+			return Value{}, -1
+		}
+		u := binary.BigEndian.Uint64(in[:8])
+		return Value{Type: typ, I64: int64(u - (1 << 63))}, 8
+	case TYPE_INT32:
+		if len(in) < 4 {
+			return Value{}, -1
+		}
+		u := binary.BigEndian.Uint32(in[:4])
+		return Value{Type: TYPE_INT32, I64: int64(int32(u - (1 << 31)))}, 4
+	case TYPE_FLOAT64:
+		if len(in) < 8 {
+			return Value{}, -1
+		}
+		u := binary.BigEndian.Uint64(in[:8])
+		if u&(1<<63) != 0 {
+			u &^= 1 << 63
+		} else {
+			u = ^u
+		}
+		return Value{Type: TYPE_FLOAT64, F64: math.Float64frombits(u)}, 8
+	case TYPE_BOOL:
+		if len(in) < 1 {
+			return Value{}, -1
+		}
+		return Value{Type: TYPE_BOOL, I64: int64(in[0])}, 1
+	case TYPE_BYTES:
+		end := bytes.IndexByte(in, 0)
+		if end < 0 {
+			return Value{}, -1
+		}
+		return Value{Type: TYPE_BYTES, Str: unEscapeString(in[:end])}, end + 1
+	default:
+		panic("invalid type while decodeValues")
+	}
+}
+
+// the non-key values of a row from out[0] = column tdef.PKeys. a row written
+// before an ADD COLUMN has fewer values, the missing ones are the defaults
+// or nulls.
+func decodeRow(tdef *TableDef, in []byte, out []Value) {
+	for i := decodeValues(in, out); i < len(out); i++ {
+		col := tdef.PKeys + i
+		if col < len(tdef.Defaults) && tdef.Defaults[col].Type != 0 {
+			out[i] = tdef.Defaults[col]
+		} else {
+			out[i] = Value{Type: tdef.Types[col], Null: true}
 		}
 	}
 }
 
 // Strings are encoded as nul terminated strings,
 // escape the nul byte so that strings contain no nul byte.
+// 0x00 -> 0x01 0x01 & 0x01 -> 0x01 0x02 keep the order of the strings.
 func escapeString(in []byte) []byte {
 	zeros := bytes.Count(in, []byte{0})
 	ones := bytes.Count(in, []byte{1})
@@ -237,71 +450,30 @@ func escapeString(in []byte) []byte {
 	if zeros+ones == 0 {
 		return in
 	}
-	out := make([]byte, len(in)+zeros+ones)
-	pos := 0
-	if len(in) > 0 && in[0] >= 0xfe {
-		out[0] = 0xfe
-		out[1] = in[0]
-		pos += 2
-		in = in[1:]
-	}
+	out := make([]byte, 0, len(in)+zeros+ones)
 	for _, ch := range in {
 		if ch <= 1 { // if null character found
-			out[pos+0] = 0x01 // replace null character by escaping character
-			out[pos+1] = ch + 1
-			pos += 2
+			out = append(out, 0x01, ch+1) // replace null character by escaping character
 		} else {
-			out[pos] = ch
-			pos += 1
+			out = append(out, ch)
 		}
 	}
 	return out
 }
 
 func unEscapeString(in []byte) []byte {
-	if len(in) == 0 {
+	if bytes.IndexByte(in, 0x01) < 0 {
 		return in
 	}
-
-	escapeCount := 0
+	out := make([]byte, 0, len(in))
 	for i := 0; i < len(in); i++ {
 		if in[i] == 0x01 && i+1 < len(in) {
-			escapeCount++
 			i++
-		}
-	}
-
-	if escapeCount == 0 && (len(in) == 0 || in[0] != 0xfe) {
-		return in
-	}
-	outLen := len(in) - escapeCount
-	if in[0] == 0xfe {
-		outLen--
-	}
-	out := make([]byte, outLen)
-	pos := 0
-	i := 0
-	if in[0] == 0xfe {
-		if len(in) < 2 {
-			return in
-		}
-		out[pos] = in[1]
-		pos++
-		i += 2
-	}
-
-	for i < len(in) {
-		if in[i] == 0x01 && i+1 < len(in) {
-			out[pos] = in[i+1] - 1
-			pos++
-			i += 2
+			out = append(out, in[i]-1)
 		} else {
-			out[pos] = in[i]
-			pos++
-			i++
+			out = append(out, in[i])
 		}
 	}
-
 	return out
 }
 
@@ -0,0 +1,450 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"sort"
+)
+
+/// Replication
+// A leader opened WithReplicationLog logs each commit as an entry of the meta
+// table, written by the commit itself: the final values of the keys it wrote.
+// a replica is a copy of the leader made by Backup & opened by OpenReplica,
+// it applies the entries after its last one with ApplyLog, each as a commit
+// of its own, so its position is the last entry it has. the entries chain
+// the checksums of the ones before them, a replica of another history is
+// told apart.
+// head:  | seq | chain | first | base |
+//        | 8B  | 8B    | 8B    | 8B   |
+// the last entry, the first one kept & the chain before the first one.
+// entry: a row per LOG_PART bytes of its data, | chain 8B | data |
+// data:  | op | key len | key | val len | val | per key in key order, the
+//        lengths are uvarints & the op is LOG_SET or LOG_DEL without a val.
+
+var (
+	ErrNoLog      error = errors.New("the database has no replication log, see WithReplicationLog")
+	ErrNotReplica error = errors.New("not a replica, see OpenReplica")
+	// a hard error: the replica cannot catch up & must be seeded again
+	ErrDiverged error = errors.New("the replica is not a copy of the leader's log, seed it again from a backup of the leader")
+)
+
+const (
+	// the entries kept by the leader for the replicas behind it
+	LOG_RETAIN = 10000
+	// the bytes of the data of an entry in a row
+	LOG_PART = 1 << 20
+)
+
+const (
+	LOG_SET = 1
+	LOG_DEL = 2
+)
+
+var logTable = crc64.MakeTable(crc64.ECMA)
+
+// a commit of the leader
+type LogEntry struct {
+	Seq   uint64 // from 1, one more than the entry before it
+	Chain uint64 // the checksum of the entries up to this one
+	Data  []byte
+}
+
+type logHead struct {
+	seq, chain  uint64 // of the last entry, 0 for none
+	first, base uint64 // the first entry kept & the chain of the one before it
+}
+
+// the commits are logged for the replicas, keeping the last `retain`
+// entries, 0 for LOG_RETAIN. once started the log is kept by every open for
+// writing.
+func WithReplicationLog(retain int) Option {
+	return func(db *DB) { db.kv.replLog, db.kv.LogRetain = true, retain }
+}
+
+// opens a copy of a leader made by Backup, see Restore. the DB reads like a
+// DB opened for writing but only the entries of ApplyLog are committed, the
+// other writes fail with ErrReadOnly. a copy without the log of its leader
+// fails with ErrDiverged.
+func OpenReplica(path string, opts ...Option) (*DB, error) {
+	return Open(path, append(opts, func(db *DB) { db.kv.Replica = true })...)
+}
+
+// the last entry of the log, committed by the leader or applied by the replica
+func (db *DB) LogPosition() (seq uint64, chain uint64, err error) {
+	var tx KVReader
+	db.kv.BeginRead(&tx)
+	defer db.kv.EndRead(&tx)
+	head, ok, err := logHeadGet(&tx.Tree)
+	if err == nil && !ok {
+		err = ErrNoLog
+	}
+	return head.seq, head.chain, err
+}
+
+// the entries after the one of seq & chain, in order & at least one when
+// there is one, the others up to about max bytes. fails with ErrDiverged
+// when that entry is not in the log or has another chain.
+func (db *DB) ReadLog(seq uint64, chain uint64, max int) ([]LogEntry, error) {
+	var tx KVReader
+	db.kv.BeginRead(&tx)
+	defer db.kv.EndRead(&tx)
+	tree := &tx.Tree
+	head, ok, err := logHeadGet(tree)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoLog
+	}
+	if seq > head.seq {
+		return nil, fmt.Errorf("%w: the entry %d is past the last one, %d", ErrDiverged, seq, head.seq)
+	}
+	if seq+1 < head.first {
+		return nil, fmt.Errorf("%w: the entry %d is no longer kept, the log starts at %d", ErrDiverged, seq, head.first)
+	}
+	want := head.base
+	if seq >= head.first {
+		entry, err := logEntryGet(tree, seq)
+		if err != nil {
+			return nil, err
+		}
+		want = entry.Chain
+	}
+	if want != chain {
+		return nil, fmt.Errorf("%w: the entry %d is another one", ErrDiverged, seq)
+	}
+
+	var out []LogEntry
+	size := 0
+	for next := seq + 1; next <= head.seq && (len(out) == 0 || size < max); next++ {
+		entry, err := logEntryGet(tree, next)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entry)
+		size += len(entry.Data)
+	}
+	return out, nil
+}
+
+// until an entry after seq is in the log, or ctx is done
+func (db *DB) WaitLog(ctx context.Context, seq uint64) error {
+	kv := &db.kv
+	for {
+		// taken before the position is read, so no commit is missed
+		kv.mu.Lock()
+		if kv.logWait == nil {
+			kv.logWait = make(chan struct{})
+		}
+		wait := kv.logWait
+		kv.mu.Unlock()
+		last, _, err := db.LogPosition()
+		if err != nil || last > seq {
+			return err
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// commit the entry after the last one of the replica, with its position.
+// fails with ErrDiverged when it is not the next one of the log.
+func (db *DB) ApplyLog(entry LogEntry) error {
+	kv := &db.kv
+	if !kv.Replica {
+		return ErrNotReplica
+	}
+	var tx KVTX
+	kv.Begin(&tx)
+	schema := false
+	err := func() error {
+		head, ok, err := logHeadGet(&tx.Tree)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrDiverged
+		}
+		if entry.Seq != head.seq+1 {
+			return fmt.Errorf("%w: the entry %d does not follow the last one, %d", ErrDiverged, entry.Seq, head.seq)
+		}
+		if logChain(head.chain, entry.Seq, entry.Data) != entry.Chain {
+			return fmt.Errorf("%w: the entry %d does not follow the replica's history", ErrDiverged, entry.Seq)
+		}
+		err = logDecode(entry.Data, func(key []byte, val []byte, set bool) error {
+			if set {
+				if err := tx.Tree.Insert(key, val); err != nil {
+					return err
+				}
+			} else {
+				tx.Tree.Delete(key)
+			}
+			tx.written(key)
+			schema = schema || binary.BigEndian.Uint32(key) == TDEF_TABLE.Prefix
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", entry.Seq, err)
+		}
+		return logAppend(&tx.Tree, head, entry, kv.logRetain(), tx.written)
+	}()
+	if err != nil {
+		kv.Abort(&tx)
+		return err
+	}
+	tx.replicated = true
+	if err := kv.Commit(&tx); err != nil {
+		return err
+	}
+	if schema {
+		// the definitions read before are cached
+		db.tablesMu.Lock()
+		clear(db.tables)
+		db.tablesMu.Unlock()
+	}
+	return nil
+}
+
+// the log is started by WithReplicationLog & kept once it is, the replicas
+// would miss the commits otherwise
+func logOpen(db *DB) error {
+	kv := &db.kv
+	var tx KVTX
+	kv.Begin(&tx)
+	_, ok, err := logHeadGet(&tx.Tree)
+	if err != nil || ok || !kv.replLog {
+		kv.Abort(&tx)
+		kv.replLog = kv.replLog || ok
+		return err
+	}
+	// not an entry, the log starts after it
+	kv.replLog = false
+	if err := logHeadPut(&tx.Tree, logHead{first: 1}, tx.written); err != nil {
+		kv.Abort(&tx)
+		return err
+	}
+	if err := kv.Commit(&tx); err != nil {
+		return fmt.Errorf("failed to start the replication log: %w", err)
+	}
+	kv.replLog = true
+	return nil
+}
+
+// a copy of a leader has the log of the leader
+func replicaCheck(db *DB) error {
+	var tx KVReader
+	db.kv.BeginRead(&tx)
+	defer db.kv.EndRead(&tx)
+	_, ok, err := logHeadGet(&tx.Tree)
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: %s has no replication log", ErrDiverged, db.Path)
+	}
+	return err
+}
+
+// log the writes of a batch as the next entry of the leader, under the writer lock
+func commitLog(kv *KV, w *kvWriter, keys map[string]struct{}) error {
+	if !kv.replLog || kv.Replica {
+		return nil
+	}
+	head, ok, err := logHeadGet(&w.Tree)
+	if err != nil || !ok {
+		return err // not started yet, see logOpen
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	var data []byte
+	local := 0
+	for _, key := range sorted {
+		if bloomRow([]byte(key)) {
+			local++ // of the file, see bloomLoad
+			continue
+		}
+		val, ok, err := w.Tree.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			data = append(data, LOG_DEL)
+			data = binary.AppendUvarint(data, uint64(len(key)))
+			data = append(data, key...)
+			continue
+		}
+		data = append(data, LOG_SET)
+		data = binary.AppendUvarint(data, uint64(len(key)))
+		data = append(data, key...)
+		data = binary.AppendUvarint(data, uint64(len(val)))
+		data = append(data, val...)
+	}
+	if local > 0 && local == len(sorted) {
+		return nil // a commit of the filters alone, see bloomPersist
+	}
+	entry := LogEntry{Seq: head.seq + 1, Data: data}
+	entry.Chain = logChain(head.chain, entry.Seq, data)
+	return logAppend(&w.Tree, head, entry, kv.logRetain(), nil)
+}
+
+func (kv *KV) logRetain() int {
+	if kv.LogRetain <= 0 {
+		return LOG_RETAIN
+	}
+	return kv.LogRetain
+}
+
+func logChain(chain uint64, seq uint64, data []byte) uint64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[0:], chain)
+	binary.LittleEndian.PutUint64(buf[8:], seq)
+	return crc64.Update(crc64.Checksum(buf[:], logTable), logTable, data)
+}
+
+// the keys & values of the data of an entry, val is nil unless set
+func logDecode(data []byte, fn func(key []byte, val []byte, set bool) error) error {
+	field := func() ([]byte, bool) {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, false
+		}
+		out := data[n : n+int(size)]
+		data = data[n+int(size):]
+		return out, true
+	}
+	for len(data) > 0 {
+		op := data[0]
+		data = data[1:]
+		key, ok := field()
+		var val []byte
+		if ok && op == LOG_SET {
+			val, ok = field()
+		}
+		if !ok || len(key) < 4 || (op != LOG_SET && op != LOG_DEL) {
+			return errors.New("bad log entry")
+		}
+		if err := fn(key, val, op == LOG_SET); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logMetaKey(name string) []byte {
+	return encodeKey(nil, TDEF_META.Prefix, []Value{{Type: TYPE_BYTES, Str: []byte(name)}})
+}
+
+func logPartKey(seq uint64, part int) []byte {
+	return logMetaKey(fmt.Sprintf("log_%016x_%04x", seq, part))
+}
+
+// the bytes of a meta row
+func logMetaGet(tree *BTree, key []byte) ([]byte, bool, error) {
+	val, ok, err := tree.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	out := []Value{{Type: TYPE_BYTES}}
+	if decodeValues(val, out) != 1 {
+		return nil, false, fmt.Errorf("corrupted meta value: %q", key)
+	}
+	return out[0].Str, true, nil
+}
+
+func logHeadGet(tree *BTree) (logHead, bool, error) {
+	val, ok, err := logMetaGet(tree, logMetaKey("log_head"))
+	if err != nil || !ok {
+		return logHead{}, false, err
+	}
+	if len(val) != 32 {
+		return logHead{}, false, fmt.Errorf("corrupted meta value: invalid length")
+	}
+	head := logHead{
+		seq:   binary.LittleEndian.Uint64(val[0:]),
+		chain: binary.LittleEndian.Uint64(val[8:]),
+		first: binary.LittleEndian.Uint64(val[16:]),
+		base:  binary.LittleEndian.Uint64(val[24:]),
+	}
+	return head, true, nil
+}
+
+// written is of a transaction, nil for the tree of a commit
+func logHeadPut(tree *BTree, head logHead, written func(key []byte)) error {
+	val := make([]byte, 32)
+	binary.LittleEndian.PutUint64(val[0:], head.seq)
+	binary.LittleEndian.PutUint64(val[8:], head.chain)
+	binary.LittleEndian.PutUint64(val[16:], head.first)
+	binary.LittleEndian.PutUint64(val[24:], head.base)
+	return logPut(tree, logMetaKey("log_head"), val, written)
+}
+
+func logPut(tree *BTree, key []byte, val []byte, written func(key []byte)) error {
+	if err := tree.Insert(key, encodeValues(nil, []Value{{Type: TYPE_BYTES, Str: val}})); err != nil {
+		return err
+	}
+	if written != nil {
+		written(key)
+	}
+	return nil
+}
+
+func logEntryGet(tree *BTree, seq uint64) (LogEntry, error) {
+	entry := LogEntry{Seq: seq}
+	for part := 0; ; part++ {
+		val, ok, err := logMetaGet(tree, logPartKey(seq, part))
+		if err != nil {
+			return entry, err
+		}
+		if !ok && part == 0 {
+			return entry, fmt.Errorf("corrupted log: the entry %d is missing", seq)
+		}
+		if !ok {
+			return entry, nil
+		}
+		if len(val) < 8 {
+			return entry, fmt.Errorf("corrupted meta value: invalid length")
+		}
+		entry.Chain = binary.LittleEndian.Uint64(val)
+		entry.Data = append(entry.Data, val[8:]...)
+	}
+}
+
+// add the entry after the head & drop the entries past `retain`
+func logAppend(tree *BTree, head logHead, entry LogEntry, retain int, written func(key []byte)) error {
+	data := entry.Data
+	for part := 0; part == 0 || len(data) > 0; part++ {
+		chunk := data[:min(len(data), LOG_PART)]
+		data = data[len(chunk):]
+		val := binary.LittleEndian.AppendUint64(nil, entry.Chain)
+		if err := logPut(tree, logPartKey(entry.Seq, part), append(val, chunk...), written); err != nil {
+			return err
+		}
+	}
+	head.seq, head.chain = entry.Seq, entry.Chain
+	for head.first+uint64(retain) <= head.seq {
+		val, _, err := logMetaGet(tree, logPartKey(head.first, 0))
+		if err != nil {
+			return err
+		}
+		if len(val) >= 8 {
+			head.base = binary.LittleEndian.Uint64(val)
+		}
+		for part := 0; ; part++ {
+			key := logPartKey(head.first, part)
+			if !tree.Delete(key) {
+				break
+			}
+			if written != nil {
+				written(key)
+			}
+		}
+		head.first++
+	}
+	return logHeadPut(tree, head, written)
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	dir := t.TempDir()
+	leader, err := Open(filepath.Join(dir, "leader.db"), WithReplicationLog(4))
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer func() { leader.Close() }()
+	tdef := &TableDef{Name: "notes", Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "note"}, PKeys: 1}
+	row := func(id int64, note []byte) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("note", note)
+	}
+	err = leader.Transact(func(tx *DBTX) error {
+		if err := tx.TableNew(tdef); err != nil {
+			return err
+		}
+		for id := int64(1); id <= 3; id++ {
+			if _, err := tx.Set("notes", row(id, []byte("first")), MODE_INSERT_ONLY); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	var backup bytes.Buffer
+	if err := leader.Backup(&backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	seed := backup.Bytes()
+	open := func(name string) *DB {
+		path := filepath.Join(dir, name)
+		if err := Restore(bytes.NewReader(seed), path); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+		db, err := OpenReplica(path)
+		if err != nil {
+			t.Fatalf("failed to open the replica: %v", err)
+		}
+		return db
+	}
+	replica := open("replica.db")
+	defer func() { replica.Close() }()
+	catchUp := func() int {
+		n := 0
+		for {
+			seq, chain, err := replica.LogPosition()
+			if err != nil {
+				t.Fatalf("failed to read the position: %v", err)
+			}
+			entries, err := leader.ReadLog(seq, chain, LOG_PART)
+			if err != nil {
+				t.Fatalf("failed to read the log: %v", err)
+			}
+			if len(entries) == 0 {
+				return n
+			}
+			for _, entry := range entries {
+				if err := replica.ApplyLog(entry); err != nil {
+					t.Fatalf("failed to apply %d: %v", entry.Seq, err)
+				}
+				n++
+			}
+		}
+	}
+	if n := catchUp(); n != 0 {
+		t.Errorf("expected the copy up to date, applied %d", n)
+	}
+
+	// the writes, a table & an entry of several rows
+	big := bytes.Repeat([]byte("x"), LOG_PART+LOG_PART/2)
+	writes := []func(tx *DBTX) error{
+		func(tx *DBTX) error {
+			if err := tx.UpdatePartial("notes", row(1, []byte("second"))); err != nil {
+				return err
+			}
+			_, err := tx.Delete("notes", *(&Record{}).AddInt64("id", 2))
+			return err
+		},
+		func(tx *DBTX) error {
+			return tx.TableNew(&TableDef{Name: "tags", Types: []uint32{TYPE_BYTES, TYPE_INT64}, Cols: []string{"tag", "n"}, PKeys: 1})
+		},
+		func(tx *DBTX) error {
+			_, err := tx.Set("notes", row(4, big), MODE_INSERT_ONLY)
+			return err
+		},
+	}
+	touch := func(tx *DBTX) error { return tx.UpdatePartial("notes", row(3, []byte("again"))) }
+	for _, write := range writes {
+		if err := leader.Transact(write); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	if n := catchUp(); n != 3 {
+		t.Errorf("expected 3 entries, applied %d", n)
+	}
+	seq, chain, _ := leader.LogPosition()
+	if rseq, rchain, _ := replica.LogPosition(); rseq != seq || rchain != chain || seq != 4 {
+		t.Errorf("expected the position of the leader %d, got %d", seq, rseq)
+	}
+	err = replica.View(func(tx *DBReader) error {
+		for id, want := range map[int64]string{1: "second", 3: "first", 4: string(big)} {
+			rec := *(&Record{}).AddInt64("id", id)
+			if ok, err := tx.Get("notes", &rec); !ok || err != nil || string(rec.Get("note").Str) != want {
+				t.Errorf("expected the row %d of the leader, got %v %v", id, ok, err)
+			}
+		}
+		if ok, _ := tx.Get("notes", (&Record{}).AddInt64("id", 2)); ok {
+			t.Errorf("expected the row 2 deleted")
+		}
+		tables, err := tx.ListTables()
+		if err != nil || len(tables) != 2 {
+			t.Errorf("expected the tables of the leader, got %v %v", tables, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replica is only written by the log
+	err = replica.Transact(func(tx *DBTX) error {
+		_, err := tx.Set("notes", row(9, nil), MODE_INSERT_ONLY)
+		return err
+	})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if err := leader.ApplyLog(LogEntry{Seq: seq + 1}); !errors.Is(err, ErrNotReplica) {
+		t.Errorf("expected ErrNotReplica, got %v", err)
+	}
+
+	// an entry that is not the next one, or that was changed, is not applied
+	if err := leader.Transact(touch); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	entries, err := leader.ReadLog(seq, chain, 0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the next entry, got %v %v", entries, err)
+	}
+	changed := entries[0]
+	changed.Data = append([]byte(nil), changed.Data...)
+	changed.Data[len(changed.Data)-1] ^= 1
+	if err := replica.ApplyLog(changed); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of the changed entry, got %v", err)
+	}
+	if err := replica.ApplyLog(LogEntry{Seq: seq + 2, Chain: entries[0].Chain}); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of an entry ahead, got %v", err)
+	}
+	if _, err := leader.ReadLog(seq, chain+1, 0); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of another history, got %v", err)
+	}
+	if _, err := leader.ReadLog(seq+5, chain, 0); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of a position ahead, got %v", err)
+	}
+
+	// the position is kept by the replica, the log by the leader
+	replica.Close()
+	if replica, err = OpenReplica(filepath.Join(dir, "replica.db")); err != nil {
+		t.Fatalf("failed to open the replica again: %v", err)
+	}
+	if rseq, _, _ := replica.LogPosition(); rseq != seq {
+		t.Errorf("expected the position %d after the restart, got %d", seq, rseq)
+	}
+	leader.Close()
+	if leader, err = Open(filepath.Join(dir, "leader.db")); err != nil {
+		t.Fatalf("failed to open the leader again: %v", err)
+	}
+	if err := leader.Transact(touch); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if n := catchUp(); n != 2 {
+		t.Errorf("expected the 2 entries after the restart, applied %d", n)
+	}
+
+	// the filters written by the leader stay in its file
+	err = leader.View(func(tx *DBReader) error {
+		_, err := tx.Get("notes", (&Record{}).AddInt64("id", 1))
+		return err
+	})
+	if err == nil {
+		err = bloomPersist(leader)
+	}
+	if err != nil {
+		t.Fatalf("failed to write the filters: %v", err)
+	}
+	catchUp()
+	for db, want := range map[*DB]bool{leader: true, replica: false} {
+		db.View(func(tx *DBReader) error {
+			if _, ok, _ := tx.kv.Tree.Get(BLOOM_GEN_KEY); ok != want {
+				t.Errorf("expected the filters in the file %v, got %v", want, ok)
+			}
+			return nil
+		})
+	}
+
+	// a copy of the first entry is behind the 2 kept
+	leader.Close()
+	if leader, err = Open(filepath.Join(dir, "leader.db"), WithReplicationLog(2)); err != nil {
+		t.Fatalf("failed to open the leader again: %v", err)
+	}
+	if err := leader.Transact(touch); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if n := catchUp(); n != 1 {
+		t.Errorf("expected the entry after the restart, applied %d", n)
+	}
+	stale := open("stale.db")
+	defer stale.Close()
+	seq, chain, _ = stale.LogPosition()
+	if _, err := leader.ReadLog(seq, chain, 0); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of a position no longer kept, got %v", err)
+	}
+	plain, err := Open(filepath.Join(dir, "plain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	if _, err := OpenReplica(filepath.Join(dir, "plain.db")); !errors.Is(err, ErrDiverged) {
+		t.Errorf("expected ErrDiverged of a DB without the log, got %v", err)
+	}
+}
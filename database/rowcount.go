@@ -0,0 +1,116 @@
+package database
+
+import (
+	"fmt"
+)
+
+// the number of rows of each table is kept in a meta row. a write adds to
+// the count of its transaction & the commit adds it to the meta row under the
+// writer lock, like the AUTO_INCREMENT rows, so the concurrent writes of a
+// table do not conflict on it.
+
+func rowCountKey(tdef *TableDef) []byte {
+	name := fmt.Sprintf("rows_%d", tdef.Prefix)
+	return encodeKey(nil, TDEF_META.Prefix, []Value{{Type: TYPE_BYTES, Str: []byte(name)}})
+}
+
+// the internal tables are not counted
+func rowCountAdd(tdef *TableDef, kvtx *KVTX, n int) {
+	if tdef.Prefix == TDEF_META.Prefix || tdef.Prefix == TDEF_TABLE.Prefix || n == 0 {
+		return
+	}
+	if kvtx.rows == nil {
+		kvtx.rows = map[string]int64{}
+	}
+	kvtx.rows[string(rowCountKey(tdef))] += int64(n)
+}
+
+// add the counts of the transaction to the meta rows, under the writer lock.
+// the meta row of a table dropped meanwhile is not added again.
+func commitRowCounts(tx *KVTX, w *kvWriter, keys map[string]struct{}) error {
+	for key, n := range tx.rows {
+		if n == 0 {
+			continue
+		}
+		_, ok, err := w.Tree.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		count, err := autoincGet(&w.Tree, []byte(key))
+		if err != nil {
+			return err
+		}
+		if err := w.Tree.Insert([]byte(key), autoincVal(count+n)); err != nil {
+			return err
+		}
+		keys[key] = struct{}{}
+	}
+	return nil
+}
+
+// the rows of the table in the tree, without the writes of an open transaction.
+// a table without the meta row, of a file opened read-only, is counted.
+func rowCountGet(db *DB, tdef *TableDef, tree *BTree) (int64, error) {
+	key := rowCountKey(tdef)
+	_, ok, err := tree.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return rowCountScan(db, tdef, tree), nil
+	}
+	return autoincGet(tree, key)
+}
+
+func rowCountScan(db *DB, tdef *TableDef, tree *BTree) int64 {
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE}
+	dbScanAll(db, tdef, &sc, tree)
+	return sc.Count()
+}
+
+// the exact number of rows of the table, without a scan
+//
+// Deprecated: use DBTX.RowCount or DBReader.RowCount.
+func (db *DB) RowCount(table string, tree *BTree) (int64, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return 0, tableNotFound(table)
+	}
+	return rowCountGet(db, tdef, tree)
+}
+
+// count the rows of every table & compare them with the meta rows
+func (db *DB) CheckRowCounts(tree *BTree) error {
+	for _, tdef := range catalogTables(db, tree) {
+		count, err := rowCountGet(db, tdef, tree)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", tdef.Name, err)
+		}
+		if n := rowCountScan(db, tdef, tree); n != count {
+			return fmt.Errorf("table %s: the row count is %d, %d rows counted", tdef.Name, count, n)
+		}
+	}
+	return nil
+}
+
+// write the meta rows of the tables created before the rows were counted
+func rowCountsInit(db *DB) error {
+	var kvtx KVTX
+	db.kv.Begin(&kvtx)
+	for _, tdef := range catalogTables(db, &kvtx.Tree) {
+		key := rowCountKey(tdef)
+		_, ok, err := kvtx.Tree.Get(key)
+		if err != nil {
+			db.kv.Abort(&kvtx)
+			return err
+		}
+		if ok {
+			continue
+		}
+		kvtx.Update(&InsertReq{Key: key, Value: autoincVal(rowCountScan(db, tdef, &kvtx.Tree))})
+	}
+	return db.kv.Commit(&kvtx)
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRowCount(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	setupTestTable(t, db)
+	var tx DBTX
+	write := func(fn func() error) {
+		t.Helper()
+		db.Begin(&tx)
+		if err := fn(); err != nil {
+			db.Abort(&tx)
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := db.Commit(&tx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+	count := func() int64 {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		n, err := reader.RowCount("users")
+		if err != nil {
+			t.Fatalf("failed to count: %v", err)
+		}
+		if err := db.CheckRowCounts(&reader.kv.Tree); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return n
+	}
+	ids := func(lo, hi int64) *Scanner {
+		return &Scanner{
+			Cmp1: CMP_GE, Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddInt64("id", lo), Key2: *(&Record{}).AddInt64("id", hi),
+		}
+	}
+
+	// the transaction counts its own rows, the others after the commit
+	db.Begin(&tx)
+	var rows []Record
+	for id := int64(1); id <= 10; id++ {
+		rows = append(rows, userRecord(id, "bulk"))
+	}
+	if err := tx.BulkInsert("users", rows); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	for id := int64(11); id <= 13; id++ {
+		if _, err := tx.Set("users", userRecord(id, "set"), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if n, err := tx.RowCount("users"); n != 13 || err != nil {
+		t.Errorf("expected 13 rows in the transaction, got %d %v", n, err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("expected no rows before the commit, got %d", n)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if n := count(); n != 13 {
+		t.Errorf("expected 13 rows, got %d", n)
+	}
+
+	write(func() error {
+		for _, mode := range []int{MODE_UPSERT, MODE_UPDATE_ONLY} {
+			if _, err := tx.Set("users", userRecord(1, "changed"), mode); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Upsert("users", userRecord(14, "new"), MODE_UPSERT); err != nil {
+			return err
+		}
+		if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", 13)); err != nil {
+			return err
+		}
+		_, err := tx.DeleteRange("users", ids(1, 5))
+		return err
+	})
+	if n := count(); n != 8 {
+		t.Errorf("expected 8 rows, got %d", n)
+	}
+
+	// the rows after a savepoint are not counted once rolled back
+	write(func() error {
+		if err := tx.Savepoint("a"); err != nil {
+			return err
+		}
+		if _, err := tx.Set("users", userRecord(20, "gone"), MODE_INSERT_ONLY); err != nil {
+			return err
+		}
+		return tx.RollbackTo("a")
+	})
+	if n := count(); n != 8 {
+		t.Errorf("expected 8 rows after the rollback, got %d", n)
+	}
+
+	// the concurrent inserts do not conflict on the count
+	const workers, inserts = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var tx DBTX
+			db.Begin(&tx)
+			for i := 0; i < inserts; i++ {
+				id := int64(100 + w*inserts + i)
+				if _, err := tx.Set("users", userRecord(id, "w"), MODE_INSERT_ONLY); err != nil {
+					db.Abort(&tx)
+					errs <- err
+					return
+				}
+			}
+			errs <- db.Commit(&tx)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := count(); n != 8+workers*inserts {
+		t.Errorf("expected %d rows, got %d", 8+workers*inserts, n)
+	}
+
+	write(func() error { return tx.Truncate("users", false) })
+	if n := count(); n != 0 {
+		t.Errorf("expected no rows after the truncate, got %d", n)
+	}
+	write(func() error {
+		_, err := tx.Set("users", userRecord(1, "again"), MODE_INSERT_ONLY)
+		return err
+	})
+
+	// a wrong count is found by the check, a missing one is counted again
+	db.Begin(&tx)
+	tdef := GetTableDef(db, "users", &tx.kv.Tree)
+	tx.kv.Update(&InsertReq{Key: rowCountKey(tdef), Value: autoincVal(5)})
+	if err := db.CheckRowCounts(&tx.kv.Tree); err == nil {
+		t.Errorf("expected the check to fail")
+	}
+	tx.kv.Del(&DeleteReq{Key: rowCountKey(tdef)})
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := rowCountsInit(db); err != nil {
+		t.Fatalf("failed to init: %v", err)
+	}
+	var reader DBReader
+	db.BeginRead(&reader)
+	if n, err := autoincGet(&reader.kv.Tree, rowCountKey(tdef)); n != 1 || err != nil {
+		t.Errorf("expected the meta row to count 1 row, got %d %v", n, err)
+	}
+	db.EndRead(&reader)
+
+	write(func() error { return tx.DropTable("users") })
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	if _, ok, _ := reader.kv.Tree.Get(rowCountKey(tdef)); ok {
+		t.Errorf("expected the meta row to be dropped with the table")
+	}
+}
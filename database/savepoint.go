@@ -0,0 +1,123 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNoSavepoint error = errors.New("no such savepoint")
+
+// the state of a transaction at a savepoint. the updates are never modified
+// in place, a new page replaces the old one in the map, so a copy of the map
+// is enough to go back to it.
+type savepoint struct {
+	name      string
+	root      uint64
+	updates   map[uint64][]byte
+	writes    map[string]struct{}
+	refreshed map[string]uint64
+	rows      map[string]int64
+}
+
+// mark the current state, a name can be reused & the latest one is used
+func (tx *KVTX) Savepoint(name string) {
+	updates := make(map[uint64][]byte, len(tx.page.updates))
+	for ptr, page := range tx.page.updates {
+		updates[ptr] = page
+	}
+	writes := make(map[string]struct{}, len(tx.writes))
+	for key := range tx.writes {
+		writes[key] = struct{}{}
+	}
+	refreshed := make(map[string]uint64, len(tx.refreshed))
+	for key, version := range tx.refreshed {
+		refreshed[key] = version
+	}
+	rows := make(map[string]int64, len(tx.rows))
+	for key, n := range tx.rows {
+		rows[key] = n
+	}
+	tx.savepoints = append(tx.savepoints, savepoint{
+		name:      name,
+		root:      tx.Tree.root,
+		updates:   updates,
+		writes:    writes,
+		refreshed: refreshed,
+		rows:      rows,
+	})
+}
+
+// discard the updates after the savepoint & the savepoints after it,
+// the savepoint is kept
+func (tx *KVTX) RollbackTo(name string) error {
+	i := savepointFind(tx, name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNoSavepoint, name)
+	}
+	sp := &tx.savepoints[i]
+	tx.Tree.root = sp.root
+	tx.Tree.version++ // the iterators over the discarded updates are stale
+	clear(tx.Tree.defs)
+	tx.page.updates = make(map[uint64][]byte, len(sp.updates))
+	for ptr, page := range sp.updates {
+		tx.page.updates[ptr] = page
+	}
+	tx.writes = make(map[string]struct{}, len(sp.writes))
+	for key := range sp.writes {
+		tx.writes[key] = struct{}{}
+	}
+	tx.refreshed = make(map[string]uint64, len(sp.refreshed))
+	for key, version := range sp.refreshed {
+		tx.refreshed[key] = version
+	}
+	tx.rows = make(map[string]int64, len(sp.rows))
+	for key, n := range sp.rows {
+		tx.rows[key] = n
+	}
+	tx.savepoints = tx.savepoints[:i+1]
+	return nil
+}
+
+// forget the savepoint & the savepoints after it, the updates are kept
+func (tx *KVTX) Release(name string) error {
+	i := savepointFind(tx, name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNoSavepoint, name)
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+func savepointFind(tx *KVTX, name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tx *DBTX) Savepoint(name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	tx.kv.Savepoint(name)
+	return nil
+}
+
+func (tx *DBTX) RollbackTo(name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	return tx.kv.RollbackTo(name)
+}
+
+func (tx *DBTX) Release(name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	return tx.kv.Release(name)
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savepoint.db")
+	db := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.kv.Close()
+	if err := initializeInternalTables(db); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+	setupTestTable(t, db)
+	setupIndexedTable(t, db)
+	// free pages for the transaction to reuse
+	for id := int64(1); id <= 50; id++ {
+		insertTestRecord(t, db, id)
+	}
+	var tx DBTX
+	db.Begin(&tx)
+	for id := int64(4); id <= 50; id++ {
+		if _, err := tx.Delete("users", *(&Record{}).AddInt64("id", id)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	insert := func(id int64, email string) {
+		t.Helper()
+		rec := (&Record{}).AddInt64("id", id).AddStr("name", []byte("John")).AddStr("email", []byte(email))
+		for _, table := range []string{"users", "people"} {
+			if _, err := tx.Set(table, *rec, MODE_INSERT_ONLY); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	check := func(tree *BTree, want []int64, emails string) {
+		t.Helper()
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("invalid tree: %v", err)
+		}
+		sc, err := db.ScanAll("users", tree)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := scanIDs(sc, tree); !equalIDs(got, want) {
+			t.Errorf("expected users %v, got %v", want, got)
+		}
+		sc = &Scanner{
+			Cmp1: CMP_GE,
+			Cmp2: CMP_LE,
+			Key1: *(&Record{}).AddStr("email", []byte("a")),
+			Key2: *(&Record{}).AddStr("email", []byte("z")),
+		}
+		if err := db.Scan("people", sc, tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, tree); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, string(rec.Get("email").Str))
+		}
+		if strings.Join(got, ",") != emails {
+			t.Errorf("expected the index scan %q, got %v", emails, got)
+		}
+	}
+
+	db.Begin(&tx)
+	insert(10, "k@x")
+	tx.Savepoint("a")
+	insert(11, "c@x")
+	insert(12, "m@x")
+	tx.Savepoint("b")
+	insert(13, "a@x")
+	tx.Savepoint("c")
+	insert(14, "b@x")
+	check(&tx.kv.Tree, []int64{1, 2, 3, 10, 11, 12, 13, 14}, "a@x,b@x,c@x,k@x,m@x")
+
+	if err := tx.RollbackTo("b"); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	check(&tx.kv.Tree, []int64{1, 2, 3, 10, 11, 12}, "c@x,k@x,m@x")
+	// the savepoints after it are gone, the savepoint itself is kept
+	if err := tx.RollbackTo("c"); !errors.Is(err, ErrNoSavepoint) {
+		t.Errorf("expected ErrNoSavepoint, got %v", err)
+	}
+	insert(15, "z@x")
+	if err := tx.RollbackTo("b"); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	check(&tx.kv.Tree, []int64{1, 2, 3, 10, 11, 12}, "c@x,k@x,m@x")
+
+	// a released savepoint keeps its updates
+	if err := tx.Release("b"); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if err := tx.RollbackTo("b"); !errors.Is(err, ErrNoSavepoint) {
+		t.Errorf("expected a released savepoint to be gone, got %v", err)
+	}
+	if err := tx.Release("b"); !errors.Is(err, ErrNoSavepoint) {
+		t.Errorf("expected a released savepoint to be gone, got %v", err)
+	}
+	insert(16, "d@x")
+	if err := tx.RollbackTo("a"); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	check(&tx.kv.Tree, []int64{1, 2, 3, 10}, "k@x")
+	insert(17, "e@x")
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	check(&reader.Tree, []int64{1, 2, 3, 10, 17}, "e@x,k@x")
+	if _, errs := reader.VerifyPages(); len(errs) != 0 {
+		t.Errorf("corrupt pages: %v", errs)
+	}
+	db.kv.EndRead(&reader)
+
+	// the savepoints end with the transaction
+	db.Begin(&tx)
+	if err := tx.RollbackTo("a"); !errors.Is(err, ErrNoSavepoint) {
+		t.Errorf("expected no savepoint in a new transaction, got %v", err)
+	}
+	db.Abort(&tx)
+
+	// the pages reused by the discarded updates are not lost, reopen & write more
+	db.kv.Close()
+	if err := db.kv.Open(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	db.Begin(&tx)
+	for id := int64(20); id <= 40; id++ {
+		insert(id, fmt.Sprintf("user%d@x", id))
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Fatalf("invalid tree: %v", err)
+	}
+	if _, errs := reader.VerifyPages(); len(errs) != 0 {
+		t.Errorf("corrupt pages: %v", errs)
+	}
+}
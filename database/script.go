@@ -0,0 +1,130 @@
+package database
+
+import (
+	"atomixDB/database/helper"
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// the line of the command run by the REPL, counted from 1
+var replLine int
+
+// an error was printed by the command, a script stops unless KeepGoing
+var replFailed bool
+
+// the editor of the lines typed at a terminal, nil for a script
+var replEditor *lineEditor
+
+// the context of the command run by the REPL, cancelled by Ctrl-C while it
+// runs. the long commands stop with it, it is never done between them.
+var replCtx = context.Background()
+
+// the cancel of replCtx, nil between the commands
+var replCancel struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+// a new replCtx for the command about to run
+func replStart() {
+	ctx, cancel := context.WithCancel(context.Background())
+	replCancel.Lock()
+	replCtx, replCancel.cancel = ctx, cancel
+	replCancel.Unlock()
+}
+
+// the command ended, Ctrl-C is for the REPL again
+func replStop() {
+	replCancel.Lock()
+	if replCancel.cancel != nil {
+		replCancel.cancel()
+		replCancel.cancel = nil
+	}
+	replCtx = context.Background()
+	replCancel.Unlock()
+}
+
+// cancels the command running, false between the commands
+func replInterrupt() bool {
+	replCancel.Lock()
+	defer replCancel.Unlock()
+	if replCancel.cancel == nil {
+		return false
+	}
+	replCancel.cancel()
+	return true
+}
+
+// prints an error of a command, to stderr after its line in a script
+func replError(format string, a ...any) {
+	replFailed = true
+	msg := fmt.Sprintf(format, a...)
+	if helper.Interactive {
+		fmt.Println(msg)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "line %d: %s\n", replLine, msg)
+}
+
+// prints the outcome of a command at a terminal, a script only prints the
+// output of its commands & its errors
+func replStatus(format string, a ...any) {
+	if helper.Interactive {
+		fmt.Printf(format+"\n", a...)
+	}
+}
+
+// hands out a line of the input by Read, so a bufio.Reader reading it reads
+// no further than the line asked for, and counts the lines read. the lines
+// of a terminal are read by replEditor.
+type lineReader struct {
+	r         *bufio.Reader
+	rest      []byte
+	line      int
+	inCommand bool // Ctrl-C & Ctrl-D cancel the command, see run
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	if len(lr.rest) == 0 {
+		var line []byte
+		var err error
+		if replEditor != nil {
+			var s string
+			s, err = replEditor.readLine()
+			if err != nil && lr.inCommand {
+				panic(errInterrupted)
+			}
+			line = []byte(s)
+		} else {
+			line, err = lr.r.ReadBytes('\n')
+		}
+		if len(line) == 0 {
+			return 0, err
+		}
+		lr.rest = line
+		lr.line++
+	}
+	n := copy(p, lr.rest)
+	lr.rest = lr.rest[n:]
+	return n, nil
+}
+
+// runs a command, false when a line it reads at the terminal is cancelled
+// with Ctrl-C or Ctrl-D
+func (lr *lineReader) run(command func()) (ok bool) {
+	lr.inCommand = true
+	defer func() {
+		lr.inCommand = false
+		if r := recover(); r != nil {
+			if r != errInterrupted {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	command()
+	return true
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"atomixDB/database/helper"
+	"bufio"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the stdout & the stderr of run
+func captureOutput(t *testing.T, run func()) (string, string) {
+	t.Helper()
+	read := func(fp **os.File) (func() string, func()) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to pipe: %v", err)
+		}
+		saved := *fp
+		*fp = w
+		done := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- string(data)
+		}()
+		return func() string { return <-done }, func() { w.Close(); *fp = saved }
+	}
+	stdout, restoreOut := read(&os.Stdout)
+	stderr, restoreErr := read(&os.Stderr)
+	run()
+	restoreOut()
+	restoreErr()
+	return stdout(), stderr()
+}
+
+func TestScript(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	script := `# the users
+create users cols=id,name,age types=1,2,3 indexes=age unique=name notnull=name defaults=age=18
+insert users
+1
+ann
+30
+insert users
+2
+bob
+
+tables
+get users
+1
+id
+1
+`
+	var failed int
+	stdout, stderr := captureOutput(t, func() { failed = repl(db, strings.NewReader(script), false) })
+	if failed != 0 || stderr != "" {
+		t.Fatalf("expected the script to pass, got %d %q", failed, stderr)
+	}
+	if strings.Contains(stdout, "Enter") || strings.Contains(stdout, "successfully") {
+		t.Errorf("expected no prompts & no status, got %q", stdout)
+	}
+	if !strings.HasPrefix(stdout, "users\n") || !strings.Contains(stdout, "| ann  |") {
+		t.Errorf("expected the tables & the row, got %q", stdout)
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	tdef := GetTableDef(db, "users", &reader.Tree)
+	rec := (&Record{}).AddInt64("id", 2)
+	found, err := db.Get("users", rec, &reader)
+	db.kv.EndRead(&reader)
+	if tdef == nil || !tdef.Unique[1] || !tdef.NotNull[1] {
+		t.Errorf("expected the unique index & NOT NULL of the script, got %+v", tdef)
+	}
+	if err != nil || !found || rec.Get("age").I64 != 18 {
+		t.Errorf("expected bob with the default age, got %v %v %v", rec, found, err)
+	}
+
+	// the first failed command ends the script, with its line
+	script = "insert users\n3\ncy\nold\nscan users\n"
+	stdout, stderr = captureOutput(t, func() { failed = repl(db, strings.NewReader(script), false) })
+	if failed != 1 || stderr != "line 1: Invalid value \"old\" for age: strconv.ParseInt: parsing \"old\": invalid syntax\n" {
+		t.Errorf("expected the error of line 1, got %d %q", failed, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("expected the scan to be skipped, got %q", stdout)
+	}
+
+	// KeepGoing runs the rest, an open transaction is aborted at the end
+	script = "drop nothing\nfoo\nbegin\nscan users\ninsert users\n4\ndi\n20\n"
+	stdout, stderr = captureOutput(t, func() { failed = repl(db, strings.NewReader(script), true) })
+	want := "line 1: Error dropping table: table 'nothing' does not exist\n" +
+		"line 2: Unknown command: foo\n" +
+		"line 5: The open transaction is aborted, it was not committed.\n"
+	if failed != 3 || stderr != want {
+		t.Errorf("expected 3 errors, got %d %q", failed, stderr)
+	}
+	if !strings.Contains(stdout, "bob") {
+		t.Errorf("expected the scan after the errors, got %q", stdout)
+	}
+	db.kv.BeginRead(&reader)
+	found, err = db.Get("users", (&Record{}).AddInt64("id", 4), &reader)
+	db.kv.EndRead(&reader)
+	if err != nil || found {
+		t.Errorf("expected the insert to be aborted, got %v %v", found, err)
+	}
+
+	script = "create t cols=id types=1 colour=red\ncreate t types=1\n"
+	_, stderr = captureOutput(t, func() { failed = repl(db, strings.NewReader(script), true) })
+	if failed != 2 || !strings.Contains(stderr, "line 1: Error creating table: unknown field \"colour=red\"") ||
+		!strings.Contains(stderr, "line 2: Error creating table: table t needs cols= and types=") {
+		t.Errorf("expected the errors of the lines, got %d %q", failed, stderr)
+	}
+}
+
+func TestTableInput(t *testing.T) {
+	td, err := helper.ParseTableInput("t cols=id,name,at types=INT64,Text,6")
+	if err != nil || !reflect.DeepEqual(td.Types, []uint32{TYPE_INT64, TYPE_BYTES, TYPE_TIME}) {
+		t.Errorf("expected the types by name & by number, got %v %v", td.Types, err)
+	}
+	for line, want := range map[string]string{
+		"t cols=id,name types=1,9":             "unknown type 9",
+		"t cols=id,name types=int64,strng":     `unknown type "strng", expected int64, bytes`,
+		"t cols=id,name types=1":               "1 types for 2 columns",
+		"t cols=id,id types=1,2":               "the column id is twice",
+		"t cols=id,name types=1,2 indexes=age": `the index column "age" is not a column of the table`,
+		"t cols=id,name types=1,2 notnull=nme": `the NOT NULL column "nme"`,
+	} {
+		if _, err := helper.ParseTableInput(line); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, line, err)
+		}
+	}
+
+	// a terminal asks again, a script fails
+	helper.Interactive = true
+	stdout, _ := captureOutput(t, func() {
+		td, err = helper.GetTableInput(bufio.NewReader(strings.NewReader("t\nid,name\nint64\nint64,bytez\nint64,bytes\nname\ny\nn\n\n\n\n")))
+	})
+	helper.Interactive = false
+	if err != nil || !reflect.DeepEqual(td.Types, []uint32{TYPE_INT64, TYPE_BYTES}) || !td.Unique[0] {
+		t.Errorf("expected the types typed again, got %+v %v", td, err)
+	}
+	if !strings.Contains(stdout, "1 types for 2 columns, please enter again.") || !strings.Contains(stdout, `unknown type "bytez"`) {
+		t.Errorf("expected the errors of the answers, got %q", stdout)
+	}
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var failed int
+	_, stderr := captureOutput(t, func() {
+		failed = repl(db, strings.NewReader("create\nt\nid,name\nint64\ntables\n"), false)
+	})
+	if failed != 1 || stderr != "line 1: Error creating table: 1 types for 2 columns\n" {
+		t.Errorf("expected the script to fail, got %d %q", failed, stderr)
+	}
+}
+
+func TestReplSession(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	var tx DBTX
+	db.Begin(&tx)
+	if err := tx.TableNew(&TableDef{Name: "t", Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "name"}, PKeys: 1}); err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// the prompt shows the open transaction, EXIT asks to roll it back
+	t.Setenv("ATOMIXDB_IDLE_TIMEOUT", "0")
+	helper.Interactive = true
+	var failed int
+	stdout, _ := captureOutput(t, func() {
+		failed = repl(db, strings.NewReader("begin\ninsert t 1 a\nexit\nn\ncommit\nbegin\ninsert t 2 b\ninsert t 3 c\nexit\ny\nscan t\n"), false)
+	})
+	helper.Interactive = false
+	for _, want := range []string{
+		"atomix> Transaction started.\natomix*> ",
+		"The open transaction will be rolled back, continue? (y/n) atomix*> ",
+		"Transaction committed, 1 pending writes applied.\natomix> ",
+		"Transaction aborted, 2 pending writes discarded.\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q, got %q", want, stdout)
+		}
+	}
+	if failed != 0 || strings.Contains(stdout, "| 1  |") {
+		t.Errorf("expected the REPL to exit before the scan, got %d %q", failed, stdout)
+	}
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	defer db.kv.EndRead(&reader)
+	if n, err := db.RowCount("t", &reader.Tree); n != 1 || err != nil {
+		t.Errorf("expected the row committed only, got %d %v", n, err)
+	}
+}
+
+func TestReplInterrupt(t *testing.T) {
+	defer replStop()
+	if replInterrupt() {
+		t.Errorf("expected no command to cancel")
+	}
+	replStart()
+	ctx := replCtx
+	if !replInterrupt() || ctx.Err() == nil {
+		t.Errorf("expected the command to be cancelled")
+	}
+	// the next command starts with a new context
+	replStop()
+	replStart()
+	if replCtx.Err() != nil {
+		t.Errorf("expected the context of the next command")
+	}
+	replStop()
+	if replInterrupt() || replCtx.Err() != nil {
+		t.Errorf("expected no command to cancel after it ended")
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"atomixDB/database"
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// a connection to a Server, with the methods of the DB of the same names.
+// safe for concurrent use, the requests are sent one at a time.
+type Client struct {
+	mu sync.Mutex
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+func Dial(addr string) (*Client, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// the server aborts the open transaction
+func (c *Client) Close() error {
+	return c.nc.Close()
+}
+
+// fills the row of the primary key, false if there is none
+func (c *Client) Get(table string, rec *database.Record) (bool, error) {
+	var found bool
+	err := c.call(OP_GET, table, *rec, func(d *decoder) {
+		if found = d.bool(); found {
+			*rec = d.record()
+		}
+	})
+	return found, err
+}
+
+// fails with database.ErrExists on an existing primary key
+func (c *Client) Insert(table string, rec database.Record) (bool, error) {
+	return c.write(OP_INSERT, table, rec)
+}
+
+// fails with database.ErrNotFound on a missing primary key
+func (c *Client) Update(table string, rec database.Record) (bool, error) {
+	return c.write(OP_UPDATE, table, rec)
+}
+
+// fails with database.ErrNotFound on a missing primary key
+func (c *Client) Delete(table string, rec database.Record) (bool, error) {
+	return c.write(OP_DELETE, table, rec)
+}
+
+// the rows of the range of Key1 & Key2 with Limit, Desc & Project, the other
+// fields of the Scanner are not sent
+func (c *Client) Scan(table string, req *database.Scanner) ([]database.Record, error) {
+	if req.Filter != nil || req.DistinctCols != nil || req.StartAfter != nil {
+		return nil, fmt.Errorf("a scan of the server has no Filter, DistinctCols or StartAfter")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body := appendString([]byte{OP_SCAN}, table)
+	if err := c.send(appendScan(body, req)); err != nil {
+		return nil, err
+	}
+	var rows []database.Record
+	for {
+		body, err := readMessage(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 && body[0] == STATUS_ROW {
+			d := &decoder{buf: body[1:]}
+			rec := d.record()
+			if err := d.end(); err != nil {
+				return nil, err
+			}
+			rows = append(rows, rec)
+			continue
+		}
+		return rows, response(body, nil)
+	}
+}
+
+// the requests after it go to a transaction of the connection
+func (c *Client) Begin() error {
+	return c.call(OP_BEGIN, "", database.Record{}, nil)
+}
+
+func (c *Client) Commit() error {
+	return c.call(OP_COMMIT, "", database.Record{}, nil)
+}
+
+func (c *Client) Abort() error {
+	return c.call(OP_ABORT, "", database.Record{}, nil)
+}
+
+func (c *Client) write(op byte, table string, rec database.Record) (bool, error) {
+	var ok bool
+	err := c.call(op, table, rec, func(d *decoder) { ok = d.bool() })
+	return ok, err
+}
+
+// sends the request & reads the values of its response with read
+func (c *Client) call(op byte, table string, rec database.Record, read func(d *decoder)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body := []byte{op}
+	if op < OP_BEGIN {
+		body = appendRecord(appendString(body, table), rec)
+	}
+	if err := c.send(body); err != nil {
+		return err
+	}
+	body, err := readMessage(c.r)
+	if err != nil {
+		return err
+	}
+	return response(body, read)
+}
+
+func (c *Client) send(body []byte) error {
+	if err := writeMessage(c.w, body); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// the RemoteError of STATUS_ERR, or the values of STATUS_OK
+func response(body []byte, read func(d *decoder)) error {
+	d := &decoder{buf: body}
+	switch status := d.byte(); {
+	case d.err != nil:
+		return d.err
+	case status == STATUS_OK:
+		if read != nil {
+			read(d)
+		}
+		return d.end()
+	case status == STATUS_ERR:
+		kind := d.uvarint()
+		msg := d.string()
+		if err := d.end(); err != nil {
+			return err
+		}
+		rerr := &RemoteError{Msg: msg}
+		if kind < uint64(len(errKinds)) {
+			rerr.Kind = errKinds[kind].err
+		}
+		return rerr
+	default:
+		return fmt.Errorf("malformed message: the status %d", status)
+	}
+}
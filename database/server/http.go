@@ -0,0 +1,507 @@
+package server
+
+import (
+	"atomixDB/database"
+	atomixsql "atomixDB/database/sql"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the rows of a page of GET /tables/{name}/rows, without a limit & at most
+const (
+	HTTP_PAGE_ROWS = 100
+	HTTP_MAX_ROWS  = 1000
+)
+
+var ErrBadRequest error = errors.New("bad request")
+
+// the HTTP/JSON API of the DB, its requests run one at a time with those of
+// the TCP connections:
+//
+//	GET    /tables             the names of the tables
+//	GET    /tables/{name}      the columns, the primary key & the indexes
+//	GET    /tables/{name}/rows ?col=&min=&max=&limit=&after=, a page of rows
+//	POST   /tables/{name}/rows a row or an array of rows, inserted together
+//	DELETE /tables/{name}/rows ?<column>=<value> of the primary key
+//	POST   /tx                 {"ops": [{"op", "table", "row"}]} in one transaction
+//
+// a row is an object of its columns: INT64 as a string of its digits, so no
+// JSON decoder rounds it, INT32 & FLOAT64 as numbers, NaN & ±Inf as the
+// strings "NaN", "+Inf" & "-Inf", BOOL as true or false, TIME as an RFC 3339
+// string with nanoseconds, BYTES in standard base64 & NULL as null. a number
+// is also read for an INT64. the values of a query string are text, BYTES
+// as the text itself. an error is {"error": {"kind", "message"}} with the
+// HTTP status of its kind.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables", s.httpTables)
+	mux.HandleFunc("GET /tables/{name}", s.httpSchema)
+	mux.HandleFunc("GET /tables/{name}/rows", s.httpRows)
+	mux.HandleFunc("POST /tables/{name}/rows", s.httpInsert)
+	mux.HandleFunc("DELETE /tables/{name}/rows", s.httpDelete)
+	mux.HandleFunc("POST /tx", s.httpTx)
+	return mux
+}
+
+func (s *Server) httpTables(w http.ResponseWriter, r *http.Request) {
+	var tables []string
+	err := s.db.View(func(tx *database.DBReader) error {
+		var err error
+		tables, err = tx.ListTables()
+		return err
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if tables == nil {
+		tables = []string{}
+	}
+	httpJSON(w, http.StatusOK, map[string]any{"tables": tables})
+}
+
+type jsonColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null,omitempty"`
+}
+
+type jsonIndex struct {
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+}
+
+type jsonSchema struct {
+	Name          string       `json:"name"`
+	Columns       []jsonColumn `json:"columns"`
+	PrimaryKey    []string     `json:"primary_key"`
+	Indexes       []jsonIndex  `json:"indexes"`
+	AutoIncrement bool         `json:"auto_increment,omitempty"`
+}
+
+func (s *Server) httpSchema(w http.ResponseWriter, r *http.Request) {
+	tdef, err := s.describe(r.PathValue("name"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	schema := jsonSchema{
+		Name:          tdef.Name,
+		PrimaryKey:    tdef.Cols[:tdef.PKeys],
+		Indexes:       []jsonIndex{},
+		AutoIncrement: tdef.AutoIncrement,
+	}
+	for i, col := range tdef.Cols {
+		notNull := i < tdef.PKeys || (i < len(tdef.NotNull) && tdef.NotNull[i])
+		schema.Columns = append(schema.Columns, jsonColumn{col, atomixsql.TypeName(tdef.Types[i]), notNull})
+	}
+	for i, index := range tdef.Indexes {
+		schema.Indexes = append(schema.Indexes, jsonIndex{index, i < len(tdef.Unique) && tdef.Unique[i]})
+	}
+	httpJSON(w, http.StatusOK, schema)
+}
+
+func (s *Server) describe(name string) (*database.TableDef, error) {
+	var tdef *database.TableDef
+	err := s.db.View(func(tx *database.DBReader) error {
+		var err error
+		tdef, err = tx.Describe(name)
+		return err
+	})
+	return tdef, err
+}
+
+// {"rows": [...], "next": "<token>"}, the rows written as they are read.
+// "next" is the after of the next page, none after the last. an error
+// after the first row ends the page with {"error"} instead of "next".
+func (s *Server) httpRows(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := HTTP_PAGE_ROWS
+	if q.Has("limit") {
+		n, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || n <= 0 {
+			httpError(w, fmt.Errorf("%w: the limit %q", ErrBadRequest, q.Get("limit")))
+			return
+		}
+		limit = min(n, HTTP_MAX_ROWS)
+	}
+	var after []byte
+	if q.Has("after") {
+		var err error
+		if after, err = base64.RawURLEncoding.DecodeString(q.Get("after")); err != nil || len(after) == 0 {
+			httpError(w, fmt.Errorf("%w: the token %q", ErrBadRequest, q.Get("after")))
+			return
+		}
+	}
+	col := q.Get("col")
+	if col == "" && (q.Has("min") || q.Has("max")) {
+		httpError(w, fmt.Errorf("%w: min & max are of a col", ErrBadRequest))
+		return
+	}
+
+	started := false
+	err := s.db.View(func(tx *database.DBReader) error {
+		tdef, err := tx.Describe(r.PathValue("name"))
+		if err != nil {
+			return err
+		}
+		// one row more than the page tells whether there is a next one
+		req := &database.Scanner{Limit: limit + 1, StartAfter: after}
+		var conds []*database.Expr
+		for _, bound := range []struct {
+			param string
+			cmp   int
+		}{{"min", database.CMP_GE}, {"max", database.CMP_LE}} {
+			if !q.Has(bound.param) {
+				continue
+			}
+			v, err := textValue(tdef, col, q.Get(bound.param))
+			if err != nil {
+				return err
+			}
+			conds = append(conds, database.CompareExpr(database.ColumnExpr(col), bound.cmp, database.LiteralExpr(v)))
+		}
+		if len(conds) > 0 {
+			req.Filter = database.AndExpr(conds...)
+		}
+		if err := tx.Scan(tdef.Name, req); err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		started = true
+		io.WriteString(w, `{"rows":[`)
+		var rec database.Record
+		var last []byte
+		for n := 0; req.Valid(); req.Next() {
+			if n == limit {
+				token, _ := json.Marshal(base64.RawURLEncoding.EncodeToString(last))
+				fmt.Fprintf(w, `],"next":%s}`+"\n", token)
+				return nil
+			}
+			if err := req.Read(&rec); err != nil {
+				return err
+			}
+			if n > 0 {
+				io.WriteString(w, ",")
+			}
+			if _, err := w.Write(jsonRow(rec)); err != nil {
+				return err
+			}
+			last = req.Position()
+			n++
+		}
+		if err := req.Err(); err != nil {
+			return err
+		}
+		io.WriteString(w, "]}\n")
+		return nil
+	})
+	if err != nil && started {
+		fmt.Fprintf(w, `],"error":%s}`+"\n", errorJSON(err))
+	} else if err != nil {
+		httpError(w, err)
+	}
+}
+
+// 201 & {"inserted": n}
+func (s *Server) httpInsert(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := readJSON(w, r, &body); err != nil {
+		httpError(w, err)
+		return
+	}
+	rows := []json.RawMessage{body}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		rows = nil
+		if err := json.Unmarshal(body, &rows); err != nil {
+			httpError(w, fmt.Errorf("%w: %v", ErrBadRequest, err))
+			return
+		}
+	}
+	name := r.PathValue("name")
+	err := s.db.Transact(func(tx *database.DBTX) error {
+		tdef, err := tx.Describe(name)
+		if err != nil {
+			return err
+		}
+		for i, raw := range rows {
+			rec, err := rowValues(tdef, raw)
+			if err == nil {
+				_, err = tx.Set(name, rec, database.MODE_INSERT_ONLY)
+			}
+			if err != nil {
+				return fmt.Errorf("row %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpJSON(w, http.StatusCreated, map[string]int{"inserted": len(rows)})
+}
+
+// {"deleted": 1}, not_found for a missing row
+func (s *Server) httpDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := r.PathValue("name")
+	err := s.db.Transact(func(tx *database.DBTX) error {
+		tdef, err := tx.Describe(name)
+		if err != nil {
+			return err
+		}
+		var key database.Record
+		for _, col := range tdef.Cols[:tdef.PKeys] {
+			if !q.Has(col) {
+				return fmt.Errorf("%w: the primary key column %s", ErrBadRequest, col)
+			}
+			v, err := textValue(tdef, col, q.Get(col))
+			if err != nil {
+				return err
+			}
+			key.Cols, key.Vals = append(key.Cols, col), append(key.Vals, v)
+		}
+		_, err = tx.Delete(name, key)
+		return err
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpJSON(w, http.StatusOK, map[string]int{"deleted": 1})
+}
+
+// an operation of POST /tx: insert, update, upsert or delete. the row of an
+// update has the columns it sets & of a delete those of the primary key.
+type jsonOp struct {
+	Op    string          `json:"op"`
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// {"applied": n}, or the error of the first failed operation with none
+// applied
+func (s *Server) httpTx(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Ops []jsonOp `json:"ops"`
+	}
+	if err := readJSON(w, r, &body); err != nil {
+		httpError(w, err)
+		return
+	}
+	err := s.db.Transact(func(tx *database.DBTX) error {
+		for i, op := range body.Ops {
+			if err := applyOp(tx, op); err != nil {
+				return fmt.Errorf("op %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpJSON(w, http.StatusOK, map[string]int{"applied": len(body.Ops)})
+}
+
+func applyOp(tx *database.DBTX, op jsonOp) error {
+	tdef, err := tx.Describe(op.Table)
+	if err != nil {
+		return err
+	}
+	rec, err := rowValues(tdef, op.Row)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "insert":
+		_, err = tx.Set(op.Table, rec, database.MODE_INSERT_ONLY)
+	case "update":
+		err = tx.UpdatePartial(op.Table, rec)
+	case "upsert":
+		_, err = tx.Set(op.Table, rec, database.MODE_UPSERT)
+	case "delete":
+		_, err = tx.Delete(op.Table, rec)
+	default:
+		err = fmt.Errorf("%w: the op %q", ErrBadRequest, op.Op)
+	}
+	return err
+}
+
+// the body of at most MAX_MESSAGE bytes
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_MESSAGE))
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("%w: %v", ErrMessageTooLarge, err)
+		}
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	return nil
+}
+
+func httpJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errKinds[errKind(err)].status)
+	fmt.Fprintf(w, `{"error":%s}`+"\n", errorJSON(err))
+}
+
+func errorJSON(err error) []byte {
+	out, _ := json.Marshal(map[string]string{"kind": errKinds[errKind(err)].name, "message": err.Error()})
+	return out
+}
+
+// the object of the row, the columns in order
+func jsonRow(rec database.Record) []byte {
+	out := []byte{'{'}
+	for i, col := range rec.Cols {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		name, _ := json.Marshal(col)
+		val, _ := json.Marshal(jsonValue(rec.Vals[i]))
+		out = append(append(append(out, name...), ':'), val...)
+	}
+	return append(out, '}')
+}
+
+func jsonValue(v database.Value) any {
+	if v.Null {
+		return nil
+	}
+	switch v.Type {
+	case database.TYPE_INT64:
+		return strconv.FormatInt(v.I64, 10)
+	case database.TYPE_INT32:
+		return v.I64
+	case database.TYPE_FLOAT64:
+		if math.IsNaN(v.F64) || math.IsInf(v.F64, 0) {
+			return strconv.FormatFloat(v.F64, 'g', -1, 64)
+		}
+		return v.F64
+	case database.TYPE_BOOL:
+		return v.I64 != 0
+	case database.TYPE_TIME:
+		return time.Unix(0, v.I64).UTC().Format(time.RFC3339Nano)
+	default:
+		return v.Str // base64
+	}
+}
+
+// the values of the object by the columns of the table, in their order
+func rowValues(tdef *database.TableDef, raw json.RawMessage) (database.Record, error) {
+	var rec database.Record
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &row); err != nil || row == nil {
+		return rec, fmt.Errorf("%w: a row is an object of its columns", ErrBadRequest)
+	}
+	for i, col := range tdef.Cols {
+		val, ok := row[col]
+		if !ok {
+			continue
+		}
+		delete(row, col)
+		v, err := parseJSON(tdef.Types[i], val)
+		if err != nil {
+			return rec, fmt.Errorf("%w: column %s: %v", database.ErrTypeMismatch, col, err)
+		}
+		rec.Cols, rec.Vals = append(rec.Cols, col), append(rec.Vals, v)
+	}
+	for col := range row {
+		return rec, fmt.Errorf("%w: %s in table %s", database.ErrColumnNotFound, col, tdef.Name)
+	}
+	return rec, nil
+}
+
+func parseJSON(typ uint32, raw json.RawMessage) (database.Value, error) {
+	v := database.Value{Type: typ}
+	if string(bytes.TrimSpace(raw)) == "null" {
+		v.Null = true
+		return v, nil
+	}
+	var text string
+	var err error
+	switch typ {
+	case database.TYPE_BOOL:
+		var b bool
+		err = json.Unmarshal(raw, &b)
+		if b {
+			v.I64 = 1
+		}
+		return v, err
+	case database.TYPE_BYTES:
+		err = json.Unmarshal(raw, &v.Str) // base64
+		return v, err
+	case database.TYPE_INT64, database.TYPE_INT32, database.TYPE_FLOAT64:
+		// a number or a string of it
+		var num json.Number
+		if err = json.Unmarshal(raw, &num); err == nil {
+			text = num.String()
+		} else if err = json.Unmarshal(raw, &text); err != nil {
+			return v, fmt.Errorf("not a number: %s", raw)
+		}
+	default:
+		if err = json.Unmarshal(raw, &text); err != nil {
+			return v, err
+		}
+	}
+	return parseText(typ, text)
+}
+
+func textValue(tdef *database.TableDef, col string, text string) (database.Value, error) {
+	for i, name := range tdef.Cols {
+		if name == col {
+			v, err := parseText(tdef.Types[i], text)
+			if err != nil {
+				return v, fmt.Errorf("%w: column %s: %v", database.ErrTypeMismatch, col, err)
+			}
+			return v, nil
+		}
+	}
+	return database.Value{}, fmt.Errorf("%w: %s in table %s", database.ErrColumnNotFound, col, tdef.Name)
+}
+
+// the value of the type from its text
+func parseText(typ uint32, text string) (database.Value, error) {
+	v := database.Value{Type: typ}
+	var err error
+	switch typ {
+	case database.TYPE_INT64:
+		v.I64, err = strconv.ParseInt(text, 10, 64)
+	case database.TYPE_INT32:
+		v.I64, err = strconv.ParseInt(text, 10, 32)
+	case database.TYPE_FLOAT64:
+		v.F64, err = strconv.ParseFloat(text, 64)
+	case database.TYPE_BOOL:
+		var b bool
+		if b, err = strconv.ParseBool(text); b {
+			v.I64 = 1
+		}
+	case database.TYPE_TIME:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, text); err == nil {
+			v.I64 = t.UnixNano()
+		}
+	default:
+		v.Str = []byte(text)
+	}
+	return v, err
+}
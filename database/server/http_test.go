@@ -0,0 +1,145 @@
+package server
+
+import (
+	"atomixDB/database"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type httpResult struct {
+	Rows    []map[string]any `json:"rows"`
+	Next    string           `json:"next"`
+	Tables  []string         `json:"tables"`
+	Applied int              `json:"applied"`
+	Error   struct {
+		Kind    string `json:"kind"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func httpDo(t *testing.T, method string, url string, body string) (int, httpResult) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to make the request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to %s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	var res httpResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("expected JSON from %s %s, got %q: %v", method, url, out, err)
+	}
+	return resp.StatusCode, res
+}
+
+func TestHTTP(t *testing.T) {
+	srv, _ := startServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if code, res := httpDo(t, "GET", ts.URL+"/tables", ""); code != 200 || len(res.Tables) != 1 || res.Tables[0] != "people" {
+		t.Errorf("expected the table people, got %d %v", code, res)
+	}
+	code, res := httpDo(t, "GET", ts.URL+"/tables/people", "")
+	if code != 200 {
+		t.Errorf("failed to get the schema: %d %v", code, res)
+	}
+	if code, res := httpDo(t, "GET", ts.URL+"/tables/nobody", ""); code != 404 || res.Error.Kind != "table_not_found" {
+		t.Errorf("expected table_not_found, got %d %v", code, res)
+	}
+
+	// the values exact, an int64 above 2^53 & bytes that are not UTF-8
+	big := int64(math.MaxInt64 - 1)
+	body := `[{"id": "9223372036854775806", "name": "/wA=", "score": "NaN", "active": true},
+		{"id": 2, "name": "Ym9i", "score": 0.5, "active": null}]`
+	if code, res := httpDo(t, "POST", ts.URL+"/tables/people/rows", body); code != 201 {
+		t.Fatalf("failed to insert: %d %v", code, res)
+	}
+	err := srv.db.View(func(tx *database.DBReader) error {
+		rec := *(&database.Record{}).AddInt64("id", big)
+		if ok, err := tx.Get("people", &rec); !ok || err != nil {
+			t.Fatalf("expected the row, got %v %v", ok, err)
+		}
+		if string(rec.Get("name").Str) != "\xff\x00" || !math.IsNaN(rec.Get("score").F64) || rec.Get("active").I64 != 1 {
+			t.Errorf("expected the values of the JSON, got %v", rec)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, res := httpDo(t, "POST", ts.URL+"/tables/people/rows", `{"id": 2}`); code != 409 || res.Error.Kind != "exists" {
+		t.Errorf("expected exists, got %d %v", code, res)
+	}
+	if code, res := httpDo(t, "POST", ts.URL+"/tables/people/rows", `{"id": 3, "age": 1}`); code != 400 || res.Error.Kind != "column_not_found" {
+		t.Errorf("expected column_not_found, got %d %v", code, res)
+	}
+	if code, res := httpDo(t, "POST", ts.URL+"/tables/people/rows", `{"id": "x"}`); code != 400 || res.Error.Kind != "type_mismatch" {
+		t.Errorf("expected type_mismatch, got %d %v", code, res)
+	}
+
+	// the operations together, or none of them
+	ops := `{"ops": [
+		{"op": "insert", "table": "people", "row": {"id": 3, "name": "Y3k=", "score": 1.5}},
+		{"op": "update", "table": "people", "row": {"id": 2, "name": "Ym9iYnk=", "score": 2}},
+		{"op": "delete", "table": "people", "row": {"id": "9223372036854775806"}}]}`
+	if code, res := httpDo(t, "POST", ts.URL+"/tx", ops); code != 200 || res.Applied != 3 {
+		t.Errorf("failed to apply: %d %v", code, res)
+	}
+	ops = `{"ops": [{"op": "insert", "table": "people", "row": {"id": 4}}, {"op": "delete", "table": "people", "row": {"id": 9}}]}`
+	if code, res := httpDo(t, "POST", ts.URL+"/tx", ops); code != 404 || res.Error.Kind != "not_found" || !strings.HasPrefix(res.Error.Message, "op 1: ") {
+		t.Errorf("expected not_found of op 1, got %d %v", code, res)
+	}
+	for i := int64(10); i < 25; i++ {
+		if code, res := httpDo(t, "POST", ts.URL+"/tables/people/rows", `{"id": `+strconv.FormatInt(i, 10)+`}`); code != 201 {
+			t.Fatalf("failed to insert: %d %v", code, res)
+		}
+	}
+
+	// pages of 4 rows of the range, from the token of the previous page
+	var ids []any
+	next := ""
+	for pages := 0; pages < 10; pages++ {
+		q := url.Values{"col": {"id"}, "min": {"3"}, "max": {"20"}, "limit": {"4"}}
+		if next != "" {
+			q.Set("after", next)
+		}
+		code, res := httpDo(t, "GET", ts.URL+"/tables/people/rows?"+q.Encode(), "")
+		if code != 200 {
+			t.Fatalf("failed to scan: %d %v", code, res)
+		}
+		for _, row := range res.Rows {
+			ids = append(ids, row["id"])
+		}
+		if next = res.Next; next == "" {
+			break
+		}
+	}
+	if len(ids) != 12 || ids[0] != "3" || ids[1] != "10" || ids[11] != "20" {
+		t.Errorf("expected the ids 3, 10 to 20, got %v", ids)
+	}
+	code, res = httpDo(t, "GET", ts.URL+"/tables/people/rows?col=id&max=2", "")
+	if code != 200 || len(res.Rows) != 1 || res.Rows[0]["name"] != "Ym9iYnk=" || res.Rows[0]["score"] != 2.0 || res.Rows[0]["active"] != nil {
+		t.Errorf("expected the row of bobby, got %d %v", code, res)
+	}
+	if code, res := httpDo(t, "GET", ts.URL+"/tables/people/rows?min=2", ""); code != 400 || res.Error.Kind != "bad_request" {
+		t.Errorf("expected bad_request, got %d %v", code, res)
+	}
+
+	if code, res := httpDo(t, "DELETE", ts.URL+"/tables/people/rows?id=3", ""); code != 200 {
+		t.Errorf("failed to delete: %d %v", code, res)
+	}
+	if code, res := httpDo(t, "DELETE", ts.URL+"/tables/people/rows?id=3", ""); code != 404 || res.Error.Kind != "not_found" {
+		t.Errorf("expected not_found, got %d %v", code, res)
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"atomixDB/database"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+)
+
+// a message is its length as a big-endian uint32 & the body of that many
+// bytes. the body of a request is the opcode & its arguments, the body of a
+// response is the status & its values.
+const MAX_MESSAGE = 16 << 20
+
+// the opcodes of the requests
+const (
+	OP_GET    = 1 // table, key -> found, row
+	OP_INSERT = 2 // table, row -> added
+	OP_UPDATE = 3 // table, row -> updated
+	OP_DELETE = 4 // table, key -> deleted
+	OP_SCAN   = 5 // table, range -> a STATUS_ROW per row, then STATUS_OK
+	OP_BEGIN  = 6
+	OP_COMMIT = 7
+	OP_ABORT  = 8
+	OP_LOG    = 9 // seq, chain -> a STATUS_LOG per part of the entries after it, see Follow
+)
+
+// the first byte of a response
+const (
+	STATUS_OK  = 0
+	STATUS_ERR = 1 // kind, message, see RemoteError
+	STATUS_ROW = 2 // a row of OP_SCAN
+	STATUS_LOG = 3 // seq, chain, last, data: a part of an entry of OP_LOG
+	// no entry of OP_LOG for LOG_HEARTBEAT
+	STATUS_BEAT = 4
+)
+
+var ErrMessageTooLarge error = errors.New("message too large")
+
+// the errors a client can match with errors.Is, sent by their index over TCP
+// & by their name with the HTTP status over HTTP. the first is an error of
+// no known kind.
+var errKinds = []struct {
+	err    error
+	name   string
+	status int
+}{
+	{nil, "internal", http.StatusInternalServerError},
+	{database.ErrNotFound, "not_found", http.StatusNotFound},
+	{database.ErrExists, "exists", http.StatusConflict},
+	{database.ErrConflict, "conflict", http.StatusConflict},
+	{database.ErrTableNotFound, "table_not_found", http.StatusNotFound},
+	{database.ErrColumnNotFound, "column_not_found", http.StatusBadRequest},
+	{database.ErrTypeMismatch, "type_mismatch", http.StatusBadRequest},
+	{database.ErrBadRange, "bad_range", http.StatusBadRequest},
+	{database.ErrNotNull, "not_null", http.StatusBadRequest},
+	{database.ErrUniqueViolation, "unique_violation", http.StatusConflict},
+	{database.ErrForeignKey, "foreign_key", http.StatusConflict},
+	{database.ErrReadOnly, "read_only", http.StatusForbidden},
+	{database.ErrTxDone, "tx_done", http.StatusConflict},
+	{database.ErrTxOpen, "tx_open", http.StatusConflict},
+	{ErrNoTx, "no_tx", http.StatusConflict},
+	{database.ErrLockTimeout, "lock_timeout", http.StatusConflict},
+	{database.ErrDeadlock, "deadlock", http.StatusConflict},
+	{database.ErrOverflow, "overflow", http.StatusBadRequest},
+	{database.ErrMemoryLimit, "memory_limit", http.StatusInternalServerError},
+	{ErrMessageTooLarge, "too_large", http.StatusRequestEntityTooLarge},
+	{ErrBadRequest, "bad_request", http.StatusBadRequest},
+	{database.ErrDiverged, "diverged", http.StatusConflict},
+	{database.ErrNoLog, "no_log", http.StatusNotFound},
+	{ErrServerClosed, "server_closed", http.StatusServiceUnavailable},
+}
+
+// an error of the server, matches the database error it was made from with
+// errors.Is
+type RemoteError struct {
+	Kind error // of errKinds, nil for another error
+	Msg  string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Msg
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.Kind
+}
+
+func errKind(err error) uint64 {
+	for i, kind := range errKinds[1:] {
+		if errors.Is(err, kind.err) {
+			return uint64(i + 1)
+		}
+	}
+	return 0
+}
+
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if size > MAX_MESSAGE {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeMessage(w *bufio.Writer, body []byte) error {
+	if len(body) > MAX_MESSAGE {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(body))
+	}
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(body)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// the encoding of the values of a message, lengths & integers are varints
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// the type, a null flag & the value of the type
+func appendValue(buf []byte, v database.Value) []byte {
+	buf = binary.AppendUvarint(buf, uint64(v.Type))
+	buf = appendBool(buf, v.Null)
+	if v.Null {
+		return buf
+	}
+	switch v.Type {
+	case database.TYPE_FLOAT64:
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v.F64))
+	case database.TYPE_BYTES:
+		buf = binary.AppendUvarint(buf, uint64(len(v.Str)))
+		buf = append(buf, v.Str...)
+	default:
+		buf = binary.AppendVarint(buf, v.I64)
+	}
+	return buf
+}
+
+// the number of columns, then the name & the value of each
+func appendRecord(buf []byte, rec database.Record) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(rec.Cols)))
+	for i, col := range rec.Cols {
+		buf = appendString(buf, col)
+		buf = appendValue(buf, rec.Vals[i])
+	}
+	return buf
+}
+
+// the range of OP_SCAN, the fields of the Scanner the protocol has
+func appendScan(buf []byte, req *database.Scanner) []byte {
+	buf = binary.AppendVarint(buf, int64(req.Cmp1))
+	buf = binary.AppendVarint(buf, int64(req.Cmp2))
+	buf = appendRecord(buf, req.Key1)
+	buf = appendRecord(buf, req.Key2)
+	buf = binary.AppendUvarint(buf, uint64(req.Limit))
+	buf = appendBool(buf, req.Desc)
+	buf = binary.AppendUvarint(buf, uint64(len(req.Project)))
+	for _, col := range req.Project {
+		buf = appendString(buf, col)
+	}
+	return buf
+}
+
+// reads the values of a message in order, the first error is kept & the
+// reads after it return zero values
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed message")
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err != nil || size > uint64(len(d.buf)) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[:size:size]
+	d.buf = d.buf[size:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) value() database.Value {
+	v := database.Value{Type: uint32(d.uvarint()), Null: d.bool()}
+	if v.Null {
+		return v
+	}
+	switch v.Type {
+	case database.TYPE_INT64, database.TYPE_INT32, database.TYPE_BOOL, database.TYPE_TIME:
+		v.I64 = d.varint()
+	case database.TYPE_FLOAT64:
+		if len(d.buf) < 8 {
+			d.fail()
+			return v
+		}
+		v.F64 = math.Float64frombits(binary.BigEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+	case database.TYPE_BYTES:
+		v.Str = d.bytes()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("malformed message: the value type %d", v.Type)
+		}
+	}
+	return v
+}
+
+func (d *decoder) record() database.Record {
+	var rec database.Record
+	n := d.uvarint()
+	// each column takes a few bytes, a larger count is malformed
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return rec
+	}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		rec.Cols = append(rec.Cols, d.string())
+		rec.Vals = append(rec.Vals, d.value())
+	}
+	return rec
+}
+
+func (d *decoder) scan() *database.Scanner {
+	req := &database.Scanner{
+		Cmp1: int(d.varint()),
+		Cmp2: int(d.varint()),
+		Key1: d.record(),
+		Key2: d.record(),
+	}
+	req.Limit = int(d.uvarint())
+	req.Desc = d.bool()
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return req
+	}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		req.Project = append(req.Project, d.string())
+	}
+	return req
+}
+
+// the error of a message with bytes after its values
+func (d *decoder) end() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("malformed message: %d bytes left", len(d.buf))
+	}
+	return d.err
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"atomixDB/database"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// how long an OP_LOG stream waits for a commit before a STATUS_BEAT,
+	// the follower reconnects after 3 of them are missed
+	LOG_HEARTBEAT = 5 * time.Second
+	// the bytes of the entries read at a time by an OP_LOG stream
+	LOG_READ_SIZE = 4 << 20
+	// how long Follow waits before connecting again
+	FOLLOW_RETRY = time.Second
+)
+
+// the entries of the log after seq, then the later ones as they are
+// committed, until the connection or the server closes
+func (s *Server) streamLog(c *conn, seq uint64, chain uint64) error {
+	for {
+		entries, err := s.db.ReadLog(seq, chain, LOG_READ_SIZE)
+		if err != nil {
+			return c.fail(err)
+		}
+		for _, entry := range entries {
+			if err := writeEntry(c.w, entry); err != nil {
+				return err
+			}
+			seq, chain = entry.Seq, entry.Chain
+		}
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, LOG_HEARTBEAT)
+		err = s.db.WaitLog(ctx, seq)
+		cancel()
+		if s.ctx.Err() != nil {
+			return c.fail(ErrServerClosed)
+		}
+		if err != nil {
+			if err := writeMessage(c.w, []byte{STATUS_BEAT}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// a STATUS_LOG per database.LOG_PART bytes of the data
+func writeEntry(w *bufio.Writer, entry database.LogEntry) error {
+	data := entry.Data
+	for first := true; first || len(data) > 0; first = false {
+		part := data[:min(len(data), database.LOG_PART)]
+		data = data[len(part):]
+		body := binary.AppendUvarint([]byte{STATUS_LOG}, entry.Seq)
+		body = binary.AppendUvarint(body, entry.Chain)
+		body = appendBool(body, len(data) == 0)
+		body = binary.AppendUvarint(body, uint64(len(part)))
+		if err := writeMessage(w, append(body, part...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keeps a replica opened by database.OpenReplica in sync with the leader
+// at addr: the entries after the last one of the replica are applied in
+// order, each at once, as the leader commits them. the connection is made
+// again FOLLOW_RETRY after it fails. returns the error of ctx once it is
+// done, or the error of the leader or of the replica that retrying does not
+// fix: database.ErrDiverged when the leader no longer has the entries of
+// the replica, the replica is seeded again from a backup of the leader.
+func Follow(ctx context.Context, db *database.DB, addr string) error {
+	for {
+		retry, err := follow(ctx, db, addr)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retry {
+			return err
+		}
+		select {
+		case <-time.After(FOLLOW_RETRY):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// the entries of a connection, retry is false for an error of the DBs
+func follow(ctx context.Context, db *database.DB, addr string) (retry bool, err error) {
+	seq, chain, err := db.LogPosition()
+	if err != nil {
+		return false, err
+	}
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return true, err
+	}
+	defer nc.Close()
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+	c := &Client{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	body := binary.AppendUvarint([]byte{OP_LOG}, seq)
+	if err := c.send(binary.AppendUvarint(body, chain)); err != nil {
+		return true, err
+	}
+
+	var data []byte // the parts of the entry so far
+	for {
+		nc.SetReadDeadline(time.Now().Add(3 * LOG_HEARTBEAT))
+		body, err := readMessage(c.r)
+		if err != nil {
+			return true, err
+		}
+		switch {
+		case len(body) > 0 && body[0] == STATUS_BEAT:
+			continue
+		case len(body) == 0 || body[0] != STATUS_LOG:
+			err := response(body, nil)
+			if err == nil {
+				err = fmt.Errorf("malformed message: the log ended")
+			}
+			return errors.Is(err, ErrServerClosed), err
+		}
+		d := &decoder{buf: body[1:]}
+		entry := database.LogEntry{Seq: d.uvarint(), Chain: d.uvarint()}
+		last := d.bool()
+		data = append(data, d.bytes()...)
+		if err := d.end(); err != nil {
+			return true, err
+		}
+		if !last {
+			continue
+		}
+		entry.Data, data = data, nil
+		if err := db.ApplyLog(entry); err != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"atomixDB/database"
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a copy of the DB opened as a replica
+func seedReplica(t *testing.T, db *database.DB) *database.DB {
+	var backup bytes.Buffer
+	if err := db.Backup(&backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "replica.db")
+	if err := database.Restore(&backup, path); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	replica, err := database.OpenReplica(path)
+	if err != nil {
+		t.Fatalf("failed to open the replica: %v", err)
+	}
+	return replica
+}
+
+// until the replica has the last entry of the leader
+func waitReplica(t *testing.T, leader *database.DB, replica *database.DB) {
+	want, _, _ := leader.LogPosition()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		seq, _, err := replica.LogPosition()
+		if err != nil || seq >= want {
+			return
+		}
+		if err := replica.WaitLog(ctx, seq); err != nil {
+			t.Fatalf("expected the replica at %d, it is at %d: %v", want, seq, err)
+		}
+	}
+}
+
+func rowCount(t *testing.T, db *database.DB) int64 {
+	var n int64
+	err := db.View(func(tx *database.DBReader) error {
+		var err error
+		n, err = tx.RowCount("people")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return n
+}
+
+func TestFollow(t *testing.T) {
+	srv, addr := startServer(t, database.WithReplicationLog(0))
+	c := dial(t, addr)
+	replica := seedReplica(t, srv.db)
+	defer func() { replica.Close() }()
+	follow := func() (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- Follow(ctx, replica, addr) }()
+		return cancel, done
+	}
+	cancel, done := follow()
+
+	// the transactions of 3 rows are seen whole by the readers of the replica
+	for id := int64(1); id <= 30; id += 3 {
+		if err := c.Begin(); err != nil {
+			t.Fatalf("failed to begin: %v", err)
+		}
+		for i := id; i < id+3; i++ {
+			if _, err := c.Insert("people", person(i, "x")); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+		if err := c.Commit(); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		if n := rowCount(t, replica); n%3 != 0 {
+			t.Errorf("expected whole transactions, the replica has %d rows", n)
+		}
+	}
+	waitReplica(t, srv.db, replica)
+	if n := rowCount(t, replica); n != 30 {
+		t.Errorf("expected the 30 rows of the leader, got %d", n)
+	}
+	if _, err := c.Delete("people", person(1, "x")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	waitReplica(t, srv.db, replica)
+	rec := *(&database.Record{}).AddInt64("id", 1)
+	err := replica.View(func(tx *database.DBReader) error {
+		ok, err := tx.Get("people", &rec)
+		if ok || err != nil {
+			t.Errorf("expected the row deleted on the replica, got %v %v", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// the replica goes on from its position after a restart
+	path := replica.Path
+	replica.Close()
+	if replica, err = database.OpenReplica(path); err != nil {
+		t.Fatalf("failed to open the replica again: %v", err)
+	}
+	for id := int64(31); id <= 35; id++ {
+		if _, err := c.Insert("people", person(id, "y")); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	cancel, done = follow()
+	waitReplica(t, srv.db, replica)
+	if n := rowCount(t, replica); n != 34 {
+		t.Errorf("expected the 34 rows of the leader, got %d", n)
+	}
+	cancel()
+	<-done
+
+	// a replica of another leader is told apart
+	other, err := database.Open(filepath.Join(t.TempDir(), "other.db"), database.WithReplicationLog(0))
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer other.Close()
+	err = other.Transact(func(tx *database.DBTX) error {
+		return tx.TableNew(&database.TableDef{Name: "other", Types: []uint32{database.TYPE_INT64}, Cols: []string{"id"}, PKeys: 1})
+	})
+	if err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	stranger := seedReplica(t, other)
+	defer stranger.Close()
+	ctx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	if err := Follow(ctx, stranger, addr); !errors.Is(err, database.ErrDiverged) {
+		t.Errorf("expected ErrDiverged, got %v", err)
+	}
+}
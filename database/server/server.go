@@ -0,0 +1,342 @@
+// a TCP server of a DB & its Client, for a DB shared by the processes of its
+// clients:
+//
+//	srv := server.New(db)
+//	ln, err := net.Listen("tcp", ":7070")
+//	go srv.Serve(ln)
+//	c, err := server.Dial("localhost:7070")
+//
+// each connection runs its requests in order, outside of a transaction each
+// request is a transaction of its own, after OP_BEGIN they go to the
+// transaction of the connection until OP_COMMIT or OP_ABORT. the requests of
+// the connections run concurrently, a commit fails with database.ErrConflict
+// on a row committed since its transaction began.
+//
+// a server of a DB opened with database.WithReplicationLog is the leader of
+// its replicas, each kept in sync by Follow:
+//
+//	replica, err := database.OpenReplica("copy.db")
+//	err = server.Follow(ctx, replica, "leader:7070")
+package server
+
+import (
+	"atomixDB/database"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var (
+	ErrServerClosed error = errors.New("the server is closed")
+	ErrNoTx         error = errors.New("no open transaction")
+)
+
+type Server struct {
+	db     *database.DB
+	ctx    context.Context // done once the server is closing, ends OP_LOG
+	cancel context.CancelFunc
+	mu     sync.Mutex // the fields below
+	ln     []net.Listener
+	// the connections, busy while running a request
+	conns   map[*conn]bool
+	closing bool
+	done    sync.WaitGroup // the connections
+}
+
+// a connection & its open transaction
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+	tx *database.DBTX // nil outside of OP_BEGIN
+}
+
+func New(db *database.DB) *Server {
+	s := &Server{db: db, conns: map[*conn]bool{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// accepts the connections of the listener until Shutdown or Close, after
+// which it returns ErrServerClosed
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = append(s.ln, ln)
+	s.mu.Unlock()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			return err
+		}
+		c := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = false
+		s.done.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// stops accepting connections & closes those waiting for a request, a
+// request that is running is answered first. the open transactions are
+// aborted. when ctx is done first the busy connections are closed too & its
+// error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	s.cancel()
+	for _, ln := range s.ln {
+		ln.Close()
+	}
+	s.ln = nil
+	for c, busy := range s.conns {
+		if !busy {
+			c.nc.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		s.done.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// closes the listeners & the connections at once, the open transactions are
+// aborted
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.cancel()
+	for _, ln := range s.ln {
+		ln.Close()
+	}
+	s.ln = nil
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.done.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(c *conn) {
+	defer s.done.Done()
+	defer func() {
+		if c.tx != nil {
+			s.db.Abort(c.tx)
+			c.tx = nil
+		}
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.nc.Close()
+	}()
+	for {
+		body, err := readMessage(c.r)
+		if err != nil {
+			if errors.Is(err, ErrMessageTooLarge) {
+				c.fail(err)
+				c.w.Flush()
+			}
+			return
+		}
+		if !s.busy(c, true) {
+			return
+		}
+		err = s.request(c, body)
+		if ferr := c.w.Flush(); err == nil {
+			err = ferr
+		}
+		if !s.busy(c, false) || err != nil {
+			return
+		}
+	}
+}
+
+// marks the connection running a request or waiting for one, false once the
+// server is closing
+func (s *Server) busy(c *conn, busy bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[c] = busy
+	return true
+}
+
+// a panic of the DB fails the request & closes the connection
+func (s *Server) request(c *conn, body []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.fail(fmt.Errorf("internal error: %v", r))
+			err = fmt.Errorf("the request panicked: %v", r)
+		}
+	}()
+	return s.handle(c, body)
+}
+
+// runs the request & writes its response, the error is of writing it
+func (s *Server) handle(c *conn, body []byte) error {
+	d := &decoder{buf: body}
+	op := d.byte()
+	switch op {
+	case OP_BEGIN, OP_COMMIT, OP_ABORT:
+		if err := d.end(); err != nil {
+			return c.fail(err)
+		}
+		return c.ok(nil, c.end(s.db, op))
+	case OP_SCAN:
+		table := d.string()
+		req := d.scan()
+		if err := d.end(); err != nil {
+			return c.fail(err)
+		}
+		return s.scan(c, table, req)
+	case OP_LOG:
+		seq, chain := d.uvarint(), d.uvarint()
+		if err := d.end(); err != nil {
+			return c.fail(err)
+		}
+		if c.tx != nil {
+			return c.fail(database.ErrTxOpen)
+		}
+		return s.streamLog(c, seq, chain)
+	}
+
+	if op < OP_GET || op > OP_DELETE {
+		if d.err != nil {
+			return c.fail(d.err)
+		}
+		return c.fail(fmt.Errorf("unknown opcode %d", op))
+	}
+	table := d.string()
+	rec := d.record()
+	if err := d.end(); err != nil {
+		return c.fail(err)
+	}
+	var out []byte
+	err := s.run(c, op != OP_GET, func(tx txn) error {
+		var ok bool
+		var err error
+		switch op {
+		case OP_GET:
+			ok, err = tx.Get(table, &rec)
+		case OP_INSERT:
+			ok, err = tx.(*database.DBTX).Set(table, rec, database.MODE_INSERT_ONLY)
+		case OP_UPDATE:
+			ok, err = tx.(*database.DBTX).Set(table, rec, database.MODE_UPDATE_ONLY)
+		case OP_DELETE:
+			ok, err = tx.(*database.DBTX).Delete(table, rec)
+		}
+		out = appendBool(out, ok)
+		if op == OP_GET && ok {
+			out = appendRecord(out, rec)
+		}
+		return err
+	})
+	return c.ok(out, err)
+}
+
+// the reads of a request, a DBReader or a DBTX
+type txn interface {
+	Get(table string, rec *database.Record) (bool, error)
+	Scan(table string, req *database.Scanner) error
+}
+
+// runs the function in the transaction of the connection, or else in a
+// transaction of its own for a write & a snapshot for a read
+func (s *Server) run(c *conn, write bool, fn func(tx txn) error) error {
+	if c.tx != nil {
+		return fn(c.tx)
+	}
+	if write {
+		return s.db.Transact(func(tx *database.DBTX) error { return fn(tx) })
+	}
+	return s.db.View(func(tx *database.DBReader) error { return fn(tx) })
+}
+
+// a STATUS_ROW per row of the range, then STATUS_OK
+func (s *Server) scan(c *conn, table string, req *database.Scanner) error {
+	var werr error
+	err := s.run(c, false, func(tx txn) error {
+		if err := tx.Scan(table, req); err != nil {
+			return err
+		}
+		var rec database.Record
+		for ; req.Valid(); req.Next() {
+			if err := req.Read(&rec); err != nil {
+				return err
+			}
+			if werr = writeMessage(c.w, appendRecord([]byte{STATUS_ROW}, rec)); werr != nil {
+				return werr
+			}
+		}
+		return req.Err()
+	})
+	if werr != nil {
+		return werr
+	}
+	return c.ok(nil, err)
+}
+
+func (c *conn) end(db *database.DB, op byte) error {
+	switch {
+	case op == OP_BEGIN && c.tx != nil:
+		return database.ErrTxOpen
+	case op == OP_BEGIN:
+		c.tx = &database.DBTX{}
+		db.Begin(c.tx)
+		return nil
+	case c.tx == nil:
+		return ErrNoTx
+	}
+	tx := c.tx
+	c.tx = nil
+	if op == OP_COMMIT {
+		return db.Commit(tx)
+	}
+	return db.Abort(tx)
+}
+
+// STATUS_OK & the values, or STATUS_ERR of the error
+func (c *conn) ok(out []byte, err error) error {
+	if err != nil {
+		return c.fail(err)
+	}
+	return writeMessage(c.w, append([]byte{STATUS_OK}, out...))
+}
+
+func (c *conn) fail(err error) error {
+	out := binary.AppendUvarint([]byte{STATUS_ERR}, errKind(err))
+	return writeMessage(c.w, appendString(out, err.Error()))
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"atomixDB/database"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a server of a new DB file on a random port & a client of it
+func startServer(t *testing.T, opts ...database.Option) (*Server, string) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "served.db"), opts...)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	err = db.Transact(func(tx *database.DBTX) error {
+		return tx.TableNew(&database.TableDef{
+			Name:  "people",
+			Types: []uint32{database.TYPE_INT64, database.TYPE_BYTES, database.TYPE_FLOAT64, database.TYPE_BOOL},
+			Cols:  []string{"id", "name", "score", "active"},
+			PKeys: 1,
+		})
+	})
+	if err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := New(db)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+		db.Close()
+	})
+	return srv, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *Client {
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func person(id int64, name string) database.Record {
+	return *(&database.Record{}).AddInt64("id", id).AddStr("name", []byte(name)).
+		AddFloat64("score", float64(id)/2).AddBool("active", id%2 == 0)
+}
+
+func TestServer(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	for i, name := range []string{"ann", "bob", "cy", "di"} {
+		if ok, err := c.Insert("people", person(int64(i+1), name)); !ok || err != nil {
+			t.Fatalf("failed to insert %s: %v %v", name, ok, err)
+		}
+	}
+	if _, err := c.Insert("people", person(1, "ann")); !errors.Is(err, database.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if _, err := c.Insert("nobody", person(1, "ann")); !errors.Is(err, database.ErrTableNotFound) {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+
+	rec := *(&database.Record{}).AddInt64("id", 3)
+	if ok, err := c.Get("people", &rec); !ok || err != nil {
+		t.Fatalf("failed to get: %v %v", ok, err)
+	}
+	if string(rec.Get("name").Str) != "cy" || rec.Get("score").F64 != 1.5 || rec.Get("active").I64 != 0 {
+		t.Errorf("expected the row of cy, got %v", rec)
+	}
+	if ok, err := c.Update("people", person(3, "cyd")); !ok || err != nil {
+		t.Errorf("failed to update: %v %v", ok, err)
+	}
+	if ok, err := c.Delete("people", *(&database.Record{}).AddInt64("id", 4)); !ok || err != nil {
+		t.Errorf("failed to delete: %v %v", ok, err)
+	}
+	if _, err := c.Delete("people", *(&database.Record{}).AddInt64("id", 4)); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	rows, err := c.Scan("people", &database.Scanner{
+		Cmp1: database.CMP_GE, Cmp2: database.CMP_LE, Desc: true, Project: []string{"id", "name"},
+		Key1: *(&database.Record{}).AddInt64("id", 2),
+		Key2: *(&database.Record{}).AddInt64("id", 9),
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	var names []string
+	for _, row := range rows {
+		names = append(names, string(row.Get("name").Str))
+	}
+	if len(names) != 2 || names[0] != "cyd" || names[1] != "bob" || len(rows[0].Cols) != 2 {
+		t.Errorf("expected cyd & bob, got %v", rows)
+	}
+	if _, err := c.Scan("people", &database.Scanner{Cmp1: database.CMP_GE}); err == nil {
+		t.Errorf("expected the error of a bad range")
+	}
+}
+
+// the requests of the connections run concurrently, each client writes rows of its own
+func TestServerConcurrent(t *testing.T) {
+	_, addr := startServer(t)
+	const clients, rows = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		c := dial(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rows; j++ {
+				id := int64(i*rows + j)
+				if _, err := c.Insert("people", person(id, "p")); err != nil {
+					errs <- err
+					return
+				}
+				rec := *(&database.Record{}).AddInt64("id", id)
+				if ok, err := c.Get("people", &rec); !ok || err != nil {
+					errs <- fmt.Errorf("row %d not found: %v", id, err)
+					return
+				}
+				if _, err := c.Scan("people", &database.Scanner{Cmp1: database.CMP_GE, Cmp2: database.CMP_LE,
+					Key1: *(&database.Record{}).AddInt64("id", 0),
+					Key2: *(&database.Record{}).AddInt64("id", clients*rows),
+				}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("request failed: %v", err)
+	}
+	all, err := dial(t, addr).Scan("people", &database.Scanner{Cmp1: database.CMP_GE, Cmp2: database.CMP_LE,
+		Key1: *(&database.Record{}).AddInt64("id", 0),
+		Key2: *(&database.Record{}).AddInt64("id", clients*rows),
+	})
+	if err != nil || len(all) != clients*rows {
+		t.Errorf("expected %d rows, got %d %v", clients*rows, len(all), err)
+	}
+}
+
+func TestServerTransactions(t *testing.T) {
+	_, addr := startServer(t)
+	a, b := dial(t, addr), dial(t, addr)
+
+	if err := a.Begin(); err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if err := a.Begin(); !errors.Is(err, database.ErrTxOpen) {
+		t.Errorf("expected ErrTxOpen, got %v", err)
+	}
+	if _, err := a.Insert("people", person(1, "ann")); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	// seen by its own transaction only
+	key := *(&database.Record{}).AddInt64("id", 1)
+	if ok, err := a.Get("people", &key); !ok || err != nil {
+		t.Errorf("expected the row in the transaction, got %v %v", ok, err)
+	}
+	key = *(&database.Record{}).AddInt64("id", 1)
+	if ok, err := b.Get("people", &key); ok || err != nil {
+		t.Errorf("expected no row outside of the transaction, got %v %v", ok, err)
+	}
+	if err := a.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if ok, err := b.Get("people", &key); !ok || err != nil {
+		t.Errorf("expected the committed row, got %v %v", ok, err)
+	}
+	if err := a.Commit(); !errors.Is(err, ErrNoTx) {
+		t.Errorf("expected ErrNoTx, got %v", err)
+	}
+
+	// both write the row, the later commit conflicts
+	a.Begin()
+	b.Begin()
+	a.Update("people", person(1, "ann a"))
+	b.Update("people", person(1, "ann b"))
+	if err := a.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := b.Commit(); !errors.Is(err, database.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	a.Begin()
+	a.Insert("people", person(2, "bob"))
+	if err := a.Abort(); err != nil {
+		t.Errorf("failed to abort: %v", err)
+	}
+	if rows, err := b.Scan("people", &database.Scanner{Cmp1: database.CMP_GE, Cmp2: database.CMP_LE}); len(rows) != 1 || err != nil {
+		t.Errorf("expected the one committed row, got %v %v", rows, err)
+	}
+
+	// the clients of many goroutines
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			c, err := Dial(addr)
+			if err != nil {
+				t.Errorf("failed to dial: %v", err)
+				return
+			}
+			defer c.Close()
+			for i := 0; i < 25; i++ {
+				if _, err := c.Insert("people", person(int64(100+g*25+i), "many")); err != nil {
+					t.Errorf("failed to insert: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if rows, err := b.Scan("people", &database.Scanner{Cmp1: database.CMP_GE, Cmp2: database.CMP_LE}); len(rows) != 101 || err != nil {
+		t.Errorf("expected 101 rows, got %d %v", len(rows), err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, addr := startServer(t)
+	c := dial(t, addr)
+	c.Begin()
+	if _, err := c.Insert("people", person(1, "ann")); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+	if err := c.Commit(); err == nil {
+		t.Errorf("expected the connection closed")
+	}
+	if _, err := Dial(addr); err == nil {
+		t.Errorf("expected no listener")
+	}
+	// the open transaction was aborted
+	err := srv.db.View(func(tx *database.DBReader) error {
+		if n, err := tx.RowCount("people"); n != 0 || err != nil {
+			t.Errorf("expected no rows, got %d %v", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("failed to read: %v", err)
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	rec := *(&database.Record{}).AddInt64("a", -5).AddStr("b", []byte{0, 1, 2}).AddFloat64("c", 2.25).
+		AddNull("d", database.TYPE_INT32).AddTime("e", time.Unix(0, 42))
+	d := &decoder{buf: appendRecord(nil, rec)}
+	got := d.record()
+	if err := d.end(); err != nil || len(got.Cols) != 5 || got.Get("a").I64 != -5 || string(got.Get("b").Str) != "\x00\x01\x02" ||
+		got.Get("c").F64 != 2.25 || !got.Get("d").Null || got.Get("e").I64 != 42 {
+		t.Errorf("expected the record back, got %v %v", got, err)
+	}
+	for _, body := range [][]byte{{}, {OP_GET, 5, 'p'}, {OP_GET, 0, 200}, append(appendRecord([]byte{OP_GET, 0}, rec), 1)} {
+		d := &decoder{buf: body}
+		d.byte()
+		d.string()
+		d.record()
+		if d.end() == nil {
+			t.Errorf("expected %v malformed", body)
+		}
+	}
+}
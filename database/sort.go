@@ -0,0 +1,391 @@
+package database
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// the rows ScanOrdered sorts in memory, see DB.SortRows. beyond them the rows
+// spill to temporary files in sorted runs, merged in one pass.
+const SORT_MEMORY_ROWS = 100_000
+
+// the rows of ScanOrdered in the order of the columns
+type OrderedScan struct {
+	sc      *Scanner // in the order already, nil for a sort
+	tree    *BTree
+	project []string // of the request, the sorted rows have every column
+	// the sorted rows, from memory or from the merge of the runs
+	rows  []Record
+	pos   int
+	merge *runMerge
+	cur   Record
+	ok    bool
+	limit int
+	count int
+	err   error
+}
+
+// scan the rows of the request in the order of the columns, descending if
+// desc. ties are broken by the primary key, in the same direction. a request
+// already in the order is only scanned, else the rows are sorted: the LIMIT
+// keeps the first rows in a heap, without one the rows beyond DB.SortRows
+// spill to temporary files. the request is consumed, Close removes the files.
+//
+// Deprecated: use DBTX.ScanOrdered or DBReader.ScanOrdered.
+func (db *DB) ScanOrdered(table string, req *Scanner, orderBy []string, desc bool, tree *BTree) (*OrderedScan, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	for _, col := range orderBy {
+		if ColIndex(tdef, col) < 0 {
+			return nil, columnNotFound(tdef.Name, col)
+		}
+	}
+	lowerFirst(req)
+	req.Desc = desc
+	if err := dbScan(db, tdef, req, tree); err != nil {
+		return nil, err
+	}
+	if scanInOrder(tdef, req, orderBy) {
+		return &OrderedScan{sc: req, tree: tree}, nil
+	}
+	s := &OrderedScan{tree: tree, project: req.Project, limit: req.Limit}
+	if err := s.sort(db, tdef, req, orderBy, desc); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// the index of the scan is in the order of the columns & then of the primary
+// key, after its leading columns with a single value
+func scanInOrder(tdef *TableDef, req *Scanner, orderBy []string) bool {
+	index := tdef.Cols[:tdef.PKeys]
+	if req.indexNo >= 0 {
+		index = tdef.Indexes[req.indexNo]
+	}
+	eq := eqPrefix(req)
+	constant := index[:eq]
+	var order []string
+	for _, col := range orderBy {
+		if !slices.Contains(constant, col) && !slices.Contains(order, col) {
+			order = append(order, col)
+		}
+	}
+	for _, col := range tdef.Cols[:tdef.PKeys] {
+		if !slices.Contains(constant, col) && !slices.Contains(order, col) {
+			order = append(order, col)
+		}
+	}
+	rest := index[eq:]
+	return len(order) <= len(rest) && slices.Equal(order, rest[:len(order)])
+}
+
+// make Key1 the lower bound, for a request whose direction is set by Desc
+func lowerFirst(req *Scanner) {
+	if req.Cmp1 < 0 && req.Cmp2 > 0 {
+		req.Cmp1, req.Cmp2, req.Key1, req.Key2 = req.Cmp2, req.Cmp1, req.Key2, req.Key1
+	}
+}
+
+// the leading columns of the range with a single value
+func eqPrefix(req *Scanner) int {
+	n := min(len(req.Key1.Vals), len(req.Key2.Vals))
+	if req.prefix {
+		// a range of the strings with the prefix
+		n--
+	}
+	eq := 0
+	for eq < n && compareValues(req.Key1.Vals[eq], req.Key2.Vals[eq]) {
+		eq++
+	}
+	return eq
+}
+
+// the order of two rows of every column
+func rowOrder(tdef *TableDef, orderBy []string, desc bool) func(a, b *Record) int {
+	var cols []int
+	for _, col := range append(slices.Clone(orderBy), tdef.Cols[:tdef.PKeys]...) {
+		if idx := ColIndex(tdef, col); !slices.Contains(cols, idx) {
+			cols = append(cols, idx)
+		}
+	}
+	return func(a, b *Record) int {
+		for _, idx := range cols {
+			v1, v2 := a.Vals[idx], b.Vals[idx]
+			order := 0
+			switch {
+			case v1.Null && v2.Null:
+			case v1.Null: // first, like in the keys
+				order = -1
+			case v2.Null:
+				order = +1
+			default:
+				order = orderValues(v1, v2)
+			}
+			if desc {
+				order = -order
+			}
+			if order != 0 {
+				return order
+			}
+		}
+		return 0
+	}
+}
+
+func (s *OrderedScan) sort(db *DB, tdef *TableDef, req *Scanner, orderBy []string, desc bool) error {
+	cmp := rowOrder(tdef, orderBy, desc)
+	budget := db.SortRows
+	if budget <= 0 {
+		budget = SORT_MEMORY_ROWS
+	}
+	// every row is read for the sort, the limit is of the sorted rows
+	req.Limit = 0
+	defer func() { req.Limit = s.limit }()
+
+	top := &rowHeap{cmp: func(a, b *Record) bool { return cmp(a, b) > 0 }}
+	topK := s.limit > 0 && s.limit <= budget
+	var rows []Record
+	for ; req.Valid(); req.Next() {
+		rec := Record{}
+		if err := req.deref(&rec, s.tree, true); err != nil {
+			return err
+		}
+		switch {
+		case !topK:
+			rows = append(rows, rec)
+		case len(top.rows) < s.limit:
+			heap.Push(top, rec)
+		case cmp(&rec, &top.rows[0]) < 0:
+			// before the last of the first rows
+			top.rows[0] = rec
+			heap.Fix(top, 0)
+		}
+		if len(rows) == budget {
+			if err := s.spill(tdef, rows, cmp); err != nil {
+				return err
+			}
+			rows = rows[:0]
+		}
+	}
+	if err := req.Err(); err != nil {
+		return err
+	}
+	if topK {
+		rows = top.rows
+	}
+	slices.SortFunc(rows, func(a, b Record) int { return cmp(&a, &b) })
+	if s.merge != nil && len(rows) > 0 {
+		// the last rows are a run in memory
+		s.merge.add(&sortRun{rows: rows})
+		rows = nil
+	}
+	s.rows = rows
+	s.advance()
+	return nil
+}
+
+// write the sorted rows to a temporary file for the merge
+func (s *OrderedScan) spill(tdef *TableDef, rows []Record, cmp func(a, b *Record) int) error {
+	slices.SortFunc(rows, func(a, b Record) int { return cmp(&a, &b) })
+	f, err := os.CreateTemp("", "atomixdb-sort-*")
+	if err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
+	if s.merge == nil {
+		s.merge = &runMerge{cmp: cmp}
+	}
+	run := &sortRun{file: f, cols: tdef.Cols, types: tdef.Types}
+	s.merge.files = append(s.merge.files, f)
+	w := bufio.NewWriter(f)
+	var buf []byte
+	for _, rec := range rows {
+		buf = encodeValues(buf[:0], rec.Vals)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(buf))))
+		w.Write(buf)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
+	run.r = bufio.NewReader(f)
+	return s.merge.add(run)
+}
+
+// move to the next sorted row
+func (s *OrderedScan) advance() {
+	switch {
+	case s.merge != nil:
+		s.cur, s.ok, s.err = s.merge.next()
+	case s.pos < len(s.rows):
+		s.cur, s.ok = s.rows[s.pos], true
+		s.pos++
+	default:
+		s.ok = false
+	}
+}
+
+func (s *OrderedScan) Valid() bool {
+	if s.sc != nil {
+		return s.sc.Valid()
+	}
+	return s.ok && s.err == nil && (s.limit == 0 || s.count < s.limit)
+}
+
+func (s *OrderedScan) Next() {
+	if s.sc != nil {
+		s.sc.Next()
+		return
+	}
+	if s.Valid() {
+		s.count++
+		s.advance()
+	}
+}
+
+// the current row, the columns of the Project of the request
+func (s *OrderedScan) Deref(rec *Record) error {
+	if s.sc != nil {
+		return s.sc.Deref(rec, s.tree)
+	}
+	if !s.Valid() {
+		return s.err
+	}
+	if s.project == nil {
+		rec.Cols, rec.Vals = s.cur.Cols, append(rec.Vals[:0], s.cur.Vals...)
+		return nil
+	}
+	rec.Cols, rec.Vals = s.project, rec.Vals[:0]
+	for _, col := range s.project {
+		rec.Vals = append(rec.Vals, *s.cur.Get(col))
+	}
+	return nil
+}
+
+// the reason the rows stopped early, nil when they ran out
+func (s *OrderedScan) Err() error {
+	if s.sc != nil {
+		return s.sc.Err()
+	}
+	return s.err
+}
+
+// remove the temporary files of the sort
+func (s *OrderedScan) Close() error {
+	var errs []error
+	if s.merge != nil {
+		for _, f := range s.merge.files {
+			errs = append(errs, f.Close(), os.Remove(f.Name()))
+		}
+		s.merge = nil
+	}
+	s.ok = false
+	return errors.Join(errs...)
+}
+
+// the first rows of a sort, the last of them on top
+type rowHeap struct {
+	rows []Record
+	cmp  func(a, b *Record) bool
+}
+
+func (h *rowHeap) Len() int           { return len(h.rows) }
+func (h *rowHeap) Less(i, j int) bool { return h.cmp(&h.rows[i], &h.rows[j]) }
+func (h *rowHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *rowHeap) Push(x any)         { h.rows = append(h.rows, x.(Record)) }
+func (h *rowHeap) Pop() any {
+	rec := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return rec
+}
+
+// a sorted run of rows, in a temporary file or in memory
+type sortRun struct {
+	file  *os.File
+	r     *bufio.Reader
+	cols  []string
+	types []uint32
+	rows  []Record
+	cur   Record
+}
+
+// the next row, false at the end of the run
+func (run *sortRun) read() (bool, error) {
+	if run.r == nil {
+		if len(run.rows) == 0 {
+			return false, nil
+		}
+		run.cur, run.rows = run.rows[0], run.rows[1:]
+		return true, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(run.r, size[:]); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("sort: %w", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(run.r, buf); err != nil {
+		return false, fmt.Errorf("sort: %w", err)
+	}
+	vals := make([]Value, len(run.types))
+	for i, typ := range run.types {
+		vals[i].Type = typ
+	}
+	if decodeValues(buf, vals) != len(vals) {
+		return false, fmt.Errorf("sort: bad row in %s", run.file.Name())
+	}
+	run.cur = Record{Cols: run.cols, Vals: vals}
+	return true, nil
+}
+
+// the runs merged, the run of the first row on top
+type runMerge struct {
+	runs  []*sortRun
+	files []*os.File
+	cmp   func(a, b *Record) int
+}
+
+func (m *runMerge) Len() int           { return len(m.runs) }
+func (m *runMerge) Less(i, j int) bool { return m.cmp(&m.runs[i].cur, &m.runs[j].cur) < 0 }
+func (m *runMerge) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *runMerge) Push(x any)         { m.runs = append(m.runs, x.(*sortRun)) }
+func (m *runMerge) Pop() any {
+	run := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return run
+}
+
+// start reading the run
+func (m *runMerge) add(run *sortRun) error {
+	ok, err := run.read()
+	if ok {
+		heap.Push(m, run)
+	}
+	return err
+}
+
+func (m *runMerge) next() (Record, bool, error) {
+	if len(m.runs) == 0 {
+		return Record{}, false, nil
+	}
+	run := m.runs[0]
+	rec := run.cur
+	ok, err := run.read()
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return rec, true, err
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestScanOrdered(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{
+		Name:    "pets",
+		Types:   []uint32{TYPE_INT64, TYPE_INT64, TYPE_BYTES},
+		Cols:    []string{"id", "grp", "name"},
+		PKeys:   1,
+		Indexes: [][]string{{"grp"}},
+	}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create the table: %v", err)
+	}
+	type pet struct {
+		id, grp int64
+		name    string // "" for NULL
+	}
+	var pets []pet
+	for id := int64(1); id <= 40; id++ {
+		p := pet{id, id % 4, fmt.Sprintf("n%02d", (id*7)%10)}
+		rec := (&Record{}).AddInt64("id", p.id).AddInt64("grp", p.grp)
+		if id%9 == 0 {
+			p.name = ""
+			rec.AddNull("name", TYPE_BYTES)
+		} else {
+			rec.AddStr("name", []byte(p.name))
+		}
+		pets = append(pets, p)
+		if _, err := tx.Set("pets", *rec, MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// the ids in the order, ties by id
+	expect := func(keep func(p pet) bool, cmp func(a, b pet) int, desc bool, limit int) string {
+		var ids []int64
+		sorted := slices.Clone(pets)
+		slices.SortStableFunc(sorted, func(a, b pet) int {
+			c := cmp(a, b)
+			if c == 0 {
+				c = int(a.id - b.id)
+			}
+			if desc {
+				c = -c
+			}
+			return c
+		})
+		for _, p := range sorted {
+			if keep(p) && (limit == 0 || len(ids) < limit) {
+				ids = append(ids, p.id)
+			}
+		}
+		return fmt.Sprint(ids)
+	}
+	byName := func(a, b pet) int {
+		switch {
+		case a.name == b.name:
+			return 0
+		case a.name < b.name: // "" is NULL, first
+			return -1
+		}
+		return +1
+	}
+	byGrp := func(a, b pet) int { return int(a.grp - b.grp) }
+	byID := func(a, b pet) int { return 0 }
+	all := func(p pet) bool { return true }
+	num := func(n int64) *Expr { return LiteralExpr(Value{Type: TYPE_INT64, I64: n}) }
+
+	var reader DBReader
+	db.BeginRead(&reader)
+	defer db.EndRead(&reader)
+	for _, tt := range []struct {
+		name    string
+		sc      Scanner
+		orderBy []string
+		desc    bool
+		budget  int
+		sorted  bool
+		want    string
+	}{
+		{"index order", Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: *(&Record{}).AddNull("grp", TYPE_INT64), Key2: *(&Record{}).AddInt64("grp", 9)},
+			[]string{"grp"}, true, 0, false, expect(all, byGrp, true, 0)},
+		{"primary key by the planner", Scanner{Filter: CompareExpr(ColumnExpr("id"), CMP_GT, num(30))},
+			[]string{"id"}, true, 0, false, expect(func(p pet) bool { return p.id > 30 }, byID, true, 0)},
+		{"equal group by id", Scanner{Filter: CompareExpr(ColumnExpr("grp"), CMP_EQ, num(2))},
+			[]string{"id"}, false, 0, false, expect(func(p pet) bool { return p.grp == 2 }, byID, false, 0)},
+		{"name in memory", Scanner{}, []string{"name"}, false, 0, true, expect(all, byName, false, 0)},
+		{"name spilled", Scanner{}, []string{"name"}, false, 7, true, expect(all, byName, false, 0)},
+		{"name desc top 5", Scanner{Limit: 5}, []string{"name"}, true, 0, true, expect(all, byName, true, 5)},
+		{"name spilled limit", Scanner{Limit: 12}, []string{"name"}, false, 5, true, expect(all, byName, false, 12)},
+		{"group & name", Scanner{Filter: CompareExpr(ColumnExpr("grp"), CMP_LE, num(1))}, []string{"grp", "name"}, false, 0, true,
+			expect(func(p pet) bool { return p.grp <= 1 }, func(a, b pet) int {
+				if c := byGrp(a, b); c != 0 {
+					return c
+				}
+				return byName(a, b)
+			}, false, 0)},
+	} {
+		db.SortRows = tt.budget
+		sc := tt.sc
+		sc.Project = []string{"id"}
+		rows, err := reader.ScanOrdered("pets", &sc, tt.orderBy, tt.desc)
+		if err != nil {
+			t.Fatalf("%s: failed to scan: %v", tt.name, err)
+		}
+		if (rows.sc == nil) != tt.sorted {
+			t.Errorf("%s: expected sorted %v", tt.name, tt.sorted)
+		}
+		var files []string
+		if rows.merge != nil {
+			for _, f := range rows.merge.files {
+				files = append(files, f.Name())
+			}
+		}
+		if tt.budget > 0 && tt.sc.Limit == 0 && len(files) == 0 {
+			t.Errorf("%s: expected the rows spilled", tt.name)
+		}
+		var ids []int64
+		for ; rows.Valid(); rows.Next() {
+			rec := Record{}
+			if err := rows.Deref(&rec); err != nil {
+				t.Fatalf("%s: failed to deref: %v", tt.name, err)
+			}
+			if len(rec.Cols) != 1 {
+				t.Fatalf("%s: expected the projected column, got %v", tt.name, rec.Cols)
+			}
+			ids = append(ids, rec.Vals[0].I64)
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+		if err := rows.Close(); err != nil {
+			t.Errorf("%s: failed to close: %v", tt.name, err)
+		}
+		for _, name := range files {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("%s: expected %s removed", tt.name, name)
+			}
+		}
+	}
+
+	sc := Scanner{}
+	if _, err := reader.ScanOrdered("pets", &sc, []string{"color"}, false); err == nil {
+		t.Errorf("expected an error for a missing column")
+	}
+}
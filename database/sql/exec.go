@@ -0,0 +1,587 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// runs the statements one after the other, in the transaction of BEGIN or
+// each in one of its own
+type Session struct {
+	db  *database.DB
+	tx  *database.DBTX  // nil outside BEGIN & COMMIT
+	ctx context.Context // of SetContext, nil for none
+}
+
+// a session in the open transaction tx, or outside of one if nil
+func NewSession(db *database.DB, tx *database.DBTX) *Session {
+	return &Session{db: db, tx: tx}
+}
+
+// the transaction of BEGIN, nil once committed or rolled back
+func (s *Session) Tx() *database.DBTX {
+	return s.tx
+}
+
+// stop the statements run after it once ctx is done, they fail with the
+// error of ctx. one of BEGIN is rolled back to its savepoint, the
+// transaction stays open.
+func (s *Session) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// the transaction of a statement outside BEGIN
+func (s *Session) begin(tx *database.DBTX) error {
+	if s.ctx == nil {
+		s.db.Begin(tx)
+		return nil
+	}
+	return s.db.BeginTx(s.ctx, tx)
+}
+
+// the result of a statement
+type Result struct {
+	Status   string // the verb, with the rows written if any: INSERT 2
+	Cols     []string
+	Rows     [][]database.Value // of SELECT
+	Affected int                // the rows written
+}
+
+// a failed statement of a transaction is rolled back to this savepoint, the
+// transaction stays open
+const STATEMENT_SAVEPOINT = "@statement"
+
+func (s *Session) Exec(query string) (*Result, error) {
+	stmt, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Run(stmt)
+}
+
+func (s *Session) Run(stmt Statement) (*Result, error) {
+	if ts, ok := stmt.(*TxStatement); ok {
+		return s.txStatement(ts)
+	}
+	if s.tx != nil {
+		if err := s.tx.Savepoint(STATEMENT_SAVEPOINT); err != nil {
+			return nil, err
+		}
+		var res *Result
+		var err error
+		if s.ctx == nil {
+			res, err = run(s.tx, stmt)
+		} else {
+			err = s.tx.WithContext(s.ctx, func() (err error) {
+				res, err = run(s.tx, stmt)
+				return err
+			})
+		}
+		if err != nil {
+			err = errors.Join(err, s.tx.RollbackTo(STATEMENT_SAVEPOINT))
+		}
+		if rerr := s.tx.Release(STATEMENT_SAVEPOINT); err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	var tx database.DBTX
+	if err := s.begin(&tx); err != nil {
+		return nil, err
+	}
+	res, err := run(&tx, stmt)
+	if read := isRead(stmt); err != nil || read {
+		s.db.Abort(&tx)
+	} else {
+		err = s.db.Commit(&tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SELECT & EXPLAIN do not write, their transaction is aborted
+func isRead(stmt Statement) bool {
+	switch stmt.(type) {
+	case *Select, *Explain:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) txStatement(ts *TxStatement) (*Result, error) {
+	if ts.Verb == "BEGIN" {
+		if s.tx != nil {
+			return nil, fmt.Errorf("a transaction is already open")
+		}
+		s.tx = &database.DBTX{}
+		s.db.Begin(s.tx)
+		return &Result{Status: ts.Verb}, nil
+	}
+	if s.tx == nil {
+		return nil, fmt.Errorf("no transaction is open")
+	}
+	tx := s.tx
+	s.tx = nil
+	if ts.Verb == "COMMIT" {
+		return &Result{Status: ts.Verb}, s.db.Commit(tx)
+	}
+	return &Result{Status: ts.Verb}, s.db.Abort(tx)
+}
+
+func run(tx *database.DBTX, stmt Statement) (*Result, error) {
+	switch stmt := stmt.(type) {
+	case *CreateTable:
+		def := stmt.Def
+		if err := tx.TableNew(&def); err != nil {
+			return nil, err
+		}
+		return &Result{Status: "CREATE TABLE"}, nil
+	case *Insert:
+		return runInsert(tx, stmt)
+	case *Select:
+		return runSelect(tx, stmt)
+	case *Update:
+		return runUpdate(tx, stmt)
+	case *Delete:
+		return runDelete(tx, stmt)
+	case *Explain:
+		return runExplain(tx, stmt)
+	case *Analyze:
+		stats, err := tx.Analyze(stmt.Table)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Status: fmt.Sprintf("ANALYZE %d", stats.Rows)}, nil
+	default:
+		return nil, fmt.Errorf("unknown statement %T", stmt)
+	}
+}
+
+func runInsert(tx *database.DBTX, stmt *Insert) (*Result, error) {
+	tdef, err := tx.Describe(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	cols, cidx := stmt.Cols, []int{}
+	if cols == nil {
+		cols = tdef.Cols
+	}
+	for i, col := range cols {
+		c := database.ColIndex(tdef, col)
+		if c < 0 {
+			return nil, errorAt(stmt.pos[i], "table %s has no column %s", tdef.Name, col)
+		}
+		cidx = append(cidx, c)
+	}
+	for _, row := range stmt.Rows {
+		if len(row) != len(cols) {
+			return nil, errorAt(row[0].Pos, "%d values for the %d columns of %s", len(row), len(cols), tdef.Name)
+		}
+		rec := database.Record{}
+		for i, lit := range row {
+			v, err := literalValue(lit, cols[i], tdef.Types[cidx[i]])
+			if err != nil {
+				return nil, err
+			}
+			rec.Cols, rec.Vals = append(rec.Cols, cols[i]), append(rec.Vals, v)
+		}
+		if _, err := tx.InsertAuto(stmt.Table, &rec); err != nil {
+			return nil, err
+		}
+	}
+	n := len(stmt.Rows)
+	return &Result{Status: fmt.Sprintf("INSERT %d", n), Affected: n}, nil
+}
+
+func runSelect(tx *database.DBTX, stmt *Select) (*Result, error) {
+	sc, err := selectScanner(tx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.GroupBy != nil {
+		return runGroupBy(tx, stmt, &sc)
+	}
+	if stmt.Aggs != nil {
+		// one row, the ORDER BY & the LIMIT are of it
+		sc.Limit = 0
+		rec, err := tx.Aggregate(stmt.Table, &sc, stmt.Aggs)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Cols: rec.Cols, Rows: [][]database.Value{rec.Vals}, Status: "SELECT 1"}, nil
+	}
+	var rows []database.Record
+	if stmt.OrderBy != "" {
+		rows, err = orderedRows(tx, stmt.Table, &sc, stmt.OrderBy, stmt.Desc)
+	} else {
+		rows, err = scanRows(tx, stmt.Table, &sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Cols: sc.Project, Status: fmt.Sprintf("SELECT %d", len(rows))}
+	for _, rec := range rows {
+		res.Rows = append(res.Rows, rec.Vals)
+	}
+	return res, nil
+}
+
+// a row for each group, the columns in the order of the select list
+func runGroupBy(tx *database.DBTX, stmt *Select, sc *database.Scanner) (*Result, error) {
+	// the LIMIT is of the groups
+	sc.Limit = 0
+	groups, err := tx.GroupBy(stmt.Table, sc, stmt.GroupBy, stmt.Aggs)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Limit > 0 && len(groups) > stmt.Limit {
+		groups = groups[:stmt.Limit]
+	}
+	res := &Result{Status: fmt.Sprintf("SELECT %d", len(groups))}
+	var idx []int // in the records of the groups
+	col, agg := 0, 0
+	for _, isAgg := range stmt.items {
+		if isAgg {
+			res.Cols = append(res.Cols, stmt.Aggs[agg].String())
+			idx = append(idx, len(stmt.GroupBy)+agg)
+			agg++
+		} else {
+			res.Cols = append(res.Cols, stmt.Cols[col])
+			idx = append(idx, slices.Index(stmt.GroupBy, stmt.Cols[col]))
+			col++
+		}
+	}
+	for _, rec := range groups {
+		row := make([]database.Value, len(idx))
+		for i, j := range idx {
+			row[i] = rec.Vals[j]
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, nil
+}
+
+// the scan of the rows & the columns of the SELECT
+func selectScanner(tx *database.DBTX, stmt *Select) (database.Scanner, error) {
+	tdef, err := tx.Describe(stmt.Table)
+	if err != nil {
+		return database.Scanner{}, err
+	}
+	cols := stmt.Cols
+	if cols == nil {
+		cols = tdef.Cols
+	}
+	for i, col := range cols {
+		if database.ColIndex(tdef, col) < 0 {
+			return database.Scanner{}, errorAt(stmt.pos[i], "table %s has no column %s", tdef.Name, col)
+		}
+	}
+	if stmt.OrderBy != "" && database.ColIndex(tdef, stmt.OrderBy) < 0 {
+		return database.Scanner{}, errorAt(stmt.orderAt, "table %s has no column %s", tdef.Name, stmt.OrderBy)
+	}
+	for i, col := range stmt.GroupBy {
+		if database.ColIndex(tdef, col) < 0 {
+			return database.Scanner{}, errorAt(stmt.groupAt[i], "table %s has no column %s", tdef.Name, col)
+		}
+	}
+	sc, err := plan(tdef, stmt.Where)
+	if err != nil {
+		return database.Scanner{}, err
+	}
+	sc.Desc = stmt.OrderBy != "" && stmt.Desc
+	sc.Limit = stmt.Limit
+	sc.Project = cols
+	if stmt.Distinct {
+		sc.DistinctCols = cols
+	}
+	return sc, nil
+}
+
+// the plan of the scan of the statement, it is not run
+func runExplain(tx *database.DBTX, stmt *Explain) (*Result, error) {
+	var table string
+	var sc database.Scanner
+	var err error
+	switch st := stmt.Stmt.(type) {
+	case *Select:
+		table = st.Table
+		sc, err = selectScanner(tx, st)
+	case *Update:
+		table = st.Table
+		sc, err = whereScanner(tx, st.Table, st.Where)
+	case *Delete:
+		table = st.Table
+		sc, err = whereScanner(tx, st.Table, st.Where)
+	default:
+		return nil, fmt.Errorf("EXPLAIN of %T", stmt.Stmt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	explain := tx.Explain
+	if stmt.Analyze {
+		explain = tx.ExplainAnalyze
+	}
+	plan, err := explain(table, &sc)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Status: plan.String()}, nil
+}
+
+func whereScanner(tx *database.DBTX, table string, where []Cond) (database.Scanner, error) {
+	tdef, err := tx.Describe(table)
+	if err != nil {
+		return database.Scanner{}, err
+	}
+	return plan(tdef, where)
+}
+
+// the rows of the scan, decoded before the caller writes to the table
+func scanRows(tx *database.DBTX, table string, sc *database.Scanner) ([]database.Record, error) {
+	if err := tx.Scan(table, sc); err != nil {
+		return nil, err
+	}
+	var rows []database.Record
+	for ; sc.Valid(); sc.Next() {
+		rec := database.Record{}
+		if err := sc.Deref(&rec, tx.Tree()); err != nil {
+			return nil, err
+		}
+		rows = append(rows, rec)
+	}
+	return rows, sc.Err()
+}
+
+// the rows of the scan in the order of the column, sorted when the scan is
+// not in it
+func orderedRows(tx *database.DBTX, table string, sc *database.Scanner, order string, desc bool) ([]database.Record, error) {
+	ordered, err := tx.ScanOrdered(table, sc, []string{order}, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ordered.Close()
+	var rows []database.Record
+	for ; ordered.Valid(); ordered.Next() {
+		rec := database.Record{}
+		if err := ordered.Deref(&rec); err != nil {
+			return nil, err
+		}
+		rows = append(rows, rec)
+	}
+	return rows, ordered.Err()
+}
+
+func runUpdate(tx *database.DBTX, stmt *Update) (*Result, error) {
+	tdef, err := tx.Describe(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	set := database.Record{}
+	for _, a := range stmt.Set {
+		c := database.ColIndex(tdef, a.Col)
+		switch {
+		case c < 0:
+			return nil, errorAt(a.Pos, "table %s has no column %s", tdef.Name, a.Col)
+		case c < tdef.PKeys:
+			return nil, errorAt(a.Pos, "the primary key column %s cannot be SET", a.Col)
+		case slices.Contains(set.Cols, a.Col):
+			return nil, errorAt(a.Pos, "%s is SET twice", a.Col)
+		}
+		v, err := literalValue(a.Val, a.Col, tdef.Types[c])
+		if err != nil {
+			return nil, err
+		}
+		set.Cols, set.Vals = append(set.Cols, a.Col), append(set.Vals, v)
+	}
+	sc, err := plan(tdef, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	sc.Project = tdef.Cols[:tdef.PKeys]
+	keys, err := scanRows(tx, stmt.Table, &sc)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		rec := database.Record{
+			Cols: append(slices.Clone(key.Cols), set.Cols...),
+			Vals: append(slices.Clone(key.Vals), set.Vals...),
+		}
+		if err := tx.UpdatePartial(stmt.Table, rec); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{Status: fmt.Sprintf("UPDATE %d", len(keys)), Affected: len(keys)}, nil
+}
+
+func runDelete(tx *database.DBTX, stmt *Delete) (*Result, error) {
+	tdef, err := tx.Describe(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := plan(tdef, stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	n, err := tx.DeleteRange(stmt.Table, &sc)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Status: fmt.Sprintf("DELETE %d", n), Affected: n}, nil
+}
+
+// the scanner of the WHERE, a filter the database plans
+func plan(tdef *database.TableDef, where []Cond) (database.Scanner, error) {
+	if len(where) == 0 {
+		return database.Scanner{}, nil
+	}
+	var exprs []*database.Expr
+	for _, c := range where {
+		col := database.ColIndex(tdef, c.Col)
+		if col < 0 {
+			return database.Scanner{}, errorAt(c.Pos, "table %s has no column %s", tdef.Name, c.Col)
+		}
+		if c.Op == "IN" {
+			var list []*database.Expr
+			for _, lit := range c.List {
+				v, err := literalValue(lit, c.Col, tdef.Types[col])
+				if err != nil {
+					return database.Scanner{}, err
+				}
+				list = append(list, database.LiteralExpr(v))
+			}
+			exprs = append(exprs, database.InExpr(database.ColumnExpr(c.Col), list...))
+			continue
+		}
+		if c.Val.Kind == LITERAL_NULL {
+			return database.Scanner{}, errorAt(c.Val.Pos, "a comparison with NULL is never true")
+		}
+		v, err := literalValue(c.Val, c.Col, tdef.Types[col])
+		if err != nil {
+			return database.Scanner{}, err
+		}
+		cmp := compareOps[c.Op]
+		if c.Op == "LIKE" {
+			if cmp, err = likeCmp(c, &v); err != nil {
+				return database.Scanner{}, err
+			}
+		}
+		exprs = append(exprs, database.CompareExpr(database.ColumnExpr(c.Col), cmp, database.LiteralExpr(v)))
+	}
+	return database.Scanner{Filter: database.AndExpr(exprs...)}, nil
+}
+
+// a LIKE of a string is a prefix 'abc%', planned as a range of an index, or
+// without a wildcard an equal string
+func likeCmp(c Cond, v *database.Value) (int, error) {
+	if v.Type != database.TYPE_BYTES {
+		return 0, errorAt(c.Pos, "LIKE of %s, not a string column", c.Col)
+	}
+	pattern, cmp := v.Str, database.CMP_EQ
+	if bytes.HasSuffix(pattern, []byte("%")) {
+		pattern, cmp = pattern[:len(pattern)-1], database.CMP_PREFIX
+	}
+	if bytes.ContainsAny(pattern, "%_") {
+		return 0, errorAt(c.Val.Pos, "only a prefix LIKE 'abc%%' is supported, got %s", literalText(c.Val))
+	}
+	v.Str = pattern
+	return cmp, nil
+}
+
+// the name of the type of a column, INT64 for TYPE_INT64
+func TypeName(typ uint32) string {
+	switch typ {
+	case database.TYPE_INT64:
+		return "INT64"
+	case database.TYPE_INT32:
+		return "INT32"
+	case database.TYPE_BYTES:
+		return "BYTES"
+	case database.TYPE_FLOAT64:
+		return "FLOAT64"
+	case database.TYPE_BOOL:
+		return "BOOL"
+	case database.TYPE_TIME:
+		return "TIME"
+	default:
+		return fmt.Sprintf("type %d", typ)
+	}
+}
+
+// the value of a literal for a column of the type
+func literalValue(lit Literal, col string, typ uint32) (database.Value, error) {
+	v := database.Value{Type: typ}
+	switch {
+	case lit.Kind == LITERAL_NULL:
+		v.Null = true
+		return v, nil
+	case lit.Kind == LITERAL_PARAM && lit.bound == nil:
+		return database.Value{}, errorAt(lit.Pos, "no value for the parameter ?, see Prepare")
+	case lit.Kind == LITERAL_PARAM:
+		// of the type of the column, see bindValue
+		return *lit.bound, nil
+	}
+	var err error
+	kind := LITERAL_STRING
+	switch typ {
+	case database.TYPE_INT64, database.TYPE_INT32:
+		kind = LITERAL_NUMBER
+		bits := 64
+		if typ == database.TYPE_INT32 {
+			bits = 32
+		}
+		if lit.Kind == kind {
+			v.I64, err = strconv.ParseInt(lit.Text, 10, bits)
+		}
+	case database.TYPE_FLOAT64:
+		kind = LITERAL_NUMBER
+		if lit.Kind == kind {
+			v.F64, err = strconv.ParseFloat(lit.Text, 64)
+		}
+	case database.TYPE_BYTES:
+		v.Str = []byte(lit.Text)
+	case database.TYPE_BOOL:
+		kind = LITERAL_BOOL
+		if lit.Text == "TRUE" {
+			v.I64 = 1
+		}
+	case database.TYPE_TIME:
+		if lit.Kind == kind {
+			var t time.Time
+			for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+				if t, err = time.Parse(layout, lit.Text); err == nil {
+					break
+				}
+			}
+			v.I64 = t.UnixNano()
+		}
+	}
+	if lit.Kind != kind || err != nil {
+		return database.Value{}, errorAt(lit.Pos, "%s is not a %s value for %s", literalText(lit), TypeName(typ), col)
+	}
+	return v, nil
+}
+
+func literalText(lit Literal) string {
+	switch lit.Kind {
+	case LITERAL_STRING:
+		return "'" + lit.Text + "'"
+	case LITERAL_NULL:
+		return "NULL"
+	default:
+		return lit.Text
+	}
+}
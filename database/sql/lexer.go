@@ -0,0 +1,118 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// an error at a position of the statement, counted in bytes from 1
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+func errorAt(pos int, format string, args ...any) error {
+	return &ParseError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+const (
+	TOKEN_EOF    = 0
+	TOKEN_IDENT  = 1 // a name or a keyword
+	TOKEN_QUOTED = 2 // a "name", never a keyword
+	TOKEN_NUMBER = 3
+	TOKEN_STRING = 4 // a 'string', the text without the quotes
+	TOKEN_SYMBOL = 5
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case TOKEN_EOF:
+		return "the end of the statement"
+	case TOKEN_STRING:
+		return fmt.Sprintf("'%s'", t.text)
+	case TOKEN_QUOTED:
+		return fmt.Sprintf("%q", t.text)
+	default:
+		return t.text
+	}
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || c == '@' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// split the statement into tokens, the last one is TOKEN_EOF
+func tokenize(in string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(in); {
+		c := in[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isLetter(c):
+			for i < len(in) && (isLetter(in[i]) || isDigit(in[i])) {
+				i++
+			}
+			tokens = append(tokens, token{TOKEN_IDENT, in[start:i], start + 1})
+		case isDigit(c) || (c == '-' && i+1 < len(in) && (isDigit(in[i+1]) || in[i+1] == '.')) || c == '.':
+			i++
+			for i < len(in) && (isDigit(in[i]) || in[i] == '.' || in[i] == 'e' || in[i] == 'E' ||
+				((in[i] == '-' || in[i] == '+') && (in[i-1] == 'e' || in[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{TOKEN_NUMBER, in[start:i], start + 1})
+		case c == '\'' || c == '"':
+			// a quote is doubled inside the quotes
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(in) {
+					return nil, errorAt(start+1, "unterminated %c", c)
+				}
+				if in[i] == c {
+					if i+1 < len(in) && in[i+1] == c {
+						i++
+					} else {
+						break
+					}
+				}
+				text.WriteByte(in[i])
+			}
+			i++
+			kind := TOKEN_STRING
+			if c == '"' {
+				kind = TOKEN_QUOTED
+			}
+			tokens = append(tokens, token{kind, text.String(), start + 1})
+		default:
+			sym := string(c)
+			if i+1 < len(in) {
+				switch two := in[i : i+2]; two {
+				case "<=", ">=", "<>", "!=":
+					sym = two
+				}
+			}
+			if !strings.Contains("(),;*=<>?", sym[:1]) && sym != "!=" {
+				return nil, errorAt(start+1, "unexpected character %q", c)
+			}
+			i += len(sym)
+			tokens = append(tokens, token{TOKEN_SYMBOL, sym, start + 1})
+		}
+	}
+	return append(tokens, token{TOKEN_EOF, "", len(in) + 1}), nil
+}
@@ -0,0 +1,672 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// a parsed statement, one of the types below
+type Statement interface{}
+
+type CreateTable struct {
+	Def database.TableDef
+}
+
+type Insert struct {
+	Table string
+	Cols  []string // nil for every column in order
+	Rows  [][]Literal
+	pos   []int // of the columns
+}
+
+type Select struct {
+	Table    string
+	Cols     []string           // nil for *
+	Aggs     []database.AggSpec // the aggregates instead of the rows
+	GroupBy  []string           // the row of the aggregates for each group, nil for one row
+	Distinct bool
+	Where    []Cond
+	OrderBy  string // empty for the order of the scan
+	Desc     bool
+	Limit    int // 0 for no limit
+	pos      []int
+	orderAt  int
+	groupAt  []int
+	items    []bool // the select list, true for the next of Aggs, false for Cols
+	star     int    // the position of *, 0 for none
+}
+
+type Update struct {
+	Table string
+	Set   []Assign
+	Where []Cond
+}
+
+type Delete struct {
+	Table string
+	Where []Cond
+}
+
+// EXPLAIN of a SELECT, UPDATE or DELETE, EXPLAIN ANALYZE runs its scan too
+type Explain struct {
+	Stmt    Statement
+	Analyze bool
+}
+
+// ANALYZE of a table, see DBTX.Analyze
+type Analyze struct {
+	Table string
+}
+
+// BEGIN, COMMIT & ROLLBACK
+type TxStatement struct {
+	Verb string
+}
+
+// col op value, col LIKE 'prefix%' or col IN (values), the conditions of a
+// WHERE are joined by AND
+type Cond struct {
+	Col  string
+	Op   string // =, !=, <>, <, <=, >, >=, LIKE or IN
+	Val  Literal
+	List []Literal // of IN
+	Pos  int
+}
+
+type Assign struct {
+	Col string
+	Val Literal
+	Pos int
+}
+
+const (
+	LITERAL_NULL   = 0
+	LITERAL_NUMBER = 1
+	LITERAL_STRING = 2
+	LITERAL_BOOL   = 3
+	LITERAL_PARAM  = 4 // a ?, the value of a prepared statement
+)
+
+type Literal struct {
+	Kind  int
+	Text  string // the number or the string, TRUE or FALSE
+	Pos   int
+	bound *database.Value // of LITERAL_PARAM, see Prepared.Exec
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+// parse one statement, a trailing ';' is optional
+func Parse(query string) (Statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.symbol(";")
+	if t := p.peek(); t.kind != TOKEN_EOF {
+		return nil, errorAt(t.pos, "unexpected %s after the statement", t)
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != TOKEN_EOF {
+		p.next++
+	}
+	return t
+}
+
+// consume the keyword if it is next
+func (p *parser) keyword(word string) bool {
+	if t := p.peek(); t.kind == TOKEN_IDENT && strings.EqualFold(t.text, word) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(words ...string) error {
+	for _, word := range words {
+		if !p.keyword(word) {
+			t := p.peek()
+			return errorAt(t.pos, "expected %s, got %s", word, t)
+		}
+	}
+	return nil
+}
+
+func (p *parser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == TOKEN_SYMBOL && t.text == sym {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.symbol(sym) {
+		t := p.peek()
+		return errorAt(t.pos, "expected %s, got %s", sym, t)
+	}
+	return nil
+}
+
+// the words that end a name list or start a clause, never a name unquoted
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"ORDER": true, "BY": true, "LIMIT": true, "GROUP": true, "HAVING": true, "JOIN": true, "IN": true, "LIKE": true,
+	"INSERT": true, "INTO": true, "VALUES": true, "UPDATE": true, "SET": true,
+	"DELETE": true, "CREATE": true, "EXPLAIN": true, "DISTINCT": true, "TABLE": true, "NULL": true, "TRUE": true, "FALSE": true,
+}
+
+func (p *parser) name(what string) (string, int, error) {
+	t := p.peek()
+	if t.kind == TOKEN_QUOTED || (t.kind == TOKEN_IDENT && !reserved[strings.ToUpper(t.text)]) {
+		p.next++
+		return t.text, t.pos, nil
+	}
+	return "", t.pos, errorAt(t.pos, "expected %s, got %s", what, t)
+}
+
+// name {, name}
+func (p *parser) names(what string) ([]string, []int, error) {
+	var names []string
+	var pos []int
+	for {
+		name, at, err := p.name(what)
+		if err != nil {
+			return nil, nil, err
+		}
+		names, pos = append(names, name), append(pos, at)
+		if !p.symbol(",") {
+			return names, pos, nil
+		}
+	}
+}
+
+func (p *parser) statement() (Statement, error) {
+	t := p.peek()
+	switch {
+	case p.keyword("CREATE"):
+		return p.createTable()
+	case p.keyword("INSERT"):
+		return p.insert()
+	case p.keyword("SELECT"):
+		return p.selectStmt()
+	case p.keyword("UPDATE"):
+		return p.update()
+	case p.keyword("DELETE"):
+		return p.delete()
+	case p.keyword("EXPLAIN"):
+		analyze := p.keyword("ANALYZE")
+		next := p.peek()
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		switch stmt.(type) {
+		case *Select, *Update, *Delete:
+			return &Explain{Stmt: stmt, Analyze: analyze}, nil
+		default:
+			return nil, errorAt(next.pos, "EXPLAIN takes a SELECT, UPDATE or DELETE")
+		}
+	case p.keyword("ANALYZE"):
+		table, _, err := p.name("a table name")
+		if err != nil {
+			return nil, err
+		}
+		return &Analyze{Table: table}, nil
+	case p.keyword("BEGIN"), p.keyword("COMMIT"), p.keyword("ROLLBACK"):
+		return &TxStatement{Verb: strings.ToUpper(t.text)}, nil
+	default:
+		return nil, errorAt(t.pos, "expected a statement, got %s", t)
+	}
+}
+
+var typeNames = map[string]uint32{
+	"INT": database.TYPE_INT64, "INT64": database.TYPE_INT64, "BIGINT": database.TYPE_INT64,
+	"INT32": database.TYPE_INT32, "INTEGER": database.TYPE_INT32,
+	"BYTES": database.TYPE_BYTES, "TEXT": database.TYPE_BYTES, "VARCHAR": database.TYPE_BYTES,
+	"FLOAT": database.TYPE_FLOAT64, "FLOAT64": database.TYPE_FLOAT64, "DOUBLE": database.TYPE_FLOAT64,
+	"BOOL": database.TYPE_BOOL, "BOOLEAN": database.TYPE_BOOL,
+	"TIME": database.TYPE_TIME, "TIMESTAMP": database.TYPE_TIME,
+}
+
+// CREATE TABLE name (col type [PRIMARY KEY] [AUTO_INCREMENT] [NOT NULL]
+// [DEFAULT value] [UNIQUE], ..., [UNIQUE] INDEX (cols), UNIQUE (cols))
+func (p *parser) createTable() (Statement, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	name, _, err := p.name("a table name")
+	if err != nil {
+		return nil, err
+	}
+	def := database.TableDef{Name: name, PKeys: 1}
+	var notNull []bool
+	var defaults []database.Value
+	pkey, pkeyAt := "", 0
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		unique := p.keyword("UNIQUE")
+		switch {
+		case !unique && p.keyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			cols, _, err := p.names("a column")
+			if err != nil {
+				return nil, err
+			}
+			if len(cols) > 1 {
+				return nil, errorAt(t.pos, "only one primary key column is supported")
+			}
+			if pkey != "" {
+				return nil, errorAt(t.pos, "a second primary key")
+			}
+			pkey, pkeyAt = cols[0], t.pos
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		case p.keyword("INDEX") || (unique && p.peek().kind == TOKEN_SYMBOL):
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			cols, _, err := p.names("a column")
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			def.Indexes = append(def.Indexes, cols)
+			def.Unique = append(def.Unique, unique)
+		case unique:
+			return nil, errorAt(p.peek().pos, "expected INDEX or (, got %s", p.peek())
+		default:
+			col, at, err := p.name("a column")
+			if err != nil {
+				return nil, err
+			}
+			tt := p.advance()
+			typ, ok := typeNames[strings.ToUpper(tt.text)]
+			if tt.kind != TOKEN_IDENT || !ok {
+				return nil, errorAt(tt.pos, "expected the type of %s, got %s", col, tt)
+			}
+			def.Cols, def.Types = append(def.Cols, col), append(def.Types, typ)
+			notNull, defaults = append(notNull, false), append(defaults, database.Value{})
+		options:
+			for {
+				ot := p.peek()
+				switch {
+				case p.keyword("PRIMARY"):
+					if err := p.expectKeyword("KEY"); err != nil {
+						return nil, err
+					}
+					if pkey != "" {
+						return nil, errorAt(ot.pos, "a second primary key")
+					}
+					pkey, pkeyAt = col, at
+				case p.keyword("AUTO_INCREMENT"):
+					def.AutoIncrement = true
+				case p.keyword("NOT"):
+					if err := p.expectKeyword("NULL"); err != nil {
+						return nil, err
+					}
+					notNull[len(notNull)-1] = true
+				case p.keyword("NULL"):
+				case p.keyword("UNIQUE"):
+					def.Indexes = append(def.Indexes, []string{col})
+					def.Unique = append(def.Unique, true)
+				case p.keyword("DEFAULT"):
+					lit, err := p.literal()
+					if err != nil {
+						return nil, err
+					}
+					v, err := literalValue(lit, col, typ)
+					if err != nil {
+						return nil, err
+					}
+					defaults[len(defaults)-1] = v
+				default:
+					break options
+				}
+			}
+		}
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if pkey == "" {
+		return nil, errorAt(p.peek().pos, "table %s has no PRIMARY KEY", name)
+	}
+	// the key is the first column of a TableDef
+	if len(def.Cols) == 0 || def.Cols[0] != pkey {
+		return nil, errorAt(pkeyAt, "the primary key %s must be the first column", pkey)
+	}
+	def.NotNull, def.Defaults = notNull, defaults
+	return &CreateTable{Def: def}, nil
+}
+
+// INSERT INTO name [(cols)] VALUES (values), ...
+func (p *parser) insert() (Statement, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	stmt := &Insert{}
+	var err error
+	if stmt.Table, _, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+	if p.symbol("(") {
+		if stmt.Cols, stmt.pos, err = p.names("a column"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		start := p.peek()
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var row []Literal
+		for {
+			lit, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, lit)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if stmt.Cols != nil && len(row) != len(stmt.Cols) {
+			return nil, errorAt(start.pos, "%d values for %d columns", len(row), len(stmt.Cols))
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		if !p.symbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// SELECT [DISTINCT] cols & aggregates | * FROM name [WHERE conds] [GROUP BY cols]
+// [ORDER BY col [ASC | DESC]] [LIMIT n]
+func (p *parser) selectStmt() (Statement, error) {
+	stmt := &Select{}
+	var err error
+	distinctAt := p.peek().pos
+	stmt.Distinct = p.keyword("DISTINCT")
+	if t := p.peek(); p.symbol("*") {
+		stmt.star = t.pos
+	} else {
+		for {
+			if err := p.selectItem(stmt); err != nil {
+				return nil, err
+			}
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, _, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if p.keyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy, stmt.groupAt, err = p.names("a column"); err != nil {
+			return nil, err
+		}
+		if stmt.star > 0 {
+			return nil, errorAt(stmt.star, "SELECT * with a GROUP BY")
+		}
+	}
+	if stmt.Distinct && stmt.Aggs != nil {
+		return nil, errorAt(distinctAt, "DISTINCT with an aggregate")
+	}
+	for i, col := range stmt.Cols {
+		switch {
+		case stmt.GroupBy != nil && !slices.Contains(stmt.GroupBy, col):
+			return nil, errorAt(stmt.pos[i], "the column %s is not in the GROUP BY", col)
+		case stmt.GroupBy == nil && stmt.Aggs != nil:
+			return nil, errorAt(stmt.pos[i], "the column %s with an aggregate", col)
+		}
+	}
+	if p.keyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.OrderBy, stmt.orderAt, err = p.name("a column"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupBy != nil {
+			return nil, errorAt(stmt.orderAt, "ORDER BY with a GROUP BY, the groups are in the order of its columns")
+		}
+		if !p.keyword("ASC") {
+			stmt.Desc = p.keyword("DESC")
+		}
+	}
+	if p.keyword("LIMIT") {
+		t := p.advance()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != TOKEN_NUMBER || err != nil || n <= 0 {
+			return nil, errorAt(t.pos, "expected a positive LIMIT, got %s", t)
+		}
+		stmt.Limit = n
+	}
+	return stmt, nil
+}
+
+var aggFuncs = map[string]int{
+	"COUNT": database.AGG_COUNT, "SUM": database.AGG_SUM, "MIN": database.AGG_MIN,
+	"MAX": database.AGG_MAX, "AVG": database.AGG_AVG,
+}
+
+// a column, or COUNT(*) or an aggregate of a column: COUNT, SUM, MIN, MAX or AVG
+func (p *parser) selectItem(stmt *Select) error {
+	t := p.peek()
+	fn, ok := aggFuncs[strings.ToUpper(t.text)]
+	if t.kind != TOKEN_IDENT || !ok || p.tokens[p.next+1].text != "(" {
+		col, at, err := p.name("a column, an aggregate or *")
+		if err != nil {
+			return err
+		}
+		stmt.Cols, stmt.pos = append(stmt.Cols, col), append(stmt.pos, at)
+		stmt.items = append(stmt.items, false)
+		return nil
+	}
+	p.next += 2
+	agg := database.AggSpec{Func: fn}
+	if fn != database.AGG_COUNT || !p.symbol("*") {
+		col, _, err := p.name("a column")
+		if err != nil {
+			return err
+		}
+		agg.Col = col
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return err
+	}
+	stmt.Aggs = append(stmt.Aggs, agg)
+	stmt.items = append(stmt.items, true)
+	return nil
+}
+
+// UPDATE name SET col = value, ... [WHERE conds]
+func (p *parser) update() (Statement, error) {
+	stmt := &Update{}
+	var err error
+	if stmt.Table, _, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, at, err := p.name("a column")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		lit, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, Assign{Col: col, Val: lit, Pos: at})
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// DELETE FROM name [WHERE conds]
+func (p *parser) delete() (Statement, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	stmt := &Delete{}
+	var err error
+	if stmt.Table, _, err = p.name("a table name"); err != nil {
+		return nil, err
+	}
+	if stmt.Where, err = p.where(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// the comparisons of a WHERE & theirs in an Expr
+var compareOps = map[string]int{
+	"=": database.CMP_EQ, "!=": database.CMP_NE, "<>": database.CMP_NE,
+	"<": database.CMP_LT, "<=": database.CMP_LE, ">": database.CMP_GT, ">=": database.CMP_GE,
+}
+
+// [WHERE cond {AND cond}], a cond is col op value, col LIKE value or
+// col IN (value {, value})
+func (p *parser) where() ([]Cond, error) {
+	if !p.keyword("WHERE") {
+		return nil, nil
+	}
+	var conds []Cond
+	for {
+		col, at, err := p.name("a column")
+		if err != nil {
+			return nil, err
+		}
+		if p.keyword("IN") {
+			list, err := p.literalList()
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, Cond{Col: col, Op: "IN", List: list, Pos: at})
+		} else if p.keyword("LIKE") {
+			lit, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, Cond{Col: col, Op: "LIKE", Val: lit, Pos: at})
+		} else {
+			t := p.advance()
+			if t.kind != TOKEN_SYMBOL || compareOps[t.text] == 0 {
+				return nil, errorAt(t.pos, "expected a comparison after %s, got %s", col, t)
+			}
+			lit, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, Cond{Col: col, Op: t.text, Val: lit, Pos: at})
+		}
+		if t := p.peek(); p.keyword("OR") {
+			return nil, errorAt(t.pos, "OR is not supported, only AND")
+		}
+		if !p.keyword("AND") {
+			return conds, nil
+		}
+	}
+}
+
+// (value {, value})
+func (p *parser) literalList() ([]Literal, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var list []Literal
+	for {
+		lit, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, lit)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *parser) literal() (Literal, error) {
+	t := p.advance()
+	switch {
+	case t.kind == TOKEN_NUMBER:
+		return Literal{Kind: LITERAL_NUMBER, Text: t.text, Pos: t.pos}, nil
+	case t.kind == TOKEN_STRING:
+		return Literal{Kind: LITERAL_STRING, Text: t.text, Pos: t.pos}, nil
+	case t.kind == TOKEN_IDENT && strings.EqualFold(t.text, "NULL"):
+		return Literal{Kind: LITERAL_NULL, Pos: t.pos}, nil
+	case t.kind == TOKEN_IDENT && (strings.EqualFold(t.text, "TRUE") || strings.EqualFold(t.text, "FALSE")):
+		return Literal{Kind: LITERAL_BOOL, Text: strings.ToUpper(t.text), Pos: t.pos}, nil
+	case t.kind == TOKEN_SYMBOL && t.text == "?":
+		return Literal{Kind: LITERAL_PARAM, Text: "?", Pos: t.pos}, nil
+	default:
+		return Literal{}, errorAt(t.pos, "expected a value, got %s", t)
+	}
+}
@@ -0,0 +1,303 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"fmt"
+	"math"
+	"time"
+)
+
+// a statement parsed once & run with the values of its ? parameters. the
+// parameters are checked against the columns of the table by Prepare, and
+// the statement is prepared again once the table changed. like its Session,
+// it is not safe for concurrent use.
+type Prepared struct {
+	s       *Session
+	query   string
+	stmt    Statement
+	table   string
+	params  []param // the ? in the statement, in order
+	prefix  uint32  // of the table, it is another table once dropped & created
+	version uint64
+	schema  uint64 // DB.SchemaVersion when checked outside of a transaction, see bind
+}
+
+type param struct {
+	lit *Literal
+	col string // empty for a value of an INSERT without columns
+	pos int    // the column of the value of an INSERT without columns
+	typ uint32
+}
+
+// parse the statement & check its parameters in the transaction of the
+// session, or in a snapshot outside of one
+func (s *Session) Prepare(query string) (*Prepared, error) {
+	p := &Prepared{s: s, query: query}
+	if err := p.prepare(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Prepared) prepare() error {
+	stmt, err := Parse(p.query)
+	if err != nil {
+		return err
+	}
+	p.stmt, p.table, p.params, p.schema = stmt, "", nil, 0
+	p.collect(stmt)
+	if p.table == "" {
+		if len(p.params) > 0 {
+			return errorAt(p.params[0].lit.Pos, "a parameter in a statement without a table")
+		}
+		return nil
+	}
+	schema := p.s.db.SchemaVersion()
+	tdef, err := p.describe()
+	if err != nil {
+		return err
+	}
+	for i := range p.params {
+		par := &p.params[i]
+		if par.col == "" {
+			if par.pos >= len(tdef.Cols) {
+				return errorAt(par.lit.Pos, "more values than the %d columns of %s", len(tdef.Cols), tdef.Name)
+			}
+			par.col = tdef.Cols[par.pos]
+		}
+		c := database.ColIndex(tdef, par.col)
+		if c < 0 {
+			return errorAt(par.lit.Pos, "table %s has no column %s", tdef.Name, par.col)
+		}
+		par.typ = tdef.Types[c]
+	}
+	p.prefix, p.version = tdef.Prefix, tdef.Version
+	if p.s.tx == nil {
+		p.schema = schema
+	}
+	return nil
+}
+
+// the parameters of the statement & their columns, and its table
+func (p *Prepared) collect(stmt Statement) {
+	add := func(lit *Literal, col string, pos int) {
+		if lit.Kind == LITERAL_PARAM {
+			p.params = append(p.params, param{lit: lit, col: col, pos: pos})
+		}
+	}
+	where := func(conds []Cond) {
+		for i := range conds {
+			c := &conds[i]
+			add(&c.Val, c.Col, 0)
+			for j := range c.List {
+				add(&c.List[j], c.Col, 0)
+			}
+		}
+	}
+	switch stmt := stmt.(type) {
+	case *Insert:
+		p.table = stmt.Table
+		for _, row := range stmt.Rows {
+			for i := range row {
+				col := ""
+				if i < len(stmt.Cols) {
+					col = stmt.Cols[i]
+				}
+				add(&row[i], col, i)
+			}
+		}
+	case *Select:
+		p.table = stmt.Table
+		where(stmt.Where)
+	case *Update:
+		p.table = stmt.Table
+		for i := range stmt.Set {
+			add(&stmt.Set[i].Val, stmt.Set[i].Col, 0)
+		}
+		where(stmt.Where)
+	case *Delete:
+		p.table = stmt.Table
+		where(stmt.Where)
+	case *Explain:
+		p.collect(stmt.Stmt)
+	}
+}
+
+// the definition of the table of the statement
+func (p *Prepared) describe() (*database.TableDef, error) {
+	if p.s.tx != nil {
+		return p.s.tx.Describe(p.table)
+	}
+	var reader database.DBReader
+	p.s.db.BeginRead(&reader)
+	defer p.s.db.EndRead(&reader)
+	return reader.Describe(p.table)
+}
+
+// the number of ? in the statement
+func (p *Prepared) NumParams() int {
+	return len(p.params)
+}
+
+// run the statement with a value for each parameter: nil for NULL, an int,
+// a float, a string, a []byte, a bool, a time.Time or a database.Value
+func (p *Prepared) Exec(args ...any) (*Result, error) {
+	if err := p.bind(args); err != nil {
+		return nil, err
+	}
+	defer p.unbind()
+	return p.s.Run(p.stmt)
+}
+
+// Exec of a statement with rows, a SELECT
+func (p *Prepared) Query(args ...any) (*Result, error) {
+	if _, ok := p.stmt.(*Select); !ok {
+		return nil, fmt.Errorf("a query of %T, not a SELECT", p.stmt)
+	}
+	return p.Exec(args...)
+}
+
+// the rows of a SELECT read one at a time, see Session.Rows
+func (p *Prepared) Rows(args ...any) (*Rows, error) {
+	if err := p.bind(args); err != nil {
+		return nil, err
+	}
+	defer p.unbind()
+	return p.s.Rows(p.stmt)
+}
+
+// the values of the parameters, prepared again first if the table changed
+func (p *Prepared) bind(args []any) error {
+	if len(args) != len(p.params) {
+		return fmt.Errorf("%d values for the %d parameters", len(args), len(p.params))
+	}
+	// outside of a transaction the table is unchanged while no commit
+	// changed the catalog
+	if schema := p.s.db.SchemaVersion(); p.table != "" && (p.s.tx != nil || schema != p.schema) {
+		tdef, err := p.describe()
+		if err != nil {
+			return err
+		}
+		if tdef.Prefix != p.prefix || tdef.Version != p.version {
+			if err := p.prepare(); err != nil {
+				return err
+			}
+		} else if p.s.tx == nil {
+			p.schema = schema
+		}
+	}
+	for i, arg := range args {
+		par := p.params[i]
+		v, err := bindValue(arg, par.typ)
+		if err != nil {
+			p.unbind()
+			return errorAt(par.lit.Pos, "parameter %d: %v for %s", i+1, err, par.col)
+		}
+		par.lit.bound = &v
+	}
+	return nil
+}
+
+func (p *Prepared) unbind() {
+	for _, par := range p.params {
+		par.lit.bound = nil
+	}
+}
+
+// the value of the type of a column
+func bindValue(arg any, typ uint32) (database.Value, error) {
+	v := database.Value{Type: typ}
+	mismatch := fmt.Errorf("%T is not a %s value", arg, TypeName(typ))
+	switch a := arg.(type) {
+	case nil:
+		v.Null = true
+		return v, nil
+	case database.Value:
+		if a.Type != typ && !(a.Null && a.Type == 0) {
+			return v, mismatch
+		}
+		a.Type = typ
+		return a, nil
+	case time.Time:
+		if typ != database.TYPE_TIME {
+			return v, mismatch
+		}
+		v.I64 = a.UnixNano()
+		return v, nil
+	case bool:
+		if typ != database.TYPE_BOOL {
+			return v, mismatch
+		}
+		if a {
+			v.I64 = 1
+		}
+		return v, nil
+	case string:
+		if typ != database.TYPE_BYTES {
+			return v, mismatch
+		}
+		v.Str = []byte(a)
+		return v, nil
+	case []byte:
+		if typ != database.TYPE_BYTES {
+			return v, mismatch
+		}
+		v.Str = a
+		return v, nil
+	case float32:
+		arg = float64(a)
+	}
+	switch typ {
+	case database.TYPE_FLOAT64:
+		switch a := arg.(type) {
+		case float64:
+			v.F64 = a
+		default:
+			n, ok := intArg(arg)
+			if !ok {
+				return v, mismatch
+			}
+			v.F64 = float64(n)
+		}
+	case database.TYPE_INT64, database.TYPE_INT32:
+		n, ok := intArg(arg)
+		if !ok {
+			return v, mismatch
+		}
+		if typ == database.TYPE_INT32 && (n < math.MinInt32 || n > math.MaxInt32) {
+			return v, fmt.Errorf("%d is out of the range of %s", n, TypeName(typ))
+		}
+		v.I64 = n
+	default:
+		return v, mismatch
+	}
+	return v, nil
+}
+
+// an int of any size, false for another type or a uint above MaxInt64
+func intArg(arg any) (int64, bool) {
+	switch a := arg.(type) {
+	case int:
+		return int64(a), true
+	case int8:
+		return int64(a), true
+	case int16:
+		return int64(a), true
+	case int32:
+		return int64(a), true
+	case int64:
+		return a, true
+	case uint8:
+		return int64(a), true
+	case uint16:
+		return int64(a), true
+	case uint32:
+		return int64(a), true
+	case uint:
+		return int64(a), uint64(a) <= math.MaxInt64
+	case uint64:
+		return int64(a), a <= math.MaxInt64
+	default:
+		return 0, false
+	}
+}
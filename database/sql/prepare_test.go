@@ -0,0 +1,106 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"errors"
+	"testing"
+)
+
+func TestPrepared(t *testing.T) {
+	s := setupSession(t)
+	insert, err := s.Prepare("INSERT INTO people VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("failed to prepare: %v", err)
+	}
+	if insert.NumParams() != 3 {
+		t.Errorf("expected 3 parameters, got %d", insert.NumParams())
+	}
+	for id := 10; id < 1010; id++ {
+		if _, err := insert.Exec(id, "p", int32(id%50)); err != nil {
+			t.Fatalf("failed to insert %d: %v", id, err)
+		}
+	}
+	if got := rowsText(mustExec(t, s, "SELECT COUNT(*) FROM people WHERE age = 10")); got != "20" {
+		t.Errorf("expected 20 rows of the inserts, got %s", got)
+	}
+
+	query, err := s.Prepare("SELECT name FROM people WHERE id IN (?, ?) AND name LIKE ? ORDER BY name")
+	if err != nil {
+		t.Fatalf("failed to prepare: %v", err)
+	}
+	for _, tt := range []struct {
+		args []any
+		want string
+	}{
+		{[]any{1, int64(2), "%"}, "ann;bob"},
+		{[]any{3, 4, "d%"}, "di"},
+		{[]any{uint8(1), nil, "a%"}, "ann"},
+		{[]any{database.Value{Type: database.TYPE_INT64, I64: 2}, 0, "bob"}, "bob"},
+	} {
+		res, err := query.Query(tt.args...)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if got := rowsText(res); got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.args, tt.want, got)
+		}
+	}
+	update, err := s.Prepare("UPDATE people SET age = ? WHERE id = ?")
+	if err != nil {
+		t.Fatalf("failed to prepare: %v", err)
+	}
+	if res, err := update.Exec(nil, 1); err != nil || res.Affected != 1 {
+		t.Fatalf("failed to update: %v %v", res, err)
+	}
+	if _, err := update.Query(1, 1); err == nil {
+		t.Errorf("expected an error for a query of an UPDATE")
+	}
+
+	// the types are checked by Prepare & by Exec
+	var pe *ParseError
+	for _, q := range []string{
+		"SELECT * FROM people WHERE phone = ?",
+		"INSERT INTO people VALUES (?, ?, ?, ?)",
+		"CREATE TABLE t (a INT PRIMARY KEY, b INT DEFAULT ?)",
+	} {
+		if _, err := s.Prepare(q); !errors.As(err, &pe) {
+			t.Errorf("%s: expected an error with a position, got %v", q, err)
+		}
+	}
+	for _, args := range [][]any{{"x", 1}, {int64(1) << 40, 1}, {1.5, 1}, {1, true}} {
+		if _, err := update.Exec(args...); !errors.As(err, &pe) || pe.Pos != 25 && pe.Pos != 38 {
+			t.Errorf("%v: expected an error at a parameter, got %v", args, err)
+		}
+	}
+	if _, err := update.Exec(1); err == nil {
+		t.Errorf("expected an error for a missing value")
+	}
+	if _, err := s.Exec("SELECT * FROM people WHERE id = ?"); !errors.As(err, &pe) || pe.Pos != 33 {
+		t.Errorf("expected an error for a parameter without a value, got %v", err)
+	}
+
+	// prepared again after the table changed
+	get, err := s.Prepare("SELECT * FROM people WHERE id = ?")
+	if err != nil {
+		t.Fatalf("failed to prepare: %v", err)
+	}
+	mustExec(t, s, "BEGIN")
+	if err := s.Tx().DropTable("people"); err != nil {
+		t.Fatalf("failed to drop: %v", err)
+	}
+	mustExec(t, s, "CREATE TABLE people (id TEXT PRIMARY KEY, age INT)")
+	mustExec(t, s, "INSERT INTO people VALUES ('x', 1)")
+	mustExec(t, s, "COMMIT")
+	if res, err := get.Query("x"); err != nil || rowsText(res) != "x,1" {
+		t.Errorf("expected the row of the new table, got %v %v", res, err)
+	}
+	version := get.version
+	mustExec(t, s, "BEGIN")
+	if err := s.Tx().AddColumn("people", "city", database.TYPE_BYTES, database.Value{}); err != nil {
+		t.Fatalf("failed to add a column: %v", err)
+	}
+	mustExec(t, s, "COMMIT")
+	if res, err := get.Query("x"); err != nil || rowsText(res) != "x,1,NULL" || get.version == version {
+		t.Errorf("expected the new column, got %v %v", res, err)
+	}
+}
@@ -0,0 +1,91 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runs a statement typed in the REPL in its transaction, nil outside BEGIN,
+// and prints the rows in the output mode of the REPL, or the status when
+// status is set. the statement stops once ctx is done.
+func Repl(ctx context.Context, db *database.DB, tx *database.DBTX, stmt string, status bool) error {
+	var res *Result
+	stats, err := db.Measure(func() (err error) {
+		s := NewSession(db, tx)
+		s.SetContext(ctx)
+		res, err = s.Exec(stmt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res.Cols != nil {
+		err = database.PrintRows(os.Stdout, res.Cols, res.Rows)
+	} else if status {
+		Print(os.Stdout, res)
+	}
+	database.PrintTiming(stats)
+	return err
+}
+
+// the rows of a SELECT in aligned columns, or the status of the others
+func Print(w io.Writer, res *Result) {
+	if res.Cols == nil {
+		fmt.Fprintln(w, res.Status)
+		return
+	}
+	cells := [][]string{res.Cols}
+	for _, row := range res.Rows {
+		line := make([]string, len(row))
+		for i, v := range row {
+			line[i] = formatValue(v)
+		}
+		cells = append(cells, line)
+	}
+	widths := make([]int, len(res.Cols))
+	for _, line := range cells {
+		for i, cell := range line {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for n, line := range cells {
+		for i, cell := range line {
+			line[i] = cell + strings.Repeat(" ", widths[i]-len(cell))
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(line, " | "), " "))
+		if n == 0 {
+			dashes := make([]string, len(widths))
+			for i, width := range widths {
+				dashes[i] = strings.Repeat("-", width)
+			}
+			fmt.Fprintln(w, strings.Join(dashes, "-+-"))
+		}
+	}
+	fmt.Fprintf(w, "(%d rows)\n", len(res.Rows))
+}
+
+func formatValue(v database.Value) string {
+	if v.Null {
+		return "NULL"
+	}
+	switch v.Type {
+	case database.TYPE_INT64, database.TYPE_INT32:
+		return strconv.FormatInt(v.I64, 10)
+	case database.TYPE_BYTES:
+		return string(v.Str)
+	case database.TYPE_FLOAT64:
+		return strconv.FormatFloat(v.F64, 'g', -1, 64)
+	case database.TYPE_BOOL:
+		return strconv.FormatBool(v.I64 != 0)
+	case database.TYPE_TIME:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"atomixDB/database"
+)
+
+// the rows of a SELECT, read from the scan one at a time. the rows of an
+// aggregate or a GROUP BY are computed first. outside BEGIN the rows have a
+// transaction of their own, ended by Close.
+type Rows struct {
+	Cols  []string
+	Types []uint32 // of the columns
+	next  func(rec *database.Record) (bool, error)
+	close func() error
+}
+
+// the next row, false after the last one
+func (r *Rows) Next(rec *database.Record) (bool, error) {
+	if r.next == nil {
+		return false, nil
+	}
+	ok, err := r.next(rec)
+	if !ok || err != nil {
+		r.next = nil
+	}
+	return ok, err
+}
+
+func (r *Rows) Close() error {
+	r.next = nil
+	if r.close == nil {
+		return nil
+	}
+	close := r.close
+	r.close = nil
+	return close()
+}
+
+// the rows of a SELECT in the transaction of the session, or in one aborted
+// by Rows.Close outside of BEGIN. another statement is run by Run & its rows,
+// if any, are without types.
+func (s *Session) Rows(stmt Statement) (*Rows, error) {
+	sel, ok := stmt.(*Select)
+	if !ok {
+		res, err := s.Run(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return resultRows(res), nil
+	}
+	if s.tx != nil {
+		return selectRows(s.tx, sel)
+	}
+	tx := &database.DBTX{}
+	if err := s.begin(tx); err != nil {
+		return nil, err
+	}
+	rows, err := selectRows(tx, sel)
+	if err != nil {
+		s.db.Abort(tx)
+		return nil, err
+	}
+	done := rows.close
+	rows.close = func() error {
+		err := done()
+		if aerr := s.db.Abort(tx); err == nil {
+			err = aerr
+		}
+		return err
+	}
+	return rows, nil
+}
+
+func selectRows(tx *database.DBTX, stmt *Select) (*Rows, error) {
+	tdef, err := tx.Describe(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.GroupBy != nil || stmt.Aggs != nil {
+		res, err := runSelect(tx, stmt)
+		if err != nil {
+			return nil, err
+		}
+		rows := resultRows(res)
+		col, agg := 0, 0
+		for _, isAgg := range stmt.items {
+			if isAgg {
+				rows.Types = append(rows.Types, aggType(tdef, stmt.Aggs[agg]))
+				agg++
+			} else {
+				rows.Types = append(rows.Types, tdef.Types[database.ColIndex(tdef, stmt.Cols[col])])
+				col++
+			}
+		}
+		return rows, nil
+	}
+
+	sc, err := selectScanner(tx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{Cols: sc.Project}
+	for _, col := range sc.Project {
+		rows.Types = append(rows.Types, tdef.Types[database.ColIndex(tdef, col)])
+	}
+	first := true
+	if stmt.OrderBy != "" {
+		ordered, err := tx.ScanOrdered(stmt.Table, &sc, []string{stmt.OrderBy}, stmt.Desc)
+		if err != nil {
+			return nil, err
+		}
+		rows.close = ordered.Close
+		rows.next = func(rec *database.Record) (bool, error) {
+			if !first {
+				ordered.Next()
+			}
+			first = false
+			if !ordered.Valid() {
+				return false, ordered.Err()
+			}
+			return true, ordered.Deref(rec)
+		}
+		return rows, nil
+	}
+	if err := tx.Scan(stmt.Table, &sc); err != nil {
+		return nil, err
+	}
+	rows.close = func() error { return nil }
+	rows.next = func(rec *database.Record) (bool, error) {
+		if !first {
+			sc.Next()
+		}
+		first = false
+		if !sc.Valid() {
+			return false, sc.Err()
+		}
+		return true, sc.Deref(rec, tx.Tree())
+	}
+	return rows, nil
+}
+
+// the rows of the result, computed already
+func resultRows(res *Result) *Rows {
+	rows := &Rows{Cols: res.Cols, close: func() error { return nil }}
+	i := 0
+	rows.next = func(rec *database.Record) (bool, error) {
+		if i == len(res.Rows) {
+			return false, nil
+		}
+		rec.Cols, rec.Vals = res.Cols, append(rec.Vals[:0], res.Rows[i]...)
+		i++
+		return true, nil
+	}
+	return rows
+}
+
+// the type of the values of the aggregate, see database.AggSpec
+func aggType(tdef *database.TableDef, agg database.AggSpec) uint32 {
+	switch agg.Func {
+	case database.AGG_COUNT:
+		return database.TYPE_INT64
+	case database.AGG_AVG:
+		return database.TYPE_FLOAT64
+	}
+	typ := tdef.Types[database.ColIndex(tdef, agg.Col)]
+	if agg.Func == database.AGG_SUM && typ != database.TYPE_FLOAT64 {
+		return database.TYPE_INT64
+	}
+	return typ
+}
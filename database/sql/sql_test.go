@@ -0,0 +1,279 @@
+package sql
+
+import (
+	"atomixDB/database"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func setupSession(t *testing.T) *Session {
+	db, err := database.Open(database.MEMORY_PATH)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewSession(db, nil)
+	for _, q := range []string{
+		"CREATE TABLE people (id INT PRIMARY KEY, name TEXT NOT NULL, age INT32, INDEX (age));",
+		"INSERT INTO people VALUES (1, 'ann', 30), (2, 'bob', 25), (3, 'cy', 30), (4, 'di', 41)",
+	} {
+		mustExec(t, s, q)
+	}
+	return s
+}
+
+func mustExec(t *testing.T, s *Session, q string) *Result {
+	t.Helper()
+	res, err := s.Exec(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return res
+}
+
+// the rows as "v,v;v,v"
+func rowsText(res *Result) string {
+	lines := make([]string, len(res.Rows))
+	for i, row := range res.Rows {
+		vals := make([]string, len(row))
+		for j, v := range row {
+			vals[j] = formatValue(v)
+		}
+		lines[i] = strings.Join(vals, ",")
+	}
+	return strings.Join(lines, ";")
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		pos   int
+	}{
+		{"SELEC * FROM t", 1},
+		{"SELECT * FROM", 14},
+		{"SELECT * FROM t WHERE a ~ 1", 25},
+		{"SELECT * FROM t WHERE a = 1 OR b = 2", 29},
+		{"SELECT * FROM t WHERE a IN ()", 29},
+		{"SELECT * FROM t WHERE a IN (1, 2", 33},
+		{"INSERT INTO t VALUES (1, 'x)", 26},
+		{"CREATE TABLE t (a INT, b BLOB)", 26},
+		{"SELECT * FROM t LIMIT 0", 23},
+		{"DELETE FROM t extra", 15},
+		{"SELECT id, COUNT(*) FROM t", 8},
+		{"SELECT SUM(*) FROM t", 12},
+		{"SELECT DISTINCT COUNT(*) FROM t", 8},
+		{"SELECT a, COUNT(*) FROM t GROUP BY b", 8},
+		{"SELECT * FROM t GROUP BY b", 8},
+		{"SELECT a FROM t GROUP BY a ORDER BY a", 37},
+	} {
+		_, err := Parse(tt.query)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Pos != tt.pos {
+			t.Errorf("%s: expected an error at %d, got %v", tt.query, tt.pos, err)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	s := setupSession(t)
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM people", "1,ann,30;2,bob,25;3,cy,30;4,di,41"},
+		{"SELECT name FROM people WHERE id = 3", "cy"},
+		{"SELECT id FROM people WHERE id > 1 AND id <= 3", "2;3"},
+		{"SELECT id FROM people WHERE id < 3 ORDER BY id DESC", "2;1"},
+		{"SELECT name FROM people WHERE age = 30", "ann;cy"},
+		{"SELECT name, age FROM people WHERE age >= 30 ORDER BY age DESC LIMIT 2", "di,41;cy,30"},
+		{"SELECT name FROM people ORDER BY age", "bob;ann;cy;di"},
+		{"SELECT id FROM people WHERE id >= 10", ""},
+		// the rest of the WHERE filters the rows
+		{"SELECT id FROM people WHERE name = 'ann'", "1"},
+		{"SELECT id FROM people WHERE age = 30 AND name <> 'ann'", "3"},
+		{"SELECT id FROM people WHERE age != 30 AND id > 1", "2;4"},
+		{"SELECT COUNT(*), SUM(age), MIN(name), MAX(age), AVG(age) FROM people", "4,126,ann,41,31.5"},
+		{"SELECT count(*) FROM people WHERE age = 30 LIMIT 1", "2"},
+		{"SELECT MAX(id), COUNT(age) FROM people WHERE id > 10", "NULL,0"},
+		{"SELECT age, COUNT(*), MIN(name) FROM people GROUP BY age", "25,1,bob;30,2,ann;41,1,di"},
+		{"SELECT MAX(id), age FROM people WHERE id > 1 GROUP BY age LIMIT 2", "2,25;3,30"},
+		{"SELECT name FROM people WHERE age = 30 GROUP BY age, name", "ann;cy"},
+		{"SELECT DISTINCT age FROM people", "30;25;41"},
+		{"SELECT DISTINCT age FROM people WHERE age >= 30", "30;41"},
+		{"SELECT DISTINCT age FROM people ORDER BY age DESC LIMIT 2", "41;30"},
+		// the rows not in the order are sorted
+		{"SELECT id FROM people WHERE age > 1 ORDER BY id", "1;2;3;4"},
+		{"SELECT name FROM people ORDER BY name DESC", "di;cy;bob;ann"},
+		{"SELECT id FROM people WHERE id > 1 ORDER BY age DESC LIMIT 2", "4;3"},
+		{"SELECT id FROM people WHERE age IN (41, 25, NULL) ORDER BY id", "2;4"},
+		{"SELECT name FROM people WHERE id IN (3) AND age IN (30)", "cy"},
+		{"SELECT id FROM people WHERE name LIKE 'b%'", "2"},
+		{"SELECT id FROM people WHERE name LIKE 'cy' AND age = 30", "3"},
+		{"SELECT COUNT(*) FROM people WHERE name LIKE '%'", "4"},
+	} {
+		if got := rowsText(mustExec(t, s, tt.query)); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.query, tt.want, got)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT * FROM people ORDER BY phone",
+		"SELECT phone FROM people",
+		"SELECT * FROM people WHERE id = 'x'",
+		"SELECT * FROM people WHERE id = NULL",
+		"SELECT * FROM people WHERE id IN (1, 'x')",
+		"SELECT * FROM people WHERE age LIKE 'a%'",
+		"SELECT * FROM people WHERE name LIKE '%a'",
+		"SELECT * FROM people WHERE name LIKE 'a_%'",
+	} {
+		var pe *ParseError
+		if _, err := s.Exec(q); !errors.As(err, &pe) {
+			t.Errorf("%s: expected an error with a position, got %v", q, err)
+		}
+	}
+	if _, err := s.Exec("SELECT SUM(name) FROM people"); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+	if _, err := s.Exec("SELECT * FROM missing"); !errors.Is(err, database.ErrTableNotFound) {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s := setupSession(t)
+	if res := mustExec(t, s, "UPDATE people SET age = 31, name = 'ann2' WHERE age = 30"); res.Affected != 2 {
+		t.Errorf("expected 2 rows updated, got %s", res.Status)
+	}
+	if got := rowsText(mustExec(t, s, "SELECT id FROM people WHERE age = 31")); got != "1;3" {
+		t.Errorf("expected the updated rows in the index, got %q", got)
+	}
+	if res := mustExec(t, s, "DELETE FROM people WHERE id >= 3"); res.Status != "DELETE 2" {
+		t.Errorf("expected DELETE 2, got %s", res.Status)
+	}
+	if got := rowsText(mustExec(t, s, "SELECT name FROM people")); got != "ann2;bob" {
+		t.Errorf("expected 2 rows left, got %q", got)
+	}
+	if _, err := s.Exec("UPDATE people SET id = 5 WHERE id = 1"); err == nil {
+		t.Errorf("expected an error for a SET of the primary key")
+	}
+	if _, err := s.Exec("INSERT INTO people (id, name) VALUES (1, 'x')"); !errors.Is(err, database.ErrDuplicateKey) {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := setupSession(t)
+	mustExec(t, s, "BEGIN")
+	mustExec(t, s, "INSERT INTO people (id, name) VALUES (5, 'ed')")
+	// the failed statement is undone, the transaction goes on
+	if _, err := s.Exec("INSERT INTO people (id, name) VALUES (6, 'fay'), (1, 'dup')"); err == nil {
+		t.Fatalf("expected a duplicate key")
+	}
+	if got := rowsText(mustExec(t, s, "SELECT id FROM people WHERE id >= 5")); got != "5" {
+		t.Errorf("expected the writes of the transaction, got %q", got)
+	}
+	mustExec(t, s, "ROLLBACK")
+	if s.Tx() != nil {
+		t.Errorf("expected no transaction after ROLLBACK")
+	}
+	if got := rowsText(mustExec(t, s, "SELECT id FROM people WHERE id >= 5")); got != "" {
+		t.Errorf("expected the rolled back rows gone, got %q", got)
+	}
+	if _, err := s.Exec("COMMIT"); err == nil {
+		t.Errorf("expected an error for COMMIT without BEGIN")
+	}
+
+	mustExec(t, s, "BEGIN")
+	mustExec(t, s, "DELETE FROM people WHERE id = 4")
+	mustExec(t, s, "COMMIT")
+	if got := rowsText(mustExec(t, s, "SELECT id FROM people")); got != "1;2;3" {
+		t.Errorf("expected the committed delete, got %q", got)
+	}
+}
+
+func TestSessionContext(t *testing.T) {
+	s := setupSession(t)
+	var values []string
+	for id := 10; id < 400; id++ {
+		values = append(values, fmt.Sprintf("(%d, 'p%d', %d)", id, id, id%50))
+	}
+	mustExec(t, s, "INSERT INTO people VALUES "+strings.Join(values, ", "))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the statement stops & is undone, the transaction goes on
+	mustExec(t, s, "BEGIN")
+	mustExec(t, s, "INSERT INTO people (id, name) VALUES (1000, 'new')")
+	s.SetContext(ctx)
+	for _, q := range []string{"DELETE FROM people WHERE id >= 0", "SELECT id FROM people WHERE name = 'none'"} {
+		if _, err := s.Exec(q); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected the error of the context, got %v", q, err)
+		}
+	}
+	s.SetContext(nil)
+	if got := rowsText(mustExec(t, s, "SELECT COUNT(*) FROM people")); got != "395" {
+		t.Errorf("expected the rows of the transaction, got %q", got)
+	}
+	mustExec(t, s, "COMMIT")
+
+	// outside BEGIN the statement does not start
+	s.SetContext(ctx)
+	if _, err := s.Exec("SELECT id FROM people WHERE id = 1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error of the context, got %v", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	s := setupSession(t)
+	var out strings.Builder
+	Print(&out, mustExec(t, s, "SELECT id, name FROM people WHERE id <= 2"))
+	want := "id | name\n---+-----\n1  | ann\n2  | bob\n(2 rows)\n"
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
+
+func TestExplain(t *testing.T) {
+	s := setupSession(t)
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"EXPLAIN SELECT name FROM people WHERE age >= 30 ORDER BY age DESC",
+			[]string{"path:   index (age, id)", "order:  descending", "fetch:  the primary row of each index entry"}},
+		{"EXPLAIN SELECT id FROM people WHERE age = 30",
+			[]string{"path:   index (age, id)", "rows:   unknown"}},
+		{"EXPLAIN SELECT * FROM people WHERE id = 2", []string{"path:   primary key (id)", "rows:   1"}},
+		{"EXPLAIN DELETE FROM people WHERE name = 'ann'",
+			[]string{"path:   primary key (id)", `filter: name = "ann"`, "rows:   unknown"}},
+		{"EXPLAIN UPDATE people SET age = 1", []string{"path:   primary key (id)", "rows:   4"}},
+		{"EXPLAIN SELECT id FROM people WHERE name LIKE 'a%'",
+			[]string{`filter: name starts with "a"`, `warning: name starts with "a" is checked on each row, name is not the next column of the range of the primary key or an index`}},
+	} {
+		res := mustExec(t, s, tt.query)
+		for _, line := range tt.want {
+			if !strings.Contains(res.Status+"\n", line+"\n") {
+				t.Errorf("%s: expected the line %q in\n%s", tt.query, line, res.Status)
+			}
+		}
+	}
+	// nothing was run
+	if got := rowsText(mustExec(t, s, "SELECT age FROM people WHERE id = 1")); got != "30" {
+		t.Errorf("expected the row unchanged, got %q", got)
+	}
+	if _, err := s.Exec("EXPLAIN INSERT INTO people VALUES (9, 'x', 1)"); err == nil {
+		t.Errorf("expected an error for EXPLAIN of an INSERT")
+	}
+
+	// the statistics estimate the rows, EXPLAIN ANALYZE counts them
+	if res := mustExec(t, s, "ANALYZE people;"); res.Status != "ANALYZE 4" {
+		t.Errorf("expected the rows analyzed, got %q", res.Status)
+	}
+	res := mustExec(t, s, "EXPLAIN ANALYZE SELECT id FROM people WHERE age = 30")
+	if !strings.HasSuffix(res.Status, "rows:   2 estimated\nactual: 2") {
+		t.Errorf("expected the estimated & actual rows, got\n%s", res.Status)
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type TreeStats struct {
+	Depth         int
+	LeafNodes     int
+	InternalNodes int
+	Keys          int // without the dummy key
+	BytesKeys     int
+	BytesVals     int // including the values in overflow pages
+	OverflowPages int
+	AvgFill       float64 // the used fraction of a page, averaged over the nodes
+}
+
+// the stats of a table & of each of its indexes.
+// the tables share a tree, so a node holding keys of several prefixes
+// is counted in each of them.
+type TableStats struct {
+	Primary TreeStats
+	Indexes []TreeStats // in the order of TableDef.Indexes
+}
+
+// computed by a full traversal
+func (tree *BTree) Stats() TreeStats {
+	return treeStatsRange(tree, nil, nil)
+}
+
+// Deprecated: use DBTX.TableStats or DBReader.TableStats.
+func (db *DB) TableStats(table string, tree *BTree) (*TableStats, error) {
+	tdef := GetTableDef(db, table, tree)
+	if tdef == nil {
+		return nil, tableNotFound(table)
+	}
+	stats := &TableStats{Primary: treeStatsPrefix(tree, tdef.Prefix)}
+	for _, prefix := range tdef.IndexPrefix {
+		stats.Indexes = append(stats.Indexes, treeStatsPrefix(tree, prefix))
+	}
+	return stats, nil
+}
+
+func treeStatsPrefix(tree *BTree, prefix uint32) TreeStats {
+	start := binary.BigEndian.AppendUint32(nil, prefix)
+	end := binary.BigEndian.AppendUint32(nil, prefix+1)
+	return treeStatsRange(tree, start, end)
+}
+
+// the stats of the nodes holding keys in [start, end), nil bounds are unbounded
+func treeStatsRange(tree *BTree, start, end []byte) TreeStats {
+	stats := TreeStats{}
+	if tree.root == 0 {
+		return stats
+	}
+	fill := 0.0
+	statsNode(tree, tree.get(tree.root), 1, start, end, &stats, &fill)
+	if nodes := stats.LeafNodes + stats.InternalNodes; nodes > 0 {
+		stats.AvgFill = fill / float64(nodes)
+	}
+	return stats
+}
+
+func statsNode(tree *BTree, node BNode, depth int, start, end []byte, stats *TreeStats, fill *float64) {
+	stats.Depth = max(stats.Depth, depth)
+	*fill += float64(node.nbytes()) / BTREE_NODE_SIZE
+	nkeys := node.nKeys()
+	switch node.bNodeType() {
+	case BNODE_LEAF:
+		stats.LeafNodes++
+		for i := uint16(0); i < nkeys; i++ {
+			key := node.getKey(i)
+			if len(key) == 0 || (start != nil && bytes.Compare(key, start) < 0) {
+				continue
+			}
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				break
+			}
+			stats.Keys++
+			stats.BytesKeys += len(key)
+			stats.BytesVals += node.valLen(i)
+			if node.isOverflow(i) {
+				stats.OverflowPages += overflowPages(node.valLen(i))
+			}
+		}
+	case BNODE_INODE:
+		stats.InternalNodes++
+		for i := uint16(0); i < nkeys; i++ {
+			// the kid covers [key i, key i+1)
+			if end != nil && bytes.Compare(node.getKey(i), end) >= 0 {
+				break
+			}
+			if start != nil && i+1 < nkeys && bytes.Compare(node.getKey(i+1), start) <= 0 {
+				continue
+			}
+			statsNode(tree, tree.get(node.getPtr(i)), depth+1, start, end, stats, fill)
+		}
+	default:
+		panic("bad node!!")
+	}
+}
@@ -13,15 +13,16 @@ type TableScanner struct {
 	prefix   []byte
 }
 
-func (db *DB) QueryWithFilter(table string, tdef *TableDef, filterRec *Record) ([]*Record, error) {
-	results, err := fullTableScan(db, table, tdef)
+// Deprecated: use DBReader.Scan with a Filter.
+func (db *DB) QueryWithFilter(table string, tdef *TableDef, filterRec *Record, kvReader *KVReader) ([]*Record, error) {
+	results, err := fullTableScan(db, table, tdef, kvReader)
 	if err != nil {
 		return nil, err
 	}
 
 	idx := ColIndex(tdef, filterRec.Cols[0])
 	if idx == -1 {
-		return nil, fmt.Errorf("column %s not found", filterRec.Cols[0])
+		return nil, columnNotFound(table, filterRec.Cols[0])
 	}
 	var matchingRecords []*Record
 	for _, record := range results {
@@ -33,6 +34,7 @@ func (db *DB) QueryWithFilter(table string, tdef *TableDef, filterRec *Record) (
 		}
 	}
 
+	db.countRows(0, int64(len(matchingRecords)))
 	if len(matchingRecords) == 0 {
 		return nil, fmt.Errorf("no matching records found")
 	}
@@ -42,7 +44,7 @@ func (db *DB) QueryWithFilter(table string, tdef *TableDef, filterRec *Record) (
 
 func NewTableScanner(db *DB, table string, kvReader *KVReader, tdef *TableDef) (*TableScanner, error) {
 	if tdef == nil {
-		return nil, fmt.Errorf("table definition not found")
+		return nil, tableNotFound(table)
 	}
 
 	return &TableScanner{
@@ -53,12 +55,8 @@ func NewTableScanner(db *DB, table string, kvReader *KVReader, tdef *TableDef) (
 	}, nil
 }
 
-func fullTableScan(db *DB, table string, tdef *TableDef) ([]*Record, error) {
-	var kvReader KVReader
-	db.kv.BeginRead(&kvReader)
-	defer db.kv.EndRead(&kvReader)
-
-	scanner, err := NewTableScanner(db, table, &kvReader, tdef)
+func fullTableScan(db *DB, table string, tdef *TableDef, kvReader *KVReader) ([]*Record, error) {
+	scanner, err := NewTableScanner(db, table, kvReader, tdef)
 	if err != nil {
 		return nil, fmt.Errorf("scanner creation failed: %v", err)
 	}
@@ -82,7 +80,7 @@ func fullTableScan(db *DB, table string, tdef *TableDef) ([]*Record, error) {
 
 func (ts *TableScanner) Start() {
 	if ts.kvReader == nil {
-		fmt.Println("KVReader is nil")
+		ts.db.logger().Warn("table scan without a reader", "table", ts.tdef.Name)
 		return
 	}
 	ts.iter = ts.kvReader.Tree.Seek(ts.prefix, CMP_GE)
@@ -98,6 +96,7 @@ func (ts *TableScanner) Next() (*Record, bool, bool) {
 	if !bytes.HasPrefix(key, ts.prefix) {
 		return nil, false, false
 	}
+	ts.db.countRows(1, 0)
 
 	rec := &Record{
 		Cols: make([]string, len(ts.tdef.Cols)),
@@ -108,17 +107,10 @@ func (ts *TableScanner) Next() (*Record, bool, bool) {
 	}
 	copy(rec.Cols, ts.tdef.Cols)
 	decodeValues(key[4:], rec.Vals[:ts.tdef.PKeys])
-	decodeValues(val, rec.Vals[ts.tdef.PKeys:])
+	decodeRow(ts.tdef, val, rec.Vals[ts.tdef.PKeys:])
 
 	ts.iter.Next()
-
-	nextKey, _ := ts.iter.Deref()
-	if bytes.Equal(key, nextKey) {
-		ts.iter = &BIter{}
-		return rec, false, true
-	}
-
-	return rec, true, true
+	return rec, ts.iter.Valid(), true
 }
 
 func (ts *TableScanner) Current() (*Record, error) {
@@ -131,7 +123,7 @@ func (ts *TableScanner) Current() (*Record, error) {
 		rec.Vals[i].Type = ts.tdef.Types[i]
 	}
 	decodeValues(key[4:], rec.Vals[:ts.tdef.PKeys])
-	decodeValues(val, rec.Vals[ts.tdef.PKeys:])
+	decodeRow(ts.tdef, val, rec.Vals[ts.tdef.PKeys:])
 	return rec, nil
 }
 
@@ -139,10 +131,15 @@ func compareValues(v1, v2 Value) bool {
 	if v1.Type != v2.Type {
 		return false
 	}
+	if v1.Null || v2.Null {
+		return v1.Null == v2.Null
+	}
 
 	switch v1.Type {
-	case TYPE_INT64:
+	case TYPE_INT64, TYPE_INT32, TYPE_BOOL, TYPE_TIME:
 		return v1.I64 == v2.I64
+	case TYPE_FLOAT64:
+		return v1.F64 == v2.F64
 	case TYPE_BYTES:
 		return bytes.Equal(v1.Str, v2.Str)
 	default:
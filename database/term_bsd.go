@@ -0,0 +1,10 @@
+//go:build darwin || freebsd || openbsd || netbsd
+
+package database
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd)
+
+package database
+
+import "errors"
+
+// the width is not detected, set it with MODE or COLUMNS
+func terminalColumns(fd uintptr) int {
+	return 0
+}
+
+// the lines are read as the terminal hands them out, without the editor
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("no raw mode on this platform")
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// the cost of running a command, see DB.Measure
+type ExecStats struct {
+	Elapsed  time.Duration // the wall-clock time
+	Examined int64         // the rows read, those a filter skipped too
+	Returned int64         // the rows found, by the scans & the lookups
+}
+
+// the rows read & found by the DB since it was opened, for DB.Measure
+type execCounters struct {
+	examined atomic.Int64
+	returned atomic.Int64
+}
+
+// print the ExecStats of the data commands, switched by TIMER
+var replTimer = false
+
+// add to the counters of Measure, nil-safe
+func (db *DB) countRows(examined, returned int64) {
+	if db == nil {
+		return
+	}
+	db.exec.examined.Add(examined)
+	if returned != 0 {
+		db.exec.returned.Add(returned)
+	}
+}
+
+// the counters of the DB when a command starts, stop() is its ExecStats
+type execTimer struct {
+	db       *DB
+	start    time.Time
+	examined int64
+	returned int64
+}
+
+func startExec(db *DB) execTimer {
+	return execTimer{db, time.Now(), db.exec.examined.Load(), db.exec.returned.Load()}
+}
+
+func (t execTimer) stop() ExecStats {
+	return ExecStats{
+		Elapsed:  time.Since(t.start),
+		Examined: t.db.exec.examined.Load() - t.examined,
+		Returned: t.db.exec.returned.Load() - t.returned,
+	}
+}
+
+// run the function & return its time with the rows the DB read & found
+// meanwhile. the rows of the other goroutines using the DB at the same time
+// are counted too.
+func (db *DB) Measure(run func() error) (ExecStats, error) {
+	timer := startExec(db)
+	err := run()
+	return timer.stop(), err
+}
+
+// 1.234 ms, 10 rows examined, 3 returned
+func (s ExecStats) String() string {
+	return fmt.Sprintf("%s, %d rows examined, %d returned", formatElapsed(s.Elapsed), s.Examined, s.Returned)
+}
+
+// in µs below a millisecond, in ms below a second
+func formatElapsed(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%.1f µs", float64(d)/float64(time.Microsecond))
+	case d < time.Second:
+		return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.3f s", d.Seconds())
+	}
+}
+
+// print the ExecStats of a command after its output, when TIMER is on
+func PrintTiming(stats ExecStats) {
+	if replTimer {
+		fmt.Printf("Time: %v\n", stats)
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	defer func() { replTimer = false }()
+
+	var tx DBTX
+	db.Begin(&tx)
+	tdef := &TableDef{Name: "items", Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"id", "qty"}, PKeys: 1}
+	if err := tx.TableNew(tdef); err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	for i := int64(0); i < 10; i++ {
+		if _, err := tx.Set("items", *(&Record{}).AddInt64("id", i).AddInt64("qty", i%4), MODE_INSERT_ONLY); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// a filter reads every row of its range & returns those it is true for
+	var reader KVReader
+	db.kv.BeginRead(&reader)
+	sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Filter: CompareExpr(ColumnExpr("qty"), CMP_EQ, LiteralExpr(Value{Type: TYPE_INT64, I64: 1}))}
+	stats, err := db.Measure(func() error {
+		if err := db.Scan("items", &sc, &reader.Tree); err != nil {
+			return err
+		}
+		for ; sc.Valid(); sc.Next() {
+		}
+		return sc.Err()
+	})
+	if err != nil || stats.Examined != 10 || stats.Returned != 3 || sc.Stats() != (ExecStats{Examined: 10, Returned: 3}) {
+		t.Errorf("expected 10 rows examined & 3 returned, got %+v %+v %v", stats, sc.Stats(), err)
+	}
+	key := (&Record{}).AddInt64("id", 4)
+	if stats, _ := db.Measure(func() error { _, err := db.Get("items", key, &reader); return err }); stats.Examined != 1 || stats.Returned != 1 || stats.Elapsed <= 0 {
+		t.Errorf("expected the row found, got %+v", stats)
+	}
+	db.kv.EndRead(&reader)
+
+	for d, want := range map[time.Duration]string{
+		500 * time.Nanosecond:   "0.5 µs",
+		1500 * time.Microsecond: "1.500 ms",
+		2 * time.Second:         "2.000 s",
+	} {
+		if got := formatElapsed(d); got != want {
+			t.Errorf("expected %q for %v, got %q", want, d, got)
+		}
+	}
+
+	// after each data command while TIMER is on
+	stdout, stderr := captureOutput(t, func() {
+		repl(db, strings.NewReader("scan items\ntimer on\nscan items\nupdate items\n3\n7\ndelete items\n5\n1\ntimer\n\nscan items\n"), false)
+	})
+	times := regexp.MustCompile(`Time: [0-9.]+ (µs|ms|s), (\d+) rows examined, (\d+) returned`).FindAllStringSubmatch(stdout, -1)
+	if stderr != "" || len(times) != 3 || times[0][2] != "10" || times[1][2] != "1" || times[2][3] != "1" {
+		t.Errorf("expected the time of 3 commands & none after it, got %q %q", stdout, stderr)
+	}
+	if replTimer {
+		t.Errorf("expected the timer switched off")
+	}
+}
@@ -1,28 +1,90 @@
 package database
 
 import (
+	"bytes"
 	"container/heap"
-	"fmt"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
 )
 
-// DB transaction
+// DB transaction, its reads & scans see its own writes. every method of it
+// can be called from several goroutines, they run one at a time. a Scanner it
+// fills & the tree of Tree are of one goroutine.
 type DBTX struct {
-	kv KVTX
+	kv         KVTX
+	db         *DB
+	onCommit   []func() // see OnCommit
+	onRollback []func()
+	ctx        context.Context // of BeginTx, nil for Begin
+	stop       func() bool     // stops the abort when ctx is done
+	locks      []string        // the row keys locked by GetForUpdate, under db.locks.mu
+	altered    []string        // the tables dropped or altered, uncached again by the commit
+	counters   []uint32        // the AUTO_INCREMENT counters reset, by the table prefix
+	// held by each operation, the context can end the transaction at any time
+	mu    sync.Mutex
+	done  bool  // committed or aborted
+	cause error // of the abort by the context
+}
+
+// DB read-only transaction, reads the snapshot of the last commit.
+// the pages it can reach are not reused until it ends. every method of it is
+// safe for concurrent use, a Scanner it fills is not.
+type DBReader struct {
+	kv KVReader
 	db *DB
 }
 
 type KVReader struct {
 	// snapshot
-	version uint64
-	Tree    BTree
-	mmap    struct {
+	version   uint64
+	Tree      BTree
+	used      uint64       // pages used, for Backup
+	free      FreeListData // for VerifyPages & Backup
+	checksums bool         // check the pages read from the file
+	mmap      struct {
 		chunks [][]byte // copied from sttruct KV, read-only
 	}
-	index int
+	mem     *memPages         // of an in-memory DB
+	logged  map[uint64][]byte // copied from struct KV, read-only
+	cache   *pageCache        // shared with the KV
+	metrics *kvMetrics        // shared with the KV, nil-safe
+	index   int               // position in the KV.readers heap
 }
 
-// KV Transaction
+// KV Transaction, the updates go to a tree of its own on top of the snapshot
+// & are applied to the latest version by Commit. transactions run
+// concurrently, a commit is rejected if it conflicts, see ConflictError. a
+// KVTX is of one goroutine, its DBTX serializes the calls.
 type KVTX struct {
+	KVReader
+	kv   *KV
+	page struct {
+		next uint64 // the pointer of the next new page, from TX_PAGE
+		// the pages of the transaction's tree, they are not in the file.
+		// a page is never modified in place, a new page replaces it.
+		updates map[uint64][]byte
+	}
+	writes     map[string]struct{} // the keys written, replayed by Commit
+	savepoints []savepoint         // in the order they were made
+	// the keys read from a later version than the snapshot, see GetForUpdate
+	refreshed map[string]uint64
+	unique    [][]byte            // the prefixes of the unique index values written
+	counters  map[string]int64    // the AUTO_INCREMENT values used, by the meta key
+	foreign   []fkCommit          // the foreign key checks of the writes
+	prefixes  map[uint32]struct{} // the key prefixes written, counted in KV.pending
+	truncated []uint32            // the key prefixes emptied by Truncate
+	rows      map[string]int64    // the rows added to each table, by the meta key of its count
+	// of ApplyLog, the only commits of a replica
+	replicated bool
+}
+
+// the updates of a commit on top of the latest version, made under the writer lock
+type kvWriter struct {
 	KVReader
 	kv   *KV
 	free FreeList
@@ -34,12 +96,32 @@ type KVTX struct {
 	}
 }
 
+// the pointers of the pages of a transaction, above any page of the file
+const TX_PAGE = 1 << 63
+
 // initialising the reader from the kv
 func (kv *KV) BeginRead(tx *KVReader) {
 	kv.mu.Lock()
+	// the file is closed & reopened, wait for the new one
+	for kv.swap != nil {
+		swap := kv.swap
+		kv.mu.Unlock()
+		<-swap
+		kv.mu.Lock()
+	}
 	tx.mmap.chunks = kv.mmap.chunks
+	tx.mem = kv.mem
+	tx.logged = kv.logged
+	tx.cache = kv.cache
+	tx.metrics = &kv.metrics
 	tx.Tree.root = kv.tree.root
 	tx.Tree.get = tx.pageGetMapped
+	tx.Tree.catalog = kv.catalog + 1
+	tx.Tree.defs = nil
+	tx.Tree.ctx = nil
+	tx.used = kv.page.flushed
+	tx.free = kv.free
+	tx.checksums = kv.Checksums()
 	tx.version = kv.version
 	heap.Push(&kv.readers, tx)
 	kv.mu.Unlock()
@@ -55,106 +137,937 @@ func (tx *KVReader) Seek(key []byte, cmp int) *BIter {
 	return tx.Tree.Seek(key, cmp)
 }
 
+// start a reader of the last commit, it never waits for the writers
+func (db *DB) BeginRead(tx *DBReader) {
+	tx.db = db
+	db.kv.BeginRead(&tx.kv)
+}
+
+func (db *DB) EndRead(tx *DBReader) {
+	db.kv.EndRead(&tx.kv)
+}
+
+func (tx *DBReader) Get(table string, rec *Record) (bool, error) {
+	return tx.db.Get(table, rec, &tx.kv)
+}
+
+func (tx *DBReader) Scan(table string, req *Scanner) error {
+	return tx.db.Scan(table, req, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ScanOrdered(table string, req *Scanner, orderBy []string, desc bool) (*OrderedScan, error) {
+	return tx.db.ScanOrdered(table, req, orderBy, desc, &tx.kv.Tree)
+}
+
+func (tx *DBReader) Aggregate(table string, req *Scanner, aggs []AggSpec) (Record, error) {
+	return tx.db.Aggregate(table, req, aggs, &tx.kv.Tree)
+}
+
+func (tx *DBReader) GroupBy(table string, req *Scanner, groupCols []string, aggs []AggSpec) ([]Record, error) {
+	return tx.db.GroupBy(table, req, groupCols, aggs, &tx.kv.Tree)
+}
+
+func (tx *DBReader) Join(left string, right string, on []JoinCond, req *Scanner, leftOuter bool) (*JoinScan, error) {
+	return tx.db.Join(left, right, on, req, leftOuter, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ScanIn(table string, col string, vals []Value) (*InScan, error) {
+	return tx.db.ScanIn(table, col, vals, &tx.kv.Tree)
+}
+
+func (tx *DBReader) PrefixMatch(table string, col string, prefix []byte) (*Scanner, error) {
+	return tx.db.PrefixMatch(table, col, prefix, &tx.kv.Tree)
+}
+
+func (tx *DBReader) Export(table string, req *Scanner, w io.Writer, format Format) (int, error) {
+	return tx.db.Export(table, req, w, format, &tx.kv.Tree)
+}
+
+func (tx *DBReader) GetStruct(table string, key any, out any) (bool, error) {
+	return tx.db.GetStruct(table, key, out, &tx.kv)
+}
+
+func (tx *DBReader) RowCount(table string) (int64, error) {
+	return tx.db.RowCount(table, &tx.kv.Tree)
+}
+
+func (tx *DBReader) MultiGet(table string, keys []Record) ([]Record, []bool, error) {
+	return tx.db.MultiGet(table, keys, &tx.kv.Tree)
+}
+
+func (tx *DBReader) Describe(name string) (*TableDef, error) {
+	return tx.db.Describe(name, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ListTables() ([]string, error) {
+	return tx.db.ListTables(&tx.kv.Tree)
+}
+
+func (tx *DBReader) Count(table string, req *Scanner) (int64, error) {
+	return tx.db.Count(table, req, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ScanAll(table string) (*Scanner, error) {
+	return tx.db.ScanAll(table, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ScanPrefix(table string, prefix Record) (*Scanner, error) {
+	return tx.db.ScanPrefix(table, prefix, &tx.kv.Tree)
+}
+
+func (tx *DBReader) TableStats(table string) (*TableStats, error) {
+	return tx.db.TableStats(table, &tx.kv.Tree)
+}
+
+// the snapshot of the reader, for Scanner.Deref
+func (tx *DBReader) Tree() *BTree {
+	return &tx.kv.Tree
+}
+
+func (tx *DBReader) Plan(table string, conds []Cond) (*QueryPlan, error) {
+	return tx.db.Plan(table, conds, &tx.kv.Tree)
+}
+
+func (tx *DBReader) Explain(table string, req *Scanner) (*ScanPlan, error) {
+	return tx.db.Explain(table, req, &tx.kv.Tree)
+}
+
+func (tx *DBReader) ExplainAnalyze(table string, req *Scanner) (*ScanPlan, error) {
+	return tx.db.ExplainAnalyze(table, req, &tx.kv.Tree)
+}
+
+// the statistics of the last Analyze of the table, nil if there is none
+func (tx *DBReader) Analysis(table string) (*TableAnalysis, error) {
+	return tx.db.Analysis(table, &tx.kv.Tree)
+}
+
+func (tx *DBReader) CheckConsistency(table string) ([]Problem, error) {
+	return tx.db.CheckConsistency(table, &tx.kv.Tree)
+}
+
+// start a transaction on the last commit, any number can be open at once
 func (db *DB) Begin(tx *DBTX) {
 	tx.db = db
+	tx.onCommit, tx.onRollback = nil, nil
+	tx.ctx, tx.stop = nil, nil
+	tx.done, tx.cause = false, nil
+	tx.altered, tx.counters = nil, nil
 	db.kv.Begin(&tx.kv)
 }
 
-func (db *DB) Commit(tx *DBTX) error {
-	return db.kv.Commit(&tx.kv)
+// the commits of the goroutines are applied one at a time, a concurrent
+// commit of the same keys fails with a ConflictError.
+// a failed commit rolls back, the rollback hooks run instead of the commit hooks.
+// the error of a panicking hook is returned after a successful commit.
+func (db *DB) Commit(tx *DBTX) (err error) {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	tx.end()
+	tx.mu.Unlock()
+	timer := db.startOp(OP_COMMIT)
+	err = db.kv.Commit(&tx.kv)
+	timer.stop(&err)
+	db.locks.release(tx)
+	var conflict *ConflictError
+	var unique *UniqueError
+	if errors.As(err, &conflict) {
+		db.countMetric(METRIC_CONFLICTS, 1)
+		conflictResolve(db, conflict)
+	} else if errors.As(err, &unique) {
+		uniqueResolve(db, unique)
+	}
+	if err == nil {
+		// not kept for the dropped tables, the others are read again at
+		// the new version of the catalog
+		db.uncache(tx.altered...)
+		for _, prefix := range tx.counters {
+			db.autoinc.forget(prefix)
+		}
+	}
+	hooks := tx.onCommit
+	if err != nil {
+		hooks = tx.onRollback
+	}
+	tx.onCommit, tx.onRollback = nil, nil
+	if herr := runHooks(hooks); err == nil {
+		err = herr
+	}
+	return err
 }
 
-func (db *DB) Abort(tx *DBTX) {
+// the error is from a panicking rollback hook, a transaction that ended is left as is
+func (db *DB) Abort(tx *DBTX) error {
+	return db.abort(tx, nil)
+}
+
+// run the function in a snapshot of the last commit, ended after it
+func (db *DB) View(read func(tx *DBReader) error) error {
+	var tx DBReader
+	db.BeginRead(&tx)
+	defer db.EndRead(&tx)
+	return read(&tx)
+}
+
+// run the function in a transaction, committed when it returns nil &
+// aborted on its error or panic
+func (db *DB) Transact(write func(tx *DBTX) error) (err error) {
+	var tx DBTX
+	db.Begin(&tx)
+	defer func() {
+		if r := recover(); r != nil {
+			db.Abort(&tx)
+			panic(r)
+		}
+	}()
+	if err := write(&tx); err != nil {
+		db.Abort(&tx)
+		return err
+	}
+	return db.Commit(&tx)
+}
+
+func (db *DB) abort(tx *DBTX, cause error) error {
+	tx.mu.Lock()
+	if tx.done {
+		tx.mu.Unlock()
+		return nil
+	}
+	tx.end()
+	tx.cause = cause
 	db.kv.Abort(&tx.kv)
+	db.countMetric(METRIC_ABORTS, 1)
+	db.locks.release(tx)
+	hooks := tx.onRollback
+	tx.onCommit, tx.onRollback = nil, nil
+	tx.mu.Unlock()
+	return runHooks(hooks)
 }
 
 func (tx *DBTX) TableNew(tdef *TableDef) error {
-	return tx.db.TableNew(tdef, &tx.kv)
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.TableNew(tdef, &tx.kv); err != nil {
+		return err
+	}
+	for _, fk := range tdef.ForeignKeys {
+		tx.altered = append(tx.altered, fk.Parent) // lists the table
+	}
+	return nil
+}
+
+func (tx *DBTX) DropTable(name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	tdef := GetTableDef(tx.db, name, &tx.kv.Tree)
+	if err := tx.db.DropTable(name, &tx.kv); err != nil {
+		return err
+	}
+	tx.altered = append(tx.altered, name)
+	for _, fk := range tdef.ForeignKeys {
+		tx.altered = append(tx.altered, fk.Parent)
+	}
+	return nil
+}
+
+func (tx *DBTX) RenameTable(old, name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	tdef := GetTableDef(tx.db, old, &tx.kv.Tree)
+	if err := tx.db.RenameTable(old, name, &tx.kv); err != nil {
+		return err
+	}
+	tx.altered = append(tx.altered, old, name)
+	tx.altered = append(tx.altered, tdef.Referenced...)
+	for _, fk := range tdef.ForeignKeys {
+		tx.altered = append(tx.altered, fk.Parent)
+	}
+	return nil
+}
+
+func (tx *DBTX) RenameColumn(table, old, name string) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.RenameColumn(table, old, name, &tx.kv); err != nil {
+		return err
+	}
+	tx.altered = append(tx.altered, table)
+	tx.altered = append(tx.altered, GetTableDef(tx.db, table, &tx.kv.Tree).Referenced...)
+	return nil
+}
+
+// the AUTO_INCREMENT counter of reset starts again once committed
+func (tx *DBTX) Truncate(table string, reset bool) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.Truncate(table, reset, &tx.kv); err != nil {
+		return err
+	}
+	if tdef := GetTableDef(tx.db, table, &tx.kv.Tree); reset && tdef.AutoIncrement {
+		tx.counters = append(tx.counters, tdef.Prefix)
+	}
+	return nil
+}
+
+func (tx *DBTX) AddColumn(table, col string, typ uint32, def Value) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.AddColumn(table, col, typ, def, &tx.kv); err != nil {
+		return err
+	}
+	tx.altered = append(tx.altered, table)
+	return nil
 }
 
 func (tx *DBTX) Set(table string, rec Record, mode int) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, []Record{rec}); err != nil {
+		return false, err
+	}
 	return tx.db.Set(table, rec, mode, &tx.kv)
 }
 
+// the assigned AUTO_INCREMENT key is added to rec, see DB.InsertAuto
+func (tx *DBTX) InsertAuto(table string, rec *Record) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, []Record{*rec}); err != nil {
+		return false, err
+	}
+	return tx.db.InsertAuto(table, rec, &tx.kv)
+}
+
+// the assigned AUTO_INCREMENT key is set in the struct, see DB.InsertStruct
+func (tx *DBTX) InsertStruct(table string, v any) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	rec, err := tx.db.structInsert(table, v, &tx.kv.Tree)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.lockWait(table, []Record{rec}); err != nil {
+		return false, err
+	}
+	return tx.db.insertStruct(table, rec, v, &tx.kv)
+}
+
+// see DB.Upsert
+func (tx *DBTX) Upsert(table string, rec Record, mode int) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, []Record{rec}); err != nil {
+		return false, err
+	}
+	return tx.db.Upsert(table, rec, mode, &tx.kv)
+}
+
+// see DB.UpdatePartial
+func (tx *DBTX) UpdatePartial(table string, rec Record) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, []Record{rec}); err != nil {
+		return err
+	}
+	return tx.db.UpdatePartial(table, rec, &tx.kv)
+}
+
+func (tx *DBTX) BulkInsert(table string, rows []Record) error {
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, rows); err != nil {
+		return err
+	}
+	return tx.db.BulkInsert(table, rows, &tx.kv)
+}
+
 func (tx *DBTX) Delete(table string, rec Record) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWait(table, []Record{rec}); err != nil {
+		return false, err
+	}
 	return tx.db.Delete(table, rec, &tx.kv)
 }
 
+// the rows are all found before any is deleted, a scan stopped by its
+// context (see Scanner.SetContext) deletes none
+func (tx *DBTX) DeleteRange(table string, req *Scanner) (int, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWaitRange(table, req); err != nil {
+		return 0, err
+	}
+	return tx.db.DeleteRange(table, req, &tx.kv)
+}
+
+func (tx *DBTX) DeleteBy(table string, rec Record) (int, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWaitRange(table, &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: rec, Key2: rec}); err != nil {
+		return 0, err
+	}
+	return tx.db.DeleteBy(table, rec, &tx.kv)
+}
+
+func (tx *DBTX) UpdateBy(table string, rec Record, update Record) (int, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.lockWaitRange(table, &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: rec, Key2: rec}); err != nil {
+		return 0, err
+	}
+	return tx.db.UpdateBy(table, rec, update, &tx.kv)
+}
+
+func (tx *DBTX) Get(table string, rec *Record) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Get(table, rec, &tx.kv.KVReader)
+}
+
+// with the rows added & deleted by the transaction
+func (tx *DBTX) RowCount(table string) (int64, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	n, err := tx.db.RowCount(table, &tx.kv.Tree)
+	if err != nil {
+		return 0, err
+	}
+	tdef := GetTableDef(tx.db, table, &tx.kv.Tree)
+	return n + tx.kv.rows[string(rowCountKey(tdef))], nil
+}
+
+func (tx *DBTX) GetStruct(table string, key any, out any) (bool, error) {
+	if err := tx.enter(); err != nil {
+		return false, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.GetStruct(table, key, out, &tx.kv.KVReader)
+}
+
+func (tx *DBTX) MultiGet(table string, keys []Record) ([]Record, []bool, error) {
+	if err := tx.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.MultiGet(table, keys, &tx.kv.Tree)
+}
+
+func (tx *DBTX) Describe(name string) (*TableDef, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Describe(name, &tx.kv.Tree)
+}
+
+// the tree of the transaction with its own writes, for Scanner.Deref
+func (tx *DBTX) Tree() *BTree {
+	return &tx.kv.Tree
+}
+
+func (tx *DBTX) Plan(table string, conds []Cond) (*QueryPlan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Plan(table, conds, &tx.kv.Tree)
+}
+
+func (tx *DBTX) Explain(table string, req *Scanner) (*ScanPlan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Explain(table, req, &tx.kv.Tree)
+}
+
+func (tx *DBTX) ExplainAnalyze(table string, req *Scanner) (*ScanPlan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.ExplainAnalyze(table, req, &tx.kv.Tree)
+}
+
+// compute the statistics of the table for the planner & write them, see
+// TableAnalysis
+func (tx *DBTX) Analyze(table string) (*TableAnalysis, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Analyze(table, &tx.kv)
+}
+
+// the statistics of the last Analyze of the table, nil if there is none
+func (tx *DBTX) Analysis(table string) (*TableAnalysis, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Analysis(table, &tx.kv.Tree)
+}
+
+func (tx *DBTX) CheckConsistency(table string) ([]Problem, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.CheckConsistency(table, &tx.kv.Tree)
+}
+
+// fix the indexes of the table found by CheckConsistency, see DB.Repair
+func (tx *DBTX) Repair(table string) ([]Problem, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Repair(table, &tx.kv)
+}
+
+// the scanner stops once the transaction ends, with its error from Err
 func (tx *DBTX) Scan(table string, req *Scanner) error {
-	return tx.db.Scan(table, req, &tx.kv.Tree)
+	if err := tx.enter(); err != nil {
+		return err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.Scan(table, req, &tx.kv.Tree); err != nil {
+		return err
+	}
+	req.tx = tx
+	return nil
+}
+
+// the sorted rows are read under the lock of the transaction, the rows of a
+// request in the order are read like those of Scan
+func (tx *DBTX) ScanOrdered(table string, req *Scanner, orderBy []string, desc bool) (*OrderedScan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	os, err := tx.db.ScanOrdered(table, req, orderBy, desc, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	req.tx = tx
+	return os, nil
+}
+
+func (tx *DBTX) Aggregate(table string, req *Scanner, aggs []AggSpec) (Record, error) {
+	if err := tx.enter(); err != nil {
+		return Record{}, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Aggregate(table, req, aggs, &tx.kv.Tree)
+}
+
+func (tx *DBTX) GroupBy(table string, req *Scanner, groupCols []string, aggs []AggSpec) ([]Record, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.GroupBy(table, req, groupCols, aggs, &tx.kv.Tree)
+}
+
+// the rows stop once the transaction ends, with its error from Err
+func (tx *DBTX) Join(left string, right string, on []JoinCond, req *Scanner, leftOuter bool) (*JoinScan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	s, err := tx.db.Join(left, right, on, req, leftOuter, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	s.tx = tx
+	return s, nil
+}
+
+// the rows stop once the transaction ends, with its error from Err
+func (tx *DBTX) ScanIn(table string, col string, vals []Value) (*InScan, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	s, err := tx.db.ScanIn(table, col, vals, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	s.tx = tx
+	return s, nil
+}
+
+func (tx *DBTX) PrefixMatch(table string, col string, prefix []byte) (*Scanner, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	sc, err := tx.db.PrefixMatch(table, col, prefix, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	sc.tx = tx
+	return sc, nil
+}
+
+// the rows of the transaction, its own writes too
+func (tx *DBTX) Export(table string, req *Scanner, w io.Writer, format Format) (int, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.Export(table, req, w, format, &tx.kv.Tree)
+}
+
+// with the tables created & dropped by the transaction
+func (tx *DBTX) ListTables() ([]string, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.ListTables(&tx.kv.Tree)
+}
+
+func (tx *DBTX) Count(table string, req *Scanner) (int64, error) {
+	if err := tx.enter(); err != nil {
+		return 0, err
+	}
+	defer tx.mu.Unlock()
+	if err := tx.db.Scan(table, req, &tx.kv.Tree); err != nil {
+		return 0, err
+	}
+	return req.Count(), nil
+}
+
+// the scanners stop once the transaction ends, like those of Scan
+func (tx *DBTX) ScanAll(table string) (*Scanner, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	sc, err := tx.db.ScanAll(table, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	sc.tx = tx
+	return sc, nil
+}
+
+func (tx *DBTX) ScanPrefix(table string, prefix Record) (*Scanner, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	sc, err := tx.db.ScanPrefix(table, prefix, &tx.kv.Tree)
+	if err != nil {
+		return nil, err
+	}
+	sc.tx = tx
+	return sc, nil
+}
+
+func (tx *DBTX) TableStats(table string) (*TableStats, error) {
+	if err := tx.enter(); err != nil {
+		return nil, err
+	}
+	defer tx.mu.Unlock()
+	return tx.db.TableStats(table, &tx.kv.Tree)
+}
+
+// the keys written by the transaction so far, of the rows & of their index
+// entries, 0 once it ended
+func (tx *DBTX) Pending() int {
+	if err := tx.enter(); err != nil {
+		return 0
+	}
+	defer tx.mu.Unlock()
+	return len(tx.kv.writes)
 }
 
 func (kv *KV) Begin(tx *KVTX) {
 	tx.kv = kv
+	tx.page.next = TX_PAGE
 	tx.page.updates = map[uint64][]byte{}
-	tx.mmap.chunks = kv.mmap.chunks
-
-	kv.writer.Lock()
-	tx.version = kv.version
-	// btree
-	tx.Tree.root = kv.tree.root
+	tx.writes = map[string]struct{}{}
+	tx.savepoints = nil
+	tx.refreshed = nil
+	tx.unique = nil
+	tx.counters = nil
+	tx.foreign = nil
+	tx.prefixes, tx.truncated = nil, nil
+	tx.rows = nil
+	tx.replicated = false
+	// the pages of the snapshot are not reused until the transaction ends
+	kv.BeginRead(&tx.KVReader)
 	tx.Tree.get = tx.pageGet
 	tx.Tree.new = tx.pageNew
 	tx.Tree.del = tx.pageDel
+}
+
+// start the updates of a commit from the latest version, under the writer lock
+func writerBegin(kv *KV, w *kvWriter) {
+	w.kv = kv
+	w.page.updates = map[uint64][]byte{}
+	w.page.nappend = 0
+	w.mmap.chunks = kv.mmap.chunks
+	w.mem = kv.mem
+	w.logged = kv.logged
+	w.cache = kv.cache
+	w.metrics = &kv.metrics
+	w.checksums = kv.Checksums()
+	w.version = kv.version
+	// btree
+	w.Tree.root = kv.tree.root
+	w.Tree.get = w.pageGet
+	w.Tree.new = w.pageNew
+	w.Tree.del = w.pageDel
 
 	// freelist
-	tx.free.FreeListData = kv.free
-	tx.free.version = kv.version
-	tx.free.get = tx.pageGet
-	tx.free.new = tx.pageAppend
-	tx.free.use = tx.pageUse
+	w.free.FreeListData = kv.free
+	w.free.version = kv.version
+	w.free.get = w.pageGet
+	w.free.new = w.pageAppend
+	w.free.use = w.pageUse
 
-	tx.free.minReader = kv.version
+	w.free.minReader = kv.version
 	kv.mu.Lock()
-
 	if len(kv.readers) > 0 {
-		tx.free.minReader = kv.readers[0].version
+		w.free.minReader = kv.readers[0].version
 	}
 	kv.mu.Unlock()
+	// the state a crash goes back to is read from disk like a snapshot
+	if versionBefore(kv.wal.durable, w.free.minReader) {
+		w.free.minReader = kv.wal.durable
+	}
 }
 
-// end a transaction: commit updates
+// a transaction queued for commit
+type commitReq struct {
+	tx      *KVTX
+	err     error
+	done    chan struct{} // closed once it is applied or rejected
+	changes []kvChange    // of the watched tables, published once visible
+}
+
+// end a transaction: check for conflicts & apply the updates to the latest version.
+// group commit: the committer that gets the writer lock applies every queued
+// transaction in one log record with a single fsync, the others just wait
+// for it. a commit is visible once it is durable.
 func (kv *KV) Commit(tx *KVTX) error {
-	defer kv.writer.Unlock()
-	if kv.tree.root == tx.Tree.root {
+	defer kv.EndRead(&tx.KVReader)
+	defer pendingEnd(kv, tx)
+	if len(tx.writes) == 0 {
 		return nil // no updates
 	}
+	if kv.ReadOnly || (kv.Replica && !tx.replicated) {
+		return ErrReadOnly
+	}
+	req := &commitReq{tx: tx, done: make(chan struct{})}
+	kv.commits.mu.Lock()
+	kv.commits.queue = append(kv.commits.queue, req)
+	kv.commits.mu.Unlock()
 
-	// phase 1: persist the page data to disk
-	if err := writePages(tx); err != nil {
-		rollbackTX(tx)
-		return err
+	kv.writer.Lock()
+	defer kv.writer.Unlock()
+	select {
+	case <-req.done:
+		return req.err // by the previous committer
+	default:
+	}
+	if kv.CommitWindow > 0 {
+		time.Sleep(kv.CommitWindow) // let more commits join
+	}
+	kv.commits.mu.Lock()
+	batch := kv.commits.queue
+	kv.commits.queue = nil
+	kv.commits.mu.Unlock()
+	commitBatch(kv, batch)
+	return req.err
+}
+
+// apply the transactions of a batch as one version, under the writer lock.
+// the conflicts are rejected one by one, any other error fails the batch
+// before the new version is visible.
+func commitBatch(kv *KV, batch []*commitReq) {
+	defer func() {
+		for _, req := range batch {
+			close(req.done)
+		}
+	}()
+	fail := func(err error) {
+		for _, req := range batch {
+			if req.err == nil {
+				req.err = err
+			}
+		}
+	}
+	var w kvWriter
+	writerBegin(kv, &w)
+	keys := map[string]struct{}{}
+	catalog := false // a table definition is written
+	for _, req := range batch {
+		if req.err = commitCheck(kv, req.tx, keys); req.err != nil {
+			continue
+		}
+		if req.err = commitUnique(req.tx, &w); req.err != nil {
+			continue
+		}
+		if req.err = commitForeign(req.tx, &w); req.err != nil {
+			continue
+		}
+		if err := commitReplay(req, &w); err != nil {
+			fail(err) // partly applied
+			return
+		}
+		if err := commitCounters(req.tx, &w, keys); err != nil {
+			fail(err)
+			return
+		}
+		if err := commitRowCounts(req.tx, &w, keys); err != nil {
+			fail(err)
+			return
+		}
+		for key := range req.tx.writes {
+			keys[key] = struct{}{}
+		}
+		if _, ok := req.tx.prefixes[TDEF_TABLE.Prefix]; ok {
+			catalog = true
+		}
+	}
+	if len(keys) == 0 {
+		return // all rejected
+	}
+	if err := commitLog(kv, &w, keys); err != nil {
+		fail(err)
+		return
 	}
 
-	// the page data must reach disk before master page.
-	// the `fsync` serves as a barrier here
-	if err := kv.fp.Sync(); err != nil {
-		rollbackTX(tx)
-		return fmt.Errorf("fsync: %w", err)
+	// phase 1: log the updates & copy them to the main file
+	if err := writePages(&w); err != nil {
+		fail(err)
+		return
 	}
+	// the reused pages may be cached from before they were freed
+	kv.cache.invalidate(w.page.updates)
 
-	// transaction is visible
-	kv.page.flushed += uint64(tx.page.nappend)
-	kv.free = tx.free.FreeListData
+	// the transactions are visible
 	kv.mu.Lock()
-	kv.tree.root = tx.Tree.root
+	kv.page.flushed += uint64(w.page.nappend)
+	kv.free = w.free.FreeListData
+	kv.tree.root = w.Tree.root
 	kv.version++
+	if catalog {
+		kv.catalog++
+	}
+	commitRecord(kv, keys)
+	if kv.logWait != nil {
+		close(kv.logWait)
+		kv.logWait = nil
+	}
 	kv.mu.Unlock()
+	for _, req := range batch {
+		if req.err == nil {
+			kv.watch.publish(req.changes)
+		}
+	}
 
-	// phase 2: update the master page to point to new tree
-	if err := masterStore(kv); err != nil {
-		return err
+	// phase 2: the master page is only updated by a checkpoint. the commits
+	// are in the log, a failed checkpoint is tried again by the next one.
+	if kv.wal.size >= kv.checkpointSize() {
+		if err := checkpoint(kv); err != nil {
+			kv.logger().Warn("checkpoint failed, the log is kept", "path", kv.Path, "err", err)
+		}
 	}
+}
 
-	if err := kv.fp.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
+// apply the final value of each key written by the transaction, in key order.
+// the changes of the watched tables are kept
+// in the request.
+func commitReplay(req *commitReq, w *kvWriter) error {
+	tx := req.tx
+	keys := make([]string, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val, ok, err := tx.Tree.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		watched := w.kv.watch.has(key)
+		if !ok {
+			if watched {
+				if old, found, _ := w.Tree.Get([]byte(key)); found {
+					req.changes = append(req.changes, kvChange{op: CHANGE_DELETE, key: []byte(key), old: bytes.Clone(old)})
+				}
+			}
+			w.Tree.Delete([]byte(key))
+			w.kv.blooms.written([]byte(key), true)
+			continue
+		}
+		old, exists, err := w.Tree.Get([]byte(key))
+		if err == nil && !exists {
+			w.kv.blooms.written([]byte(key), false)
+		}
+		if err == nil && watched {
+			change := kvChange{op: CHANGE_INSERT, key: []byte(key), val: bytes.Clone(val)}
+			if exists {
+				change.op, change.old = CHANGE_UPDATE, bytes.Clone(old)
+			}
+			req.changes = append(req.changes, change)
+		}
+		if err == nil {
+			err = w.Tree.Insert([]byte(key), val)
+		}
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // end a transaction: rollback
 func (kv *KV) Abort(tx *KVTX) {
-	kv.writer.Unlock()
+	pendingEnd(kv, tx)
+	kv.EndRead(&tx.KVReader)
 }
 
 func (tx *KVTX) Seek(key []byte, cmp int) *BIter {
@@ -162,18 +1075,85 @@ func (tx *KVTX) Seek(key []byte, cmp int) *BIter {
 }
 
 func (tx *KVTX) Update(req *InsertReq) bool {
+	version := tx.Tree.version
 	tx.Tree.InsertEx(req)
+	if tx.Tree.version != version {
+		tx.written(req.Key)
+	}
 	return req.Added
 }
 
 func (tx *KVTX) Del(req *DeleteReq) bool {
-	return tx.Tree.DeleteEx(req)
+	deleted := tx.Tree.DeleteEx(req)
+	if deleted {
+		tx.written(req.Key)
+	}
+	return deleted
+}
+
+// delete the keys from the first to the last one, they must be every key in the range
+func (tx *KVTX) DeleteRange(keys [][]byte) int {
+	if len(keys) == 0 {
+		return 0
+	}
+	first, last := keys[0], keys[len(keys)-1]
+	if bytes.Compare(first, last) > 0 {
+		first, last = last, first
+	}
+	n := tx.Tree.DeleteRange(first, last)
+	for _, key := range keys {
+		tx.written(key)
+	}
+	return n
 }
 
-// rollbackTX the tree & other in-memmory data structures
-func rollbackTX(tx *KVTX) {
-	tx.kv.tree.root = tx.Tree.root
-	tx.kv.free = tx.free.FreeListData
-	tx.page.nappend = 0
-	tx.page.updates = make(map[uint64][]byte)
+// a key written, the first write under a key prefix is shown to the others
+func (tx *KVTX) written(key []byte) {
+	tx.writes[string(key)] = struct{}{}
+	if len(key) < 4 {
+		return
+	}
+	prefix := binary.BigEndian.Uint32(key)
+	if prefix == TDEF_TABLE.Prefix {
+		// the cached definitions are not of the tree
+		tx.Tree.catalog, tx.Tree.defs = 0, map[string]*TableDef{}
+	}
+	if _, ok := tx.prefixes[prefix]; ok {
+		return
+	}
+	if tx.prefixes == nil {
+		tx.prefixes = map[uint32]struct{}{}
+	}
+	tx.prefixes[prefix] = struct{}{}
+	tx.kv.mu.Lock()
+	if tx.kv.pending == nil {
+		tx.kv.pending = map[uint32]int{}
+	}
+	tx.kv.pending[prefix]++
+	tx.kv.mu.Unlock()
+}
+
+func pendingEnd(kv *KV, tx *KVTX) {
+	if len(tx.prefixes) == 0 {
+		return
+	}
+	kv.mu.Lock()
+	for prefix := range tx.prefixes {
+		if kv.pending[prefix]--; kv.pending[prefix] == 0 {
+			delete(kv.pending, prefix)
+		}
+	}
+	kv.mu.Unlock()
+	tx.prefixes = nil
+}
+
+// whether a transaction other than tx wrote under the key prefix
+func pendingOthers(kv *KV, tx *KVTX, prefix uint32) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	n := kv.pending[prefix]
+	if _, ok := tx.prefixes[prefix]; ok {
+		n--
+	}
+	return n > 0
 }
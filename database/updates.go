@@ -1,11 +1,14 @@
 package database
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 const (
@@ -16,6 +19,14 @@ const (
 
 const TABLE_PREFIX_MIN = 1
 
+var ErrNotNull error = errors.New("column cannot be null")
+
+var (
+	ErrExists    error = errors.New("record already exists")
+	ErrNotFound  error = errors.New("record not found")
+	ErrTableBusy error = errors.New("table written by another open transaction")
+)
+
 type InsertReq struct {
 	tree *BTree
 	// out
@@ -36,7 +47,11 @@ type DeleteReq struct {
 	Old []byte
 }
 
+// Deprecated: use DBTX.TableNew.
 func (db *DB) TableNew(tdef *TableDef, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
 	if err := tableDefCheck(tdef); err != nil {
 		return fmt.Errorf("invalid table definition: %w", err)
 	}
@@ -96,6 +111,9 @@ func (db *DB) TableNew(tdef *TableDef, kvtx *KVTX) error {
 		return fmt.Errorf("failed to add meta entry")
 	}
 
+	if err := fkDefine(db, tdef, kvtx); err != nil {
+		return fmt.Errorf("invalid table definition: %w", err)
+	}
 	// Marshal and store table definition
 	val, err := json.Marshal(tdef)
 	if err != nil {
@@ -109,29 +127,43 @@ func (db *DB) TableNew(tdef *TableDef, kvtx *KVTX) error {
 	if !added {
 		return fmt.Errorf("failed to add table definition")
 	}
+	if tdef.Prefix != TDEF_META.Prefix && tdef.Prefix != TDEF_TABLE.Prefix {
+		kvtx.Update(&InsertReq{Key: rowCountKey(tdef), Value: autoincVal(0)})
+	}
 	return nil
 }
 
-func (db *DB) Set(table string, rec Record, mode int, kvtx *KVTX) (bool, error) {
+// Deprecated: use DBTX.Set.
+func (db *DB) Set(table string, rec Record, mode int, kvtx *KVTX) (_ bool, err error) {
+	defer db.startOp(OP_INSERT).stop(&err)
+	if kvtx.kv.ReadOnly {
+		return false, ErrReadOnly
+	}
 	tdef := GetTableDef(db, table, &kvtx.Tree)
 	if tdef == nil {
-		return false, fmt.Errorf("table not found: %s", table)
+		return false, tableNotFound(table)
 	}
 	return dbUpdate(db, tdef, rec, mode, kvtx)
 }
 
-func (db *DB) Get(table string, rec *Record, kvReader *KVReader) (bool, error) {
+// Deprecated: use DBTX.Get or DBReader.Get.
+func (db *DB) Get(table string, rec *Record, kvReader *KVReader) (_ bool, err error) {
+	defer db.startOp(OP_GET).stop(&err)
 	tdef := GetTableDef(db, table, &kvReader.Tree)
 	if tdef == nil {
-		return false, fmt.Errorf("table not found: %s", table)
+		return false, tableNotFound(table)
+	}
+	if bloomAbsent(db, tdef, *rec, kvReader) {
+		return false, nil
 	}
 	return dbGet(db, tdef, rec, &kvReader.Tree)
 }
 
+// Deprecated: use DBReader.Scan from Key1 to Key2.
 func (db *DB) GetRange(table string, start, end *Record, kvReader *KVReader) ([]*Record, error) {
 	tdef := GetTableDef(db, table, &kvReader.Tree)
 	if tdef == nil {
-		return nil, fmt.Errorf("table not found: %s", table)
+		return nil, tableNotFound(table)
 	}
 
 	var results []*Record
@@ -156,7 +188,9 @@ func (db *DB) GetRange(table string, start, end *Record, kvReader *KVReader) ([]
 		}
 		copy(rec.Cols, tdef.Cols)
 
-		sc.Deref(rec, &kvReader.Tree)
+		if err := sc.Deref(rec, &kvReader.Tree); err != nil {
+			return results, err
+		}
 		results = append(results, rec)
 
 		sc.Next()
@@ -170,48 +204,646 @@ func (db *DB) GetRange(table string, start, end *Record, kvReader *KVReader) ([]
 	return results, nil
 }
 
+// Deprecated: use DBTX.Set with MODE_INSERT_ONLY.
 func (db *DB) Insert(table string, rec Record, kvtx *KVTX) (bool, error) {
 	return db.Set(table, rec, MODE_INSERT_ONLY, kvtx)
 }
 
+// insert a row, an omitted AUTO_INCREMENT key is assigned & added to rec
+//
+// Deprecated: use DBTX.InsertAuto.
+func (db *DB) InsertAuto(table string, rec *Record, kvtx *KVTX) (_ bool, err error) {
+	defer db.startOp(OP_INSERT).stop(&err)
+	if kvtx.kv.ReadOnly {
+		return false, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return false, tableNotFound(table)
+	}
+	if err := autoincFill(db, tdef, rec, MODE_INSERT_ONLY, kvtx); err != nil {
+		return false, err
+	}
+	return dbUpdate(db, tdef, *rec, MODE_INSERT_ONLY, kvtx)
+}
+
+// Deprecated: use DBTX.Set with MODE_UPDATE_ONLY.
 func (db *DB) Update(table string, rec Record, kvtx *KVTX) (bool, error) {
 	return db.Set(table, rec, MODE_UPDATE_ONLY, kvtx)
 }
 
-func (db *DB) Upsert(table string, rec Record, kvtx *KVTX) (bool, error) {
-	return db.Set(table, rec, MODE_UPSERT, kvtx)
+// update the columns of rec in the row with its primary key, the other
+// columns keep their values. fails if the row is missing.
+//
+// Deprecated: use DBTX.UpdatePartial.
+func (db *DB) UpdatePartial(table string, rec Record, kvtx *KVTX) (err error) {
+	defer db.startOp(OP_INSERT).stop(&err)
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(table)
+	}
+	for _, col := range rec.Cols {
+		if ColIndex(tdef, col) < 0 {
+			return columnNotFound(tdef.Name, col)
+		}
+	}
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return err
+	}
+	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
+	val, ok, err := kvtx.Tree.Get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return rowError(tdef, values, ErrNotFound)
+	}
+	db.countRows(1, 1)
+	row := rowDecode(tdef, key, val)
+	for i, col := range rec.Cols {
+		row.Vals[ColIndex(tdef, col)] = rec.Vals[i]
+	}
+	_, err = dbUpdate(db, tdef, row, MODE_UPDATE_ONLY, kvtx)
+	return err
 }
 
-func (db *DB) Delete(table string, rec Record, kvtx *KVTX) (bool, error) {
+// write a row with the mode, returns whether a new row was created.
+// MODE_INSERT_ONLY fails with ErrExists on an existing primary key &
+// MODE_UPDATE_ONLY with ErrNotFound on a missing one.
+//
+// Deprecated: use DBTX.Upsert.
+func (db *DB) Upsert(table string, rec Record, mode int, kvtx *KVTX) (_ bool, err error) {
+	defer db.startOp(OP_INSERT).stop(&err)
+	if kvtx.kv.ReadOnly {
+		return false, ErrReadOnly
+	}
 	tdef := GetTableDef(db, table, &kvtx.Tree)
 	if tdef == nil {
-		return false, fmt.Errorf("table not found: %s", table)
+		return false, tableNotFound(table)
+	}
+	req := InsertReq{Mode: mode}
+	if _, err := dbUpdateReq(db, tdef, rec, &req, kvtx); err != nil {
+		return false, err
+	}
+	return req.Added, nil
+}
+
+// insert rows sorted by the primary key, with fewer tree descents than Insert
+//
+// Deprecated: use DBTX.BulkInsert.
+func (db *DB) BulkInsert(table string, rows []Record, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(table)
+	}
+	return dbBulkInsert(db, tdef, rows, kvtx)
+}
+
+// Deprecated: use DBTX.Delete.
+func (db *DB) Delete(table string, rec Record, kvtx *KVTX) (_ bool, err error) {
+	defer db.startOp(OP_DELETE).stop(&err)
+	if kvtx.kv.ReadOnly {
+		return false, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return false, tableNotFound(table)
 	}
 	return dbDelete(db, tdef, rec, kvtx)
 }
 
+// delete the rows matched by the scan along with their index entries,
+// returns the number of rows deleted
+//
+// Deprecated: use DBTX.DeleteRange.
+func (db *DB) DeleteRange(table string, req *Scanner, kvtx *KVTX) (int, error) {
+	if kvtx.kv.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return 0, tableNotFound(table)
+	}
+	return dbDeleteRange(db, tdef, req, kvtx)
+}
+
+// delete every row with the values of the columns of rec, found through the
+// primary key or the index starting with the columns. returns the number of
+// rows deleted.
+//
+// Deprecated: use DBTX.DeleteBy.
+func (db *DB) DeleteBy(table string, rec Record, kvtx *KVTX) (int, error) {
+	if kvtx.kv.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return 0, tableNotFound(table)
+	}
+	sc, err := matchScanner(tdef, rec)
+	if err != nil {
+		return 0, err
+	}
+	return dbDeleteRange(db, tdef, sc, kvtx)
+}
+
+// change the columns of update in every row with the values of the columns
+// of rec, found like DeleteBy. the primary key is not changed. returns the
+// number of rows updated.
+//
+// Deprecated: use DBTX.UpdateBy.
+func (db *DB) UpdateBy(table string, rec Record, update Record, kvtx *KVTX) (int, error) {
+	if kvtx.kv.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return 0, tableNotFound(table)
+	}
+	for _, col := range update.Cols {
+		switch c := ColIndex(tdef, col); {
+		case c < 0:
+			return 0, columnNotFound(tdef.Name, col)
+		case c < tdef.PKeys:
+			return 0, fmt.Errorf("cannot change the primary key column: %s", col)
+		}
+	}
+	sc, err := matchScanner(tdef, rec)
+	if err != nil {
+		return 0, err
+	}
+	if err := dbScan(db, tdef, sc, &kvtx.Tree); err != nil {
+		return 0, err
+	}
+	// collect everything before updating the tree, a scan stopped by its
+	// context leaves the rows as they are
+	var rows []Record
+	for ; sc.Valid(); sc.Next() {
+		row := Record{}
+		if err := sc.Deref(&row, &kvtx.Tree); err != nil {
+			return 0, err
+		}
+		rows = append(rows, row)
+	}
+	for _, row := range rows {
+		for i, col := range update.Cols {
+			row.Vals[ColIndex(tdef, col)] = update.Vals[i]
+		}
+		if _, err := dbUpdate(db, tdef, row, MODE_UPDATE_ONLY, kvtx); err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), nil
+}
+
+// the scan of the rows with the values of rec, the columns start the
+// primary key or an index
+func matchScanner(tdef *TableDef, rec Record) (*Scanner, error) {
+	if len(rec.Cols) == 0 {
+		return nil, fmt.Errorf("no columns to match")
+	}
+	for _, col := range rec.Cols {
+		if ColIndex(tdef, col) < 0 {
+			return nil, columnNotFound(tdef.Name, col)
+		}
+	}
+	if _, err := findIndex(tdef, rec.Cols); err != nil {
+		indexes := []string{fmt.Sprintf("primary key (%s)", strings.Join(tdef.Cols[:tdef.PKeys], ","))}
+		for _, index := range tdef.Indexes {
+			indexes = append(indexes, fmt.Sprintf("(%s)", strings.Join(index, ",")))
+		}
+		return nil, fmt.Errorf("no index starts with (%s), the indexes of %s: %s",
+			strings.Join(rec.Cols, ","), tdef.Name, strings.Join(indexes, ", "))
+	}
+	return &Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: rec, Key2: rec}, nil
+}
+
+// remove the table, its rows, its index entries & its definition.
+// the pages are freed by the commit.
+//
+// Deprecated: use DBTX.DropTable.
+func (db *DB) DropTable(name string, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if name == TDEF_META.Name || name == TDEF_TABLE.Name {
+		return fmt.Errorf("cannot drop the internal table %s", name)
+	}
+	tdef := getTableDefDB(db, name, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(name)
+	}
+	if err := fkDrop(db, tdef, kvtx); err != nil {
+		return err
+	}
+	if err := tableEmpty(tdef, kvtx); err != nil {
+		return err
+	}
+	table := (&Record{}).AddStr("name", []byte(name))
+	if _, err := dbDelete(db, TDEF_TABLE, *table, kvtx); err != nil {
+		return fmt.Errorf("failed to delete table definition: %w", err)
+	}
+	kvtx.Del(&DeleteReq{Key: autoincKey(tdef)})
+	delete(kvtx.counters, string(autoincKey(tdef)))
+	kvtx.Del(&DeleteReq{Key: rowCountKey(tdef)})
+	delete(kvtx.rows, string(rowCountKey(tdef)))
+	kvtx.Del(&DeleteReq{Key: bloomKey(tdef.Prefix)})
+	kvtx.Del(&DeleteReq{Key: analysisKey(tdef)})
+	db.uncache(name)
+	return nil
+}
+
+// delete every row & index entry of the table, the definition is kept. the
+// AUTO_INCREMENT counter starts again from 1 with reset, see DBTX.Truncate.
+// fails with ErrTableBusy while another transaction has written to the table
+// & the commit fails with ErrConflict if one commits a write to it meanwhile.
+//
+// Deprecated: use DBTX.Truncate.
+func (db *DB) Truncate(table string, reset bool, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if table == TDEF_META.Name || table == TDEF_TABLE.Name {
+		return fmt.Errorf("cannot truncate the internal table %s", table)
+	}
+	tdef := GetTableDef(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(table)
+	}
+	for _, name := range tdef.Referenced {
+		if name != tdef.Name {
+			return fmt.Errorf("table %s is referenced by a foreign key of %s", tdef.Name, name)
+		}
+	}
+	prefixes := append([]uint32{tdef.Prefix}, tdef.IndexPrefix...)
+	for _, prefix := range prefixes {
+		if pendingOthers(kvtx.kv, kvtx, prefix) {
+			return fmt.Errorf("%w: %s", ErrTableBusy, table)
+		}
+	}
+	if err := tableEmpty(tdef, kvtx); err != nil {
+		return err
+	}
+	kvtx.truncated = append(kvtx.truncated, prefixes...)
+	if reset {
+		kvtx.Del(&DeleteReq{Key: autoincKey(tdef)})
+		delete(kvtx.counters, string(autoincKey(tdef)))
+	}
+	return nil
+}
+
+// delete the keys of the table & of its indexes, whole subtrees at a time
+func tableEmpty(tdef *TableDef, kvtx *KVTX) error {
+	for _, prefix := range append([]uint32{tdef.Prefix}, tdef.IndexPrefix...) {
+		keys := prefixKeys(&kvtx.Tree, prefix)
+		if n := kvtx.DeleteRange(keys); n != len(keys) {
+			return fmt.Errorf("deleted %d keys, expected %d", n, len(keys))
+		}
+		if prefix == tdef.Prefix {
+			rowCountAdd(tdef, kvtx, -len(keys))
+		}
+	}
+	return nil
+}
+
+// append a column to the table. the rows written before read it as def,
+// the inserts without it get def too. Value{} for no default.
+//
+// Deprecated: use DBTX.AddColumn.
+func (db *DB) AddColumn(table, col string, typ uint32, def Value, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if table == TDEF_META.Name || table == TDEF_TABLE.Name {
+		return fmt.Errorf("cannot alter the internal table %s", table)
+	}
+	tdef := getTableDefDB(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(table)
+	}
+	tdef.Cols = append(tdef.Cols, col)
+	tdef.Types = append(tdef.Types, typ)
+	defaults := make([]Value, len(tdef.Cols))
+	copy(defaults, tdef.Defaults)
+	defaults[len(defaults)-1] = def
+	tdef.Defaults = defaults
+	if err := tableDefCheck(tdef); err != nil {
+		return fmt.Errorf("invalid column: %w", err)
+	}
+	return tableDefUpdate(db, tdef, kvtx)
+}
+
+// rename the table in the catalog & in the foreign keys referencing it, the
+// rows keep their prefix
+//
+// Deprecated: use DBTX.RenameTable.
+func (db *DB) RenameTable(old, name string, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	for _, n := range []string{old, name} {
+		if n == TDEF_META.Name || n == TDEF_TABLE.Name {
+			return fmt.Errorf("cannot rename the internal table %s", n)
+		}
+	}
+	if name == "" {
+		return errors.New("table name cannot be empty")
+	}
+	tdef := getTableDefDB(db, old, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(old)
+	}
+	if getTableDefDB(db, name, &kvtx.Tree) != nil {
+		return fmt.Errorf("%w: %s", ErrTableAlreadyExists, name)
+	}
+	rename := func(names []string) {
+		for i := range names {
+			if names[i] == old {
+				names[i] = name
+			}
+		}
+	}
+	// the parents list it, the children reference it
+	related := map[string]bool{}
+	for _, fk := range tdef.ForeignKeys {
+		related[fk.Parent] = true
+	}
+	for _, child := range tdef.Referenced {
+		related[child] = true
+	}
+	delete(related, old)
+	for other := range related {
+		odef := getTableDefDB(db, other, &kvtx.Tree)
+		if odef == nil {
+			continue
+		}
+		rename(odef.Referenced)
+		for i := range odef.ForeignKeys {
+			if odef.ForeignKeys[i].Parent == old {
+				odef.ForeignKeys[i].Parent = name
+			}
+		}
+		if err := tableDefUpdate(db, odef, kvtx); err != nil {
+			return err
+		}
+	}
+	rename(tdef.Referenced)
+	for i := range tdef.ForeignKeys {
+		if tdef.ForeignKeys[i].Parent == old {
+			tdef.ForeignKeys[i].Parent = name
+		}
+	}
+
+	if _, err := dbDelete(db, TDEF_TABLE, *(&Record{}).AddStr("name", []byte(old)), kvtx); err != nil {
+		return fmt.Errorf("failed to delete table definition: %w", err)
+	}
+	tdef.Name = name
+	tdef.Version++
+	val, err := json.Marshal(tdef)
+	if err != nil {
+		return fmt.Errorf("failed to marshal table definition: %w", err)
+	}
+	rec := (&Record{}).AddStr("name", []byte(name)).AddStr("def", val)
+	if _, err := dbUpdate(db, TDEF_TABLE, *rec, MODE_INSERT_ONLY, kvtx); err != nil {
+		return fmt.Errorf("failed to add table definition: %w", err)
+	}
+	db.uncache(old, name)
+	return nil
+}
+
+// rename the column in the table, its indexes & the foreign keys using it
+//
+// Deprecated: use DBTX.RenameColumn.
+func (db *DB) RenameColumn(table, old, name string, kvtx *KVTX) error {
+	if kvtx.kv.ReadOnly {
+		return ErrReadOnly
+	}
+	if table == TDEF_META.Name || table == TDEF_TABLE.Name {
+		return fmt.Errorf("cannot alter the internal table %s", table)
+	}
+	tdef := getTableDefDB(db, table, &kvtx.Tree)
+	if tdef == nil {
+		return tableNotFound(table)
+	}
+	c := ColIndex(tdef, old)
+	if c < 0 {
+		return columnNotFound(tdef.Name, old)
+	}
+	rename := func(cols []string) {
+		for i := range cols {
+			if cols[i] == old {
+				cols[i] = name
+			}
+		}
+	}
+	tdef.Cols[c] = name
+	for _, index := range tdef.Indexes {
+		rename(index)
+	}
+	for i := range tdef.ForeignKeys {
+		fk := &tdef.ForeignKeys[i]
+		rename(fk.Cols)
+		if fk.Parent == table {
+			rename(fk.RefCols)
+		}
+	}
+	if err := tableDefCheck(tdef); err != nil {
+		return fmt.Errorf("invalid column: %w", err)
+	}
+	for _, child := range tdef.Referenced {
+		if child == table {
+			continue
+		}
+		cdef := getTableDefDB(db, child, &kvtx.Tree)
+		if cdef == nil {
+			continue
+		}
+		for i := range cdef.ForeignKeys {
+			if cdef.ForeignKeys[i].Parent == table {
+				rename(cdef.ForeignKeys[i].RefCols)
+			}
+		}
+		if err := tableDefUpdate(db, cdef, kvtx); err != nil {
+			return err
+		}
+	}
+	return tableDefUpdate(db, tdef, kvtx)
+}
+
+// the row of a write in the column order. a value without a type is a null
+// like Value.Null, it fails for a NOT NULL column or a primary key column. a
+// missing column of an insert gets its default, or is a null. the other
+// writes need every column.
+func rowFill(tdef *TableDef, rec Record, insert bool) (Record, error) {
+	row := Record{}
+	for i, col := range tdef.Cols {
+		v := rec.Get(col)
+		null := v == nil || v.Type == 0 || v.Null
+		switch {
+		case i < tdef.PKeys && v != nil && null:
+			return Record{}, fmt.Errorf("%w: %s", ErrNotNull, col)
+		case !null || i < tdef.PKeys:
+		case v == nil && !insert:
+			continue // fails in checkRecord
+		case v == nil && i < len(tdef.Defaults) && tdef.Defaults[i].Type != 0:
+			v = &tdef.Defaults[i]
+		case i < len(tdef.NotNull) && tdef.NotNull[i]:
+			return Record{}, fmt.Errorf("%w: %s", ErrNotNull, col)
+		default:
+			v = &Value{Type: tdef.Types[i], Null: true}
+		}
+		if v != nil {
+			row.Cols = append(row.Cols, col)
+			row.Vals = append(row.Vals, *v)
+		}
+	}
+	return row, nil
+}
+
+// every key of the table or index prefix, in order
+func prefixKeys(tree *BTree, prefix uint32) [][]byte {
+	var keys [][]byte
+	prefixScan(tree, binary.BigEndian.AppendUint32(nil, prefix), func(key []byte) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	return keys
+}
+
+// the first key with the prefix that is not skipped, nil if none
+func prefixFind(tree *BTree, prefix []byte, skip func(key []byte) bool) []byte {
+	var found []byte
+	prefixScan(tree, prefix, func(key []byte) bool {
+		if skip != nil && skip(key) {
+			return true
+		}
+		found = key
+		return false
+	})
+	return found
+}
+
+// call fn with the keys with the prefix in order, until it returns false
+func prefixScan(tree *BTree, prefix []byte, fn func(key []byte) bool) {
+	iter := tree.Seek(prefix, CMP_GE)
+	for ok := iter.Valid(); ok; ok = iterNext(iter, len(iter.path)-1) {
+		key, _ := iter.Deref()
+		if !bytes.HasPrefix(key, prefix) || !fn(key) {
+			return
+		}
+	}
+}
+
 func dbDelete(db *DB, tdef *TableDef, rec Record, kvtx *KVTX) (bool, error) {
 	values, err := checkRecord(tdef, rec, tdef.PKeys)
 	if err != nil {
 		return false, err
 	}
 	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
-	req := DeleteReq{Key: key}
-	deleted, error := kvtx.Delete(&req)
-	if error != nil || !deleted || len(tdef.Indexes) == 0 {
-		return deleted, error
+	// the whole row is read first for the keys of its index entries, a
+	// missing row leaves the indexes untouched
+	val, ok, err := kvtx.Tree.Get(key)
+	if err != nil {
+		return false, err
 	}
-	for i := tdef.PKeys; i <= len(tdef.Cols[tdef.PKeys:]); i++ {
-		values[i] = Value{Type: tdef.Types[i]}
+	if !ok {
+		return false, rowError(tdef, values, ErrNotFound)
 	}
-	if deleted {
-		decodeValues(req.Old, values[tdef.PKeys:])
-		indexOp(db, tdef, Record{tdef.Cols, values}, INDEX_DEL, kvtx)
+	old := rowDecode(tdef, key, val)
+	if len(tdef.Referenced) > 0 {
+		if err := fkRestrict(db, tdef, old, nil, kvtx); err != nil {
+			return false, err
+		}
+	}
+	// the entries go before the row so none is left pointing at nothing
+	for _, ikey := range indexKeys(tdef, old) {
+		kvtx.Del(&DeleteReq{Key: ikey})
 	}
-	return deleted, nil
+	kvtx.Del(&DeleteReq{Key: key})
+	rowCountAdd(tdef, kvtx, -1)
+	db.countRows(1, 1)
+	if len(tdef.Referenced) > 0 {
+		return true, fkCascade(db, tdef, old, kvtx)
+	}
+	return true, nil
+}
+
+func dbDeleteRange(db *DB, tdef *TableDef, req *Scanner, kvtx *KVTX) (int, error) {
+	// the whole row is needed for the index keys
+	req.Project = nil
+	if err := dbScan(db, tdef, req, &kvtx.Tree); err != nil {
+		return 0, err
+	}
+	// collect everything before updating the tree
+	var keys [][]byte
+	var ikeys [][]byte
+	var rows []Record // for the foreign keys
+	for ; req.Valid(); req.Next() {
+		rec := Record{}
+		if err := req.Deref(&rec, &kvtx.Tree); err != nil {
+			return 0, err
+		}
+		keys = append(keys, encodeKey(nil, tdef.Prefix, rec.Vals[:tdef.PKeys]))
+		ikeys = append(ikeys, indexKeys(tdef, rec)...)
+		if len(tdef.Referenced) > 0 {
+			rows = append(rows, rec)
+		}
+	}
+	if err := req.Err(); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	for _, rec := range rows {
+		if err := fkRestrict(db, tdef, rec, nil, kvtx); err != nil {
+			return 0, err
+		}
+	}
+
+	if req.indexNo < 0 && req.filter == nil && req.distinct == nil {
+		// the rows are contiguous in the primary key order
+		if n := kvtx.DeleteRange(keys); n != len(keys) {
+			return 0, fmt.Errorf("deleted %d rows, expected %d", n, len(keys))
+		}
+	} else {
+		for _, key := range keys {
+			kvtx.Del(&DeleteReq{Key: key})
+		}
+	}
+	rowCountAdd(tdef, kvtx, -len(keys))
+	for _, key := range ikeys {
+		kvtx.Del(&DeleteReq{Key: key})
+	}
+	for _, rec := range rows {
+		if err := fkCascade(db, tdef, rec, kvtx); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
 }
 
 func dbUpdate(db *DB, tdef *TableDef, rec Record, mode int, kvtx *KVTX) (bool, error) {
+	return dbUpdateReq(db, tdef, rec, &InsertReq{Mode: mode}, kvtx)
+}
+
+// write the row with req.Mode, the outputs of req tell what was written
+func dbUpdateReq(db *DB, tdef *TableDef, rec Record, req *InsertReq, kvtx *KVTX) (bool, error) {
+	mode := req.Mode
+	if err := autoincFill(db, tdef, &rec, mode, kvtx); err != nil {
+		return false, err
+	}
+	rec, err := rowFill(tdef, rec, mode == MODE_INSERT_ONLY)
+	if err != nil {
+		return false, err
+	}
 	values, err := checkRecord(tdef, rec, len(tdef.Cols))
 	if err != nil {
 		return false, err
@@ -220,39 +852,142 @@ func dbUpdate(db *DB, tdef *TableDef, rec Record, mode int, kvtx *KVTX) (bool, e
 	if !isTableValid {
 		return false, errors.New("invalid type")
 	}
+	if err := uniqueCheck(tdef, Record{tdef.Cols, values}, mode, kvtx); err != nil {
+		return false, err
+	}
+	if err := fkCheck(db, tdef, Record{tdef.Cols, values}, kvtx); err != nil {
+		return false, err
+	}
 	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
-	vals := encodeValues(nil, values[tdef.PKeys:])
-	req := InsertReq{Key: key, Value: vals, Mode: mode}
-	added, err := kvtx.SetWithMode(&req)
+	if len(tdef.Referenced) > 0 && mode != MODE_INSERT_ONLY {
+		// the referenced columns of a unique index may change
+		val, ok, err := kvtx.Tree.Get(key)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			row := Record{tdef.Cols, values}
+			if err := fkRestrict(db, tdef, rowDecode(tdef, key, val), &row, kvtx); err != nil {
+				return false, err
+			}
+		}
+	}
+	// every index key is checked before the first write, so the row & its
+	// entries are all written or none of them is
+	var ikeys [][]byte
+	if len(tdef.Indexes) > 0 {
+		ikeys = indexKeys(tdef, Record{tdef.Cols, values})
+		if err := indexKeysCheck(tdef, ikeys); err != nil {
+			return false, err
+		}
+	}
+	req.Key, req.Value = key, encodeValues(nil, values[tdef.PKeys:])
+	added, err := kvtx.SetWithMode(req)
+	if errors.Is(err, ErrExists) || errors.Is(err, ErrNotFound) {
+		return false, rowError(tdef, values, err)
+	}
+	if err == nil && req.Added {
+		rowCountAdd(tdef, kvtx, 1)
+	}
 	// if err or no changes made return
 	if err != nil || len(tdef.Indexes) == 0 {
 		return added, err
 	}
 
-	if req.Updated && !req.Added {
-		//  delete the old index entries
-		decodeValues(req.Old, values[tdef.PKeys:]) // get the old row
-		indexOp(db, tdef, Record{tdef.Cols, values}, INDEX_DEL, kvtx)
+	if req.Old != nil {
+		// only the entries of the indexes with a changed column are rewritten
+		old := Record{tdef.Cols, append([]Value(nil), values...)}
+		decodeRow(tdef, req.Old, old.Vals[tdef.PKeys:])
+		return added, indexUpdate(tdef, old, ikeys, kvtx)
 	}
 	if req.Updated || req.Added {
-		indexOp(db, tdef, rec, INDEX_ADD, kvtx)
+		for _, ikey := range ikeys {
+			if _, err := kvtx.SetWithMode(&InsertReq{Key: ikey}); err != nil {
+				return added, err
+			}
+		}
 	}
 	return added, nil
 }
 
+func dbBulkInsert(db *DB, tdef *TableDef, rows []Record, kvtx *KVTX) error {
+	keys := make([][]byte, len(rows))
+	vals := make([][]byte, len(rows))
+	ikeys := make([][][]byte, len(tdef.Indexes))
+	taken := map[string]bool{} // the unique values of the rows
+	for i := range rows {
+		if err := autoincFill(db, tdef, &rows[i], MODE_INSERT_ONLY, kvtx); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		rec, err := rowFill(tdef, rows[i], true)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		values, err := checkRecord(tdef, rec, len(tdef.Cols))
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if !validateTableTypes(tdef, rec) {
+			return fmt.Errorf("row %d: invalid type", i)
+		}
+		keys[i] = encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
+		vals[i] = encodeValues(nil, values[tdef.PKeys:])
+		if i > 0 && bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			return fmt.Errorf("row %d: rows must be sorted by the primary key without duplicates", i)
+		}
+		for j, key := range indexKeys(tdef, Record{tdef.Cols, values}) {
+			ikeys[j] = append(ikeys[j], key)
+		}
+		row := Record{tdef.Cols, values}
+		if err := uniqueCheck(tdef, row, MODE_INSERT_ONLY, kvtx); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := fkCheck(db, tdef, row, kvtx); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		for j := range tdef.Indexes {
+			if !indexUnique(tdef, j) || uniqueNull(tdef, j, row) {
+				continue
+			}
+			prefix := uniquePrefix(tdef, j, row)
+			if taken[string(prefix)] {
+				return fmt.Errorf("row %d: %w", i, uniqueError(tdef, j, prefix))
+			}
+			taken[string(prefix)] = true
+		}
+	}
+	if err := kvtx.BulkSet(keys, vals); err != nil {
+		if errors.Is(err, ErrKeyExists) {
+			return fmt.Errorf("%w: %w", ErrExists, err)
+		}
+		return err
+	}
+	rowCountAdd(tdef, kvtx, len(rows))
+	// an index key contains the primary key so it's unique as well
+	for _, index := range ikeys {
+		sort.Slice(index, func(a, b int) bool {
+			return bytes.Compare(index[a], index[b]) < 0
+		})
+		if err := kvtx.BulkSet(index, make([][]byte, len(index))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (tree *BTree) DeleteEx(req *DeleteReq) bool {
 	if tree == nil || req == nil {
 		return false
 	}
 
 	// Check if the key already exists
-	key, exists, err := tree.Get(req.Key)
+	val, exists, err := tree.Get(req.Key)
 	if err != nil || !exists {
 		return false
 	}
-	isDeleted := tree.Delete(key)
+	isDeleted := tree.Delete(req.Key)
 	if isDeleted {
-		req.Old = key
+		req.Old = val
 	}
 	return isDeleted
 }
@@ -303,15 +1038,19 @@ func (db *KVTX) SetWithMode(req *InsertReq) (bool, error) {
 			req.Old = old
 			return true, err
 		}
-		return false, errors.New("record not found")
+		return false, ErrNotFound
 
 	case MODE_UPSERT:
-		old, exists, _ := db.Get(req.Key)
+		old, exists, err := db.Get(req.Key)
+		if err != nil {
+			return false, err
+		}
 		if exists {
 			req.Old = old
 		}
-		err := db.Set(req.Key, req.Value)
+		err = db.Set(req.Key, req.Value)
 		req.Updated = true
+		req.Added = !exists
 		return true, err
 
 	case MODE_INSERT_ONLY:
@@ -324,7 +1063,7 @@ func (db *KVTX) SetWithMode(req *InsertReq) (bool, error) {
 			req.Added = true
 			return true, err
 		}
-		return false, errors.New("record already exists")
+		return false, ErrExists
 
 	default:
 		return false, errors.New("invalid update mode")
@@ -352,7 +1091,7 @@ func tableDefCheck(tdef *TableDef) error {
 		}
 		columnNames[col] = true
 
-		if tdef.Types[i] != TYPE_BYTES && tdef.Types[i] != TYPE_INT64 {
+		if tdef.Types[i] < TYPE_INT64 || tdef.Types[i] > TYPE_TIME {
 			return fmt.Errorf("invalid data type for column %s", col)
 		}
 	}
@@ -360,6 +1099,20 @@ func tableDefCheck(tdef *TableDef) error {
 	if tdef.PKeys > 1 {
 		return errors.New("only one primary key is allowed")
 	}
+	if len(tdef.Unique) > len(tdef.Indexes) {
+		return errors.New("more unique flags than indexes")
+	}
+	if len(tdef.NotNull) > len(tdef.Cols) || len(tdef.Defaults) > len(tdef.Cols) {
+		return errors.New("more column constraints than columns")
+	}
+	if tdef.AutoIncrement && (tdef.PKeys != 1 || tdef.Types[0] != TYPE_INT64) {
+		return errors.New("AUTO_INCREMENT needs a single int64 primary key")
+	}
+	for i, def := range tdef.Defaults {
+		if def.Type != 0 && def.Type != tdef.Types[i] {
+			return fmt.Errorf("the default of column %s does not match its type", tdef.Cols[i])
+		}
+	}
 	for i, index := range tdef.Indexes {
 		index, err := checkIndexKeys(tdef, index)
 		if err != nil {
@@ -379,6 +1132,9 @@ func validateTableTypes(tdef *TableDef, rec Record) bool {
 		if rec.Vals[i].Type != tdef.Types[i] {
 			return false
 		}
+		if v := rec.Vals[i].I64; rec.Vals[i].Type == TYPE_INT32 && v != int64(int32(v)) {
+			return false
+		}
 	}
 	return true
 }
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUpdateIndexedColumn(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	row := func(id int64, name []byte, qty int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("name", name).AddInt64("qty", qty)
+	}
+	err := db.Transact(func(tx *DBTX) error {
+		err := tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64}, Cols: []string{"id", "name", "qty"},
+			PKeys: 1, Indexes: [][]string{{"name"}, {"qty"}},
+		})
+		for i := int64(0); err == nil && i < 5; i++ {
+			_, err = tx.Set("items", row(i, []byte{'a' + byte(i)}, i*10), MODE_INSERT_ONLY)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	// the ids of the rows found through the index of the column
+	find := func(tx *DBTX, rec Record) []int64 {
+		sc, err := tx.ScanPrefix("items", rec)
+		if err != nil {
+			t.Fatalf("scan %v: %v", formatRecord(rec), err)
+		}
+		var ids []int64
+		for ; sc.Valid(); sc.Next() {
+			var got Record
+			if err := sc.Deref(&got, tx.Tree()); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			ids = append(ids, got.Get("id").I64)
+		}
+		return ids
+	}
+	check := func(tx *DBTX) {
+		problems, err := tx.CheckConsistency("items")
+		if err != nil || problems != nil {
+			t.Fatalf("expected consistent indexes, got %v %v", problems, err)
+		}
+	}
+
+	err = db.Transact(func(tx *DBTX) error {
+		if _, err := tx.Set("items", row(1, []byte("z"), 10), MODE_UPDATE_ONLY); err != nil {
+			return err
+		}
+		if ids := find(tx, *(&Record{}).AddStr("name", []byte("b"))); len(ids) != 0 {
+			t.Errorf("expected no row under the old value, got %v", ids)
+		}
+		if ids := find(tx, *(&Record{}).AddStr("name", []byte("z"))); len(ids) != 1 || ids[0] != 1 {
+			t.Errorf("expected the row 1 under the new value, got %v", ids)
+		}
+		// the unchanged index keeps its entry
+		if ids := find(tx, *(&Record{}).AddInt64("qty", 10)); len(ids) != 1 || ids[0] != 1 {
+			t.Errorf("expected the row 1 under its qty, got %v", ids)
+		}
+		check(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	// a new value too long for its index is rejected before anything is
+	// written, the row & both of its entries stay as they were
+	long := bytes.Repeat([]byte("x"), BTREE_MAX_KEY_SIZE)
+	err = db.Transact(func(tx *DBTX) error {
+		if _, err := tx.Set("items", row(2, long, 99), MODE_UPDATE_ONLY); err == nil {
+			t.Errorf("expected an error for the index key size")
+		}
+		if _, err := tx.Set("items", row(7, long, 70), MODE_INSERT_ONLY); err == nil {
+			t.Errorf("expected an error for the index key size")
+		}
+		got := (&Record{}).AddInt64("id", 2)
+		if ok, err := tx.Get("items", got); err != nil || !ok {
+			t.Fatalf("get: %v %v", ok, err)
+		}
+		if name, qty := got.Get("name").Str, got.Get("qty").I64; string(name) != "c" || qty != 20 {
+			t.Errorf("expected the row unchanged, got %s", formatRecord(*got))
+		}
+		if ids := find(tx, *(&Record{}).AddInt64("qty", 20)); len(ids) != 1 || ids[0] != 2 {
+			t.Errorf("expected the row 2 under its old qty, got %v", ids)
+		}
+		if ok, _ := tx.Get("items", (&Record{}).AddInt64("id", 7)); ok {
+			t.Errorf("expected the rejected insert to write no row")
+		}
+		check(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("rejected update: %v", err)
+	}
+}
+
+func TestDeleteIndexEntries(t *testing.T) {
+	db := setupMemoryDB(t)
+	defer db.kv.Close()
+	row := func(id int64, name string, qty int64) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("name", []byte(name)).AddInt64("qty", qty)
+	}
+	err := db.Transact(func(tx *DBTX) error {
+		err := tx.TableNew(&TableDef{
+			Name: "items", Types: []uint32{TYPE_INT64, TYPE_BYTES, TYPE_INT64}, Cols: []string{"id", "name", "qty"},
+			PKeys: 1, Indexes: [][]string{{"name"}, {"qty"}},
+		})
+		for i := int64(0); err == nil && i < 3; i++ {
+			_, err = tx.Set("items", row(i, string(rune('a'+i)), i*10), MODE_INSERT_ONLY)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	count := func(tx *DBTX, rec Record) int {
+		sc, err := tx.ScanPrefix("items", rec)
+		if err != nil {
+			t.Fatalf("scan %v: %v", formatRecord(rec), err)
+		}
+		n := 0
+		for ; sc.Valid(); sc.Next() {
+			n++
+		}
+		return n
+	}
+
+	err = db.Transact(func(tx *DBTX) error {
+		pkey := *(&Record{}).AddInt64("id", 1)
+		if ok, err := tx.Delete("items", pkey); !ok || err != nil {
+			t.Fatalf("delete: %v %v", ok, err)
+		}
+		if n := count(tx, *(&Record{}).AddStr("name", []byte("b"))); n != 0 {
+			t.Errorf("expected no entry in the name index, got %d", n)
+		}
+		if n := count(tx, *(&Record{}).AddInt64("qty", 10)); n != 0 {
+			t.Errorf("expected no entry in the qty index, got %d", n)
+		}
+		// a second delete finds no row & leaves the other entries alone
+		if ok, err := tx.Delete("items", pkey); ok || !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected not found, got %v %v", ok, err)
+		}
+		if n := count(tx, *(&Record{}).AddInt64("qty", 20)); n != 1 {
+			t.Errorf("expected the entry of the row 2, got %d", n)
+		}
+		problems, err := tx.CheckConsistency("items")
+		if err != nil || problems != nil {
+			t.Errorf("expected consistent indexes, got %v %v", problems, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+}
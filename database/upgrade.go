@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// the meta row of a rewrite done before the master page was upgraded
+const FORMAT_META_KEY = "format"
+
+// rewrite the keys & values of a format 2 file with the leading byte of each
+// value, in one transaction. the meta row marks it done, the master page
+// keeps format 2 until the next checkpoint & a crash before it replays the
+// rewrite from the log.
+func valuesRewrite(db *DB) error {
+	var kvtx KVTX
+	db.kv.Begin(&kvtx)
+	marker := (&Record{}).AddStr("key", []byte(FORMAT_META_KEY))
+	done, err := dbGet(db, TDEF_META, marker, &kvtx.Tree)
+	if err == nil && !done {
+		err = valuesRewriteTx(db, &kvtx)
+	}
+	if err != nil || done {
+		db.kv.Abort(&kvtx)
+	} else {
+		err = db.kv.Commit(&kvtx)
+	}
+	if err != nil {
+		return err
+	}
+	if !done {
+		db.logger().Info("rewrote the values to the current format", "path", db.Path, "format", FORMAT_VERSION)
+	}
+	db.kv.writer.Lock()
+	db.kv.format = FORMAT_VERSION
+	db.kv.writer.Unlock()
+	return nil
+}
+
+func valuesRewriteTx(db *DB, kvtx *KVTX) error {
+	tdefs := []*TableDef{TDEF_META, TDEF_TABLE}
+	for _, key := range prefixKeys(&kvtx.Tree, TDEF_TABLE.Prefix) {
+		val, _, err := kvtx.Tree.Get(key)
+		if err != nil {
+			return err
+		}
+		rec := []Value{{Type: TYPE_BYTES}, {Type: TYPE_BYTES}}
+		if legacyDecode(key[4:], rec[:1]) != 1 || legacyDecode(val, rec[1:]) != 1 {
+			return fmt.Errorf("corrupted table definition %q", key)
+		}
+		name := string(rec[0].Str)
+		if name == TDEF_META.Name || name == TDEF_TABLE.Name {
+			continue
+		}
+		tdef := &TableDef{}
+		if err := json.Unmarshal(rec[1].Str, tdef); err != nil {
+			return fmt.Errorf("table %s: %w", name, err)
+		}
+		tdefs = append(tdefs, tdef)
+	}
+
+	// the old keys of every prefix are deleted before the new ones are added
+	var keys, vals [][]byte
+	rewrite := func(prefix uint32, ktypes []uint32, vtypes []uint32) error {
+		old := prefixKeys(&kvtx.Tree, prefix)
+		for _, key := range old {
+			val, _, err := kvtx.Tree.Get(key)
+			if err != nil {
+				return err
+			}
+			kvals := legacyValues(key[4:], ktypes)
+			if len(kvals) != len(ktypes) {
+				return fmt.Errorf("corrupted key %q", key)
+			}
+			keys = append(keys, encodeKey(nil, prefix, kvals))
+			vals = append(vals, encodeValues(nil, legacyValues(val, vtypes)))
+		}
+		kvtx.DeleteRange(old)
+		return nil
+	}
+	for _, tdef := range tdefs {
+		if err := rewrite(tdef.Prefix, tdef.Types[:tdef.PKeys], tdef.Types[tdef.PKeys:]); err != nil {
+			return fmt.Errorf("table %s: %w", tdef.Name, err)
+		}
+		for i, index := range tdef.Indexes {
+			types := make([]uint32, len(index))
+			for j, col := range index {
+				types[j] = tdef.Types[ColIndex(tdef, col)]
+			}
+			if err := rewrite(tdef.IndexPrefix[i], types, nil); err != nil {
+				return fmt.Errorf("table %s: %w", tdef.Name, err)
+			}
+		}
+	}
+	for i := range keys {
+		kvtx.Update(&InsertReq{Key: keys[i], Value: vals[i]})
+	}
+
+	marker := (&Record{}).AddStr("key", []byte(FORMAT_META_KEY)).
+		AddStr("val", []byte(fmt.Sprint(FORMAT_VERSION)))
+	_, err := dbUpdate(db, TDEF_META, *marker, MODE_UPSERT, kvtx)
+	return err
+}
+
+// the values of the types encoded by format 2, without the leading bytes.
+// a row written before an ADD COLUMN has fewer values.
+func legacyValues(in []byte, types []uint32) []Value {
+	out := make([]Value, len(types))
+	for i, typ := range types {
+		out[i].Type = typ
+	}
+	return out[:legacyDecode(in, out)]
+}
+
+func legacyDecode(in []byte, out []Value) int {
+	for i, v := range out {
+		n := 0
+		if out[i], n = decodeValue(in, v.Type); n < 0 {
+			return i
+		}
+		in = in[n:]
+	}
+	return len(out)
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the values without the leading bytes of format 3
+func legacyEncode(prefix uint32, vals ...Value) []byte {
+	out := binary.BigEndian.AppendUint32(nil, prefix)
+	for _, v := range vals {
+		out = append(out, encodeValues(nil, []Value{v})[1:]...)
+	}
+	return out
+}
+
+func TestValuesRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	str := func(s string) Value { return Value{Type: TYPE_BYTES, Str: []byte(s)} }
+	num := func(n int64) Value { return Value{Type: TYPE_INT64, I64: n} }
+
+	// a format 2 file with a table, written as the KV
+	tdef := &TableDef{
+		Name:        "people",
+		Types:       []uint32{TYPE_INT64, TYPE_BYTES, TYPE_BYTES},
+		Cols:        []string{"id", "name", "email"},
+		PKeys:       1,
+		Indexes:     [][]string{{"email", "id"}},
+		Prefix:      3,
+		IndexPrefix: []uint32{4},
+	}
+	kv := KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var tx KVTX
+	kv.Begin(&tx)
+	next := binary.LittleEndian.AppendUint32(nil, 5)
+	set := func(key []byte, val []byte) {
+		tx.Update(&InsertReq{Key: key, Value: val})
+	}
+	set(legacyEncode(TDEF_META.Prefix, str("next_prefix")), legacyEncode(0, str(string(next)))[4:])
+	for _, tdef := range []*TableDef{TDEF_META, TDEF_TABLE, tdef} {
+		def, _ := json.Marshal(tdef)
+		set(legacyEncode(TDEF_TABLE.Prefix, str(tdef.Name)), legacyEncode(0, str(string(def)))[4:])
+	}
+	for id, email := range map[int64]string{-1: "b@x", 2: "a\x00@x"} {
+		set(legacyEncode(tdef.Prefix, num(id)), legacyEncode(0, str("n"), str(email))[4:])
+		set(legacyEncode(tdef.IndexPrefix[0], str(email), num(id)), nil)
+	}
+	// a row written before the email column was added
+	set(legacyEncode(tdef.Prefix, num(3)), legacyEncode(0, str("n"))[4:])
+	if err := kv.Commit(&tx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	kv.format = 2
+	kv.Close()
+
+	if _, err := OpenReadOnly(path); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected the read-only open to fail, got %v", err)
+	}
+	open := func() *DB {
+		t.Helper()
+		db := &DB{Path: path, kv: KV{Path: path}, tables: make(map[string]cachedDef)}
+		if err := db.kv.Open(); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if err := initializeInternalTables(db); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+		return db
+	}
+	check := func(db *DB) {
+		t.Helper()
+		var reader DBReader
+		db.BeginRead(&reader)
+		defer db.EndRead(&reader)
+		sc := Scanner{Cmp1: CMP_GE, Cmp2: CMP_LE, Key1: Record{Cols: []string{"email"}}, Key2: Record{Cols: []string{"email"}}}
+		if err := reader.Scan("people", &sc); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var got []string
+		for ; sc.Valid(); sc.Next() {
+			rec := Record{}
+			if err := sc.Deref(&rec, &reader.kv.Tree); err != nil {
+				t.Fatalf("deref: %v", err)
+			}
+			got = append(got, formatRecord(rec))
+		}
+		expected := []string{`id=2 name="n" email="a\x00@x"`, `id=-1 name="n" email="b@x"`}
+		if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+		rec := (&Record{}).AddInt64("id", 3)
+		if ok, err := dbGet(db, GetTableDef(db, "people", &reader.kv.Tree), rec, &reader.kv.Tree); !ok || err != nil {
+			t.Fatalf("failed to get: %v %v", ok, err)
+		}
+		if got := formatRecord(*rec); got != `id=3 name="n" email=NULL` {
+			t.Errorf("expected the added column to be null, got %s", got)
+		}
+	}
+	db := open()
+	check(db)
+	// as if it crashed before the checkpoint, the meta row keeps it from running twice
+	db.kv.format = 2
+	db.kv.Close()
+	db = open()
+	check(db)
+	db.kv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if format := binary.LittleEndian.Uint32(data[72:]); format != FORMAT_VERSION {
+		t.Errorf("expected format %d, got %d", FORMAT_VERSION, format)
+	}
+}
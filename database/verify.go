@@ -0,0 +1,130 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// checks the structure of the whole tree
+func (tree *BTree) Verify() error {
+	if tree.root == 0 {
+		return nil
+	}
+	leafDepth := -1
+	return verifyNode(tree, tree.root, 0, nil, nil, &leafDepth)
+}
+
+// `lo` is the separator key copied into the node, `hi` the next separator.
+// nil bounds are unbounded.
+func verifyNode(tree *BTree, ptr uint64, depth int, lo, hi []byte, leafDepth *int) error {
+	node, err := verifyGet(tree, ptr)
+	if err != nil {
+		return err
+	}
+	if err := verifyLayout(node); err != nil {
+		return fmt.Errorf("page %d: %w", ptr, err)
+	}
+
+	nkeys := node.nKeys()
+	if lo != nil && !bytes.Equal(node.getKey(0), lo) {
+		return fmt.Errorf("page %d: key 0: does not match the parent separator", ptr)
+	}
+	for i := uint16(0); i < nkeys; i++ {
+		key := node.getKey(i)
+		if i > 0 && bytes.Compare(node.getKey(i-1), key) >= 0 {
+			return fmt.Errorf("page %d: key %d: keys are not sorted", ptr, i)
+		}
+		if hi != nil && bytes.Compare(key, hi) >= 0 {
+			return fmt.Errorf("page %d: key %d: beyond the parent separator", ptr, i)
+		}
+	}
+
+	switch node.bNodeType() {
+	case BNODE_LEAF:
+		if *leafDepth == -1 {
+			*leafDepth = depth
+		} else if *leafDepth != depth {
+			return fmt.Errorf("page %d: leaf at depth %d, expected %d", ptr, depth, *leafDepth)
+		}
+		for i := uint16(0); i < nkeys; i++ {
+			if !node.isOverflow(i) {
+				continue
+			}
+			if err := verifyOverflow(tree, node, i); err != nil {
+				return fmt.Errorf("page %d: key %d: %w", ptr, i, err)
+			}
+		}
+	case BNODE_INODE:
+		// a lone kid is only merged away at the root, see BTree.Delete
+		if ptr != tree.root && nkeys < 2 {
+			return fmt.Errorf("page %d: internal node with %d kid", ptr, nkeys)
+		}
+		for i := uint16(0); i < nkeys; i++ {
+			kid := node.getPtr(i)
+			if kid == 0 {
+				return fmt.Errorf("page %d: key %d: null child pointer", ptr, i)
+			}
+			var next []byte
+			if i+1 < nkeys {
+				next = node.getKey(i + 1)
+			} else {
+				next = hi
+			}
+			err := verifyNode(tree, kid, depth+1, node.getKey(i), next, leafDepth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dereference a page without panicking on a bad pointer
+func verifyGet(tree *BTree, ptr uint64) (node BNode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("page %d: cannot be read: %v", ptr, r)
+		}
+	}()
+	return tree.get(ptr), nil
+}
+
+// checks that the header & the KV offsets stay within the page
+func verifyLayout(node BNode) error {
+	if len(node.data) < HEADER {
+		return fmt.Errorf("page too small")
+	}
+	btype, nkeys := node.bNodeType(), node.nKeys()
+	if btype != BNODE_LEAF && btype != BNODE_INODE {
+		return fmt.Errorf("bad node type %d", btype)
+	}
+	if nkeys == 0 {
+		return fmt.Errorf("node without keys")
+	}
+	size := len(node.data)
+	if size > BTREE_PAGE_SIZE {
+		size = BTREE_PAGE_SIZE
+	}
+	if HEADER+10*int(nkeys) > size {
+		return fmt.Errorf("%d keys do not fit in a page", nkeys)
+	}
+	for i := uint16(0); i < nkeys; i++ {
+		pos := int(node.kvPos(i))
+		if pos+4 > size {
+			return fmt.Errorf("key %d: offset out of the page", i)
+		}
+		klen := binary.LittleEndian.Uint16(node.data[pos:])
+		vlen := binary.LittleEndian.Uint16(node.data[pos+2:]) &^ VAL_OVERFLOW
+		if pos+4+int(klen)+int(vlen) > size {
+			return fmt.Errorf("key %d: out of the page", i)
+		}
+		if btype == BNODE_INODE && (vlen != 0 || node.isOverflow(i)) {
+			return fmt.Errorf("key %d: internal node with a value", i)
+		}
+	}
+	if int(node.nbytes()) > size {
+		return fmt.Errorf("node size exceeds the page size")
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+/// Write-ahead log
+// A commit appends the new page images & master fields to the log before
+// touching the main file. The main file & its master page are only synced
+// at a checkpoint, which empties the log. On open, the records that are
+// newer than the master page are replayed, a torn record at the end is a
+// commit that never finished and is dropped.
+// record: | size | crc32 | master fields | npages | (ptr, page) * npages |
+//         | 4B   | 4B    | 56B           | 4B     | (8B, 4KB)            |
+// size & crc32 cover everything after the crc32.
+// master fields: | version | root | pages_used | free_list |
+//                | 8B      | 8B   | 8B         | 32B       |
+
+const (
+	SYNC_EVERY_COMMIT = 0 // fsync the log on each commit
+	SYNC_PERIODIC     = 1 // fsync the log at most once per SyncInterval
+)
+
+const (
+	WAL_HEADER          = 8
+	WAL_MASTER          = 56
+	WAL_PAGE            = 8 + BTREE_PAGE_SIZE
+	WAL_CHECKPOINT_SIZE = 16 << 20 // the default log size that triggers a checkpoint
+	WAL_SYNC_INTERVAL   = time.Second
+)
+
+// the fields of the master page, also the state a log record brings the DB to
+type masterState struct {
+	version uint64
+	root    uint64
+	used    uint64 // pages used
+	free    FreeListData
+}
+
+func walPath(path string) string {
+	return path + "-wal"
+}
+
+func walOpen(db *KV) error {
+	fp, err := os.OpenFile(walPath(db.Path), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	db.wal.fp = fp
+	db.wal.size = 0
+	db.wal.synced = time.Now()
+	return nil
+}
+
+// apply the committed records that did not reach the main file
+func walReplay(db *KV) error {
+	if _, err := db.wal.fp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("read log: %w", err)
+	}
+	applied, err := walScan(db, db.wal.fp, func(master masterState, pages map[uint64][]byte) error {
+		return walApply(db, master, pages)
+	})
+	if err != nil {
+		return err
+	}
+	if applied {
+		db.logger().Info("replayed the log", "path", walPath(db.Path), "version", db.version)
+		return checkpoint(db)
+	}
+	// nothing new, drop what is left
+	if err := db.files().Truncate(db.wal.fp, 0); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	return nil
+}
+
+// read-only: the records newer than the master page are kept in memory
+// in front of the mapped file, the log is left as it is
+func walLoad(db *KV) error {
+	fp, err := os.Open(walPath(db.Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer fp.Close()
+	db.logged = map[uint64][]byte{}
+	_, err = walScan(db, fp, func(master masterState, pages map[uint64][]byte) error {
+		for ptr, page := range pages {
+			db.logged[ptr] = page
+		}
+		return nil
+	})
+	return err
+}
+
+// passes each record that follows the DB version to `apply` & moves the DB
+// to its state, returns whether any record was applied
+func walScan(db *KV, log io.Reader, apply func(masterState, map[uint64][]byte) error) (bool, error) {
+	r := bufio.NewReader(log)
+	applied := false
+	for {
+		master, pages, err := walRead(r)
+		if err != nil {
+			// the end of the log or a torn record
+			if errors.Is(err, io.EOF) {
+				return applied, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errWALCorrupt) {
+				db.logger().Warn("dropped a torn record at the end of the log", "path", walPath(db.Path), "version", db.version)
+				return applied, nil
+			}
+			return applied, fmt.Errorf("read log: %w", err)
+		}
+		if !versionBefore(db.version, master.version) {
+			continue // already in the main file
+		}
+		if master.version != db.version+1 {
+			// the log was emptied by a checkpoint after the master page was read
+			return applied, nil
+		}
+		if err := apply(master, pages); err != nil {
+			return applied, err
+		}
+		db.tree.root = master.root
+		db.page.flushed = master.used
+		db.free = master.free
+		db.version = master.version
+		applied = true
+	}
+}
+
+var errWALCorrupt = errors.New("bad log record")
+
+func walRead(r io.Reader) (masterState, map[uint64][]byte, error) {
+	var header [WAL_HEADER]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return masterState{}, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:])
+	if size < WAL_MASTER+4 || (size-WAL_MASTER-4)%WAL_PAGE != 0 {
+		return masterState{}, nil, errWALCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return masterState{}, nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+		return masterState{}, nil, errWALCorrupt
+	}
+
+	master := masterState{
+		version: binary.LittleEndian.Uint64(data[0:]),
+		root:    binary.LittleEndian.Uint64(data[8:]),
+		used:    binary.LittleEndian.Uint64(data[16:]),
+		free: FreeListData{
+			headPage: binary.LittleEndian.Uint64(data[24:]),
+			headSeq:  binary.LittleEndian.Uint64(data[32:]),
+			tailPage: binary.LittleEndian.Uint64(data[40:]),
+			tailSeq:  binary.LittleEndian.Uint64(data[48:]),
+		},
+	}
+	npages := binary.LittleEndian.Uint32(data[WAL_MASTER:])
+	if uint32(len(data)-WAL_MASTER-4) != npages*WAL_PAGE {
+		return masterState{}, nil, errWALCorrupt
+	}
+	pages := make(map[uint64][]byte, npages)
+	for pos := WAL_MASTER + 4; pos < len(data); pos += WAL_PAGE {
+		ptr := binary.LittleEndian.Uint64(data[pos:])
+		if ptr == 0 || ptr >= master.used {
+			return masterState{}, nil, errWALCorrupt
+		}
+		pages[ptr] = data[pos+8 : pos+WAL_PAGE]
+	}
+	return master, pages, nil
+}
+
+func walApply(db *KV, master masterState, pages map[uint64][]byte) error {
+	if err := extendFile(db, int(master.used)); err != nil {
+		return err
+	}
+	if err := extendMmap(db, int(master.used)); err != nil {
+		return err
+	}
+	for ptr, page := range pages {
+		copy(mappedPage(db.mmap.chunks, ptr).data, page)
+	}
+	return nil
+}
+
+// append the updates of a transaction, the log is synced according to the mode
+func walAppend(tx *kvWriter, master masterState) error {
+	db := tx.kv
+	npages := 0
+	for _, page := range tx.page.updates {
+		if page != nil {
+			npages++
+		}
+	}
+	data := make([]byte, WAL_HEADER+WAL_MASTER+4, WAL_HEADER+WAL_MASTER+4+npages*WAL_PAGE)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+0:], master.version)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+8:], master.root)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+16:], master.used)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+24:], master.free.headPage)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+32:], master.free.headSeq)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+40:], master.free.tailPage)
+	binary.LittleEndian.PutUint64(data[WAL_HEADER+48:], master.free.tailSeq)
+	binary.LittleEndian.PutUint32(data[WAL_HEADER+WAL_MASTER:], uint32(npages))
+	for ptr, page := range tx.page.updates {
+		if page != nil {
+			data = binary.LittleEndian.AppendUint64(data, ptr)
+			data = append(data, page[:BTREE_PAGE_SIZE]...)
+		}
+	}
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(data)-WAL_HEADER))
+	binary.LittleEndian.PutUint32(data[4:], crc32.ChecksumIEEE(data[WAL_HEADER:]))
+
+	files := db.files()
+	// cut the partial record so the later ones are not lost behind it, once
+	// it can be if it could not be when the append failed
+	cut := func() error {
+		err := files.Truncate(db.wal.fp, db.wal.size)
+		db.wal.torn = err != nil
+		return err
+	}
+	if db.wal.torn {
+		if err := cut(); err != nil {
+			return fmt.Errorf("truncate log: %w", err)
+		}
+	}
+	if _, err := files.Write(db.wal.fp, data); err != nil {
+		cut()
+		return fmt.Errorf("write log: %w", err)
+	}
+	if db.Sync == SYNC_PERIODIC && time.Since(db.wal.synced) < db.syncInterval() {
+		db.wal.size += int64(len(data))
+		return nil
+	}
+	if err := files.Sync(db.wal.fp); err != nil {
+		cut()
+		return fmt.Errorf("fsync log: %w", err)
+	}
+	db.wal.size += int64(len(data))
+	db.wal.synced = time.Now()
+	db.wal.durable = master.version
+	return nil
+}
+
+// persist the main file & empty the log. the pages are synced before the
+// master page points to them & the master page before the log goes
+func checkpoint(db *KV) error {
+	if err := db.files().Sync(db.fp); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	if err := masterStore(db); err != nil {
+		return err
+	}
+	if err := db.files().Sync(db.fp); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	// not synced, the records left are older than the master page
+	if err := db.files().Truncate(db.wal.fp, 0); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	db.wal.size, db.wal.torn = 0, false
+	db.wal.synced = time.Now()
+	db.wal.durable = db.version
+	return nil
+}
+
+func (db *KV) syncInterval() time.Duration {
+	if db.SyncInterval <= 0 {
+		return WAL_SYNC_INTERVAL
+	}
+	return db.SyncInterval
+}
+
+func (db *KV) checkpointSize() int64 {
+	if db.CheckpointSize <= 0 {
+		return WAL_CHECKPOINT_SIZE
+	}
+	return db.CheckpointSize
+}
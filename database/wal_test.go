@@ -0,0 +1,316 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stop like a killed process, the log is not checkpointed
+func crashKV(kv *KV) {
+	_ = kv.wal.fp.Close()
+	kv.wal.fp = nil
+	kv.Close()
+}
+
+func walKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+// commit n inserts the keys [100n, 100n+100) & deletes the first 10 of the previous batch
+func walCommit(t *testing.T, kv *KV, n int) {
+	var tx KVTX
+	kv.Begin(&tx)
+	for i := n * 100; i < n*100+100; i++ {
+		if err := tx.Set(walKey(i), []byte(fmt.Sprintf("val%d", i))); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	for i := (n - 1) * 100; n > 0 && i < (n-1)*100+10; i++ {
+		if _, err := tx.Delete(&DeleteReq{Key: walKey(i)}); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	if err := kv.Commit(&tx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+// checks the keys after the commits [0, n]
+func walCheck(t *testing.T, kv *KV, n int) {
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+	if err := reader.Tree.Verify(); err != nil {
+		t.Fatalf("invalid tree after commit %d: %v", n, err)
+	}
+	for i := 0; i < 100*(n+2); i++ {
+		val, ok, err := reader.Tree.Get(walKey(i))
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		deleted := i%100 < 10 && i/100 < n
+		if want := i/100 <= n && !deleted; ok != want {
+			t.Fatalf("after commit %d: key %d expected found=%v", n, i, want)
+		}
+		if ok && string(val) != fmt.Sprintf("val%d", i) {
+			t.Fatalf("after commit %d: key %d has a bad value %q", n, i, val)
+		}
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	for _, mode := range []int{SYNC_EVERY_COMMIT, SYNC_PERIODIC} {
+		t.Run(fmt.Sprintf("sync mode %d", mode), func(t *testing.T) {
+			testWALRecovery(t, mode)
+		})
+	}
+}
+
+func testWALRecovery(t *testing.T, mode int) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal.db")
+	kv := KV{Path: path, CheckpointSize: 1 << 40}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	walCommit(t, &kv, 0)
+	kv.Close()
+	if _, err := os.Stat(walPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected the log to be removed on close, got %v", err)
+	}
+
+	kv = KV{Path: path, Sync: mode, SyncInterval: time.Hour, CheckpointSize: 1 << 40}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	readFile := func(path string) []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return data
+	}
+	durable := kv.wal.durable
+	ends := []int64{0}                // the log size after each commit
+	mains := [][]byte{readFile(path)} // the main file after each commit
+	for n := 1; n <= 30; n++ {
+		walCommit(t, &kv, n)
+		ends = append(ends, kv.wal.size)
+		mains = append(mains, readFile(path))
+	}
+	if synced := kv.wal.durable != durable; synced != (mode == SYNC_EVERY_COMMIT) {
+		t.Fatalf("expected the log synced=%v, durable version %d -> %d", !synced, durable, kv.wal.durable)
+	}
+	crashKV(&kv)
+	walData := readFile(walPath(path))
+	if int64(len(walData)) != ends[len(ends)-1] {
+		t.Fatalf("expected a log of %d bytes, got %d", ends[len(ends)-1], len(walData))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 40; trial++ {
+		// the writes are killed at a random offset of the log
+		off := rng.Int63n(int64(len(walData)) + 1)
+		if trial == 0 {
+			off = int64(len(walData))
+		}
+		n := 0
+		for n+1 < len(ends) && ends[n+1] <= off {
+			n++
+		}
+		// the main file has the commits in the log so far. an unsynced log
+		// can lose records the main file has already seen.
+		mainData := mains[n]
+		if mode == SYNC_PERIODIC {
+			mainData = mains[len(mains)-1]
+		}
+		// the pages appended after the checkpoint may be lost as well
+		base := len(mains[0]) / BTREE_PAGE_SIZE
+		npages := base + rng.Intn(len(mainData)/BTREE_PAGE_SIZE-base+1)
+
+		trialPath := filepath.Join(dir, fmt.Sprintf("trial%d.db", trial))
+		if err := os.WriteFile(trialPath, mainData[:npages*BTREE_PAGE_SIZE], 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.WriteFile(walPath(trialPath), walData[:off], 0o644); err != nil {
+			t.Fatalf("write log: %v", err)
+		}
+
+		trialKV := KV{Path: trialPath}
+		if err := trialKV.Open(); err != nil {
+			t.Fatalf("trial %d: open after a crash at offset %d: %v", trial, off, err)
+		}
+		walCheck(t, &trialKV, n)
+		// the recovered DB keeps working
+		walCommit(t, &trialKV, n+1)
+		walCheck(t, &trialKV, n+1)
+		trialKV.Close()
+	}
+}
+
+// concurrent commits share the log records & fsyncs, none are lost
+func TestGroupCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "group.db")
+	kv := KV{Path: path, CommitWindow: 5 * time.Millisecond}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer kv.Close()
+
+	const workers, commits = 16, 5
+	version := kv.version
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < commits; i++ {
+				var tx KVTX
+				kv.Begin(&tx)
+				err := tx.Set(walKey(w*commits+i), []byte("val"))
+				if err == nil {
+					err = kv.Commit(&tx)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("commit: %v", err)
+	}
+	if n := kv.version - version; n >= workers*commits {
+		t.Errorf("expected the commits to be grouped, got %d versions for %d commits", n, workers*commits)
+	}
+
+	// the same key in a batch, the first one wins
+	var tx1, tx2 KVTX
+	kv.Begin(&tx1)
+	kv.Begin(&tx2)
+	for _, tx := range []*KVTX{&tx1, &tx2} {
+		if err := tx.Set(walKey(0), []byte("again")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	results := make(chan error, 2)
+	for _, tx := range []*KVTX{&tx1, &tx2} {
+		go func() { results <- kv.Commit(tx) }()
+	}
+	err1, err2 := <-results, <-results
+	if (err1 == nil) == (err2 == nil) || !errors.Is(errors.Join(err1, err2), ErrConflict) {
+		t.Errorf("expected a single conflict, got %v, %v", err1, err2)
+	}
+
+	// durable once returned
+	crashKV(&kv)
+	kv = KV{Path: path}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	var reader KVReader
+	kv.BeginRead(&reader)
+	defer kv.EndRead(&reader)
+	for i := 0; i < workers*commits; i++ {
+		if _, ok, err := reader.Tree.Get(walKey(i)); !ok || err != nil {
+			t.Fatalf("key %d lost: %v %v", i, ok, err)
+		}
+	}
+}
+
+// records the operations on the file & on the log
+type opLog struct {
+	osFileOps
+	wal *os.File
+	ops []string
+}
+
+func (l *opLog) name(fp *os.File) string {
+	if fp == l.wal {
+		return "log"
+	}
+	return "file"
+}
+
+func (l *opLog) WriteAt(fp *os.File, data []byte, offset int64) (int, error) {
+	l.ops = append(l.ops, "write "+l.name(fp))
+	return l.osFileOps.WriteAt(fp, data, offset)
+}
+
+func (l *opLog) Truncate(fp *os.File, size int64) error {
+	l.ops = append(l.ops, "truncate "+l.name(fp))
+	return l.osFileOps.Truncate(fp, size)
+}
+
+func (l *opLog) Sync(fp *os.File) error {
+	l.ops = append(l.ops, "sync "+l.name(fp))
+	return l.osFileOps.Sync(fp)
+}
+
+// the pages are durable before the master page, the master page before the log goes
+func TestCheckpointOrder(t *testing.T) {
+	ops := &opLog{}
+	kv := KV{Path: filepath.Join(t.TempDir(), "order.db"), Files: ops, CheckpointSize: 1 << 40}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer kv.Close()
+	walCommit(t, &kv, 0)
+	ops.wal, ops.ops = kv.wal.fp, nil
+	if err := checkpoint(&kv); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	want := []string{"sync file", "write file", "sync file", "truncate log"}
+	if fmt.Sprint(ops.ops) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, ops.ops)
+	}
+}
+
+// small transactions with an fsync per commit, the concurrent ones share them
+func BenchmarkGroupCommit(b *testing.B) {
+	for _, committers := range []int{1, 32} {
+		b.Run(fmt.Sprintf("committers %d", committers), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "group.db")
+			kv := KV{Path: path}
+			if err := kv.Open(); err != nil {
+				b.Fatalf("open: %v", err)
+			}
+			defer kv.Close()
+			var next atomic.Int64 // the commits taken by the committers
+			version := kv.version
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for c := 0; c < committers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := next.Add(1); i <= int64(b.N); i = next.Add(1) {
+						var tx KVTX
+						kv.Begin(&tx)
+						err := tx.Set(walKey(int(i)), []byte("val"))
+						if err == nil {
+							err = kv.Commit(&tx)
+						}
+						if err != nil {
+							b.Errorf("commit: %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.ReportMetric(float64(b.N)/float64(kv.version-version), "commits/fsync")
+		})
+	}
+}
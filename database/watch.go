@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var ErrWatchOverflow error = errors.New("the watcher fell behind, its changes were dropped")
+
+const (
+	CHANGE_INSERT = 1
+	CHANGE_UPDATE = 2
+	CHANGE_DELETE = 3
+)
+
+// the events a watcher buffers, the last one is kept for ErrWatchOverflow
+const WATCH_BUFFER = 1024
+
+// a committed change of a row of a watched table
+type ChangeEvent struct {
+	Op    int // CHANGE_INSERT, CHANGE_UPDATE or CHANGE_DELETE
+	Table string
+	PKey  Record // the primary key of the row
+	Old   Record // the row before, empty for an insert
+	New   Record // the row after, empty for a delete
+	// of the commit, shared by its changes & increasing in commit order
+	Seq uint64
+	// ErrWatchOverflow or the error of Watch, the last event of the channel
+	Err error
+}
+
+// a key of a watched table written by a commit, no old value for
+// CHANGE_INSERT & no new one for CHANGE_DELETE
+type kvChange struct {
+	op            int
+	key, old, val []byte
+}
+
+type watcher struct {
+	tdef *TableDef
+	ch   chan ChangeEvent
+}
+
+// the watchers by the prefix of the table, under KV.writer
+type watchSet struct {
+	watchers map[uint32][]*watcher
+	seq      uint64 // of the last commit published
+}
+
+// a channel of the changes of the rows of the table, sent after their
+// transaction commits & in commit order. the changes of an aborted or
+// rejected transaction are never sent. the committers do not wait for the
+// channel: with WATCH_BUFFER events unread the channel gets an event of
+// ErrWatchOverflow & is closed. the cancel function closes it too.
+func (db *DB) Watch(table string) (<-chan ChangeEvent, context.CancelFunc) {
+	ch := make(chan ChangeEvent, WATCH_BUFFER)
+	var reader DBReader
+	db.BeginRead(&reader)
+	tdef, err := reader.Describe(table)
+	db.EndRead(&reader)
+	if err != nil {
+		ch <- ChangeEvent{Table: table, Err: err}
+		close(ch)
+		return ch, func() {}
+	}
+
+	w := &watcher{tdef: tdef, ch: ch}
+	kv := &db.kv
+	kv.writer.Lock()
+	if kv.watch == nil {
+		kv.watch = &watchSet{watchers: map[uint32][]*watcher{}}
+	}
+	kv.watch.watchers[tdef.Prefix] = append(kv.watch.watchers[tdef.Prefix], w)
+	kv.writer.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			kv.writer.Lock()
+			kv.watch.remove(w)
+			kv.writer.Unlock()
+		})
+	}
+}
+
+// the key is of the rows of a watched table
+func (ws *watchSet) has(key string) bool {
+	return ws != nil && len(key) >= 4 && ws.watchers[binary.BigEndian.Uint32([]byte(key))] != nil
+}
+
+// a watcher removed is closed, the watcher of an overflow is removed already
+func (ws *watchSet) remove(w *watcher) {
+	list := ws.watchers[w.tdef.Prefix]
+	for i, other := range list {
+		if other == w {
+			list = append(list[:i:i], list[i+1:]...)
+			close(w.ch)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(ws.watchers, w.tdef.Prefix)
+	} else {
+		ws.watchers[w.tdef.Prefix] = list
+	}
+}
+
+func (ws *watchSet) close() {
+	if ws == nil {
+		return
+	}
+	for _, list := range ws.watchers {
+		for _, w := range list {
+			close(w.ch)
+		}
+	}
+	ws.watchers = map[uint32][]*watcher{}
+}
+
+// send the changes of a commit, after it is visible
+func (ws *watchSet) publish(changes []kvChange) {
+	if ws == nil || len(changes) == 0 {
+		return
+	}
+	ws.seq++
+	for _, change := range changes {
+		for _, w := range ws.watchers[binary.BigEndian.Uint32(change.key)] {
+			ws.send(w, changeEvent(w.tdef, change, ws.seq))
+		}
+	}
+}
+
+func (ws *watchSet) send(w *watcher, event ChangeEvent) {
+	if len(w.ch) >= cap(w.ch)-1 {
+		w.ch <- ChangeEvent{Table: w.tdef.Name, Seq: event.Seq, Err: ErrWatchOverflow}
+		ws.remove(w)
+		return
+	}
+	w.ch <- event
+}
+
+func changeEvent(tdef *TableDef, change kvChange, seq uint64) ChangeEvent {
+	event := ChangeEvent{Op: change.op, Table: tdef.Name, Seq: seq}
+	var row Record
+	if change.op != CHANGE_INSERT {
+		event.Old = rowDecode(tdef, change.key, change.old)
+		row = event.Old
+	}
+	if change.op != CHANGE_DELETE {
+		event.New = rowDecode(tdef, change.key, change.val)
+		row = event.New
+	}
+	event.PKey = Record{Cols: row.Cols[:tdef.PKeys], Vals: append([]Value(nil), row.Vals[:tdef.PKeys]...)}
+	return event
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	db := setupMemoryDB(t)
+
+	for _, name := range []string{"orders", "items", "audit"} {
+		tdef := &TableDef{Name: name, Types: []uint32{TYPE_INT64, TYPE_BYTES}, Cols: []string{"id", "note"}, PKeys: 1}
+		if err := db.Transact(func(tx *DBTX) error { return tx.TableNew(tdef) }); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	row := func(id int64, note string) Record {
+		return *(&Record{}).AddInt64("id", id).AddStr("note", []byte(note))
+	}
+	events, cancel := db.Watch("items")
+	defer cancel()
+
+	// the three tables in a transaction, the changes of items once it commits
+	var tx DBTX
+	db.Begin(&tx)
+	for _, name := range []string{"orders", "items", "audit"} {
+		for id := int64(1); id <= 2; id++ {
+			if _, err := tx.Set(name, row(id, "new"), MODE_INSERT_ONLY); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("expected no event before the commit, got %d", len(events))
+	}
+	if err := db.Commit(&tx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the 2 rows of items, got %d events", len(events))
+	}
+	first := <-events
+	second := <-events
+	if first.Op != CHANGE_INSERT || first.Table != "items" || first.PKey.Get("id").I64 != 1 ||
+		string(first.New.Get("note").Str) != "new" || len(first.Old.Cols) != 0 || second.Seq != first.Seq {
+		t.Errorf("expected the insert of items 1 & 2 in one commit, got %+v %+v", first, second)
+	}
+
+	// an aborted transaction sends nothing
+	db.Begin(&tx)
+	tx.Set("items", row(3, "gone"), MODE_INSERT_ONLY)
+	db.Abort(&tx)
+	err := db.Transact(func(tx *DBTX) error {
+		if err := tx.UpdatePartial("items", row(1, "changed")); err != nil {
+			return err
+		}
+		_, err := tx.Delete("items", *(&Record{}).AddInt64("id", 2))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	update, del := <-events, <-events
+	if update.Op != CHANGE_UPDATE || string(update.Old.Get("note").Str) != "new" || string(update.New.Get("note").Str) != "changed" {
+		t.Errorf("expected the update of items 1, got %+v", update)
+	}
+	if del.Op != CHANGE_DELETE || del.PKey.Get("id").I64 != 2 || len(del.New.Cols) != 0 || del.Seq <= first.Seq {
+		t.Errorf("expected the delete of items 2 after the insert, got %+v", del)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected no more events, got %d", len(events))
+	}
+
+	// a watcher that is not read overflows, the committers go on
+	slow, slowCancel := db.Watch("audit")
+	defer slowCancel()
+	for id := int64(10); id < 10+WATCH_BUFFER+10; id++ {
+		if err := db.Transact(func(tx *DBTX) error { _, err := tx.Set("audit", row(id, "x"), MODE_INSERT_ONLY); return err }); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	var last ChangeEvent
+	n := 0
+	for event := range slow {
+		last = event
+		n++
+	}
+	if n != WATCH_BUFFER || !errors.Is(last.Err, ErrWatchOverflow) {
+		t.Errorf("expected %d events ending with ErrWatchOverflow, got %d %v", WATCH_BUFFER, n, last.Err)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("expected the channel closed by cancel")
+	}
+	missing, _ := db.Watch("nobody")
+	if event := <-missing; !errors.Is(event.Err, ErrTableNotFound) {
+		t.Errorf("expected ErrTableNotFound, got %v", event.Err)
+	}
+	open, _ := db.Watch("orders")
+	db.Close()
+	if _, ok := <-open; ok {
+		t.Errorf("expected the channel closed by Close")
+	}
+}
@@ -2,4 +2,4 @@ module atomixDB
 
 go 1.23.2
 
-require golang.org/x/sys v0.31.0 // indirect
+require golang.org/x/sys v0.31.0
@@ -2,8 +2,104 @@ package main
 
 import (
 	"atomixDB/database"
+	"atomixDB/database/server"
+	"atomixDB/database/sql"
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	database.StartDB()
+	script := flag.String("f", "", "run the commands of the file instead of a terminal")
+	keepGoing := flag.Bool("k", false, "keep running a script after a failed command")
+	listen := flag.String("listen", "", "serve the DB file of -db to clients at the address instead")
+	httpAddr := flag.String("http", "", "serve the HTTP API of the DB file of -db at the address instead")
+	path := flag.String("db", "database.db", "the DB file of -listen, -http & -follow")
+	leader := flag.Bool("leader", false, "keep the log of the commits for the replicas of -follow")
+	follow := flag.String("follow", "", "keep the DB file of -db, a backup of the leader, in sync with the leader at the address")
+	flag.Parse()
+
+	if *listen != "" || *httpAddr != "" || *follow != "" {
+		serve(*listen, *httpAddr, *path, *leader, *follow)
+		return
+	}
+	database.ReplSQL = sql.Repl
+	database.StartDB(database.ReplOptions{Script: *script, KeepGoing: *keepGoing})
+}
+
+// until SIGINT or SIGTERM, then the requests running are answered & the open
+// transactions are aborted. a replica of -follow serves the reads.
+func serve(addr string, httpAddr string, path string, leader bool, follow string) {
+	var db *database.DB
+	var err error
+	switch {
+	case follow != "":
+		db, err = database.OpenReplica(path)
+	case leader:
+		db, err = database.Open(path, database.WithReplicationLog(0))
+	default:
+		db, err = database.Open(path)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", path, err)
+	}
+	srv := server.New(db)
+	followed := make(chan struct{})
+	ctx, stopFollow := context.WithCancel(context.Background())
+	go func() {
+		defer close(followed)
+		if follow == "" {
+			return
+		}
+		log.Printf("Following %s into %s", follow, path)
+		if err := server.Follow(ctx, db, follow); err != context.Canceled {
+			log.Printf("Failed to follow %s: %v", follow, err)
+		}
+	}()
+	var web *http.Server
+	if httpAddr != "" {
+		web = &http.Server{Addr: httpAddr, Handler: srv.Handler()}
+		go func() {
+			log.Printf("Serving the HTTP API of %s at %s", path, httpAddr)
+			if err := web.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("Failed to serve HTTP: %v", err)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		<-sigChan
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		stopFollow()
+		if web != nil {
+			web.Shutdown(ctx)
+		}
+		srv.Shutdown(ctx)
+		close(stop)
+	}()
+	if addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			db.Close()
+			log.Fatalf("Failed to listen: %v", err)
+		}
+		log.Printf("Serving %s at %s", path, ln.Addr())
+		if err := srv.Serve(ln); err != server.ErrServerClosed {
+			log.Printf("Failed to serve: %v", err)
+		}
+	}
+	<-stop
+	<-followed
+	if err := db.Close(); err != nil {
+		log.Fatalf("Failed to close %s: %v", path, err)
+	}
 }